@@ -298,8 +298,6 @@ func (c *Client) previewACLPostRequest(ctx context.Context, body []byte, preview
 	req.URL.RawQuery = q.Encode()
 
 	req.Header.Set("Content-Type", "application/hujson")
-	c.setAuth(req)
-
 	b, resp, err := c.sendRequest(req)
 	if err != nil {
 		return nil, err
@@ -449,8 +447,6 @@ func (c *Client) ValidateACLJSON(ctx context.Context, source, dest string) (test
 	}
 
 	req.Header.Set("Content-Type", "application/json")
-	c.setAuth(req)
-
 	b, resp, err := c.sendRequest(req)
 	if err != nil {
 		return nil, err
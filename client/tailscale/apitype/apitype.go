@@ -4,7 +4,13 @@
 // Package apitype contains types for the Tailscale LocalAPI and control plane API.
 package apitype
 
-import "tailscale.com/tailcfg"
+import (
+	"net/netip"
+	"time"
+
+	"tailscale.com/net/speedtest"
+	"tailscale.com/tailcfg"
+)
 
 // LocalAPIHost is the Host header value used by the LocalAPI.
 const LocalAPIHost = "local-tailscaled.sock"
@@ -38,3 +44,174 @@ type SetPushDeviceTokenRequest struct {
 	// PushDeviceToken is the iOS/macOS APNs device token (and any future Android equivalent).
 	PushDeviceToken string
 }
+
+// ServiceRecord is a DNS-SD style (RFC 6763) description of a service a
+// node offers on its Tailscale IPs. Nodes publish their records to peers
+// over the PeerAPI at /v0/services.
+type ServiceRecord struct {
+	// Service is the DNS-SD service type and protocol, such as
+	// "_http._tcp".
+	Service string
+
+	// Port is the port the service listens on.
+	Port uint16
+
+	// Priority and Weight are as in DNS SRV records (RFC 2782).
+	Priority uint16 `json:",omitempty"`
+	Weight   uint16 `json:",omitempty"`
+
+	// TXT contains "key=value" metadata about the service, such as
+	// "version=1.2.3", as in DNS-SD TXT records.
+	TXT []string `json:",omitempty"`
+}
+
+// SuggestedRoutesResponse is the JSON type returned by the LocalAPI
+// /debug-suggested-routes handler.
+type SuggestedRoutesResponse struct {
+	// Observing is whether the node is currently observing traffic
+	// to learn which subnets to suggest.
+	Observing bool
+
+	// Routes are the subnets that traffic was observed to or from,
+	// busiest first.
+	Routes []SuggestedRoute
+}
+
+// SuggestedRoute is a subnet that a subnet router has seen traffic to or
+// from and that may be worth advertising.
+type SuggestedRoute struct {
+	Route    netip.Prefix
+	Packets  uint64
+	Bytes    uint64
+	LastSeen time.Time
+
+	// CoveredBy is the already advertised route that contains Route,
+	// if any.
+	CoveredBy netip.Prefix `json:",omitempty"`
+}
+
+// CapabilitiesResponse is the JSON type returned by the LocalAPI
+// /capabilities handler.
+type CapabilitiesResponse struct {
+	// Capabilities are the capabilities (node attributes) that the
+	// control server granted this node, sorted.
+	Capabilities []string
+
+	// Unknown are those of Capabilities that aren't Tailscale's own and
+	// have no registered handler, such as node attributes specific to a
+	// custom control server. They're ignored.
+	Unknown []string `json:",omitempty"`
+}
+
+// UsageResponse is the JSON type returned by the LocalAPI /usage handler.
+type UsageResponse struct {
+	// Recording is whether the node is recording its traffic, per the
+	// RecordUsage pref. When it isn't, Days only has what was recorded
+	// previously.
+	Recording bool
+
+	// Days are the daily totals of the traffic sent to and received
+	// from peers, oldest first. Days without traffic are omitted.
+	Days []UsageDay
+}
+
+// UsageDay is the traffic sent to and received from peers on a day.
+type UsageDay struct {
+	// Date is the day, in the node's local time zone, formatted as
+	// "2006-01-02".
+	Date string
+
+	// Peers are the byte counts of each peer and route, busiest first.
+	Peers []PeerUsage
+}
+
+// PeerUsage is the traffic sent to and received from a peer on a day,
+// either addressed to the peer itself or via one of its routes.
+type PeerUsage struct {
+	Peer tailcfg.StableNodeID
+
+	// Name is the peer's name when its traffic was last counted.
+	Name string
+
+	// Route is the subnet route or exit node route that the traffic
+	// was routed via, or the zero value if it was addressed to the
+	// peer itself.
+	Route netip.Prefix
+
+	TxBytes uint64 // sent to the peer
+	RxBytes uint64 // received from the peer
+}
+
+// PostureResponse is the JSON type returned by the LocalAPI /posture
+// handler.
+type PostureResponse struct {
+	// Attributes are the device posture attributes reported to the
+	// control server, mapping keys like "diskencryption:enabled" to
+	// their values.
+	Attributes map[string]string
+
+	// Errors are the errors of the posture collectors that failed, by
+	// collector name. Their attributes aren't reported.
+	Errors map[string]string `json:",omitempty"`
+
+	// Collected is when the attributes were last collected, or the zero
+	// time if no posture collector is registered.
+	Collected time.Time
+}
+
+// SpeedTestResponse is the JSON type returned by the LocalAPI /speedtest
+// handler.
+type SpeedTestResponse struct {
+	Protocol  speedtest.Protocol // "tcp" or "udp"
+	Direction string             // "download" (from the peer) or "upload"
+
+	// Path is how the test traffic reached the peer, such as
+	// "direct 203.0.113.5:41641" or "DERP(nyc)".
+	Path string
+
+	Results []speedtest.Result
+	UDP     *speedtest.UDPStats `json:",omitempty"` // for UDP tests
+}
+
+// LatencyMatrix is the JSON type returned by the LocalAPI
+// /debug-latency-matrix handler.
+type LatencyMatrix struct {
+	// Rows are the latencies measured by each node, starting with this
+	// one.
+	Rows []LatencyRow
+}
+
+// LatencyRow is the latencies from one node to the others of a
+// LatencyMatrix. It's also the JSON type returned by the PeerAPI
+// /v0/latency handler.
+type LatencyRow struct {
+	From     string // Tailscale IP of the node that measured
+	FromName string // its MagicDNS name
+
+	// Err is why the node couldn't be asked to measure, if so.
+	Err string `json:",omitempty"`
+
+	Results []LatencyResult
+}
+
+// LatencyResult is the latency measured to one node.
+type LatencyResult struct {
+	To     string // Tailscale IP of the node measured
+	ToName string // its MagicDNS name
+
+	Err            string  `json:",omitempty"`
+	LatencySeconds float64 `json:",omitempty"`
+
+	// Path is how the pings reached the node, such as
+	// "direct 203.0.113.5:41641" or "DERP(nyc)", or "self" for the
+	// measuring node itself.
+	Path string `json:",omitempty"`
+}
+
+// WakeOnLANResponse is the JSON type returned by the LocalAPI
+// /wake-on-lan handler, and by the PeerAPI /v0/wol handler of the node
+// that sent the Wake-on-LAN packets.
+type WakeOnLANResponse struct {
+	SentTo []string // names of the interfaces the packet was sent on
+	Errors []string // errors sending on other interfaces
+}
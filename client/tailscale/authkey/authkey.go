@@ -0,0 +1,139 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+// Package authkey mints Tailscale auth keys using an OAuth client
+// (https://tailscale.com/kb/1215/oauth-clients/), for provisioning
+// pipelines that create a short-lived key per machine.
+package authkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tailscale.com/client/tailscale"
+	"tailscale.com/tailcfg"
+)
+
+// DefaultBaseURL is the API server used if Options.BaseURL is empty.
+const DefaultBaseURL = "https://api.tailscale.com"
+
+// Options are the parameters of the auth keys to mint.
+type Options struct {
+	// ClientID and ClientSecret are the credentials of an OAuth client
+	// with the "devices" scope.
+	ClientID     string
+	ClientSecret string
+
+	// BaseURL optionally specifies an alternate API server.
+	// If empty, DefaultBaseURL is used.
+	BaseURL string
+
+	// Tags are the ACL tags applied to nodes that use the key.
+	// Keys minted via an OAuth client must have at least one tag.
+	Tags []string
+
+	// Reusable is whether the key can register more than one node.
+	Reusable bool
+	// Ephemeral is whether nodes registered with the key are ephemeral
+	// (https://tailscale.com/kb/1111/ephemeral-nodes/).
+	Ephemeral bool
+	// Preauthorized is whether nodes registered with the key skip
+	// device approval, if it is enabled for the tailnet.
+	Preauthorized bool
+
+	// Expiry is how long the key is valid for. If zero, the server's
+	// default (90 days) applies.
+	Expiry time.Duration
+
+	// Description is an optional human-readable description of the key,
+	// shown in the admin console.
+	Description string
+}
+
+// Validate reports whether o describes a key that can be minted.
+func (o *Options) Validate() error {
+	if o.ClientID == "" || o.ClientSecret == "" {
+		return errors.New("OAuth client ID and secret are required")
+	}
+	if len(o.Tags) == 0 {
+		return errors.New("at least one tag must be specified")
+	}
+	for _, t := range o.Tags {
+		if err := tailcfg.CheckTag(t); err != nil {
+			return fmt.Errorf("invalid tag %q: %w", t, err)
+		}
+	}
+	if o.Expiry < 0 {
+		return errors.New("expiry must not be negative")
+	}
+	if o.Expiry != 0 && o.Expiry < time.Second {
+		return errors.New("expiry must be at least one second")
+	}
+	return nil
+}
+
+// Minter mints auth keys. Use New to create one.
+//
+// A Minter caches its OAuth access token, so minting many keys with the
+// same Minter only authenticates once.
+type Minter struct {
+	opts   Options
+	client *tailscale.Client
+}
+
+// New returns a Minter for keys described by opts.
+//
+// It requires the caller to have set tailscale.I_Acknowledge_This_API_Is_Unstable.
+func New(opts Options) (*Minter, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("authkey: %w", err)
+	}
+	if opts.BaseURL == "" {
+		opts.BaseURL = DefaultBaseURL
+	}
+	c := tailscale.NewClient("-", &tailscale.OAuthClientCredentials{
+		ClientID:     opts.ClientID,
+		ClientSecret: opts.ClientSecret,
+		Scopes:       []string{"devices"},
+	})
+	c.BaseURL = opts.BaseURL
+	return &Minter{opts: opts, client: c}, nil
+}
+
+// Client returns the API client used by m. Its HTTPClient may be
+// replaced before the first call to Mint.
+func (m *Minter) Client() *tailscale.Client { return m.client }
+
+// Mint creates a new auth key and returns its secret value, which
+// cannot be retrieved again later, along with its metadata.
+func (m *Minter) Mint(ctx context.Context) (string, *tailscale.Key, error) {
+	caps := tailscale.KeyCapabilities{
+		Devices: tailscale.KeyDeviceCapabilities{
+			Create: tailscale.KeyDeviceCreateCapabilities{
+				Reusable:      m.opts.Reusable,
+				Ephemeral:     m.opts.Ephemeral,
+				Preauthorized: m.opts.Preauthorized,
+				Tags:          m.opts.Tags,
+			},
+		},
+	}
+	secret, key, err := m.client.CreateKeyWithDescription(ctx, caps, m.opts.Expiry, m.opts.Description)
+	if err != nil {
+		return "", nil, fmt.Errorf("authkey: %w", err)
+	}
+	return secret, key, nil
+}
+
+// Mint is a convenience function that mints a single auth key described
+// by opts.
+func Mint(ctx context.Context, opts Options) (string, *tailscale.Key, error) {
+	m, err := New(opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return m.Mint(ctx)
+}
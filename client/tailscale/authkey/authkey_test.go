@@ -0,0 +1,114 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package authkey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale"
+)
+
+func TestValidate(t *testing.T) {
+	valid := Options{ClientID: "id", ClientSecret: "secret", Tags: []string{"tag:ci"}}
+	tests := []struct {
+		name    string
+		mod     func(*Options)
+		wantErr bool
+	}{
+		{"valid", func(*Options) {}, false},
+		{"no-secret", func(o *Options) { o.ClientSecret = "" }, true},
+		{"no-tags", func(o *Options) { o.Tags = nil }, true},
+		{"bad-tag", func(o *Options) { o.Tags = []string{"ci"} }, true},
+		{"negative-expiry", func(o *Options) { o.Expiry = -time.Hour }, true},
+		{"tiny-expiry", func(o *Options) { o.Expiry = time.Millisecond }, true},
+		{"expiry", func(o *Options) { o.Expiry = time.Hour }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := valid
+			tt.mod(&o)
+			if err := o.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate = %v; wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMint(t *testing.T) {
+	tailscale.I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { tailscale.I_Acknowledge_This_API_Is_Unstable = false }()
+
+	var tokens int
+	var gotReq map[string]any
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		tokens++
+		if r.FormValue("scope") != "devices" {
+			t.Errorf("scope = %q", r.FormValue("scope"))
+		}
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "tok", "expires_in": 3600})
+	})
+	mux.HandleFunc("/api/v2/tailnet/-/keys", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		gotReq = nil
+		json.NewDecoder(r.Body).Decode(&gotReq)
+		w.Write([]byte(`{"id":"kid","key":"tskey-auth-secret"}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	m, err := New(Options{
+		ClientID:      "id",
+		ClientSecret:  "secret",
+		BaseURL:       ts.URL,
+		Tags:          []string{"tag:ci"},
+		Ephemeral:     true,
+		Preauthorized: true,
+		Expiry:        10 * time.Minute,
+		Description:   "ci job",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		secret, key, err := m.Mint(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret != "tskey-auth-secret" || key.ID != "kid" {
+			t.Errorf("Mint = %q, %+v", secret, key)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("minted %d access tokens; want 1", tokens)
+	}
+	want := map[string]any{
+		"capabilities": map[string]any{
+			"devices": map[string]any{
+				"create": map[string]any{
+					"reusable":      false,
+					"ephemeral":     true,
+					"preauthorized": true,
+					"tags":          []any{"tag:ci"},
+				},
+			},
+		},
+		"expirySeconds": float64(600),
+		"description":   "ci job",
+	}
+	if !reflect.DeepEqual(gotReq, want) {
+		t.Errorf("key request = %v; want %v", gotReq, want)
+	}
+}
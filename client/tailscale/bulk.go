@@ -0,0 +1,163 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"tailscale.com/util/multierr"
+)
+
+// BulkOptions configures a bulk device operation.
+type BulkOptions struct {
+	// Concurrency is how many devices to operate on at once.
+	// If zero or negative, 4 is used.
+	Concurrency int
+
+	// DryRun, if true, makes the operation make no changes. Each
+	// device's BulkResult reports what would have been done.
+	DryRun bool
+}
+
+func (o *BulkOptions) concurrency() int {
+	if o == nil || o.Concurrency <= 0 {
+		return 4
+	}
+	return o.Concurrency
+}
+
+func (o *BulkOptions) dryRun() bool { return o != nil && o.DryRun }
+
+// BulkResult is the outcome of a bulk operation on one device.
+type BulkResult struct {
+	DeviceID string
+
+	// Action describes what was done to the device, or what would have
+	// been done on a dry run. It's empty if there was nothing to do.
+	Action string
+
+	// Err is the error operating on the device, if any.
+	Err error
+}
+
+// DeviceIDs returns the IDs of devs for use with the bulk operations,
+// optionally only those for which keep returns true.
+func DeviceIDs(devs []*Device, keep func(*Device) bool) []string {
+	var ids []string
+	for _, d := range devs {
+		if keep == nil || keep(d) {
+			ids = append(ids, d.DeviceID)
+		}
+	}
+	return ids
+}
+
+// bulk runs do for each of deviceIDs, at most opts.concurrency() at a
+// time. do returns the action taken, or to take if dryRun, on the
+// device. It returns each device's result, in the order of deviceIDs,
+// and all errors combined.
+func (c *Client) bulk(ctx context.Context, deviceIDs []string, opts *BulkOptions, do func(ctx context.Context, deviceID string, dryRun bool) (string, error)) ([]BulkResult, error) {
+	res := make([]BulkResult, len(deviceIDs))
+	sem := make(chan struct{}, opts.concurrency())
+	var wg sync.WaitGroup
+	for i, id := range deviceIDs {
+		res[i].DeviceID = id
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			res[i].Err = ctx.Err()
+			continue
+		}
+		wg.Add(1)
+		go func(r *BulkResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			r.Action, r.Err = do(ctx, r.DeviceID, opts.dryRun())
+			if r.Err != nil {
+				r.Err = fmt.Errorf("device %s: %w", r.DeviceID, r.Err)
+			}
+		}(&res[i])
+	}
+	wg.Wait()
+	var errs []error
+	for _, r := range res {
+		if r.Err != nil {
+			errs = append(errs, r.Err)
+		}
+	}
+	return res, multierr.New(errs...)
+}
+
+// BulkSetTags sets the ACL tags of each of deviceIDs to tags.
+func (c *Client) BulkSetTags(ctx context.Context, deviceIDs []string, tags []string, opts *BulkOptions) ([]BulkResult, error) {
+	return c.bulk(ctx, deviceIDs, opts, func(ctx context.Context, id string, dryRun bool) (string, error) {
+		action := fmt.Sprintf("set tags %q", tags)
+		if dryRun {
+			return action, nil
+		}
+		return action, c.SetTags(ctx, id, tags)
+	})
+}
+
+// BulkEnableRoutes enables the subnet routes each of deviceIDs
+// advertises, keeping those already enabled.
+func (c *Client) BulkEnableRoutes(ctx context.Context, deviceIDs []string, opts *BulkOptions) ([]BulkResult, error) {
+	return c.bulk(ctx, deviceIDs, opts, func(ctx context.Context, id string, dryRun bool) (string, error) {
+		routes, err := c.Routes(ctx, id)
+		if err != nil {
+			return "", err
+		}
+		enabled := make(map[netip.Prefix]bool)
+		for _, p := range routes.EnabledRoutes {
+			enabled[p] = true
+		}
+		want := routes.EnabledRoutes
+		var added []netip.Prefix
+		for _, p := range routes.AdvertisedRoutes {
+			if !enabled[p] {
+				added = append(added, p)
+				want = append(want, p)
+			}
+		}
+		if len(added) == 0 {
+			return "", nil
+		}
+		action := fmt.Sprintf("enable routes %v", added)
+		if dryRun {
+			return action, nil
+		}
+		_, err = c.SetRoutes(ctx, id, want)
+		return action, err
+	})
+}
+
+// BulkSetKeyExpiryDisabled sets whether the node keys of each of
+// deviceIDs expires.
+func (c *Client) BulkSetKeyExpiryDisabled(ctx context.Context, deviceIDs []string, disabled bool, opts *BulkOptions) ([]BulkResult, error) {
+	return c.bulk(ctx, deviceIDs, opts, func(ctx context.Context, id string, dryRun bool) (string, error) {
+		action := "enable key expiry"
+		if disabled {
+			action = "disable key expiry"
+		}
+		if dryRun {
+			return action, nil
+		}
+		return action, c.SetKeyExpiryDisabled(ctx, id, disabled)
+	})
+}
+
+// BulkDeleteDevices deletes each of deviceIDs from the Client's tailnet.
+func (c *Client) BulkDeleteDevices(ctx context.Context, deviceIDs []string, opts *BulkOptions) ([]BulkResult, error) {
+	return c.bulk(ctx, deviceIDs, opts, func(ctx context.Context, id string, dryRun bool) (string, error) {
+		if dryRun {
+			return "delete", nil
+		}
+		return "delete", c.DeleteDevice(ctx, id)
+	})
+}
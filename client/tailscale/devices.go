@@ -119,30 +119,17 @@ func (c *Client) Devices(ctx context.Context, fields *DeviceFieldsOpts) (deviceL
 		}
 	}()
 
-	path := fmt.Sprintf("%s/api/v2/tailnet/%s/devices", c.baseURL(), c.tailnet)
-	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-	// Add fields.
-	fieldStr := fields.addFieldsToQueryParameter()
-	q := req.URL.Query()
-	q.Add("fields", fieldStr)
-	req.URL.RawQuery = q.Encode()
-
-	b, resp, err := c.sendRequest(req)
-	if err != nil {
-		return nil, err
-	}
-	// If status code was not successful, return the error.
-	// TODO: Change the check for the StatusCode to include other 2XX success codes.
-	if resp.StatusCode != http.StatusOK {
-		return nil, handleErrorResponse(b, resp)
-	}
-
-	var devices GetDevicesResponse
-	err = json.Unmarshal(b, &devices)
-	return devices.Devices, err
+	q := url.Values{"fields": {fields.addFieldsToQueryParameter()}}
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/devices?%s", c.baseURL(), c.tailnet, q.Encode())
+	err = c.getPages(ctx, path, func(b []byte) error {
+		var devices GetDevicesResponse
+		if err := json.Unmarshal(b, &devices); err != nil {
+			return err
+		}
+		deviceList = append(deviceList, devices.Devices...)
+		return nil
+	})
+	return deviceList, err
 }
 
 // Device retrieved the details for a specific device.
@@ -259,3 +246,31 @@ func (c *Client) SetTags(ctx context.Context, deviceID string, tags []string) er
 
 	return nil
 }
+
+// SetKeyExpiryDisabled sets whether a device's node key expires.
+func (c *Client) SetKeyExpiryDisabled(ctx context.Context, deviceID string, disabled bool) error {
+	params := &struct {
+		KeyExpiryDisabled bool `json:"keyExpiryDisabled"`
+	}{KeyExpiryDisabled: disabled}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("%s/api/v2/device/%s/key", c.baseURL(), url.PathEscape(deviceID))
+	req, err := http.NewRequestWithContext(ctx, "POST", path, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	// If status code was not successful, return the error.
+	// TODO: Change the check for the StatusCode to include other 2XX success codes.
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(b, resp)
+	}
+
+	return nil
+}
@@ -34,6 +34,11 @@ type DNSSearchPaths struct {
 	SearchPaths []string `json:"searchPaths"` // DNS search paths
 }
 
+// SplitDNSConfig maps DNS domains to the nameservers that resolve them
+// for the tailnet. In an update, a nil value for a domain removes its
+// split DNS configuration.
+type SplitDNSConfig map[string][]string
+
 // DNSPreferences is the preferences set for a given tailnet.
 //
 // It includes MagicDNS which can be turned on or off. To enable MagicDNS,
@@ -64,17 +69,23 @@ func (c *Client) dnsGETRequest(ctx context.Context, endpoint string) ([]byte, er
 }
 
 func (c *Client) dnsPOSTRequest(ctx context.Context, endpoint string, postData interface{}) ([]byte, error) {
+	return c.dnsWriteRequest(ctx, "POST", endpoint, postData)
+}
+
+// dnsWriteRequest sends postData as JSON to the DNS endpoint using method,
+// which is one of POST, PUT or PATCH.
+func (c *Client) dnsWriteRequest(ctx context.Context, method, endpoint string, postData interface{}) ([]byte, error) {
 	path := fmt.Sprintf("%s/api/v2/tailnet/%s/dns/%s", c.baseURL(), c.tailnet, endpoint)
 	data, err := json.Marshal(&postData)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", path, bytes.NewBuffer(data))
-	req.Header.Set("Content-Type", "application/json")
+	req, err := http.NewRequestWithContext(ctx, method, path, bytes.NewBuffer(data))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/json")
 
 	b, resp, err := c.sendRequest(req)
 	if err != nil {
@@ -231,3 +242,58 @@ func (c *Client) SetSearchPaths(ctx context.Context, searchpaths []string) (newS
 	err = json.Unmarshal(b, &dnsResp)
 	return dnsResp.SearchPaths, err
 }
+
+// SplitDNS retrieves the split DNS configuration for a tailnet.
+func (c *Client) SplitDNS(ctx context.Context) (cfg SplitDNSConfig, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SplitDNS: %w", err)
+		}
+	}()
+	b, err := c.dnsGETRequest(ctx, "split-dns")
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &cfg)
+	return cfg, err
+}
+
+// SetSplitDNS replaces the split DNS configuration of a tailnet with cfg.
+// An empty cfg removes all split DNS configuration.
+//
+// It returns the resulting split DNS configuration.
+func (c *Client) SetSplitDNS(ctx context.Context, cfg SplitDNSConfig) (newCfg SplitDNSConfig, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetSplitDNS: %w", err)
+		}
+	}()
+	if cfg == nil {
+		cfg = SplitDNSConfig{}
+	}
+	b, err := c.dnsWriteRequest(ctx, "PUT", "split-dns", cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &newCfg)
+	return newCfg, err
+}
+
+// UpdateSplitDNS merges cfg into the split DNS configuration of a tailnet.
+// Domains not mentioned in cfg are left unchanged, and domains mapped to
+// nil in cfg are removed.
+//
+// It returns the resulting split DNS configuration.
+func (c *Client) UpdateSplitDNS(ctx context.Context, cfg SplitDNSConfig) (newCfg SplitDNSConfig, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.UpdateSplitDNS: %w", err)
+		}
+	}()
+	b, err := c.dnsWriteRequest(ctx, "PATCH", "split-dns", cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &newCfg)
+	return newCfg, err
+}
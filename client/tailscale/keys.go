@@ -17,6 +17,9 @@ type Key struct {
 	ID           string          `json:"id"`
 	Created      time.Time       `json:"created"`
 	Expires      time.Time       `json:"expires"`
+	Revoked      time.Time       `json:"revoked"` // zero if not revoked
+	Invalid      bool            `json:"invalid,omitempty"`
+	Description  string          `json:"description,omitempty"`
 	Capabilities KeyCapabilities `json:"capabilities"`
 }
 
@@ -38,32 +41,38 @@ type KeyDeviceCreateCapabilities struct {
 	Tags          []string `json:"tags,omitempty"`
 }
 
-// Keys returns the list of keys for the current user.
+// Keys returns the list of key IDs for the current user.
 func (c *Client) Keys(ctx context.Context) ([]string, error) {
-	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys", c.baseURL(), c.tailnet)
-	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	b, resp, err := c.sendRequest(req)
+	keys, err := c.ListKeys(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, handleErrorResponse(b, resp)
+	ret := make([]string, 0, len(keys))
+	for _, k := range keys {
+		ret = append(ret, k.ID)
 	}
+	return ret, nil
+}
 
-	var keys struct {
-		Keys []*Key `json:"keys"`
-	}
-	if err := json.Unmarshal(b, &keys); err != nil {
+// ListKeys returns the metadata of all keys for the current user,
+// following pagination if the server splits the list over several
+// responses.
+func (c *Client) ListKeys(ctx context.Context) ([]*Key, error) {
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys", c.baseURL(), c.tailnet)
+	var ret []*Key
+	err := c.getPages(ctx, path, func(b []byte) error {
+		var keys struct {
+			Keys []*Key `json:"keys"`
+		}
+		if err := json.Unmarshal(b, &keys); err != nil {
+			return err
+		}
+		ret = append(ret, keys.Keys...)
+		return nil
+	})
+	if err != nil {
 		return nil, err
 	}
-	ret := make([]string, 0, len(keys.Keys))
-	for _, k := range keys.Keys {
-		ret = append(ret, k.ID)
-	}
 	return ret, nil
 }
 
@@ -71,9 +80,39 @@ func (c *Client) Keys(ctx context.Context) ([]string, error) {
 // can be created. Returns the key itself, which cannot be retrieved again
 // later, and the key metadata.
 func (c *Client) CreateKey(ctx context.Context, caps KeyCapabilities) (string, *Key, error) {
-	keyRequest := struct {
-		Capabilities KeyCapabilities `json:"capabilities"`
-	}{caps}
+	return c.CreateKeyWithExpiry(ctx, caps, 0)
+}
+
+// CreateKeyWithExpiry is like CreateKey, but allows specifying a expiration time.
+//
+// The time is truncated to a whole number of seconds. If zero, that means no expiration.
+func (c *Client) CreateKeyWithExpiry(ctx context.Context, caps KeyCapabilities, expiry time.Duration) (string, *Key, error) {
+	return c.createKey(ctx, createKeyRequest{
+		Capabilities:  caps,
+		ExpirySeconds: int64(expiry.Seconds()),
+	})
+}
+
+// CreateKeyWithDescription is like CreateKeyWithExpiry, but also attaches
+// a human-readable description to the key, shown in the admin console.
+func (c *Client) CreateKeyWithDescription(ctx context.Context, caps KeyCapabilities, expiry time.Duration, description string) (string, *Key, error) {
+	return c.createKey(ctx, createKeyRequest{
+		Capabilities:  caps,
+		ExpirySeconds: int64(expiry.Seconds()),
+		Description:   description,
+	})
+}
+
+type createKeyRequest struct {
+	Capabilities  KeyCapabilities `json:"capabilities"`
+	ExpirySeconds int64           `json:"expirySeconds,omitempty"`
+	Description   string          `json:"description,omitempty"`
+}
+
+func (c *Client) createKey(ctx context.Context, keyRequest createKeyRequest) (string, *Key, error) {
+	if keyRequest.ExpirySeconds < 0 {
+		return "", nil, fmt.Errorf("negative key expiry")
+	}
 	bs, err := json.Marshal(keyRequest)
 	if err != nil {
 		return "", nil, err
@@ -127,7 +166,7 @@ func (c *Client) Key(ctx context.Context, id string) (*Key, error) {
 	return &key, nil
 }
 
-// DeleteKey deletes the key with the given ID.
+// DeleteKey deletes (revokes) the key with the given ID.
 func (c *Client) DeleteKey(ctx context.Context, id string) error {
 	path := fmt.Sprintf("%s/api/v2/tailnet/%s/keys/%s", c.baseURL(), c.tailnet, id)
 	req, err := http.NewRequestWithContext(ctx, "DELETE", path, nil)
@@ -28,8 +28,10 @@
 	"go4.org/mem"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/envknob"
+	"tailscale.com/health"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/netutil"
 	"tailscale.com/paths"
 	"tailscale.com/safesocket"
@@ -37,6 +39,7 @@
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
 	"tailscale.com/types/tkatype"
+	"tailscale.com/wgengine/filter"
 )
 
 // defaultLocalClient is the default LocalClient when using the legacy
@@ -368,18 +371,98 @@ func (lc *LocalClient) DebugAction(ctx context.Context, action string) error {
 	return nil
 }
 
+// StartFilterTrace makes tailscaled's packet filter record its decisions
+// about new flows for d, or stop if d is zero. If ip is valid, only flows
+// to or from it are recorded.
+func (lc *LocalClient) StartFilterTrace(ctx context.Context, d time.Duration, ip netip.Addr) error {
+	v := url.Values{}
+	v.Set("dur", d.String())
+	if ip.IsValid() {
+		v.Set("ip", ip.String())
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-filter-trace?"+v.Encode(), http.StatusNoContent, nil)
+	if err != nil {
+		return fmt.Errorf("error %w: %s", err, body)
+	}
+	return nil
+}
+
+// FilterTrace returns the packet filter decisions recorded since the last
+// StartFilterTrace, oldest first.
+func (lc *LocalClient) FilterTrace(ctx context.Context) ([]filter.TraceEntry, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-filter-trace")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]filter.TraceEntry](body)
+}
+
+// DebugSuggestedRoutes returns the subnets that traffic through the node
+// was observed to or from, as a subnet router might want to advertise.
+func (lc *LocalClient) DebugSuggestedRoutes(ctx context.Context) (*apitype.SuggestedRoutesResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-suggested-routes")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.SuggestedRoutesResponse](body)
+}
+
+// DebugSetObserveRoutes starts or stops observing the traffic through the
+// node for DebugSuggestedRoutes. Starting discards any earlier
+// observations.
+func (lc *LocalClient) DebugSetObserveRoutes(ctx context.Context, on bool) (*apitype.SuggestedRoutesResponse, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-suggested-routes?observe="+strconv.FormatBool(on), 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.SuggestedRoutesResponse](body)
+}
+
 // DebugPortmap invokes the debug-portmap endpoint, and returns an
 // io.ReadCloser that can be used to read the logs that are printed during this
 // process.
 func (lc *LocalClient) DebugPortmap(ctx context.Context, duration time.Duration, ty, gwSelf string) (io.ReadCloser, error) {
+	return lc.DebugPortmapWithOpts(ctx, DebugPortmapOpts{
+		Duration:       duration,
+		Type:           ty,
+		GatewayAndSelf: gwSelf,
+	})
+}
+
+// DebugPortmapOpts are the options of the debug-portmap endpoint.
+type DebugPortmapOpts struct {
+	// Duration is how long to wait for each port mapping.
+	Duration time.Duration
+
+	// Type is the port mapping protocol to try: "pmp", "pcp" or "upnp".
+	// If empty, all of the ones that the gateway offers are tried.
+	Type string
+
+	// GatewayAndSelf, if non-empty, overrides the gateway and self IPs,
+	// as "gatewayIP/selfIP".
+	GatewayAndSelf string
+
+	// Hold, if positive, is how long to hold each mapping for, renewing
+	// it as needed, to see how the gateway treats it.
+	Hold time.Duration
+}
+
+func (o DebugPortmapOpts) values() url.Values {
 	vals := make(url.Values)
-	vals.Set("duration", duration.String())
-	vals.Set("type", ty)
-	if gwSelf != "" {
-		vals.Set("gateway_and_self", gwSelf)
+	vals.Set("duration", o.Duration.String())
+	vals.Set("type", o.Type)
+	if o.GatewayAndSelf != "" {
+		vals.Set("gateway_and_self", o.GatewayAndSelf)
+	}
+	if o.Hold > 0 {
+		vals.Set("hold", o.Hold.String())
 	}
+	return vals
+}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-portmap?"+vals.Encode(), nil)
+// DebugPortmapWithOpts is like DebugPortmap, with more options.
+func (lc *LocalClient) DebugPortmapWithOpts(ctx context.Context, opts DebugPortmapOpts) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+apitype.LocalAPIHost+"/localapi/v0/debug-portmap?"+opts.values().Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -396,6 +479,18 @@ func (lc *LocalClient) DebugPortmap(ctx context.Context, duration time.Duration,
 	return res.Body, nil
 }
 
+// DebugPortmapReport invokes the debug-portmap endpoint and returns its
+// report of the gateway's port mapping behavior, including the logs.
+func (lc *LocalClient) DebugPortmapReport(ctx context.Context, opts DebugPortmapOpts) (*ipnstate.DebugPortmapReport, error) {
+	vals := opts.values()
+	vals.Set("format", "json")
+	body, err := lc.get200(ctx, "/localapi/v0/debug-portmap?"+vals.Encode())
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.DebugPortmapReport](body)
+}
+
 // SetDevStoreKeyValue set a statestore key/value. It's only meant for development.
 // The schema (including when keys are re-read) is not a stable interface.
 func (lc *LocalClient) SetDevStoreKeyValue(ctx context.Context, key, value string) error {
@@ -568,6 +663,27 @@ func (lc *LocalClient) CheckIPForwarding(ctx context.Context) error {
 	return nil
 }
 
+// IPForwardingReport is like CheckIPForwarding, but also returns which
+// sysctls need enabling, for FixIPForwarding.
+func (lc *LocalClient) IPForwardingReport(ctx context.Context) (*ipnstate.IPForwardingReport, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/check-ip-forwarding")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.IPForwardingReport](body)
+}
+
+// FixIPForwarding asks the local Tailscale daemon to enable the sysctls
+// needed to forward IP packets as a subnet router or exit node, until
+// reboot. It returns the IP forwarding report afterwards.
+func (lc *LocalClient) FixIPForwarding(ctx context.Context) (*ipnstate.IPForwardingReport, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/check-ip-forwarding", 200, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.IPForwardingReport](body)
+}
+
 // CheckPrefs validates the provided preferences, without making any changes.
 //
 // The CLI uses this before a Start call to fail fast if the preferences won't
@@ -599,6 +715,16 @@ func (lc *LocalClient) EditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) (*ipn
 	return decodeJSON[*ipn.Prefs](body)
 }
 
+// PreviewEditPrefs returns the changes that EditPrefs(ctx, mp) would make
+// to the current prefs, without applying them.
+func (lc *LocalClient) PreviewEditPrefs(ctx context.Context, mp *ipn.MaskedPrefs) ([]ipn.PrefChange, error) {
+	body, err := lc.send(ctx, "POST", "/localapi/v0/preview-prefs", http.StatusOK, jsonBody(mp))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.PrefChange](body)
+}
+
 // StartLoginInteractive starts an interactive login.
 func (lc *LocalClient) StartLoginInteractive(ctx context.Context) error {
 	_, err := lc.send(ctx, "POST", "/localapi/v0/login-interactive", http.StatusNoContent, nil)
@@ -694,6 +820,51 @@ func (lc *LocalClient) DialTCP(ctx context.Context, host string, port uint16) (n
 	return netutil.NewAltReadWriteCloserConn(rwc, switchedConn), nil
 }
 
+// HealthChecks runs the local tailscaled's named health checks, such as
+// "control" and "cert-expiry", and returns their results.
+func (lc *LocalClient) HealthChecks(ctx context.Context) ([]health.CheckResult, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/health-checks")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]health.CheckResult](body)
+}
+
+// Usage returns the local tailscaled's daily totals of the traffic sent to
+// and received from peers, on the days from since onwards. If since is
+// zero, all the stored days are returned.
+func (lc *LocalClient) Usage(ctx context.Context, since time.Time) (*apitype.UsageResponse, error) {
+	path := "/localapi/v0/usage"
+	if !since.IsZero() {
+		path += "?since=" + since.Format("2006-01-02")
+	}
+	body, err := lc.get200(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.UsageResponse](body)
+}
+
+// Capabilities returns the capabilities (node attributes) that the control
+// server granted this node, including those unknown to tailscaled.
+func (lc *LocalClient) Capabilities(ctx context.Context) (*apitype.CapabilitiesResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/capabilities")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.CapabilitiesResponse](body)
+}
+
+// Posture returns the device posture attributes that tailscaled's posture
+// collectors report to the control server.
+func (lc *LocalClient) Posture(ctx context.Context) (*apitype.PostureResponse, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/posture")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*apitype.PostureResponse](body)
+}
+
 // CurrentDERPMap returns the current DERPMap that is being used by the local tailscaled.
 // It is intended to be used with netcheck to see availability of DERPs.
 func (lc *LocalClient) CurrentDERPMap(ctx context.Context) (*tailcfg.DERPMap, error) {
@@ -819,6 +990,94 @@ func (lc *LocalClient) Ping(ctx context.Context, ip netip.Addr, pingtype tailcfg
 	return decodeJSON[*ipnstate.PingResult](body)
 }
 
+// WarmPath establishes the best path to peer, a Tailscale IP or peer name,
+// ahead of latency-sensitive traffic to it, such as live video, so that the
+// traffic doesn't pay for the WireGuard handshake and path discovery. It
+// returns once a direct path is found, or after a few seconds if none is,
+// with the result of the last disco ping to peer.
+func (lc *LocalClient) WarmPath(ctx context.Context, peer string) (*ipnstate.PingResult, error) {
+	v := url.Values{}
+	v.Set("peer", peer)
+	body, err := lc.send(ctx, "POST", "/localapi/v0/warm-path?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.PingResult](body)
+}
+
+// LatencyMatrix measures the latency and path from this node to the peers
+// with the Tailscale IPs ips. If allPairs, the peers also measure their
+// latency to this node and each other.
+func (lc *LocalClient) LatencyMatrix(ctx context.Context, ips []netip.Addr, allPairs bool) (*apitype.LatencyMatrix, error) {
+	v := url.Values{}
+	for _, ip := range ips {
+		v.Add("ip", ip.String())
+	}
+	v.Set("all_pairs", strconv.FormatBool(allPairs))
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-latency-matrix?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.LatencyMatrix](body)
+}
+
+// SpeedTest runs a speedtest with the peer with the Tailscale IP ip over
+// its PeerAPI. The proto is "tcp" or "udp", and bitrate is the number of
+// bits per second sent in UDP tests; zero means the default. If upload is
+// false, the peer sends the test traffic.
+func (lc *LocalClient) SpeedTest(ctx context.Context, ip netip.Addr, proto string, upload bool, duration time.Duration, bitrate int64) (*apitype.SpeedTestResponse, error) {
+	v := url.Values{}
+	v.Set("ip", ip.String())
+	v.Set("proto", proto)
+	if upload {
+		v.Set("direction", "upload")
+	} else {
+		v.Set("direction", "download")
+	}
+	v.Set("duration", duration.String())
+	if bitrate != 0 {
+		v.Set("bitrate", strconv.FormatInt(bitrate, 10))
+	}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/speedtest?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.SpeedTestResponse](body)
+}
+
+// ConfigDrift returns the prefs declared in tailscaled's --config file
+// whose values were changed since, such as with "tailscale set".
+func (lc *LocalClient) ConfigDrift(ctx context.Context) ([]ipn.PrefChange, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/config-drift")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]ipn.PrefChange](body)
+}
+
+// ControlBackoff returns the schedules tailscaled's control client
+// retries failed requests to the control server on.
+func (lc *LocalClient) ControlBackoff(ctx context.Context) ([]backoff.State, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-control-backoff")
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[[]backoff.State](body)
+}
+
+// WakeOnLAN asks the peer with the Tailscale IP via, such as a subnet
+// router, to send a Wake-on-LAN packet for the MAC address mac on its LANs.
+func (lc *LocalClient) WakeOnLAN(ctx context.Context, via netip.Addr, mac string) (*apitype.WakeOnLANResponse, error) {
+	v := url.Values{}
+	v.Set("via", via.String())
+	v.Set("mac", mac)
+	body, err := lc.send(ctx, "POST", "/localapi/v0/wake-on-lan?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*apitype.WakeOnLANResponse](body)
+}
+
 // NetworkLockStatus fetches information about the tailnet key authority, if one is configured.
 func (lc *LocalClient) NetworkLockStatus(ctx context.Context) (*ipnstate.NetworkLockStatus, error) {
 	body, err := lc.send(ctx, "GET", "/localapi/v0/tka/status", 200, nil)
@@ -887,6 +1146,25 @@ type signRequest struct {
 	return nil
 }
 
+// NetworkLockSubmitSignature transmits sig, a node-key signature made
+// elsewhere by a trusted tailnet lock key, such as one on a hardware
+// token, to the control plane. The signature is verified first.
+func (lc *LocalClient) NetworkLockSubmitSignature(ctx context.Context, sig tkatype.MarshaledSignature) error {
+	var b bytes.Buffer
+	type submitRequest struct {
+		Signature tkatype.MarshaledSignature
+	}
+
+	if err := json.NewEncoder(&b).Encode(submitRequest{Signature: sig}); err != nil {
+		return err
+	}
+
+	if _, err := lc.send(ctx, "POST", "/localapi/v0/tka/submit-signature", 200, &b); err != nil {
+		return fmt.Errorf("error: %w", err)
+	}
+	return nil
+}
+
 // NetworkLockAffectedSigs returns all signatures signed by the specified keyID.
 func (lc *LocalClient) NetworkLockAffectedSigs(ctx context.Context, keyID tkatype.KeyID) ([]tkatype.MarshaledSignature, error) {
 	body, err := lc.send(ctx, "POST", "/localapi/v0/tka/affected-sigs", 200, bytes.NewReader(keyID))
@@ -1044,7 +1322,7 @@ func (lc *LocalClient) SwitchProfile(ctx context.Context, profile ipn.ProfileID)
 // If the profile is the current profile, an empty profile
 // will be selected as if SwitchToEmptyProfile was called.
 func (lc *LocalClient) DeleteProfile(ctx context.Context, profile ipn.ProfileID) error {
-	_, err := lc.send(ctx, "DELETE", "/localapi/v0/profiles"+url.PathEscape(string(profile)), http.StatusNoContent, nil)
+	_, err := lc.send(ctx, "DELETE", "/localapi/v0/profiles/"+url.PathEscape(string(profile)), http.StatusNoContent, nil)
 	return err
 }
 
@@ -1057,6 +1335,26 @@ func (lc *LocalClient) DebugDERPRegion(ctx context.Context, regionIDOrCode strin
 	return decodeJSON[*ipnstate.DebugDERPRegionReport](body)
 }
 
+// DebugDial asks tailscaled to connect to port on the peer host, a name or
+// IP, and to report how each stage of that went.
+func (lc *LocalClient) DebugDial(ctx context.Context, host string, port uint16) (*ipnstate.DebugDialReport, error) {
+	v := url.Values{"host": {host}, "port": {strconv.Itoa(int(port))}}
+	body, err := lc.send(ctx, "POST", "/localapi/v0/debug-dial?"+v.Encode(), 200, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error %w: %s", err, body)
+	}
+	return decodeJSON[*ipnstate.DebugDialReport](body)
+}
+
+// DebugResolveRoute asks tailscaled how it routes traffic to ip, and why.
+func (lc *LocalClient) DebugResolveRoute(ctx context.Context, ip netip.Addr) (*ipnstate.DebugRouteReport, error) {
+	body, err := lc.get200(ctx, "/localapi/v0/debug-resolve-route?ip="+url.QueryEscape(ip.String()))
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON[*ipnstate.DebugRouteReport](body)
+}
+
 // DebugSetExpireIn marks the current node key to expire in d.
 //
 // This is meant primarily for debug and testing.
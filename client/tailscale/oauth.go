@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenExpiryDelta is how long before its advertised expiry an OAuth
+// access token is considered stale and proactively refreshed.
+const tokenExpiryDelta = time.Minute
+
+// OAuthClientCredentials is an AuthMethod for NewClient that authenticates
+// requests using an OAuth client (https://tailscale.com/kb/1215/oauth-clients/).
+//
+// Short-lived access tokens are minted from the client ID and secret
+// using the OAuth 2.0 client credentials grant, cached, and refreshed
+// automatically before they expire or if the server rejects them.
+//
+// Exported fields should be set before the AuthMethod is used and not
+// changed thereafter. A single OAuthClientCredentials is safe for
+// concurrent use and may be shared by multiple Clients.
+type OAuthClientCredentials struct {
+	// ClientID and ClientSecret are the OAuth client's credentials.
+	ClientID     string
+	ClientSecret string
+
+	// Scopes optionally restricts the access token to a subset of the
+	// OAuth client's scopes, such as "devices" or "dns:read".
+	// If empty, the token carries all of the client's scopes.
+	Scopes []string
+
+	// TokenURL optionally specifies an alternate token endpoint.
+	// If empty, "/api/v2/oauth/token" under the Client's BaseURL is used.
+	TokenURL string
+
+	mu     sync.Mutex
+	token  string    // current access token, or empty
+	expiry time.Time // when token must be refreshed; zero means never
+}
+
+func (o *OAuthClientCredentials) modifyRequest(c *Client, req *http.Request) error {
+	tok, err := o.accessToken(req.Context(), c)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+tok)
+	return nil
+}
+
+func (o *OAuthClientCredentials) invalidateToken() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.token = ""
+}
+
+// accessToken returns a valid access token, minting a new one via c's
+// HTTP client if there is no cached token or it is about to expire.
+func (o *OAuthClientCredentials) accessToken(ctx context.Context, c *Client) (string, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.token != "" && (o.expiry.IsZero() || time.Now().Before(o.expiry)) {
+		return o.token, nil
+	}
+	tok, expiresIn, err := o.fetchToken(ctx, c)
+	if err != nil {
+		return "", fmt.Errorf("tailscale: minting OAuth access token: %w", err)
+	}
+	o.token = tok
+	o.expiry = time.Time{}
+	if expiresIn > 0 {
+		o.expiry = time.Now().Add(expiresIn - tokenExpiryDelta)
+	}
+	return tok, nil
+}
+
+func (o *OAuthClientCredentials) tokenURL(c *Client) string {
+	if o.TokenURL != "" {
+		return o.TokenURL
+	}
+	return c.baseURL() + "/api/v2/oauth/token"
+}
+
+// fetchToken performs the client credentials grant against the token
+// endpoint and returns the access token and its lifetime, which is zero
+// if the server didn't specify one.
+func (o *OAuthClientCredentials) fetchToken(ctx context.Context, c *Client) (tok string, expiresIn time.Duration, err error) {
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {o.ClientID},
+		"client_secret": {o.ClientSecret},
+	}
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", o.tokenURL(c), strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// Don't use c.sendRequest; that would recurse into modifyRequest.
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	var res struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+		Error       string `json:"error"`
+		Description string `json:"error_description"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, maxReadSize)).Decode(&res); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			return "", 0, fmt.Errorf("token endpoint returned %v", resp.Status)
+		}
+		return "", 0, err
+	}
+	if resp.StatusCode != http.StatusOK || res.Error != "" {
+		msg := res.Error
+		if res.Description != "" {
+			msg += ": " + res.Description
+		}
+		return "", 0, fmt.Errorf("token endpoint returned %v: %s", resp.Status, msg)
+	}
+	if res.AccessToken == "" {
+		return "", 0, fmt.Errorf("token endpoint returned no access_token")
+	}
+	if res.TokenType != "" && !strings.EqualFold(res.TokenType, "bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", res.TokenType)
+	}
+	return res.AccessToken, time.Duration(res.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestOAuthClientCredentials(t *testing.T) {
+	I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { I_Acknowledge_This_API_Is_Unstable = false }()
+
+	var (
+		minted    atomic.Int32
+		expiresIn atomic.Int64
+		revoked   atomic.Bool // reject the current token once
+	)
+	expiresIn.Store(3600)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v2/oauth/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		if got := r.PostForm.Get("grant_type"); got != "client_credentials" {
+			t.Errorf("grant_type = %q", got)
+		}
+		if r.PostForm.Get("client_id") != "id" || r.PostForm.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if got := r.PostForm.Get("scope"); got != "devices dns:read" {
+			t.Errorf("scope = %q", got)
+		}
+		n := minted.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": fmt.Sprintf("tok%d", n),
+			"token_type":   "Bearer",
+			"expires_in":   expiresIn.Load(),
+		})
+	})
+	mux.HandleFunc("/api/v2/tailnet/-/keys", func(w http.ResponseWriter, r *http.Request) {
+		want := fmt.Sprintf("Bearer tok%d", minted.Load())
+		if r.Header.Get("Authorization") != want || revoked.Swap(false) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"message":"unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`{"keys":[{"id":"k1"}]}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	creds := &OAuthClientCredentials{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		Scopes:       []string{"devices", "dns:read"},
+	}
+	c := NewClient("-", creds)
+	c.BaseURL = ts.URL
+	ctx := context.Background()
+
+	keys := func() {
+		t.Helper()
+		got, err := c.Keys(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0] != "k1" {
+			t.Fatalf("Keys = %q", got)
+		}
+	}
+	checkMinted := func(want int32) {
+		t.Helper()
+		if got := minted.Load(); got != want {
+			t.Fatalf("minted %d tokens; want %d", got, want)
+		}
+	}
+
+	// Tokens are cached while valid.
+	keys()
+	keys()
+	checkMinted(1)
+
+	// A token rejected by the server is refreshed and the request retried.
+	revoked.Store(true)
+	keys()
+	checkMinted(2)
+
+	// Tokens within tokenExpiryDelta of expiry are refreshed proactively.
+	creds.invalidateToken()
+	expiresIn.Store(int64(tokenExpiryDelta.Seconds() / 2))
+	keys()
+	keys()
+	checkMinted(4)
+
+	// Bad credentials surface the token endpoint's error.
+	bad := NewClient("-", &OAuthClientCredentials{ClientID: "id", ClientSecret: "wrong"})
+	bad.BaseURL = ts.URL
+	if _, err := bad.Keys(ctx); err == nil {
+		t.Fatal("expected error with bad client secret")
+	}
+}
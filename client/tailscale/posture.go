@@ -0,0 +1,118 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DevicePostureAttributes are the posture attributes of a device, such as
+// "node:os" or custom "custom:..." attributes set via the API.
+type DevicePostureAttributes struct {
+	// Attributes maps attribute keys to their values, which are strings,
+	// numbers or booleans.
+	Attributes map[string]any `json:"attributes"`
+	// Expiries maps attribute keys to when they expire, for attributes
+	// that were set with an expiry.
+	Expiries map[string]time.Time `json:"expiries,omitempty"`
+}
+
+// DevicePostureAttributes retrieves the posture attributes of a device.
+func (c *Client) DevicePostureAttributes(ctx context.Context, deviceID string) (attrs *DevicePostureAttributes, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DevicePostureAttributes: %w", err)
+		}
+	}()
+	path := fmt.Sprintf("%s/api/v2/device/%s/attributes", c.baseURL(), url.PathEscape(deviceID))
+	req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, handleErrorResponse(b, resp)
+	}
+	err = json.Unmarshal(b, &attrs)
+	return attrs, err
+}
+
+// SetDevicePostureAttribute sets the custom posture attribute key of a
+// device to value, which must be a string, number or boolean. If expiry is
+// non-zero, the attribute is removed automatically at that time.
+//
+// Only attributes in the "custom:" namespace can be set.
+func (c *Client) SetDevicePostureAttribute(ctx context.Context, deviceID, key string, value any, expiry time.Time) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetDevicePostureAttribute: %w", err)
+		}
+	}()
+	switch value.(type) {
+	case string, bool, int, int64, float64:
+	default:
+		return fmt.Errorf("unsupported attribute value type %T", value)
+	}
+	params := struct {
+		Value  any        `json:"value"`
+		Expiry *time.Time `json:"expiry,omitempty"`
+	}{Value: value}
+	if !expiry.IsZero() {
+		params.Expiry = &expiry
+	}
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", c.postureAttributePath(deviceID, key), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(b, resp)
+	}
+	return nil
+}
+
+// DeleteDevicePostureAttribute removes the custom posture attribute key
+// from a device.
+func (c *Client) DeleteDevicePostureAttribute(ctx context.Context, deviceID, key string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DeleteDevicePostureAttribute: %w", err)
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, "DELETE", c.postureAttributePath(deviceID, key), nil)
+	if err != nil {
+		return err
+	}
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return handleErrorResponse(b, resp)
+	}
+	return nil
+}
+
+func (c *Client) postureAttributePath(deviceID, key string) string {
+	return fmt.Sprintf("%s/api/v2/device/%s/attributes/%s", c.baseURL(), url.PathEscape(deviceID), url.PathEscape(key))
+}
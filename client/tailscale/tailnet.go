@@ -28,7 +28,6 @@ func (c *Client) TailnetDeleteRequest(ctx context.Context, tailnetID string) (er
 		return err
 	}
 
-	c.setAuth(req)
 	b, resp, err := c.sendRequest(req)
 	if err != nil {
 		return err
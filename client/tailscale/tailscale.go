@@ -11,11 +11,18 @@
 package tailscale
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
 )
 
 // I_Acknowledge_This_API_Is_Unstable must be set true to use this package
@@ -69,22 +76,208 @@ func (c *Client) baseURL() string {
 
 // AuthMethod is the interface for API authentication methods.
 //
-// Most users will use AuthKey.
+// Most users will use APIKey or OAuthClientCredentials.
 type AuthMethod interface {
-	modifyRequest(req *http.Request)
+	modifyRequest(c *Client, req *http.Request) error
 }
 
 // APIKey is an AuthMethod for NewClient that authenticates requests
 // using an authkey.
 type APIKey string
 
-func (ak APIKey) modifyRequest(req *http.Request) {
+func (ak APIKey) modifyRequest(c *Client, req *http.Request) error {
 	req.SetBasicAuth(string(ak), "")
+	return nil
 }
 
-func (c *Client) setAuth(r *http.Request) {
+func (c *Client) setAuth(r *http.Request) error {
 	if c.auth != nil {
-		c.auth.modifyRequest(r)
+		return c.auth.modifyRequest(c, r)
+	}
+	return nil
+}
+
+// tokenInvalidator is implemented by AuthMethods that cache credentials
+// which the server may reject before their advertised expiry.
+type tokenInvalidator interface {
+	invalidateToken()
+}
+
+// maxRateLimitRetries is how many times a request that was rejected with
+// 429 Too Many Requests is retried before the error is returned to the
+// caller.
+const maxRateLimitRetries = 3
+
+// maxRetryWait is the longest the client will wait before retrying a
+// rate-limited request. If the server asks for a longer wait, the 429
+// is returned to the caller instead.
+const maxRetryWait = time.Minute
+
+// do sends req with authentication.
+//
+// If the server rejects the credentials with a 401 and the AuthMethod
+// caches tokens, the token is discarded and the request is retried once
+// with a fresh one. If the server responds with 429 Too Many Requests,
+// the request is retried after the delay advertised in the Retry-After
+// header (or an exponential backoff), up to maxRateLimitRetries times.
+//
+// Requests are only retried if their body can be replayed.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	reauthed := false
+	rateLimited := 0
+	for {
+		if err := c.setAuth(req); err != nil {
+			return nil, err
+		}
+		resp, err := c.httpClient().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		var wait time.Duration
+		switch resp.StatusCode {
+		case http.StatusUnauthorized:
+			ti, ok := c.auth.(tokenInvalidator)
+			if !ok || reauthed {
+				return resp, nil
+			}
+			reauthed = true
+			ti.invalidateToken()
+		case http.StatusTooManyRequests:
+			if rateLimited == maxRateLimitRetries {
+				return resp, nil
+			}
+			wait = retryAfter(resp.Header, rateLimited)
+			if wait > maxRetryWait {
+				return resp, nil
+			}
+			rateLimited++
+		default:
+			return resp, nil
+		}
+		next, err := rewindRequest(req)
+		if err != nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-req.Context().Done():
+				t.Stop()
+				return nil, req.Context().Err()
+			case <-t.C:
+			}
+		}
+		req = next
+	}
+}
+
+// rewindRequest returns a copy of req suitable for sending again,
+// with a fresh body if it has one.
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req.Clone(req.Context()), nil
+	}
+	if req.GetBody == nil {
+		return nil, errors.New("request body cannot be replayed")
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, err
+	}
+	r2 := req.Clone(req.Context())
+	r2.Body = body
+	return r2, nil
+}
+
+// retryAfter returns how long to wait before retrying a rate-limited
+// request, per the Retry-After header in h. If the header is missing or
+// malformed, it backs off exponentially from one second based on the
+// number of previous retries.
+func retryAfter(h http.Header, retries int) time.Duration {
+	if v := h.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil {
+			if d := time.Until(t); d > 0 {
+				return d
+			}
+			return 0
+		}
+	}
+	return time.Second << retries
+}
+
+// nextPageURL returns the URL of the next page of results from the "next"
+// relation of resp's Link header (RFC 8288), if any, resolved against
+// base, the API server's URL.
+func nextPageURL(resp *http.Response, base *url.URL) (*url.URL, bool) {
+	for _, v := range resp.Header.Values("Link") {
+		for _, link := range strings.Split(v, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			isNext := false
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+				if strings.EqualFold(k, "rel") && slices.Contains(strings.Fields(strings.Trim(v, `"`)), "next") {
+					isNext = true
+				}
+			}
+			if !isNext {
+				continue
+			}
+			u, err := base.Parse(target[1 : len(target)-1])
+			if err != nil {
+				continue
+			}
+			return u, true
+		}
+	}
+	return nil, false
+}
+
+// maxPages bounds how many pages getPages will follow, as protection
+// against a server that links pages in a cycle.
+const maxPages = 1000
+
+// getPages GETs the API endpoint at path, then follows any pagination
+// links in the responses, calling fn with the body of each page in turn.
+func (c *Client) getPages(ctx context.Context, path string, fn func([]byte) error) error {
+	base, err := url.Parse(c.baseURL())
+	if err != nil {
+		return err
+	}
+	for i := 0; ; i++ {
+		if i == maxPages {
+			return errors.New("too many pages in API response")
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", path, nil)
+		if err != nil {
+			return err
+		}
+		b, resp, err := c.sendRequest(req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return handleErrorResponse(b, resp)
+		}
+		if err := fn(b); err != nil {
+			return err
+		}
+		next, ok := nextPageURL(resp, base)
+		if !ok {
+			return nil
+		}
+		// Requests carry the API credentials, so only follow links to
+		// the API server.
+		if next.Scheme != base.Scheme || next.Host != base.Host {
+			return fmt.Errorf("refusing to follow pagination link to %s://%s, not the API server", next.Scheme, next.Host)
+		}
+		path = next.String()
 	}
 }
 
@@ -109,8 +302,7 @@ func (c *Client) Do(req *http.Request) (*http.Response, error) {
 	if !I_Acknowledge_This_API_Is_Unstable {
 		return nil, errors.New("use of Client without setting I_Acknowledge_This_API_Is_Unstable")
 	}
-	c.setAuth(req)
-	return c.httpClient().Do(req)
+	return c.do(req)
 }
 
 // sendRequest add the authentication key to the request and sends it. It
@@ -119,8 +311,7 @@ func (c *Client) sendRequest(req *http.Request) ([]byte, *http.Response, error)
 	if !I_Acknowledge_This_API_Is_Unstable {
 		return nil, nil, errors.New("use of Client without setting I_Acknowledge_This_API_Is_Unstable")
 	}
-	c.setAuth(req)
-	resp, err := c.httpClient().Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, resp, err
 	}
@@ -0,0 +1,209 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNextPageURL(t *testing.T) {
+	base, _ := url.Parse("https://api.example.com/api/v2/tailnet/-/devices")
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"", ""},
+		{`<https://api.example.com/p2>; rel="next"`, "https://api.example.com/p2"},
+		{`</api/v2/tailnet/-/devices?cursor=abc>; rel=next`, "https://api.example.com/api/v2/tailnet/-/devices?cursor=abc"},
+		{`<https://api.example.com/p1>; rel="prev", <https://api.example.com/p3>; rel="next last"`, "https://api.example.com/p3"},
+		{`<https://api.example.com/p1>; rel="prev"`, ""},
+		{`garbage`, ""},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if tt.link != "" {
+			resp.Header.Set("Link", tt.link)
+		}
+		u, ok := nextPageURL(resp, base)
+		var got string
+		if ok {
+			got = u.String()
+		}
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("nextPageURL(%q) = %q, %v; want %q", tt.link, got, ok, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	h := http.Header{}
+	if got := retryAfter(h, 2); got != 4*time.Second {
+		t.Errorf("backoff = %v; want 4s", got)
+	}
+	h.Set("Retry-After", "7")
+	if got := retryAfter(h, 2); got != 7*time.Second {
+		t.Errorf("seconds = %v; want 7s", got)
+	}
+	h.Set("Retry-After", time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if got := retryAfter(h, 0); got != 0 {
+		t.Errorf("past date = %v; want 0", got)
+	}
+}
+
+func TestPaginationAndRateLimit(t *testing.T) {
+	I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { I_Acknowledge_This_API_Is_Unstable = false }()
+
+	var hits, limited int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		page := r.URL.Query().Get("page")
+		// Rate limit the first request for page 2.
+		if page == "2" && limited == 0 {
+			limited++
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		switch page {
+		case "":
+			w.Header().Set("Link", `</api/v2/tailnet/-/keys?page=2>; rel="next"`)
+			io.WriteString(w, `{"keys":[{"id":"k1"},{"id":"k2"}]}`)
+		case "2":
+			io.WriteString(w, `{"keys":[{"id":"k3"}]}`)
+		default:
+			t.Errorf("unexpected page %q", page)
+		}
+	}))
+	defer ts.Close()
+
+	c := NewClient("-", APIKey("key"))
+	c.BaseURL = ts.URL
+	keys, err := c.Keys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(keys, ","); got != "k1,k2,k3" {
+		t.Errorf("keys = %q; want k1,k2,k3", got)
+	}
+	if hits != 3 {
+		t.Errorf("hits = %d; want 3", hits)
+	}
+}
+
+func TestPaginationOtherOrigin(t *testing.T) {
+	I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { I_Acknowledge_This_API_Is_Unstable = false }()
+
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("request to other origin with Authorization %q", r.Header.Get("Authorization"))
+	}))
+	defer other.Close()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "<"+other.URL+`/api/v2/tailnet/-/keys?page=2>; rel="next"`)
+		io.WriteString(w, `{"keys":[{"id":"k1"}]}`)
+	}))
+	defer ts.Close()
+
+	c := NewClient("-", APIKey("key"))
+	c.BaseURL = ts.URL
+	if _, err := c.Keys(context.Background()); err == nil || !strings.Contains(err.Error(), "refusing") {
+		t.Fatalf("err = %v; want refusal", err)
+	}
+}
+
+func TestRateLimitGivesUp(t *testing.T) {
+	I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { I_Acknowledge_This_API_Is_Unstable = false }()
+
+	var hits int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		fmt.Fprintf(w, `{"message":"slow down"}`)
+	}))
+	defer ts.Close()
+
+	c := NewClient("-", APIKey("key"))
+	c.BaseURL = ts.URL
+	_, err := c.Keys(context.Background())
+	if er, ok := err.(ErrResponse); !ok || er.Status != http.StatusTooManyRequests {
+		t.Fatalf("err = %v; want 429 ErrResponse", err)
+	}
+	if want := maxRateLimitRetries + 1; hits != want {
+		t.Errorf("hits = %d; want %d", hits, want)
+	}
+}
+
+func TestBulk(t *testing.T) {
+	I_Acknowledge_This_API_Is_Unstable = true
+	defer func() { I_Acknowledge_This_API_Is_Unstable = false }()
+
+	var (
+		mu    sync.Mutex
+		posts []string
+	)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "GET" && strings.HasSuffix(r.URL.Path, "/routes"):
+			io.WriteString(w, `{"advertisedRoutes":["10.0.0.0/24","10.1.0.0/24"],"enabledRoutes":["10.0.0.0/24"]}`)
+			return
+		case strings.Contains(r.URL.Path, "/bad"):
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"not found"}`)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		posts = append(posts, r.Method+" "+r.URL.Path+" "+string(b))
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	c := NewClient("-", APIKey("key"))
+	c.BaseURL = ts.URL
+	ctx := context.Background()
+
+	res, err := c.BulkEnableRoutes(ctx, []string{"d1", "d2"}, &BulkOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("dry run made changes: %q", posts)
+	}
+	for _, r := range res {
+		if r.Action != "enable routes [10.1.0.0/24]" {
+			t.Errorf("%s: action = %q", r.DeviceID, r.Action)
+		}
+	}
+
+	res, err = c.BulkSetKeyExpiryDisabled(ctx, []string{"d1", "bad", "d2"}, true, &BulkOptions{Concurrency: 1})
+	if err == nil || res[1].Err == nil || res[0].Err != nil || res[2].Err != nil {
+		t.Fatalf("got %+v, %v; want only device bad to fail", res, err)
+	}
+	want := []string{
+		`POST /api/v2/device/d1/key {"keyExpiryDisabled":true}`,
+		`POST /api/v2/device/d2/key {"keyExpiryDisabled":true}`,
+	}
+	if got := strings.Join(posts, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", got, strings.Join(want, "\n"))
+	}
+
+	ids := DeviceIDs([]*Device{{DeviceID: "a", OS: "linux"}, {DeviceID: "b", OS: "windows"}}, func(d *Device) bool { return d.OS == "linux" })
+	if len(ids) != 1 || ids[0] != "a" {
+		t.Errorf("DeviceIDs = %q; want [a]", ids)
+	}
+}
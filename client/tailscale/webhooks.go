@@ -0,0 +1,200 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build go1.19
+
+package tailscale
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WebhookProviderType is the type of service that receives a webhook's
+// events, which determines how the payload is formatted.
+type WebhookProviderType string
+
+const (
+	WebhookEmptyProviderType      WebhookProviderType = "" // generic JSON payload
+	WebhookSlackProviderType      WebhookProviderType = "slack"
+	WebhookMattermostProviderType WebhookProviderType = "mattermost"
+	WebhookGoogleChatProviderType WebhookProviderType = "googlechat"
+	WebhookDiscordProviderType    WebhookProviderType = "discord"
+)
+
+// WebhookSubscriptionType is a category of tailnet event that a webhook
+// can subscribe to, such as "nodeCreated" or "policyUpdate".
+type WebhookSubscriptionType string
+
+// Webhook is a webhook endpoint configured for a tailnet.
+type Webhook struct {
+	EndpointID       string                    `json:"endpointId"`
+	EndpointURL      string                    `json:"endpointUrl"`
+	ProviderType     WebhookProviderType       `json:"providerType"`
+	CreatorLoginName string                    `json:"creatorLoginName"`
+	Created          time.Time                 `json:"created"`
+	LastModified     time.Time                 `json:"lastModified"`
+	Subscriptions    []WebhookSubscriptionType `json:"subscriptions"`
+
+	// Secret is the signing secret for the webhook's payloads. It is only
+	// populated in the responses of CreateWebhook and RotateWebhookSecret.
+	Secret *string `json:"secret,omitempty"`
+}
+
+// CreateWebhookRequest is the set of parameters used to create a webhook.
+type CreateWebhookRequest struct {
+	EndpointURL   string                    `json:"endpointUrl"`
+	ProviderType  WebhookProviderType       `json:"providerType"`
+	Subscriptions []WebhookSubscriptionType `json:"subscriptions"`
+}
+
+func (c *Client) webhookRequest(ctx context.Context, method, path string, body any) ([]byte, error) {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, path, reqBody)
+	if err != nil {
+		return nil, err
+	}
+	if reqBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	b, resp, err := c.sendRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, handleErrorResponse(b, resp)
+	}
+	return b, nil
+}
+
+func (c *Client) webhookPath(endpointID string, suffix ...string) string {
+	p := fmt.Sprintf("%s/api/v2/webhooks/%s", c.baseURL(), url.PathEscape(endpointID))
+	for _, s := range suffix {
+		p += "/" + s
+	}
+	return p
+}
+
+// Webhooks lists the webhooks configured for the tailnet.
+func (c *Client) Webhooks(ctx context.Context) (hooks []*Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Webhooks: %w", err)
+		}
+	}()
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/webhooks", c.baseURL(), c.tailnet)
+	err = c.getPages(ctx, path, func(b []byte) error {
+		var res struct {
+			Webhooks []*Webhook `json:"webhooks"`
+		}
+		if err := json.Unmarshal(b, &res); err != nil {
+			return err
+		}
+		hooks = append(hooks, res.Webhooks...)
+		return nil
+	})
+	return hooks, err
+}
+
+// CreateWebhook creates a webhook for the tailnet. The returned Webhook's
+// Secret is populated and cannot be retrieved again later.
+func (c *Client) CreateWebhook(ctx context.Context, params CreateWebhookRequest) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.CreateWebhook: %w", err)
+		}
+	}()
+	path := fmt.Sprintf("%s/api/v2/tailnet/%s/webhooks", c.baseURL(), c.tailnet)
+	b, err := c.webhookRequest(ctx, "POST", path, params)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &hook)
+	return hook, err
+}
+
+// Webhook retrieves the webhook with the given endpoint ID.
+func (c *Client) Webhook(ctx context.Context, endpointID string) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.Webhook: %w", err)
+		}
+	}()
+	b, err := c.webhookRequest(ctx, "GET", c.webhookPath(endpointID), nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &hook)
+	return hook, err
+}
+
+// SetWebhookSubscriptions replaces the set of events the webhook with the
+// given endpoint ID is subscribed to.
+func (c *Client) SetWebhookSubscriptions(ctx context.Context, endpointID string, subscriptions []WebhookSubscriptionType) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.SetWebhookSubscriptions: %w", err)
+		}
+	}()
+	params := struct {
+		Subscriptions []WebhookSubscriptionType `json:"subscriptions"`
+	}{subscriptions}
+	b, err := c.webhookRequest(ctx, "PATCH", c.webhookPath(endpointID), params)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &hook)
+	return hook, err
+}
+
+// DeleteWebhook deletes the webhook with the given endpoint ID.
+func (c *Client) DeleteWebhook(ctx context.Context, endpointID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.DeleteWebhook: %w", err)
+		}
+	}()
+	_, err = c.webhookRequest(ctx, "DELETE", c.webhookPath(endpointID), nil)
+	return err
+}
+
+// TestWebhook asks the control plane to send a test event to the webhook
+// with the given endpoint ID. Delivery happens asynchronously.
+func (c *Client) TestWebhook(ctx context.Context, endpointID string) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.TestWebhook: %w", err)
+		}
+	}()
+	_, err = c.webhookRequest(ctx, "POST", c.webhookPath(endpointID, "test"), nil)
+	return err
+}
+
+// RotateWebhookSecret generates a new signing secret for the webhook with
+// the given endpoint ID. The returned Webhook's Secret is populated.
+func (c *Client) RotateWebhookSecret(ctx context.Context, endpointID string) (hook *Webhook, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("tailscale.RotateWebhookSecret: %w", err)
+		}
+	}()
+	b, err := c.webhookRequest(ctx, "POST", c.webhookPath(endpointID, "rotate"), nil)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, &hook)
+	return hook, err
+}
@@ -0,0 +1,39 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckPost(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   map[string]string
+		wantCode int
+	}{
+		{"same-origin", map[string]string{"Origin": "http://100.64.0.1:5252", "Sec-Fetch-Site": "same-origin", "Content-Type": "application/json"}, 0},
+		{"no-origin", map[string]string{"Content-Type": "application/json; charset=utf-8"}, 0},
+		{"cross-site", map[string]string{"Sec-Fetch-Site": "cross-site", "Content-Type": "application/json"}, http.StatusForbidden},
+		{"same-site", map[string]string{"Sec-Fetch-Site": "same-site", "Content-Type": "application/json"}, http.StatusForbidden},
+		{"other-origin", map[string]string{"Origin": "http://evil.example", "Content-Type": "application/json"}, http.StatusForbidden},
+		{"form", map[string]string{"Origin": "http://100.64.0.1:5252", "Content-Type": "application/x-www-form-urlencoded"}, http.StatusUnsupportedMediaType},
+		{"text", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"no-type", nil, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "http://100.64.0.1:5252/", nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			code, err := checkPost(r)
+			if code != tt.wantCode || (err != nil) != (tt.wantCode != 0) {
+				t.Errorf("checkPost = %d, %v; want %d", code, err, tt.wantCode)
+			}
+		})
+	}
+}
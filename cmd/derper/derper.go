@@ -46,6 +46,8 @@
 	hostname   = flag.String("hostname", "derp.tailscale.com", "LetsEncrypt host name, if addr's port is :443")
 	runSTUN    = flag.Bool("stun", true, "whether to run a STUN server. It will bind to the same IP (if any) as the --addr flag value.")
 	runDERP    = flag.Bool("derp", true, "whether to run a DERP server. The only reason to set this false is if you're decommissioning a server but want to keep its bootstrap DNS functionality still running.")
+	stunAddrs  = flag.String("stun-addrs", "", "optional comma-separated list of UDP addresses to serve STUN on, in form \":port\", \"ip:port\", or for IPv6 \"[ip]:port\", instead of the -stun-port on the -a flag's IP. An IPv4 address (including 0.0.0.0) binds only IPv4 and an IPv6 address (including [::]) only IPv6; an omitted IP binds both.")
+	stunOnly   = flag.Bool("stun-only", false, "run only the STUN server, with no DERP, HTTP, or HTTPS listeners and no config file. Useful for adding STUN vantage points; several instances can run on one host with distinct -stun-addrs.")
 
 	meshPSKFile    = flag.String("mesh-psk-file", defaultMeshPSKFile(), "if non-empty, path to file containing the mesh pre-shared key file. It should contain some hex string; whitespace is trimmed.")
 	meshWith       = flag.String("mesh-with", "", "optional comma-separated list of hostnames to mesh with; the server's own hostname can be in the list")
@@ -144,6 +146,21 @@ func main() {
 	if err != nil {
 		log.Fatalf("invalid server address: %v", err)
 	}
+	stunListenAddrs, err := parseSTUNAddrs(listenHost, *stunPort, *stunAddrs)
+	if err != nil {
+		log.Fatalf("invalid -stun-addrs: %v", err)
+	}
+
+	if *stunOnly {
+		if !*runSTUN {
+			log.Fatalf("derper: -stun-only and -stun=false are mutually exclusive")
+		}
+		log.Printf("derper: running only STUN")
+		for _, a := range stunListenAddrs {
+			go serveSTUN(a)
+		}
+		select {}
+	}
 
 	cfg := loadConfig()
 
@@ -219,7 +236,9 @@ func main() {
 	debug.Handle("traffic", "Traffic check", http.HandlerFunc(s.ServeDebugTraffic))
 
 	if *runSTUN {
-		go serveSTUN(listenHost, *stunPort)
+		for _, a := range stunListenAddrs {
+			go serveSTUN(a)
+		}
 	}
 
 	quietLogger := log.New(logFilter{}, "", 0)
@@ -360,8 +379,61 @@ func probeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func serveSTUN(host string, port int) {
-	pc, err := net.ListenPacket("udp", net.JoinHostPort(host, fmt.Sprint(port)))
+// parseSTUNAddrs returns the addresses to serve STUN on: those in the
+// comma-separated list addrs if non-empty, otherwise port on host.
+func parseSTUNAddrs(host string, port int, addrs string) ([]string, error) {
+	if addrs == "" {
+		return []string{net.JoinHostPort(host, fmt.Sprint(port))}, nil
+	}
+	var ret []string
+	seen := map[string]bool{}
+	for _, a := range strings.Split(addrs, ",") {
+		a = strings.TrimSpace(a)
+		if a == "" {
+			continue
+		}
+		h, p, err := net.SplitHostPort(a)
+		if err != nil {
+			return nil, err
+		}
+		if h != "" {
+			if _, err := netip.ParseAddr(h); err != nil {
+				return nil, fmt.Errorf("%q: host must be an IP address", a)
+			}
+		}
+		if _, err := net.LookupPort("udp", p); err != nil {
+			return nil, fmt.Errorf("%q: %w", a, err)
+		}
+		if seen[a] {
+			return nil, fmt.Errorf("%q is listed twice", a)
+		}
+		seen[a] = true
+		ret = append(ret, a)
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("no addresses")
+	}
+	return ret, nil
+}
+
+// stunNetwork returns the network to listen on for the STUN address addr,
+// so that an IPv4 or IPv6 address (wildcard or not) binds just that address
+// family.
+func stunNetwork(addr string) string {
+	h, _, _ := net.SplitHostPort(addr)
+	ip, err := netip.ParseAddr(h)
+	switch {
+	case err != nil:
+		return "udp"
+	case ip.Unmap().Is4():
+		return "udp4"
+	default:
+		return "udp6"
+	}
+}
+
+func serveSTUN(addr string) {
+	pc, err := net.ListenPacket(stunNetwork(addr), addr)
 	if err != nil {
 		log.Fatalf("failed to open STUN listener: %v", err)
 	}
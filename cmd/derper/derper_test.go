@@ -8,6 +8,7 @@
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 
@@ -128,3 +129,45 @@ func TestNoContent(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSTUNAddrs(t *testing.T) {
+	tests := []struct {
+		host    string
+		addrs   string
+		want    []string
+		wantErr bool
+	}{
+		{host: "", want: []string{":3478"}},
+		{host: "10.0.0.1", want: []string{"10.0.0.1:3478"}},
+		{host: "::1", want: []string{"[::1]:3478"}},
+		{addrs: "0.0.0.0:3478, [::]:3478", want: []string{"0.0.0.0:3478", "[::]:3478"}},
+		{addrs: ":3478,:3479,", want: []string{":3478", ":3479"}},
+		{addrs: "10.0.0.1:3478,10.0.0.1:3478", wantErr: true},
+		{addrs: "10.0.0.1", wantErr: true},
+		{addrs: "example.com:3478", wantErr: true},
+		{addrs: "10.0.0.1:xyz", wantErr: true},
+		{addrs: ",", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSTUNAddrs(tt.host, 3478, tt.addrs)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSTUNAddrs(%q, %q) error = %v; want error: %v", tt.host, tt.addrs, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSTUNAddrs(%q, %q) = %q; want %q", tt.host, tt.addrs, got, tt.want)
+		}
+	}
+
+	for addr, want := range map[string]string{
+		":3478":        "udp",
+		"0.0.0.0:3478": "udp4",
+		"[::]:3478":    "udp6",
+		"[::1]:3478":   "udp6",
+		"1.2.3.4:3478": "udp4",
+	} {
+		if got := stunNetwork(addr); got != want {
+			t.Errorf("stunNetwork(%q) = %q; want %q", addr, got, want)
+		}
+	}
+}
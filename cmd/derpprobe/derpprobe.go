@@ -24,12 +24,18 @@
 	listen     = flag.String("listen", ":8030", "HTTP listen address")
 	probeOnce  = flag.Bool("once", false, "probe once and print results, then exit; ignores the listen flag")
 	interval   = flag.Duration("interval", 15*time.Second, "probe interval")
+
+	alertWebhook = flag.String("alert-webhook", "", "if non-empty, URL to POST a JSON prober.Alert to when a probe starts failing and when it recovers")
+	alertAfter   = flag.Int("alert-after", 3, "number of consecutive probe failures before alerting")
 )
 
 func main() {
 	flag.Parse()
 
 	p := prober.New().WithSpread(true).WithOnce(*probeOnce)
+	if *alertWebhook != "" && !*probeOnce {
+		p.WithAlerts(*alertAfter, prober.WebhookAlertFunc(*alertWebhook))
+	}
 	dp, err := prober.DERP(p, *derpMapURL, *interval, *interval, *interval)
 	if err != nil {
 		log.Fatal(err)
@@ -54,6 +60,9 @@ func main() {
 	tsweb.Debugger(mux)
 	expvar.Publish("derpprobe", p.Expvar())
 	mux.HandleFunc("/", http.HandlerFunc(serveFunc(p)))
+	// Unlike /debug/varz, which tsweb.Debugger restricts to Tailscale
+	// and loopback addresses, metrics are served to any scraper.
+	mux.HandleFunc("/metrics", tsweb.VarzHandler)
 	log.Fatal(http.ListenAndServe(*listen, mux))
 }
 
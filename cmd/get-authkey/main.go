@@ -4,6 +4,10 @@
 // get-authkey allocates an authkey using an OAuth API client
 // https://tailscale.com/kb/1215/oauth-clients/ and prints it
 // to stdout for scripts to capture and use.
+//
+// With --count, several keys are allocated and printed one per line,
+// so provisioning pipelines can hand a distinct short-lived key to each
+// machine.
 package main
 
 import (
@@ -14,8 +18,8 @@
 	"os"
 	"strings"
 
-	"golang.org/x/oauth2/clientcredentials"
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/authkey"
 )
 
 func main() {
@@ -27,6 +31,9 @@ func main() {
 	ephemeral := flag.Bool("ephemeral", false, "allocate an ephemeral authkey")
 	preauth := flag.Bool("preauth", true, "set the authkey as pre-authorized")
 	tags := flag.String("tags", "", "comma-separated list of tags to apply to the authkey")
+	expiry := flag.Duration("expiry", 0, "how long the authkey is valid for, such as 1h; if zero, the server default applies")
+	desc := flag.String("description", "", "optional description of the authkey, shown in the admin console")
+	count := flag.Int("count", 1, "number of authkeys to allocate, printed one per line")
 	flag.Parse()
 
 	clientId := os.Getenv("TS_API_CLIENT_ID")
@@ -38,39 +45,31 @@ func main() {
 	if *tags == "" {
 		log.Fatal("at least one tag must be specified")
 	}
-
-	baseUrl := os.Getenv("TS_BASE_URL")
-	if baseUrl == "" {
-		baseUrl = "https://api.tailscale.com"
+	if *count < 1 {
+		log.Fatal("--count must be at least 1")
 	}
 
-	credentials := clientcredentials.Config{
-		ClientID:     clientId,
-		ClientSecret: clientSecret,
-		TokenURL:     baseUrl + "/api/v2/oauth/token",
-		Scopes:       []string{"device"},
+	m, err := authkey.New(authkey.Options{
+		ClientID:      clientId,
+		ClientSecret:  clientSecret,
+		BaseURL:       os.Getenv("TS_BASE_URL"),
+		Tags:          strings.Split(*tags, ","),
+		Reusable:      *reusable,
+		Ephemeral:     *ephemeral,
+		Preauthorized: *preauth,
+		Expiry:        *expiry,
+		Description:   *desc,
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	ctx := context.Background()
-	tsClient := tailscale.NewClient("-", nil)
-	tsClient.HTTPClient = credentials.Client(ctx)
-	tsClient.BaseURL = baseUrl
-
-	caps := tailscale.KeyCapabilities{
-		Devices: tailscale.KeyDeviceCapabilities{
-			Create: tailscale.KeyDeviceCreateCapabilities{
-				Reusable:      *reusable,
-				Ephemeral:     *ephemeral,
-				Preauthorized: *preauth,
-				Tags:          strings.Split(*tags, ","),
-			},
-		},
+	for i := 0; i < *count; i++ {
+		key, _, err := m.Mint(ctx)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		fmt.Println(key)
 	}
-
-	authkey, _, err := tsClient.CreateKey(ctx, caps)
-	if err != nil {
-		log.Fatal(err.Error())
-	}
-
-	fmt.Println(authkey)
 }
@@ -0,0 +1,274 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// Config is the idproxy configuration file, in HuJSON.
+type Config struct {
+	// Hostname is the tailnet hostname to serve on.
+	Hostname string
+
+	// StateDir optionally specifies the directory of the tsnet state.
+	StateDir string `json:",omitempty"`
+
+	// Backend is the http or https URL of the upstream server.
+	Backend string
+
+	// HTTPS is whether to serve over HTTPS with the node's *.ts.net
+	// certificate, rather than over HTTP.
+	HTTPS bool `json:",omitempty"`
+
+	// Paths optionally limits the paths, or path prefixes if ending in
+	// "/", of the requests the identity headers are added to, such as
+	// only "/login" for backends that then keep their own session.
+	// Identities are checked for all requests regardless.
+	Paths []string `json:",omitempty"`
+
+	// Headers maps the names of the headers to set on requests to the
+	// backend to text/template templates of their values, executed with
+	// the identity of the requester. See the idproxy doc for the fields.
+	// Headers of the same names sent by clients are always removed.
+	Headers map[string]string `json:",omitempty"`
+
+	// JWT, if set, adds a signed JWT asserting the requester's identity to
+	// requests to the backend.
+	JWT *JWTConfig `json:",omitempty"`
+
+	// Rules map identities to whether they're allowed, and to the
+	// variables available to the templates. The first rule matching the
+	// requester applies; requesters no rule matches are denied. If there
+	// are no rules, all users are allowed, and tagged nodes denied.
+	Rules []Rule `json:",omitempty"`
+}
+
+// JWTConfig configures the JWT added to requests to the backend.
+type JWTConfig struct {
+	// Header is the name of the header to send the JWT in, such as
+	// "Authorization" or "X-Auth-Token".
+	Header string
+
+	// Prefix is prepended to the JWT in the header, such as "Bearer ".
+	Prefix string `json:",omitempty"`
+
+	// Alg is the signing algorithm: "HS256" (the default) signs with the
+	// secret in KeyFile, and "RS256" with the PEM-encoded RSA private key
+	// in KeyFile.
+	Alg string `json:",omitempty"`
+
+	// KeyFile is the path of the signing secret or key.
+	KeyFile string
+
+	// Issuer and Audience, if non-empty, are the "iss" and "aud" claims.
+	Issuer   string `json:",omitempty"`
+	Audience string `json:",omitempty"`
+
+	// Lifetime is how long the JWT is valid, such as "5m", the default.
+	Lifetime string `json:",omitempty"`
+
+	// Claims are extra claims, as templates like Config.Headers.
+	Claims map[string]string `json:",omitempty"`
+}
+
+// Rule maps identities to whether they may use the backend.
+type Rule struct {
+	// Match are the identities the rule applies to: "*" for all users,
+	// "*@example.com" for the users of a domain, "alice@example.com" for
+	// a user, or "tag:ci" for the nodes with a tag.
+	Match []string
+
+	// Deny is whether the matching identities are denied.
+	Deny bool `json:",omitempty"`
+
+	// Vars are variables available to the templates as .Vars.Name.
+	Vars map[string]string `json:",omitempty"`
+}
+
+// identity is the data the header and claim templates are executed with.
+type identity struct {
+	LoginName     string            // user login name, such as "alice@example.com"
+	DisplayName   string            // user display name
+	ProfilePicURL string            // user profile picture URL, if any
+	Node          string            // node MagicDNS name, without trailing dot
+	Tags          []string          // tags of the node, if tagged
+	IP            string            // Tailscale IP the request came from
+	Vars          map[string]string // Rule.Vars of the matching rule
+}
+
+// loadConfig reads and validates the HuJSON config file at path.
+func loadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c := new(Config)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+func (c *Config) validate() error {
+	if c.Hostname == "" || strings.Contains(c.Hostname, ".") {
+		return errors.New("missing or invalid Hostname")
+	}
+	u, err := url.Parse(c.Backend)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid Backend %q; want an http or https URL", c.Backend)
+	}
+	for _, r := range c.Rules {
+		if len(r.Match) == 0 {
+			return errors.New("rule with no Match")
+		}
+	}
+	if j := c.JWT; j != nil {
+		if j.Header == "" || j.KeyFile == "" {
+			return errors.New("JWT requires Header and KeyFile")
+		}
+		if j.Alg != "" && j.Alg != "HS256" && j.Alg != "RS256" {
+			return fmt.Errorf("unsupported JWT Alg %q; want HS256 or RS256", j.Alg)
+		}
+		if j.Lifetime != "" {
+			if d, err := time.ParseDuration(j.Lifetime); err != nil || d <= 0 {
+				return fmt.Errorf("invalid JWT Lifetime %q", j.Lifetime)
+			}
+		}
+	}
+	return nil
+}
+
+// matches reports whether the identity who matches the Rule.Match
+// pattern pat.
+func matches(pat string, who *apitype.WhoIsResponse) bool {
+	if tag, ok := strings.CutPrefix(pat, "tag:"); ok {
+		for _, t := range who.Node.Tags {
+			if t == "tag:"+tag {
+				return true
+			}
+		}
+		return false
+	}
+	if len(who.Node.Tags) > 0 || who.UserProfile == nil {
+		return false // tagged nodes only match tags
+	}
+	login := who.UserProfile.LoginName
+	switch {
+	case pat == "*":
+		return true
+	case strings.HasPrefix(pat, "*@"):
+		return strings.HasSuffix(login, pat[1:])
+	}
+	return login == pat
+}
+
+// ruleFor returns the rule applying to who, and whether who is allowed.
+func (c *Config) ruleFor(who *apitype.WhoIsResponse) (_ *Rule, ok bool) {
+	if len(c.Rules) == 0 {
+		return &Rule{}, len(who.Node.Tags) == 0 && who.UserProfile != nil
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		for _, pat := range r.Match {
+			if matches(pat, who) {
+				return r, !r.Deny
+			}
+		}
+	}
+	return nil, false
+}
+
+// wantsHeaders reports whether the identity headers are added to
+// requests to urlPath.
+func (c *Config) wantsHeaders(urlPath string) bool {
+	if len(c.Paths) == 0 {
+		return true
+	}
+	for _, p := range c.Paths {
+		if urlPath == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(urlPath, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTemplates parses the templates of m.
+func parseTemplates(m map[string]string) (map[string]*template.Template, error) {
+	ret := map[string]*template.Template{}
+	for k, v := range m {
+		t, err := template.New(k).Funcs(template.FuncMap{"join": strings.Join}).Option("missingkey=zero").Parse(v)
+		if err != nil {
+			return nil, fmt.Errorf("template for %q: %w", k, err)
+		}
+		ret[k] = t
+	}
+	return ret, nil
+}
+
+// loadSigningKey returns the key of JWT signing algorithm alg in keyFile:
+// a []byte secret for HS256, or an *rsa.PrivateKey for RS256.
+func loadSigningKey(alg, keyFile string) (any, error) {
+	b, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if alg != "RS256" {
+		secret := []byte(strings.TrimSpace(string(b)))
+		if len(secret) < 32 {
+			return nil, fmt.Errorf("%s: HS256 secret must be at least 32 bytes", keyFile)
+		}
+		return secret, nil
+	}
+	blk, _ := pem.Decode(b)
+	if blk == nil {
+		return nil, fmt.Errorf("%s: no PEM key", keyFile)
+	}
+	if k, err := x509.ParsePKCS1PrivateKey(blk.Bytes); err == nil {
+		return k, nil
+	}
+	k, err := x509.ParsePKCS8PrivateKey(blk.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", keyFile, err)
+	}
+	rk, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an RSA key", keyFile)
+	}
+	return rk, nil
+}
+
+// headerNames returns the names of the headers c sets on requests to the
+// backend, which are removed from client requests.
+func (c *Config) headerNames() []string {
+	var ret []string
+	for k := range c.Headers {
+		ret = append(ret, http.CanonicalHeaderKey(k))
+	}
+	if c.JWT != nil {
+		ret = append(ret, http.CanonicalHeaderKey(c.JWT.Header))
+	}
+	return ret
+}
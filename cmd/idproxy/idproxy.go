@@ -0,0 +1,295 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The idproxy command is a reverse proxy which identifies requesters by
+// their originating Tailscale identity and passes that identity to an
+// upstream server in request headers and optionally a signed JWT, per a
+// config file. It generalizes proxy-to-grafana to any backend with an
+// auth proxy or JWT login mode.
+//
+// Set the TS_AUTHKEY environment variable to have this server automatically
+// join your tailnet, or look for the logged auth link on first start.
+//
+// The config file is HuJSON. For example, for Grafana's auth proxy:
+//
+//	{
+//		"Hostname": "grafana",
+//		"Backend":  "http://localhost:3000",
+//		"HTTPS":    true,
+//		"Paths":    ["/login"],
+//		"Headers": {
+//			"X-Webauth-User": "{{.LoginName}}",
+//			"X-Webauth-Name": "{{.DisplayName}}",
+//			"X-Webauth-Role": "{{.Vars.Role}}",
+//		},
+//		"Rules": [
+//			{"Match": ["alice@example.com"], "Vars": {"Role": "Admin"}},
+//			{"Match": ["*@example.com"], "Vars": {"Role": "Viewer"}},
+//		],
+//	}
+//
+// Header values and JWT claims are text/template templates executed with
+// the requester's identity: .LoginName, .DisplayName, .ProfilePicURL,
+// .Node (its MagicDNS name), .Tags, .IP, and the .Vars of the rule that
+// matched. The "join" func joins strings, as in {{join .Tags ","}}.
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tsnet"
+	"tailscale.com/types/logger"
+)
+
+var (
+	flagConfig  = flag.String("config", "", "path of the HuJSON config file")
+	flagVerbose = flag.Bool("verbose", false, "be verbose")
+)
+
+func main() {
+	flag.Parse()
+	if *flagConfig == "" {
+		log.Fatal("missing --config")
+	}
+	cfg, err := loadConfig(*flagConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts := &tsnet.Server{
+		Dir:      cfg.StateDir,
+		Hostname: cfg.Hostname,
+	}
+	if !*flagVerbose {
+		ts.Logf = logger.Discard
+	}
+	if _, err := ts.Up(context.Background()); err != nil {
+		log.Fatalf("Error starting tsnet.Server: %v", err)
+	}
+	lc, err := ts.LocalClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := newIDProxy(cfg, lc.WhoIs)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var ln net.Listener
+	if cfg.HTTPS {
+		ln, err = ts.Listen("tcp", ":443")
+		if err != nil {
+			log.Fatal(err)
+		}
+		ln = tls.NewListener(ln, &tls.Config{GetCertificate: lc.GetCertificate})
+		if _, err := ts.ServeHTTPSRedirect(); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		ln, err = ts.Listen("tcp", ":80")
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	log.Printf("idproxy running at %v, proxying to %v", ln.Addr(), cfg.Backend)
+	log.Fatal(http.Serve(ln, p))
+}
+
+// whoIsFunc looks up the tailnet identity of a remote ip:port.
+type whoIsFunc func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+// idProxy is the reverse proxy to the Config.Backend.
+type idProxy struct {
+	cfg     *Config
+	whoIs   whoIsFunc
+	rp      *httputil.ReverseProxy
+	headers map[string]*template.Template
+	claims  map[string]*template.Template
+	strip   []string // header names removed from client requests
+
+	jwtKey      any // []byte for HS256, *rsa.PrivateKey for RS256; nil if no JWT
+	jwtLifetime time.Duration
+
+	now func() time.Time // for tests
+}
+
+func newIDProxy(cfg *Config, whoIs whoIsFunc) (*idProxy, error) {
+	backend, err := url.Parse(cfg.Backend)
+	if err != nil {
+		return nil, err
+	}
+	p := &idProxy{
+		cfg:   cfg,
+		whoIs: whoIs,
+		rp:    httputil.NewSingleHostReverseProxy(backend),
+		strip: cfg.headerNames(),
+		now:   time.Now,
+	}
+	if p.headers, err = parseTemplates(cfg.Headers); err != nil {
+		return nil, err
+	}
+	if j := cfg.JWT; j != nil {
+		if p.claims, err = parseTemplates(j.Claims); err != nil {
+			return nil, err
+		}
+		if p.jwtKey, err = loadSigningKey(j.Alg, j.KeyFile); err != nil {
+			return nil, err
+		}
+		p.jwtLifetime = 5 * time.Minute
+		if j.Lifetime != "" {
+			p.jwtLifetime, _ = time.ParseDuration(j.Lifetime) // validated by loadConfig
+		}
+	}
+	return p, nil
+}
+
+func (p *idProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	who, err := p.whoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		log.Printf("WhoIs(%v): %v", r.RemoteAddr, err)
+		http.Error(w, "failed to identify remote host", http.StatusForbidden)
+		return
+	}
+	rule, ok := p.cfg.ruleFor(who)
+	if !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	for _, h := range p.strip {
+		r2.Header.Del(h)
+	}
+	if p.cfg.wantsHeaders(r.URL.Path) {
+		if err := p.setIdentityHeaders(r2.Header, newIdentity(who, r.RemoteAddr, rule)); err != nil {
+			log.Printf("setting identity headers for %v: %v", r.RemoteAddr, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+	}
+	p.rp.ServeHTTP(w, r2)
+}
+
+func newIdentity(who *apitype.WhoIsResponse, remoteAddr string, rule *Rule) *identity {
+	id := &identity{
+		Node: strings.TrimSuffix(who.Node.Name, "."),
+		Tags: who.Node.Tags,
+		Vars: rule.Vars,
+	}
+	if up := who.UserProfile; up != nil {
+		id.LoginName = up.LoginName
+		id.DisplayName = up.DisplayName
+		id.ProfilePicURL = up.ProfilePicURL
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		id.IP = host
+	}
+	return id
+}
+
+// setIdentityHeaders sets the configured headers and JWT for id on h.
+func (p *idProxy) setIdentityHeaders(h http.Header, id *identity) error {
+	for k, t := range p.headers {
+		v, err := execTemplate(t, id)
+		if err != nil {
+			return err
+		}
+		h.Set(k, v)
+	}
+	if j := p.cfg.JWT; j != nil {
+		tok, err := p.signJWT(id)
+		if err != nil {
+			return err
+		}
+		h.Set(j.Header, j.Prefix+tok)
+	}
+	return nil
+}
+
+func execTemplate(t *template.Template, id *identity) (string, error) {
+	var sb strings.Builder
+	if err := t.Execute(&sb, id); err != nil {
+		return "", err
+	}
+	// Values can't have newlines in headers.
+	return strings.NewReplacer("\r", "", "\n", "").Replace(sb.String()), nil
+}
+
+// signJWT returns a JWT asserting id, with the configured claims.
+func (p *idProxy) signJWT(id *identity) (string, error) {
+	j := p.cfg.JWT
+	now := p.now()
+	claims := map[string]any{}
+	for k, t := range p.claims {
+		v, err := execTemplate(t, id)
+		if err != nil {
+			return "", err
+		}
+		claims[k] = v
+	}
+	claims["sub"] = id.LoginName
+	if len(id.Tags) > 0 {
+		claims["sub"] = id.Node
+		claims["tags"] = id.Tags
+	}
+	if id.DisplayName != "" {
+		claims["name"] = id.DisplayName
+	}
+	if j.Issuer != "" {
+		claims["iss"] = j.Issuer
+	}
+	if j.Audience != "" {
+		claims["aud"] = j.Audience
+	}
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(p.jwtLifetime).Unix()
+
+	alg := "HS256"
+	if _, ok := p.jwtKey.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+	hdr, err := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64(hdr) + "." + b64(body)
+	var sig []byte
+	switch k := p.jwtKey.(type) {
+	case []byte:
+		m := hmac.New(sha256.New, k)
+		m.Write([]byte(signingInput))
+		sig = m.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signingInput))
+		if sig, err = rsa.SignPKCS1v15(crand.Reader, k, crypto.SHA256, h[:]); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unexpected JWT key type %T", k)
+	}
+	return signingInput + "." + b64(sig), nil
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
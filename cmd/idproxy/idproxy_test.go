@@ -0,0 +1,204 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestIDProxy(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, h := range []string{"X-Webauth-User", "X-Webauth-Role", "X-Node", "X-Token"} {
+			fmt.Fprintf(w, "%s=%s\n", h, r.Header.Get(h))
+		}
+	}))
+	defer backend.Close()
+
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "secret")
+	if err := os.WriteFile(keyFile, []byte(testSecret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfgFile := filepath.Join(dir, "idproxy.hujson")
+	if err := os.WriteFile(cfgFile, []byte(`{
+		"Hostname": "app",
+		"Backend":  "`+backend.URL+`",
+		"Paths":    ["/login", "/api/"],
+		"Headers": {
+			"X-Webauth-User": "{{.LoginName}}",
+			"X-Webauth-Role": "{{.Vars.Role}}",
+			"X-Node":         "{{.Node}}/{{join .Tags \",\"}}",
+		},
+		"JWT": {
+			"Header":  "X-Token",
+			"KeyFile": "`+keyFile+`",
+			"Issuer":  "idproxy",
+			"Claims":  {"role": "{{.Vars.Role}}"},
+		},
+		"Rules": [
+			{"Match": ["mallory@example.com"], "Deny": true},
+			{"Match": ["alice@example.com"], "Vars": {"Role": "Admin"}},
+			{"Match": ["*@example.com", "tag:ci"], "Vars": {"Role": "Viewer"}},
+		],
+	}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	whos := map[string]*apitype.WhoIsResponse{
+		"100.64.0.1": userWhoIs("alice@example.com"),
+		"100.64.0.2": userWhoIs("bob@example.com"),
+		"100.64.0.3": userWhoIs("mallory@example.com"),
+		"100.64.0.4": userWhoIs("eve@other.example"),
+		"100.64.0.5": {
+			Node:        &tailcfg.Node{Name: "runner.example.ts.net.", Tags: []string{"tag:ci"}},
+			UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+		},
+		"100.64.0.6": {
+			Node:        &tailcfg.Node{Name: "web.example.ts.net.", Tags: []string{"tag:web"}},
+			UserProfile: &tailcfg.UserProfile{LoginName: "tagged-devices"},
+		},
+	}
+	now := time.Unix(1700000000, 0)
+	p, err := newIDProxy(cfg, func(ctx context.Context, addr string) (*apitype.WhoIsResponse, error) {
+		if who, ok := whos[strings.TrimSuffix(addr, ":1234")]; ok {
+			return who, nil
+		}
+		return nil, errors.New("not found")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.now = func() time.Time { return now }
+
+	get := func(ip, path string) (int, map[string]string) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		req.Header.Set("X-Webauth-User", "spoofed")
+		rec := httptest.NewRecorder()
+		p.ServeHTTP(rec, req)
+		got := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(rec.Body.String()), "\n") {
+			k, v, _ := strings.Cut(line, "=")
+			got[k] = v
+		}
+		return rec.Code, got
+	}
+
+	tests := []struct {
+		ip, path string
+		wantCode int
+		wantUser string
+		wantRole string
+		wantNode string
+	}{
+		{ip: "100.64.0.1", path: "/login", wantCode: 200, wantUser: "alice@example.com", wantRole: "Admin", wantNode: "laptop.example.ts.net/"},
+		{ip: "100.64.0.2", path: "/api/x", wantCode: 200, wantUser: "bob@example.com", wantRole: "Viewer", wantNode: "laptop.example.ts.net/"},
+		{ip: "100.64.0.2", path: "/other", wantCode: 200}, // no headers, but spoofed one removed
+		{ip: "100.64.0.3", path: "/login", wantCode: 403},
+		{ip: "100.64.0.4", path: "/login", wantCode: 403},
+		{ip: "100.64.0.5", path: "/login", wantCode: 200, wantUser: "tagged-devices", wantRole: "Viewer", wantNode: "runner.example.ts.net/tag:ci"},
+		{ip: "100.64.0.6", path: "/login", wantCode: 403},
+		{ip: "100.64.0.9", path: "/login", wantCode: 403},
+	}
+	for _, tt := range tests {
+		code, got := get(tt.ip, tt.path)
+		if code != tt.wantCode {
+			t.Errorf("%s %s: code %d; want %d", tt.ip, tt.path, code, tt.wantCode)
+			continue
+		}
+		if code != 200 {
+			continue
+		}
+		if got["X-Webauth-User"] != tt.wantUser || got["X-Webauth-Role"] != tt.wantRole || got["X-Node"] != tt.wantNode {
+			t.Errorf("%s %s: headers %q; want user %q, role %q, node %q", tt.ip, tt.path, got, tt.wantUser, tt.wantRole, tt.wantNode)
+		}
+		tok := got["X-Token"]
+		if tt.wantUser == "" {
+			if tok != "" {
+				t.Errorf("%s %s: unexpected JWT", tt.ip, tt.path)
+			}
+			continue
+		}
+		var claims map[string]any
+		if err := verifyHS256(tok, []byte(testSecret), &claims); err != nil {
+			t.Errorf("%s %s: JWT %q: %v", tt.ip, tt.path, tok, err)
+			continue
+		}
+		if claims["role"] != tt.wantRole || claims["iss"] != "idproxy" || claims["exp"] != float64(now.Add(5*time.Minute).Unix()) {
+			t.Errorf("%s %s: JWT claims %v", tt.ip, tt.path, claims)
+		}
+	}
+}
+
+func TestConfigDefaultRules(t *testing.T) {
+	c := &Config{Hostname: "app", Backend: "http://localhost:3000"}
+	if err := c.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.ruleFor(userWhoIs("alice@example.com")); !ok {
+		t.Error("user denied with no rules")
+	}
+	if _, ok := c.ruleFor(&apitype.WhoIsResponse{Node: &tailcfg.Node{Tags: []string{"tag:ci"}}}); ok {
+		t.Error("tagged node allowed with no rules")
+	}
+	for _, bad := range []*Config{
+		{Hostname: "app.example", Backend: "http://localhost:3000"},
+		{Hostname: "app", Backend: "localhost:3000"},
+		{Hostname: "app", Backend: "http://localhost:3000", Rules: []Rule{{}}},
+		{Hostname: "app", Backend: "http://localhost:3000", JWT: &JWTConfig{Header: "X-Token"}},
+		{Hostname: "app", Backend: "http://localhost:3000", JWT: &JWTConfig{Header: "X-Token", KeyFile: "k", Alg: "none"}},
+	} {
+		if err := bad.validate(); err == nil {
+			t.Errorf("validate(%+v) = nil; want error", bad)
+		}
+	}
+}
+
+func userWhoIs(login string) *apitype.WhoIsResponse {
+	return &apitype.WhoIsResponse{
+		Node:        &tailcfg.Node{Name: "laptop.example.ts.net."},
+		UserProfile: &tailcfg.UserProfile{LoginName: login, DisplayName: login},
+	}
+}
+
+// verifyHS256 checks the HS256 signature of a compact JWS with secret and
+// decodes its claims into v.
+func verifyHS256(tok string, secret []byte, v any) error {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+	m := hmac.New(sha256.New, secret)
+	m.Write([]byte(parts[0] + "." + parts[1]))
+	if got := base64.RawURLEncoding.EncodeToString(m.Sum(nil)); got != parts[2] {
+		return errors.New("bad signature")
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
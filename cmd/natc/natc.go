@@ -0,0 +1,460 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The natc command is a NAT-to-tailnet connector: a userspace app connector
+// that lets tailnet clients reach a set of domains on the internet through
+// it, on any platform, with no kernel networking or root.
+//
+// It's a DNS server for the domains given with --domains, meant to be set
+// as their split DNS nameserver in the tailnet's DNS settings. It answers
+// each name with an IP of its own from --v4-pfx, which it advertises as a
+// subnet route, and forwards the TCP connections it then receives for that
+// IP, on any of --ports, to the same port of the domain on the internet.
+//
+// TLS connections to natc's own Tailscale IPs are also forwarded, to the
+// SNI hostname of the connection, if that is one of the domains.
+//
+// Domains matching --deny-domains are refused even if they match
+// --domains, so a wildcard can exclude some of its subdomains. With
+// --ip-ttl, the IP of a domain that's neither looked up nor connected to
+// for that long is unassigned, to be reused for another domain, so that
+// the domains clients have ever looked up don't exhaust --v4-pfx. The
+// table of assigned IPs is served as JSON at /debug/ips on --debug-port.
+//
+// natc only ever advertises --v4-pfx, never routes learned from DNS
+// answers, so there's no learned route table to cap by prefix length;
+// its table lives in the natc process rather than tailscaled, so it's
+// served by natc rather than the LocalAPI.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+	"inet.af/tcpproxy"
+	"tailscale.com/ipn"
+	"tailscale.com/net/netutil"
+	"tailscale.com/tsnet"
+	"tailscale.com/tsweb"
+	"tailscale.com/types/nettype"
+)
+
+var (
+	domains     = flag.String("domains", "", "comma-separated list of domains to connect to; a \"*.\" prefix matches all subdomains")
+	denyDomains = flag.String("deny-domains", "", "comma-separated list of domains to refuse even if they match --domains; a \"*.\" prefix matches all subdomains")
+	ports       = flag.String("ports", "80,443", "comma-separated list of TCP ports to forward")
+	v4Prefix    = flag.String("v4-pfx", "198.18.0.0/24", "IPv4 prefix to assign IPs to the domains from, advertised as a subnet route")
+	ipTTL       = flag.Duration("ip-ttl", 0, "if non-zero, how long a domain keeps its IP after it was last looked up or connected to; at least the DNS TTL of 2m")
+	hostname    = flag.String("hostname", "natc", "hostname of the node on the tailnet")
+	debugPort   = flag.Int("debug-port", 0, "if non-zero, tailnet port to serve the debug endpoints, including /debug/ips, on")
+)
+
+// dnsTTL is the TTL of the DNS records natc answers with.
+const dnsTTL = 2 * time.Minute
+
+func main() {
+	flag.Parse()
+	if *domains == "" {
+		log.Fatal("no domains")
+	}
+	if *ports == "" {
+		log.Fatal("no ports")
+	}
+	pfx, err := netip.ParsePrefix(*v4Prefix)
+	if err != nil {
+		log.Fatalf("invalid --v4-pfx: %v", err)
+	}
+	if !pfx.Addr().Is4() || pfx.Bits() > 30 {
+		log.Fatalf("invalid --v4-pfx %v: must be an IPv4 prefix of at most /30", pfx)
+	}
+	pfx = pfx.Masked()
+	if *ipTTL != 0 && *ipTTL < dnsTTL {
+		log.Fatalf("invalid --ip-ttl %v: must be at least the DNS TTL of %v", *ipTTL, dnsTTL)
+	}
+
+	s := &server{
+		domains: strings.Split(*domains, ","),
+		pool:    newIPPool(pfx),
+	}
+	if *denyDomains != "" {
+		s.denyDomains = strings.Split(*denyDomains, ",")
+	}
+	s.pool.ttl = *ipTTL
+	s.ts.Hostname = *hostname
+	s.ts.ProcessSubnets = true
+	defer s.ts.Close()
+
+	ctx := context.Background()
+	if _, err := s.ts.Up(ctx); err != nil {
+		log.Fatal(err)
+	}
+	lc, err := s.ts.LocalClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := lc.EditPrefs(ctx, &ipn.MaskedPrefs{
+		Prefs:              ipn.Prefs{AdvertiseRoutes: []netip.Prefix{pfx}},
+		AdvertiseRoutesSet: true,
+	}); err != nil {
+		log.Fatalf("advertising %v: %v", pfx, err)
+	}
+	log.Printf("Advertising %v; approve it as a subnet route in the admin console if needed", pfx)
+
+	for _, portStr := range strings.Split(*ports, ",") {
+		ln, err := s.ts.Listen("tcp", ":"+portStr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Serving on port %v ...", portStr)
+		go s.serve(ln)
+	}
+
+	ln, err := s.ts.Listen("udp", ":53")
+	if err != nil {
+		log.Fatal(err)
+	}
+	go s.serveDNS(ln)
+
+	if *debugPort != 0 {
+		mux := http.NewServeMux()
+		debug := tsweb.Debugger(mux)
+		debug.Handle("ips", "Assigned IPs (JSON)", http.HandlerFunc(s.serveIPs))
+		dln, err := s.ts.Listen("tcp", fmt.Sprintf(":%d", *debugPort))
+		if err != nil {
+			log.Fatal(err)
+		}
+		go func() {
+			log.Fatal(http.Serve(dln, mux))
+		}()
+	}
+
+	if s.pool.ttl > 0 {
+		go func() {
+			for range time.Tick(s.pool.ttl / 2) {
+				s.pool.expire()
+			}
+		}()
+	}
+
+	select {}
+}
+
+type server struct {
+	ts          tsnet.Server
+	domains     []string
+	denyDomains []string // take precedence over domains
+	pool        *ipPool
+}
+
+// allowed reports whether name, a hostname without a trailing dot, is one
+// of s.domains and not one of s.denyDomains.
+func (s *server) allowed(name string) bool {
+	return matchDomain(s.domains, name) && !matchDomain(s.denyDomains, name)
+}
+
+// matchDomain reports whether name, a hostname without a trailing dot,
+// matches any of domains, each either a hostname or "*." and a domain of
+// which name is a subdomain.
+func matchDomain(domains []string, name string) bool {
+	name = strings.ToLower(name)
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(d), "."))
+		if suffix, ok := strings.CutPrefix(d, "*"); ok {
+			if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+				return true
+			}
+		} else if name == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) serve(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go s.serveConn(c)
+	}
+}
+
+func (s *server) serveConn(c net.Conn) {
+	addrPortStr := c.LocalAddr().String()
+	dst, err := netip.ParseAddrPort(addrPortStr)
+	if err != nil {
+		log.Printf("bogus addrPort %q", addrPortStr)
+		c.Close()
+		return
+	}
+	port := fmt.Sprint(dst.Port())
+
+	var dialer net.Dialer
+	dialer.Timeout = 5 * time.Second
+
+	var p tcpproxy.Proxy
+	p.ListenFunc = func(net, laddr string) (net.Listener, error) {
+		return netutil.NewOneConnListener(c, nil), nil
+	}
+	if s.pool.contains(dst.Addr()) {
+		domain, ok := s.pool.domainOf(dst.Addr())
+		if !ok {
+			log.Printf("connection from %v to unassigned %v", c.RemoteAddr(), dst.Addr())
+			c.Close()
+			return
+		}
+		p.AddRoute(addrPortStr, &tcpproxy.DialProxy{
+			Addr:        net.JoinHostPort(domain, port),
+			DialContext: dialer.DialContext,
+		})
+	} else {
+		p.AddSNIRouteFunc(addrPortStr, func(ctx context.Context, sniName string) (t tcpproxy.Target, ok bool) {
+			if !s.allowed(sniName) {
+				log.Printf("connection from %v to disallowed SNI %q", c.RemoteAddr(), sniName)
+				return nil, false
+			}
+			return &tcpproxy.DialProxy{
+				Addr:        net.JoinHostPort(sniName, port),
+				DialContext: dialer.DialContext,
+			}, true
+		})
+	}
+	p.Start()
+}
+
+func (s *server) serveDNS(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go s.serveDNSConn(c.(nettype.ConnPacketConn))
+	}
+}
+
+func (s *server) serveDNSConn(c nettype.ConnPacketConn) {
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := c.Read(buf)
+	if err != nil {
+		log.Printf("c.Read failed: %v\n ", err)
+		return
+	}
+
+	var msg dnsmessage.Message
+	err = msg.Unpack(buf[:n])
+	if err != nil {
+		log.Printf("dnsmessage unpack failed: %v\n ", err)
+		return
+	}
+
+	buf, err = s.dnsResponse(&msg)
+	if err != nil {
+		log.Printf("s.dnsResponse failed: %v\n", err)
+		return
+	}
+
+	_, err = c.Write(buf)
+	if err != nil {
+		log.Printf("c.Write failed: %v\n", err)
+		return
+	}
+}
+
+// dnsResponse returns the response to req: an IP from s.pool for A
+// queries for the allowed domains, no records for their other queries,
+// and a refusal for other domains.
+func (s *server) dnsResponse(req *dnsmessage.Message) (buf []byte, err error) {
+	hdr := dnsmessage.Header{
+		ID:            req.Header.ID,
+		Response:      true,
+		Authoritative: true,
+	}
+	if len(req.Questions) == 0 {
+		resp := dnsmessage.NewBuilder(buf, hdr)
+		return resp.Finish()
+	}
+	q := req.Questions[0]
+	name := strings.TrimSuffix(q.Name.String(), ".")
+
+	var ip netip.Addr
+	switch {
+	case !s.allowed(name):
+		hdr.RCode = dnsmessage.RCodeRefused
+	case q.Type == dnsmessage.TypeA:
+		ip, err = s.pool.ipFor(strings.ToLower(name))
+		if err != nil {
+			log.Printf("assigning an IP to %q: %v", name, err)
+			hdr.RCode = dnsmessage.RCodeServerFailure
+		}
+	}
+
+	resp := dnsmessage.NewBuilder(buf, hdr)
+	resp.EnableCompression()
+	if err = resp.StartQuestions(); err != nil {
+		return
+	}
+	resp.Question(q)
+	if err = resp.StartAnswers(); err != nil {
+		return
+	}
+	if ip.IsValid() {
+		err = resp.AResource(
+			dnsmessage.ResourceHeader{Name: q.Name, Class: q.Class, TTL: uint32(dnsTTL / time.Second)},
+			dnsmessage.AResource{A: ip.As4()},
+		)
+		if err != nil {
+			return
+		}
+	}
+	return resp.Finish()
+}
+
+// serveIPs serves the IPs of s.pool that are assigned to domains, as a
+// JSON array of ipAssignment, in order of IP.
+func (s *server) serveIPs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(s.pool.assignments())
+}
+
+// errPoolExhausted is returned by ipPool.ipFor when all the IPs of the
+// pool are assigned.
+var errPoolExhausted = errors.New("all IPs of the prefix are assigned; use a larger --v4-pfx or an --ip-ttl")
+
+// ipPool assigns the IPs of a prefix to domains. Each keeps its IP for the
+// lifetime of the process, or, if ttl is non-zero, until it goes unused for
+// ttl.
+type ipPool struct {
+	pfx netip.Prefix
+	ttl time.Duration    // or zero to never unassign IPs
+	now func() time.Time // or nil for time.Now
+
+	mu       sync.Mutex
+	next     netip.Addr   // next never-assigned IP
+	free     []netip.Addr // unassigned IPs to reuse, oldest first
+	byDomain map[string]netip.Addr
+	byIP     map[netip.Addr]*ipAssignment
+}
+
+// ipAssignment is an IP that ipPool assigned to a domain.
+type ipAssignment struct {
+	IP       netip.Addr
+	Domain   string
+	LastUsed time.Time // last looked up or connected to
+}
+
+func newIPPool(pfx netip.Prefix) *ipPool {
+	return &ipPool{
+		pfx:      pfx,
+		next:     pfx.Addr().Next(), // skip the network address
+		byDomain: map[string]netip.Addr{},
+		byIP:     map[netip.Addr]*ipAssignment{},
+	}
+}
+
+func (p *ipPool) timeNow() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
+
+// contains reports whether ip is in the pool's prefix.
+func (p *ipPool) contains(ip netip.Addr) bool {
+	return p.pfx.Contains(ip)
+}
+
+// ipFor returns the IP assigned to domain, assigning it one if needed.
+func (p *ipPool) ipFor(domain string) (netip.Addr, error) {
+	now := p.timeNow()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ip, ok := p.byDomain[domain]; ok {
+		p.byIP[ip].LastUsed = now
+		return ip, nil
+	}
+	if len(p.free) == 0 && !p.pfx.Contains(p.next.Next()) {
+		// Don't hand out the broadcast address at the end of the
+		// prefix, but reclaim the IPs that went unused.
+		p.expireLocked(now)
+	}
+	var ip netip.Addr
+	if len(p.free) > 0 {
+		ip = p.free[0]
+		p.free = p.free[1:]
+	} else if p.pfx.Contains(p.next.Next()) {
+		ip = p.next
+		p.next = ip.Next()
+	} else {
+		return netip.Addr{}, errPoolExhausted
+	}
+	p.byDomain[domain] = ip
+	p.byIP[ip] = &ipAssignment{IP: ip, Domain: domain, LastUsed: now}
+	return ip, nil
+}
+
+// domainOf returns the domain that ip is assigned to, if any.
+func (p *ipPool) domainOf(ip netip.Addr) (domain string, ok bool) {
+	now := p.timeNow()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	a, ok := p.byIP[ip]
+	if !ok {
+		return "", false
+	}
+	a.LastUsed = now
+	return a.Domain, true
+}
+
+// expire unassigns the IPs that went unused for p.ttl, if non-zero.
+func (p *ipPool) expire() {
+	now := p.timeNow()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.expireLocked(now)
+}
+
+func (p *ipPool) expireLocked(now time.Time) {
+	if p.ttl == 0 {
+		return
+	}
+	var expired []netip.Addr
+	for ip, a := range p.byIP {
+		if now.Sub(a.LastUsed) >= p.ttl {
+			expired = append(expired, ip)
+			delete(p.byIP, ip)
+			delete(p.byDomain, a.Domain)
+		}
+	}
+	// Reuse the lowest IPs first, for stable results.
+	sort.Slice(expired, func(i, j int) bool { return expired[i].Less(expired[j]) })
+	p.free = append(p.free, expired...)
+}
+
+// assignments returns the IPs assigned to domains, in order of IP.
+func (p *ipPool) assignments() []ipAssignment {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ret := make([]ipAssignment, 0, len(p.byIP))
+	for _, a := range p.byIP {
+		ret = append(ret, *a)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].IP.Less(ret[j].IP) })
+	return ret
+}
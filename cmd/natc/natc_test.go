@@ -0,0 +1,160 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestMatchDomain(t *testing.T) {
+	domains := []string{"example.com", "*.corp.example.org."}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"example.com", true},
+		{"EXAMPLE.com", true},
+		{"www.example.com", false},
+		{"corp.example.org", false},
+		{"git.corp.example.org", true},
+		{"a.b.corp.example.org", true},
+		{"evilcorp.example.org", false},
+		{"example.net", false},
+	}
+	for _, tt := range tests {
+		if got := matchDomain(domains, tt.name); got != tt.want {
+			t.Errorf("matchDomain(%q) = %v; want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestIPPool(t *testing.T) {
+	p := newIPPool(netip.MustParsePrefix("198.18.0.0/30"))
+	a, err := p.ipFor("a.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddr("198.18.0.1"); a != want {
+		t.Errorf("first IP = %v; want %v", a, want)
+	}
+	b, err := p.ipFor("b.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := netip.MustParseAddr("198.18.0.2"); b != want {
+		t.Errorf("second IP = %v; want %v", b, want)
+	}
+	if again, _ := p.ipFor("a.example.com"); again != a {
+		t.Errorf("IP of a.example.com changed from %v to %v", a, again)
+	}
+	if _, err := p.ipFor("c.example.com"); err != errPoolExhausted {
+		t.Errorf("third IP error = %v; want errPoolExhausted", err)
+	}
+	if d, ok := p.domainOf(b); !ok || d != "b.example.com" {
+		t.Errorf("domainOf(%v) = %q, %v; want b.example.com", b, d, ok)
+	}
+	if _, ok := p.domainOf(netip.MustParseAddr("198.18.0.3")); ok {
+		t.Error("domainOf of an unassigned IP succeeded")
+	}
+	if p.contains(netip.MustParseAddr("198.18.1.1")) {
+		t.Error("pool contains an IP outside its prefix")
+	}
+}
+
+func TestIPPoolExpiry(t *testing.T) {
+	now := time.Unix(1000, 0)
+	p := newIPPool(netip.MustParsePrefix("198.18.0.0/30"))
+	p.ttl = 10 * time.Minute
+	p.now = func() time.Time { return now }
+
+	a, _ := p.ipFor("a.example.com")
+	b, _ := p.ipFor("b.example.com")
+	now = now.Add(6 * time.Minute)
+	p.domainOf(a) // a connection keeps a's IP in use
+	now = now.Add(6 * time.Minute)
+
+	// The pool is full, so b's IP, unused for 12m, is reclaimed.
+	c, err := p.ipFor("c.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != b {
+		t.Errorf("IP of c.example.com = %v; want b.example.com's %v", c, b)
+	}
+	if d, ok := p.domainOf(a); !ok || d != "a.example.com" {
+		t.Errorf("domainOf(%v) = %q, %v; want a.example.com", a, d, ok)
+	}
+	got := p.assignments()
+	if len(got) != 2 || got[0].Domain != "a.example.com" || got[1].Domain != "c.example.com" {
+		t.Errorf("assignments = %+v; want a.example.com and c.example.com", got)
+	}
+
+	now = now.Add(10 * time.Minute)
+	p.expire()
+	if got := p.assignments(); len(got) != 0 {
+		t.Errorf("assignments after expiry = %+v; want none", got)
+	}
+	if again, _ := p.ipFor("d.example.com"); again != a {
+		t.Errorf("IP of d.example.com = %v; want lowest free %v", again, a)
+	}
+}
+
+func TestDNSResponse(t *testing.T) {
+	s := &server{
+		domains:     []string{"*.example.com"},
+		denyDomains: []string{"*.internal.example.com"},
+		pool:        newIPPool(netip.MustParsePrefix("198.18.0.0/24")),
+	}
+	query := func(name string, typ dnsmessage.Type) dnsmessage.Message {
+		t.Helper()
+		req := &dnsmessage.Message{
+			Header: dnsmessage.Header{ID: 123},
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName(name),
+				Type:  typ,
+				Class: dnsmessage.ClassINET,
+			}},
+		}
+		buf, err := s.dnsResponse(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(buf); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Header.ID != 123 {
+			t.Errorf("response ID = %v; want 123", resp.Header.ID)
+		}
+		return resp
+	}
+
+	resp := query("www.example.com.", dnsmessage.TypeA)
+	if resp.Header.RCode != dnsmessage.RCodeSuccess || len(resp.Answers) != 1 {
+		t.Fatalf("A response = %v, %d answers; want success with 1", resp.Header.RCode, len(resp.Answers))
+	}
+	got := netip.AddrFrom4(resp.Answers[0].Body.(*dnsmessage.AResource).A)
+	if d, _ := s.pool.domainOf(got); d != "www.example.com" {
+		t.Errorf("answered %v, assigned to %q; want www.example.com", got, d)
+	}
+
+	resp = query("www.example.com.", dnsmessage.TypeAAAA)
+	if resp.Header.RCode != dnsmessage.RCodeSuccess || len(resp.Answers) != 0 {
+		t.Errorf("AAAA response = %v, %d answers; want success with none", resp.Header.RCode, len(resp.Answers))
+	}
+
+	resp = query("www.example.net.", dnsmessage.TypeA)
+	if resp.Header.RCode != dnsmessage.RCodeRefused {
+		t.Errorf("response for a disallowed domain = %v; want refused", resp.Header.RCode)
+	}
+
+	resp = query("git.internal.example.com.", dnsmessage.TypeA)
+	if resp.Header.RCode != dnsmessage.RCodeRefused {
+		t.Errorf("response for a denied domain = %v; want refused", resp.Header.RCode)
+	}
+}
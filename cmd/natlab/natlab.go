@@ -0,0 +1,96 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Command natlab simulates two Tailscale nodes establishing a direct path
+// through canned types of NATs and firewalls, all in memory, and prints
+// whether they could.
+//
+// With --all, it prints the results for every pair of NAT profiles. Set
+// NATLAB_TRACE=1 to trace every simulated packet.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"tailscale.com/tstest/natlab"
+)
+
+var (
+	flagA   = flag.String("a", string(natlab.PortRestrictedNAT), "NAT profile of node A's network")
+	flagB   = flag.String("b", string(natlab.PortRestrictedNAT), "NAT profile of node B's network")
+	flagAll = flag.Bool("all", false, "probe every pair of NAT profiles")
+)
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: natlab [--a=profile] [--b=profile] [--all]\n\nNAT profiles, from easiest to hardest:")
+		for _, p := range natlab.NATProfiles {
+			fmt.Fprintf(os.Stderr, " %s", p)
+		}
+		fmt.Fprintf(os.Stderr, "\n\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if *flagAll {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 2, 2, ' ', 0)
+		fmt.Fprint(tw, "A \\ B")
+		for _, b := range natlab.NATProfiles {
+			fmt.Fprintf(tw, "\t%s", b)
+		}
+		fmt.Fprintln(tw)
+		for _, a := range natlab.NATProfiles {
+			fmt.Fprint(tw, a)
+			for _, b := range natlab.NATProfiles {
+				res, err := natlab.NewPair(a, b).Probe(ctx)
+				if err != nil {
+					log.Fatal(err)
+				}
+				fmt.Fprintf(tw, "\t%s", pathOf(res))
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+		return
+	}
+
+	a, err := natlab.ParseNATProfile(*flagA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b, err := natlab.ParseNATProfile(*flagB)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := natlab.NewPair(a, b).Probe(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("A (%s) endpoints: %v, mapping varies by destination: %v\n", a, res.AEndpoints, natlab.MappingVariesByDest(res.AEndpoints))
+	fmt.Printf("B (%s) endpoints: %v, mapping varies by destination: %v\n", b, res.BEndpoints, natlab.MappingVariesByDest(res.BEndpoints))
+	fmt.Printf("A to B: %v, B to A: %v\n", res.AToB, res.BToA)
+	fmt.Printf("path: %s\n", pathOf(res))
+}
+
+// pathOf returns the path the nodes would use given res: "direct", or
+// "derp" if they'd have to relay.
+func pathOf(res *natlab.ProbeResult) string {
+	if res.Direct() {
+		return "direct"
+	}
+	return "derp"
+}
@@ -112,12 +112,14 @@ func Run(args []string) (err error) {
 			setCmd,
 			loginCmd,
 			logoutCmd,
+			deprovisionCmd,
 			switchCmd,
 			netcheckCmd,
 			ipCmd,
 			statusCmd,
 			pingCmd,
 			ncCmd,
+			wolCmd,
 			sshCmd,
 			versionCmd,
 			webCmd,
@@ -126,6 +128,9 @@ func Run(args []string) (err error) {
 			certCmd,
 			netlockCmd,
 			licensesCmd,
+			usageCmd,
+			netmapCmd,
+			ephemeralRunCmd,
 		},
 		FlagSet:   rootfs,
 		Exec:      func(context.Context, []string) error { return flag.ErrHelp },
@@ -4,25 +4,37 @@
 package cli
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
+	"net"
 	"net/netip"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
 	"github.com/google/go-cmp/cmp"
+	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/speedtest"
+	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/tstest"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
 	"tailscale.com/types/persist"
 	"tailscale.com/types/preftype"
 	"tailscale.com/version/distro"
+	"tailscale.com/wgengine/filter"
 )
 
 // geese is a collection of gooses. It need not be complete.
@@ -611,6 +623,92 @@ func TestPrefsFromUpArgs(t *testing.T) {
 			},
 			wantErr: `--exit-node-allow-lan-access can only be used with --exit-node`,
 		},
+		{
+			name: "error_exit_node_allow_lan_cidrs_without_lan_access",
+			args: upArgsT{
+				exitNodeIP:            "100.105.106.107",
+				exitNodeAllowLANCIDRs: "192.168.2.0/24",
+			},
+			wantErr: `--exit-node-allow-lan-cidrs can only be used with --exit-node-allow-lan-access`,
+		},
+		{
+			name: "error_exit_node_allow_lan_cidrs_non_masked",
+			args: upArgsT{
+				exitNodeIP:             "100.105.106.107",
+				exitNodeAllowLANAccess: true,
+				exitNodeAllowLANCIDRs:  "192.168.2.1/24",
+			},
+			wantErr: `192.168.2.1/24 has non-address bits set; expected 192.168.2.0/24`,
+		},
+		{
+			name: "exit_node_allow_lan_cidrs",
+			args: upArgsT{
+				exitNodeIP:             "100.105.106.107",
+				exitNodeAllowLANAccess: true,
+				exitNodeAllowLANCIDRs:  "192.168.2.0/24,224.0.0.251/32",
+				netfilterMode:          "off",
+			},
+			want: &ipn.Prefs{
+				WantRunning:            true,
+				NoSNAT:                 true,
+				ExitNodeIP:             netip.MustParseAddr("100.105.106.107"),
+				ExitNodeAllowLANAccess: true,
+				ExitNodeAllowLANCIDRs: []netip.Prefix{
+					netip.MustParsePrefix("192.168.2.0/24"),
+					netip.MustParsePrefix("224.0.0.251/32"),
+				},
+			},
+		},
+		{
+			name: "error_reject_routes_non_masked",
+			args: upArgsT{
+				rejectRoutes: "10.1.2.3/8",
+			},
+			wantErr: `10.1.2.3/8 has non-address bits set; expected 10.0.0.0/8`,
+		},
+		{
+			name: "error_reject_routes_default_route",
+			args: upArgsT{
+				rejectRoutes: "0.0.0.0/0",
+			},
+			wantErr: `0.0.0.0/0 would reject exit node routes; use --exit-node= to stop using an exit node`,
+		},
+		{
+			name: "reject_routes",
+			args: upArgsT{
+				acceptRoutes:  true,
+				rejectRoutes:  "10.0.0.0/8,fd00::/8",
+				netfilterMode: "off",
+			},
+			want: &ipn.Prefs{
+				WantRunning: true,
+				NoSNAT:      true,
+				RouteAll:    true,
+				RejectRoutes: []netip.Prefix{
+					netip.MustParsePrefix("10.0.0.0/8"),
+					netip.MustParsePrefix("fd00::/8"),
+				},
+			},
+		},
+		{
+			name: "error_keepalive_peers_bad_tag",
+			args: upArgsT{
+				keepAlivePeers: "office,tag:",
+			},
+			wantErr: `tag: "tag:": tag names must not be empty`,
+		},
+		{
+			name: "keepalive_peers",
+			args: upArgsT{
+				keepAlivePeers: "office,100.64.0.1,tag:router",
+				netfilterMode:  "off",
+			},
+			want: &ipn.Prefs{
+				WantRunning:    true,
+				NoSNAT:         true,
+				KeepAlivePeers: []string{"office", "100.64.0.1", "tag:router"},
+			},
+		},
 		{
 			name: "error_tag_prefix",
 			args: upArgsT{
@@ -862,13 +960,85 @@ func TestUpdatePrefs(t *testing.T) {
 				ControlURLSet:             true,
 				CorpDNSSet:                true,
 				ExitNodeAllowLANAccessSet: true,
+				ExitNodeAllowLANCIDRsSet:  true,
+				KillSwitchSet:             true,
 				ExitNodeIDSet:             true,
 				ExitNodeIPSet:             true,
+				ExitRateLimitSet:          true,
 				HostnameSet:               true,
+				KeepAlivePeersSet:         true,
+				MaintenanceSet:            true,
 				NetfilterModeSet:          true,
 				NoSNATSet:                 true,
 				OperatorUserSet:           true,
+				ProxyURLSet:               true,
+				RejectRoutesSet:           true,
+				RemoteAdminSet:            true,
+				PowerSaveSet:              true,
+				RecordUsageSet:            true,
 				RouteAllSet:               true,
+				RouteMetricSet:            true,
+				RunSSHSet:                 true,
+				ShieldsUpSet:              true,
+				WantRunningSet:            true,
+			},
+		},
+		{
+			name:  "implicit_reset_needs_accept",
+			flags: []string{"--accept-routes"},
+			curPrefs: &ipn.Prefs{
+				ControlURL:       ipn.DefaultControlURL,
+				Persist:          &persist.Persist{LoginName: "crawshaw.github"},
+				AllowSingleHosts: true,
+				CorpDNS:          true,
+				ShieldsUp:        true,
+				NetfilterMode:    preftype.NetfilterOn,
+			},
+			env:          upCheckEnv{backendState: "Running"},
+			wantErrSubtr: "requires mentioning all",
+		},
+		{
+			name:  "implicit_reset_accepted",
+			flags: []string{"--accept-routes", "--accept-changes"},
+			curPrefs: &ipn.Prefs{
+				ControlURL:       ipn.DefaultControlURL,
+				Persist:          &persist.Persist{LoginName: "crawshaw.github"},
+				AllowSingleHosts: true,
+				CorpDNS:          true,
+				ShieldsUp:        true,
+				NetfilterMode:    preftype.NetfilterOn,
+			},
+			env: upCheckEnv{backendState: "Running"},
+			checkUpdatePrefsMutations: func(t *testing.T, newPrefs *ipn.Prefs) {
+				if newPrefs.ShieldsUp || !newPrefs.RouteAll {
+					t.Errorf("ShieldsUp, RouteAll = %v, %v; want false, true", newPrefs.ShieldsUp, newPrefs.RouteAll)
+				}
+			},
+			wantJustEditMP: &ipn.MaskedPrefs{
+				AdvertiseRoutesSet:        true,
+				AdvertiseTagsSet:          true,
+				AllowSingleHostsSet:       true,
+				ControlURLSet:             true,
+				CorpDNSSet:                true,
+				ExitNodeAllowLANAccessSet: true,
+				ExitNodeAllowLANCIDRsSet:  true,
+				KillSwitchSet:             true,
+				ExitNodeIDSet:             true,
+				ExitNodeIPSet:             true,
+				ExitRateLimitSet:          true,
+				HostnameSet:               true,
+				KeepAlivePeersSet:         true,
+				MaintenanceSet:            true,
+				NetfilterModeSet:          true,
+				NoSNATSet:                 true,
+				OperatorUserSet:           true,
+				ProxyURLSet:               true,
+				RejectRoutesSet:           true,
+				RemoteAdminSet:            true,
+				PowerSaveSet:              true,
+				RecordUsageSet:            true,
+				RouteAllSet:               true,
+				RouteMetricSet:            true,
 				RunSSHSet:                 true,
 				ShieldsUpSet:              true,
 				WantRunningSet:            true,
@@ -1238,3 +1408,553 @@ func TestParseNLArgs(t *testing.T) {
 		})
 	}
 }
+
+func TestUpWizard(t *testing.T) {
+	tests := []struct {
+		name     string
+		goos     string
+		flags    []string
+		input    string
+		curPrefs *ipn.Prefs
+		st       *ipnstate.Status
+		want     upArgsT // only the fields the wizard asks about
+		wantErr  bool
+	}{
+		{
+			name:  "defaults",
+			goos:  "linux",
+			input: "\n\n\n\n",
+			want:  upArgsT{},
+		},
+		{
+			name:  "all_yes",
+			goos:  "linux",
+			input: "y\nexit1\nyes\ny\nY\n",
+			want: upArgsT{
+				acceptRoutes:           true,
+				exitNodeIP:             "exit1",
+				exitNodeAllowLANAccess: true,
+				advertiseDefaultRoute:  true,
+				runSSH:                 true,
+			},
+		},
+		{
+			name:     "defaults_from_prefs",
+			goos:     "linux",
+			input:    "\n\n\nn\n",
+			curPrefs: &ipn.Prefs{RouteAll: true, AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}, RunSSH: true},
+			want: upArgsT{
+				acceptRoutes:          true,
+				advertiseDefaultRoute: true,
+			},
+		},
+		{
+			name:     "exit_node_id_default",
+			goos:     "linux",
+			input:    "\n\n\n\n\n",
+			curPrefs: &ipn.Prefs{ExitNodeID: "nExit"},
+			st: &ipnstate.Status{Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+				key.NewNode().Public(): {ID: "nExit", TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.5")}},
+			}},
+			want: upArgsT{exitNodeIP: "100.64.0.5"},
+		},
+		{
+			name:  "reask_bad_answer",
+			goos:  "windows",
+			input: "maybe\nn\n\n\n",
+			want:  upArgsT{},
+		},
+		{
+			name:  "skip_explicit_flags",
+			goos:  "linux",
+			flags: []string{"--accept-routes", "--ssh=false"},
+			input: "\ny\n",
+			want: upArgsT{
+				acceptRoutes:          true,
+				advertiseDefaultRoute: true,
+			},
+		},
+		{
+			name:    "eof",
+			goos:    "linux",
+			input:   "y\n",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var upArgs upArgsT
+			fs := newUpFlagSet(tt.goos, &upArgs, "up")
+			if err := fs.Parse(tt.flags); err != nil {
+				t.Fatal(err)
+			}
+			curPrefs := tt.curPrefs
+			if curPrefs == nil {
+				curPrefs = ipn.NewPrefs()
+				curPrefs.RouteAll = false
+			}
+			st := tt.st
+			if st == nil {
+				st = new(ipnstate.Status)
+			}
+			var out bytes.Buffer
+			w := &upWizard{
+				r:    bufio.NewReader(strings.NewReader(tt.input)),
+				w:    &out,
+				fs:   fs,
+				goos: tt.goos,
+			}
+			err := w.run(curPrefs, st)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("run error = %v; wantErr %v\noutput: %s", err, tt.wantErr, out.Bytes())
+			}
+			if tt.wantErr {
+				return
+			}
+			got := upArgsT{
+				acceptRoutes:           upArgs.acceptRoutes,
+				exitNodeIP:             upArgs.exitNodeIP,
+				exitNodeAllowLANAccess: upArgs.exitNodeAllowLANAccess,
+				advertiseDefaultRoute:  upArgs.advertiseDefaultRoute,
+				runSSH:                 upArgs.runSSH,
+			}
+			if got != tt.want {
+				t.Errorf("got %+v; want %+v", got, tt.want)
+			}
+			// Answers must count as explicitly given flags so that the
+			// accidental setting revert check sees them.
+			var visited int
+			fs.Visit(func(*flag.Flag) { visited++ })
+			if visited == 0 {
+				t.Error("no flags were marked as set")
+			}
+		})
+	}
+}
+
+func TestUpPrefChanges(t *testing.T) {
+	var upArgs upArgsT
+	fs := newUpFlagSet("linux", &upArgs, "up")
+	if err := fs.Parse([]string{"--accept-routes"}); err != nil {
+		t.Fatal(err)
+	}
+	cur := &ipn.Prefs{ShieldsUp: true, AdvertiseRoutes: []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}}
+	next := &ipn.Prefs{RouteAll: true}
+	got := upPrefChanges(cur.Changes(next), fs)
+	want := []upPrefChange{
+		{PrefChange: ipn.PrefChange{Field: "RouteAll", Old: false, New: true}, Flag: "accept-routes"},
+		{PrefChange: ipn.PrefChange{Field: "ShieldsUp", Old: true, New: false}, Flag: "shields-up", Implicit: true},
+		{PrefChange: ipn.PrefChange{Field: "AdvertiseRoutes", Old: cur.AdvertiseRoutes, New: []netip.Prefix(nil)}, Flag: "advertise-exit-node", Implicit: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestSplitCpArgs(t *testing.T) {
+	tests := []struct {
+		args        []string
+		files, tgts []string
+	}{
+		{[]string{"a", "b:"}, []string{"a"}, []string{"b"}},
+		{[]string{"a", "b", "c:", "[fd7a::1]:"}, []string{"a", "b"}, []string{"c", "[fd7a::1]"}},
+		{[]string{"a", "b"}, []string{"a", "b"}, nil},
+		{[]string{"b:"}, []string{}, []string{"b"}},
+	}
+	for _, tt := range tests {
+		files, tgts := splitCpArgs(tt.args)
+		if !reflect.DeepEqual(files, tt.files) || !reflect.DeepEqual(tgts, tt.tgts) {
+			t.Errorf("splitCpArgs(%q) = %q, %q; want %q, %q", tt.args, files, tgts, tt.files, tt.tgts)
+		}
+	}
+}
+
+func TestByteRate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    byteRate
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"1000", 1000, false},
+		{"500K", 500 << 10, false},
+		{"1.5m", 1.5 * (1 << 20), false},
+		{"2G", 2 << 30, false},
+		{"0", 0, true},
+		{"-1K", 0, true},
+		{"fast", 0, true},
+	}
+	for _, tt := range tests {
+		var r byteRate
+		err := r.Set(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Set(%q) error = %v; wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && r != tt.want {
+			t.Errorf("Set(%q) = %v; want %v", tt.in, r, tt.want)
+		}
+	}
+}
+
+func TestProgressBar(t *testing.T) {
+	tests := []struct {
+		n     uint64
+		total int64
+		want  string
+	}{
+		{0, 100, "[>                   ]"},
+		{50, 100, "[==========>         ]"},
+		{100, 100, "[====================]"},
+		{200, 100, "[====================]"},
+	}
+	for _, tt := range tests {
+		if got := progressBar(tt.n, tt.total); got != tt.want {
+			t.Errorf("progressBar(%d, %d) = %q; want %q", tt.n, tt.total, got, tt.want)
+		}
+	}
+}
+
+func TestStatusHints(t *testing.T) {
+	now := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	inDays := func(d int) *time.Time {
+		t := now.Add(time.Duration(d) * 24 * time.Hour)
+		return &t
+	}
+	st := &ipnstate.Status{
+		Self: &ipnstate.PeerStatus{KeyExpiry: inDays(3)},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {Active: true, Relay: "nyc"},
+			key.NewNode().Public(): {Active: true, Relay: "nyc", CurAddr: "1.2.3.4:41641"},
+		},
+		Health: []string{"no map response in 5m0s"},
+		HealthWarnings: []ipnstate.HealthWarning{
+			{Code: "control", Severity: "error", Text: "no map response in 5m0s"},
+		},
+	}
+	hints := statusHints(st, now)
+	if len(hints) != 3 {
+		t.Fatalf("got %d hints, want 3: %q", len(hints), hints)
+	}
+	for i, want := range []string{"expires in 3d", "coordination server", "1 active peer(s) are relayed"} {
+		if !strings.Contains(hints[i], want) {
+			t.Errorf("hint %d = %q; want it to contain %q", i, hints[i], want)
+		}
+	}
+
+	// The hints follow the warning codes, not their text.
+	st = &ipnstate.Status{
+		Health: []string{"not connected to home DERP region 1"},
+		HealthWarnings: []ipnstate.HealthWarning{
+			{Code: "udp4-unbound", Severity: "error", Text: "something else"},
+		},
+	}
+	if hints := statusHints(st, now); len(hints) != 1 || !strings.Contains(hints[0], "UDP socket") {
+		t.Errorf("udp4-unbound got hints %q", hints)
+	}
+
+	if hints := statusHints(&ipnstate.Status{Self: &ipnstate.PeerStatus{KeyExpiry: inDays(30)}}, now); len(hints) != 0 {
+		t.Errorf("healthy status got hints %q", hints)
+	}
+}
+
+func TestStatusHealth(t *testing.T) {
+	ws := []ipnstate.HealthWarning{
+		{Code: "derp-region", Severity: "warning", Text: "derp1: slow"},
+		{Code: "dns", Severity: "error", Text: "dns: boom"},
+		{Code: "derp-region", Severity: "warning", Text: "derp2: slow"},
+	}
+	if got := healthExitCode(nil); got != 0 {
+		t.Errorf("healthExitCode(nil) = %d; want 0", got)
+	}
+	if got := healthExitCode(ws[:1]); got != 1 {
+		t.Errorf("healthExitCode(warning) = %d; want 1", got)
+	}
+	if got := healthExitCode(ws); got != 2 {
+		t.Errorf("healthExitCode(error) = %d; want 2", got)
+	}
+
+	want := `# HELP tailscale_healthy Whether tailscaled reports no health problems.
+# TYPE tailscale_healthy gauge
+tailscale_healthy 0
+# HELP tailscale_health_warnings Number of health problems reported by tailscaled, by code and severity.
+# TYPE tailscale_health_warnings gauge
+tailscale_health_warnings{code="derp-region",severity="warning"} 2
+tailscale_health_warnings{code="dns",severity="error"} 1
+`
+	if got := healthPrometheus(ws); got != want {
+		t.Errorf("healthPrometheus = %s; want %s", got, want)
+	}
+	if got := healthPrometheus(nil); !strings.Contains(got, "tailscale_healthy 1\n") {
+		t.Errorf("healthPrometheus(nil) = %s; want healthy", got)
+	}
+}
+
+func TestKeyExpiryHint(t *testing.T) {
+	now := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+	tests := []struct {
+		ps   *ipnstate.PeerStatus
+		want string
+	}{
+		{&ipnstate.PeerStatus{}, ""},
+		{&ipnstate.PeerStatus{Expired: true}, "key expired"},
+		{&ipnstate.PeerStatus{KeyExpiry: at(-time.Hour)}, "key expired"},
+		{&ipnstate.PeerStatus{KeyExpiry: at(30 * time.Minute)}, "key expires in <1h"},
+		{&ipnstate.PeerStatus{KeyExpiry: at(5 * time.Hour)}, "key expires in 5h"},
+		{&ipnstate.PeerStatus{KeyExpiry: at(50 * time.Hour)}, "key expires in 2d"},
+		{&ipnstate.PeerStatus{KeyExpiry: at(8 * 24 * time.Hour)}, ""},
+	}
+	for i, tt := range tests {
+		if got := keyExpiryHint(tt.ps, now); got != tt.want {
+			t.Errorf("%d: got %q; want %q", i, got, tt.want)
+		}
+	}
+}
+
+func TestFormatSuggestedRoutes(t *testing.T) {
+	if got, want := string(formatSuggestedRoutes(nil)), "No traffic to or from private subnets observed.\n"; got != want {
+		t.Errorf("empty: got %q; want %q", got, want)
+	}
+	seen := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	got := string(formatSuggestedRoutes([]apitype.SuggestedRoute{
+		{
+			Route:     netip.MustParsePrefix("10.1.2.0/24"),
+			Packets:   100,
+			Bytes:     12345,
+			LastSeen:  seen,
+			CoveredBy: netip.MustParsePrefix("10.0.0.0/8"),
+		},
+		{
+			Route:    netip.MustParsePrefix("192.168.1.0/24"),
+			Packets:  3,
+			Bytes:    180,
+			LastSeen: seen,
+		},
+	}))
+	want := `ROUTE           PACKETS  BYTES  LAST SEEN             COVERED BY
+10.1.2.0/24     100      12345  2023-02-01T12:00:00Z  10.0.0.0/8
+192.168.1.0/24  3        180    2023-02-01T12:00:00Z  -
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatUsage(t *testing.T) {
+	if got, want := string(formatUsage(nil, false)), "No traffic to or from peers recorded.\n"; got != want {
+		t.Errorf("empty: got %q; want %q", got, want)
+	}
+	days := []apitype.UsageDay{
+		{
+			Date: "2023-02-01",
+			Peers: []apitype.PeerUsage{
+				{Peer: "n1", Name: "old-name", TxBytes: 1000, RxBytes: 500},
+				{Peer: "n2", Name: "exit", Route: netip.MustParsePrefix("0.0.0.0/0"), TxBytes: 2_000_000, RxBytes: 40_000_000},
+			},
+		},
+		{
+			Date: "2023-02-02",
+			Peers: []apitype.PeerUsage{
+				{Peer: "n1", Name: "laptop", TxBytes: 24, RxBytes: 0},
+			},
+		},
+	}
+	got := string(formatUsage(days, false))
+	want := `PEER    ROUTE      SENT    RECEIVED
+exit    0.0.0.0/0  2.0 MB  40.0 MB
+laptop  -          1.0 kB  500 B
+TOTAL              2.0 MB  40.0 MB
+`
+	if got != want {
+		t.Errorf("by peer: got:\n%s\nwant:\n%s", got, want)
+	}
+	got = string(formatUsage(days, true))
+	want = `DATE        SENT    RECEIVED
+2023-02-01  2.0 MB  40.0 MB
+2023-02-02  24 B    0 B
+TOTAL       2.0 MB  40.0 MB
+`
+	if got != want {
+		t.Errorf("daily: got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatSpeedTest(t *testing.T) {
+	start := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	res := &apitype.SpeedTestResponse{
+		Protocol:  speedtest.UDP,
+		Direction: "download",
+		Path:      "direct 203.0.113.5:41641",
+		Results: []speedtest.Result{
+			{Bytes: 1_250_000, IntervalStart: start, IntervalEnd: start.Add(time.Second)},
+			{Bytes: 625_000, IntervalStart: start.Add(time.Second), IntervalEnd: start.Add(2 * time.Second)},
+			{Bytes: 1_875_000, IntervalStart: start, IntervalEnd: start.Add(2 * time.Second), Total: true},
+		},
+		UDP: &speedtest.UDPStats{Sent: 2000, Received: 1980},
+	}
+	got := string(formatSpeedTest(res))
+	want := `INTERVAL           TRANSFER  BITRATE
+0.00-1.00 s        1.25 MB   10.00 Mbit/s
+1.00-2.00 s        0.62 MB   5.00 Mbit/s
+total 0.00-2.00 s  1.88 MB   7.50 Mbit/s
+Lost 20/2000 packets (1.00%)
+Path: direct 203.0.113.5:41641
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatWakeOnLAN(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	tests := []struct {
+		res  apitype.WakeOnLANResponse
+		want string
+	}{
+		{
+			res:  apitype.WakeOnLANResponse{SentTo: []string{"eth0", "eth1"}},
+			want: "sent Wake-on-LAN packet for 00:11:22:33:44:55 from router on eth0, eth1",
+		},
+		{
+			res:  apitype.WakeOnLANResponse{SentTo: []string{"eth0"}, Errors: []string{"boom"}},
+			want: "sent Wake-on-LAN packet for 00:11:22:33:44:55 from router on eth0\nerror: boom",
+		},
+		{
+			res:  apitype.WakeOnLANResponse{},
+			want: "router has no LAN to send a Wake-on-LAN packet for 00:11:22:33:44:55 on",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatWakeOnLAN(mac, "router", &tt.res); got != tt.want {
+			t.Errorf("formatWakeOnLAN(%+v) = %q; want %q", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestFormatLatencyMatrix(t *testing.T) {
+	m := &apitype.LatencyMatrix{Rows: []apitype.LatencyRow{
+		{From: "100.64.0.1", FromName: "self.tail-scale.ts.net.", Results: []apitype.LatencyResult{
+			{To: "100.64.0.2", ToName: "nyc.tail-scale.ts.net.", LatencySeconds: 0.0123, Path: "direct 203.0.113.5:41641"},
+			{To: "100.64.0.3", ToName: "ams.tail-scale.ts.net.", LatencySeconds: 0.0871, Path: "DERP(ams)"},
+		}},
+		{From: "100.64.0.2", FromName: "nyc.tail-scale.ts.net.", Results: []apitype.LatencyResult{
+			{To: "100.64.0.1", ToName: "self.tail-scale.ts.net.", LatencySeconds: 0.0125, Path: "direct 198.51.100.7:41641"},
+			{To: "100.64.0.3", ToName: "ams.tail-scale.ts.net.", Err: "timeout"},
+		}},
+		{From: "100.64.0.3", FromName: "ams.tail-scale.ts.net.", Err: "HTTP status 403 Forbidden: denied; no latency access"},
+	}}
+	want := `FROM \ TO  self    nyc     ams
+self       -       12.3ms  87.1ms DERP(ams)
+nyc        12.5ms  -       error
+ams                        -
+error: nyc to ams: timeout
+error: ams: HTTP status 403 Forbidden: denied; no latency access
+`
+	if got := string(formatLatencyMatrix(m)); got != want {
+		t.Errorf("formatLatencyMatrix = \n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatControlBackoff(t *testing.T) {
+	st := []backoff.State{
+		{Name: "authRoutine", MaxBackoff: 30 * time.Second, Jitter: 1},
+		{Name: "mapRoutine", Failures: 4, MaxBackoff: 30 * time.Second, Jitter: 1, LastDelay: 213400 * time.Microsecond, Remaining: 100100 * time.Microsecond},
+	}
+	want := `NAME         FAILURES  LAST DELAY  RETRY IN  MAX  JITTER
+authRoutine  0         0s          -         30s  100%
+mapRoutine   4         213ms       100ms     30s  100%
+`
+	if got := string(formatControlBackoff(st)); got != want {
+		t.Errorf("formatControlBackoff = \n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatFilterTraceEntry(t *testing.T) {
+	when := time.Date(2023, 2, 1, 12, 30, 45, 123e6, time.Local)
+	tests := []struct {
+		e    filter.TraceEntry
+		want string
+	}{
+		{
+			e: filter.TraceEntry{
+				Time:    when,
+				Dir:     "in",
+				Flow:    flowtrack.Tuple{Proto: ipproto.TCP, Src: netip.MustParseAddrPort("100.64.0.2:51234"), Dst: netip.MustParseAddrPort("100.64.0.1:22")},
+				Verdict: "Accept",
+				Why:     "tcp ok",
+				Rule:    "[TCP]100.64.0.2/32=>100.64.0.1/32:22",
+			},
+			want: "12:30:45.123 Accept in  (TCP 100.64.0.2:51234 => 100.64.0.1:22): tcp ok by rule [TCP]100.64.0.2/32=>100.64.0.1/32:22",
+		},
+		{
+			e: filter.TraceEntry{
+				Time:    when,
+				Dir:     "in",
+				Flow:    flowtrack.Tuple{Proto: ipproto.UDP, Src: netip.MustParseAddrPort("100.64.0.3:5000"), Dst: netip.MustParseAddrPort("100.64.0.1:53")},
+				Verdict: "Drop",
+				Why:     "no rules matched",
+			},
+			want: "12:30:45.123 Drop in  (UDP 100.64.0.3:5000 => 100.64.0.1:53): no rules matched",
+		},
+	}
+	for _, tt := range tests {
+		if got := formatFilterTraceEntry(tt.e); got != tt.want {
+			t.Errorf("got  %q\nwant %q", got, tt.want)
+		}
+	}
+}
+
+func TestCheckFilter(t *testing.T) {
+	snap := &netmap.Snapshot{
+		Version: netmap.SnapshotVersion,
+		Self: netmap.SnapshotNode{
+			Name:      "self.tail-scale.ts.net.",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		},
+		Peers: []netmap.SnapshotNode{{
+			Name:      "peer.tail-scale.ts.net.",
+			Hostname:  "peer-host",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32"), netip.MustParsePrefix("fd7a:115c:a1e0::2/128")},
+		}},
+		PacketFilterRules: []tailcfg.FilterRule{{
+			SrcIPs:   []string{"100.64.0.2"},
+			DstPorts: []tailcfg.NetPortRange{{IP: "100.64.0.1", Ports: tailcfg.PortRange{First: 22, Last: 22}}},
+		}},
+	}
+	for _, arg := range []string{"peer", "peer-host", "peer.tail-scale.ts.net", "100.64.0.2"} {
+		if ip, err := snapshotNodeIP(snap, arg, netip.Addr{}); err != nil || ip != netip.MustParseAddr("100.64.0.2") {
+			t.Errorf("snapshotNodeIP(%q) = %v, %v; want 100.64.0.2", arg, ip, err)
+		}
+	}
+	if ip, err := snapshotNodeIP(snap, "peer", netip.MustParseAddr("fd7a:115c:a1e0::1")); err != nil || ip != netip.MustParseAddr("fd7a:115c:a1e0::2") {
+		t.Errorf("snapshotNodeIP for IPv6 = %v, %v", ip, err)
+	}
+	if _, err := snapshotNodeIP(snap, "nope", netip.Addr{}); err == nil {
+		t.Error("snapshotNodeIP found an unknown node")
+	}
+
+	self := netip.MustParseAddr("100.64.0.1")
+	tests := []struct {
+		src                  string
+		port                 uint16
+		wantVerdict, wantWhy string
+		wantRule             string
+	}{
+		{"100.64.0.2", 22, "Accept", "tcp ok", "[TCP UDP ICMPv4 ICMPv6]100.64.0.2/32=>100.64.0.1/32:22"},
+		{"100.64.0.2", 80, "Drop", "no rules matched", ""},
+		{"100.64.0.3", 22, "Drop", "no rules matched", ""},
+	}
+	for _, tt := range tests {
+		e, err := checkFilter(snap, netip.MustParseAddr(tt.src), self, tt.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.Verdict != tt.wantVerdict || e.Why != tt.wantWhy || e.Rule != tt.wantRule {
+			t.Errorf("checkFilter(%s, %d) = %s, %q, %q; want %s, %q, %q", tt.src, tt.port, e.Verdict, e.Why, e.Rule, tt.wantVerdict, tt.wantWhy, tt.wantRule)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/version/distro"
+)
+
+var openwrtConfigureCmd = &ffcli.Command{
+	Name:       "openwrt",
+	Exec:       runConfigureOpenWrt,
+	ShortUsage: "openwrt [--interface=tailscale0] [--remove]",
+	ShortHelp:  "Configure OpenWrt's network and firewall for Tailscale",
+	LongHelp: strings.TrimSpace(`
+The 'openwrt' command adds a "tailscale" network interface and firewall zone
+to the OpenWrt configuration, with forwarding to and from the "lan" zone, so
+the router can act as a Tailscale subnet router or exit node without custom
+firewall includes.
+
+While the zone exists, tailscaled leaves the handling of Tailscale traffic to
+the OpenWrt firewall (fw3 or fw4) rather than managing iptables rules itself.
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("openwrt")
+		fs.StringVar(&configureOpenWrtArgs.iface, "interface", "tailscale0", "name of the Tailscale network device")
+		fs.BoolVar(&configureOpenWrtArgs.remove, "remove", false, "remove the Tailscale interface and firewall zone instead")
+		return fs
+	})(),
+}
+
+var configureOpenWrtArgs struct {
+	iface  string
+	remove bool
+}
+
+// openWrtUCISections are the uci sections created by "tailscale configure
+// openwrt".
+var openWrtUCISections = []string{
+	"network.tailscale",
+	"firewall.tailscale",
+	"firewall.tailscale_lan",
+	"firewall.lan_tailscale",
+}
+
+// openWrtUCISettings returns the "uci set" arguments that configure the
+// Tailscale interface and firewall zone for the iface device.
+func openWrtUCISettings(iface string) []string {
+	return []string{
+		"network.tailscale=interface",
+		"network.tailscale.proto=none",
+		"network.tailscale.device=" + iface,
+
+		"firewall.tailscale=zone",
+		"firewall.tailscale.name=tailscale",
+		"firewall.tailscale.input=ACCEPT",
+		"firewall.tailscale.output=ACCEPT",
+		"firewall.tailscale.forward=ACCEPT",
+		"firewall.tailscale.masq=1",
+		"firewall.tailscale.mtu_fix=1",
+		"firewall.tailscale.network=tailscale",
+
+		"firewall.tailscale_lan=forwarding",
+		"firewall.tailscale_lan.src=tailscale",
+		"firewall.tailscale_lan.dest=lan",
+
+		"firewall.lan_tailscale=forwarding",
+		"firewall.lan_tailscale.src=lan",
+		"firewall.lan_tailscale.dest=tailscale",
+	}
+}
+
+func runConfigureOpenWrt(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unknown arguments")
+	}
+	if runtime.GOOS != "linux" || distro.Get() != distro.OpenWrt {
+		return errors.New("only implemented on OpenWrt")
+	}
+	if uid := os.Getuid(); uid != 0 {
+		return fmt.Errorf("must be run as root, not %q (%v)", os.Getenv("USER"), uid)
+	}
+	if configureOpenWrtArgs.iface == "" {
+		return errors.New("--interface must not be empty")
+	}
+
+	uci := func(args ...string) error {
+		if out, err := exec.CommandContext(ctx, "uci", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("uci %s: %v, %s", strings.Join(args, " "), err, out)
+		}
+		return nil
+	}
+	if configureOpenWrtArgs.remove {
+		for _, sec := range openWrtUCISections {
+			// Ignore errors: the section may not exist.
+			exec.CommandContext(ctx, "uci", "-q", "delete", sec).Run()
+		}
+	} else {
+		for _, s := range openWrtUCISettings(configureOpenWrtArgs.iface) {
+			if err := uci("set", s); err != nil {
+				return err
+			}
+		}
+	}
+	for _, config := range []string{"network", "firewall"} {
+		if err := uci("commit", config); err != nil {
+			return err
+		}
+	}
+	if out, err := exec.CommandContext(ctx, "ubus", "call", "network", "reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("reloading network: %v, %s", err, out)
+	}
+	if out, err := exec.CommandContext(ctx, "/etc/init.d/firewall", "reload").CombinedOutput(); err != nil {
+		return fmt.Errorf("reloading firewall: %v, %s", err, out)
+	}
+	printf("Done. To restart Tailscale to use the new configuration, run:\n\n  /etc/init.d/tailscale restart\n\n")
+	return nil
+}
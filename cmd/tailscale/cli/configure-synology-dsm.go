@@ -0,0 +1,275 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn"
+	"tailscale.com/version/distro"
+)
+
+// synoWebAPIBin is DSM's command line client of its web API, which is how
+// packages manage DSM's certificates and reverse-proxy entries.
+const synoWebAPIBin = "/usr/syno/bin/synowebapi"
+
+// synologyCertDesc is the description of the certificate installed by
+// "tailscale configure synology --cert" in DSM's certificate store.
+const synologyCertDesc = "Tailscale"
+
+// synologyProxy is a reverse-proxy entry to configure with
+// "tailscale configure synology --proxy".
+type synologyProxy struct {
+	Port    int      // HTTPS port on the MagicDNS name
+	Backend *url.URL // http or https URL of the local web app
+}
+
+// parseSynologyProxies parses the --proxy flag of "tailscale configure
+// synology": comma-separated HTTPS_PORT:BACKEND_PORT or
+// HTTPS_PORT:BACKEND_URL entries.
+func parseSynologyProxies(s string) ([]synologyProxy, error) {
+	var ret []synologyProxy
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		portStr, backend, ok := strings.Cut(f, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid --proxy entry %q; want HTTPS_PORT:BACKEND_PORT or HTTPS_PORT:BACKEND_URL", f)
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil || port == 0 {
+			return nil, fmt.Errorf("invalid port %q in --proxy entry %q", portStr, f)
+		}
+		if _, err := strconv.ParseUint(backend, 10, 16); err == nil {
+			backend = "http://localhost:" + backend
+		}
+		u, err := url.Parse(backend)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" || u.Port() == "" {
+			return nil, fmt.Errorf("invalid backend %q in --proxy entry %q; want a port or an http(s)://host:port URL", backend, f)
+		}
+		if slices.IndexFunc(ret, func(p synologyProxy) bool { return p.Port == int(port) }) != -1 {
+			return nil, fmt.Errorf("duplicate port %d in --proxy", port)
+		}
+		ret = append(ret, synologyProxy{Port: int(port), Backend: u})
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("--proxy has no entries")
+	}
+	return ret, nil
+}
+
+// synologyProxyEntry returns the DSM reverse-proxy entry serving p on
+// domain over HTTPS. If uuid is non-empty, the entry replaces the
+// existing entry with that UUID.
+func synologyProxyEntry(domain string, p synologyProxy, uuid string) map[string]any {
+	backendPort, _ := strconv.Atoi(p.Backend.Port())
+	backendProto := 0 // HTTP
+	if p.Backend.Scheme == "https" {
+		backendProto = 1
+	}
+	e := map[string]any{
+		"description": fmt.Sprintf("%s %s:%d", synologyCertDesc, domain, p.Port),
+		"backend": map[string]any{
+			"fqdn":     p.Backend.Hostname(),
+			"port":     backendPort,
+			"protocol": backendProto,
+		},
+		"frontend": map[string]any{
+			"acl":      nil,
+			"fqdn":     domain,
+			"port":     p.Port,
+			"protocol": 1, // HTTPS
+			"https":    map[string]any{"hsts": false},
+		},
+		"customize_headers": []any{},
+	}
+	if uuid != "" {
+		e["UUID"] = uuid
+	}
+	return e
+}
+
+func runConfigureSynologyDSM(ctx context.Context) error {
+	if runtime.GOOS != "linux" || distro.Get() != distro.Synology {
+		return errors.New("only implemented on Synology")
+	}
+	if uid := os.Getuid(); uid != 0 {
+		return fmt.Errorf("must be run as root, not %q (%v)", os.Getenv("USER"), uid)
+	}
+	if v := distro.DSMVersion(); v < 7 {
+		return fmt.Errorf("--cert and --proxy require DSM 7 or later, not DSM %d", v)
+	}
+	var proxies []synologyProxy
+	if synologyArgs.proxy != "" {
+		var err error
+		if proxies, err = parseSynologyProxies(synologyArgs.proxy); err != nil {
+			return err
+		}
+	}
+
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return err
+	}
+	if st.BackendState != ipn.Running.String() {
+		return errors.New("Tailscale is not running")
+	}
+	if len(st.CertDomains) == 0 {
+		return errors.New("HTTPS cert support is not enabled/configured for your tailnet")
+	}
+	domain := st.CertDomains[0]
+
+	if synologyArgs.cert {
+		if err := installSynologyCert(ctx, domain); err != nil {
+			return err
+		}
+		printf("Installed the certificate for %s in DSM.\n", domain)
+	}
+	for _, p := range proxies {
+		if err := configureSynologyProxy(ctx, domain, p); err != nil {
+			return err
+		}
+		printf("Proxying https://%s to %s.\n", net.JoinHostPort(domain, strconv.Itoa(p.Port)), p.Backend)
+	}
+	return nil
+}
+
+// synoWebAPI calls method of the DSM web API api, with the params
+// encoded as JSON, and returns the "data" of the response.
+func synoWebAPI(ctx context.Context, api, method string, version int, params map[string]any) (json.RawMessage, error) {
+	args := []string{"--exec", "api=" + api, "method=" + method, "version=" + strconv.Itoa(version)}
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v, err := json.Marshal(params[k])
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, k+"="+string(v))
+	}
+	out, err := exec.CommandContext(ctx, synoWebAPIBin, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s.%s: %w", synoWebAPIBin, api, method, err)
+	}
+	var res struct {
+		Success bool            `json:"success"`
+		Data    json.RawMessage `json:"data"`
+		Error   struct {
+			Code int `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(out, &res); err != nil {
+		return nil, fmt.Errorf("%s.%s: invalid response %q", api, method, out)
+	}
+	if !res.Success {
+		return nil, fmt.Errorf("%s.%s: error code %d", api, method, res.Error.Code)
+	}
+	return res.Data, nil
+}
+
+// installSynologyCert imports the certificate for domain into DSM's
+// certificate store, replacing any certificate for domain it already has.
+func installSynologyCert(ctx context.Context, domain string) error {
+	data, err := synoWebAPI(ctx, "SYNO.Core.Certificate.CRT", "list", 1, nil)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Certificates []struct {
+			ID      string `json:"id"`
+			Subject struct {
+				CommonName string `json:"common_name"`
+			} `json:"subject"`
+		} `json:"certificates"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("listing DSM certificates: %w", err)
+	}
+	var id string
+	for _, c := range list.Certificates {
+		if c.Subject.CommonName == domain {
+			id = c.ID
+			break
+		}
+	}
+
+	certPEM, keyPEM, err := localClient.CertPair(ctx, domain)
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "tailscale-synology-cert")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, certPEM, 0600); err != nil {
+		return err
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	params := map[string]any{
+		"cert_tmp":   certFile,
+		"key_tmp":    keyFile,
+		"desc":       synologyCertDesc,
+		"as_default": synologyArgs.certDefault,
+	}
+	if id != "" {
+		params["id"] = id
+	}
+	_, err = synoWebAPI(ctx, "SYNO.Core.Certificate", "import", 1, params)
+	return err
+}
+
+// configureSynologyProxy creates or updates the DSM reverse-proxy entry
+// serving p on domain.
+func configureSynologyProxy(ctx context.Context, domain string, p synologyProxy) error {
+	const api = "SYNO.Core.AppPortal.ReverseProxy"
+	data, err := synoWebAPI(ctx, api, "list", 1, nil)
+	if err != nil {
+		return err
+	}
+	var list struct {
+		Entries []struct {
+			UUID     string `json:"UUID"`
+			Frontend struct {
+				FQDN string `json:"fqdn"`
+				Port int    `json:"port"`
+			} `json:"frontend"`
+		} `json:"entries"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("listing DSM reverse-proxy entries: %w", err)
+	}
+	method, uuid := "create", ""
+	for _, e := range list.Entries {
+		if strings.EqualFold(e.Frontend.FQDN, domain) && e.Frontend.Port == p.Port {
+			method, uuid = "update", e.UUID
+			break
+		}
+	}
+	_, err = synoWebAPI(ctx, api, method, 1, map[string]any{
+		"entry": synologyProxyEntry(domain, p, uuid),
+	})
+	return err
+}
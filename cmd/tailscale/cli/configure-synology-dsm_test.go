@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseSynologyProxies(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string // JSON of the entries for foo.tail-scale.ts.net
+		wantErr bool
+	}{
+		{in: "", wantErr: true},
+		{in: "443", wantErr: true},
+		{in: "0:5000", wantErr: true},
+		{in: "443:ftp://localhost:21", wantErr: true},
+		{in: "443:http://localhost", wantErr: true},
+		{in: "443:5000,443:5001", wantErr: true},
+		{
+			in:   "443:5000",
+			want: `[{"backend":{"fqdn":"localhost","port":5000,"protocol":0},"customize_headers":[],"description":"Tailscale foo.tail-scale.ts.net:443","frontend":{"acl":null,"fqdn":"foo.tail-scale.ts.net","https":{"hsts":false},"port":443,"protocol":1}}]`,
+		},
+		{
+			in:   "443:5000, 8443:https://127.0.0.1:5001",
+			want: `[{"backend":{"fqdn":"localhost","port":5000,"protocol":0},"customize_headers":[],"description":"Tailscale foo.tail-scale.ts.net:443","frontend":{"acl":null,"fqdn":"foo.tail-scale.ts.net","https":{"hsts":false},"port":443,"protocol":1}},{"backend":{"fqdn":"127.0.0.1","port":5001,"protocol":1},"customize_headers":[],"description":"Tailscale foo.tail-scale.ts.net:8443","frontend":{"acl":null,"fqdn":"foo.tail-scale.ts.net","https":{"hsts":false},"port":8443,"protocol":1}}]`,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseSynologyProxies(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSynologyProxies(%q) = %v; want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSynologyProxies(%q): %v", tt.in, err)
+			continue
+		}
+		var entries []map[string]any
+		for _, p := range got {
+			entries = append(entries, synologyProxyEntry("foo.tail-scale.ts.net", p, ""))
+		}
+		j, err := json.Marshal(entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(j) != tt.want {
+			t.Errorf("parseSynologyProxies(%q) entries:\n got %s\nwant %s", tt.in, j, tt.want)
+		}
+	}
+}
@@ -33,26 +33,46 @@
 }
 
 var synologyConfigureCmd = &ffcli.Command{
-	Name:      "synology",
-	Exec:      runConfigureSynology,
-	ShortHelp: "Configure Synology to enable more Tailscale features",
+	Name:       "synology",
+	Exec:       runConfigureSynology,
+	ShortUsage: "synology [--cert] [--proxy=443:5000,...]",
+	ShortHelp:  "Configure Synology to enable more Tailscale features",
 	LongHelp: strings.TrimSpace(`
 The 'configure-host' command is intended to run at boot as root
 to create the /dev/net/tun device and give the tailscaled binary
 permission to use it.
 
+With --cert or --proxy, it instead integrates Tailscale with DSM 7: --cert
+installs this node's MagicDNS HTTPS certificate into DSM's certificate store,
+replacing the one installed by a previous run, and --proxy configures DSM
+reverse-proxy entries serving local web apps over HTTPS on the MagicDNS name.
+Run it periodically, such as from the DSM Task Scheduler, to keep the
+certificate renewed.
+
 See: https://tailscale.com/kb/1152/synology-outbound/
 `),
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("synology")
+		fs.BoolVar(&synologyArgs.cert, "cert", false, "install this node's MagicDNS HTTPS certificate into DSM's certificate store")
+		fs.BoolVar(&synologyArgs.certDefault, "cert-default", false, "with --cert, make the certificate DSM's default certificate")
+		fs.StringVar(&synologyArgs.proxy, "proxy", "", "comma-separated reverse-proxy entries to configure on the MagicDNS name, as HTTPS_PORT:BACKEND_PORT or HTTPS_PORT:BACKEND_URL, such as \"443:5000\"")
 		return fs
 	})(),
 }
 
+var synologyArgs struct {
+	cert        bool
+	certDefault bool
+	proxy       string
+}
+
 func runConfigureSynology(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unknown arguments")
 	}
+	if synologyArgs.cert || synologyArgs.proxy != "" {
+		return runConfigureSynologyDSM(ctx)
+	}
 	if runtime.GOOS != "linux" || distro.Get() != distro.Synology {
 		return errors.New("only implemented on Synology")
 	}
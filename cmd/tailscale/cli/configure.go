@@ -34,5 +34,8 @@ func configureSubcommands() (out []*ffcli.Command) {
 	if runtime.GOOS == "linux" && distro.Get() == distro.Synology {
 		out = append(out, synologyConfigureCmd)
 	}
+	if runtime.GOOS == "linux" && distro.Get() == distro.OpenWrt {
+		out = append(out, openwrtConfigureCmd)
+	}
 	return out
 }
@@ -24,14 +24,18 @@
 	"runtime"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"golang.org/x/net/http/httpproxy"
+	"tailscale.com/client/tailscale"
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/control/controlhttp"
 	"tailscale.com/hostinfo"
 	"tailscale.com/ipn"
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/paths"
@@ -39,8 +43,11 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/views"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/must"
 	"tailscale.com/wgengine/capture"
+	"tailscale.com/wgengine/filter"
 )
 
 var debugCmd = &ffcli.Command{
@@ -53,6 +60,7 @@
 		fs.StringVar(&debugArgs.cpuFile, "cpu-profile", "", "if non-empty, grab a CPU profile for --profile-seconds seconds and write it to this file; - for stdout")
 		fs.StringVar(&debugArgs.memFile, "mem-profile", "", "if non-empty, grab a memory profile and write it to this file; - for stdout")
 		fs.IntVar(&debugArgs.cpuSec, "profile-seconds", 15, "number of seconds to run a CPU profile for, when --cpu-profile is non-empty")
+		fs.StringVar(&debugArgs.netmapFile, "netmap-file", "", "if non-empty, a snapshot from 'tailscale netmap export' for the derp-map and filter-check subcommands to analyze instead of tailscaled's network map")
 		return fs
 	})(),
 	Subcommands: []*ffcli.Command{
@@ -61,6 +69,22 @@
 			Exec:      runDERPMap,
 			ShortHelp: "print DERP map",
 		},
+		{
+			Name:      "capabilities",
+			Exec:      runDebugCapabilities,
+			ShortHelp: "print the node capabilities granted by the control server",
+		},
+		{
+			Name:      "posture",
+			Exec:      runDebugPosture,
+			ShortHelp: "print the device posture attributes reported to the control server",
+		},
+		{
+			Name:       "warm-path",
+			Exec:       runDebugWarmPath,
+			ShortUsage: "warm-path <hostname-or-IP>",
+			ShortHelp:  "establish the best path to a peer ahead of traffic to it",
+		},
 		{
 			Name:      "component-logs",
 			Exec:      runDebugComponentLogs,
@@ -191,6 +215,41 @@
 			Exec:      runDebugDERP,
 			ShortHelp: "test a DERP configuration",
 		},
+		{
+			Name:       "dial",
+			Exec:       runDebugDial,
+			ShortUsage: "dial <host>:<port>",
+			ShortHelp:  "trace each stage of connecting to a peer's TCP port",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug dial' command tries to connect to a TCP port on a
+peer, named by MagicDNS name or IP, and reports each stage with its
+timing: finding the peer in the netmap, the packet filter, disco probes,
+DERP, the WireGuard handshake and the TCP connection. It exits non-zero
+naming the first stage that failed.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("dial")
+				fs.BoolVar(&debugDialArgs.json, "json", false, "output JSON")
+				return fs
+			})(),
+		},
+		{
+			Name:       "resolve-route",
+			Exec:       runDebugResolveRoute,
+			ShortUsage: "resolve-route <ip>",
+			ShortHelp:  "explain how traffic to an IP is routed",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug resolve-route' command explains how this node routes
+traffic to an IP: to itself, to a peer, through a subnet router or an exit
+node, to the local network, or outside the tunnel. It lists the prefs,
+routes and packet filter rules that led to that decision.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("resolve-route")
+				fs.BoolVar(&debugResolveRouteArgs.json, "json", false, "output JSON")
+				return fs
+			})(),
+		},
 		{
 			Name:      "capture",
 			Exec:      runCapture,
@@ -204,12 +263,24 @@
 		{
 			Name:      "portmap",
 			Exec:      debugPortmap,
-			ShortHelp: "run portmap debugging debugging",
+			ShortHelp: "probe the gateway's port mapping services",
+			LongHelp: strings.TrimSpace(`
+The 'tailscale debug portmap' command probes the gateway for the NAT-PMP,
+PCP and UPnP port mapping services, and maps a port with each one it finds,
+printing the logs and a summary of the gateway's behavior, including the
+quirks that may hinder NAT traversal.
+
+With --hold, each mapping is held, and renewed as needed, to see how the
+gateway treats it over time. With --json, it prints a report to attach to
+NAT traversal bug reports instead.
+`),
 			FlagSet: (func() *flag.FlagSet {
 				fs := newFlagSet("portmap")
-				fs.DurationVar(&debugPortmapArgs.duration, "duration", 5*time.Second, "timeout for port mapping")
+				fs.DurationVar(&debugPortmapArgs.duration, "duration", 5*time.Second, "timeout for each port mapping")
 				fs.StringVar(&debugPortmapArgs.ty, "type", "", `portmap debug type (one of "", "pmp", "pcp", or "upnp")`)
 				fs.StringVar(&debugPortmapArgs.gwSelf, "gw-self", "", `override gateway and self IP (format: "gatewayIP/selfIP")`)
+				fs.DurationVar(&debugPortmapArgs.hold, "hold", 0, "how long to hold each mapping for, if non-zero")
+				fs.BoolVar(&debugPortmapArgs.json, "json", false, "print a JSON report instead of the logs")
 				return fs
 			})(),
 		},
@@ -218,14 +289,139 @@
 			Exec:      runPeerEndpointChanges,
 			ShortHelp: "prints debug information about a peer's endpoint changes",
 		},
+		{
+			Name:      "suggested-routes",
+			Exec:      runSuggestedRoutes,
+			ShortHelp: "suggest subnet routes to advertise, based on observed traffic",
+			LongHelp: strings.TrimSpace(`
+On a subnet router, "tailscale debug suggested-routes --start" starts
+observing which private subnets the traffic through this node flows to and
+from. Running "tailscale debug suggested-routes" later prints those subnets
+(as /24 or /64 routes), busiest first, along with any advertised route that
+already covers each. This helps narrow down a broad advertised route, such
+as 10.0.0.0/8, to the subnets that are actually used.
+
+With --advertise, the suggested routes that aren't covered by an advertised
+route are added to the advertised routes. Like any advertised route, they
+must still be approved in the admin console.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("suggested-routes")
+				fs.BoolVar(&suggestedRoutesArgs.start, "start", false, "start observing traffic, discarding earlier observations")
+				fs.BoolVar(&suggestedRoutesArgs.stop, "stop", false, "stop observing traffic")
+				fs.BoolVar(&suggestedRoutesArgs.advertise, "advertise", false, "advertise the suggested routes not covered by an advertised route")
+				fs.BoolVar(&suggestedRoutesArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "speedtest",
+			Exec:       runDebugSpeedTest,
+			ShortUsage: "debug speedtest [--udp] [--upload] [--duration=5s] [--bitrate=100] <hostname-or-IP>",
+			ShortHelp:  "measure the throughput of Tailscale traffic to and from a peer",
+			LongHelp: strings.TrimSpace(`
+"tailscale debug speedtest" measures the throughput of traffic with a peer
+over Tailscale, along the path (direct or DERP-relayed) that other traffic
+to the peer takes. Comparing it with the throughput of the underlying link
+shows the overhead of Tailscale.
+
+By default, the peer sends TCP traffic to this node. With --upload, this
+node sends. With --udp, UDP packets are sent at --bitrate, and the share
+of them lost is reported as well. UDP tests require both nodes to use a
+TUN device rather than userspace networking.
+
+The peer must be owned by the same user as this node, or grant it access
+to debug it.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("speedtest")
+				fs.BoolVar(&speedTestArgs.udp, "udp", false, "measure UDP throughput instead of TCP")
+				fs.BoolVar(&speedTestArgs.upload, "upload", false, "send the test traffic to the peer, instead of from it")
+				fs.DurationVar(&speedTestArgs.duration, "duration", speedtest.DefaultDuration, "duration of the test")
+				fs.Float64Var(&speedTestArgs.bitrate, "bitrate", speedtest.DefaultUDPBitrate/1e6, "Mbit/s to send in UDP tests")
+				fs.BoolVar(&speedTestArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "latency-matrix",
+			Exec:       runDebugLatencyMatrix,
+			ShortUsage: "debug latency-matrix [--tag=tag:x] [--all-pairs] [--json] [<hostname-or-IP>...]",
+			ShortHelp:  "measure the latency and path between this node and peers, or between each pair",
+			LongHelp: strings.TrimSpace(`
+"tailscale debug latency-matrix" pings the given peers, and the online peers
+with --tag, and shows the latency to each along with its path: direct, or
+relayed through a DERP region. With --all-pairs, each peer also measures its
+latency to this node and the others over its PeerAPI, giving a full matrix of
+the tailnet's paths, such as to choose DERP regions or exit nodes for a team
+spread across sites.
+
+With --all-pairs, the peers must be owned by the same user as this node, or
+grant it access to debug them.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("latency-matrix")
+				fs.StringVar(&latencyMatrixArgs.tag, "tag", "", "also measure the online peers with this tag")
+				fs.BoolVar(&latencyMatrixArgs.allPairs, "all-pairs", false, "also have the peers measure their latency to each other")
+				fs.BoolVar(&latencyMatrixArgs.json, "json", false, "output in JSON format")
+				return fs
+			})(),
+		},
+		{
+			Name:       "filter-trace",
+			Exec:       runDebugFilterTrace,
+			ShortUsage: "debug filter-trace [--for=1m] [--ip=<hostname-or-IP>] [--json]",
+			ShortHelp:  "trace which packet filter rule allows or drops each new flow",
+			LongHelp: strings.TrimSpace(`
+"tailscale debug filter-trace" makes the packet filter record its decision
+about each new flow to or from this node, and prints them as they happen: the
+verdict, the reason, and for flows allowed by a rule of the tailnet policy,
+that rule. Use it to see why a connection is or isn't allowed by the ACLs.
+
+Recording is rate limited, and stops after --for or when interrupted.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("filter-trace")
+				fs.DurationVar(&filterTraceArgs.forDur, "for", time.Minute, "how long to trace for")
+				fs.StringVar(&filterTraceArgs.ip, "ip", "", "only trace flows to or from this peer, by hostname or Tailscale IP")
+				fs.BoolVar(&filterTraceArgs.json, "json", false, "output in JSON format, one decision per line")
+				return fs
+			})(),
+		},
+		{
+			Name:       "filter-check",
+			Exec:       runDebugFilterCheck,
+			ShortUsage: "debug filter-check [--dst=IP] <src-hostname-or-IP> <port>",
+			ShortHelp:  "check whether this node's packet filter allows a TCP connection from a peer",
+			LongHelp: strings.TrimSpace(`
+"tailscale debug filter-check" evaluates this node's packet filter rules for a
+new TCP connection from the given peer to the port of this node's Tailscale IP
+of the same address family, or of --dst, such as an address in one of its
+subnet routes, and prints the verdict and the rule that allowed it, if any.
+
+With "tailscale debug --netmap-file=FILE filter-check", it checks the rules of
+the node in a snapshot from "tailscale netmap export" instead, offline.
+`),
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("filter-check")
+				fs.StringVar(&filterCheckArgs.dst, "dst", "", "the destination IP, if not this node's Tailscale IP")
+				return fs
+			})(),
+		},
+		{
+			Name:      "control-backoff",
+			Exec:      runDebugControlBackoff,
+			ShortHelp: "print the schedules tailscaled retries failed requests to the control server on",
+		},
 	},
 }
 
 var debugArgs struct {
-	file    string
-	cpuSec  int
-	cpuFile string
-	memFile string
+	file       string
+	cpuSec     int
+	cpuFile    string
+	memFile    string
+	netmapFile string
 }
 
 func writeProfile(dst string, v []byte) error {
@@ -401,6 +597,15 @@ func runWatchIPN(ctx context.Context, args []string) error {
 }
 
 func runDERPMap(ctx context.Context, args []string) error {
+	if debugArgs.netmapFile != "" {
+		snap, err := loadNetmapSnapshot(ctx)
+		if err != nil {
+			return err
+		}
+		enc := json.NewEncoder(Stdout)
+		enc.SetIndent("", "\t")
+		return enc.Encode(snap.DERPMap)
+	}
 	dm, err := localClient.CurrentDERPMap(ctx)
 	if err != nil {
 		return fmt.Errorf(
@@ -413,6 +618,54 @@ func runDERPMap(ctx context.Context, args []string) error {
 	return nil
 }
 
+func runDebugCapabilities(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	res, err := localClient.Capabilities(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "\t")
+	enc.Encode(res)
+	return nil
+}
+
+func runDebugPosture(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	res, err := localClient.Posture(ctx)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(Stdout)
+	enc.SetIndent("", "\t")
+	enc.Encode(res)
+	return nil
+}
+
+func runDebugWarmPath(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: warm-path <hostname-or-IP>")
+	}
+	pr, err := localClient.WarmPath(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if pr.Err != "" {
+		return errors.New(pr.Err)
+	}
+	latency := time.Duration(pr.LatencySeconds * float64(time.Second)).Round(time.Millisecond)
+	if pr.Endpoint != "" {
+		printf("direct path to %s (%s) via %s in %v\n", pr.NodeName, pr.NodeIP, pr.Endpoint, latency)
+	} else {
+		printf("no direct path to %s (%s) yet; relayed via DERP(%s) in %v\n", pr.NodeName, pr.NodeIP, pr.DERPRegionCode, latency)
+	}
+	return nil
+}
+
 func localAPIAction(action string) func(context.Context, []string) error {
 	return func(ctx context.Context, args []string) error {
 		if len(args) > 0 {
@@ -752,6 +1005,87 @@ func runDebugDERP(ctx context.Context, args []string) error {
 	return nil
 }
 
+var debugDialArgs struct {
+	json bool
+}
+
+func runDebugDial(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: debug dial <host>:<port>")
+	}
+	host, portStr, err := net.SplitHostPort(args[0])
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port %q", portStr)
+	}
+	rep, err := localClient.DebugDial(ctx, host, uint16(port))
+	if err != nil {
+		return err
+	}
+	if debugDialArgs.json {
+		fmt.Printf("%s\n", must.Get(json.MarshalIndent(rep, "", " ")))
+	} else {
+		for _, st := range rep.Stages {
+			status, msg := "ok", st.Info
+			if st.Err != "" {
+				status, msg = "FAIL", st.Err
+			}
+			fmt.Printf("%-9s %-4s %7.1fms  %s\n", st.Name, status, st.LatencySeconds*1000, msg)
+		}
+	}
+	if st, ok := rep.FirstErr(); ok {
+		return fmt.Errorf("dial failed at %s stage: %s", st.Name, st.Err)
+	}
+	return nil
+}
+
+var debugResolveRouteArgs struct {
+	json bool
+}
+
+func runDebugResolveRoute(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: debug resolve-route <ip>")
+	}
+	ip, err := netip.ParseAddr(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid IP %q", args[0])
+	}
+	rep, err := localClient.DebugResolveRoute(ctx, ip)
+	if err != nil {
+		return err
+	}
+	if debugResolveRouteArgs.json {
+		fmt.Printf("%s\n", must.Get(json.MarshalIndent(rep, "", " ")))
+		return nil
+	}
+	switch rep.Via {
+	case "local":
+		fmt.Printf("%v: this node\n", rep.IP)
+	case "peer":
+		fmt.Printf("%v: peer %s\n", rep.IP, rep.Peer)
+	case "subnet":
+		fmt.Printf("%v: subnet router %s, route %v\n", rep.IP, rep.Peer, rep.Route)
+	case "exit-node":
+		fmt.Printf("%v: exit node %s\n", rep.IP, rep.Peer)
+	case "lan":
+		fmt.Printf("%v: local network %v, outside the tunnel\n", rep.IP, rep.Route)
+	case "outside":
+		fmt.Printf("%v: outside the tunnel\n", rep.IP)
+	case "drop":
+		fmt.Printf("%v: dropped\n", rep.IP)
+	default:
+		fmt.Printf("%v: %s\n", rep.IP, rep.Via)
+	}
+	for _, r := range rep.Reasons {
+		fmt.Printf("  - %s\n", r)
+	}
+	return nil
+}
+
 var setExpireArgs struct {
 	in time.Duration
 }
@@ -811,14 +1145,26 @@ func runCapture(ctx context.Context, args []string) error {
 	duration time.Duration
 	gwSelf   string
 	ty       string
+	hold     time.Duration
+	json     bool
 }
 
 func debugPortmap(ctx context.Context, args []string) error {
-	rc, err := localClient.DebugPortmap(ctx,
-		debugPortmapArgs.duration,
-		debugPortmapArgs.ty,
-		debugPortmapArgs.gwSelf,
-	)
+	opts := tailscale.DebugPortmapOpts{
+		Duration:       debugPortmapArgs.duration,
+		Type:           debugPortmapArgs.ty,
+		GatewayAndSelf: debugPortmapArgs.gwSelf,
+		Hold:           debugPortmapArgs.hold,
+	}
+	if debugPortmapArgs.json {
+		rep, err := localClient.DebugPortmapReport(ctx, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", must.Get(json.MarshalIndent(rep, "", "\t")))
+		return nil
+	}
+	rc, err := localClient.DebugPortmapWithOpts(ctx, opts)
 	if err != nil {
 		return err
 	}
@@ -885,3 +1231,430 @@ func runPeerEndpointChanges(ctx context.Context, args []string) error {
 	fmt.Printf("%s", dst.String())
 	return nil
 }
+
+var suggestedRoutesArgs struct {
+	start     bool
+	stop      bool
+	advertise bool
+	json      bool
+}
+
+func runSuggestedRoutes(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if suggestedRoutesArgs.start && suggestedRoutesArgs.stop {
+		return errors.New("--start and --stop are mutually exclusive")
+	}
+	var res *apitype.SuggestedRoutesResponse
+	var err error
+	switch {
+	case suggestedRoutesArgs.start:
+		res, err = localClient.DebugSetObserveRoutes(ctx, true)
+	case suggestedRoutesArgs.stop:
+		// Fetch what was observed before it's discarded.
+		if res, err = localClient.DebugSuggestedRoutes(ctx); err == nil {
+			_, err = localClient.DebugSetObserveRoutes(ctx, false)
+		}
+	default:
+		res, err = localClient.DebugSuggestedRoutes(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	if suggestedRoutesArgs.advertise {
+		if err := advertiseSuggestedRoutes(ctx, res.Routes); err != nil {
+			return err
+		}
+	}
+	if suggestedRoutesArgs.json {
+		j, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	if suggestedRoutesArgs.start {
+		outln("Observing traffic. Run \"tailscale debug suggested-routes\" later to see suggested routes.")
+		return nil
+	}
+	if !res.Observing && !suggestedRoutesArgs.stop {
+		return errors.New("not observing traffic; use --start to start")
+	}
+	Stdout.Write(formatSuggestedRoutes(res.Routes))
+	return nil
+}
+
+// advertiseSuggestedRoutes adds the routes not already covered by an
+// advertised route to the advertised routes.
+func advertiseSuggestedRoutes(ctx context.Context, routes []apitype.SuggestedRoute) error {
+	prefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return err
+	}
+	mp := &ipn.MaskedPrefs{AdvertiseRoutesSet: true}
+	mp.AdvertiseRoutes = append(mp.AdvertiseRoutes, prefs.AdvertiseRoutes...)
+	var added []string
+	for _, r := range routes {
+		if !r.CoveredBy.IsValid() {
+			mp.AdvertiseRoutes = append(mp.AdvertiseRoutes, r.Route)
+			added = append(added, r.Route.String())
+		}
+	}
+	if len(added) == 0 {
+		return nil
+	}
+	if _, err := localClient.EditPrefs(ctx, mp); err != nil {
+		return err
+	}
+	if !suggestedRoutesArgs.json {
+		printf("Advertising %s\n", strings.Join(added, ", "))
+	}
+	return nil
+}
+
+// formatSuggestedRoutes formats routes as a table for
+// "tailscale debug suggested-routes".
+func formatSuggestedRoutes(routes []apitype.SuggestedRoute) []byte {
+	if len(routes) == 0 {
+		return []byte("No traffic to or from private subnets observed.\n")
+	}
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tPACKETS\tBYTES\tLAST SEEN\tCOVERED BY")
+	for _, r := range routes {
+		covered := "-"
+		if r.CoveredBy.IsValid() {
+			covered = r.CoveredBy.String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\n", r.Route, r.Packets, r.Bytes, r.LastSeen.Format(time.RFC3339), covered)
+	}
+	tw.Flush()
+	return b.Bytes()
+}
+
+var speedTestArgs struct {
+	udp      bool
+	upload   bool
+	duration time.Duration
+	bitrate  float64 // Mbit/s
+	json     bool
+}
+
+func runDebugSpeedTest(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: tailscale debug speedtest <hostname-or-IP>")
+	}
+	if d := speedTestArgs.duration; d < speedtest.MinDuration || d > speedtest.MaxDuration {
+		return fmt.Errorf("--duration must be within %v and %v", speedtest.MinDuration, speedtest.MaxDuration)
+	}
+	if speedTestArgs.bitrate <= 0 {
+		return errors.New("--bitrate must be positive")
+	}
+	ipStr, self, err := tailscaleIPFromArg(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if self {
+		return errors.New("can't run a speedtest with this node itself")
+	}
+	ip, err := netip.ParseAddr(ipStr)
+	if err != nil {
+		return err
+	}
+	proto := speedtest.TCP
+	var bitrate int64
+	if speedTestArgs.udp {
+		proto = speedtest.UDP
+		bitrate = int64(speedTestArgs.bitrate * 1e6)
+	}
+	if !speedTestArgs.json {
+		dir := "download from"
+		if speedTestArgs.upload {
+			dir = "upload to"
+		}
+		printf("Running a %v %s %s test with %v...\n", speedTestArgs.duration, strings.ToUpper(string(proto)), dir, args[0])
+	}
+	res, err := localClient.SpeedTest(ctx, ip, string(proto), speedTestArgs.upload, speedTestArgs.duration, bitrate)
+	if err != nil {
+		return err
+	}
+	if speedTestArgs.json {
+		j, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	Stdout.Write(formatSpeedTest(res))
+	return nil
+}
+
+// formatSpeedTest formats the results of a speedtest as a table of the
+// throughput of each interval, followed by the total and path.
+func formatSpeedTest(res *apitype.SpeedTestResponse) []byte {
+	var b bytes.Buffer
+	if len(res.Results) == 0 {
+		b.WriteString("No traffic was received.\n")
+	} else {
+		tw := tabwriter.NewWriter(&b, 0, 2, 2, ' ', 0)
+		fmt.Fprintln(tw, "INTERVAL\tTRANSFER\tBITRATE")
+		start := res.Results[0].IntervalStart
+		for _, r := range res.Results {
+			when := fmt.Sprintf("%.2f-%.2f s", r.IntervalStart.Sub(start).Seconds(), r.IntervalEnd.Sub(start).Seconds())
+			if r.Total {
+				when = "total " + when
+			}
+			fmt.Fprintf(tw, "%s\t%.2f MB\t%.2f Mbit/s\n", when, r.MegaBytes(), r.MBitsPerSecond())
+		}
+		tw.Flush()
+	}
+	if res.UDP != nil && res.UDP.Sent > 0 {
+		fmt.Fprintf(&b, "Lost %d/%d packets (%.2f%%)\n", res.UDP.Lost(), res.UDP.Sent, 100*float64(res.UDP.Lost())/float64(res.UDP.Sent))
+	}
+	fmt.Fprintf(&b, "Path: %s\n", res.Path)
+	return b.Bytes()
+}
+
+var latencyMatrixArgs struct {
+	tag      string
+	allPairs bool
+	json     bool
+}
+
+func runDebugLatencyMatrix(ctx context.Context, args []string) error {
+	var ips []netip.Addr
+	seen := map[netip.Addr]bool{}
+	add := func(ip netip.Addr) {
+		if !seen[ip] {
+			seen[ip] = true
+			ips = append(ips, ip)
+		}
+	}
+	for _, arg := range args {
+		ipStr, self, err := tailscaleIPFromArg(ctx, arg)
+		if err != nil {
+			return err
+		}
+		if self {
+			return fmt.Errorf("%s is this node; it's always measured from", arg)
+		}
+		ip, err := netip.ParseAddr(ipStr)
+		if err != nil {
+			return err
+		}
+		add(ip)
+	}
+	if tag := latencyMatrixArgs.tag; tag != "" {
+		if err := tailcfg.CheckTag(tag); err != nil {
+			return fmt.Errorf("invalid --tag: %w", err)
+		}
+		st, err := localClient.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, ps := range st.Peer {
+			if ps.Online && ps.Tags != nil && views.SliceContains(*ps.Tags, tag) && len(ps.TailscaleIPs) > 0 {
+				add(ps.TailscaleIPs[0])
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return errors.New("usage: tailscale debug latency-matrix [--tag=tag:x] [--all-pairs] [--json] [<hostname-or-IP>...]")
+	}
+	res, err := localClient.LatencyMatrix(ctx, ips, latencyMatrixArgs.allPairs)
+	if err != nil {
+		return err
+	}
+	if latencyMatrixArgs.json {
+		j, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	Stdout.Write(formatLatencyMatrix(res))
+	return nil
+}
+
+// formatLatencyMatrix formats a latency matrix as a table with a row per
+// node that measured and a column per node measured, followed by the
+// errors.
+func formatLatencyMatrix(m *apitype.LatencyMatrix) []byte {
+	// The columns are the nodes in the order they first appear.
+	var cols []string
+	colName := map[string]string{}
+	addCol := func(ip, name string) {
+		if _, ok := colName[ip]; !ok {
+			cols = append(cols, ip)
+		}
+		if name != "" || colName[ip] == "" {
+			colName[ip] = latencyNodeName(ip, name)
+		}
+	}
+	for _, row := range m.Rows {
+		addCol(row.From, row.FromName)
+	}
+	for _, row := range m.Rows {
+		for _, r := range row.Results {
+			addCol(r.To, r.ToName)
+		}
+	}
+
+	var b bytes.Buffer
+	var errs []string
+	tw := tabwriter.NewWriter(&b, 0, 2, 2, ' ', 0)
+	fmt.Fprint(tw, "FROM \\ TO")
+	for _, ip := range cols {
+		fmt.Fprintf(tw, "\t%s", colName[ip])
+	}
+	fmt.Fprintln(tw)
+	for _, row := range m.Rows {
+		from := latencyNodeName(row.From, row.FromName)
+		fmt.Fprint(tw, from)
+		if row.Err != "" {
+			errs = append(errs, fmt.Sprintf("%s: %s", from, row.Err))
+		}
+		results := map[string]apitype.LatencyResult{}
+		for _, r := range row.Results {
+			results[r.To] = r
+		}
+		for _, ip := range cols {
+			r, ok := results[ip]
+			switch {
+			case ip == row.From || r.Path == "self":
+				fmt.Fprint(tw, "\t-")
+			case !ok:
+				fmt.Fprint(tw, "\t")
+			case r.Err != "":
+				fmt.Fprint(tw, "\terror")
+				errs = append(errs, fmt.Sprintf("%s to %s: %s", from, colName[ip], r.Err))
+			case strings.HasPrefix(r.Path, "DERP("):
+				fmt.Fprintf(tw, "\t%.1fms %s", r.LatencySeconds*1000, r.Path)
+			default:
+				fmt.Fprintf(tw, "\t%.1fms", r.LatencySeconds*1000)
+			}
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	for _, e := range errs {
+		fmt.Fprintf(&b, "error: %s\n", e)
+	}
+	return b.Bytes()
+}
+
+// latencyNodeName returns the short name of a node of a latency matrix,
+// or its IP if it has no name.
+func latencyNodeName(ip, name string) string {
+	if name == "" {
+		return ip
+	}
+	return dnsname.FirstLabel(name)
+}
+
+func runDebugControlBackoff(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	st, err := localClient.ControlBackoff(ctx)
+	if err != nil {
+		return err
+	}
+	Stdout.Write(formatControlBackoff(st))
+	return nil
+}
+
+// formatControlBackoff returns a table of the backoff schedules st.
+func formatControlBackoff(st []backoff.State) []byte {
+	var buf bytes.Buffer
+	tw := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tFAILURES\tLAST DELAY\tRETRY IN\tMAX\tJITTER")
+	for _, s := range st {
+		retry := "-"
+		if s.Remaining > 0 {
+			retry = s.Remaining.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%v\t%s\t%v\t%.0f%%\n", s.Name, s.Failures, s.LastDelay.Round(time.Millisecond), retry, s.MaxBackoff, s.Jitter*100)
+	}
+	tw.Flush()
+	return buf.Bytes()
+}
+
+var filterTraceArgs struct {
+	forDur time.Duration
+	ip     string
+	json   bool
+}
+
+func runDebugFilterTrace(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	if filterTraceArgs.forDur <= 0 {
+		return errors.New("--for must be positive")
+	}
+	var ip netip.Addr
+	if filterTraceArgs.ip != "" {
+		s, _, err := tailscaleIPFromArg(ctx, filterTraceArgs.ip)
+		if err != nil {
+			return err
+		}
+		if ip, err = netip.ParseAddr(s); err != nil {
+			return err
+		}
+	}
+	if err := localClient.StartFilterTrace(ctx, filterTraceArgs.forDur, ip); err != nil {
+		return err
+	}
+	defer func() {
+		// Stop the trace if interrupted before it ends.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		localClient.StartFilterTrace(ctx, 0, netip.Addr{})
+	}()
+	end := time.Now().Add(filterTraceArgs.forDur)
+	var last time.Time // of the last decision printed
+	for {
+		done := !time.Now().Before(end)
+		trace, err := localClient.FilterTrace(ctx)
+		if err != nil {
+			return err
+		}
+		for _, e := range trace {
+			if !e.Time.After(last) {
+				continue
+			}
+			last = e.Time
+			if filterTraceArgs.json {
+				j, err := json.Marshal(e)
+				if err != nil {
+					return err
+				}
+				outln(string(j))
+			} else {
+				outln(formatFilterTraceEntry(e))
+			}
+		}
+		if done {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// formatFilterTraceEntry returns the one-line summary of e.
+func formatFilterTraceEntry(e filter.TraceEntry) string {
+	s := fmt.Sprintf("%s %s %-3s %v: %s", e.Time.Format("15:04:05.000"), e.Verdict, e.Dir, e.Flow, e.Why)
+	if e.Rule != "" {
+		s += " by rule " + e.Rule
+	}
+	return s
+}
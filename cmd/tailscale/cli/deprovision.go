@@ -0,0 +1,100 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
+)
+
+var deprovisionCmd = &ffcli.Command{
+	Name:       "deprovision",
+	ShortUsage: "deprovision [flags]",
+	ShortHelp:  "Log out of and remove all profiles, to decommission a machine",
+
+	LongHelp: strings.TrimSpace(`
+"tailscale deprovision" logs out of every profile and deletes them,
+including their saved state, leaving tailscaled with nothing to
+configure. That removes the routes, firewall rules and DNS
+configuration it installed. It doesn't prompt, so it can be used
+in fleet decommissioning scripts.
+
+With --delete-node, it also deletes each profile's node from its
+tailnet using the API key given by --api-key, so the machines vanish
+from the admin console instead of lingering as expired.
+
+To remove everything else, stop tailscaled and run
+"tailscaled --cleanup" (the systemd unit does this on stop).
+`),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("deprovision")
+		fs.BoolVar(&deprovisionArgs.deleteNode, "delete-node", false, "also delete each profile's node via the API")
+		fs.StringVar(&deprovisionArgs.apiKeyOrFile, "api-key", "", `API key for --delete-node; if it begins with "file:", then it's a path to a file containing the key`)
+		return fs
+	})(),
+	Exec: runDeprovision,
+}
+
+var deprovisionArgs struct {
+	deleteNode   bool
+	apiKeyOrFile string
+}
+
+func runDeprovision(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return fmt.Errorf("too many non-flag arguments: %q", args)
+	}
+	var api *tailscale.Client
+	if deprovisionArgs.deleteNode {
+		key, err := deprovisionAPIKey()
+		if err != nil {
+			return err
+		}
+		tailscale.I_Acknowledge_This_API_Is_Unstable = true
+		api = tailscale.NewClient("-", tailscale.APIKey(key))
+	}
+
+	_, all, err := localClient.ProfileStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range all {
+		if api != nil && p.NodeID != "" {
+			if err := api.DeleteDevice(ctx, string(p.NodeID)); err != nil {
+				return fmt.Errorf("deleting node %v of profile %q: %w", p.NodeID, p.Name, err)
+			}
+			printf("Deleted node %v\n", p.NodeID)
+		}
+		if err := logoutProfile(ctx, p); err != nil {
+			return err
+		}
+		if err := localClient.DeleteProfile(ctx, p.ID); err != nil {
+			return fmt.Errorf("deleting profile %q: %w", p.Name, err)
+		}
+		printf("Removed profile %q\n", p.Name)
+	}
+	return nil
+}
+
+func deprovisionAPIKey() (string, error) {
+	v := deprovisionArgs.apiKeyOrFile
+	if file, ok := strings.CutPrefix(v, "file:"); ok {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		v = strings.TrimSpace(string(b))
+	}
+	if v == "" {
+		return "", errors.New("--delete-node requires --api-key")
+	}
+	return v, nil
+}
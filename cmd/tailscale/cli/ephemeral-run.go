@@ -0,0 +1,312 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+)
+
+var ephemeralRunCmd = &ffcli.Command{
+	Name:       "ephemeral-run",
+	ShortUsage: "ephemeral-run [flags] -- <command> [args...]",
+	ShortHelp:  "Run a command with access to the tailnet from a temporary node",
+	LongHelp: strings.TrimSpace(`
+The 'ephemeral-run' command starts a separate userspace-networking tailscaled
+that joins the tailnet as an ephemeral node, using the auth key in the
+TS_AUTHKEY environment variable. It then runs the command with the
+ALL_PROXY, HTTP_PROXY and HTTPS_PROXY environment variables (and their lower
+case versions) set to the node's SOCKS5 and HTTP proxies, so that programs
+that honor them can reach the tailnet. When the command exits, the node is
+logged out, which removes it from the tailnet, and tailscaled is stopped.
+
+It doesn't use or require the system's tailscaled, nor root, which makes it
+suitable for CI jobs that need tailnet access. The exit code is that of the
+command.
+`),
+	Exec: runEphemeralRun,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("ephemeral-run")
+		fs.StringVar(&ephemeralRunArgs.tailscaled, "tailscaled", "", "path to the tailscaled binary; defaults to the one next to this binary, or in $PATH")
+		fs.StringVar(&ephemeralRunArgs.server, "login-server", ipn.DefaultControlURL, "base URL of control server")
+		fs.StringVar(&ephemeralRunArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
+		fs.StringVar(&ephemeralRunArgs.advertiseTags, "advertise-tags", "", "comma-separated ACL tags to request; each must start with \"tag:\" (e.g. \"tag:ci\")")
+		fs.BoolVar(&ephemeralRunArgs.acceptRoutes, "accept-routes", false, "accept routes advertised by other Tailscale nodes")
+		fs.StringVar(&ephemeralRunArgs.socksAddr, "socks5-server", "", "[ip]:port to run the SOCKS5 proxy on; defaults to a free localhost port")
+		fs.StringVar(&ephemeralRunArgs.httpProxyAddr, "http-proxy", "", "[ip]:port to run the HTTP proxy on; defaults to a free localhost port")
+		fs.DurationVar(&ephemeralRunArgs.timeout, "timeout", 2*time.Minute, "maximum amount of time to wait for the node to come up")
+		fs.BoolVar(&ephemeralRunArgs.verbose, "verbose", false, "show tailscaled's logs on stderr")
+		return fs
+	})(),
+}
+
+var ephemeralRunArgs struct {
+	tailscaled    string
+	server        string
+	hostname      string
+	advertiseTags string
+	acceptRoutes  bool
+	socksAddr     string
+	httpProxyAddr string
+	timeout       time.Duration
+	verbose       bool
+}
+
+func runEphemeralRun(ctx context.Context, args []string) error {
+	code, err := ephemeralRun(ctx, args)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		os.Exit(code)
+	}
+	return nil
+}
+
+// ephemeralRun runs "tailscale ephemeral-run" and returns the exit code of
+// the command, once the node it brought up for it is torn down.
+func ephemeralRun(ctx context.Context, args []string) (exitCode int, err error) {
+	if len(args) == 0 {
+		return 0, errors.New("usage: tailscale ephemeral-run [flags] -- <command> [args...]")
+	}
+	authKey := os.Getenv("TS_AUTHKEY")
+	if authKey == "" {
+		return 0, errors.New("TS_AUTHKEY must be set to an auth key to join the tailnet with")
+	}
+	var tags []string
+	if ephemeralRunArgs.advertiseTags != "" {
+		tags = strings.Split(ephemeralRunArgs.advertiseTags, ",")
+		for _, tag := range tags {
+			if err := tailcfg.CheckTag(tag); err != nil {
+				return 0, fmt.Errorf("tag: %q: %s", tag, err)
+			}
+		}
+	}
+	tailscaled, err := findTailscaled(ephemeralRunArgs.tailscaled)
+	if err != nil {
+		return 0, err
+	}
+	socksAddr, err := freeLocalAddr(ephemeralRunArgs.socksAddr)
+	if err != nil {
+		return 0, err
+	}
+	httpProxyAddr, err := freeLocalAddr(ephemeralRunArgs.httpProxyAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	dir, err := os.MkdirTemp("", "tailscale-ephemeral-run")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "tailscaled.sock")
+	if runtime.GOOS == "windows" {
+		socket = fmt.Sprintf(`\\.\pipe\tailscale-ephemeral-run-%d`, os.Getpid())
+	}
+
+	daemon := exec.Command(tailscaled,
+		"--tun=userspace-networking",
+		"--state=mem:",
+		"--statedir="+dir,
+		"--socket="+socket,
+		"--port=0",
+		"--socks5-server="+socksAddr,
+		"--outbound-http-proxy-listen="+httpProxyAddr,
+	)
+	if ephemeralRunArgs.verbose {
+		daemon.Stdout = os.Stderr
+		daemon.Stderr = os.Stderr
+	}
+	if err := daemon.Start(); err != nil {
+		return 0, fmt.Errorf("starting tailscaled: %w", err)
+	}
+	daemonDone := make(chan struct{})
+	go func() {
+		daemon.Wait()
+		close(daemonDone)
+	}()
+	defer stopTailscaled(daemon, daemonDone)
+
+	lc := &tailscale.LocalClient{Socket: socket, UseSocketOnly: true}
+	upCtx, cancel := context.WithTimeout(ctx, ephemeralRunArgs.timeout)
+	defer cancel()
+	if err := ephemeralUp(upCtx, lc, daemonDone, authKey, tags); err != nil {
+		return 0, err
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := lc.Logout(ctx); err != nil {
+			errf("logging out the ephemeral node: %v\n", err)
+		}
+	}()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), proxyEnv(socksAddr, httpProxyAddr)...)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	// Forward interrupts to the command rather than dying on them, so that
+	// the node is still torn down afterwards.
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigc)
+	cmdDone := make(chan error, 1)
+	go func() { cmdDone <- cmd.Wait() }()
+	for {
+		select {
+		case sig := <-sigc:
+			cmd.Process.Signal(sig)
+		case err := <-cmdDone:
+			var ee *exec.ExitError
+			if errors.As(err, &ee) {
+				return ee.ExitCode(), nil
+			}
+			return 0, err
+		}
+	}
+}
+
+// ephemeralUp waits for the tailscaled started by ephemeral-run to listen
+// on lc's socket, then logs it in with authKey and waits for it to be
+// running. daemonDone is closed if tailscaled exits.
+func ephemeralUp(ctx context.Context, lc *tailscale.LocalClient, daemonDone <-chan struct{}, authKey string, tags []string) error {
+	var watcher *tailscale.IPNBusWatcher
+	for {
+		var err error
+		watcher, err = lc.WatchIPNBus(ctx, ipn.NotifyInitialState)
+		if err == nil {
+			break
+		}
+		select {
+		case <-daemonDone:
+			return errors.New("tailscaled exited unexpectedly; use --verbose to see its logs")
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for tailscaled to start: %w", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	defer watcher.Close()
+
+	prefs := ipn.NewPrefs()
+	prefs.ControlURL = ephemeralRunArgs.server
+	prefs.WantRunning = true
+	prefs.Hostname = ephemeralRunArgs.hostname
+	prefs.AdvertiseTags = tags
+	prefs.RouteAll = ephemeralRunArgs.acceptRoutes
+	if err := lc.Start(ctx, ipn.Options{AuthKey: authKey, UpdatePrefs: prefs}); err != nil {
+		return err
+	}
+	for {
+		n, err := watcher.Next()
+		if err != nil {
+			if ctx.Err() != nil {
+				return errors.New("timeout waiting for the node to come up")
+			}
+			return err
+		}
+		if n.ErrMessage != nil {
+			return fmt.Errorf("backend error: %v", *n.ErrMessage)
+		}
+		if n.State != nil {
+			switch *n.State {
+			case ipn.Running:
+				return nil
+			case ipn.NeedsMachineAuth:
+				return errors.New("the node needs to be approved by an admin; use a pre-approved auth key")
+			}
+		}
+		if n.BrowseToURL != nil {
+			return errors.New("the auth key was not accepted; interactive login is not supported")
+		}
+	}
+}
+
+// stopTailscaled stops the tailscaled started by ephemeral-run, whose Wait
+// closes done, killing it if it doesn't exit in time.
+func stopTailscaled(daemon *exec.Cmd, done <-chan struct{}) {
+	if runtime.GOOS == "windows" {
+		daemon.Process.Kill()
+	} else {
+		daemon.Process.Signal(syscall.SIGTERM)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		daemon.Process.Kill()
+		<-done
+	}
+}
+
+// findTailscaled returns the path of the tailscaled binary to run, which is
+// path if non-empty, else the tailscaled next to this binary or in $PATH.
+func findTailscaled(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	name := "tailscaled"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	if exe, err := os.Executable(); err == nil {
+		p := filepath.Join(filepath.Dir(exe), name)
+		if st, err := os.Stat(p); err == nil && st.Mode().IsRegular() {
+			return p, nil
+		}
+	}
+	p, err := exec.LookPath(name)
+	if err != nil {
+		return "", errors.New("tailscaled not found; use --tailscaled to specify its path")
+	}
+	return p, nil
+}
+
+// freeLocalAddr returns addr if non-empty, else the address of a currently
+// free localhost TCP port.
+func freeLocalAddr(addr string) (string, error) {
+	if addr != "" {
+		return addr, nil
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer ln.Close()
+	return ln.Addr().String(), nil
+}
+
+// proxyEnv returns the environment variables that point programs at the
+// SOCKS5 proxy at socksAddr and the HTTP proxy at httpProxyAddr.
+func proxyEnv(socksAddr, httpProxyAddr string) []string {
+	var env []string
+	add := func(k, v string) {
+		env = append(env, k+"="+v, strings.ToLower(k)+"="+v)
+	}
+	// socks5h, so that the proxy resolves names, including MagicDNS ones.
+	add("ALL_PROXY", "socks5h://"+socksAddr)
+	add("HTTP_PROXY", "http://"+httpProxyAddr)
+	add("HTTPS_PROXY", "http://"+httpProxyAddr)
+	return env
+}
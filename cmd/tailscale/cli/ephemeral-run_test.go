@@ -0,0 +1,24 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxyEnv(t *testing.T) {
+	got := proxyEnv("127.0.0.1:1055", "127.0.0.1:1056")
+	want := []string{
+		"ALL_PROXY=socks5h://127.0.0.1:1055",
+		"all_proxy=socks5h://127.0.0.1:1055",
+		"HTTP_PROXY=http://127.0.0.1:1056",
+		"http_proxy=http://127.0.0.1:1056",
+		"HTTPS_PROXY=http://127.0.0.1:1056",
+		"https_proxy=http://127.0.0.1:1056",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("proxyEnv = %q; want %q", got, want)
+	}
+}
@@ -17,6 +17,7 @@
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -53,148 +54,320 @@
 type countingReader struct {
 	io.Reader
 	n atomic.Uint64
+
+	// total, if non-nil, is also incremented by the number of bytes read,
+	// to track the progress of several transfers together.
+	total *atomic.Uint64
 }
 
 func (c *countingReader) Read(buf []byte) (int, error) {
 	n, err := c.Reader.Read(buf)
 	c.n.Add(uint64(n))
+	if c.total != nil {
+		c.total.Add(uint64(n))
+	}
 	return n, err
 }
 
 var fileCpCmd = &ffcli.Command{
 	Name:       "cp",
-	ShortUsage: "file cp <files...> <target>:",
+	ShortUsage: "file cp <files...> <target>: [<target>:...]",
 	ShortHelp:  "Copy file(s) to a host",
-	Exec:       runCp,
+	LongHelp: strings.TrimSpace(`
+"tailscale file cp" sends files to one or more of your devices using
+Taildrop. If several targets are given, the files are sent to all of
+them concurrently.
+`),
+	Exec: runCp,
 	FlagSet: (func() *flag.FlagSet {
 		fs := newFlagSet("cp")
 		fs.StringVar(&cpArgs.name, "name", "", "alternate filename to use, especially useful when <file> is \"-\" (stdin)")
 		fs.BoolVar(&cpArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&cpArgs.targets, "targets", false, "list possible file cp targets")
+		fs.Var(&cpArgs.limitRate, "limit-rate", "maximum total sending rate in bytes per second, with an optional K, M or G suffix (e.g. \"500K\"); empty means unlimited")
 		return fs
 	})(),
 }
 
 var cpArgs struct {
-	name    string
-	verbose bool
-	targets bool
+	name      string
+	verbose   bool
+	targets   bool
+	limitRate byteRate
+}
+
+// cpTarget is a resolved destination of "tailscale file cp".
+type cpTarget struct {
+	arg      string // as given on the command line, without the colon
+	ip       string
+	stableID tailcfg.StableNodeID
 }
 
 func runCp(ctx context.Context, args []string) error {
 	if cpArgs.targets {
 		return runCpTargets(ctx, args)
 	}
-	if len(args) < 2 {
-		return errors.New("usage: tailscale file cp <files...> <target>:")
-	}
-	files, target := args[:len(args)-1], args[len(args)-1]
-	target, ok := strings.CutSuffix(target, ":")
-	if !ok {
+	files, targetArgs := splitCpArgs(args)
+	if len(targetArgs) == 0 && len(args) >= 2 {
 		return fmt.Errorf("final argument to 'tailscale file cp' must end in colon")
 	}
+	if len(files) == 0 || len(targetArgs) == 0 {
+		return errors.New("usage: tailscale file cp <files...> <target>: [<target>:...]")
+	}
+
+	var targets []cpTarget
+	for _, arg := range targetArgs {
+		t, err := resolveCpTarget(ctx, arg)
+		if err != nil {
+			return err
+		}
+		targets = append(targets, t)
+	}
+
+	if len(files) > 1 && cpArgs.name != "" {
+		return errors.New("can't use --name= with multiple files")
+	}
+	for _, fileArg := range files {
+		if fileArg != "-" {
+			continue
+		}
+		if len(files) > 1 {
+			return errors.New("can't use '-' as STDIN file when providing filename arguments")
+		}
+		if len(targets) > 1 {
+			return errors.New("can't send STDIN to multiple targets")
+		}
+	}
+
+	var rl *rate.Limiter
+	if cpArgs.limitRate > 0 {
+		rl = rate.NewLimiter(rate.Limit(cpArgs.limitRate), rateLimitBurst)
+	}
+
+	if len(targets) == 1 {
+		return sendFiles(ctx, targets[0], files, rl, isatty.IsTerminal(os.Stderr.Fd()), nil)
+	}
+
+	// Send to all targets concurrently, showing their combined progress.
+	var total atomic.Uint64
+	var (
+		done = make(chan struct{}, 1)
+		wg   sync.WaitGroup
+	)
+	if isatty.IsTerminal(os.Stderr.Fd()) {
+		size := int64(0)
+		for _, fileArg := range files {
+			fi, err := os.Stat(fileArg)
+			if err != nil {
+				size = -1
+				break
+			}
+			size += fi.Size()
+		}
+		if size >= 0 {
+			size *= int64(len(targets))
+		}
+		name := fmt.Sprintf("%d file(s) to %d targets", len(files), len(targets))
+		wg.Add(1)
+		go printProgress(&wg, done, total.Load, name, size)
+	}
+	errs := make([]error, len(targets))
+	var sendWG sync.WaitGroup
+	for i, t := range targets {
+		i, t := i, t
+		sendWG.Add(1)
+		go func() {
+			defer sendWG.Done()
+			errs[i] = sendFiles(ctx, t, files, rl, false, &total)
+		}()
+	}
+	sendWG.Wait()
+	done <- struct{}{}
+	wg.Wait()
+
+	var failed int
+	for i, err := range errs {
+		if err != nil {
+			failed++
+			fmt.Fprintf(Stderr, "sending to %s: %v\n", targets[i].arg, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to send to %d of %d targets", failed, len(targets))
+	}
+	return nil
+}
+
+// splitCpArgs splits the arguments of "tailscale file cp" into the files
+// to send and the targets, which are the trailing arguments ending in a
+// colon. The returned targets have their colons removed.
+func splitCpArgs(args []string) (files, targets []string) {
+	i := len(args)
+	for i > 0 && strings.HasSuffix(args[i-1], ":") {
+		i--
+	}
+	for _, t := range args[i:] {
+		targets = append(targets, strings.TrimSuffix(t, ":"))
+	}
+	return args[:i], targets
+}
+
+// resolveCpTarget resolves the "tailscale file cp" target arg, which has
+// already had its trailing colon removed.
+func resolveCpTarget(ctx context.Context, arg string) (cpTarget, error) {
+	target := arg
 	hadBrackets := false
 	if strings.HasPrefix(target, "[") && strings.HasSuffix(target, "]") {
 		hadBrackets = true
 		target = strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
 	}
 	if ip, err := netip.ParseAddr(target); err == nil && ip.Is6() && !hadBrackets {
-		return fmt.Errorf("an IPv6 literal must be written as [%s]", ip)
+		return cpTarget{}, fmt.Errorf("an IPv6 literal must be written as [%s]", ip)
 	} else if hadBrackets && (err != nil || !ip.Is6()) {
-		return errors.New("unexpected brackets around target")
+		return cpTarget{}, errors.New("unexpected brackets around target")
 	}
 	ip, _, err := tailscaleIPFromArg(ctx, target)
 	if err != nil {
-		return err
+		return cpTarget{}, err
 	}
 
 	stableID, isOffline, err := getTargetStableID(ctx, ip)
 	if err != nil {
-		return fmt.Errorf("can't send to %s: %v", target, err)
+		return cpTarget{}, fmt.Errorf("can't send to %s: %v", target, err)
 	}
 	if isOffline {
 		fmt.Fprintf(Stderr, "# warning: %s is offline\n", target)
 	}
+	return cpTarget{arg: target, ip: ip, stableID: stableID}, nil
+}
 
-	if len(files) > 1 {
-		if cpArgs.name != "" {
-			return errors.New("can't use --name= with multiple files")
-		}
-		for _, fileArg := range files {
-			if fileArg == "-" {
-				return errors.New("can't use '-' as STDIN file when providing filename arguments")
-			}
+// sendFiles sends files to t, one at a time. If rl is non-nil, reads of
+// the files are limited by it. If showProgress, a progress line is shown
+// for each file. If total is non-nil, it's incremented as file contents
+// are sent.
+func sendFiles(ctx context.Context, t cpTarget, files []string, rl *rate.Limiter, showProgress bool, total *atomic.Uint64) error {
+	for _, fileArg := range files {
+		if err := sendFile(ctx, t, fileArg, rl, showProgress, total); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	for _, fileArg := range files {
-		var fileContents *countingReader
-		var name = cpArgs.name
-		var contentLength int64 = -1
-		if fileArg == "-" {
-			fileContents = &countingReader{Reader: os.Stdin}
-			if name == "" {
-				name, fileContents, err = pickStdinFilename()
-				if err != nil {
-					return err
-				}
-			}
-		} else {
-			f, err := os.Open(fileArg)
-			if err != nil {
-				if version.IsSandboxedMacOS() {
-					return errors.New("the GUI version of Tailscale on macOS runs in a macOS sandbox that can't read files")
-				}
-				return err
-			}
-			defer f.Close()
-			fi, err := f.Stat()
+func sendFile(ctx context.Context, t cpTarget, fileArg string, rl *rate.Limiter, showProgress bool, total *atomic.Uint64) error {
+	var r io.Reader
+	var name = cpArgs.name
+	var contentLength int64 = -1
+	if fileArg == "-" {
+		r = os.Stdin
+		if name == "" {
+			var err error
+			name, r, err = pickStdinFilename()
 			if err != nil {
 				return err
 			}
-			if fi.IsDir() {
-				return errors.New("directories not supported")
-			}
-			contentLength = fi.Size()
-			fileContents = &countingReader{Reader: io.LimitReader(f, contentLength)}
-			if name == "" {
-				name = filepath.Base(fileArg)
-			}
-
-			if envknob.Bool("TS_DEBUG_SLOW_PUSH") {
-				fileContents = &countingReader{Reader: &slowReader{r: fileContents}}
-			}
 		}
-
-		if cpArgs.verbose {
-			log.Printf("sending %q to %v/%v/%v ...", name, target, ip, stableID)
-		}
-
-		var (
-			done = make(chan struct{}, 1)
-			wg   sync.WaitGroup
-		)
-		if isatty.IsTerminal(os.Stderr.Fd()) {
-			go printProgress(&wg, done, fileContents, name, contentLength)
-			wg.Add(1)
+	} else {
+		f, err := os.Open(fileArg)
+		if err != nil {
+			if version.IsSandboxedMacOS() {
+				return errors.New("the GUI version of Tailscale on macOS runs in a macOS sandbox that can't read files")
+			}
+			return err
 		}
-
-		err := localClient.PushFile(ctx, stableID, contentLength, name, fileContents)
+		defer f.Close()
+		fi, err := f.Stat()
 		if err != nil {
 			return err
 		}
-		if cpArgs.verbose {
-			log.Printf("sent %q", name)
+		if fi.IsDir() {
+			return errors.New("directories not supported")
+		}
+		contentLength = fi.Size()
+		r = io.LimitReader(f, contentLength)
+		if name == "" {
+			name = filepath.Base(fileArg)
+		}
+
+		if envknob.Bool("TS_DEBUG_SLOW_PUSH") {
+			r = &slowReader{r: r}
 		}
-		done <- struct{}{}
-		wg.Wait()
 	}
+	if rl != nil {
+		r = &slowReader{r: r, rl: rl}
+	}
+	fileContents := &countingReader{Reader: r, total: total}
+
+	if cpArgs.verbose {
+		log.Printf("sending %q to %v/%v/%v ...", name, t.arg, t.ip, t.stableID)
+	}
+
+	var (
+		done = make(chan struct{}, 1)
+		wg   sync.WaitGroup
+	)
+	if showProgress {
+		wg.Add(1)
+		go printProgress(&wg, done, fileContents.n.Load, name, contentLength)
+	}
+
+	err := localClient.PushFile(ctx, t.stableID, contentLength, name, fileContents)
+	done <- struct{}{}
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if cpArgs.verbose {
+		log.Printf("sent %q to %v", name, t.arg)
+	}
+	return nil
+}
+
+// byteRate is a flag.Value for a rate in bytes per second, such as "500K".
+type byteRate float64
+
+func (r *byteRate) String() string {
+	if *r == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*r), 'f', -1, 64)
+}
+
+func (r *byteRate) Set(s string) error {
+	if s == "" {
+		*r = 0
+		return nil
+	}
+	mult := 1.0
+	switch s[len(s)-1] {
+	case 'k', 'K':
+		mult = 1 << 10
+	case 'm', 'M':
+		mult = 1 << 20
+	case 'g', 'G':
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return fmt.Errorf("invalid rate %q", s)
+	}
+	*r = byteRate(v * mult)
 	return nil
 }
 
+// rateLimitBurst is the maximum number of bytes read at once from a rate
+// limited file.
+const rateLimitBurst = 16 << 10
+
 const vtRestartLine = "\r\x1b[K"
 
-func printProgress(wg *sync.WaitGroup, done <-chan struct{}, r *countingReader, name string, contentLength int64) {
+// progressBarWidth is the width of the progress bar, in characters.
+const progressBarWidth = 20
+
+func printProgress(wg *sync.WaitGroup, done <-chan struct{}, bytesRead func() uint64, name string, contentLength int64) {
 	defer wg.Done()
 	var lastBytesRead uint64
 
@@ -204,7 +377,7 @@ func printProgress(wg *sync.WaitGroup, done <-chan struct{}, r *countingReader,
 			fmt.Fprintln(os.Stderr)
 			return
 		case <-time.After(time.Second):
-			n := r.n.Load()
+			n := bytesRead()
 			contentLengthStr := "???"
 			if contentLength > 0 {
 				contentLengthStr = fmt.Sprint(contentLength / 1024)
@@ -212,7 +385,7 @@ func printProgress(wg *sync.WaitGroup, done <-chan struct{}, r *countingReader,
 
 			fmt.Fprintf(os.Stderr, "%s%s\t\t%s", vtRestartLine, padTruncateString(name, 36), padTruncateString(fmt.Sprintf("%d/%s kb", n/1024, contentLengthStr), 16))
 			if contentLength > 0 {
-				fmt.Fprintf(os.Stderr, "\t%.02f%%", float64(n)/float64(contentLength)*100)
+				fmt.Fprintf(os.Stderr, "\t%s %.02f%%", progressBar(n, contentLength), float64(n)/float64(contentLength)*100)
 			} else {
 				fmt.Fprintf(os.Stderr, "\t-------%%")
 			}
@@ -226,6 +399,20 @@ func printProgress(wg *sync.WaitGroup, done <-chan struct{}, r *countingReader,
 	}
 }
 
+// progressBar returns a progressBarWidth+2 character wide bar showing the
+// fraction n/total, like "[=====>              ]".
+func progressBar(n uint64, total int64) string {
+	filled := int(float64(n) / float64(total) * progressBarWidth)
+	if filled > progressBarWidth {
+		filled = progressBarWidth
+	}
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	return "[" + bar + "]"
+}
+
 func padTruncateString(str string, truncateAt int) string {
 	if len(str) <= truncateAt {
 		return str + strings.Repeat(" ", truncateAt-len(str))
@@ -303,28 +490,32 @@ func ext(b []byte) string {
 // pickStdinFilename reads a bit of stdin to return a good filename
 // for its contents. The returned Reader is the concatenation of the
 // read and unread bits.
-func pickStdinFilename() (name string, r *countingReader, err error) {
+func pickStdinFilename() (name string, r io.Reader, err error) {
 	sniff, err := io.ReadAll(io.LimitReader(os.Stdin, maxSniff))
 	if err != nil {
 		return "", nil, err
 	}
-	return "stdin" + ext(sniff), &countingReader{Reader: io.MultiReader(bytes.NewReader(sniff), os.Stdin)}, nil
+	return "stdin" + ext(sniff), io.MultiReader(bytes.NewReader(sniff), os.Stdin), nil
 }
 
+// slowReader is a reader whose reads are limited by rl, which defaults to
+// 1 KB/s with TS_DEBUG_SLOW_PUSH.
 type slowReader struct {
 	r  io.Reader
 	rl *rate.Limiter
 }
 
 func (r *slowReader) Read(p []byte) (n int, err error) {
-	const burst = 4 << 10
+	burst := 4 << 10
+	if r.rl == nil {
+		r.rl = rate.NewLimiter(rate.Limit(1<<10), burst)
+	} else {
+		burst = r.rl.Burst()
+	}
 	plen := len(p)
 	if plen > burst {
 		plen = burst
 	}
-	if r.rl == nil {
-		r.rl = rate.NewLimiter(rate.Limit(1<<10), burst)
-	}
 	n, err = r.r.Read(p[:plen])
 	r.rl.WaitN(context.Background(), n)
 	return
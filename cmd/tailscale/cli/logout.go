@@ -5,10 +5,12 @@
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"strings"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
 )
 
 var logoutCmd = &ffcli.Command{
@@ -20,13 +22,52 @@
 "tailscale logout" brings the network down and invalidates
 the current node key, forcing a future use of it to cause
 a reauthentication.
+
+With --all-profiles, it does so for every profile (see
+"tailscale switch --list"), not just the current one.
 `),
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("logout")
+		fs.BoolVar(&logoutArgs.allProfiles, "all-profiles", false, "log out of all profiles, not just the current one")
+		return fs
+	})(),
 	Exec: runLogout,
 }
 
+var logoutArgs struct {
+	allProfiles bool
+}
+
 func runLogout(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return fmt.Errorf("too many non-flag arguments: %q", args)
 	}
-	return localClient.Logout(ctx)
+	if !logoutArgs.allProfiles {
+		return localClient.Logout(ctx)
+	}
+	cur, all, err := localClient.ProfileStatus(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range all {
+		if err := logoutProfile(ctx, p); err != nil {
+			return err
+		}
+	}
+	if cur.ID != "" {
+		// Leave the original profile selected, as logged out.
+		return localClient.SwitchProfile(ctx, cur.ID)
+	}
+	return nil
+}
+
+// logoutProfile switches to the profile p and logs out of it.
+func logoutProfile(ctx context.Context, p ipn.LoginProfile) error {
+	if err := localClient.SwitchProfile(ctx, p.ID); err != nil {
+		return fmt.Errorf("switching to profile %q: %w", p.Name, err)
+	}
+	if err := localClient.Logout(ctx); err != nil {
+		return fmt.Errorf("logging out of profile %q: %w", p.Name, err)
+	}
+	return nil
 }
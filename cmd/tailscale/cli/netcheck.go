@@ -34,6 +34,8 @@
 		fs.StringVar(&netcheckArgs.format, "format", "", `output format; empty (for human-readable), "json" or "json-line"`)
 		fs.DurationVar(&netcheckArgs.every, "every", 0, "if non-zero, do an incremental report with the given frequency")
 		fs.BoolVar(&netcheckArgs.verbose, "verbose", false, "verbose logs")
+		fs.StringVar(&netcheckArgs.stunServers, "stun-servers", "", "comma-separated list of additional STUN servers to probe, as host:port; if empty, $TS_NETCHECK_STUN_SERVERS")
+		fs.StringVar(&netcheckArgs.httpProbes, "http-probes", "", "comma-separated list of additional URLs to measure HTTP latency to, which should respond with a 2xx status without redirecting; if empty, $TS_NETCHECK_HTTP_PROBES")
 		return fs
 	})(),
 }
@@ -42,6 +44,9 @@
 	format  string
 	every   time.Duration
 	verbose bool
+
+	stunServers string
+	httpProbes  string
 }
 
 func runNetcheck(ctx context.Context, args []string) error {
@@ -49,6 +54,12 @@ func runNetcheck(ctx context.Context, args []string) error {
 		UDPBindAddr: envknob.String("TS_DEBUG_NETCHECK_UDP_BIND"),
 		PortMapper:  portmapper.NewClient(logger.WithPrefix(log.Printf, "portmap: "), nil, nil),
 	}
+	if netcheckArgs.stunServers != "" {
+		c.ExtraSTUNServers = netcheck.ParseProbeTargets(netcheckArgs.stunServers)
+	}
+	if netcheckArgs.httpProbes != "" {
+		c.ExtraHTTPProbes = netcheck.ParseProbeTargets(netcheckArgs.httpProbes)
+	}
 	if netcheckArgs.verbose {
 		c.Logf = logger.WithPrefix(log.Printf, "netcheck: ")
 		c.Verbose = true
@@ -173,9 +184,27 @@ func printReport(dm *tailcfg.DERPMap, report *netcheck.Report) error {
 			printf("\t\t- %3s: %-7s (%s%s)\n", r.RegionCode, latency, derpNum, r.RegionName)
 		}
 	}
+	printLatencies("STUN server latency", report.STUNServerLatency)
+	printLatencies("HTTP probe latency", report.HTTPProbeLatency)
 	return nil
 }
 
+// printLatencies prints the latencies in m, fastest first, if any.
+func printLatencies(title string, m map[string]time.Duration) {
+	if len(m) == 0 {
+		return
+	}
+	printf("\t* %s:\n", title)
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return m[keys[i]] < m[keys[j]] })
+	for _, k := range keys {
+		printf("\t\t- %-7s %s\n", m[k].Round(time.Millisecond/10), k)
+	}
+}
+
 func portMapping(r *netcheck.Report) string {
 	if !r.AnyPortMappingChecked() {
 		return "not checked"
@@ -0,0 +1,238 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"go4.org/netipx"
+	"tailscale.com/ipn"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/wgengine/filter"
+)
+
+var netmapCmd = &ffcli.Command{
+	Name:       "netmap",
+	ShortUsage: "netmap <subcommand> [command flags]",
+	ShortHelp:  "Export the network map for offline analysis",
+	LongHelp: strings.TrimSpace(`
+'tailscale netmap export' writes a sanitized snapshot of this node's network
+map as JSON: its peers, their addresses and routes, the DERP map, and the
+packet filter rules. Node keys are hashed, and private keys and peers' public
+endpoints are omitted. Visualizers and auditors can consume it, and it can be
+analyzed offline with 'tailscale debug --netmap-file=FILE derp-map' or
+'filter-check'.
+`),
+	Exec: func(context.Context, []string) error { return flag.ErrHelp },
+	Subcommands: []*ffcli.Command{
+		{
+			Name:       "export",
+			ShortUsage: "netmap export [--out=FILE]",
+			ShortHelp:  "Write a sanitized snapshot of the network map",
+			Exec:       runNetmapExport,
+			FlagSet: (func() *flag.FlagSet {
+				fs := newFlagSet("export")
+				fs.StringVar(&netmapArgs.out, "out", "", "write the snapshot to this file instead of stdout")
+				return fs
+			})(),
+		},
+	},
+}
+
+var netmapArgs struct {
+	out string
+}
+
+func runNetmapExport(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments")
+	}
+	nm, err := currentNetMap(ctx)
+	if err != nil {
+		return err
+	}
+	j, err := json.MarshalIndent(nm.Snapshot(time.Now()), "", "\t")
+	if err != nil {
+		return err
+	}
+	j = append(j, '\n')
+	if netmapArgs.out == "" {
+		Stdout.Write(j)
+		return nil
+	}
+	return os.WriteFile(netmapArgs.out, j, 0600)
+}
+
+// currentNetMap returns tailscaled's current network map.
+func currentNetMap(ctx context.Context) (*netmap.NetworkMap, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	w, err := localClient.WatchIPNBus(ctx, ipn.NotifyInitialNetMap)
+	if err != nil {
+		return nil, fixTailscaledConnectError(err)
+	}
+	defer w.Close()
+	n, err := w.Next()
+	if err != nil {
+		return nil, err
+	}
+	if n.NetMap == nil {
+		return nil, errors.New("no network map; is Tailscale up and logged in?")
+	}
+	return n.NetMap, nil
+}
+
+// loadNetmapSnapshot returns the snapshot in the file of debug
+// --netmap-file, or else of tailscaled's current network map.
+func loadNetmapSnapshot(ctx context.Context) (*netmap.Snapshot, error) {
+	if debugArgs.netmapFile == "" {
+		nm, err := currentNetMap(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return nm.Snapshot(time.Now()), nil
+	}
+	b, err := os.ReadFile(debugArgs.netmapFile)
+	if err != nil {
+		return nil, err
+	}
+	s := new(netmap.Snapshot)
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", debugArgs.netmapFile, err)
+	}
+	if s.Version != netmap.SnapshotVersion {
+		return nil, fmt.Errorf("%s is a version %d snapshot; want version %d", debugArgs.netmapFile, s.Version, netmap.SnapshotVersion)
+	}
+	return s, nil
+}
+
+var filterCheckArgs struct {
+	dst string
+}
+
+func runDebugFilterCheck(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: tailscale debug filter-check [--dst=IP] <src-hostname-or-IP> <port>")
+	}
+	port, err := parsePort(args[1])
+	if err != nil {
+		return err
+	}
+	snap, err := loadNetmapSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	var dst netip.Addr
+	if filterCheckArgs.dst != "" {
+		if dst, err = netip.ParseAddr(filterCheckArgs.dst); err != nil {
+			return fmt.Errorf("invalid --dst: %w", err)
+		}
+	}
+	src, err := snapshotNodeIP(snap, args[0], dst)
+	if err != nil {
+		return err
+	}
+	if !dst.IsValid() {
+		if dst, err = snapshotSelfIP(snap, src.Is6()); err != nil {
+			return err
+		}
+	}
+	e, err := checkFilter(snap, src, dst, port)
+	if err != nil {
+		return err
+	}
+	if e.Rule != "" {
+		printf("%s %v: %s by rule %s\n", e.Verdict, e.Flow, e.Why, e.Rule)
+	} else {
+		printf("%s %v: %s\n", e.Verdict, e.Flow, e.Why)
+	}
+	return nil
+}
+
+// checkFilter returns the decision of the packet filter of the snapshot
+// snap's node about a new TCP connection from src to dst:port.
+func checkFilter(snap *netmap.Snapshot, src, dst netip.Addr, port uint16) (filter.TraceEntry, error) {
+	matches, err := filter.MatchesFromFilterRules(snap.PacketFilterRules)
+	if err != nil {
+		return filter.TraceEntry{}, err
+	}
+	var b netipx.IPSetBuilder
+	for _, p := range snap.Self.Addresses {
+		b.AddPrefix(p)
+	}
+	for _, p := range snap.Self.PrimaryRoutes {
+		b.AddPrefix(p)
+	}
+	localNets, err := b.IPSet()
+	if err != nil {
+		return filter.TraceEntry{}, err
+	}
+	f := filter.New(matches, localNets, localNets, nil, logger.Discard)
+	f.StartTrace(time.Minute, netip.Addr{})
+	f.CheckTCP(src, dst, port)
+	tr := f.Trace()
+	if len(tr) != 1 {
+		return filter.TraceEntry{}, errors.New("no packet filter decision")
+	}
+	return tr[0], nil
+}
+
+// snapshotNodeIP returns the Tailscale IP of the node in snap named by
+// arg, a Tailscale IP, MagicDNS name or hostname, preferring the address
+// family of dst if it's valid, and otherwise IPv4.
+func snapshotNodeIP(snap *netmap.Snapshot, arg string, dst netip.Addr) (netip.Addr, error) {
+	if ip, err := netip.ParseAddr(arg); err == nil {
+		return ip, nil
+	}
+	want6 := dst.IsValid() && dst.Is6()
+	for _, n := range append([]netmap.SnapshotNode{snap.Self}, snap.Peers...) {
+		if !strings.EqualFold(arg, dnsname.FirstLabel(n.Name)) &&
+			!strings.EqualFold(arg, strings.TrimSuffix(n.Name, ".")) &&
+			!strings.EqualFold(arg, n.Hostname) {
+			continue
+		}
+		for _, p := range n.Addresses {
+			if p.IsSingleIP() && p.Addr().Is6() == want6 {
+				return p.Addr(), nil
+			}
+		}
+		family := "IPv4"
+		if want6 {
+			family = "IPv6"
+		}
+		return netip.Addr{}, fmt.Errorf("node %q has no Tailscale %s address", arg, family)
+	}
+	return netip.Addr{}, fmt.Errorf("no node named %q in the network map", arg)
+}
+
+// snapshotSelfIP returns the Tailscale IPv6 or IPv4 address of snap's own
+// node.
+func snapshotSelfIP(snap *netmap.Snapshot, is6 bool) (netip.Addr, error) {
+	for _, p := range snap.Self.Addresses {
+		if p.IsSingleIP() && p.Addr().Is6() == is6 {
+			return p.Addr(), nil
+		}
+	}
+	return netip.Addr{}, errors.New("this node has no Tailscale IP of the source's address family; use --dst")
+}
+
+func parsePort(s string) (uint16, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil || port == 0 {
+		return 0, fmt.Errorf("invalid port %q", s)
+	}
+	return uint16(port), nil
+}
@@ -6,6 +6,7 @@
 import (
 	"bytes"
 	"context"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
@@ -13,6 +14,7 @@
 	"flag"
 	"fmt"
 	"os"
+	"os/exec"
 	"strconv"
 	"strings"
 
@@ -412,12 +414,36 @@ func runNetworkLockModify(ctx context.Context, addArgs, removeArgs []string) err
 	return nil
 }
 
+var nlSignArgs struct {
+	signer    string
+	signerKey string
+}
+
 var nlSignCmd = &ffcli.Command{
 	Name:       "sign",
-	ShortUsage: "sign <node-key> [<rotation-key>]",
+	ShortUsage: "sign [--signer=<command> --signer-key=<tlpub>] <node-key> [<rotation-key>]",
 	ShortHelp:  "Signs a node key and transmits the signature to the coordination server",
-	LongHelp:   "Signs a node key and transmits the signature to the coordination server",
-	Exec:       runNetworkLockSign,
+	LongHelp: strings.TrimSpace(`
+Signs a node key and transmits the signature to the coordination server.
+
+By default, the node key is signed with this node's tailnet lock key.
+
+To sign with a trusted tailnet lock key held on a hardware token (such
+as a FIDO2 or PIV security key) instead, so that it never needs to be
+in any node's state, pass --signer with a helper command that uses the
+token, and --signer-key with the token's tailnet lock public key. The
+helper is run with the hex-encoded 32-byte message to sign on its
+standard input, and must write the hex-encoded 64-byte ed25519
+signature to its standard output. It may prompt for a PIN or touch on
+the terminal.
+`),
+	Exec: runNetworkLockSign,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("lock sign")
+		fs.StringVar(&nlSignArgs.signer, "signer", "", "command to sign with instead of this node's tailnet lock key")
+		fs.StringVar(&nlSignArgs.signerKey, "signer-key", "", "tailnet lock public key (tlpub:...) of --signer")
+		return fs
+	})(),
 }
 
 func runNetworkLockSign(ctx context.Context, args []string) error {
@@ -438,9 +464,65 @@ func runNetworkLockSign(ctx context.Context, args []string) error {
 		}
 	}
 
+	if nlSignArgs.signer != "" {
+		return signWithExternalSigner(ctx, nodeKey, []byte(rotationKey.Verifier()))
+	}
 	return localClient.NetworkLockSign(ctx, nodeKey, []byte(rotationKey.Verifier()))
 }
 
+// signWithExternalSigner signs nodeKey using the --signer command and
+// submits the signature.
+func signWithExternalSigner(ctx context.Context, nodeKey key.NodePublic, rotationPublic []byte) error {
+	var signerKey key.NLPublic
+	if err := signerKey.UnmarshalText([]byte(nlSignArgs.signerKey)); err != nil {
+		return fmt.Errorf("decoding --signer-key: %w", err)
+	}
+	p, err := nodeKey.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	sig := tka.NodeKeySignature{
+		SigKind:        tka.SigDirect,
+		KeyID:          signerKey.KeyID(),
+		Pubkey:         p,
+		WrappingPubkey: rotationPublic,
+	}
+	sigHash := sig.SigHash()
+	fmt.Fprintf(Stderr, "Signing %v with %v; your security key may need a touch.\n", nodeKey.ShortString(), signerKey.CLIString())
+	sig.Signature, err = runExternalSigner(ctx, nlSignArgs.signer, sigHash[:])
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(signerKey.Verifier(), sigHash[:], sig.Signature) {
+		return errors.New("signer returned an invalid signature; is --signer-key the signer's key?")
+	}
+	return localClient.NetworkLockSubmitSignature(ctx, sig.Serialize())
+}
+
+// runExternalSigner runs the space-separated command signer to sign msg,
+// per the protocol described in the help for "lock sign".
+func runExternalSigner(ctx context.Context, signer string, msg []byte) ([]byte, error) {
+	args := strings.Fields(signer)
+	if len(args) == 0 {
+		return nil, errors.New("empty --signer")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin = strings.NewReader(hex.EncodeToString(msg) + "\n")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("running signer: %w", err)
+	}
+	sig, err := hex.DecodeString(strings.TrimSpace(string(out)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signer output: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signer returned %d bytes; want a %d-byte signature", len(sig), ed25519.SignatureSize)
+	}
+	return sig, nil
+}
+
 var nlDisableCmd = &ffcli.Command{
 	Name:       "disable",
 	ShortUsage: "disable <disablement-secret>",
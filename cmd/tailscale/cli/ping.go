@@ -16,8 +16,10 @@
 	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/opt"
 )
 
 var pingCmd = &ffcli.Command{
@@ -36,7 +38,10 @@
 does not inject packets into either side's TUN devices.
 
 By default, 'tailscale ping' stops after 10 pings or once a direct
-(non-DERP) path has been established, whichever comes first.
+(non-DERP) path has been established, whichever comes first. With
+--until-direct-timeout, it keeps pinging until a direct path is
+established or the timeout passes, and then explains which NAT or
+firewall conditions on either side likely prevented it.
 
 The provided hostname must resolve to or be a Tailscale IP
 (e.g. 100.x.y.z) or a subnet IP advertised by a Tailscale
@@ -48,6 +53,7 @@
 		fs := newFlagSet("ping")
 		fs.BoolVar(&pingArgs.verbose, "verbose", false, "verbose output")
 		fs.BoolVar(&pingArgs.untilDirect, "until-direct", true, "stop once a direct path is established")
+		fs.DurationVar(&pingArgs.untilDirectTimeout, "until-direct-timeout", 0, "if non-zero, keep pinging until a direct path is established or this much time passes, ignoring -c, and explain what prevented one")
 		fs.BoolVar(&pingArgs.tsmp, "tsmp", false, "do a TSMP-level ping (through WireGuard, but not either host OS stack)")
 		fs.BoolVar(&pingArgs.icmp, "icmp", false, "do a ICMP-level ping (through WireGuard, but not the local host OS stack)")
 		fs.BoolVar(&pingArgs.peerAPI, "peerapi", false, "try hitting the peer's peerapi HTTP server")
@@ -58,13 +64,14 @@
 }
 
 var pingArgs struct {
-	num         int
-	untilDirect bool
-	verbose     bool
-	tsmp        bool
-	icmp        bool
-	peerAPI     bool
-	timeout     time.Duration
+	num                int
+	untilDirect        bool
+	untilDirectTimeout time.Duration
+	verbose            bool
+	tsmp               bool
+	icmp               bool
+	peerAPI            bool
+	timeout            time.Duration
 }
 
 func pingType() tailcfg.PingType {
@@ -110,20 +117,37 @@ func runPing(ctx context.Context, args []string) error {
 		log.Printf("lookup %q => %q", hostOrIP, ip)
 	}
 
+	pingIP := netip.MustParseAddr(ip)
+	var deadline time.Time
+	if pingArgs.untilDirectTimeout > 0 {
+		deadline = time.Now().Add(pingArgs.untilDirectTimeout)
+	}
+	// done reports whether n pings are all that should be sent.
+	done := func(n int) bool {
+		if !deadline.IsZero() {
+			return !time.Now().Before(deadline)
+		}
+		return n == pingArgs.num
+	}
+
 	n := 0
 	anyPong := false
 	for {
 		n++
 		ctx, cancel := context.WithTimeout(ctx, pingArgs.timeout)
-		pr, err := localClient.Ping(ctx, netip.MustParseAddr(ip), pingType())
+		pr, err := localClient.Ping(ctx, pingIP, pingType())
 		cancel()
 		if err != nil {
 			if errors.Is(err, context.DeadlineExceeded) {
 				printf("ping %q timed out\n", ip)
-				if n == pingArgs.num {
+				if done(n) {
 					if !anyPong {
 						return errors.New("no reply")
 					}
+					if !deadline.IsZero() && pingArgs.untilDirect {
+						explainNoDirectPath(ctx, pingIP)
+						return errors.New("direct connection not established")
+					}
 					return nil
 				}
 				continue
@@ -165,11 +189,14 @@ func runPing(ctx context.Context, args []string) error {
 		}
 		time.Sleep(time.Second)
 
-		if n == pingArgs.num {
+		if done(n) {
 			if !anyPong {
 				return errors.New("no reply")
 			}
 			if pingArgs.untilDirect {
+				if !deadline.IsZero() {
+					explainNoDirectPath(ctx, pingIP)
+				}
 				return errors.New("direct connection not established")
 			}
 			return nil
@@ -177,6 +204,111 @@ func runPing(ctx context.Context, args []string) error {
 	}
 }
 
+// explainNoDirectPath prints the likely reasons that no direct path to
+// the peer with ip was established, from both sides' network reports in
+// the netmap.
+func explainNoDirectPath(ctx context.Context, ip netip.Addr) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	w, err := localClient.WatchIPNBus(ctx, ipn.NotifyInitialNetMap)
+	if err != nil {
+		printf("can't diagnose: %v\n", err)
+		return
+	}
+	defer w.Close()
+	n, err := w.Next()
+	if err != nil || n.NetMap == nil {
+		printf("can't diagnose: no netmap\n")
+		return
+	}
+	nm := n.NetMap
+	peer := peerForIP(nm.Peers, ip)
+	if peer == nil {
+		printf("can't diagnose: no peer with IP %v\n", ip)
+		return
+	}
+	var peerNI *tailcfg.NetInfo
+	if hi := peer.Hostinfo; hi.Valid() && hi.NetInfo().Valid() {
+		peerNI = hi.NetInfo().AsStruct()
+	}
+	printf("\nNo direct path after %v. Likely reasons:\n", pingArgs.untilDirectTimeout)
+	for _, line := range directPathAdvice(nm.Hostinfo.NetInfo, peerNI, peer.ComputedName) {
+		printf("\t* %s\n", line)
+	}
+}
+
+// peerForIP returns the peer with the Tailscale IP ip, or else the peer
+// routing the subnet containing ip.
+func peerForIP(peers []*tailcfg.Node, ip netip.Addr) *tailcfg.Node {
+	for _, p := range peers {
+		for _, a := range p.Addresses {
+			if a.Addr() == ip {
+				return p
+			}
+		}
+	}
+	for _, p := range peers {
+		for _, r := range p.PrimaryRoutes {
+			if r.Contains(ip) {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// defaultWireGuardPort is the UDP port tailscaled listens on by default.
+const defaultWireGuardPort = 41641
+
+// directPathAdvice returns, from the network reports of this node and
+// the peer named peerName, the conditions likely preventing a direct
+// path between them and how to fix them. Either report may be nil if
+// unknown.
+func directPathAdvice(self, peer *tailcfg.NetInfo, peerName string) []string {
+	var ret []string
+	selfHard, selfOK := natAdvice(&ret, "this device", self)
+	peerHard, peerOK := natAdvice(&ret, peerName, peer)
+	switch {
+	case selfHard && peerHard:
+		ret = append(ret, "both sides are behind hard NATs, so at least one side needs a port mapping or an open UDP port")
+	case selfOK && peerOK:
+		ret = append(ret, "neither side reports NAT problems; a firewall on either device, or between them, may be blocking UDP")
+	}
+	return ret
+}
+
+// natAdvice appends to ret the problems in the network report ni of the
+// device named who. It reports whether the device is behind a hard NAT
+// without a port mapping, and whether no problems were found.
+func natAdvice(ret *[]string, who string, ni *tailcfg.NetInfo) (hard, ok bool) {
+	if ni == nil {
+		*ret = append(*ret, fmt.Sprintf("%s hasn't reported its network conditions yet; run 'tailscale netcheck' on it", who))
+		return false, false
+	}
+	if ni.WorkingUDP.EqualBool(false) {
+		*ret = append(*ret, fmt.Sprintf("%s can't send UDP to the internet; allow outbound UDP on its network or firewall", who))
+		return false, false
+	}
+	if !ni.MappingVariesByDestIP.EqualBool(true) || ni.HavePortMap {
+		return false, true
+	}
+	var protos []string
+	for _, p := range []struct {
+		name string
+		v    opt.Bool
+	}{{"UPnP", ni.UPnP}, {"NAT-PMP", ni.PMP}, {"PCP", ni.PCP}} {
+		if p.v.EqualBool(true) {
+			protos = append(protos, p.name)
+		}
+	}
+	if len(protos) > 0 {
+		*ret = append(*ret, fmt.Sprintf("%s is behind a hard NAT (mappings vary by destination); its router offers %s but no port mapping is open, so check that tailscaled can use it", who, strings.Join(protos, ", ")))
+	} else {
+		*ret = append(*ret, fmt.Sprintf("%s is behind a hard NAT (mappings vary by destination); enable UPnP, NAT-PMP or PCP on its router, or forward UDP port %d (or tailscaled's --port) to it", who, defaultWireGuardPort))
+	}
+	return true, false
+}
+
 func tailscaleIPFromArg(ctx context.Context, hostOrIP string) (ip string, self bool, err error) {
 	// If the argument is an IP address, use it directly without any resolution.
 	if net.ParseIP(hostOrIP) != nil {
@@ -0,0 +1,64 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+)
+
+func TestDirectPathAdvice(t *testing.T) {
+	easy := &tailcfg.NetInfo{WorkingUDP: "true", MappingVariesByDestIP: "false"}
+	hard := &tailcfg.NetInfo{WorkingUDP: "true", MappingVariesByDestIP: "true"}
+	hardUPnP := &tailcfg.NetInfo{WorkingUDP: "true", MappingVariesByDestIP: "true", UPnP: "true"}
+	hardMapped := &tailcfg.NetInfo{WorkingUDP: "true", MappingVariesByDestIP: "true", HavePortMap: true}
+	noUDP := &tailcfg.NetInfo{WorkingUDP: "false"}
+
+	tests := []struct {
+		name       string
+		self, peer *tailcfg.NetInfo
+		want       []string
+	}{
+		{
+			name: "both_easy",
+			self: easy,
+			peer: easy,
+			want: []string{"neither side reports NAT problems; a firewall on either device, or between them, may be blocking UDP"},
+		},
+		{
+			name: "mapped_is_easy",
+			self: hardMapped,
+			peer: easy,
+			want: []string{"neither side reports NAT problems; a firewall on either device, or between them, may be blocking UDP"},
+		},
+		{
+			name: "both_hard",
+			self: hard,
+			peer: hardUPnP,
+			want: []string{
+				"this device is behind a hard NAT (mappings vary by destination); enable UPnP, NAT-PMP or PCP on its router, or forward UDP port 41641 (or tailscaled's --port) to it",
+				"peer is behind a hard NAT (mappings vary by destination); its router offers UPnP but no port mapping is open, so check that tailscaled can use it",
+				"both sides are behind hard NATs, so at least one side needs a port mapping or an open UDP port",
+			},
+		},
+		{
+			name: "no_udp_unknown_peer",
+			self: noUDP,
+			want: []string{
+				"this device can't send UDP to the internet; allow outbound UDP on its network or firewall",
+				"peer hasn't reported its network conditions yet; run 'tailscale netcheck' on it",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := directPathAdvice(tt.self, tt.peer, "peer")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got:\n%q\nwant:\n%q", got, tt.want)
+			}
+		})
+	}
+}
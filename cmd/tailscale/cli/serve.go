@@ -11,6 +11,7 @@
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"net/url"
 	"os"
 	"path"
@@ -19,12 +20,16 @@
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
+	"golang.org/x/exp/constraints"
+	"golang.org/x/exp/maps"
 	"golang.org/x/exp/slices"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
 )
@@ -55,17 +60,30 @@ func newServeCommand(e *serveEnv) *ffcli.Command {
   - To proxy requests to a web server at 127.0.0.1:3000:
     $ tailscale serve / proxy 3000
 
+  - To proxy requests to a web server on another node in your tailnet:
+    $ tailscale serve / proxy http://nas:8080
+
   - To serve a single file or a directory of files:
     $ tailscale serve / path /home/alice/blog/index.html
     $ tailscale serve /images/ path /home/alice/blog/images
 
   - To serve simple static text:
     $ tailscale serve / text "Hello, world!"
+
+  - To only allow some users and tagged nodes of your tailnet:
+    $ tailscale serve --allow=alice@example.com,tag:admin /admin/ proxy 8080
+
+  - To close WebSocket connections to a proxy after an hour without traffic:
+    $ tailscale serve --idle-timeout=1h / proxy 3000
 `),
 		Exec: e.runServe,
 		FlagSet: e.newFlags("serve", func(fs *flag.FlagSet) {
 			fs.BoolVar(&e.remove, "remove", false, "remove an existing serve config")
 			fs.UintVar(&e.servePort, "serve-port", 443, "port to serve on (443, 8443 or 10000)")
+			fs.StringVar(&e.allowFrom, "allow", "", "comma-separated login names, tags or peer capabilities to restrict the handler to; empty means the whole tailnet")
+			fs.DurationVar(&e.readTimeout, "read-timeout", 0, "for proxy, how long to wait for the backend to respond; 0 means no limit")
+			fs.DurationVar(&e.writeTimeout, "write-timeout", 0, "for proxy, how long each write to the client may block; 0 means no limit")
+			fs.DurationVar(&e.idleTimeout, "idle-timeout", 0, "for proxy, how long an upgraded connection, such as a WebSocket, may go without traffic; 0 means no limit")
 		}),
 		UsageFunc: usageFunc,
 		Subcommands: []*ffcli.Command{
@@ -89,9 +107,16 @@ func newServeCommand(e *serveEnv) *ffcli.Command {
 					"",
 					"  - Forward raw, TLS-terminated TCP packets to a local TCP server on port 5432:",
 					"    $ tailscale serve tcp --terminate-tls 5432",
+					"",
+					"  - Forward TLS-terminated TCP to port 5432 on another node in your tailnet:",
+					"    $ tailscale serve tcp --terminate-tls db:5432",
+					"",
+					"  - Forward TCP ports 30000 to 30100 to the same ports on a local server:",
+					"    $ tailscale serve tcp --ports=30000-30100 localhost",
 				}, "\n"),
 				FlagSet: e.newFlags("serve-tcp", func(fs *flag.FlagSet) {
 					fs.BoolVar(&e.terminateTLS, "terminate-tls", false, "terminate TLS before forwarding TCP connection")
+					fs.StringVar(&e.tcpPorts, "ports", "", "range of ports, such as 30000-30100, to forward to the same ports on the target host, instead of --serve-port")
 				}),
 				UsageFunc: usageFunc,
 			},
@@ -109,6 +134,34 @@ func newServeCommand(e *serveEnv) *ffcli.Command {
 				}, "\n"),
 				UsageFunc: usageFunc,
 			},
+			{
+				Name:       "reset",
+				Exec:       e.runServeReset,
+				ShortUsage: "reset [flags] [<mount-point>]",
+				ShortHelp:  "remove all or part of the serve config",
+				LongHelp: strings.Join([]string{
+					"The serve config can be shared by several apps on a node;",
+					"use --scope to remove only part of it.",
+					"",
+					"EXAMPLES",
+					"  - Remove everything:",
+					"    $ tailscale serve reset",
+					"",
+					"  - Turn off Funnel, leaving serving to your tailnet as is:",
+					"    $ tailscale serve reset --scope=funnel",
+					"",
+					"  - Remove everything on serve port 8443:",
+					"    $ tailscale serve --serve-port=8443 reset --scope=port",
+					"",
+					"  - Preview removing the /images/ handler on port 443:",
+					"    $ tailscale serve reset --scope=path --dry-run /images/",
+				}, "\n"),
+				FlagSet: e.newFlags("serve-reset", func(fs *flag.FlagSet) {
+					fs.StringVar(&e.resetScope, "scope", "all", `what to remove: "all", "funnel" (Funnel on all ports), "port" (everything on --serve-port), or "path" (the handler for <mount-point> on --serve-port)`)
+					fs.BoolVar(&e.dryRun, "dry-run", false, "print what would be removed, without removing it")
+				}),
+				UsageFunc: usageFunc,
+			},
 		},
 	}
 }
@@ -147,8 +200,15 @@ type serveEnv struct {
 	// flags
 	servePort    uint // Port to serve on. Defaults to 443.
 	terminateTLS bool
-	remove       bool // remove a serve config
-	json         bool // output JSON (status only for now)
+	tcpPorts     string // port range for "serve tcp", instead of servePort
+	remove       bool   // remove a serve config
+	json         bool   // output JSON (status only for now)
+	resetScope   string
+	dryRun       bool
+	allowFrom    string // comma-separated HTTPHandler.AllowFrom
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	idleTimeout  time.Duration
 
 	lc localServeClient // localClient interface, specific to serve
 
@@ -273,7 +333,11 @@ func (e *serveEnv) runServe(ctx context.Context, args []string) error {
 		}
 		h.Path = args[2]
 	case "proxy":
-		t, err := expandProxyTarget(args[2])
+		st, err := e.getLocalClientStatus(ctx)
+		if err != nil {
+			return fmt.Errorf("getting client status: %w", err)
+		}
+		t, err := expandProxyTarget(args[2], st)
 		if err != nil {
 			return err
 		}
@@ -287,6 +351,22 @@ func (e *serveEnv) runServe(ctx context.Context, args []string) error {
 		fmt.Fprintf(os.Stderr, "error: unknown serve type %q\n\n", args[1])
 		return flag.ErrHelp
 	}
+	for _, a := range strings.Split(e.allowFrom, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			h.AllowFrom = append(h.AllowFrom, a)
+		}
+	}
+	if e.readTimeout != 0 || e.writeTimeout != 0 || e.idleTimeout != 0 {
+		if h.Proxy == "" {
+			return errors.New("timeouts are only supported for proxy")
+		}
+		if e.readTimeout < 0 || e.writeTimeout < 0 || e.idleTimeout < 0 {
+			return errors.New("timeouts can't be negative")
+		}
+		h.ReadTimeout = e.readTimeout
+		h.WriteTimeout = e.writeTimeout
+		h.IdleTimeout = e.idleTimeout
+	}
 
 	cursc, err := e.lc.GetServeConfig(ctx)
 	if err != nil {
@@ -396,7 +476,11 @@ func cleanMountPoint(mount string) (string, error) {
 	return "", fmt.Errorf("invalid mount point %q", mount)
 }
 
-func expandProxyTarget(target string) (string, error) {
+// expandProxyTarget returns the proxy URL to store in the serve config for
+// target, which may be a port number, a host:port, or a URL. The host must
+// be localhost or a Tailscale node in st, named by MagicDNS name or
+// Tailscale IP.
+func expandProxyTarget(target string, st *ipnstate.Status) (string, error) {
 	if allNumeric(target) {
 		p, err := strconv.ParseUint(target, 10, 16)
 		if p == 0 || err != nil {
@@ -417,13 +501,9 @@ func expandProxyTarget(target string) (string, error) {
 	default:
 		return "", fmt.Errorf("must be a URL starting with http://, https://, or https+insecure://")
 	}
-	host := u.Hostname()
-	switch host {
-	// TODO(shayne,bradfitz): do we want to do this?
-	case "localhost", "127.0.0.1":
-		host = "127.0.0.1"
-	default:
-		return "", fmt.Errorf("only localhost or 127.0.0.1 proxies are currently supported")
+	host, err := expandBackendHost(u.Hostname(), st)
+	if err != nil {
+		return "", err
 	}
 	url := u.Scheme + "://" + host
 	if u.Port() != "" {
@@ -432,6 +512,69 @@ func expandProxyTarget(target string) (string, error) {
 	return url, nil
 }
 
+// expandTCPTarget returns the address to store as a TCPForward for
+// target, which is either a local port number or a host:port whose host
+// is localhost or a Tailscale node in st.
+func expandTCPTarget(target string, st *ipnstate.Status) (string, error) {
+	host, port := "127.0.0.1", target
+	if !allNumeric(target) {
+		var err error
+		host, port, err = net.SplitHostPort(target)
+		if err != nil {
+			return "", fmt.Errorf("invalid target %q: %w", target, err)
+		}
+		host, err = expandBackendHost(host, st)
+		if err != nil {
+			return "", err
+		}
+	}
+	if p, err := strconv.ParseUint(port, 10, 16); p == 0 || err != nil {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// expandBackendHost validates host as the host of a serve backend. Local
+// backends are normalized to 127.0.0.1; other backends must be Tailscale
+// nodes in st, named by MagicDNS name (short or fully qualified) or
+// Tailscale IP, and are returned as given, without a trailing dot.
+func expandBackendHost(host string, st *ipnstate.Status) (string, error) {
+	switch host {
+	case "localhost", "127.0.0.1":
+		return "127.0.0.1", nil
+	}
+	host = strings.TrimSuffix(host, ".")
+	if isTailnetHost(host, st) {
+		return host, nil
+	}
+	return "", fmt.Errorf("backend host %q is not localhost or a node in your tailnet", host)
+}
+
+// isTailnetHost reports whether host is the MagicDNS name or a Tailscale
+// IP of one of the peers in st.
+func isTailnetHost(host string, st *ipnstate.Status) bool {
+	if st == nil {
+		return false
+	}
+	ip, err := netip.ParseAddr(host)
+	for _, ps := range st.Peer {
+		if err == nil {
+			if slices.Contains(ps.TailscaleIPs, ip) {
+				return true
+			}
+			continue
+		}
+		name := strings.TrimSuffix(ps.DNSName, ".")
+		if name == "" {
+			continue
+		}
+		if strings.EqualFold(host, name) || strings.EqualFold(host, dnsname.FirstLabel(name)) {
+			return true
+		}
+	}
+	return false
+}
+
 func allNumeric(s string) bool {
 	for i := 0; i < len(s); i++ {
 		if s[i] < '0' || s[i] > '9' {
@@ -460,7 +603,7 @@ func (e *serveEnv) runServeStatus(ctx context.Context, args []string) error {
 		e.stdout().Write(j)
 		return nil
 	}
-	if sc == nil || (len(sc.TCP) == 0 && len(sc.Web) == 0 && len(sc.AllowFunnel) == 0) {
+	if sc == nil || (len(sc.TCP) == 0 && len(sc.TCPRanges) == 0 && len(sc.Web) == 0 && len(sc.AllowFunnel) == 0) {
 		printf("No serve config\n")
 		return nil
 	}
@@ -521,6 +664,13 @@ func printTCPStatusTree(ctx context.Context, sc *ipn.ServeConfig, st *ipnstate.S
 		}
 		printf("|--> tcp://%s\n", h.TCPForward)
 	}
+	for _, r := range sc.TCPRanges {
+		printf("|-- tcp://%s:%v (tailnet only)\n", dnsName, r.Ports)
+		for _, a := range st.TailscaleIPs {
+			printf("|-- tcp://%s\n", net.JoinHostPort(a.String(), r.Ports.String()))
+		}
+		printf("|--> tcp://%s\n", net.JoinHostPort(r.TCPForward, r.Ports.String()))
+	}
 	return nil
 }
 
@@ -562,6 +712,21 @@ func printWebStatusTree(sc *ipn.ServeConfig, hp ipn.HostPort) {
 	for _, m := range mounts {
 		h := sc.Web[hp].Handlers[m]
 		t, d := srvTypeAndDesc(h)
+		if len(h.AllowFrom) > 0 {
+			d += " (allow: " + strings.Join(h.AllowFrom, ", ") + ")"
+		}
+		var timeouts []string
+		for _, t := range []struct {
+			name string
+			d    time.Duration
+		}{{"read", h.ReadTimeout}, {"write", h.WriteTimeout}, {"idle", h.IdleTimeout}} {
+			if t.d > 0 {
+				timeouts = append(timeouts, t.name+" "+t.d.String())
+			}
+		}
+		if len(timeouts) > 0 {
+			d += " (timeouts: " + strings.Join(timeouts, ", ") + ")"
+		}
 		printf("%s %s%s %-5s %s\n", "|--", m, strings.Repeat(" ", maxLen-len(m)), t, d)
 	}
 }
@@ -578,23 +743,31 @@ func elipticallyTruncate(s string, max int) string {
 //
 // Examples:
 //   - tailscale serve tcp 5432
+//   - tailscale serve tcp db:5432
 //   - tailscale serve --serve-port=8443 tcp 4430
 //   - tailscale serve --serve-port=10000 tcp --terminate-tls 8080
+//   - tailscale serve tcp --ports=30000-30100 localhost
 func (e *serveEnv) runServeTCP(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		fmt.Fprintf(os.Stderr, "error: invalid number of arguments\n\n")
 		return flag.ErrHelp
 	}
+	if e.tcpPorts != "" {
+		return e.serveTCPRange(ctx, args[0])
+	}
 
 	srvPort, err := e.validateServePort()
 	if err != nil {
 		return err
 	}
 
-	portStr := args[0]
-	p, err := strconv.ParseUint(portStr, 10, 16)
-	if p == 0 || err != nil {
-		fmt.Fprintf(os.Stderr, "error: invalid port %q\n\n", portStr)
+	st, err := e.getLocalClientStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("getting client status: %w", err)
+	}
+	fwdAddr, err := expandTCPTarget(args[0], st)
+	if err != nil {
+		return err
 	}
 
 	cursc, err := e.lc.GetServeConfig(ctx)
@@ -606,8 +779,6 @@ func (e *serveEnv) runServeTCP(ctx context.Context, args []string) error {
 		sc = new(ipn.ServeConfig)
 	}
 
-	fwdAddr := "127.0.0.1:" + portStr
-
 	if sc.IsServingWeb(srvPort) {
 		if e.remove {
 			return fmt.Errorf("unable to remove; serving web, not TCP forwarding on serve port %d", srvPort)
@@ -646,6 +817,62 @@ func (e *serveEnv) runServeTCP(ctx context.Context, args []string) error {
 	return nil
 }
 
+// serveTCPRange manages the serve config for forwarding the ports in
+// e.tcpPorts to the same ports on host.
+func (e *serveEnv) serveTCPRange(ctx context.Context, host string) error {
+	ports, err := tailcfg.ParsePortRange(e.tcpPorts)
+	if err != nil {
+		return err
+	}
+	if ports.First == 0 {
+		return fmt.Errorf("invalid port range %q", e.tcpPorts)
+	}
+	if e.terminateTLS {
+		return errors.New("--terminate-tls can't be used with --ports")
+	}
+	st, err := e.getLocalClientStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("getting client status: %w", err)
+	}
+	host, err = expandBackendHost(host, st)
+	if err != nil {
+		return err
+	}
+	want := ipn.TCPPortRange{Ports: ports, TCPForward: host}
+
+	cursc, err := e.lc.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	sc := cursc.Clone() // nil if no config
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	i := slices.IndexFunc(sc.TCPRanges, func(r ipn.TCPPortRange) bool {
+		return r.Ports.Overlaps(ports)
+	})
+
+	if e.remove {
+		if i < 0 || sc.TCPRanges[i] != want {
+			return errors.New("error: serve config does not exist")
+		}
+		sc.TCPRanges = slices.Delete(sc.TCPRanges, i, i+1)
+		// clear slice mostly for testing
+		if len(sc.TCPRanges) == 0 {
+			sc.TCPRanges = nil
+		}
+		return e.lc.SetServeConfig(ctx, sc)
+	}
+	if i >= 0 {
+		if sc.TCPRanges[i] == want {
+			return nil // nothing to save
+		}
+		return fmt.Errorf("cannot serve TCP ports %v; already forwarding ports %v", ports, sc.TCPRanges[i].Ports)
+	}
+	sc.TCPRanges = append(sc.TCPRanges, want)
+	return e.lc.SetServeConfig(ctx, sc)
+}
+
 // runServeFunnel is the entry point for the "serve funnel" subcommand and
 // manages turning on/off funnel. Funnel is off by default.
 //
@@ -721,3 +948,150 @@ func checkHasAccess(nodeAttrs []string) error {
 	}
 	return nil
 }
+
+// runServeReset is the entry point for the "serve reset" subcommand,
+// which removes the part of the serve config in --scope.
+//
+// Examples:
+//   - tailscale serve reset
+//   - tailscale serve reset --scope=funnel
+//   - tailscale serve --serve-port=8443 reset --scope=port --dry-run
+//   - tailscale serve reset --scope=path /images/
+func (e *serveEnv) runServeReset(ctx context.Context, args []string) error {
+	var mount string
+	switch e.resetScope {
+	case "all", "funnel", "port":
+		if len(args) != 0 {
+			return flag.ErrHelp
+		}
+	case "path":
+		if len(args) != 1 {
+			fmt.Fprintf(os.Stderr, "error: --scope=path requires a mount point\n\n")
+			return flag.ErrHelp
+		}
+		var err error
+		if mount, err = cleanMountPoint(args[0]); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid --scope %q; must be all, funnel, port or path", e.resetScope)
+	}
+	srvPort, err := e.validateServePort()
+	if err != nil {
+		return err
+	}
+
+	cursc, err := e.lc.GetServeConfig(ctx)
+	if err != nil {
+		return err
+	}
+	var hp ipn.HostPort
+	if e.resetScope == "port" || e.resetScope == "path" {
+		dnsName, err := e.getSelfDNSName(ctx)
+		if err != nil {
+			return err
+		}
+		hp = ipn.HostPort(net.JoinHostPort(dnsName, strconv.Itoa(int(srvPort))))
+	}
+	sc := cursc.Clone()
+	removed := resetServeConfig(sc, e.resetScope, srvPort, hp, mount)
+	if len(removed) == 0 {
+		fmt.Fprintf(e.stdout(), "Nothing to remove\n")
+		return nil
+	}
+	verb := "Removed"
+	if e.dryRun {
+		verb = "Would remove"
+	}
+	for _, r := range removed {
+		fmt.Fprintf(e.stdout(), "%s %s\n", verb, r)
+	}
+	if e.dryRun {
+		return nil
+	}
+	return e.lc.SetServeConfig(ctx, sc)
+}
+
+// resetServeConfig removes the part of sc in scope (see runServeReset)
+// and returns a description of each thing removed, in a stable order.
+// The port scope is serve port srvPort, which is served as hp; the path
+// scope is the handler for mount on hp.
+func resetServeConfig(sc *ipn.ServeConfig, scope string, srvPort uint16, hp ipn.HostPort, mount string) (removed []string) {
+	if sc == nil {
+		return nil
+	}
+	removeFunnel := func(hp ipn.HostPort) {
+		if sc.AllowFunnel[hp] {
+			removed = append(removed, fmt.Sprintf("Funnel on %s", hp))
+		}
+		delete(sc.AllowFunnel, hp)
+	}
+	removeWeb := func(hp ipn.HostPort) {
+		if wsc, ok := sc.Web[hp]; ok {
+			for _, m := range sortedKeys(wsc.Handlers) {
+				removed = append(removed, fmt.Sprintf("handler for https://%s%s", hp, m))
+			}
+		}
+		delete(sc.Web, hp)
+	}
+	removeTCP := func(p uint16) {
+		if h, ok := sc.TCP[p]; ok && h.TCPForward != "" {
+			removed = append(removed, fmt.Sprintf("TCP forward from port %d to %s", p, h.TCPForward))
+		}
+		delete(sc.TCP, p)
+	}
+
+	switch scope {
+	case "all":
+		for _, hp := range sortedKeys(sc.AllowFunnel) {
+			removeFunnel(hp)
+		}
+		for _, hp := range sortedKeys(sc.Web) {
+			removeWeb(hp)
+		}
+		for _, p := range sortedKeys(sc.TCP) {
+			removeTCP(p)
+		}
+		for _, r := range sc.TCPRanges {
+			removed = append(removed, fmt.Sprintf("TCP forward from ports %v to %s", r.Ports, r.TCPForward))
+		}
+		sc.TCPRanges = nil
+	case "funnel":
+		for _, hp := range sortedKeys(sc.AllowFunnel) {
+			removeFunnel(hp)
+		}
+	case "port":
+		removeFunnel(hp)
+		removeWeb(hp)
+		removeTCP(srvPort)
+	case "path":
+		if !sc.WebHandlerExists(hp, mount) {
+			return nil
+		}
+		removed = append(removed, fmt.Sprintf("handler for https://%s%s", hp, mount))
+		delete(sc.Web[hp].Handlers, mount)
+		// Cascade, as "serve --remove" does.
+		if len(sc.Web[hp].Handlers) == 0 {
+			delete(sc.Web, hp)
+			delete(sc.TCP, srvPort)
+			removeFunnel(hp)
+		}
+	}
+	// clear empty maps mostly for testing
+	if len(sc.TCP) == 0 {
+		sc.TCP = nil
+	}
+	if len(sc.Web) == 0 {
+		sc.Web = nil
+	}
+	if len(sc.AllowFunnel) == 0 {
+		sc.AllowFunnel = nil
+	}
+	return removed
+}
+
+func sortedKeys[K constraints.Ordered, V any](m map[K]V) []K {
+	keys := maps.Keys(m)
+	slices.Sort(keys)
+	return keys
+}
@@ -8,16 +8,19 @@
 	"context"
 	"flag"
 	"fmt"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
 )
 
 func TestCleanMountPoint(t *testing.T) {
@@ -110,6 +113,42 @@ type step struct {
 		wantErr: exactErr(flag.ErrHelp, "flag.ErrHelp"),
 	})
 
+	// allow
+	add(step{reset: true})
+	add(step{
+		command: cmd("--allow=alice@example.com,tag:admin /admin text hi"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/admin": {Text: "hi", AllowFrom: []string{"alice@example.com", "tag:admin"}},
+				}},
+			},
+		},
+	})
+
+	// timeouts
+	add(step{reset: true})
+	add(step{
+		command: cmd("--read-timeout=30s --idle-timeout=1h / proxy 3000"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000", ReadTimeout: 30 * time.Second, IdleTimeout: time.Hour},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("--idle-timeout=1h /admin text hi"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("--write-timeout=-1s / proxy 3000"),
+		wantErr: anyErr(),
+	})
+
 	// https
 	add(step{reset: true})
 	add(step{
@@ -267,6 +306,46 @@ type step struct {
 		},
 	})
 
+	// proxy to other tailnet nodes
+	add(step{reset: true})
+	add(step{
+		command: cmd("/ proxy http://nas:8080"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://nas:8080"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("/ proxy https+insecure://nas.test.ts.net.:8443"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "https+insecure://nas.test.ts.net:8443"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("/ proxy 100.101.102.103:8080"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://100.101.102.103:8080"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("/ proxy http://100.101.102.104:8080"), // not a peer
+		wantErr: anyErr(),
+	})
+
 	// tcp
 	add(step{reset: true})
 	add(step{
@@ -328,6 +407,79 @@ type step struct {
 		command: cmd("--remove tcp 123"),
 		want:    &ipn.ServeConfig{},
 	})
+	add(step{
+		command: cmd("tcp 0"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("tcp otherhost:5432"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("tcp --terminate-tls nas:5432"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{
+				443: {
+					TCPForward:   "nas:5432",
+					TerminateTLS: "foo.test.ts.net",
+				},
+			},
+		},
+	})
+	add(step{
+		command: cmd("--remove tcp nas:5432"),
+		want:    &ipn.ServeConfig{},
+	})
+	add(step{reset: true})
+	add(step{
+		command: cmd("tcp --ports=30000-30100 localhost"),
+		want: &ipn.ServeConfig{
+			TCPRanges: []ipn.TCPPortRange{
+				{Ports: tailcfg.PortRange{First: 30000, Last: 30100}, TCPForward: "127.0.0.1"},
+			},
+		},
+	})
+	add(step{
+		command: cmd("tcp --ports=30000-30100 localhost"),
+		want:    nil, // nothing to save
+	})
+	add(step{
+		command: cmd("tcp --ports=30100-30200 nas"), // overlaps
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("tcp --ports=40000-40010 nas"),
+		want: &ipn.ServeConfig{
+			TCPRanges: []ipn.TCPPortRange{
+				{Ports: tailcfg.PortRange{First: 30000, Last: 30100}, TCPForward: "127.0.0.1"},
+				{Ports: tailcfg.PortRange{First: 40000, Last: 40010}, TCPForward: "nas"},
+			},
+		},
+	})
+	add(step{
+		command: cmd("tcp --ports=50000-50010 otherhost"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("tcp --ports=0-10 localhost"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("tcp --ports=50000-50010 --terminate-tls localhost"),
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("--remove tcp --ports=30000-30100 nas"), // wrong target
+		wantErr: anyErr(),
+	})
+	add(step{
+		command: cmd("--remove tcp --ports=30000-30100 localhost"),
+		want: &ipn.ServeConfig{
+			TCPRanges: []ipn.TCPPortRange{
+				{Ports: tailcfg.PortRange{First: 40000, Last: 40010}, TCPForward: "nas"},
+			},
+		},
+	})
 
 	// text
 	add(step{reset: true})
@@ -605,6 +757,94 @@ type step struct {
 		wantErr: anyErr(),
 	})
 
+	// reset
+	add(step{reset: true})
+	add(step{
+		command: cmd("/ proxy 3000"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("--serve-port=8443 /hi text hi"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 8443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000"},
+				}},
+				"foo.test.ts.net:8443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/hi": {Text: "hi"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("funnel on"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 8443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000"},
+				}},
+				"foo.test.ts.net:8443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/hi": {Text: "hi"},
+				}},
+			},
+			AllowFunnel: map[ipn.HostPort]bool{"foo.test.ts.net:443": true},
+		},
+	})
+	add(step{
+		command: cmd("reset --scope=funnel --dry-run"),
+		want:    nil, // nothing to save
+	})
+	add(step{
+		command: cmd("reset --scope=funnel"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 8443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000"},
+				}},
+				"foo.test.ts.net:8443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/hi": {Text: "hi"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("--serve-port=8443 reset --scope=port"),
+		want: &ipn.ServeConfig{
+			TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}},
+			Web: map[ipn.HostPort]*ipn.WebServerConfig{
+				"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+					"/": {Proxy: "http://127.0.0.1:3000"},
+				}},
+			},
+		},
+	})
+	add(step{
+		command: cmd("reset --scope=path /nope"),
+		want:    nil, // nothing to save
+	})
+	add(step{
+		command: cmd("reset --scope=path /"),
+		want:    &ipn.ServeConfig{},
+	})
+	add(step{
+		command: cmd("reset --scope=path"),
+		wantErr: exactErr(flag.ErrHelp, "flag.ErrHelp"),
+	})
+	add(step{
+		command: cmd("reset --scope=bogus"),
+		wantErr: anyErr(),
+	})
+
 	lc := &fakeLocalServeClient{}
 	// And now run the steps above.
 	for i, st := range steps {
@@ -671,14 +911,20 @@ type fakeLocalServeClient struct {
 // fakeStatus is a fake ipnstate.Status value for tests.
 // It's not a full implementation, just enough to test the serve command.
 //
-// It returns a state that's running, with a fake DNSName and the Funnel
-// node attribute capability.
+// It returns a state that's running, with a fake DNSName, the Funnel
+// node attribute capability, and a single peer named "nas".
 var fakeStatus = &ipnstate.Status{
 	BackendState: ipn.Running.String(),
 	Self: &ipnstate.PeerStatus{
 		DNSName:      "foo.test.ts.net",
 		Capabilities: []string{tailcfg.NodeAttrFunnel},
 	},
+	Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+		key.NewNode().Public(): {
+			DNSName:      "nas.test.ts.net.",
+			TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.101.102.103")},
+		},
+	},
 }
 
 func (lc *fakeLocalServeClient) Status(ctx context.Context) (*ipnstate.Status, error) {
@@ -721,3 +967,29 @@ func cmd(s string) []string {
 	fmt.Printf("cmd: %v", cmds)
 	return cmds
 }
+
+func TestResetServeConfig(t *testing.T) {
+	sc := &ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{443: {HTTPS: true}, 5432: {TCPForward: "127.0.0.1:5432"}},
+		Web: map[ipn.HostPort]*ipn.WebServerConfig{
+			"foo.test.ts.net:443": {Handlers: map[string]*ipn.HTTPHandler{
+				"/":        {Proxy: "http://127.0.0.1:3000"},
+				"/images/": {Path: "/var/www/images"},
+			}},
+		},
+		AllowFunnel: map[ipn.HostPort]bool{"foo.test.ts.net:443": true},
+	}
+	got := resetServeConfig(sc, "all", 443, "", "")
+	want := []string{
+		"Funnel on foo.test.ts.net:443",
+		"handler for https://foo.test.ts.net:443/",
+		"handler for https://foo.test.ts.net:443/images/",
+		"TCP forward from port 5432 to 127.0.0.1:5432",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("removed:\n got %q\nwant %q", got, want)
+	}
+	if !reflect.DeepEqual(sc, &ipn.ServeConfig{}) {
+		t.Errorf("after reset, config = %s; want empty", asJSON(sc))
+	}
+}
@@ -8,12 +8,16 @@
 	"errors"
 	"flag"
 	"fmt"
+	"math"
 	"net/netip"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"tailscale.com/ipn"
+	"tailscale.com/net/netutil"
 	"tailscale.com/net/tsaddr"
+	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/safesocket"
+	"tailscale.com/version/distro"
 )
 
 var setCmd = &ffcli.Command{
@@ -32,9 +36,20 @@
 
 type setArgsT struct {
 	acceptRoutes           bool
+	rejectRoutes           string
+	keepAlivePeers         string
 	acceptDNS              bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
+	exitNodeAllowLANCIDRs  string
+	exitNodeKillSwitch     bool
+	routeMetric            uint
+	exitRateLimit          uint64
+	maintenance            bool
+	remoteAdmin            string
+	powerSave              bool
+	recordUsage            bool
+	proxyURL               string
 	shieldsUp              bool
 	runSSH                 bool
 	hostname               string
@@ -44,6 +59,9 @@ type setArgsT struct {
 	acceptedRisks          string
 	profileName            string
 	forceDaemon            bool
+	snat                   bool
+	netfilterMode          string
+	singleRoutes           bool
 }
 
 func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
@@ -51,21 +69,40 @@ func newSetFlagSet(goos string, setArgs *setArgsT) *flag.FlagSet {
 
 	setf.StringVar(&setArgs.profileName, "nickname", "", "nickname for the current account")
 	setf.BoolVar(&setArgs.acceptRoutes, "accept-routes", false, "accept routes advertised by other Tailscale nodes")
+	setf.StringVar(&setArgs.rejectRoutes, "reject-routes", "", "routes not to accept from other nodes with --accept-routes, including any routes within them (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\") or empty string to accept all")
+	setf.StringVar(&setArgs.keepAlivePeers, "keepalive-peers", "", "peers to keep the path to warm with WireGuard keepalives, by hostname, Tailscale IP or ACL tag (comma-separated, e.g. \"office-router,tag:server\") or empty string for none")
 	setf.BoolVar(&setArgs.acceptDNS, "accept-dns", false, "accept DNS configuration from the admin panel")
 	setf.StringVar(&setArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	setf.BoolVar(&setArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
+	setf.StringVar(&setArgs.exitNodeAllowLANCIDRs, "exit-node-allow-lan-cidrs", "", "additional routes to access directly instead of via the exit node with --exit-node-allow-lan-access (comma-separated, e.g. \"192.168.2.0/24,224.0.0.251/32\") or empty string for none")
+	setf.BoolVar(&setArgs.exitNodeKillSwitch, "exit-node-kill-switch", false, "Block internet traffic while the exit node is unreachable, instead of sending it directly")
 	setf.BoolVar(&setArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	setf.BoolVar(&setArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	setf.StringVar(&setArgs.hostname, "hostname", "", "hostname to use instead of the one provided by the OS")
+	setf.StringVar(&setArgs.proxyURL, "proxy-url", "", `URL of an upstream HTTP, HTTPS or SOCKS5 proxy (e.g. "http://proxy:3128" or "socks5://proxy:1080") for control, logging and DERP traffic, or empty string to use the environment's proxy`)
 	setf.StringVar(&setArgs.advertiseRoutes, "advertise-routes", "", "routes to advertise to other nodes (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\") or empty string to not advertise routes")
 	setf.BoolVar(&setArgs.advertiseDefaultRoute, "advertise-exit-node", false, "offer to be an exit node for internet traffic for the tailnet")
+	setf.BoolVar(&setArgs.singleRoutes, "host-routes", false, "HIDDEN: install host routes to other Tailscale nodes")
 	if safesocket.GOOSUsesPeerCreds(goos) {
 		setf.StringVar(&setArgs.opUser, "operator", "", "Unix username to allow to operate on tailscaled without sudo")
 	}
 	switch goos {
+	case "linux":
+		setf.BoolVar(&setArgs.snat, "snat-subnet-routes", false, "source NAT traffic to local routes advertised with --advertise-routes")
+		setf.StringVar(&setArgs.netfilterMode, "netfilter-mode", "", "netfilter mode (one of on, nodivert, off)")
 	case "windows":
 		setf.BoolVar(&setArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
+	switch goos {
+	case "linux", "windows":
+		setf.UintVar(&setArgs.routeMetric, "route-metric", 0, "metric of the routes Tailscale installs, to prefer them over (lower) or under (higher) other VPNs' routes to the same prefixes; 0 means the OS default")
+	}
+
+	setf.Uint64Var(&setArgs.exitRateLimit, "exit-rate-limit", 0, "maximum bytes per second forwarded between each peer and the internet or advertised routes, when acting as an exit node or subnet router; 0 means no limit")
+	setf.BoolVar(&setArgs.maintenance, "maintenance", false, "mark this node as in maintenance, so peers avoid routing through it while other routers are available")
+	setf.BoolVar(&setArgs.powerSave, "power-save", false, "always run in the low-power mode, which probes peers less often to save battery; it's on regardless while running on battery, when logs are also uploaded less often")
+	setf.BoolVar(&setArgs.recordUsage, "record-usage", false, "record the traffic sent to and received from each peer, for \"tailscale usage\"")
+	setf.StringVar(&setArgs.remoteAdmin, "remote-admin", "", "allow tagged peers granted remote administration by the tailnet policy to use this node's LocalAPI: \"read\" for read-only access, \"write\" for full access, or empty to disallow")
 
 	registerAcceptRiskFlag(setf, &setArgs.acceptedRisks)
 	return setf
@@ -92,13 +129,43 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 			RouteAll:               setArgs.acceptRoutes,
 			CorpDNS:                setArgs.acceptDNS,
 			ExitNodeAllowLANAccess: setArgs.exitNodeAllowLANAccess,
+			KillSwitch:             setArgs.exitNodeKillSwitch,
 			ShieldsUp:              setArgs.shieldsUp,
 			RunSSH:                 setArgs.runSSH,
 			Hostname:               setArgs.hostname,
+			ProxyURL:               setArgs.proxyURL,
 			OperatorUser:           setArgs.opUser,
 			ForceDaemon:            setArgs.forceDaemon,
+			NoSNAT:                 !setArgs.snat,
+			AllowSingleHosts:       setArgs.singleRoutes,
+			ExitRateLimit:          setArgs.exitRateLimit,
+			Maintenance:            setArgs.maintenance,
+			RemoteAdmin:            setArgs.remoteAdmin,
+			PowerSave:              setArgs.powerSave,
+			RecordUsage:            setArgs.recordUsage,
 		},
 	}
+	if setArgs.routeMetric > math.MaxUint32 {
+		return fmt.Errorf("invalid value --route-metric=%d", setArgs.routeMetric)
+	}
+	maskedPrefs.Prefs.RouteMetric = uint32(setArgs.routeMetric)
+	if maskedPrefs.Prefs.RejectRoutes, err = parseRejectRoutes(setArgs.rejectRoutes); err != nil {
+		return err
+	}
+	if maskedPrefs.Prefs.KeepAlivePeers, err = parseKeepAlivePeers(setArgs.keepAlivePeers); err != nil {
+		return err
+	}
+	if maskedPrefs.Prefs.ExitNodeAllowLANCIDRs, err = parseLANCIDRs(setArgs.exitNodeAllowLANCIDRs); err != nil {
+		return err
+	}
+	if setArgs.proxyURL != "" {
+		if _, err := tshttpproxy.ParseProxyURL(setArgs.proxyURL); err != nil {
+			return fmt.Errorf("invalid value --proxy-url=%q: %w", setArgs.proxyURL, err)
+		}
+	}
+	if err := checkRemoteAdminFlag(setArgs.remoteAdmin); err != nil {
+		return err
+	}
 
 	if setArgs.exitNodeIP != "" {
 		if err := maskedPrefs.Prefs.SetExitNodeIP(setArgs.exitNodeIP, st); err != nil {
@@ -123,6 +190,14 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 	if maskedPrefs.IsEmpty() {
 		return flag.ErrHelp
 	}
+	if maskedPrefs.NetfilterModeSet {
+		if distro.Get() == distro.Synology && setArgs.netfilterMode != "off" {
+			return errors.New("--netfilter-mode values besides \"off\" not supported on Synology; see https://github.com/tailscale/tailscale/issues/1995")
+		}
+		if maskedPrefs.NetfilterMode, err = parseNetfilterMode(setArgs.netfilterMode, warnf); err != nil {
+			return err
+		}
+	}
 
 	curPrefs, err := localClient.GetPrefs(ctx)
 	if err != nil {
@@ -147,6 +222,10 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 		return err
 	}
 
+	if maskedPrefs.AdvertiseRoutesSet && len(maskedPrefs.AdvertiseRoutes) > 0 {
+		checkIPForwarding(ctx, true)
+	}
+
 	_, err = localClient.EditPrefs(ctx, maskedPrefs)
 	return err
 }
@@ -159,11 +238,11 @@ func runSet(ctx context.Context, args []string) (retErr error) {
 // setArgs is the parsed command-line arguments.
 func calcAdvertiseRoutesForSet(advertiseExitNodeSet, advertiseRoutesSet bool, curPrefs *ipn.Prefs, setArgs setArgsT) (routes []netip.Prefix, err error) {
 	if advertiseExitNodeSet && advertiseRoutesSet {
-		return calcAdvertiseRoutes(setArgs.advertiseRoutes, setArgs.advertiseDefaultRoute)
+		return netutil.CalcAdvertiseRoutes(setArgs.advertiseRoutes, setArgs.advertiseDefaultRoute)
 
 	}
 	if advertiseRoutesSet {
-		return calcAdvertiseRoutes(setArgs.advertiseRoutes, curPrefs.AdvertisesExitNode())
+		return netutil.CalcAdvertiseRoutes(setArgs.advertiseRoutes, curPrefs.AdvertisesExitNode())
 	}
 	if advertiseExitNodeSet {
 		alreadyAdvertisesExitNode := curPrefs.AdvertisesExitNode()
@@ -4,6 +4,7 @@
 package cli
 
 import (
+	"flag"
 	"net/netip"
 	"reflect"
 	"testing"
@@ -129,3 +130,27 @@ func TestCalcAdvertiseRoutesForSet(t *testing.T) {
 		})
 	}
 }
+
+func TestSetFlagsCoverUpPrefs(t *testing.T) {
+	for _, goos := range geese {
+		var setArgs setArgsT
+		setFlags := map[string]bool{}
+		newSetFlagSet(goos, &setArgs).VisitAll(func(f *flag.Flag) {
+			setFlags[f.Name] = true
+		})
+		var upArgs upArgsT
+		newUpFlagSet(goos, &upArgs, "up").VisitAll(func(f *flag.Flag) {
+			if _, ok := prefsOfFlag[f.Name]; !ok {
+				return
+			}
+			switch f.Name {
+			case "login-server", "advertise-tags":
+				// Changing these needs a new login, with "up".
+				return
+			}
+			if !setFlags[f.Name] {
+				t.Errorf("up flag --%s is not a set flag on %s", f.Name, goos)
+			}
+		})
+	}
+}
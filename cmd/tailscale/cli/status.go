@@ -14,8 +14,10 @@
 	"net/http"
 	"net/netip"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3/ffcli"
 	"github.com/toqueteos/webbrowser"
@@ -28,7 +30,7 @@
 
 var statusCmd = &ffcli.Command{
 	Name:       "status",
-	ShortUsage: "status [--active] [--web] [--json]",
+	ShortUsage: "status [--active] [--web] [--json] [--health [--prometheus]]",
 	ShortHelp:  "Show state of tailscaled and its connections",
 	LongHelp: strings.TrimSpace(`
 
@@ -44,6 +46,17 @@
 (and be sure to select branch/tag that corresponds to the version
  of Tailscale you're running)
 
+HEALTH
+
+With --health, only the daemon's health problems are printed, one per
+line with their severity, code and description, for monitoring
+systems. The exit status follows the Nagios plugin convention: 0 if
+there are no problems, 1 if there are only warnings, and 2 if there are
+errors. With --json, the problems are printed as a JSON array of the
+"type HealthWarning" declaration in the file above, and with
+--prometheus, in the Prometheus text format for node-exporter's
+textfile collector; both exit 0.
+
 `),
 	Exec: runStatus,
 	FlagSet: (func() *flag.FlagSet {
@@ -55,6 +68,8 @@
 		fs.BoolVar(&statusArgs.peers, "peers", true, "show status of peers")
 		fs.StringVar(&statusArgs.listen, "listen", "127.0.0.1:8384", "listen address for web mode; use port 0 for automatic")
 		fs.BoolVar(&statusArgs.browser, "browser", true, "Open a browser in web mode")
+		fs.BoolVar(&statusArgs.health, "health", false, "show only health problems, for monitoring systems")
+		fs.BoolVar(&statusArgs.prometheus, "prometheus", false, "with --health, output in Prometheus text format")
 		return fs
 	})(),
 }
@@ -67,12 +82,21 @@
 	active  bool   // in CLI mode, filter output to only peers with active sessions
 	self    bool   // in CLI mode, show status of local machine
 	peers   bool   // in CLI mode, show status of peer machines
+
+	health     bool // show only health problems
+	prometheus bool // in health mode, output in Prometheus text format
 }
 
 func runStatus(ctx context.Context, args []string) error {
 	if len(args) > 0 {
 		return errors.New("unexpected non-flag arguments to 'tailscale status'")
 	}
+	if statusArgs.health {
+		return runStatusHealth(ctx)
+	}
+	if statusArgs.prometheus {
+		return errors.New("--prometheus requires --health")
+	}
 	getStatus := localClient.Status
 	if !statusArgs.peers {
 		getStatus = localClient.StatusWithoutPeers
@@ -148,6 +172,7 @@ func runStatus(ctx context.Context, args []string) error {
 		os.Exit(1)
 	}
 
+	now := time.Now()
 	var buf bytes.Buffer
 	f := func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }
 	printPS := func(ps *ipnstate.PeerStatus) {
@@ -194,6 +219,12 @@ func runStatus(ctx context.Context, args []string) error {
 		if anyTraffic {
 			f(", tx %d rx %d", ps.TxBytes, ps.RxBytes)
 		}
+		if h := keyExpiryHint(ps, now); h != "" {
+			f("; %s", h)
+		}
+		if ps.Maintenance {
+			f("; in maintenance")
+		}
 		f("\n")
 	}
 
@@ -222,10 +253,185 @@ func runStatus(ctx context.Context, args []string) error {
 		outln()
 		printHealth()
 	}
+	if st.PowerSave {
+		outln()
+		printf("# Low-power mode is on: peers are probed and logs uploaded less often.\n")
+		printf("# See the powersave_* and magicsock_* counters of \"tailscale debug metrics\".\n")
+	}
+	if hints := statusHints(st, now); len(hints) > 0 {
+		outln()
+		printf("# Hints:\n")
+		for _, h := range hints {
+			printf("#     - %s\n", h)
+		}
+	}
 	printFunnelStatus(ctx)
 	return nil
 }
 
+// keyExpiryWarning is how long before a node key expires that "tailscale
+// status" starts pointing it out.
+const keyExpiryWarning = 7 * 24 * time.Hour
+
+// keyExpiryHint returns a short annotation for ps if its node key has
+// expired or expires soon, or the empty string otherwise.
+func keyExpiryHint(ps *ipnstate.PeerStatus, now time.Time) string {
+	if ps.Expired || (ps.KeyExpiry != nil && !ps.KeyExpiry.After(now)) {
+		return "key expired"
+	}
+	if ps.KeyExpiry != nil && ps.KeyExpiry.Sub(now) < keyExpiryWarning {
+		return "key expires in " + roundDuration(ps.KeyExpiry.Sub(now))
+	}
+	return ""
+}
+
+// roundDuration formats d to the nearest hour, or day if it's at least a
+// day long.
+func roundDuration(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", int(d.Round(24*time.Hour)/(24*time.Hour)))
+	}
+	if d < time.Hour {
+		return "<1h"
+	}
+	return fmt.Sprintf("%dh", int(d.Round(time.Hour)/time.Hour))
+}
+
+// runStatusHealth implements "tailscale status --health".
+func runStatusHealth(ctx context.Context) error {
+	st, err := localClient.StatusWithoutPeers(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	ws := st.HealthWarnings
+	switch {
+	case statusArgs.json:
+		if ws == nil {
+			ws = []ipnstate.HealthWarning{} // print [] rather than null
+		}
+		j, err := json.MarshalIndent(ws, "", "  ")
+		if err != nil {
+			return err
+		}
+		printf("%s\n", j)
+	case statusArgs.prometheus:
+		printf("%s", healthPrometheus(ws))
+	default:
+		if len(ws) == 0 {
+			outln("OK")
+		}
+		for _, w := range ws {
+			if w.DocsURL != "" {
+				printf("%s\t%s\t%s (see %s)\n", w.Severity, w.Code, w.Text, w.DocsURL)
+			} else {
+				printf("%s\t%s\t%s\n", w.Severity, w.Code, w.Text)
+			}
+		}
+		if code := healthExitCode(ws); code != 0 {
+			os.Exit(code)
+		}
+	}
+	return nil
+}
+
+// healthExitCode returns the Nagios plugin exit status for the health
+// problems ws: 0 (OK) if there are none, 2 (CRITICAL) if any is an error,
+// and 1 (WARNING) otherwise.
+func healthExitCode(ws []ipnstate.HealthWarning) int {
+	code := 0
+	for _, w := range ws {
+		if w.Severity == "error" {
+			return 2
+		}
+		code = 1
+	}
+	return code
+}
+
+// healthPrometheus returns the health problems ws in the Prometheus text
+// exposition format, with the number of problems by code and severity.
+func healthPrometheus(ws []ipnstate.HealthWarning) string {
+	type key struct{ code, severity string }
+	var keys []key
+	n := map[key]int{}
+	for _, w := range ws {
+		k := key{w.Code, w.Severity}
+		if n[k] == 0 {
+			keys = append(keys, k)
+		}
+		n[k]++
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].code != keys[j].code {
+			return keys[i].code < keys[j].code
+		}
+		return keys[i].severity < keys[j].severity
+	})
+	var b strings.Builder
+	healthy := 1
+	if len(ws) > 0 {
+		healthy = 0
+	}
+	fmt.Fprintf(&b, "# HELP tailscale_healthy Whether tailscaled reports no health problems.\n")
+	fmt.Fprintf(&b, "# TYPE tailscale_healthy gauge\n")
+	fmt.Fprintf(&b, "tailscale_healthy %d\n", healthy)
+	fmt.Fprintf(&b, "# HELP tailscale_health_warnings Number of health problems reported by tailscaled, by code and severity.\n")
+	fmt.Fprintf(&b, "# TYPE tailscale_health_warnings gauge\n")
+	for _, k := range keys {
+		fmt.Fprintf(&b, "tailscale_health_warnings{code=%q,severity=%q} %d\n", k.code, k.severity, n[k])
+	}
+	return b.String()
+}
+
+// statusHints returns actionable hints about problems visible in st, such
+// as this node's key expiring soon or peers being relayed, for the
+// "# Hints" section of "tailscale status".
+func statusHints(st *ipnstate.Status, now time.Time) []string {
+	var hints []string
+	if st.Self != nil {
+		switch h := keyExpiryHint(st.Self, now); {
+		case h == "key expired":
+			hints = append(hints, "This device's node key has expired; run 'tailscale up --force-reauth' to log in again.")
+		case h != "":
+			hints = append(hints, fmt.Sprintf("This device's node key expires in %s; run 'tailscale up --force-reauth' to reauthenticate, or disable key expiry for it in the admin console.", roundDuration(st.Self.KeyExpiry.Sub(now))))
+		}
+	}
+
+	// Match on the health warning codes rather than their text, which
+	// is free-form and may change.
+	var noUDP, staleNetMap, badDERP bool
+	for _, w := range st.HealthWarnings {
+		switch w.Code {
+		case "udp4-unbound":
+			noUDP = true
+		case "control":
+			staleNetMap = true
+		case "derp-home":
+			badDERP = true
+		}
+	}
+	if staleNetMap {
+		hints = append(hints, "tailscaled isn't getting updates from the coordination server, so the peer list may be stale; check this device's connection to the coordination server.")
+	}
+	if badDERP {
+		hints = append(hints, "The connection to this device's home DERP relay is unhealthy, so relayed connections may fail; run 'tailscale netcheck' for details.")
+	}
+
+	var relayed int
+	for _, ps := range st.Peer {
+		if ps.Active && ps.Relay != "" && ps.CurAddr == "" {
+			relayed++
+		}
+	}
+	switch {
+	case noUDP:
+		hints = append(hints, "tailscaled couldn't open a UDP socket, so all connections are relayed through DERP; check whether another program or a policy is blocking UDP.")
+	case relayed > 0:
+		hints = append(hints, fmt.Sprintf("%d active peer(s) are relayed through DERP instead of connected directly; a firewall may be blocking UDP. Run 'tailscale netcheck' and see https://tailscale.com/kb/1082/firewall-ports.", relayed))
+	}
+	return hints
+}
+
 // printFunnelStatus prints the status of the funnel, if it's running.
 // It prints nothing if the funnel is not running.
 func printFunnelStatus(ctx context.Context) {
@@ -6,12 +6,12 @@
 import (
 	"context"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"log"
+	"math"
 	"net/netip"
 	"os"
 	"os/signal"
@@ -24,12 +24,15 @@
 	"time"
 
 	shellquote "github.com/kballard/go-shellquote"
+	"github.com/mattn/go-isatty"
 	"github.com/peterbourgon/ff/v3/ffcli"
 	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/exp/slices"
 	"tailscale.com/health/healthmsg"
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
-	"tailscale.com/net/tsaddr"
+	"tailscale.com/net/netutil"
+	"tailscale.com/net/tshttpproxy"
 	"tailscale.com/safesocket"
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
@@ -57,9 +60,17 @@
 is also used. (The flags --auth-key, --force-reauth, and --qr are not
 considered settings that need to be re-specified when modifying
 settings.)
+
+With --interactive, "tailscale up" asks about the most common settings,
+explaining each one, instead of requiring them to be given as flags.
 `),
 	FlagSet: upFlagSet,
 	Exec: func(ctx context.Context, args []string) error {
+		if upArgsGlobal.interactive {
+			if err := runUpInteractive(ctx); err != nil {
+				return err
+			}
+		}
 		return runUp(ctx, "up", args, upArgsGlobal)
 	},
 }
@@ -99,11 +110,16 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	upf.StringVar(&upArgs.authKeyOrFile, "auth-key", "", `node authorization key; if it begins with "file:", then it's a path to a file containing the authkey`)
 
 	upf.StringVar(&upArgs.server, "login-server", ipn.DefaultControlURL, "base URL of control server")
+	upf.StringVar(&upArgs.proxyURL, "proxy-url", "", `URL of an upstream HTTP, HTTPS or SOCKS5 proxy (e.g. "http://proxy:3128" or "socks5://proxy:1080") for control, logging and DERP traffic, instead of the environment's proxy`)
 	upf.BoolVar(&upArgs.acceptRoutes, "accept-routes", acceptRouteDefault(goos), "accept routes advertised by other Tailscale nodes")
+	upf.StringVar(&upArgs.rejectRoutes, "reject-routes", "", "routes not to accept from other nodes with --accept-routes, including any routes within them (comma-separated, e.g. \"10.0.0.0/8,192.168.0.0/24\")")
+	upf.StringVar(&upArgs.keepAlivePeers, "keepalive-peers", "", "peers to keep the path to warm with WireGuard keepalives, by hostname, Tailscale IP or ACL tag (comma-separated, e.g. \"office-router,tag:server\")")
 	upf.BoolVar(&upArgs.acceptDNS, "accept-dns", true, "accept DNS configuration from the admin panel")
 	upf.BoolVar(&upArgs.singleRoutes, "host-routes", true, "HIDDEN: install host routes to other Tailscale nodes")
 	upf.StringVar(&upArgs.exitNodeIP, "exit-node", "", "Tailscale exit node (IP or base name) for internet traffic, or empty string to not use an exit node")
 	upf.BoolVar(&upArgs.exitNodeAllowLANAccess, "exit-node-allow-lan-access", false, "Allow direct access to the local network when routing traffic via an exit node")
+	upf.StringVar(&upArgs.exitNodeAllowLANCIDRs, "exit-node-allow-lan-cidrs", "", "additional routes to access directly instead of via the exit node with --exit-node-allow-lan-access (comma-separated, e.g. \"192.168.2.0/24,224.0.0.251/32\")")
+	upf.BoolVar(&upArgs.exitNodeKillSwitch, "exit-node-kill-switch", false, "Block internet traffic while the exit node is unreachable, instead of sending it directly")
 	upf.BoolVar(&upArgs.shieldsUp, "shields-up", false, "don't allow incoming connections")
 	upf.BoolVar(&upArgs.runSSH, "ssh", false, "run an SSH server, permitting access per tailnet admin's declared policy")
 	upf.StringVar(&upArgs.advertiseTags, "advertise-tags", "", "comma-separated ACL tags to request; each must start with \"tag:\" (e.g. \"tag:eng,tag:montreal,tag:ssh\")")
@@ -120,6 +136,15 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 	case "windows":
 		upf.BoolVar(&upArgs.forceDaemon, "unattended", false, "run in \"Unattended Mode\" where Tailscale keeps running even after the current GUI user logs out (Windows-only)")
 	}
+	switch goos {
+	case "linux", "windows":
+		upf.UintVar(&upArgs.routeMetric, "route-metric", 0, "metric of the routes Tailscale installs, to prefer them over (lower) or under (higher) other VPNs' routes to the same prefixes; 0 means the OS default")
+	}
+	upf.Uint64Var(&upArgs.exitRateLimit, "exit-rate-limit", 0, "maximum bytes per second forwarded between each peer and the internet or advertised routes, when acting as an exit node or subnet router; 0 means no limit")
+	upf.BoolVar(&upArgs.maintenance, "maintenance", false, "mark this node as in maintenance, so peers avoid routing through it while other routers are available")
+	upf.BoolVar(&upArgs.powerSave, "power-save", false, "always run in the low-power mode, which probes peers less often to save battery; it's on regardless while running on battery, when logs are also uploaded less often")
+	upf.BoolVar(&upArgs.recordUsage, "record-usage", false, "record the traffic sent to and received from each peer, for \"tailscale usage\"")
+	upf.StringVar(&upArgs.remoteAdmin, "remote-admin", "", "allow tagged peers granted remote administration by the tailnet policy to use this node's LocalAPI: \"read\" for read-only access, \"write\" for full access, or empty to disallow")
 	upf.DurationVar(&upArgs.timeout, "timeout", 0, "maximum amount of time to wait for tailscaled to enter a Running state; default (0s) blocks forever")
 
 	if cmd == "login" {
@@ -131,12 +156,89 @@ func newUpFlagSet(goos string, upArgs *upArgsT, cmd string) *flag.FlagSet {
 		upf.BoolVar(&upArgs.json, "json", false, "output in JSON format (WARNING: format subject to change)")
 		upf.BoolVar(&upArgs.reset, "reset", false, "reset unspecified settings to their default values")
 		upf.BoolVar(&upArgs.forceReauth, "force-reauth", false, "force reauthentication")
+		upf.BoolVar(&upArgs.acceptChanges, "accept-changes", false, "apply changes to settings not mentioned on the command line, resetting them to their default values, instead of failing")
+		upf.BoolVar(&upArgs.interactive, "interactive", false, "ask about common settings, with explanations, before connecting")
 		registerAcceptRiskFlag(upf, &upArgs.acceptedRisks)
 	}
 
 	return upf
 }
 
+// parseLANCIDRs parses the comma-separated CIDR prefixes of the
+// --exit-node-allow-lan-cidrs flag.
+func parseLANCIDRs(s string) ([]netip.Prefix, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ret []netip.Prefix
+	for _, f := range strings.Split(s, ",") {
+		ipp, err := netip.ParsePrefix(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR prefix", f)
+		}
+		if ipp != ipp.Masked() {
+			return nil, fmt.Errorf("%s has non-address bits set; expected %s", ipp, ipp.Masked())
+		}
+		if ipp.Bits() == 0 {
+			return nil, fmt.Errorf("%s can't bypass the exit node; use --exit-node= to stop using it", ipp)
+		}
+		ret = append(ret, ipp)
+	}
+	return ret, nil
+}
+
+// parseKeepAlivePeers parses the comma-separated peer selectors of the
+// --keepalive-peers flag.
+func parseKeepAlivePeers(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	ret := strings.Split(s, ",")
+	for _, sel := range ret {
+		if sel == "" {
+			return nil, fmt.Errorf("empty peer in %q", s)
+		}
+		if strings.HasPrefix(sel, "tag:") {
+			if err := tailcfg.CheckTag(sel); err != nil {
+				return nil, fmt.Errorf("tag: %q: %s", sel, err)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseRejectRoutes parses the comma-separated CIDR prefixes of the
+// --reject-routes flag.
+func parseRejectRoutes(s string) ([]netip.Prefix, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var ret []netip.Prefix
+	for _, f := range strings.Split(s, ",") {
+		ipp, err := netip.ParsePrefix(f)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid CIDR prefix", f)
+		}
+		if ipp != ipp.Masked() {
+			return nil, fmt.Errorf("%s has non-address bits set; expected %s", ipp, ipp.Masked())
+		}
+		if ipp.Bits() == 0 {
+			return nil, fmt.Errorf("%s would reject exit node routes; use --exit-node= to stop using an exit node", ipp)
+		}
+		ret = append(ret, ipp)
+	}
+	return ret, nil
+}
+
+// checkRemoteAdminFlag checks the value of the --remote-admin flag.
+func checkRemoteAdminFlag(s string) error {
+	switch s {
+	case "", ipn.RemoteAdminRead, ipn.RemoteAdminWrite:
+		return nil
+	}
+	return fmt.Errorf("invalid value --remote-admin=%q; want %q, %q or empty", s, ipn.RemoteAdminRead, ipn.RemoteAdminWrite)
+}
+
 func defaultNetfilterMode() string {
 	if distro.Get() == distro.Synology {
 		return "off"
@@ -148,11 +250,22 @@ type upArgsT struct {
 	qr                     bool
 	reset                  bool
 	server                 string
+	proxyURL               string
 	acceptRoutes           bool
+	rejectRoutes           string
+	keepAlivePeers         string
 	acceptDNS              bool
 	singleRoutes           bool
 	exitNodeIP             string
 	exitNodeAllowLANAccess bool
+	exitNodeAllowLANCIDRs  string
+	exitNodeKillSwitch     bool
+	routeMetric            uint
+	exitRateLimit          uint64
+	maintenance            bool
+	remoteAdmin            string
+	powerSave              bool
+	recordUsage            bool
 	shieldsUp              bool
 	runSSH                 bool
 	forceReauth            bool
@@ -169,6 +282,8 @@ type upArgsT struct {
 	timeout                time.Duration
 	acceptedRisks          string
 	profileName            string
+	interactive            bool
+	acceptChanges          bool
 }
 
 func (a upArgsT) getAuthKey() (string, error) {
@@ -211,88 +326,16 @@ type upOutputJSON struct {
 	QR           string `json:",omitempty"` // a DataURL (base64) PNG of a QR code AuthURL
 	BackendState string `json:",omitempty"` // name of state like Running or NeedsMachineAuth
 	Error        string `json:",omitempty"` // description of an error
+
+	// PrefChanges, if non-empty, are the changes that would be made to
+	// the node's prefs but need --accept-changes.
+	PrefChanges []upPrefChange `json:",omitempty"`
 }
 
 func warnf(format string, args ...any) {
 	printf("Warning: "+format+"\n", args...)
 }
 
-var (
-	ipv4default = netip.MustParsePrefix("0.0.0.0/0")
-	ipv6default = netip.MustParsePrefix("::/0")
-)
-
-func validateViaPrefix(ipp netip.Prefix) error {
-	if !tsaddr.IsViaPrefix(ipp) {
-		return fmt.Errorf("%v is not a 4-in-6 prefix", ipp)
-	}
-	if ipp.Bits() < (128 - 32) {
-		return fmt.Errorf("%v 4-in-6 prefix must be at least a /%v", ipp, 128-32)
-	}
-	a := ipp.Addr().As16()
-	// The first 64 bits of a are the via prefix.
-	// The next 32 bits are the "site ID".
-	// The last 32 bits are the IPv4.
-	// For now, we reserve the top 3 bytes of the site ID,
-	// and only allow users to use site IDs 0-255.
-	siteID := binary.BigEndian.Uint32(a[8:12])
-	if siteID > 0xFF {
-		return fmt.Errorf("route %v contains invalid site ID %08x; must be 0xff or less", ipp, siteID)
-	}
-	return nil
-}
-
-func calcAdvertiseRoutes(advertiseRoutes string, advertiseDefaultRoute bool) ([]netip.Prefix, error) {
-	routeMap := map[netip.Prefix]bool{}
-	if advertiseRoutes != "" {
-		var default4, default6 bool
-		advroutes := strings.Split(advertiseRoutes, ",")
-		for _, s := range advroutes {
-			ipp, err := netip.ParsePrefix(s)
-			if err != nil {
-				return nil, fmt.Errorf("%q is not a valid IP address or CIDR prefix", s)
-			}
-			if ipp != ipp.Masked() {
-				return nil, fmt.Errorf("%s has non-address bits set; expected %s", ipp, ipp.Masked())
-			}
-			if tsaddr.IsViaPrefix(ipp) {
-				if err := validateViaPrefix(ipp); err != nil {
-					return nil, err
-				}
-			}
-			if ipp == ipv4default {
-				default4 = true
-			} else if ipp == ipv6default {
-				default6 = true
-			}
-			routeMap[ipp] = true
-		}
-		if default4 && !default6 {
-			return nil, fmt.Errorf("%s advertised without its IPv6 counterpart, please also advertise %s", ipv4default, ipv6default)
-		} else if default6 && !default4 {
-			return nil, fmt.Errorf("%s advertised without its IPv4 counterpart, please also advertise %s", ipv6default, ipv4default)
-		}
-	}
-	if advertiseDefaultRoute {
-		routeMap[netip.MustParsePrefix("0.0.0.0/0")] = true
-		routeMap[netip.MustParsePrefix("::/0")] = true
-	}
-	if len(routeMap) == 0 {
-		return nil, nil
-	}
-	routes := make([]netip.Prefix, 0, len(routeMap))
-	for r := range routeMap {
-		routes = append(routes, r)
-	}
-	sort.Slice(routes, func(i, j int) bool {
-		if routes[i].Bits() != routes[j].Bits() {
-			return routes[i].Bits() < routes[j].Bits()
-		}
-		return routes[i].Addr().Less(routes[j].Addr())
-	})
-	return routes, nil
-}
-
 // prefsFromUpArgs returns the ipn.Prefs for the provided args.
 //
 // Note that the parameters upArgs and warnf are named intentionally
@@ -300,7 +343,7 @@ func calcAdvertiseRoutes(advertiseRoutes string, advertiseDefaultRoute bool) ([]
 // function exists for testing and should have no side effects or
 // outside interactions (e.g. no making Tailscale LocalAPI calls).
 func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goos string) (*ipn.Prefs, error) {
-	routes, err := calcAdvertiseRoutes(upArgs.advertiseRoutes, upArgs.advertiseDefaultRoute)
+	routes, err := netutil.CalcAdvertiseRoutes(upArgs.advertiseRoutes, upArgs.advertiseDefaultRoute)
 	if err != nil {
 		return nil, err
 	}
@@ -308,6 +351,24 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	if upArgs.exitNodeIP == "" && upArgs.exitNodeAllowLANAccess {
 		return nil, fmt.Errorf("--exit-node-allow-lan-access can only be used with --exit-node")
 	}
+	if upArgs.exitNodeIP == "" && upArgs.exitNodeKillSwitch {
+		return nil, fmt.Errorf("--exit-node-kill-switch can only be used with --exit-node")
+	}
+	rejectRoutes, err := parseRejectRoutes(upArgs.rejectRoutes)
+	if err != nil {
+		return nil, err
+	}
+	keepAlivePeers, err := parseKeepAlivePeers(upArgs.keepAlivePeers)
+	if err != nil {
+		return nil, err
+	}
+	lanCIDRs, err := parseLANCIDRs(upArgs.exitNodeAllowLANCIDRs)
+	if err != nil {
+		return nil, err
+	}
+	if len(lanCIDRs) > 0 && !upArgs.exitNodeAllowLANAccess {
+		return nil, fmt.Errorf("--exit-node-allow-lan-cidrs can only be used with --exit-node-allow-lan-access")
+	}
 
 	var tags []string
 	if upArgs.advertiseTags != "" {
@@ -328,6 +389,8 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	prefs.ControlURL = upArgs.server
 	prefs.WantRunning = true
 	prefs.RouteAll = upArgs.acceptRoutes
+	prefs.RejectRoutes = rejectRoutes
+	prefs.KeepAlivePeers = keepAlivePeers
 	if distro.Get() == distro.Synology {
 		// ipn.NewPrefs returns a non-zero Netfilter default. But Synology only
 		// supports "off" mode.
@@ -344,6 +407,8 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	}
 
 	prefs.ExitNodeAllowLANAccess = upArgs.exitNodeAllowLANAccess
+	prefs.ExitNodeAllowLANCIDRs = lanCIDRs
+	prefs.KillSwitch = upArgs.exitNodeKillSwitch
 	prefs.CorpDNS = upArgs.acceptDNS
 	prefs.AllowSingleHosts = upArgs.singleRoutes
 	prefs.ShieldsUp = upArgs.shieldsUp
@@ -355,27 +420,56 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 	prefs.OperatorUser = upArgs.opUser
 	prefs.ProfileName = upArgs.profileName
 
+	if upArgs.proxyURL != "" {
+		if _, err := tshttpproxy.ParseProxyURL(upArgs.proxyURL); err != nil {
+			return nil, fmt.Errorf("invalid value --proxy-url=%q: %w", upArgs.proxyURL, err)
+		}
+	}
+	prefs.ProxyURL = upArgs.proxyURL
+
+	if upArgs.routeMetric > math.MaxUint32 {
+		return nil, fmt.Errorf("invalid value --route-metric=%d", upArgs.routeMetric)
+	}
+	prefs.RouteMetric = uint32(upArgs.routeMetric)
+	prefs.ExitRateLimit = upArgs.exitRateLimit
+	prefs.Maintenance = upArgs.maintenance
+	if err := checkRemoteAdminFlag(upArgs.remoteAdmin); err != nil {
+		return nil, err
+	}
+	prefs.RemoteAdmin = upArgs.remoteAdmin
+	prefs.PowerSave = upArgs.powerSave
+	prefs.RecordUsage = upArgs.recordUsage
+
 	if goos == "linux" {
 		prefs.NoSNAT = !upArgs.snat
 
-		switch upArgs.netfilterMode {
-		case "on":
-			prefs.NetfilterMode = preftype.NetfilterOn
-		case "nodivert":
-			prefs.NetfilterMode = preftype.NetfilterNoDivert
-			warnf("netfilter=nodivert; add iptables calls to ts-* chains manually.")
-		case "off":
-			prefs.NetfilterMode = preftype.NetfilterOff
-			if defaultNetfilterMode() != "off" {
-				warnf("netfilter=off; configure iptables yourself.")
-			}
-		default:
-			return nil, fmt.Errorf("invalid value --netfilter-mode=%q", upArgs.netfilterMode)
+		var err error
+		if prefs.NetfilterMode, err = parseNetfilterMode(upArgs.netfilterMode, warnf); err != nil {
+			return nil, err
 		}
 	}
 	return prefs, nil
 }
 
+// parseNetfilterMode returns the NetfilterMode of the --netfilter-mode flag
+// value v, warning with warnf about the modes that leave iptables to the
+// user.
+func parseNetfilterMode(v string, warnf logger.Logf) (preftype.NetfilterMode, error) {
+	switch v {
+	case "on":
+		return preftype.NetfilterOn, nil
+	case "nodivert":
+		warnf("netfilter=nodivert; add iptables calls to ts-* chains manually.")
+		return preftype.NetfilterNoDivert, nil
+	case "off":
+		if defaultNetfilterMode() != "off" {
+			warnf("netfilter=off; configure iptables yourself.")
+		}
+		return preftype.NetfilterOff, nil
+	}
+	return 0, fmt.Errorf("invalid value --netfilter-mode=%q", v)
+}
+
 // updatePrefs returns how to edit preferences based on the
 // flag-provided 'prefs' and the currently active 'curPrefs'.
 //
@@ -388,11 +482,16 @@ func prefsFromUpArgs(upArgs upArgsT, warnf logger.Logf, st *ipnstate.Status, goo
 // transition to running from a previously-logged-in but down state,
 // without changing any settings.
 func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, justEditMP *ipn.MaskedPrefs, err error) {
+	var resetAccepted bool
 	if !env.upArgs.reset {
 		applyImplicitPrefs(prefs, curPrefs, env)
 
 		if err := checkForAccidentalSettingReverts(prefs, curPrefs, env); err != nil {
-			return false, nil, err
+			if !env.upArgs.acceptChanges {
+				return false, nil, err
+			}
+			// All changes were accepted, so treat every flag as mentioned.
+			resetAccepted = true
 		}
 	}
 
@@ -427,7 +526,7 @@ func updatePrefs(prefs, curPrefs *ipn.Prefs, env upCheckEnv) (simpleUp bool, jus
 		justEditMP.WantRunningSet = true
 		justEditMP.Prefs = *prefs
 		visitFlags := env.flagSet.Visit
-		if env.upArgs.reset {
+		if env.upArgs.reset || resetAccepted {
 			visitFlags = env.flagSet.VisitAll
 		}
 		visitFlags(func(f *flag.Flag) {
@@ -498,9 +597,7 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 	}
 
 	if len(prefs.AdvertiseRoutes) > 0 {
-		if err := localClient.CheckIPForwarding(context.Background()); err != nil {
-			warnf("%v", err)
-		}
+		checkIPForwarding(ctx, !upArgs.json)
 	}
 
 	curPrefs, err := localClient.GetPrefs(ctx)
@@ -530,6 +627,14 @@ func runUp(ctx context.Context, cmd string, args []string, upArgs upArgsT) (retE
 	}()
 
 	simpleUp, justEditMP, err := updatePrefs(prefs, curPrefs, env)
+	var revertErr *prefRevertError
+	if errors.As(err, &revertErr) {
+		if err := confirmPrefChanges(revertErr, env); err != nil {
+			return err
+		}
+		env.upArgs.acceptChanges = true
+		simpleUp, justEditMP, err = updatePrefs(prefs, curPrefs, env)
+	}
 	if err != nil {
 		fatalf("%s", err)
 	}
@@ -763,14 +868,25 @@ func init() {
 	// The rest are 1:1:
 	addPrefFlagMapping("accept-dns", "CorpDNS")
 	addPrefFlagMapping("accept-routes", "RouteAll")
+	addPrefFlagMapping("reject-routes", "RejectRoutes")
+	addPrefFlagMapping("keepalive-peers", "KeepAlivePeers")
 	addPrefFlagMapping("advertise-tags", "AdvertiseTags")
 	addPrefFlagMapping("host-routes", "AllowSingleHosts")
 	addPrefFlagMapping("hostname", "Hostname")
 	addPrefFlagMapping("login-server", "ControlURL")
 	addPrefFlagMapping("netfilter-mode", "NetfilterMode")
+	addPrefFlagMapping("proxy-url", "ProxyURL")
+	addPrefFlagMapping("route-metric", "RouteMetric")
+	addPrefFlagMapping("exit-rate-limit", "ExitRateLimit")
+	addPrefFlagMapping("maintenance", "Maintenance")
+	addPrefFlagMapping("remote-admin", "RemoteAdmin")
+	addPrefFlagMapping("power-save", "PowerSave")
+	addPrefFlagMapping("record-usage", "RecordUsage")
 	addPrefFlagMapping("shields-up", "ShieldsUp")
 	addPrefFlagMapping("snat-subnet-routes", "NoSNAT")
 	addPrefFlagMapping("exit-node-allow-lan-access", "ExitNodeAllowLANAccess")
+	addPrefFlagMapping("exit-node-allow-lan-cidrs", "ExitNodeAllowLANCIDRs")
+	addPrefFlagMapping("exit-node-kill-switch", "KillSwitch")
 	addPrefFlagMapping("unattended", "ForceDaemon")
 	addPrefFlagMapping("operator", "OperatorUser")
 	addPrefFlagMapping("ssh", "RunSSH")
@@ -792,7 +908,7 @@ func addPrefFlagMapping(flagName string, prefNames ...string) {
 // correspond to an ipn.Pref.
 func preflessFlag(flagName string) bool {
 	switch flagName {
-	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk":
+	case "auth-key", "force-reauth", "reset", "qr", "json", "timeout", "accept-risk", "interactive", "accept-changes":
 		return true
 	}
 	return false
@@ -917,7 +1033,141 @@ type isBool interface {
 		fmt.Fprintf(&sb, " %s", a)
 	}
 	sb.WriteString("\n\n")
-	return errors.New(sb.String())
+	return &prefRevertError{msg: sb.String(), changes: curPrefs.Changes(newPrefs)}
+}
+
+// prefRevertError is the error returned by checkForAccidentalSettingReverts.
+type prefRevertError struct {
+	msg string
+
+	// changes are all the changes to curPrefs that the "up" invocation
+	// would make, including the implicit resets.
+	changes []ipn.PrefChange
+}
+
+func (e *prefRevertError) Error() string { return e.msg }
+
+// upPrefChange is a pref change as reported by "tailscale up".
+type upPrefChange struct {
+	ipn.PrefChange
+	Flag     string `json:",omitempty"` // flag controlling the pref, if any
+	Implicit bool   `json:",omitempty"` // whether the change is due to Flag not being mentioned
+}
+
+// upPrefChanges annotates changes with the flags that control them.
+func upPrefChanges(changes []ipn.PrefChange, flagSet *flag.FlagSet) []upPrefChange {
+	flagIsSet := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		flagIsSet[f.Name] = true
+	})
+	var ret []upPrefChange
+	for _, c := range changes {
+		uc := upPrefChange{PrefChange: c}
+		var flags []string
+		for flagName, prefs := range prefsOfFlag {
+			if slices.Contains(prefs, c.Field) && flagSet.Lookup(flagName) != nil {
+				flags = append(flags, flagName)
+			}
+		}
+		sort.Strings(flags)
+		if len(flags) > 0 {
+			uc.Flag = flags[0]
+			uc.Implicit = true
+			for _, f := range flags {
+				if flagIsSet[f] {
+					uc.Flag = f
+					uc.Implicit = false
+					break
+				}
+			}
+		}
+		ret = append(ret, uc)
+	}
+	return ret
+}
+
+// confirmPrefChanges shows the changes in revertErr and, if stdin is a
+// terminal, asks whether to make them anyway. It returns an error if the
+// changes should not be made.
+func confirmPrefChanges(revertErr *prefRevertError, env upCheckEnv) error {
+	changes := upPrefChanges(revertErr.changes, env.flagSet)
+	const rerun = "re-run with --accept-changes to apply these changes"
+	if env.upArgs.json {
+		js := &upOutputJSON{
+			Error:       "settings would change; " + rerun,
+			PrefChanges: changes,
+		}
+		data, err := json.MarshalIndent(js, "", "  ")
+		if err != nil {
+			return err
+		}
+		outln(string(data))
+		return errors.New(js.Error)
+	}
+	fmt.Fprint(Stderr, revertErr.Error())
+	fmt.Fprintf(Stderr, "Alternatively, review the changes this command would make:\n\n")
+	for _, c := range changes {
+		name := c.Field
+		if c.Flag != "" {
+			name = "--" + c.Flag
+		}
+		fmt.Fprintf(Stderr, "\t%s: %v -> %v", name, c.Old, c.New)
+		if c.Implicit {
+			fmt.Fprintf(Stderr, " (not mentioned; reset to default)")
+		}
+		fmt.Fprintln(Stderr)
+	}
+	fmt.Fprintln(Stderr)
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return errors.New(rerun)
+	}
+	fmt.Fprint(Stderr, "Apply these changes? [y/N] ")
+	var resp string
+	fmt.Scanln(&resp)
+	switch strings.ToLower(resp) {
+	case "y", "yes":
+		return nil
+	}
+	return errors.New("aborted; " + rerun)
+}
+
+// checkIPForwarding warns if the system isn't configured to forward the
+// packets of advertised routes. If prompt and stdin is a terminal, it
+// offers to enable the needed sysctls.
+func checkIPForwarding(ctx context.Context, prompt bool) {
+	r, err := localClient.IPForwardingReport(ctx)
+	if err != nil {
+		warnf("%v", err)
+		return
+	}
+	if r.Warning == "" {
+		return
+	}
+	warnf("%s", r.Warning)
+	if !prompt || len(r.DisabledSysctls) == 0 || !isatty.IsTerminal(os.Stdin.Fd()) {
+		return
+	}
+	fmt.Fprintf(Stderr, "Enable %s now, until reboot? [y/N] ", strings.Join(r.DisabledSysctls, " and "))
+	var resp string
+	fmt.Scanln(&resp)
+	switch strings.ToLower(resp) {
+	case "y", "yes":
+	default:
+		return
+	}
+	fixed, err := localClient.FixIPForwarding(ctx)
+	if err != nil {
+		warnf("enabling IP forwarding: %v", err)
+		return
+	}
+	if fixed.Warning != "" {
+		warnf("%s", fixed.Warning)
+		return
+	}
+	printf("Enabled IP forwarding. To keep it enabled after reboot, add these lines to /etc/sysctl.d/99-tailscale.conf:\n")
+	for _, k := range r.DisabledSysctls {
+		printf("\t%s = 1\n", k)
+	}
 }
 
 // applyImplicitPrefs mutates prefs to add implicit preferences for the user operator.
@@ -982,6 +1232,17 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(prefs.ControlURL)
 		case "accept-routes":
 			set(prefs.RouteAll)
+		case "reject-routes":
+			var sb strings.Builder
+			for i, r := range prefs.RejectRoutes {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(r.String())
+			}
+			set(sb.String())
+		case "keepalive-peers":
+			set(strings.Join(prefs.KeepAlivePeers, ","))
 		case "host-routes":
 			set(prefs.AllowSingleHosts)
 		case "accept-dns":
@@ -992,10 +1253,23 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(exitNodeIPStr())
 		case "exit-node-allow-lan-access":
 			set(prefs.ExitNodeAllowLANAccess)
+		case "exit-node-allow-lan-cidrs":
+			var sb strings.Builder
+			for i, r := range prefs.ExitNodeAllowLANCIDRs {
+				if i > 0 {
+					sb.WriteByte(',')
+				}
+				sb.WriteString(r.String())
+			}
+			set(sb.String())
+		case "exit-node-kill-switch":
+			set(prefs.KillSwitch)
 		case "advertise-tags":
 			set(strings.Join(prefs.AdvertiseTags, ","))
 		case "hostname":
 			set(prefs.Hostname)
+		case "proxy-url":
+			set(prefs.ProxyURL)
 		case "operator":
 			set(prefs.OperatorUser)
 		case "advertise-routes":
@@ -1013,6 +1287,18 @@ func prefsToFlags(env upCheckEnv, prefs *ipn.Prefs) (flagVal map[string]any) {
 			set(!prefs.NoSNAT)
 		case "netfilter-mode":
 			set(prefs.NetfilterMode.String())
+		case "route-metric":
+			set(prefs.RouteMetric)
+		case "exit-rate-limit":
+			set(prefs.ExitRateLimit)
+		case "maintenance":
+			set(prefs.Maintenance)
+		case "remote-admin":
+			set(prefs.RemoteAdmin)
+		case "power-save":
+			set(prefs.PowerSave)
+		case "record-usage":
+			set(prefs.RecordUsage)
 		case "unattended":
 			set(prefs.ForceDaemon)
 		}
@@ -0,0 +1,172 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/mattn/go-isatty"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/version/distro"
+)
+
+// runUpInteractive asks the user about the common "tailscale up" settings
+// before running "up", for "tailscale up --interactive".
+func runUpInteractive(ctx context.Context) error {
+	if upArgsGlobal.json {
+		return errors.New("--interactive and --json are mutually exclusive")
+	}
+	if !isatty.IsTerminal(os.Stdin.Fd()) {
+		return errors.New("--interactive requires a terminal")
+	}
+	curPrefs, err := localClient.GetPrefs(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	st, err := localClient.Status(ctx)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	w := &upWizard{
+		r:        bufio.NewReader(os.Stdin),
+		w:        Stdout,
+		fs:       upFlagSet,
+		goos:     effectiveGOOS(),
+		synology: distro.Get() == distro.Synology,
+	}
+	return w.run(curPrefs, st)
+}
+
+// upWizard walks the user through the settings of "tailscale up",
+// explaining each one. Each answer is applied to fs as if the matching
+// flag had been given on the command line, so the usual "up" checks and
+// error messages apply unchanged.
+type upWizard struct {
+	r        *bufio.Reader
+	w        io.Writer
+	fs       *flag.FlagSet
+	goos     string
+	synology bool // accept-routes and exit nodes aren't supported
+}
+
+// run asks each question whose flag wasn't given explicitly. The default
+// answer for each question is the setting in curPrefs. st is used to find
+// the IP of the current exit node.
+func (w *upWizard) run(curPrefs *ipn.Prefs, st *ipnstate.Status) error {
+	set := map[string]bool{}
+	w.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	fmt.Fprintln(w.w, strings.TrimSpace(`
+This will walk you through connecting this device to your Tailscale network.
+Press Enter to accept the default shown in capitals. Once you're done, you'll
+be given a URL to log in with, if this device isn't logged in already.
+`))
+
+	if !w.synology && !set["accept-routes"] {
+		fmt.Fprintln(w.w, "\nOther devices in your tailnet can act as subnet routers, making networks")
+		fmt.Fprintln(w.w, "behind them (such as a home LAN or a cloud VPC) reachable over Tailscale.")
+		if err := w.askBool("accept-routes", "Use subnet routes advertised by other devices?", curPrefs.RouteAll); err != nil {
+			return err
+		}
+	}
+
+	if !w.synology && !set["exit-node"] {
+		fmt.Fprintln(w.w, "\nAn exit node routes all of this device's internet traffic through another")
+		fmt.Fprintln(w.w, "device in your tailnet, like a traditional VPN.")
+		// Once resolved, the exit node is only in ExitNodeID.
+		def := ""
+		if ip := exitNodeIP(curPrefs, st); ip.IsValid() {
+			def = ip.String()
+		}
+		name, err := w.askString("Exit node to use (name or Tailscale IP), or empty for none", def)
+		if err != nil {
+			return err
+		}
+		if err := w.fs.Set("exit-node", name); err != nil {
+			return err
+		}
+		if name != "" && !set["exit-node-allow-lan-access"] {
+			fmt.Fprintln(w.w, "\nWhile using an exit node, devices on your local network, such as printers,")
+			fmt.Fprintln(w.w, "are unreachable unless you allow local network access.")
+			if err := w.askBool("exit-node-allow-lan-access", "Allow access to your local network?", curPrefs.ExitNodeAllowLANAccess); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !set["advertise-exit-node"] {
+		fmt.Fprintln(w.w, "\nThis device can itself be offered as an exit node for the rest of your")
+		fmt.Fprintln(w.w, "tailnet. It must also be approved in the admin console.")
+		if err := w.askBool("advertise-exit-node", "Offer this device as an exit node?", hasExitNodeRoutes(curPrefs.AdvertiseRoutes)); err != nil {
+			return err
+		}
+	}
+
+	if w.goos == "linux" && !set["ssh"] {
+		fmt.Fprintln(w.w, "\nTailscale SSH lets devices in your tailnet SSH into this one, with access")
+		fmt.Fprintln(w.w, "controlled by your tailnet's policy instead of SSH keys.")
+		if err := w.askBool("ssh", "Run the Tailscale SSH server?", curPrefs.RunSSH); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(w.w)
+	return nil
+}
+
+// askBool asks a yes/no question and sets the boolean flag named
+// flagName to the answer.
+func (w *upWizard) askBool(flagName, question string, def bool) error {
+	choices := "[y/N]"
+	if def {
+		choices = "[Y/n]"
+	}
+	for {
+		ans, err := w.askString(question+" "+choices, "")
+		if err != nil {
+			return err
+		}
+		v := def
+		switch strings.ToLower(ans) {
+		case "":
+		case "y", "yes":
+			v = true
+		case "n", "no":
+			v = false
+		default:
+			fmt.Fprintln(w.w, `Please answer "y" or "n".`)
+			continue
+		}
+		return w.fs.Set(flagName, strconv.FormatBool(v))
+	}
+}
+
+// askString asks question and returns the trimmed answer, or def if the
+// answer is empty.
+func (w *upWizard) askString(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.w, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.w, "%s: ", question)
+	}
+	line, err := w.r.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", errors.New("--interactive: unexpected end of input")
+		}
+		return "", err
+	}
+	if ans := strings.TrimSpace(line); ans != "" {
+		return ans, nil
+	}
+	return def, nil
+}
@@ -0,0 +1,164 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/netip"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+var usageCmd = &ffcli.Command{
+	Name:       "usage",
+	ShortUsage: "usage [--since=YYYY-MM-DD] [--daily] [--json]",
+	ShortHelp:  "Show how much traffic was sent to and received from peers",
+	LongHelp: `"tailscale usage" shows the bytes of Tailscale traffic this device sent to and
+received from each peer, and via each peer's subnet routes or exit node. By
+default, it shows the totals since the start of the current month.
+
+Traffic is only recorded once enabled with "tailscale set --record-usage". The
+totals are updated every 5 minutes and kept across restarts, for the last 100
+days with traffic.`,
+	Exec: runUsage,
+	FlagSet: (func() *flag.FlagSet {
+		fs := newFlagSet("usage")
+		fs.StringVar(&usageArgs.since, "since", "", "show traffic from this date (YYYY-MM-DD) onwards; defaults to the start of the current month")
+		fs.BoolVar(&usageArgs.daily, "daily", false, "show the totals of each day instead of each peer")
+		fs.BoolVar(&usageArgs.json, "json", false, "output in JSON format")
+		return fs
+	})(),
+}
+
+var usageArgs struct {
+	since string
+	daily bool
+	json  bool
+}
+
+func runUsage(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected non-flag arguments to 'tailscale usage'")
+	}
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	if usageArgs.since != "" {
+		var err error
+		since, err = time.ParseInLocation("2006-01-02", usageArgs.since, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --since date %q; want YYYY-MM-DD", usageArgs.since)
+		}
+	}
+	res, err := localClient.Usage(ctx, since)
+	if err != nil {
+		return fixTailscaledConnectError(err)
+	}
+	if usageArgs.json {
+		j, err := json.MarshalIndent(res, "", "\t")
+		if err != nil {
+			return err
+		}
+		outln(string(j))
+		return nil
+	}
+	Stdout.Write(formatUsage(res.Days, usageArgs.daily))
+	if !res.Recording {
+		outln("\nTraffic isn't being recorded. To record it, run: tailscale set --record-usage")
+	}
+	return nil
+}
+
+// formatUsage formats the traffic totals of days as a table for
+// "tailscale usage", with a row per peer and route, or per day if daily.
+func formatUsage(days []apitype.UsageDay, daily bool) []byte {
+	if len(days) == 0 {
+		return []byte("No traffic to or from peers recorded.\n")
+	}
+	var b bytes.Buffer
+	tw := tabwriter.NewWriter(&b, 0, 2, 2, ' ', 0)
+	var total apitype.PeerUsage
+	if daily {
+		fmt.Fprintln(tw, "DATE\tSENT\tRECEIVED")
+		for _, d := range days {
+			var dt apitype.PeerUsage
+			for _, pu := range d.Peers {
+				dt.TxBytes += pu.TxBytes
+				dt.RxBytes += pu.RxBytes
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", d.Date, formatByteCount(dt.TxBytes), formatByteCount(dt.RxBytes))
+			total.TxBytes += dt.TxBytes
+			total.RxBytes += dt.RxBytes
+		}
+		fmt.Fprintf(tw, "TOTAL\t%s\t%s\n", formatByteCount(total.TxBytes), formatByteCount(total.RxBytes))
+		tw.Flush()
+		return b.Bytes()
+	}
+
+	type key struct {
+		peer  tailcfg.StableNodeID
+		route netip.Prefix
+	}
+	sums := map[key]*apitype.PeerUsage{}
+	var rows []*apitype.PeerUsage
+	for _, d := range days { // oldest first, so the latest name wins
+		for _, pu := range d.Peers {
+			k := key{pu.Peer, pu.Route}
+			sum, ok := sums[k]
+			if !ok {
+				sum = &apitype.PeerUsage{Peer: pu.Peer, Route: pu.Route}
+				sums[k] = sum
+				rows = append(rows, sum)
+			}
+			if pu.Name != "" {
+				sum.Name = pu.Name
+			}
+			sum.TxBytes += pu.TxBytes
+			sum.RxBytes += pu.RxBytes
+			total.TxBytes += pu.TxBytes
+			total.RxBytes += pu.RxBytes
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].TxBytes+rows[i].RxBytes > rows[j].TxBytes+rows[j].RxBytes
+	})
+	fmt.Fprintln(tw, "PEER\tROUTE\tSENT\tRECEIVED")
+	for _, r := range rows {
+		name := r.Name
+		if name == "" {
+			name = string(r.Peer)
+		}
+		route := "-"
+		if r.Route.IsValid() {
+			route = r.Route.String()
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", name, route, formatByteCount(r.TxBytes), formatByteCount(r.RxBytes))
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t%s\t%s\n", formatByteCount(total.TxBytes), formatByteCount(total.RxBytes))
+	tw.Flush()
+	return b.Bytes()
+}
+
+// formatByteCount formats n as a decimal (SI) byte count, like "1.5 MB".
+func formatByteCount(n uint64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
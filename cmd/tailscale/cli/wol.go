@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/peterbourgon/ff/v3/ffcli"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+var wolCmd = &ffcli.Command{
+	Name:       "wol",
+	ShortUsage: "wol <mac-address> --via <hostname-or-IP>",
+	ShortHelp:  "Wake a machine on a peer's LAN with a Wake-on-LAN packet",
+	LongHelp: strings.TrimSpace(`
+
+The 'tailscale wol' command asks the peer given by --via, typically a
+subnet router, to send a Wake-on-LAN magic packet for the MAC address on
+all of its LANs, to wake a machine there over the tailnet.
+
+The peer must be owned by the same user, or grant this device the
+"https://tailscale.com/cap/wake-on-lan" capability in the tailnet's
+ACLs.
+
+`),
+	Exec:    runWOL,
+	FlagSet: wolFlagSet,
+}
+
+var wolFlagSet = (func() *flag.FlagSet {
+	fs := newFlagSet("wol")
+	fs.StringVar(&wolArgs.via, "via", "", "the peer to send the packet from, by hostname or Tailscale IP")
+	return fs
+})()
+
+var wolArgs struct {
+	via string
+}
+
+func runWOL(ctx context.Context, args []string) error {
+	// Allow the flags after the MAC address too, as in
+	// "tailscale wol 00:11:22:33:44:55 --via router".
+	if len(args) > 1 {
+		if err := wolFlagSet.Parse(args[1:]); err != nil {
+			return err
+		}
+		args = append(args[:1], wolFlagSet.Args()...)
+	}
+	if len(args) != 1 || wolArgs.via == "" {
+		return errors.New("usage: wol <mac-address> --via <hostname-or-IP>")
+	}
+	mac, err := net.ParseMAC(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid MAC address %q", args[0])
+	}
+	ip, self, err := tailscaleIPFromArg(ctx, wolArgs.via)
+	if err != nil {
+		return err
+	}
+	if self {
+		return errors.New("--via must be a peer, not this device")
+	}
+	via, err := netip.ParseAddr(ip)
+	if err != nil {
+		return err
+	}
+	res, err := localClient.WakeOnLAN(ctx, via, mac.String())
+	if err != nil {
+		return err
+	}
+	outln(formatWakeOnLAN(mac, wolArgs.via, res))
+	if len(res.SentTo) == 0 {
+		return errors.New("no Wake-on-LAN packet sent")
+	}
+	return nil
+}
+
+// formatWakeOnLAN returns the summary of the Wake-on-LAN packets for mac
+// sent from the peer via, as reported in res.
+func formatWakeOnLAN(mac net.HardwareAddr, via string, res *apitype.WakeOnLANResponse) string {
+	var sb strings.Builder
+	if len(res.SentTo) > 0 {
+		fmt.Fprintf(&sb, "sent Wake-on-LAN packet for %v from %s on %s", mac, via, strings.Join(res.SentTo, ", "))
+	} else {
+		fmt.Fprintf(&sb, "%s has no LAN to send a Wake-on-LAN packet for %v on", via, mac)
+	}
+	for _, e := range res.Errors {
+		fmt.Fprintf(&sb, "\nerror: %s", e)
+	}
+	return sb.String()
+}
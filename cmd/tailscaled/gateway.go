@@ -0,0 +1,50 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+
+	"tailscale.com/net/dhcpd"
+	"tailscale.com/types/logger"
+)
+
+// startGateway starts serving DHCP, and router advertisements if
+// requested, on --gateway-interface, making it a LAN whose clients reach
+// the tailnet (and, with an exit node, the internet) through this node.
+func startGateway(logf logger.Logf) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("not supported on %s", runtime.GOOS)
+	}
+	conf := dhcpd.Config{
+		Interface: args.gatewayIface,
+		Logf:      logf,
+	}
+	var err error
+	if conf.Prefix, err = netip.ParsePrefix(args.gatewayV4Pfx); err != nil {
+		return fmt.Errorf("--gateway-v4-pfx: %w", err)
+	}
+	if args.gatewayV6Pfx != "" {
+		if conf.V6Prefix, err = netip.ParsePrefix(args.gatewayV6Pfx); err != nil {
+			return fmt.Errorf("--gateway-v6-pfx: %w", err)
+		}
+	}
+	s, err := dhcpd.New(conf)
+	if err != nil {
+		return err
+	}
+	go func() {
+		if err := s.Serve(); err != nil {
+			logf("gateway: %v", err)
+		}
+	}()
+	routes := conf.Prefix.Masked().String()
+	if conf.V6Prefix.IsValid() {
+		routes += "," + conf.V6Prefix.Masked().String()
+	}
+	logf("gateway: serving %s; for tailnet peers to answer its clients, run: tailscale set --advertise-routes=%s", args.gatewayIface, routes)
+	return nil
+}
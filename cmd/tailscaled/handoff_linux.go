@@ -0,0 +1,214 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tailscale/wireguard-go/tun"
+	"golang.org/x/sys/unix"
+	"tailscale.com/net/tstun"
+	"tailscale.com/types/logger"
+)
+
+// handoffProto is the request a tailscaled started with --handoff sends
+// to the running one to take over its TUN device.
+const handoffProto = "tailscaled-handoff-v1"
+
+// handoffTUN is the TUN device file handed over by the previously
+// running tailscaled, if started with --handoff and it was running.
+var handoffTUN *os.File
+
+// handoffSocketPath returns the path of the unix socket on which a
+// running tailscaled accepts handoff requests.
+func handoffSocketPath() string {
+	return args.socketpath + ".handoff"
+}
+
+// maybeTakeOverTUN takes over the TUN device of the running tailscaled,
+// which exits, if started with --handoff.
+func maybeTakeOverTUN(logf logger.Logf) {
+	if !args.handoff {
+		return
+	}
+	if args.socketpath == "" {
+		logf("handoff: --handoff needs --socket; starting from scratch")
+		return
+	}
+	f, err := requestHandoff(logf, handoffSocketPath())
+	if err != nil {
+		logf("handoff: %v; starting from scratch", err)
+		return
+	}
+	handoffTUN = f
+}
+
+// newTUN returns the TUN device handed over by the previous tailscaled,
+// if any, or else creates the TUN device name.
+func newTUN(logf logger.Logf, name string) (tun.Device, string, error) {
+	if f := handoffTUN; f != nil {
+		handoffTUN = nil
+		return tstun.NewFromFile(logf, f)
+	}
+	return tstunNew(logf, name)
+}
+
+// serveHandoff hands dev over to the first tailscaled started with
+// --handoff by the same user, and then exits without tearing down the
+// device's routes, firewall rules or DNS configuration, which the new
+// tailscaled keeps. It's only called with --handoff-listen.
+//
+// TODO: hand over WireGuard sessions too, once wireguard-go can export
+// them. For now peers handshake again with the new process, which has the
+// same node key, pausing connections through the node meanwhile.
+func serveHandoff(logf logger.Logf, dev tun.Device) {
+	if args.socketpath == "" {
+		logf("handoff: --handoff-listen needs --socket")
+		return
+	}
+	fd, ok := dev.(interface{ File() *os.File })
+	if !ok {
+		return
+	}
+	_, err := listenHandoff(logf, handoffSocketPath(), fd.File(), func() {
+		if logPol != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			logPol.Shutdown(ctx)
+			cancel()
+		}
+		// Don't run any cleanup, which would remove the routes and
+		// such that the new tailscaled now owns.
+		os.Exit(0)
+	})
+	if err != nil {
+		logf("handoff: %v", err)
+	}
+}
+
+// listenHandoff listens on the unix socket path for handoff requests,
+// answers the first valid one with tunFile and then calls exit, which
+// should stop the process so the new one can proceed.
+func listenHandoff(logf logger.Logf, path string, tunFile *os.File, exit func()) (net.Listener, error) {
+	os.Remove(path)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			c, err := ln.AcceptUnix()
+			if err != nil {
+				return
+			}
+			if err := handOver(c, tunFile); err != nil {
+				logf("handoff: %v", err)
+				c.Close()
+				continue
+			}
+			logf("handoff: handed TUN device over; exiting")
+			ln.Close()
+			exit()
+			c.Close()
+			return
+		}
+	}()
+	return ln, nil
+}
+
+// handOver sends tunFile over c, in response to a handoff request.
+func handOver(c *net.UnixConn, tunFile *os.File) error {
+	if err := checkPeerUser(c); err != nil {
+		return err
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("reading request: %w", err)
+	}
+	if strings.TrimSpace(line) != handoffProto {
+		return fmt.Errorf("unknown request %q", strings.TrimSpace(line))
+	}
+	_, _, err = c.WriteMsgUnix([]byte("ok\n"), unix.UnixRights(int(tunFile.Fd())), nil)
+	return err
+}
+
+// requestHandoff asks the tailscaled listening on path for its TUN
+// device and returns it, once that tailscaled has exited.
+func requestHandoff(logf logger.Logf, path string) (*os.File, error) {
+	c, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	if err := checkPeerUser(c); err != nil {
+		return nil, err
+	}
+	c.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := io.WriteString(c, handoffProto+"\n"); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 16)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := c.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if string(buf[:n]) != "ok\n" {
+		return nil, fmt.Errorf("unexpected response %q", buf[:n])
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return nil, errors.New("no TUN device in response")
+	}
+	fds, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return nil, errors.New("no TUN device in response")
+	}
+	f := os.NewFile(uintptr(fds[0]), "/dev/net/tun")
+
+	// Wait for the old tailscaled to exit, closing the conn, so it has
+	// released its UDP port and state.
+	if _, err := c.Read(buf); !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+		f.Close()
+		return nil, fmt.Errorf("waiting for the running tailscaled to exit: %v", err)
+	}
+	logf("handoff: took over TUN device from the running tailscaled")
+	return f, nil
+}
+
+// checkPeerUser returns an error unless the process on the other end of
+// c runs as the same user as this one, per SO_PEERCRED.
+func checkPeerUser(c *net.UnixConn) error {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *unix.Ucred
+	var credErr error
+	if err := rc.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return fmt.Errorf("getting peer credentials: %w", credErr)
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer pid %d runs as uid %d, not %d", cred.Pid, cred.Uid, os.Getuid())
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandoff(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tailscaled.sock.handoff")
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	exited := make(chan bool, 1)
+	ln, err := listenHandoff(t.Logf, path, r, func() { exited <- true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	f, err := requestHandoff(t.Logf, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	select {
+	case <-exited:
+	default:
+		t.Fatal("requestHandoff returned before the old process exited")
+	}
+
+	// The handed over file is the same pipe.
+	if _, err := io.WriteString(w, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("read %q from handed over file; want %q", buf, "hello")
+	}
+
+	// The old process stops listening after handing off.
+	if _, err := requestHandoff(t.Logf, path); err == nil {
+		t.Error("second handoff succeeded")
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package main
+
+import (
+	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/types/logger"
+)
+
+func maybeTakeOverTUN(logf logger.Logf) {
+	if args.handoff {
+		logf("handoff: --handoff is only supported on Linux; starting from scratch")
+	}
+	if args.handoffListen {
+		logf("handoff: --handoff-listen is only supported on Linux; ignoring")
+	}
+}
+
+func newTUN(logf logger.Logf, name string) (tun.Device, string, error) {
+	return tstunNew(logf, name)
+}
+
+func serveHandoff(logf logger.Logf, dev tun.Device) {}
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -13,6 +14,7 @@
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 )
 
 func init() {
@@ -20,12 +22,20 @@ func init() {
 	uninstallSystemDaemon = uninstallSystemDaemonDarwin
 }
 
-// darwinLaunchdPlist is the launchd.plist that's written to
+// darwinLaunchdPlist returns the launchd.plist that's written to
 // /Library/LaunchDaemons/com.tailscale.tailscaled.plist or (in the
-// future) a user-specific location.
+// future) a user-specific location. The tailscaled flags in args are
+// added to its command line.
 //
 // See man launchd.plist.
-const darwinLaunchdPlist = `
+func darwinLaunchdPlist(args []string) string {
+	var progArgs strings.Builder
+	for _, a := range append([]string{targetBin}, args...) {
+		progArgs.WriteString("    <string>")
+		xml.EscapeText(&progArgs, []byte(a))
+		progArgs.WriteString("</string>\n")
+	}
+	return `
 <?xml version="1.0" encoding="UTF-8"?>
 <!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
 <plist version="1.0">
@@ -36,15 +46,25 @@ func init() {
 
   <key>ProgramArguments</key>
   <array>
-    <string>/usr/local/bin/tailscaled</string>
-  </array>
+` + progArgs.String() + `  </array>
 
   <key>RunAtLoad</key>
   <true/>
 
+  <key>KeepAlive</key>
+  <true/>
+
+  <key>StandardErrorPath</key>
+  <string>` + darwinLogFile + `</string>
+
 </dict>
 </plist>
 `
+}
+
+// darwinLogFile is where launchd writes tailscaled's stderr, so that
+// headless Macs have its logs locally too.
+const darwinLogFile = "/var/log/tailscaled.log"
 
 const sysPlist = "/Library/LaunchDaemons/com.tailscale.tailscaled.plist"
 const targetBin = "/usr/local/bin/tailscaled"
@@ -100,9 +120,14 @@ func uninstallSystemDaemonDarwin(args []string) (ret error) {
 	return ret
 }
 
+// installSystemDaemonDarwin installs tailscaled as a LaunchDaemon. Any
+// args are tailscaled flags (such as "--tun=userspace-networking") for
+// the daemon to run with.
 func installSystemDaemonDarwin(args []string) (err error) {
-	if len(args) > 0 {
-		return errors.New("install subcommand takes no arguments")
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			return fmt.Errorf("install subcommand takes only tailscaled flags; got %q", a)
+		}
 	}
 	defer func() {
 		if err != nil && os.Getuid() != 0 {
@@ -131,7 +156,7 @@ func installSystemDaemonDarwin(args []string) (err error) {
 			return err
 		}
 	}
-	if err := os.WriteFile(sysPlist, []byte(darwinLaunchdPlist), 0700); err != nil {
+	if err := os.WriteFile(sysPlist, []byte(darwinLaunchdPlist(args)), 0700); err != nil {
 		return err
 	}
 
@@ -13,12 +13,14 @@
 	"net"
 	"net/http"
 	"net/http/httputil"
+	"net/netip"
 	"strings"
 )
 
 // httpProxyHandler returns an HTTP proxy http.Handler using the
-// provided backend dialer.
-func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error)) http.Handler {
+// provided backend dialer. If authorize is non-nil, clients it returns an
+// error for are refused.
+func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.Conn, error), authorize func(netip.AddrPort) error) http.Handler {
 	rp := &httputil.ReverseProxy{
 		Director: func(r *http.Request) {}, // no change
 		Transport: &http.Transport{
@@ -26,6 +28,16 @@ func httpProxyHandler(dialer func(ctx context.Context, netw, addr string) (net.C
 		},
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorize != nil {
+			src, err := netip.ParseAddrPort(r.RemoteAddr)
+			if err == nil {
+				err = authorize(src)
+			}
+			if err != nil {
+				http.Error(w, "proxy access denied", http.StatusForbidden)
+				return
+			}
+		}
 		if r.Method != "CONNECT" {
 			backURL := r.RequestURI
 			if strings.HasPrefix(backURL, "/") || backURL == "*" {
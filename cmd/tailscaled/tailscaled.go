@@ -26,12 +26,14 @@
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"tailscale.com/cmd/tailscaled/childproc"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnserver"
 	"tailscale.com/ipn/store"
@@ -42,6 +44,7 @@
 	"tailscale.com/net/netns"
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
+	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/net/tstun"
 	"tailscale.com/paths"
@@ -133,6 +136,16 @@ func defaultPort() uint16 {
 	socksAddr      string // listen address for SOCKS5 server
 	httpProxyAddr  string // listen address for HTTP proxy server
 	disableLogs    bool
+
+	configPath       string // optional path of the declared prefs config file
+	configAutoRevert bool   // whether to revert prefs that drift from configPath
+
+	handoff       bool // whether to take over the TUN device of the running tailscaled
+	handoffListen bool // whether to hand the TUN device over to a later tailscaled --handoff
+
+	gatewayIface string // optional LAN interface to serve DHCP and RAs on
+	gatewayV4Pfx string // gateway's address and prefix on gatewayIface
+	gatewayV6Pfx string // optional IPv6 /64 to advertise on gatewayIface
 }
 
 var (
@@ -158,8 +171,8 @@ func main() {
 	flag.IntVar(&args.verbose, "verbose", 0, "log verbosity level; 0 is default, 1 or higher are increasingly verbose")
 	flag.BoolVar(&args.cleanup, "cleanup", false, "clean up system state and exit")
 	flag.StringVar(&args.debug, "debug", "", "listen address ([ip]:port) of optional debug server")
-	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080")`)
-	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080")`)
+	flag.StringVar(&args.socksAddr, "socks5-server", "", `optional [ip]:port to run a SOCK5 server (e.g. "localhost:1080"), or "tailscale:port" to also serve tailnet peers on the Tailscale IPs with userspace networking`)
+	flag.StringVar(&args.httpProxyAddr, "outbound-http-proxy-listen", "", `optional [ip]:port to run an outbound HTTP proxy (e.g. "localhost:8080"), or "tailscale:port" to also serve tailnet peers on the Tailscale IPs with userspace networking`)
 	flag.StringVar(&args.tunname, "tun", defaultTunName(), `tunnel interface name; use "userspace-networking" (beta) to not use TUN`)
 	flag.Var(flagtype.PortValue(&args.port, defaultPort()), "port", "UDP port to listen on for WireGuard and peer-to-peer traffic; 0 means automatically select")
 	flag.StringVar(&args.statepath, "state", "", "absolute path of state file; use 'kube:<secret-name>' to use Kubernetes secrets or 'arn:aws:ssm:...' to store in AWS SSM; use 'mem:' to not store state and register as an ephemeral node. If empty and --statedir is provided, the default is <statedir>/tailscaled.state. Default: "+paths.DefaultTailscaledStateFile())
@@ -168,6 +181,13 @@ func main() {
 	flag.StringVar(&args.birdSocketPath, "bird-socket", "", "path of the bird unix socket")
 	flag.BoolVar(&printVersion, "version", false, "print version information and exit")
 	flag.BoolVar(&args.disableLogs, "no-logs-no-support", false, "disable log uploads; this also disables any technical support")
+	flag.StringVar(&args.configPath, "config", "", `optional path of a JSON file of prefs to apply at startup, such as {"RouteAll": true}; changes to them are reported as config drift`)
+	flag.BoolVar(&args.configAutoRevert, "config-auto-revert", false, "with --config, change back prefs that were changed from the config file")
+	flag.StringVar(&args.gatewayIface, "gateway-interface", "", "optional LAN interface to act as a site gateway on, handing out addresses from --gateway-v4-pfx with DHCP, and routing clients and their DNS through this node (Linux only)")
+	flag.StringVar(&args.gatewayV4Pfx, "gateway-v4-pfx", "", "with --gateway-interface, its IPv4 address and prefix, such as 192.168.77.1/24, to hand out the other addresses of; advertise it as a subnet route")
+	flag.StringVar(&args.gatewayV6Pfx, "gateway-v6-pfx", "", "with --gateway-interface, an optional IPv6 /64 for clients to configure addresses from with router advertisements; advertise it as a subnet route")
+	flag.BoolVar(&args.handoff, "handoff", false, "take over the TUN device, with its routes, from the running tailscaled started with --handoff-listen, which then exits, so restarts for upgrades keep the node's routes and only pause connections while peers re-handshake (Linux only)")
+	flag.BoolVar(&args.handoffListen, "handoff-listen", false, "accept requests from a tailscaled started with --handoff, run by the same user, to hand it the TUN device and exit (Linux only)")
 
 	if len(os.Args) > 0 && filepath.Base(os.Args[0]) == "tailscale" && beCLI != nil {
 		beCLI()
@@ -217,6 +237,15 @@ func main() {
 		log.Fatalf("--bird-socket is not supported on %s", runtime.GOOS)
 	}
 
+	if args.gatewayIface == "" && (args.gatewayV4Pfx != "" || args.gatewayV6Pfx != "") {
+		log.SetFlags(0)
+		log.Fatalf("--gateway-v4-pfx and --gateway-v6-pfx require --gateway-interface")
+	}
+	if args.gatewayIface != "" && args.gatewayV4Pfx == "" {
+		log.SetFlags(0)
+		log.Fatalf("--gateway-interface requires --gateway-v4-pfx")
+	}
+
 	// Only apply a default statepath when neither have been provided, so that a
 	// user may specify only --statedir if they wish.
 	if args.statepath == "" && args.statedir == "" {
@@ -377,6 +406,14 @@ func run() error {
 		debugMux = newDebugMux()
 	}
 
+	maybeTakeOverTUN(logf)
+
+	if args.gatewayIface != "" {
+		if err := startGateway(logf); err != nil {
+			return fmt.Errorf("--gateway-interface: %w", err)
+		}
+	}
+
 	logid := pol.PublicID.String()
 	return startIPNServer(context.Background(), logf, logid)
 }
@@ -458,7 +495,20 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid string) (_ *ip
 		logPol.Logtail.SetLinkMonitor(linkMon)
 	}
 
-	socksListener, httpProxyListener := mustStartProxyListeners(args.socksAddr, args.httpProxyAddr)
+	socksListener, httpProxyListener := mustStartProxyListeners(proxyListenAddr(args.socksAddr), proxyListenAddr(args.httpProxyAddr))
+
+	// The proxies authorize their tailnet clients by Tailscale identity,
+	// once the LocalBackend that knows them exists.
+	var proxyBackend atomic.Pointer[ipnlocal.LocalBackend]
+	authorizeProxyClient := func(src netip.AddrPort) error {
+		if lb := proxyBackend.Load(); lb != nil {
+			return lb.AuthorizeProxyClient(src)
+		}
+		if tsaddr.IsTailscaleIP(src.Addr()) {
+			return errors.New("tailscaled is starting")
+		}
+		return nil
+	}
 
 	dialer := &tsdial.Dialer{Logf: logf} // mutated below (before used)
 	e, onlyNetstack, err := createEngine(logf, linkMon, dialer)
@@ -492,10 +542,14 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid string) (_ *ip
 		dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
 			return ns.DialContextTCP(ctx, dst)
 		}
+		dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+			return ns.DialContextUDP(ctx, dst)
+		}
 	}
+	logpolicy.SetTailnetDialer(dialer.UserDial)
 	if socksListener != nil || httpProxyListener != nil {
 		if httpProxyListener != nil {
-			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial)}
+			hs := &http.Server{Handler: httpProxyHandler(dialer.UserDial, authorizeProxyClient)}
 			go func() {
 				log.Fatalf("HTTP proxy exited: %v", hs.Serve(httpProxyListener))
 			}()
@@ -504,6 +558,13 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid string) (_ *ip
 			ss := &socks5.Server{
 				Logf:   logger.WithPrefix(logf, "socks5: "),
 				Dialer: dialer.UserDial,
+				Authorize: func(clientAddr net.Addr) error {
+					src, err := netip.ParseAddrPort(clientAddr.String())
+					if err != nil {
+						return err
+					}
+					return authorizeProxyClient(src)
+				},
 			}
 			go func() {
 				log.Fatalf("SOCKS5 server exited: %v", ss.Serve(socksListener))
@@ -524,6 +585,7 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid string) (_ *ip
 	if err != nil {
 		return nil, fmt.Errorf("ipnlocal.NewLocalBackend: %w", err)
 	}
+	proxyBackend.Store(lb)
 	lb.SetVarRoot(opts.VarRoot)
 	if logPol != nil {
 		lb.SetLogFlusher(logPol.Logtail.StartFlush)
@@ -535,6 +597,21 @@ func getLocalBackend(ctx context.Context, logf logger.Logf, logid string) (_ *ip
 		return smallzstd.NewDecoder(nil)
 	})
 	configureTaildrop(logf, lb)
+	if args.configPath != "" {
+		b, err := os.ReadFile(args.configPath)
+		if err != nil {
+			return nil, fmt.Errorf("reading --config: %w", err)
+		}
+		mp, err := ipn.ParseDeclaredPrefs(b)
+		if err != nil {
+			return nil, fmt.Errorf("parsing --config %s: %w", args.configPath, err)
+		}
+		if err := lb.SetDeclaredPrefs(mp, args.configAutoRevert); err != nil {
+			return nil, err
+		}
+	} else if args.configAutoRevert {
+		return nil, errors.New("--config-auto-revert requires --config")
+	}
 	if err := ns.Start(lb); err != nil {
 		log.Fatalf("failed to start netstack: %v", err)
 	}
@@ -616,7 +693,7 @@ func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, na
 			}
 		}
 	} else {
-		dev, devName, err := tstunNew(logf, name)
+		dev, devName, err := newTUN(logf, name)
 		if err != nil {
 			tstun.Diagnose(logf, name, err)
 			return nil, false, fmt.Errorf("tstun.New(%q): %w", name, err)
@@ -649,6 +726,9 @@ func tryEngine(logf logger.Logf, linkMon *monitor.Mon, dialer *tsdial.Dialer, na
 	if err != nil {
 		return nil, onlyNetstack, err
 	}
+	if conf.Tun != nil && args.handoffListen {
+		serveHandoff(logf, conf.Tun)
+	}
 	return e, onlyNetstack, nil
 }
 
@@ -687,6 +767,25 @@ func newNetstack(logf logger.Logf, dialer *tsdial.Dialer, e wgengine.Engine) (*n
 	return netstack.Create(logf, tunDev, e, magicConn, dialer, dns)
 }
 
+// proxyListenAddr returns the address to listen on for the proxy
+// listen address flag value addr. For "tailscale:port", that's the
+// loopback port that netstack forwards the tailnet's connections to the
+// Tailscale IPs to, with userspace networking.
+func proxyListenAddr(addr string) string {
+	port, ok := strings.CutPrefix(addr, "tailscale:")
+	if !ok {
+		return addr
+	}
+	if args.tunname != "userspace-networking" {
+		log.Fatalf("proxy listen address %q requires --tun=userspace-networking", addr)
+	}
+	if port == "0" {
+		// The tailnet couldn't know the port.
+		log.Fatalf("proxy listen address %q needs a fixed port", addr)
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
 // mustStartProxyListeners creates listeners for local SOCKS and HTTP
 // proxies, if the respective addresses are not empty. socksAddr and
 // httpAddr can be the same, in which case socksListener will receive
@@ -0,0 +1,588 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// The tsidp command is an OpenID Connect Identity Provider server.
+//
+// It joins the tailnet as its own node and issues ID tokens asserting the
+// tailnet identity (user and node) of whoever is visiting it, as
+// determined by WhoIs on the connecting address. Apps that support OIDC
+// login (Grafana, MinIO, Proxmox, ...) can then offer "login with
+// Tailscale" without a separate identity store.
+//
+// The node must have HTTPS certificates enabled, as the issuer URL is
+// the node's https://<name>.<tailnet>.ts.net address.
+//
+// Set the TS_AUTHKEY environment variable to have this server automatically
+// join your tailnet, or look for the logged auth link on first start.
+//
+// Register the relying parties in the HuJSON file given with --clients,
+// each with a client ID, a client secret and the redirect URIs it may use,
+// and configure them with the issuer URL (logged at startup); the
+// discovery document is served at /.well-known/openid-configuration.
+// Relying parties must authenticate with their secret at the token
+// endpoint, which may only be called from the tailnet:
+//
+//	[
+//		{
+//			"client_id": "grafana",
+//			"client_secret": "...",
+//			"redirect_uris": ["https://grafana.example.ts.net/login/generic_oauth"],
+//		},
+//	]
+package main
+
+import (
+	"context"
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tailscale/hujson"
+	"tailscale.com/atomicfile"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tsnet"
+	"tailscale.com/types/logger"
+)
+
+var (
+	flagHostname = flag.String("hostname", "idp", "tailnet hostname of the IdP node")
+	flagDir      = flag.String("dir", "", "directory for tsnet and signing key state; if empty, a default is used")
+	flagClients  = flag.String("clients", "", "path of the HuJSON file of the relying parties allowed to use the IdP")
+	flagVerbose  = flag.Bool("verbose", false, "be verbose")
+)
+
+// Lifetimes of the credentials the IdP issues.
+const (
+	codeLifetime  = 5 * time.Minute
+	tokenLifetime = 5 * time.Minute
+)
+
+func main() {
+	flag.Parse()
+	ctx := context.Background()
+	if *flagClients == "" {
+		log.Fatal("--clients is required")
+	}
+	clients, err := loadClients(*flagClients)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ts := &tsnet.Server{
+		Hostname: *flagHostname,
+		Dir:      *flagDir,
+	}
+	if !*flagVerbose {
+		ts.Logf = logger.Discard
+	}
+	st, err := ts.Up(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	lc, err := ts.LocalClient()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(st.CertDomains) == 0 {
+		log.Fatal("tsidp requires HTTPS certificates to be enabled for the tailnet")
+	}
+
+	dir := *flagDir
+	if dir == "" {
+		confDir, err := os.UserConfigDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		dir = filepath.Join(confDir, "tsidp")
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			log.Fatal(err)
+		}
+	}
+	signer, err := loadOrCreateKey(filepath.Join(dir, "oidc-key.pem"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := newIDPServer("https://"+st.CertDomains[0], signer, lc.WhoIs, clients)
+	ln, err := ts.Listen("tcp", ":443")
+	if err != nil {
+		log.Fatal(err)
+	}
+	ln = tls.NewListener(ln, &tls.Config{GetCertificate: lc.GetCertificate})
+	log.Printf("tsidp running at %s", srv.serverURL)
+	log.Fatal(http.Serve(ln, srv))
+}
+
+// loadOrCreateKey returns the RSA signing key stored as PEM at path,
+// generating and storing one if it doesn't exist yet.
+func loadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := os.ReadFile(path)
+	if err == nil {
+		blk, _ := pem.Decode(b)
+		if blk == nil || blk.Type != "RSA PRIVATE KEY" {
+			return nil, fmt.Errorf("%s: invalid PEM key", path)
+		}
+		return x509.ParsePKCS1PrivateKey(blk.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	k, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	b = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)})
+	if err := atomicfile.WriteFile(path, b, 0600); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// client is a relying party registered with --clients.
+type client struct {
+	ID           string   `json:"client_id"`
+	Secret       string   `json:"client_secret"`
+	RedirectURIs []string `json:"redirect_uris"`
+}
+
+// loadClients returns the clients registered in the HuJSON file at path,
+// by client ID.
+func loadClients(path string) (map[string]*client, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b, err = hujson.Standardize(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var cs []*client
+	if err := json.Unmarshal(b, &cs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m := map[string]*client{}
+	for _, c := range cs {
+		switch {
+		case c.ID == "":
+			return nil, fmt.Errorf("%s: client without client_id", path)
+		case c.Secret == "":
+			return nil, fmt.Errorf("%s: client %q without client_secret", path, c.ID)
+		case len(c.RedirectURIs) == 0:
+			return nil, fmt.Errorf("%s: client %q without redirect_uris", path, c.ID)
+		case m[c.ID] != nil:
+			return nil, fmt.Errorf("%s: duplicate client %q", path, c.ID)
+		}
+		for _, ru := range c.RedirectURIs {
+			if u, err := url.Parse(ru); err != nil || !u.IsAbs() || u.Fragment != "" {
+				return nil, fmt.Errorf("%s: client %q: invalid redirect URI %q", path, c.ID, ru)
+			}
+		}
+		m[c.ID] = c
+	}
+	if len(m) == 0 {
+		return nil, errors.New(path + ": no clients")
+	}
+	return m, nil
+}
+
+// allowsRedirect reports whether uri is one of c's redirect URIs.
+func (c *client) allowsRedirect(uri string) bool {
+	for _, ru := range c.RedirectURIs {
+		if ru == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// whoIsFunc looks up the tailnet identity of a remote ip:port.
+type whoIsFunc func(ctx context.Context, remoteAddr string) (*apitype.WhoIsResponse, error)
+
+type idpServer struct {
+	serverURL string // "https://foo.bar.ts.net", the issuer
+	signer    *rsa.PrivateKey
+	kid       string
+	whoIs     whoIsFunc
+	clients   map[string]*client // by client ID
+	mux       *http.ServeMux
+
+	mu          sync.Mutex
+	code        map[string]*authRequest // code => request
+	accessToken map[string]*authRequest // access token => request
+}
+
+// authRequest is a successful authorization by a tailnet user, pending
+// exchange of its code for tokens (and afterwards, backing the issued
+// access token).
+type authRequest struct {
+	clientID      string
+	redirectURI   string
+	nonce         string
+	codeChallenge string // PKCE S256 challenge, or empty
+	who           *apitype.WhoIsResponse
+	validTill     time.Time
+}
+
+func newIDPServer(serverURL string, signer *rsa.PrivateKey, whoIs whoIsFunc, clients map[string]*client) *idpServer {
+	h := sha256.Sum256(signer.PublicKey.N.Bytes())
+	s := &idpServer{
+		serverURL:   serverURL,
+		signer:      signer,
+		kid:         hex.EncodeToString(h[:8]),
+		whoIs:       whoIs,
+		clients:     clients,
+		mux:         http.NewServeMux(),
+		code:        map[string]*authRequest{},
+		accessToken: map[string]*authRequest{},
+	}
+	s.mux.HandleFunc("/.well-known/openid-configuration", s.serveDiscovery)
+	s.mux.HandleFunc("/.well-known/jwks.json", s.serveJWKS)
+	s.mux.HandleFunc("/authorize", s.serveAuthorize)
+	s.mux.HandleFunc("/token", s.serveToken)
+	s.mux.HandleFunc("/userinfo", s.serveUserInfo)
+	return s
+}
+
+func (s *idpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *idpServer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"issuer":                                s.serverURL,
+		"authorization_endpoint":                s.serverURL + "/authorize",
+		"token_endpoint":                        s.serverURL + "/token",
+		"userinfo_endpoint":                     s.serverURL + "/userinfo",
+		"jwks_uri":                              s.serverURL + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid", "email", "profile"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported": []string{
+			"sub", "iss", "aud", "exp", "iat", "nonce", "email", "name",
+			"picture", "username", "node", "node_id", "tailnet", "addresses",
+		},
+	})
+}
+
+func (s *idpServer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	pub := s.signer.PublicKey
+	writeJSON(w, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": s.kid,
+			"n":   b64(pub.N.Bytes()),
+			"e":   b64(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+// serveAuthorize identifies the visiting tailnet user and redirects back
+// to the relying party with an authorization code. There's no consent
+// screen: being on the tailnet is the login.
+func (s *idpServer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	who, err := s.whoIs(r.Context(), r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "tsidp: caller is not on the tailnet", http.StatusForbidden)
+		return
+	}
+	if len(who.Node.Tags) > 0 || who.UserProfile == nil {
+		http.Error(w, "tsidp: tagged nodes have no user to log in as", http.StatusForbidden)
+		return
+	}
+	q := r.URL.Query()
+	clientID := q.Get("client_id")
+	redirectURI := q.Get("redirect_uri")
+	if clientID == "" || redirectURI == "" {
+		http.Error(w, "tsidp: client_id and redirect_uri are required", http.StatusBadRequest)
+		return
+	}
+	// Don't redirect to unregistered URIs, even with an error, so codes
+	// only ever go to the relying party they're for.
+	c := s.clients[clientID]
+	if c == nil {
+		http.Error(w, "tsidp: unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if !c.allowsRedirect(redirectURI) {
+		http.Error(w, "tsidp: redirect_uri not registered for client", http.StatusBadRequest)
+		return
+	}
+	ru, err := url.Parse(redirectURI)
+	if err != nil {
+		http.Error(w, "tsidp: invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if rt := q.Get("response_type"); rt != "code" {
+		redirectError(w, r, ru, q.Get("state"), "unsupported_response_type")
+		return
+	}
+	if !strings.Contains(" "+q.Get("scope")+" ", " openid ") {
+		redirectError(w, r, ru, q.Get("state"), "invalid_scope")
+		return
+	}
+	ar := &authRequest{
+		clientID:    clientID,
+		redirectURI: redirectURI,
+		nonce:       q.Get("nonce"),
+		who:         who,
+		validTill:   time.Now().Add(codeLifetime),
+	}
+	if cc := q.Get("code_challenge"); cc != "" {
+		if m := q.Get("code_challenge_method"); m != "S256" {
+			redirectError(w, r, ru, q.Get("state"), "invalid_request")
+			return
+		}
+		ar.codeChallenge = cc
+	}
+	code := randHex(32)
+	s.mu.Lock()
+	s.pruneLocked()
+	s.code[code] = ar
+	s.mu.Unlock()
+
+	rq := ru.Query()
+	rq.Set("code", code)
+	if state := q.Get("state"); state != "" {
+		rq.Set("state", state)
+	}
+	ru.RawQuery = rq.Encode()
+	http.Redirect(w, r, ru.String(), http.StatusFound)
+}
+
+func redirectError(w http.ResponseWriter, r *http.Request, ru *url.URL, state, code string) {
+	rq := ru.Query()
+	rq.Set("error", code)
+	if state != "" {
+		rq.Set("state", state)
+	}
+	ru.RawQuery = rq.Encode()
+	http.Redirect(w, r, ru.String(), http.StatusFound)
+}
+
+// serveToken exchanges an authorization code for an ID token and an
+// access token usable at the userinfo endpoint.
+func (s *idpServer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "tsidp: POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := s.whoIs(r.Context(), r.RemoteAddr); err != nil {
+		tokenError(w, http.StatusForbidden, "invalid_client")
+		return
+	}
+	if r.FormValue("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.FormValue("client_id"), r.FormValue("client_secret")
+	}
+	c := s.clients[clientID]
+	if c == nil || subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	code := r.FormValue("code")
+	s.mu.Lock()
+	ar, ok := s.code[code]
+	delete(s.code, code) // codes are single use, even on failure
+	s.mu.Unlock()
+	if !ok || time.Now().After(ar.validTill) ||
+		ar.redirectURI != r.FormValue("redirect_uri") ||
+		ar.clientID != c.ID {
+		tokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+	if ar.codeChallenge != "" {
+		h := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+		if subtle.ConstantTimeCompare([]byte(b64(h[:])), []byte(ar.codeChallenge)) != 1 {
+			tokenError(w, http.StatusBadRequest, "invalid_grant")
+			return
+		}
+	}
+
+	now := time.Now()
+	claims := s.claims(ar, now)
+	idToken, err := s.signJWT(claims)
+	if err != nil {
+		tokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	at := randHex(32)
+	s.mu.Lock()
+	s.accessToken[at] = &authRequest{
+		clientID:  ar.clientID,
+		who:       ar.who,
+		validTill: now.Add(tokenLifetime),
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]any{
+		"access_token": at,
+		"token_type":   "Bearer",
+		"expires_in":   int(tokenLifetime.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+func (s *idpServer) serveUserInfo(w http.ResponseWriter, r *http.Request) {
+	at, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		http.Error(w, "tsidp: missing bearer token", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	ar, ok := s.accessToken[at]
+	s.mu.Unlock()
+	if !ok || time.Now().After(ar.validTill) {
+		http.Error(w, "tsidp: invalid or expired token", http.StatusUnauthorized)
+		return
+	}
+	up := ar.who.UserProfile
+	writeJSON(w, map[string]any{
+		"sub":      subject(ar.who),
+		"name":     up.DisplayName,
+		"email":    up.LoginName,
+		"picture":  up.ProfilePicURL,
+		"username": username(up.LoginName),
+	})
+}
+
+// pruneLocked removes expired codes and access tokens.
+// s.mu must be held.
+func (s *idpServer) pruneLocked() {
+	now := time.Now()
+	for _, m := range []map[string]*authRequest{s.code, s.accessToken} {
+		for k, ar := range m {
+			if now.After(ar.validTill) {
+				delete(m, k)
+			}
+		}
+	}
+}
+
+// tailscaleClaims are the claims of ID tokens issued by tsidp: the
+// registered OIDC claims plus the caller's tailnet identity.
+type tailscaleClaims struct {
+	Issuer   string `json:"iss"`
+	Subject  string `json:"sub"`
+	Audience string `json:"aud"`
+	Expiry   int64  `json:"exp"`
+	IssuedAt int64  `json:"iat"`
+	Nonce    string `json:"nonce,omitempty"`
+
+	Email     string   `json:"email"`
+	Name      string   `json:"name"`
+	Picture   string   `json:"picture,omitempty"`
+	Username  string   `json:"username"`
+	NodeName  string   `json:"node"`
+	NodeID    string   `json:"node_id"`
+	Tailnet   string   `json:"tailnet"`
+	Addresses []string `json:"addresses"`
+}
+
+func (s *idpServer) claims(ar *authRequest, now time.Time) tailscaleClaims {
+	n, up := ar.who.Node, ar.who.UserProfile
+	name := strings.TrimSuffix(n.Name, ".")
+	_, tailnet, _ := strings.Cut(name, ".")
+	c := tailscaleClaims{
+		Issuer:   s.serverURL,
+		Subject:  subject(ar.who),
+		Audience: ar.clientID,
+		Expiry:   now.Add(tokenLifetime).Unix(),
+		IssuedAt: now.Unix(),
+		Nonce:    ar.nonce,
+		Email:    up.LoginName,
+		Name:     up.DisplayName,
+		Picture:  up.ProfilePicURL,
+		Username: username(up.LoginName),
+		NodeName: name,
+		NodeID:   string(n.StableID),
+		Tailnet:  tailnet,
+	}
+	for _, a := range n.Addresses {
+		c.Addresses = append(c.Addresses, a.Addr().String())
+	}
+	return c
+}
+
+// subject returns the stable OIDC subject for the user behind who.
+func subject(who *apitype.WhoIsResponse) string {
+	return fmt.Sprintf("userid:%d", who.UserProfile.ID)
+}
+
+// username returns the local part of a login name.
+func username(loginName string) string {
+	u, _, _ := strings.Cut(loginName, "@")
+	return u
+}
+
+// signJWT returns claims as a compact-serialized JWS signed with RS256.
+func (s *idpServer) signJWT(claims any) (string, error) {
+	hdr, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.kid})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64(hdr) + "." + b64(body)
+	h := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(crand.Reader, s.signer, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64(sig), nil
+}
+
+func b64(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+
+func randHex(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing JSON response: %v", err)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package main
+
+import (
+	"context"
+	"crypto"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+)
+
+// verifyJWT checks the RS256 signature of a compact JWS against pub and
+// decodes its claims into v.
+func verifyJWT(tok string, pub *rsa.PublicKey, v any) error {
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed JWT")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], sig); err != nil {
+		return err
+	}
+	body, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	who := &apitype.WhoIsResponse{
+		Node: &tailcfg.Node{
+			Name:      "laptop.example.ts.net.",
+			StableID:  "nSTABLE",
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		},
+		UserProfile: &tailcfg.UserProfile{
+			ID:          42,
+			LoginName:   "alice@example.com",
+			DisplayName: "Alice",
+		},
+	}
+	onTailnet := true
+	clients := map[string]*client{
+		"grafana": {
+			ID:           "grafana",
+			Secret:       "grafana-secret",
+			RedirectURIs: []string{"https://grafana.example.ts.net/login/generic_oauth"},
+		},
+		"evil": {
+			ID:           "evil",
+			Secret:       "evil-secret",
+			RedirectURIs: []string{"https://evil.example.ts.net/cb"},
+		},
+	}
+	s := newIDPServer("https://idp.example.ts.net", key, func(ctx context.Context, addr string) (*apitype.WhoIsResponse, error) {
+		if !onTailnet {
+			return nil, errors.New("not found")
+		}
+		return who, nil
+	}, clients)
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	verifier := "0123456789abcdef0123456789abcdef0123456789abcdef"
+	h := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"client_id":             {"grafana"},
+		"redirect_uri":          {"https://grafana.example.ts.net/login/generic_oauth"},
+		"response_type":         {"code"},
+		"scope":                 {"openid email profile"},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6"},
+		"code_challenge":        {b64(h[:])},
+		"code_challenge_method": {"S256"},
+	}
+	res, err := noRedirect.Get(ts.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorize status = %v", res.Status)
+	}
+	loc, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loc.Query().Get("state"); got != "xyz" {
+		t.Errorf("state = %q", got)
+	}
+	code := loc.Query().Get("code")
+
+	exchangeAs := func(clientID, secret, code, verifier string) *http.Response {
+		t.Helper()
+		res, err := http.PostForm(ts.URL+"/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {q.Get("redirect_uri")},
+			"client_id":     {clientID},
+			"client_secret": {secret},
+			"code_verifier": {verifier},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	exchange := func(code, verifier string) *http.Response {
+		t.Helper()
+		return exchangeAs("grafana", "grafana-secret", code, verifier)
+	}
+
+	// Codes can't be redeemed without the client's secret, or by
+	// another client.
+	if res := exchangeAs("grafana", "", code, verifier); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("exchange without secret: status = %v", res.Status)
+	}
+	if res := exchangeAs("evil", "evil-secret", code, verifier); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("exchange by another client: status = %v", res.Status)
+	}
+	res, err = noRedirect.Get(ts.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	loc, err = url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code = loc.Query().Get("code")
+
+	res = exchange(code, verifier)
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("token status = %v", res.Status)
+	}
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tok); err != nil {
+		t.Fatal(err)
+	}
+	var claims tailscaleClaims
+	if err := verifyJWT(tok.IDToken, &key.PublicKey, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "userid:42" || claims.Audience != "grafana" || claims.Nonce != "n-0S6" ||
+		claims.Email != "alice@example.com" || claims.Tailnet != "example.ts.net" ||
+		claims.Issuer != "https://idp.example.ts.net" || claims.Username != "alice" {
+		t.Errorf("unexpected claims: %+v", claims)
+	}
+
+	// Codes are single use.
+	if res := exchange(code, verifier); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("reused code: status = %v", res.Status)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+tok.AccessToken)
+	res, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var ui map[string]any
+	json.NewDecoder(res.Body).Decode(&ui)
+	if ui["email"] != "alice@example.com" {
+		t.Errorf("userinfo = %v", ui)
+	}
+
+	// Unregistered clients and redirect URIs are refused without
+	// redirecting.
+	for _, mod := range []url.Values{
+		{"client_id": {"unknown"}},
+		{"redirect_uri": {"https://evil.example.ts.net/cb"}},
+	} {
+		bad := url.Values{}
+		for k, v := range q {
+			bad[k] = v
+		}
+		for k, v := range mod {
+			bad[k] = v
+		}
+		res, err := noRedirect.Get(ts.URL + "/authorize?" + bad.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("authorize with %v: status = %v; want 400", mod, res.Status)
+		}
+	}
+
+	// Callers not on the tailnet can't authorize.
+	onTailnet = false
+	res, err = noRedirect.Get(ts.URL + "/authorize?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("off-tailnet authorize status = %v", res.Status)
+	}
+}
@@ -8,9 +8,11 @@
 	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"tailscale.com/envknob"
 	"tailscale.com/health"
 	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/sockstats"
@@ -57,6 +59,9 @@ type Auto struct {
 
 	unregisterHealthWatch func()
 
+	authBackoff *backoff.Backoff // paces authRoutine's retries
+	mapBackoff  *backoff.Backoff // paces mapRoutine's retries
+
 	mu sync.Mutex // mutex guards the following fields
 
 	paused               bool // whether we should stop making HTTP requests
@@ -120,6 +125,8 @@ func NewNoStart(opts Options) (_ *Auto, err error) {
 		mapDone:    make(chan struct{}),
 		statusFunc: opts.Status,
 	}
+	c.authBackoff = newControlBackoff("authRoutine", c.logf)
+	c.mapBackoff = newControlBackoff("mapRoutine", c.logf)
 	c.authCtx, c.authCancel = context.WithCancel(context.Background())
 	c.authCtx = sockstats.WithSockStats(c.authCtx, sockstats.LabelControlClientAuto)
 
@@ -131,6 +138,41 @@ func NewNoStart(opts Options) (_ *Auto, err error) {
 
 }
 
+var (
+	controlBackoffMax    = envknob.RegisterDuration("TS_CONTROL_BACKOFF_MAX")
+	controlBackoffJitter = envknob.RegisterString("TS_CONTROL_BACKOFF_JITTER")
+)
+
+// newControlBackoff returns the Backoff for retrying requests to control.
+//
+// It caps retries at 30 seconds apart and randomizes them fully (by
+// Jitter 1), so that the many clients that lose control at once in an
+// outage, such as a fleet behind one office NAT, don't reconnect in
+// lockstep when it's back and trip its rate limits. The
+// TS_CONTROL_BACKOFF_MAX (a duration) and TS_CONTROL_BACKOFF_JITTER (from
+// 0 to 1) environment variables override these.
+func newControlBackoff(name string, logf logger.Logf) *backoff.Backoff {
+	maxBackoff := 30 * time.Second
+	if d := controlBackoffMax(); d > 0 {
+		maxBackoff = d
+	}
+	bo := backoff.NewBackoff(name, logf, maxBackoff)
+	bo.Jitter = 1
+	if s := controlBackoffJitter(); s != "" {
+		if j, err := strconv.ParseFloat(s, 64); err == nil && j >= 0 && j <= 1 {
+			bo.Jitter = j
+		} else {
+			logf("ignoring invalid TS_CONTROL_BACKOFF_JITTER %q; want a number from 0 to 1", s)
+		}
+	}
+	return bo
+}
+
+// BackoffState returns the retry schedules of the auth and map routines.
+func (c *Auto) BackoffState() []backoff.State {
+	return []backoff.State{c.authBackoff.State(), c.mapBackoff.State()}
+}
+
 // SetPaused controls whether HTTP activity should be paused.
 //
 // The client can be paused and unpaused repeatedly, unlike Start and Shutdown, which can only be used once.
@@ -309,7 +351,7 @@ func (c *Auto) cancelMapSafely() {
 
 func (c *Auto) authRoutine() {
 	defer close(c.authDone)
-	bo := backoff.NewBackoff("authRoutine", c.logf, 30*time.Second)
+	bo := c.authBackoff
 
 	for {
 		c.mu.Lock()
@@ -451,7 +493,7 @@ func (c *Auto) unpausedChanLocked() <-chan struct{} {
 
 func (c *Auto) mapRoutine() {
 	defer close(c.mapDone)
-	bo := backoff.NewBackoff("mapRoutine", c.logf, 30*time.Second)
+	bo := c.mapBackoff
 
 	for {
 		c.mu.Lock()
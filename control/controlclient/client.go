@@ -11,6 +11,7 @@
 import (
 	"context"
 
+	"tailscale.com/logtail/backoff"
 	"tailscale.com/tailcfg"
 )
 
@@ -73,6 +74,9 @@ type Client interface {
 	// in a separate http request. It has nothing to do with the rest of
 	// the state machine.
 	UpdateEndpoints(endpoints []tailcfg.Endpoint)
+	// BackoffState returns the schedules the client retries failed
+	// requests to the control server on.
+	BackoffState() []backoff.State
 }
 
 // UserVisibleError is an error that should be shown to users.
@@ -21,6 +21,7 @@
 	"os"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -79,6 +80,7 @@ type Direct struct {
 	onControlTime          func(time.Time)              // or nil
 
 	dialPlan ControlDialPlanner // can be nil
+	mapCache MapCache           // can be nil
 
 	mu             sync.Mutex        // mutex guards the following fields
 	serverKey      key.MachinePublic // original ("legacy") nacl crypto_box-based public key
@@ -97,6 +99,24 @@ type Direct struct {
 	tkaHead       string
 	everEndpoints bool   // whether we've ever had non-empty endpoints
 	lastPingURL   string // last PingRequest.URL received, for dup suppression
+
+	// mapResume, if non-nil, is the last streaming map session, which
+	// was interrupted, for the next one to try to resume.
+	mapResume *mapResumeState
+
+	// mapCacheLoaded is whether mapCache has been consulted; it's only
+	// used for the first map poll.
+	mapCacheLoaded bool
+}
+
+// mapResumeState is the state of an interrupted streaming map session
+// needed to resume it, so that a flaky connection to control doesn't cost
+// a full netmap each time it breaks.
+type mapResumeState struct {
+	nodeKey key.NodePublic // the node key the session was for
+	handle  string         // its MapResponse.MapSessionHandle
+	seq     int64          // the MapResponse.Seq last processed in it
+	sess    *mapSession    // the netmap state as of seq
 }
 
 type Options struct {
@@ -143,6 +163,11 @@ type Options struct {
 	// If we receive a new DialPlan from the server, this value will be
 	// updated.
 	DialPlan ControlDialPlanner
+
+	// MapCache optionally persists the last netmap, so that the client
+	// can start with it after a restart, before control sends any map
+	// response, and ask control to resume the map session from it.
+	MapCache MapCache
 }
 
 // ControlDialPlanner is the interface optionally supplied when creating a
@@ -243,6 +268,7 @@ func NewDirect(opts Options) (*Direct, error) {
 		linkMon:                opts.LinkMonitor,
 		skipIPForwardingCheck:  opts.SkipIPForwardingCheck,
 		pinger:                 opts.Pinger,
+		mapCache:               opts.MapCache,
 		popBrowser:             opts.PopBrowserURL,
 		onClientVersion:        opts.OnClientVersion,
 		onControlTime:          opts.OnControlTime,
@@ -589,8 +615,8 @@ func (c *Direct) doLogin(ctx context.Context, opt loginOpt) (mustRegen bool, new
 	if res.StatusCode != 200 {
 		msg, _ := io.ReadAll(res.Body)
 		res.Body.Close()
-		return regen, opt.URL, nil, fmt.Errorf("register request: http %d: %.200s",
-			res.StatusCode, strings.TrimSpace(string(msg)))
+		return regen, opt.URL, nil, withRetryAfter(res, fmt.Errorf("register request: http %d: %.200s",
+			res.StatusCode, strings.TrimSpace(string(msg))))
 	}
 	resp := tailcfg.RegisterResponse{}
 	if err := decode(res, &resp, serverKey, serverNoiseKey, machinePrivKey); err != nil {
@@ -834,6 +860,22 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 		vlogf = c.logf
 	}
 
+	var resume *mapResumeState
+	if allowStream && cb != nil {
+		resume = c.mapResumeFor(persist.PublicNodeKey())
+		if resume == nil {
+			var nm *netmap.NetworkMap
+			resume, nm = c.loadMapCache(c.newMapSession(persist.PrivateNodeKey(), machinePrivKey.Public(), vlogf))
+			if nm != nil {
+				c.logf("netmap: starting with cached netmap of %d peers", len(nm.Peers))
+				c.mu.Lock()
+				c.expiry = &nm.Expiry
+				c.mu.Unlock()
+				cb(nm)
+			}
+		}
+	}
+
 	request := &tailcfg.MapRequest{
 		Version:       tailcfg.CurrentCapabilityVersion,
 		KeepAlive:     c.keepAlive,
@@ -876,6 +918,10 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 	if c.newDecompressor != nil {
 		request.Compress = "zstd"
 	}
+	if resume != nil {
+		request.MapSessionHandle = resume.handle
+		request.MapSessionSeq = resume.seq
+	}
 
 	bodyData, err := encode(request, serverKey, serverNoiseKey, machinePrivKey)
 	if err != nil {
@@ -918,8 +964,8 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 	if res.StatusCode != 200 {
 		msg, _ := io.ReadAll(res.Body)
 		res.Body.Close()
-		return fmt.Errorf("initial fetch failed %d: %.200s",
-			res.StatusCode, strings.TrimSpace(string(msg)))
+		return withRetryAfter(res, fmt.Errorf("initial fetch failed %d: %.200s",
+			res.StatusCode, strings.TrimSpace(string(msg))))
 	}
 	defer res.Body.Close()
 
@@ -959,11 +1005,29 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 		}
 	}()
 
-	sess := newMapSession(persist.PrivateNodeKey())
-	sess.logf = c.logf
-	sess.vlogf = vlogf
-	sess.machinePubKey = machinePubKey
-	sess.keepSharerAndUserSplit = c.keepSharerAndUserSplit
+	sess := c.newMapSession(persist.PrivateNodeKey(), machinePubKey, vlogf)
+
+	// If the server names the session, remember it and how far we got, so
+	// that the next poll can resume it if this one gets interrupted.
+	var sessHandle string
+	var sessSeq int64
+	var lastCacheStore time.Time
+	var cacheDirty bool // whether sess has changed since lastCacheStore
+	defer func() {
+		if cacheDirty {
+			c.storeMapCache(sess, sessHandle, sessSeq)
+		}
+		if sessHandle != "" {
+			c.mu.Lock()
+			c.mapResume = &mapResumeState{
+				nodeKey: persist.PublicNodeKey(),
+				handle:  sessHandle,
+				seq:     sessSeq,
+				sess:    sess,
+			}
+			c.mu.Unlock()
+		}
+	}()
 
 	// If allowStream, then the server will use an HTTP long poll to
 	// return incremental results. There is always one response right
@@ -999,6 +1063,24 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 		if allowStream {
 			health.GotStreamedMapResponse()
 		}
+		if i == 0 && resume != nil {
+			// Whether or not the server resumes the session, the
+			// state of the old one is now obsolete.
+			c.mu.Lock()
+			c.mapResume = nil
+			c.mu.Unlock()
+			if resp.MapSessionHandle == resume.handle {
+				c.logf("netmap: resumed map session after seq %d", resume.seq)
+				metricMapSessionResumed.Add(1)
+				sess = resume.sess
+				sessSeq = resume.seq
+			} else {
+				metricMapSessionNotResumed.Add(1)
+			}
+		}
+		if i == 0 && allowStream {
+			sessHandle = resp.MapSessionHandle
+		}
 
 		if pr := resp.PingRequest; pr != nil && c.isUniquePingRequest(pr) {
 			metricMapResponsePings.Add(1)
@@ -1113,6 +1195,16 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 		c.mu.Unlock()
 
 		cb(nm)
+		if resp.Seq != 0 {
+			sessSeq = resp.Seq
+		}
+		if c.mapCache != nil {
+			cacheDirty = true
+			if now.Sub(lastCacheStore) >= mapCacheInterval {
+				c.storeMapCache(sess, sessHandle, sessSeq)
+				lastCacheStore, cacheDirty = now, false
+			}
+		}
 	}
 	if ctx.Err() != nil {
 		return ctx.Err()
@@ -1120,6 +1212,30 @@ func (c *Direct) sendMapRequest(ctx context.Context, maxPolls int, readOnly bool
 	return nil
 }
 
+// newMapSession returns a new mapSession for the node key priv.
+func (c *Direct) newMapSession(priv key.NodePrivate, machinePub key.MachinePublic, vlogf logger.Logf) *mapSession {
+	sess := newMapSession(priv)
+	sess.logf = c.logf
+	sess.vlogf = vlogf
+	sess.machinePubKey = machinePub
+	sess.keepSharerAndUserSplit = c.keepSharerAndUserSplit
+	return sess
+}
+
+// mapResumeFor returns the state of the last interrupted streaming map
+// session if it was for nodeKey, in which case the next map request
+// should ask to resume it.
+func (c *Direct) mapResumeFor(nodeKey key.NodePublic) *mapResumeState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	rs := c.mapResume
+	if rs != nil && rs.nodeKey != nodeKey {
+		c.mapResume = nil
+		return nil
+	}
+	return rs
+}
+
 // decode JSON decodes the res.Body into v. If serverNoiseKey is not specified,
 // it uses the serverKey and mkey to decode the message from the NaCl-crypto-box.
 func decode(res *http.Response, v any, serverKey, serverNoiseKey key.MachinePublic, mkey key.MachinePrivate) error {
@@ -1269,6 +1385,7 @@ type devKnobs struct {
 	ForceProxyDNS  func() bool
 	StripEndpoints func() bool // strip endpoints from control (only use disco messages)
 	StripCaps      func() bool // strip all local node's control-provided capabilities
+	NoMapCache     func() bool // don't load or store the Options.MapCache
 }
 
 func initDevKnob() devKnobs {
@@ -1277,6 +1394,7 @@ func initDevKnob() devKnobs {
 		ForceProxyDNS:  envknob.RegisterBool("TS_DEBUG_PROXY_DNS"),
 		StripEndpoints: envknob.RegisterBool("TS_DEBUG_STRIP_ENDPOINTS"),
 		StripCaps:      envknob.RegisterBool("TS_DEBUG_STRIP_CAPS"),
+		NoMapCache:     envknob.RegisterBool("TS_DEBUG_NO_NETMAP_CACHE"),
 	}
 }
 
@@ -1713,6 +1831,53 @@ func (c *Direct) ReportHealthChange(sys health.Subsystem, sysErr error) {
 	res.Body.Close()
 }
 
+// retryAfterError is an error response from control that asked to wait
+// before retrying. It implements backoff.RetryAfterError.
+type retryAfterError struct {
+	err error
+	d   time.Duration
+}
+
+func (e retryAfterError) Error() string             { return e.err.Error() }
+func (e retryAfterError) Unwrap() error             { return e.err }
+func (e retryAfterError) RetryAfter() time.Duration { return e.d }
+
+// withRetryAfter returns err, the error for the response res, with the
+// delay from res's Retry-After header if it's a 429 or 503 with one, so
+// the caller's backoff honors it.
+func withRetryAfter(res *http.Response, err error) error {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return err
+	}
+	d, ok := parseRetryAfter(res.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return err
+	}
+	return retryAfterError{err, d}
+}
+
+// parseRetryAfter parses the value of a Retry-After header, either a
+// number of seconds or an HTTP date, returning the delay from now.
+func parseRetryAfter(v string, now time.Time) (_ time.Duration, ok bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
 var (
 	metricMapRequestsActive = clientmetric.NewGauge("controlclient_map_requests_active")
 
@@ -1726,6 +1891,12 @@ func (c *Direct) ReportHealthChange(sys health.Subsystem, sysErr error) {
 	metricMapResponseMap        = clientmetric.NewCounter("controlclient_map_response_map")       // any non-keepalive map response
 	metricMapResponseMapDelta   = clientmetric.NewCounter("controlclient_map_response_map_delta") // 2nd+ non-keepalive map response
 
+	metricMapSessionResumed    = clientmetric.NewCounter("controlclient_map_session_resumed")
+	metricMapSessionNotResumed = clientmetric.NewCounter("controlclient_map_session_not_resumed")
+
+	metricMapCacheLoaded = clientmetric.NewCounter("controlclient_map_cache_loaded")
+	metricMapCacheStored = clientmetric.NewCounter("controlclient_map_cache_stored")
+
 	metricSetDNS      = clientmetric.NewCounter("controlclient_setdns")
 	metricSetDNSError = clientmetric.NewCounter("controlclient_setdns_error")
 )
@@ -142,3 +142,51 @@ func TestTsmpPing(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestMapResumeFor(t *testing.T) {
+	nodeKey := key.NewNode().Public()
+	c := &Direct{}
+	if rs := c.mapResumeFor(nodeKey); rs != nil {
+		t.Fatalf("got %+v with no interrupted session", rs)
+	}
+
+	rs := &mapResumeState{nodeKey: nodeKey, handle: "sess", seq: 5}
+	c.mapResume = rs
+	if got := c.mapResumeFor(nodeKey); got != rs {
+		t.Fatalf("got %+v; want %+v", got, rs)
+	}
+	if c.mapResume != rs {
+		t.Fatal("state dropped before the session was resumed")
+	}
+
+	// A new node key starts over.
+	if got := c.mapResumeFor(key.NewNode().Public()); got != nil {
+		t.Fatalf("got %+v for another node key", got)
+	}
+	if c.mapResume != nil {
+		t.Fatal("state of the old node key kept")
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in     string
+		want   time.Duration
+		wantOK bool
+	}{
+		{"", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"0", 0, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 01 Feb 2023 12:01:30 GMT", 90 * time.Second, true},
+		{"Wed, 01 Feb 2023 11:00:00 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.in, now)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseRetryAfter(%q) = %v, %v; want %v, %v", tt.in, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+// MapCache is the interface optionally supplied when creating a control
+// client to persist its last netmap across restarts. The data is
+// encrypted to the node key, so it's only usable by the same node.
+//
+// It is usually implemented by the ipn.StateStore.
+type MapCache interface {
+	// Load returns the stored data, or nil if there is none.
+	Load() ([]byte, error)
+
+	// Store replaces the stored data.
+	Store([]byte) error
+}
+
+const (
+	// mapCacheInterval is how often at most the netmap is stored while
+	// it's changing, besides when a map session ends.
+	mapCacheInterval = 30 * time.Second
+
+	// mapCacheMaxAge is how old a stored netmap can be and still be used
+	// on startup.
+	mapCacheMaxAge = 7 * 24 * time.Hour
+)
+
+// mapCacheState is the stored state of a map session.
+type mapCacheState struct {
+	// Handle and Seq are the map session's MapSessionHandle and the
+	// MapResponse.Seq of the state, if any, for the next map request to
+	// ask to resume the session from.
+	Handle string `json:",omitempty"`
+	Seq    int64  `json:",omitempty"`
+
+	// Saved is when the state was stored.
+	Saved time.Time
+
+	// Map is a full MapResponse recreating the session's state.
+	Map *tailcfg.MapResponse
+}
+
+// fullResponse returns a full MapResponse that, passed to the
+// netmapForResponse of a new mapSession, recreates the state of ms,
+// as well as the last netmap it returned.
+func (ms *mapSession) fullResponse() *tailcfg.MapResponse {
+	resp := &tailcfg.MapResponse{
+		Node:                      ms.lastNode,
+		DERPMap:                   ms.lastDERPMap,
+		DNSConfig:                 ms.lastDNSConfig,
+		SSHPolicy:                 ms.lastSSHPolicy,
+		Peers:                     ms.previousPeers,
+		Domain:                    ms.lastDomain,
+		DomainDataPlaneAuditLogID: ms.lastDomainAuditLogID,
+		Health:                    ms.lastHealth,
+		TKAInfo:                   ms.lastTKAInfo,
+	}
+	if ms.lastPacketFilterRules.Len() > 0 {
+		resp.PacketFilter = ms.lastPacketFilterRules.AsSlice()
+	}
+	resp.CollectServices.Set(ms.collectServices)
+	if ms.stickyDebug != (tailcfg.Debug{}) {
+		d := ms.stickyDebug
+		resp.Debug = &d
+	}
+	for _, up := range ms.lastUserProfile {
+		resp.UserProfiles = append(resp.UserProfiles, up)
+	}
+	sort.Slice(resp.UserProfiles, func(i, j int) bool {
+		return resp.UserProfiles[i].ID < resp.UserProfiles[j].ID
+	})
+	return resp
+}
+
+// encodeMapCache returns st sealed to the node key of priv.
+func encodeMapCache(st *mapCacheState, priv key.NodePrivate) ([]byte, error) {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return nil, err
+	}
+	return priv.SealTo(priv.Public(), j), nil
+}
+
+// decodeMapCache opens the data of encodeMapCache with the node key priv.
+func decodeMapCache(b []byte, priv key.NodePrivate) (*mapCacheState, error) {
+	j, ok := priv.OpenFrom(priv.Public(), b)
+	if !ok {
+		return nil, errors.New("not sealed to the current node key")
+	}
+	st := new(mapCacheState)
+	if err := json.Unmarshal(j, st); err != nil {
+		return nil, err
+	}
+	if st.Map == nil || st.Map.Node == nil {
+		return nil, errors.New("no netmap")
+	}
+	return st, nil
+}
+
+// storeMapCache stores the state of sess, as of MapResponse.Seq seq of the
+// map session handle, to c.mapCache.
+func (c *Direct) storeMapCache(sess *mapSession, handle string, seq int64) {
+	if c.mapCache == nil || DevKnob.NoMapCache() {
+		return
+	}
+	b, err := encodeMapCache(&mapCacheState{
+		Handle: handle,
+		Seq:    seq,
+		Saved:  c.timeNow(),
+		Map:    sess.fullResponse(),
+	}, sess.privateNodeKey)
+	if err == nil {
+		err = c.mapCache.Store(b)
+	}
+	if err != nil {
+		c.logf("netmap: storing cache: %v", err)
+		return
+	}
+	metricMapCacheStored.Add(1)
+}
+
+// loadMapCache restores the state of the map session stored in c.mapCache
+// into the new session sess, the first time it's called. It returns the
+// netmap of the stored state, if any, and the state to resume the session
+// from, if the stored session can be resumed.
+func (c *Direct) loadMapCache(sess *mapSession) (*mapResumeState, *netmap.NetworkMap) {
+	c.mu.Lock()
+	loaded := c.mapCacheLoaded
+	c.mapCacheLoaded = true
+	c.mu.Unlock()
+	if loaded || c.mapCache == nil || DevKnob.NoMapCache() {
+		return nil, nil
+	}
+	st, err := c.readMapCache(sess.privateNodeKey)
+	if err != nil {
+		c.logf("netmap: not using cache: %v", err)
+		return nil, nil
+	}
+	if st == nil {
+		return nil, nil
+	}
+	nm := sess.netmapForResponse(st.Map)
+	if !nm.Expiry.IsZero() && nm.Expiry.Before(c.timeNow()) {
+		c.logf("netmap: not using cache: node key expired")
+		return nil, nil
+	}
+	metricMapCacheLoaded.Add(1)
+	var rs *mapResumeState
+	if st.Handle != "" {
+		rs = &mapResumeState{
+			nodeKey: sess.privateNodeKey.Public(),
+			handle:  st.Handle,
+			seq:     st.Seq,
+			sess:    sess,
+		}
+	}
+	return rs, nm
+}
+
+// readMapCache returns the state in c.mapCache, or nil if there is none.
+func (c *Direct) readMapCache(priv key.NodePrivate) (*mapCacheState, error) {
+	b, err := c.mapCache.Load()
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	st, err := decodeMapCache(b, priv)
+	if err != nil {
+		return nil, err
+	}
+	if age := c.timeNow().Sub(st.Saved); age > mapCacheMaxAge {
+		return nil, fmt.Errorf("stored %v ago", age.Round(time.Hour))
+	}
+	return st, nil
+}
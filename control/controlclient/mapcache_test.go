@@ -0,0 +1,108 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package controlclient
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/logger"
+)
+
+type memMapCache struct{ b []byte }
+
+func (c *memMapCache) Load() ([]byte, error) { return c.b, nil }
+func (c *memMapCache) Store(b []byte) error  { c.b = b; return nil }
+
+func TestMapCache(t *testing.T) {
+	now := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	priv := key.NewNode()
+	machine := key.NewMachine().Public()
+	cache := new(memMapCache)
+	newDirect := func() *Direct {
+		return &Direct{
+			logf:     t.Logf,
+			timeNow:  func() time.Time { return now },
+			mapCache: cache,
+		}
+	}
+
+	c := newDirect()
+	sess := c.newMapSession(priv, machine, logger.Discard)
+	sess.netmapForResponse(&tailcfg.MapResponse{
+		Node: &tailcfg.Node{
+			ID:        1,
+			Name:      "self.example.ts.net.",
+			User:      10,
+			Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+			KeyExpiry: now.Add(time.Hour),
+		},
+		Peers: []*tailcfg.Node{
+			{ID: 2, Name: "a.example.ts.net.", User: 10},
+			{ID: 3, Name: "b.example.ts.net.", User: 11},
+		},
+		UserProfiles: []tailcfg.UserProfile{
+			{ID: 10, LoginName: "alice@example.com"},
+			{ID: 11, LoginName: "bob@example.com"},
+		},
+		DNSConfig:    &tailcfg.DNSConfig{Domains: []string{"example.ts.net"}},
+		PacketFilter: []tailcfg.FilterRule{{SrcIPs: []string{"*"}, DstPorts: []tailcfg.NetPortRange{{IP: "*", Ports: tailcfg.PortRangeAny}}}},
+		Domain:       "example.com",
+	})
+	want := sess.netmapForResponse(&tailcfg.MapResponse{
+		PeersRemoved: []tailcfg.NodeID{3},
+	})
+	c.storeMapCache(sess, "sess", 7)
+	if cache.b == nil {
+		t.Fatal("nothing stored")
+	}
+
+	// A new client with the same node key starts with the stored netmap,
+	// and to resume the session.
+	c = newDirect()
+	rs, nm := c.loadMapCache(c.newMapSession(priv, machine, logger.Discard))
+	if nm == nil {
+		t.Fatal("no netmap loaded")
+	}
+	if got, want := nm.VeryConcise(), want.VeryConcise(); got != want {
+		t.Errorf("loaded netmap:\n%s\nwant:\n%s", got, want)
+	}
+	if len(nm.Peers) != 1 || nm.UserProfiles[10].LoginName != "alice@example.com" || len(nm.PacketFilter) != 1 || nm.Domain != "example.com" {
+		t.Errorf("loaded netmap peers %v, profiles %v, filter %v, domain %q", nm.Peers, nm.UserProfiles, nm.PacketFilter, nm.Domain)
+	}
+	if rs == nil || rs.handle != "sess" || rs.seq != 7 || rs.nodeKey != priv.Public() || rs.sess == nil {
+		t.Fatalf("resume state = %+v", rs)
+	}
+
+	// Deltas resumed from the loaded session apply to the stored state.
+	nm = rs.sess.netmapForResponse(&tailcfg.MapResponse{
+		PeersChanged: []*tailcfg.Node{{ID: 4, Name: "c.example.ts.net.", User: 10}},
+	})
+	if len(nm.Peers) != 2 || nm.Peers[1].ID != 4 {
+		t.Errorf("peers after delta = %v", nm.Peers)
+	}
+
+	// It's only loaded once.
+	if rs, nm := c.loadMapCache(c.newMapSession(priv, machine, logger.Discard)); rs != nil || nm != nil {
+		t.Error("loaded again")
+	}
+
+	// Another node key can't use it.
+	c = newDirect()
+	if rs, nm := c.loadMapCache(c.newMapSession(key.NewNode(), machine, logger.Discard)); rs != nil || nm != nil {
+		t.Error("loaded with another node key")
+	}
+
+	// Nor is it used after the node key expired, or a week later.
+	for _, d := range []time.Duration{2 * time.Hour, 8 * 24 * time.Hour} {
+		c = newDirect()
+		c.timeNow = func() time.Time { return now.Add(d) }
+		if rs, nm := c.loadMapCache(c.newMapSession(priv, machine, logger.Discard)); rs != nil || nm != nil {
+			t.Errorf("loaded after %v", d)
+		}
+	}
+}
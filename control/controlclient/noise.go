@@ -134,6 +134,14 @@ func (c *noiseConn) Close() error {
 	return nil
 }
 
+// The HTTP/2 health check of Noise connections: a PING is sent after a
+// connection receives nothing for noiseReadIdleTimeout, and the connection
+// is closed if the PING isn't answered within noisePingTimeout.
+const (
+	noiseReadIdleTimeout = 30 * time.Second
+	noisePingTimeout     = 15 * time.Second
+)
+
 // NoiseClient provides a http.Client to connect to tailcontrol over
 // the ts2021 protocol.
 type NoiseClient struct {
@@ -220,6 +228,11 @@ func NewNoiseClient(privKey key.MachinePrivate, serverPubKey key.MachinePublic,
 	if err != nil {
 		return nil, err
 	}
+	// Detect dead connections with HTTP/2 PINGs, rather than only when
+	// the map long-poll times out, so that requests on flaky links move
+	// to a new connection, and map sessions get resumed on it, sooner.
+	h2Transport.ReadIdleTimeout = noiseReadIdleTimeout
+	h2Transport.PingTimeout = noisePingTimeout
 	np.h2t = h2Transport
 
 	np.Client = &http.Client{Transport: np}
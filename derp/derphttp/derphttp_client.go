@@ -28,6 +28,7 @@
 	"time"
 
 	"go4.org/mem"
+	"golang.org/x/net/proxy"
 	"tailscale.com/derp"
 	"tailscale.com/envknob"
 	"tailscale.com/net/dnscache"
@@ -523,8 +524,18 @@ func (c *Client) dialRegion(ctx context.Context, reg *tailcfg.DERPRegion) (net.C
 	return nil, nil, firstErr
 }
 
+// tlsSessionCache is the TLS session cache of the Clients that don't set
+// their own, shared by all of them in the process so that reconnects to a
+// DERP node, and other Clients connecting to it (such as those of other
+// profiles or tsnet.Servers), resume a TLS session instead of doing a full
+// handshake.
+var tlsSessionCache = tls.NewLRUClientSessionCache(64)
+
 func (c *Client) tlsClient(nc net.Conn, node *tailcfg.DERPNode) *tls.Conn {
 	tlsConf := tlsdial.Config(c.tlsServerName(node), c.TLSConfig)
+	if tlsConf.ClientSessionCache == nil {
+		tlsConf.ClientSessionCache = tlsSessionCache
+	}
 	if node != nil {
 		if node.InsecureForTests {
 			tlsConf.InsecureSkipVerify = true
@@ -689,9 +700,13 @@ func firstStr(a, b string) string {
 	return b
 }
 
-// dialNodeUsingProxy connects to n using a CONNECT to the HTTP(s) proxy in proxyURL.
+// dialNodeUsingProxy connects to n using a CONNECT to the HTTP(s) proxy in
+// proxyURL, or via the SOCKS5 proxy in proxyURL.
 func (c *Client) dialNodeUsingProxy(ctx context.Context, n *tailcfg.DERPNode, proxyURL *url.URL) (proxyConn net.Conn, err error) {
 	pu := proxyURL
+	if pu.Scheme == "socks5" || pu.Scheme == "socks5h" {
+		return c.dialNodeUsingSOCKS5(ctx, n, pu)
+	}
 	if pu.Scheme == "https" {
 		var d tls.Dialer
 		proxyConn, err = d.DialContext(ctx, "tcp", net.JoinHostPort(pu.Hostname(), firstStr(pu.Port(), "443")))
@@ -753,6 +768,26 @@ func (c *Client) dialNodeUsingProxy(ctx context.Context, n *tailcfg.DERPNode, pr
 	return proxyConn, nil
 }
 
+// dialNodeUsingSOCKS5 connects to n via the SOCKS5 proxy in proxyURL.
+func (c *Client) dialNodeUsingSOCKS5(ctx context.Context, n *tailcfg.DERPNode, proxyURL *url.URL) (net.Conn, error) {
+	d, err := proxy.FromURL(proxyURL, netns.NewDialer(c.logf))
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := d.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS5 dialer for %v doesn't support contexts", proxyURL.Redacted())
+	}
+	target := net.JoinHostPort(n.HostName, "443")
+	conn, err := cd.DialContext(ctx, "tcp", target)
+	if err != nil {
+		c.logf("derphttp: SOCKS5 dial to %s: %v", target, err)
+		return nil, err
+	}
+	c.logf("derphttp: SOCKS5 dial to %s: connected", target)
+	return conn, nil
+}
+
 func (c *Client) Send(dstKey key.NodePublic, b []byte) error {
 	client, _, err := c.connect(context.TODO(), "derphttp.Client.Send")
 	if err != nil {
@@ -9,11 +9,13 @@
 	"crypto/tls"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"sync"
 	"testing"
 	"time"
 
 	"tailscale.com/derp"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/key"
 )
 
@@ -206,3 +208,50 @@ func TestPing(t *testing.T) {
 		t.Fatalf("Ping: %v", err)
 	}
 }
+
+func TestTLSSessionResumption(t *testing.T) {
+	s := derp.NewServer(key.NewNode(), t.Logf)
+	defer s.Close()
+
+	httpsrv := httptest.NewUnstartedServer(Handler(s))
+	httpsrv.Config.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	httpsrv.StartTLS()
+	defer httpsrv.Close()
+	port := httpsrv.Listener.Addr().(*net.TCPAddr).Port
+
+	region := &tailcfg.DERPRegion{
+		RegionID: 1,
+		Nodes: []*tailcfg.DERPNode{{
+			Name:             "1a",
+			RegionID:         1,
+			HostName:         "derp-test.localhost",
+			IPv4:             "127.0.0.1",
+			IPv6:             "none",
+			DERPPort:         port,
+			InsecureForTests: true,
+		}},
+	}
+	connect := func() *tls.ConnectionState {
+		t.Helper()
+		c := NewRegionClient(key.NewNode(), t.Logf, func() *tailcfg.DERPRegion { return region })
+		defer c.Close()
+		if err := c.Connect(context.Background()); err != nil {
+			t.Fatalf("client Connect: %v", err)
+		}
+		// Reading the server info also reads the TLS session ticket
+		// that the server sends after the handshake.
+		waitConnect(t, c)
+		cs, ok := c.TLSConnectionState()
+		if !ok {
+			t.Fatal("no TLS connection state")
+		}
+		return cs
+	}
+	if cs := connect(); cs.DidResume {
+		t.Error("first connection resumed a TLS session")
+	}
+	// The session is shared with other Clients.
+	if cs := connect(); !cs.DidResume {
+		t.Error("second connection didn't resume the TLS session")
+	}
+}
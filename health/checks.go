@@ -0,0 +1,195 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+)
+
+var (
+	checks = map[string]*check{} // guarded by mu
+
+	// runChecksMu serializes runs of the checks, so that a state
+	// change is reported to the webhook exactly once.
+	runChecksMu sync.Mutex
+
+	webhookURL = envknob.RegisterString("TS_HEALTH_WEBHOOK_URL")
+)
+
+func init() {
+	RegisterCheck("control", controlCheck)
+	RegisterCheck("derp-home", derpHomeCheck)
+}
+
+// check is a named health check registered with RegisterCheck.
+type check struct {
+	name string
+	run  func() error
+
+	// The fields below are guarded by runChecksMu.
+	known bool      // whether run has been called yet
+	err   error     // result of the most recent run
+	since time.Time // when the check last changed state
+}
+
+// CheckResult is the state of a named health check, as reported by
+// CheckResults and sent to the health webhook.
+type CheckResult struct {
+	// Name is the name of the check, such as "control" or "derp-home".
+	Name string
+
+	// Healthy is whether the check passed.
+	Healthy bool
+
+	// Error is the reason the check failed, if it's not Healthy.
+	Error string `json:",omitempty"`
+
+	// Since is when the check entered its current state.
+	Since time.Time
+}
+
+// RegisterCheck adds a named health check. The run func reports whether
+// the check is healthy by returning nil. It's called every minute while
+// any watcher is registered (see RegisterWatcher), as one always is in
+// tailscaled, and whenever CheckResults is called. Either way, changes of
+// state are POSTed to TS_HEALTH_WEBHOOK_URL, if set. The returned func
+// unregisters the check.
+//
+// If a check with the same name is already registered, it is replaced.
+func RegisterCheck(name string, run func() error) (unregister func()) {
+	c := &check{name: name, run: run}
+	mu.Lock()
+	defer mu.Unlock()
+	checks[name] = c
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if checks[name] == c {
+			delete(checks, name)
+		}
+	}
+}
+
+// CheckResults runs all the registered health checks and returns their
+// results, sorted by name.
+func CheckResults() []CheckResult {
+	return runChecks()
+}
+
+// runChecks runs all the registered checks and POSTs the results to the
+// webhook in TS_HEALTH_WEBHOOK_URL, if set, when any check changed from
+// healthy to unhealthy or back.
+func runChecks() []CheckResult {
+	runChecksMu.Lock()
+	defer runChecksMu.Unlock()
+
+	mu.Lock()
+	cs := make([]*check, 0, len(checks))
+	for _, c := range checks {
+		cs = append(cs, c)
+	}
+	mu.Unlock()
+	sort.Slice(cs, func(i, j int) bool { return cs[i].name < cs[j].name })
+
+	now := time.Now()
+	var results, changed []CheckResult
+	for _, c := range cs {
+		err := c.run()
+		wasKnown, wasHealthy := c.known, c.err == nil
+		if !c.known || wasHealthy != (err == nil) {
+			c.since = now
+		}
+		c.known, c.err = true, err
+
+		res := CheckResult{Name: c.name, Healthy: err == nil, Since: c.since}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+		// Like watchers, don't report the transition from unknown to
+		// healthy.
+		if (wasKnown && wasHealthy != res.Healthy) || (!wasKnown && !res.Healthy) {
+			changed = append(changed, res)
+		}
+	}
+	if url := webhookURL(); url != "" && len(changed) > 0 {
+		go postWebhook(url, changed, results)
+	}
+	return results
+}
+
+// webhookPayload is the JSON body POSTed to the health webhook.
+type webhookPayload struct {
+	Host    string        // os.Hostname of this node
+	Changed []CheckResult // checks whose state changed
+	Checks  []CheckResult // all checks
+}
+
+func postWebhook(url string, changed, all []CheckResult) {
+	host, _ := os.Hostname()
+	body, err := json.Marshal(webhookPayload{
+		Host:    host,
+		Changed: changed,
+		Checks:  all,
+	})
+	if err != nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("health: webhook: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("health: webhook: %v", err)
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		log.Printf("health: webhook: unexpected status %v", res.Status)
+	}
+}
+
+// controlCheck is the "control" check, which reports whether the
+// coordination server is reachable.
+func controlCheck() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if !ipnWantRunning {
+		return nil
+	}
+	return controlErrorLocked(time.Now())
+}
+
+// derpHomeCheck is the "derp-home" check, which reports whether this
+// node's home DERP region is connected and healthy.
+func derpHomeCheck() error {
+	mu.Lock()
+	defer mu.Unlock()
+	if !ipnWantRunning {
+		return nil
+	}
+	if err := derpHomeErrorLocked(time.Now()); err != nil {
+		return err
+	}
+	if problem, ok := derpRegionHealthProblem[derpHomeRegion]; ok {
+		return fmt.Errorf("derp%d: %v", derpHomeRegion, problem)
+	}
+	return nil
+}
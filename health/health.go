@@ -78,7 +78,7 @@
 // NewWarnable returns a new warnable item that the caller can mark
 // as health or in warning state.
 func NewWarnable(opts ...WarnableOpt) *Warnable {
-	w := new(Warnable)
+	w := &Warnable{code: "warnable"}
 	for _, o := range opts {
 		o.mod(w)
 	}
@@ -102,6 +102,15 @@ func WithMapDebugFlag(name string) WarnableOpt {
 	})
 }
 
+// WithCode returns a WarnableOpt for NewWarnable that sets the Warning.Code
+// the returned Warnable is reported with when Set to a non-nil value.
+// The default is "warnable".
+func WithCode(code string) WarnableOpt {
+	return warnOptFunc(func(w *Warnable) {
+		w.code = code
+	})
+}
+
 type warnOptFunc func(*Warnable)
 
 func (f warnOptFunc) mod(w *Warnable) { f(w) }
@@ -110,6 +119,7 @@ func (f warnOptFunc) mod(w *Warnable) { f(w) }
 // The caller of NewWarnable is responsible for calling Set to update the state.
 type Warnable struct {
 	debugFlag string // optional MapRequest.DebugFlag to send when unhealthy
+	code      string // Warning.Code
 
 	isSet atomic.Bool
 	mu    sync.Mutex
@@ -397,9 +407,12 @@ func timerSelfCheck() {
 	defer mu.Unlock()
 	checkReceiveFuncs()
 	selfCheckLocked()
-	if timer != nil {
-		timer.Reset(time.Minute)
+	if timer == nil {
+		// The last watcher was unregistered as the timer fired.
+		return
 	}
+	timer.Reset(time.Minute)
+	go runChecks()
 }
 
 func selfCheckLocked() {
@@ -423,38 +436,81 @@ func OverallError() error {
 var fakeErrForTesting = envknob.RegisterString("TS_DEBUG_FAKE_HEALTH_ERROR")
 
 func overallErrorLocked() error {
+	ws := warningsLocked(time.Now())
+	errs := make([]error, len(ws))
+	for i, w := range ws {
+		errs[i] = w.err
+	}
+	return multierr.New(errs...)
+}
+
+// Severity is how serious a health Warning is.
+type Severity string
+
+const (
+	// SeverityError means the node can't connect to the tailnet, or
+	// some subsystem is broken.
+	SeverityError = Severity("error")
+
+	// SeverityWarning means something may not work as expected.
+	SeverityWarning = Severity("warning")
+)
+
+// Warning is a health problem, as returned by Warnings.
+type Warning struct {
+	// Code identifies the kind of problem, such as "network-down",
+	// "control" or "dns", for monitoring systems to match on. For
+	// subsystem errors it's the Subsystem name.
+	Code string
+
+	// Severity is how serious the problem is.
+	Severity Severity
+
+	// Text describes the problem. It's the same as the corresponding
+	// element of OverallError.
+	Text string
+
+	// DocsURL, if non-empty, is a page describing the problem and how
+	// to fix it.
+	DocsURL string `json:",omitempty"`
+
+	err error // returned by OverallError
+}
+
+// Warnings returns the same health problems as OverallError, each with a
+// code and severity, sorted by Text.
+func Warnings() []Warning {
+	mu.Lock()
+	defer mu.Unlock()
+	return warningsLocked(time.Now())
+}
+
+const firewallDocsURL = "https://tailscale.com/kb/1082/firewall-ports/"
+
+func newWarning(code string, sev Severity, docsURL string, err error) Warning {
+	return Warning{Code: code, Severity: sev, Text: err.Error(), DocsURL: docsURL, err: err}
+}
+
+// warningsLocked returns the current health problems. Problems that
+// prevent the node from connecting at all are reported alone.
+func warningsLocked(now time.Time) []Warning {
 	if !anyInterfaceUp {
-		return errors.New("network down")
+		return []Warning{newWarning("network-down", SeverityError, "", errors.New("network down"))}
 	}
 	if localLogConfigErr != nil {
-		return localLogConfigErr
+		return []Warning{newWarning("log-config", SeverityError, "", localLogConfigErr)}
 	}
 	if !ipnWantRunning {
-		return fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning)
-	}
-	if lastLoginErr != nil {
-		return fmt.Errorf("not logged in, last login error=%v", lastLoginErr)
-	}
-	now := time.Now()
-	if !inMapPoll && (lastMapPollEndedAt.IsZero() || now.Sub(lastMapPollEndedAt) > 10*time.Second) {
-		return errors.New("not in map poll")
-	}
-	const tooIdle = 2*time.Minute + 5*time.Second
-	if d := now.Sub(lastStreamedMapResponse).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("no map response in %v", d)
+		return []Warning{newWarning("not-running", SeverityWarning, "", fmt.Errorf("state=%v, wantRunning=%v", ipnState, ipnWantRunning))}
 	}
-	rid := derpHomeRegion
-	if rid == 0 {
-		return errors.New("no DERP home")
+	if err := controlErrorLocked(now); err != nil {
+		return []Warning{newWarning("control", SeverityError, firewallDocsURL, err)}
 	}
-	if !derpRegionConnected[rid] {
-		return fmt.Errorf("not connected to home DERP region %v", rid)
-	}
-	if d := now.Sub(derpRegionLastFrame[rid]).Round(time.Second); d > tooIdle {
-		return fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d)
+	if err := derpHomeErrorLocked(now); err != nil {
+		return []Warning{newWarning("derp-home", SeverityError, firewallDocsURL, err)}
 	}
 	if udp4Unbound {
-		return errors.New("no udp4 bind")
+		return []Warning{newWarning("udp4-unbound", SeverityError, firewallDocsURL, errors.New("no udp4 bind"))}
 	}
 
 	// TODO: use
@@ -463,43 +519,80 @@ func overallErrorLocked() error {
 	_ = lastStreamedMapResponse
 	_ = lastMapRequestHeard
 
-	var errs []error
+	var ws []Warning
 	for _, recv := range receiveFuncs {
 		if recv.missing {
-			errs = append(errs, fmt.Errorf("%s is not running", recv.name))
+			ws = append(ws, newWarning("receive-func", SeverityError, "", fmt.Errorf("%s is not running", recv.name)))
 		}
 	}
 	for sys, err := range sysErr {
 		if err == nil || sys == SysOverall {
 			continue
 		}
-		errs = append(errs, fmt.Errorf("%v: %w", sys, err))
+		ws = append(ws, newWarning(string(sys), SeverityError, subsystemDocsURL[sys], fmt.Errorf("%v: %w", sys, err)))
 	}
 	for w := range warnables {
 		if err := w.get(); err != nil {
-			errs = append(errs, err)
+			ws = append(ws, newWarning(w.code, SeverityWarning, "", err))
 		}
 	}
 	for regionID, problem := range derpRegionHealthProblem {
-		errs = append(errs, fmt.Errorf("derp%d: %v", regionID, problem))
+		ws = append(ws, newWarning("derp-region", SeverityWarning, "", fmt.Errorf("derp%d: %v", regionID, problem)))
 	}
 	for _, s := range controlHealth {
-		errs = append(errs, errors.New(s))
+		ws = append(ws, newWarning("control-message", SeverityWarning, "", errors.New(s)))
 	}
 	if err := envknob.ApplyDiskConfigError(); err != nil {
-		errs = append(errs, err)
+		ws = append(ws, newWarning("disk-config", SeverityWarning, "", err))
 	}
 	for serverName, err := range tlsConnectionErrors {
-		errs = append(errs, fmt.Errorf("TLS connection error for %q: %w", serverName, err))
+		ws = append(ws, newWarning("tls", SeverityError, "", fmt.Errorf("TLS connection error for %q: %w", serverName, err)))
 	}
-	if e := fakeErrForTesting(); len(errs) == 0 && e != "" {
-		return errors.New(e)
+	if e := fakeErrForTesting(); len(ws) == 0 && e != "" {
+		return []Warning{newWarning("fake", SeverityError, "", errors.New(e))}
 	}
-	sort.Slice(errs, func(i, j int) bool {
-		// Not super efficient (stringifying these in a sort), but probably max 2 or 3 items.
-		return errs[i].Error() < errs[j].Error()
+	sort.Slice(ws, func(i, j int) bool {
+		return ws[i].Text < ws[j].Text
 	})
-	return multierr.New(errs...)
+	return ws
+}
+
+// subsystemDocsURL are the docs pages for errors of some subsystems.
+var subsystemDocsURL = map[Subsystem]string{
+	SysTKA: "https://tailscale.com/kb/1226/tailnet-lock/",
+}
+
+const tooIdle = 2*time.Minute + 5*time.Second
+
+// controlErrorLocked returns the reason the coordination server isn't
+// reachable, if any.
+func controlErrorLocked(now time.Time) error {
+	if lastLoginErr != nil {
+		return fmt.Errorf("not logged in, last login error=%v", lastLoginErr)
+	}
+	if !inMapPoll && (lastMapPollEndedAt.IsZero() || now.Sub(lastMapPollEndedAt) > 10*time.Second) {
+		return errors.New("not in map poll")
+	}
+	if d := now.Sub(lastStreamedMapResponse).Round(time.Second); d > tooIdle {
+		return fmt.Errorf("no map response in %v", d)
+	}
+	return nil
+}
+
+// derpHomeErrorLocked returns the reason this node's home DERP region
+// isn't connected, if any.
+func derpHomeErrorLocked(now time.Time) error {
+	rid := derpHomeRegion
+	if rid == 0 {
+		return errors.New("no DERP home")
+	}
+	if !derpRegionConnected[rid] {
+		return fmt.Errorf("not connected to home DERP region %v", rid)
+	}
+	if d := now.Sub(derpRegionLastFrame[rid]).Round(time.Second); d > tooIdle {
+		return fmt.Errorf("haven't heard from home DERP region %v in %v", rid, d)
+	}
+	return nil
 }
 
 var (
@@ -4,10 +4,17 @@
 package health
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
+
+	"tailscale.com/envknob"
 )
 
 func TestAppendWarnableDebugFlags(t *testing.T) {
@@ -37,3 +44,156 @@ func resetWarnables() {
 	defer mu.Unlock()
 	warnables = make(map[*Warnable]struct{})
 }
+
+func TestCheckResults(t *testing.T) {
+	payloads := make(chan webhookPayload, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decoding webhook body: %v", err)
+		}
+		payloads <- p
+	}))
+	defer ts.Close()
+	envknob.Setenv("TS_HEALTH_WEBHOOK_URL", ts.URL)
+	defer envknob.Setenv("TS_HEALTH_WEBHOOK_URL", "")
+
+	var (
+		mu      sync.Mutex
+		testErr error
+	)
+	setTestErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		testErr = err
+	}
+	unregister := RegisterCheck("test", func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return testErr
+	})
+	defer unregister()
+
+	getResult := func() CheckResult {
+		t.Helper()
+		for _, res := range CheckResults() {
+			if res.Name == "test" {
+				return res
+			}
+		}
+		t.Fatal("test check not in CheckResults")
+		return CheckResult{}
+	}
+	nextPayload := func() webhookPayload {
+		t.Helper()
+		select {
+		case p := <-payloads:
+			return p
+		case <-time.After(10 * time.Second):
+			t.Fatal("timeout waiting for webhook")
+			return webhookPayload{}
+		}
+	}
+
+	if res := getResult(); !res.Healthy || res.Error != "" {
+		t.Fatalf("initial result = %+v; want healthy", res)
+	}
+
+	setTestErr(errors.New("boom"))
+	res := getResult()
+	if res.Healthy || res.Error != "boom" {
+		t.Fatalf("result = %+v; want unhealthy with error boom", res)
+	}
+	p := nextPayload()
+	if len(p.Changed) != 1 || !sameResult(p.Changed[0], res) {
+		t.Fatalf("webhook Changed = %+v; want [%+v]", p.Changed, res)
+	}
+
+	// Staying unhealthy is not a state change.
+	if res2 := getResult(); !sameResult(res2, res) {
+		t.Fatalf("result = %+v; want unchanged %+v", res2, res)
+	}
+
+	setTestErr(nil)
+	res = getResult()
+	if !res.Healthy {
+		t.Fatalf("result = %+v; want healthy", res)
+	}
+	p = nextPayload()
+	if len(p.Changed) != 1 || !sameResult(p.Changed[0], res) {
+		t.Fatalf("webhook Changed = %+v; want [%+v]", p.Changed, res)
+	}
+	select {
+	case p := <-payloads:
+		t.Fatalf("unexpected webhook: %+v", p)
+	default:
+	}
+}
+
+func sameResult(a, b CheckResult) bool {
+	return a.Name == b.Name && a.Healthy == b.Healthy && a.Error == b.Error && a.Since.Equal(b.Since)
+}
+
+func TestWarnings(t *testing.T) {
+	resetWarnables()
+	mu.Lock()
+	anyInterfaceUp = false
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		anyInterfaceUp = true
+		mu.Unlock()
+	}()
+
+	ws := Warnings()
+	if len(ws) != 1 || ws[0].Code != "network-down" || ws[0].Severity != SeverityError {
+		t.Fatalf("Warnings = %+v; want just network-down error", ws)
+	}
+	if err := OverallError(); err == nil || err.Error() != ws[0].Text {
+		t.Errorf("OverallError = %v; want %q", err, ws[0].Text)
+	}
+
+	mu.Lock()
+	anyInterfaceUp = true
+	ipnWantRunning = false
+	mu.Unlock()
+	if ws := Warnings(); len(ws) != 1 || ws[0].Code != "not-running" || ws[0].Severity != SeverityWarning {
+		t.Fatalf("Warnings = %+v; want just not-running warning", ws)
+	}
+
+	// Once connected, all the problems are reported.
+	mu.Lock()
+	ipnWantRunning = true
+	inMapPoll = true
+	lastStreamedMapResponse = time.Now()
+	derpHomeRegion = 1
+	derpRegionConnected[1] = true
+	derpRegionLastFrame[1] = time.Now()
+	mu.Unlock()
+	defer func() {
+		mu.Lock()
+		ipnWantRunning, inMapPoll, derpHomeRegion = false, false, 0
+		delete(derpRegionConnected, 1)
+		delete(derpRegionLastFrame, 1)
+		mu.Unlock()
+	}()
+	if ws := Warnings(); len(ws) != 0 {
+		t.Fatalf("Warnings = %+v; want none", ws)
+	}
+	w := NewWarnable(WithCode("test-warnable"))
+	w.Set(errors.New("a warnable problem"))
+	SetTKAHealth(errors.New("boom"))
+	defer SetTKAHealth(nil)
+
+	ws = Warnings()
+	want := []Warning{
+		{Code: "test-warnable", Severity: SeverityWarning, Text: "a warnable problem"},
+		{Code: "tailnet-lock", Severity: SeverityError, Text: "tailnet-lock: boom", DocsURL: "https://tailscale.com/kb/1226/tailnet-lock/"},
+	}
+	for i := range ws {
+		ws[i].err = nil
+	}
+	if !reflect.DeepEqual(ws, want) {
+		t.Errorf("Warnings = %+v; want %+v", ws, want)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package posture collects device posture attributes, such as whether disk
+// encryption is enabled, the OS patch level or the presence of an EDR
+// agent, to report to the control server for use in access policies.
+//
+// The collectors are provided by the program embedding tailscaled, which
+// registers them with Register.
+package posture
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"tailscale.com/util/mak"
+)
+
+// A Collector collects some of a device's posture attributes.
+type Collector interface {
+	// Name returns the name of the collector, like "diskencryption". It
+	// must be lowercase letters, digits and dashes, and is the namespace
+	// of the collector's attributes.
+	Name() string
+
+	// Collect returns the attributes collected, mapping their keys,
+	// like "enabled", to their values. The keys are reported prefixed by
+	// the collector's name and a colon, as in "diskencryption:enabled".
+	Collect(ctx context.Context) (map[string]string, error)
+}
+
+// CollectTimeout is how long a Collector's Collect may run before its
+// context is canceled.
+const CollectTimeout = 30 * time.Second
+
+var validName = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+var collectors map[string]Collector
+
+// Register registers c to report posture attributes. It must be called
+// before the LocalBackend is created, typically from an init func. It
+// panics if c's name is invalid or already registered.
+func Register(c Collector) {
+	name := c.Name()
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("invalid posture collector name %q", name))
+	}
+	if _, ok := collectors[name]; ok {
+		panic(fmt.Sprintf("posture collector %q already registered", name))
+	}
+	mak.Set(&collectors, name, c)
+}
+
+// HaveCollectors reports whether any Collector is registered.
+func HaveCollectors() bool {
+	return len(collectors) > 0
+}
+
+// Collect runs the registered collectors, in the order of their names,
+// and returns the attributes they collected. The errors of the collectors
+// that failed are returned in errs, keyed by their name.
+func Collect(ctx context.Context) (attrs map[string]string, errs map[string]error) {
+	names := make([]string, 0, len(collectors))
+	for name := range collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cattrs, err := collect(ctx, collectors[name])
+		if err != nil {
+			mak.Set(&errs, name, err)
+			continue
+		}
+		for k, v := range cattrs {
+			mak.Set(&attrs, name+":"+k, v)
+		}
+	}
+	return attrs, errs
+}
+
+func collect(ctx context.Context, c Collector) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, CollectTimeout)
+	defer cancel()
+	return c.Collect(ctx)
+}
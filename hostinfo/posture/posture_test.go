@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package posture
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testCollector struct {
+	name  string
+	attrs map[string]string
+	err   error
+}
+
+func (c testCollector) Name() string { return c.name }
+
+func (c testCollector) Collect(ctx context.Context) (map[string]string, error) {
+	return c.attrs, c.err
+}
+
+func TestCollect(t *testing.T) {
+	defer func(old map[string]Collector) { collectors = old }(collectors)
+	collectors = nil
+
+	if HaveCollectors() {
+		t.Fatal("HaveCollectors with none registered")
+	}
+	Register(testCollector{name: "diskencryption", attrs: map[string]string{"enabled": "true"}})
+	Register(testCollector{name: "os-patch", attrs: map[string]string{"level": "2023-02", "reboot-pending": "false"}})
+	Register(testCollector{name: "edr", err: errors.New("agent not responding")})
+	if !HaveCollectors() {
+		t.Fatal("!HaveCollectors with collectors registered")
+	}
+
+	attrs, errs := Collect(context.Background())
+	wantAttrs := map[string]string{
+		"diskencryption:enabled":  "true",
+		"os-patch:level":          "2023-02",
+		"os-patch:reboot-pending": "false",
+	}
+	if !reflect.DeepEqual(attrs, wantAttrs) {
+		t.Errorf("attrs = %v; want %v", attrs, wantAttrs)
+	}
+	if len(errs) != 1 || errs["edr"] == nil {
+		t.Errorf("errs = %v; want edr error", errs)
+	}
+}
+
+func TestRegisterInvalid(t *testing.T) {
+	defer func(old map[string]Collector) { collectors = old }(collectors)
+	collectors = nil
+
+	for _, name := range []string{"", "Disk", "disk:enc", "-disk", "disk_enc"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Register(%q) didn't panic", name)
+				}
+			}()
+			Register(testCollector{name: name})
+		}()
+	}
+	Register(testCollector{name: "disk"})
+	defer func() {
+		if recover() == nil {
+			t.Error("duplicate Register didn't panic")
+		}
+	}()
+	Register(testCollector{name: "disk"})
+}
@@ -111,10 +111,30 @@ type Notify struct {
 	// macOS Network Extension.
 	LocalTCPPort *uint16 `json:",omitempty"`
 
+	// CaptivePortal, if non-nil, is whether a captive portal was
+	// detected on the current network. While one is, internet traffic
+	// bypasses the exit node, if any, so that the portal's sign-in page
+	// can be reached.
+	CaptivePortal *bool `json:",omitempty"`
+
 	// ClientVersion, if non-nil, describes whether a client version update
 	// is available.
 	ClientVersion *tailcfg.ClientVersion `json:",omitempty"`
 
+	// KeyExpiryWarning, if non-nil, warns that the node key is about to
+	// expire, or has, after which the node drops off the tailnet until
+	// it's reauthenticated. It's sent once per crossed threshold.
+	KeyExpiryWarning *KeyExpiryWarning `json:",omitempty"`
+
+	// PolicyChanged, if non-empty, is the names of the system policy
+	// settings (such as "KillSwitch") that a sysadmin changed while
+	// tailscaled was running, and that now apply.
+	PolicyChanged []string `json:",omitempty"`
+
+	// LinkChange, if non-nil, describes a major change of the machine's
+	// network, such as moving to another Wi-Fi network or to cellular.
+	LinkChange *LinkChange `json:",omitempty"`
+
 	// type is mirrored in xcode/Shared/IPN.swift
 }
 
@@ -154,10 +174,51 @@ func (n Notify) String() string {
 	if n.LocalTCPPort != nil {
 		fmt.Fprintf(&sb, "tcpport=%v ", n.LocalTCPPort)
 	}
+	if n.CaptivePortal != nil {
+		fmt.Fprintf(&sb, "captive=%v ", *n.CaptivePortal)
+	}
+	if n.KeyExpiryWarning != nil {
+		fmt.Fprintf(&sb, "keyexpiry=%v ", n.KeyExpiryWarning.Expiry.Format(time.RFC3339))
+	}
+	if len(n.PolicyChanged) != 0 {
+		fmt.Fprintf(&sb, "policy=%v ", n.PolicyChanged)
+	}
+	if n.LinkChange != nil {
+		fmt.Fprintf(&sb, "link=%v ", n.LinkChange.Kind)
+	}
 	s := sb.String()
 	return s[0:len(s)-1] + "}"
 }
 
+// KeyExpiryWarning is a warning that the node key expires soon.
+type KeyExpiryWarning struct {
+	// Expiry is when the node key expires.
+	Expiry time.Time
+
+	// Threshold is the warning threshold that the time left before
+	// Expiry crossed, such as 24h, or zero once the key has expired.
+	Threshold time.Duration
+}
+
+// LinkChange describes a major change of the machine's network.
+type LinkChange struct {
+	// Kind is how the network changed: "renumbered" (new addresses on
+	// the same LAN, such as a new DHCP lease), "lan-switched" (the same
+	// interface on another LAN), "interface-swapped" (the default route
+	// moved to another interface), "time-jumped" (likely a wake from
+	// sleep), or "other".
+	Kind string
+
+	// DefaultInterface is the name of the interface with the default
+	// route, if known.
+	DefaultInterface string `json:",omitempty"`
+
+	// Metered is whether the default route interface is metered, such as
+	// a cellular link. GUIs may want to pause bulk transfers like Taildrop
+	// on metered links.
+	Metered bool `json:",omitempty"`
+}
+
 // PartialFile represents an in-progress file transfer.
 type PartialFile struct {
 	Name         string    // e.g. "foo.jpg"
@@ -0,0 +1,46 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipn
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// ParseDeclaredPrefs parses the contents of a tailscaled --config file, a
+// JSON object of Prefs fields such as {"RouteAll": true}. The fields in
+// the object are declared, and set in the returned MaskedPrefs; the others
+// are left for "tailscale up" and "tailscale set" to manage.
+func ParseDeclaredPrefs(b []byte) (*MaskedPrefs, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, err
+	}
+	mp := new(MaskedPrefs)
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&mp.Prefs); err != nil {
+		return nil, err
+	}
+	mv := reflect.ValueOf(mp).Elem()
+	for name := range fields {
+		set := mv.FieldByName(name + "Set")
+		if name == "Egg" || set.Kind() != reflect.Bool {
+			return nil, fmt.Errorf("pref %q can't be declared", name)
+		}
+		set.SetBool(true)
+	}
+	return mp, nil
+}
+
+// Drift returns the fields set in m whose values in p differ, with Old
+// from p and New from m.
+func (m *MaskedPrefs) Drift(p PrefsView) []PrefChange {
+	cur := p.AsStruct()
+	want := p.AsStruct()
+	want.ApplyEdits(m)
+	return cur.Changes(want)
+}
@@ -7,6 +7,7 @@
 
 import (
 	"net/netip"
+	"time"
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/persist"
@@ -21,6 +22,9 @@ func (src *Prefs) Clone() *Prefs {
 	}
 	dst := new(Prefs)
 	*dst = *src
+	dst.RejectRoutes = append(src.RejectRoutes[:0:0], src.RejectRoutes...)
+	dst.KeepAlivePeers = append(src.KeepAlivePeers[:0:0], src.KeepAlivePeers...)
+	dst.ExitNodeAllowLANCIDRs = append(src.ExitNodeAllowLANCIDRs[:0:0], src.ExitNodeAllowLANCIDRs...)
 	dst.AdvertiseTags = append(src.AdvertiseTags[:0:0], src.AdvertiseTags...)
 	dst.AdvertiseRoutes = append(src.AdvertiseRoutes[:0:0], src.AdvertiseRoutes...)
 	dst.Persist = src.Persist.Clone()
@@ -30,13 +34,21 @@ func (src *Prefs) Clone() *Prefs {
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsCloneNeedsRegeneration = Prefs(struct {
 	ControlURL             string
+	ProxyURL               string
 	RouteAll               bool
+	RejectRoutes           []netip.Prefix
+	KeepAlivePeers         []string
 	AllowSingleHosts       bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
 	ExitNodeAllowLANAccess bool
+	ExitNodeAllowLANCIDRs  []netip.Prefix
+	KillSwitch             bool
 	CorpDNS                bool
 	RunSSH                 bool
+	RemoteAdmin            string
+	PowerSave              bool
+	RecordUsage            bool
 	WantRunning            bool
 	LoggedOut              bool
 	ShieldsUp              bool
@@ -47,7 +59,10 @@ func (src *Prefs) Clone() *Prefs {
 	Egg                    bool
 	AdvertiseRoutes        []netip.Prefix
 	NoSNAT                 bool
+	ExitRateLimit          uint64
+	Maintenance            bool
 	NetfilterMode          preftype.NetfilterMode
+	RouteMetric            uint32
 	OperatorUser           string
 	ProfileName            string
 	Persist                *persist.Persist
@@ -67,6 +82,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 			dst.TCP[k] = v.Clone()
 		}
 	}
+	dst.TCPRanges = append(src.TCPRanges[:0:0], src.TCPRanges...)
 	if dst.Web != nil {
 		dst.Web = map[HostPort]*WebServerConfig{}
 		for k, v := range src.Web {
@@ -85,6 +101,7 @@ func (src *ServeConfig) Clone() *ServeConfig {
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigCloneNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	TCPRanges   []TCPPortRange
 	Web         map[HostPort]*WebServerConfig
 	AllowFunnel map[HostPort]bool
 }{})
@@ -115,14 +132,19 @@ func (src *HTTPHandler) Clone() *HTTPHandler {
 	}
 	dst := new(HTTPHandler)
 	*dst = *src
+	dst.AllowFrom = append(src.AllowFrom[:0:0], src.AllowFrom...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerCloneNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path         string
+	Proxy        string
+	Text         string
+	AllowFrom    []string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
 }{})
 
 // Clone makes a deep copy of WebServerConfig.
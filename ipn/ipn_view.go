@@ -9,6 +9,7 @@
 	"encoding/json"
 	"errors"
 	"net/netip"
+	"time"
 
 	"tailscale.com/tailcfg"
 	"tailscale.com/types/persist"
@@ -63,14 +64,26 @@ func (v *PrefsView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v PrefsView) ControlURL() string                 { return v.ж.ControlURL }
-func (v PrefsView) RouteAll() bool                     { return v.ж.RouteAll }
-func (v PrefsView) AllowSingleHosts() bool             { return v.ж.AllowSingleHosts }
-func (v PrefsView) ExitNodeID() tailcfg.StableNodeID   { return v.ж.ExitNodeID }
-func (v PrefsView) ExitNodeIP() netip.Addr             { return v.ж.ExitNodeIP }
-func (v PrefsView) ExitNodeAllowLANAccess() bool       { return v.ж.ExitNodeAllowLANAccess }
+func (v PrefsView) ControlURL() string { return v.ж.ControlURL }
+func (v PrefsView) ProxyURL() string   { return v.ж.ProxyURL }
+func (v PrefsView) RouteAll() bool     { return v.ж.RouteAll }
+func (v PrefsView) RejectRoutes() views.IPPrefixSlice {
+	return views.IPPrefixSliceOf(v.ж.RejectRoutes)
+}
+func (v PrefsView) KeepAlivePeers() views.Slice[string] { return views.SliceOf(v.ж.KeepAlivePeers) }
+func (v PrefsView) AllowSingleHosts() bool              { return v.ж.AllowSingleHosts }
+func (v PrefsView) ExitNodeID() tailcfg.StableNodeID    { return v.ж.ExitNodeID }
+func (v PrefsView) ExitNodeIP() netip.Addr              { return v.ж.ExitNodeIP }
+func (v PrefsView) ExitNodeAllowLANAccess() bool        { return v.ж.ExitNodeAllowLANAccess }
+func (v PrefsView) ExitNodeAllowLANCIDRs() views.IPPrefixSlice {
+	return views.IPPrefixSliceOf(v.ж.ExitNodeAllowLANCIDRs)
+}
+func (v PrefsView) KillSwitch() bool                   { return v.ж.KillSwitch }
 func (v PrefsView) CorpDNS() bool                      { return v.ж.CorpDNS }
 func (v PrefsView) RunSSH() bool                       { return v.ж.RunSSH }
+func (v PrefsView) RemoteAdmin() string                { return v.ж.RemoteAdmin }
+func (v PrefsView) PowerSave() bool                    { return v.ж.PowerSave }
+func (v PrefsView) RecordUsage() bool                  { return v.ж.RecordUsage }
 func (v PrefsView) WantRunning() bool                  { return v.ж.WantRunning }
 func (v PrefsView) LoggedOut() bool                    { return v.ж.LoggedOut }
 func (v PrefsView) ShieldsUp() bool                    { return v.ж.ShieldsUp }
@@ -83,7 +96,10 @@ func (v PrefsView) AdvertiseRoutes() views.IPPrefixSlice {
 	return views.IPPrefixSliceOf(v.ж.AdvertiseRoutes)
 }
 func (v PrefsView) NoSNAT() bool                          { return v.ж.NoSNAT }
+func (v PrefsView) ExitRateLimit() uint64                 { return v.ж.ExitRateLimit }
+func (v PrefsView) Maintenance() bool                     { return v.ж.Maintenance }
 func (v PrefsView) NetfilterMode() preftype.NetfilterMode { return v.ж.NetfilterMode }
+func (v PrefsView) RouteMetric() uint32                   { return v.ж.RouteMetric }
 func (v PrefsView) OperatorUser() string                  { return v.ж.OperatorUser }
 func (v PrefsView) ProfileName() string                   { return v.ж.ProfileName }
 func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.View() }
@@ -91,13 +107,21 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _PrefsViewNeedsRegeneration = Prefs(struct {
 	ControlURL             string
+	ProxyURL               string
 	RouteAll               bool
+	RejectRoutes           []netip.Prefix
+	KeepAlivePeers         []string
 	AllowSingleHosts       bool
 	ExitNodeID             tailcfg.StableNodeID
 	ExitNodeIP             netip.Addr
 	ExitNodeAllowLANAccess bool
+	ExitNodeAllowLANCIDRs  []netip.Prefix
+	KillSwitch             bool
 	CorpDNS                bool
 	RunSSH                 bool
+	RemoteAdmin            string
+	PowerSave              bool
+	RecordUsage            bool
 	WantRunning            bool
 	LoggedOut              bool
 	ShieldsUp              bool
@@ -108,7 +132,10 @@ func (v PrefsView) Persist() persist.PersistView          { return v.ж.Persist.
 	Egg                    bool
 	AdvertiseRoutes        []netip.Prefix
 	NoSNAT                 bool
+	ExitRateLimit          uint64
+	Maintenance            bool
 	NetfilterMode          preftype.NetfilterMode
+	RouteMetric            uint32
 	OperatorUser           string
 	ProfileName            string
 	Persist                *persist.Persist
@@ -165,6 +192,8 @@ func (v ServeConfigView) TCP() views.MapFn[uint16, *TCPPortHandler, TCPPortHandl
 	})
 }
 
+func (v ServeConfigView) TCPRanges() views.Slice[TCPPortRange] { return views.SliceOf(v.ж.TCPRanges) }
+
 func (v ServeConfigView) Web() views.MapFn[HostPort, *WebServerConfig, WebServerConfigView] {
 	return views.MapFnOf(v.ж.Web, func(t *WebServerConfig) WebServerConfigView {
 		return t.View()
@@ -178,6 +207,7 @@ func (v ServeConfigView) AllowFunnel() views.Map[HostPort, bool] {
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _ServeConfigViewNeedsRegeneration = ServeConfig(struct {
 	TCP         map[uint16]*TCPPortHandler
+	TCPRanges   []TCPPortRange
 	Web         map[HostPort]*WebServerConfig
 	AllowFunnel map[HostPort]bool
 }{})
@@ -283,15 +313,23 @@ func (v *HTTPHandlerView) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
-func (v HTTPHandlerView) Path() string  { return v.ж.Path }
-func (v HTTPHandlerView) Proxy() string { return v.ж.Proxy }
-func (v HTTPHandlerView) Text() string  { return v.ж.Text }
+func (v HTTPHandlerView) Path() string                   { return v.ж.Path }
+func (v HTTPHandlerView) Proxy() string                  { return v.ж.Proxy }
+func (v HTTPHandlerView) Text() string                   { return v.ж.Text }
+func (v HTTPHandlerView) AllowFrom() views.Slice[string] { return views.SliceOf(v.ж.AllowFrom) }
+func (v HTTPHandlerView) ReadTimeout() time.Duration     { return v.ж.ReadTimeout }
+func (v HTTPHandlerView) WriteTimeout() time.Duration    { return v.ж.WriteTimeout }
+func (v HTTPHandlerView) IdleTimeout() time.Duration     { return v.ж.IdleTimeout }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HTTPHandlerViewNeedsRegeneration = HTTPHandler(struct {
-	Path  string
-	Proxy string
-	Text  string
+	Path         string
+	Proxy        string
+	Text         string
+	AllowFrom    []string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
 }{})
 
 // View returns a readonly view of WebServerConfig.
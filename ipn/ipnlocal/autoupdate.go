@@ -0,0 +1,397 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/winutil"
+	"tailscale.com/version"
+)
+
+var (
+	// autoUpdateEnabled is whether tailscaled installs the updates that
+	// control says are available with "tailscale update". On Windows, it
+	// can also be enabled with the "InstallUpdates" system policy set to
+	// "always".
+	autoUpdateEnabled = envknob.RegisterBool("TS_AUTO_UPDATE")
+
+	// autoUpdateWindowKnob is the daily maintenance window, in local
+	// time, in which to install updates, such as "02:00-04:30". It may
+	// wrap around midnight. Empty means at any time. On Windows, it can
+	// also be set with the "AutoUpdateWindow" system policy.
+	autoUpdateWindowKnob = envknob.RegisterString("TS_AUTO_UPDATE_WINDOW")
+)
+
+const (
+	// autoUpdateStateKey is the StateStore key of the autoUpdateState.
+	autoUpdateStateKey = ipn.StateKey("_auto-update")
+
+	// autoUpdateHealthGrace is how long an updated tailscaled has to
+	// reach the Running state before the update is rolled back.
+	autoUpdateHealthGrace = 10 * time.Minute
+
+	// autoUpdateStaleAfter is how long after an update is started that
+	// it's given up on, if tailscaled still runs the old version.
+	autoUpdateStaleAfter = time.Hour
+
+	// maxFailedAutoUpdates is how many of the versions that were rolled
+	// back are remembered.
+	maxFailedAutoUpdates = 10
+)
+
+// autoUpdateState is the state of automatic updates, persisted across the
+// restart of tailscaled that an update causes.
+type autoUpdateState struct {
+	// From and To are the versions of the update in progress, if To is
+	// non-empty.
+	From, To string
+	// Started is when the update to To was started.
+	Started time.Time
+	// RollingBack is whether the update in progress is the rollback of
+	// From after To failed.
+	RollingBack bool `json:",omitempty"`
+	// Failed are the versions that were rolled back, which aren't
+	// installed again.
+	Failed []string `json:",omitempty"`
+}
+
+// maintenanceWindow is a daily window of local time, as offsets from
+// midnight. If end is before start, it wraps around midnight.
+type maintenanceWindow struct {
+	start, end time.Duration
+}
+
+// parseMaintenanceWindow parses a maintenance window such as
+// "02:00-04:30".
+func parseMaintenanceWindow(s string) (maintenanceWindow, error) {
+	a, b, ok := strings.Cut(s, "-")
+	if !ok {
+		return maintenanceWindow{}, fmt.Errorf("invalid maintenance window %q; want HH:MM-HH:MM", s)
+	}
+	var w maintenanceWindow
+	for _, f := range []struct {
+		s string
+		d *time.Duration
+	}{{a, &w.start}, {b, &w.end}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(f.s))
+		if err != nil {
+			return maintenanceWindow{}, fmt.Errorf("invalid maintenance window %q; want HH:MM-HH:MM", s)
+		}
+		*f.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	if w.start == w.end {
+		return maintenanceWindow{}, fmt.Errorf("invalid maintenance window %q; it's empty", s)
+	}
+	return w, nil
+}
+
+// untilOpen returns how long until the window w is open, as of now, or
+// zero if it's open.
+func (w maintenanceWindow) untilOpen(now time.Time) time.Duration {
+	y, m, d := now.Date()
+	sinceMidnight := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	open := sinceMidnight >= w.start && sinceMidnight < w.end
+	if w.end < w.start {
+		open = sinceMidnight >= w.start || sinceMidnight < w.end
+	}
+	if open {
+		return 0
+	}
+	if sinceMidnight < w.start {
+		return w.start - sinceMidnight
+	}
+	return 24*time.Hour - sinceMidnight + w.start
+}
+
+// inAutoUpdateRollout reports whether the node nodeID is among the pct
+// percent of nodes that get the update to ver. The nodes are bucketed
+// per version, so that it's not always the same ones that go first.
+func inAutoUpdateRollout(nodeID tailcfg.StableNodeID, ver string, pct int) bool {
+	h := fnv.New32a()
+	h.Write([]byte(nodeID))
+	h.Write([]byte{0})
+	h.Write([]byte(ver))
+	return int(h.Sum32()%100) < pct
+}
+
+// autoUpdateRolloutPercent returns the percentage of nodes that get
+// updates, from the CapabilityAutoUpdateRollout capability in caps (as
+// in "https://tailscale.com/cap/auto-update-rollout?pct=25"), or 100 if
+// there's none.
+func autoUpdateRolloutPercent(caps []string) int {
+	for _, c := range caps {
+		name, query, _ := strings.Cut(c, "?")
+		if name != tailcfg.CapabilityAutoUpdateRollout {
+			continue
+		}
+		q, err := url.ParseQuery(query)
+		if err != nil {
+			return 0
+		}
+		pct, err := strconv.Atoi(q.Get("pct"))
+		if err != nil || pct < 0 {
+			return 0
+		}
+		if pct > 100 {
+			return 100
+		}
+		return pct
+	}
+	return 100
+}
+
+func autoUpdatesEnabled() bool {
+	return autoUpdateEnabled() || winutil.GetPolicyString("InstallUpdates", "") == "always"
+}
+
+func autoUpdateWindow() (w maintenanceWindow, ok bool, err error) {
+	s := autoUpdateWindowKnob()
+	if s == "" {
+		s = winutil.GetPolicyString("AutoUpdateWindow", "")
+	}
+	if s == "" {
+		return maintenanceWindow{}, false, nil
+	}
+	w, err = parseMaintenanceWindow(s)
+	return w, err == nil, err
+}
+
+func (b *LocalBackend) readAutoUpdateState() (autoUpdateState, error) {
+	var st autoUpdateState
+	j, err := b.pm.Store().ReadState(autoUpdateStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	return st, json.Unmarshal(j, &st)
+}
+
+func (b *LocalBackend) writeAutoUpdateState(st autoUpdateState) error {
+	j, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return b.pm.Store().WriteState(autoUpdateStateKey, j)
+}
+
+// maybeScheduleAutoUpdateLocked schedules the update to v.LatestVersion,
+// in the next maintenance window, if automatic updates are enabled and
+// this node is in its rollout.
+//
+// b.mu must be held.
+func (b *LocalBackend) maybeScheduleAutoUpdateLocked(v *tailcfg.ClientVersion) {
+	if !autoUpdatesEnabled() || v.RunningLatest || v.LatestVersion == "" {
+		return
+	}
+	ver := v.LatestVersion
+	if b.autoUpdateTimer != nil {
+		if b.autoUpdateTo == ver {
+			return
+		}
+		b.autoUpdateTimer.Stop()
+		b.autoUpdateTimer = nil
+	}
+	st, err := b.readAutoUpdateState()
+	if err != nil {
+		b.logf("autoupdate: reading state: %v", err)
+		return
+	}
+	if slices.Contains(st.Failed, ver) {
+		return
+	}
+	if st.To != "" && time.Since(st.Started) < autoUpdateStaleAfter {
+		// Already in progress.
+		return
+	}
+	var nodeID tailcfg.StableNodeID
+	var caps []string
+	if b.netMap != nil && b.netMap.SelfNode != nil {
+		nodeID = b.netMap.SelfNode.StableID
+		caps = b.netMap.SelfNode.Capabilities
+	}
+	if pct := autoUpdateRolloutPercent(caps); !inAutoUpdateRollout(nodeID, ver, pct) {
+		b.logf("autoupdate: %v is available, but not yet rolled out to this node (%d%%)", ver, pct)
+		return
+	}
+	var wait time.Duration
+	w, ok, err := autoUpdateWindow()
+	if err != nil {
+		b.logf("autoupdate: %v; not updating", err)
+		return
+	}
+	if ok {
+		wait = w.untilOpen(time.Now())
+	}
+	b.logf("autoupdate: updating to %v in %v", ver, wait.Round(time.Minute))
+	b.autoUpdateTo = ver
+	b.autoUpdateTimer = time.AfterFunc(wait, func() { b.startAutoUpdate(ver) })
+}
+
+// startAutoUpdate runs "tailscale update" to install ver.
+func (b *LocalBackend) startAutoUpdate(ver string) {
+	b.mu.Lock()
+	if b.shutdownCalled || b.autoUpdateTo != ver {
+		b.mu.Unlock()
+		return
+	}
+	// The timer can fire late, such as after the machine slept through
+	// the maintenance window; wait for the next one then.
+	if w, ok, _ := autoUpdateWindow(); ok {
+		if wait := w.untilOpen(time.Now()); wait > 0 {
+			b.autoUpdateTimer = time.AfterFunc(wait, func() { b.startAutoUpdate(ver) })
+			b.mu.Unlock()
+			return
+		}
+	}
+	b.autoUpdateTimer = nil
+	b.autoUpdateTo = ""
+	b.mu.Unlock()
+
+	st, err := b.readAutoUpdateState()
+	if err != nil {
+		b.logf("autoupdate: reading state: %v", err)
+		return
+	}
+	st.From, st.To, st.Started, st.RollingBack = version.Short(), ver, time.Now(), false
+	if err := b.runUpdate(st); err != nil {
+		b.logf("autoupdate: updating to %v: %v", ver, err)
+	}
+}
+
+// runUpdate records st and runs "tailscale update" to install st.To,
+// which restarts tailscaled if it succeeds.
+func (b *LocalBackend) runUpdate(st autoUpdateState) error {
+	if err := b.writeAutoUpdateState(st); err != nil {
+		return err
+	}
+	cmd, err := updateCommand(st.To)
+	if err != nil {
+		return err
+	}
+	b.logf("autoupdate: running %q", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w; output: %s", err, out)
+	}
+	return nil
+}
+
+// updateCommand returns the command that installs ver.
+func updateCommand(ver string) (*exec.Cmd, error) {
+	exe, err := tailscaleCLIPath()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{exe, "update", "--yes", "--version=" + ver}
+	if runtime.GOOS == "linux" {
+		// The package manager restarts tailscaled, which would kill
+		// the update in tailscaled's cgroup, so run it in its own.
+		if systemdRun, err := exec.LookPath("systemd-run"); err == nil {
+			args = append([]string{systemdRun, "--scope", "--collect", "--quiet"}, args...)
+		}
+	}
+	return exec.Command(args[0], args[1:]...), nil
+}
+
+// tailscaleCLIPath returns the path of the tailscale CLI, which is
+// usually next to tailscaled, or else in $PATH.
+func tailscaleCLIPath() (string, error) {
+	name := "tailscale"
+	if runtime.GOOS == "windows" {
+		name = "tailscale.exe"
+	}
+	if exe, err := os.Executable(); err == nil {
+		p := filepath.Join(filepath.Dir(exe), name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+	return exec.LookPath(name)
+}
+
+// checkAutoUpdated checks whether tailscaled was just updated by an
+// automatic update, and if so, rolls the update back unless tailscaled
+// reaches the Running state within autoUpdateHealthGrace.
+func (b *LocalBackend) checkAutoUpdated() {
+	st, err := b.readAutoUpdateState()
+	if err != nil {
+		b.logf("autoupdate: reading state: %v", err)
+		return
+	}
+	if st.To == "" {
+		return
+	}
+	cur := version.Short()
+	switch {
+	case cur == st.To && st.RollingBack:
+		b.logf("autoupdate: rolled back to %v", cur)
+	case cur == st.To:
+		if !b.waitRunning(autoUpdateHealthGrace) {
+			b.logf("autoupdate: %v didn't start running within %v; rolling back to %v", cur, autoUpdateHealthGrace, st.From)
+			st.Failed = append(st.Failed, cur)
+			if len(st.Failed) > maxFailedAutoUpdates {
+				st.Failed = st.Failed[len(st.Failed)-maxFailedAutoUpdates:]
+			}
+			st.From, st.To, st.Started, st.RollingBack = cur, st.From, time.Now(), true
+			if err := b.runUpdate(st); err != nil {
+				b.logf("autoupdate: rolling back: %v", err)
+			}
+			return
+		}
+		b.logf("autoupdate: updated from %v to %v", st.From, cur)
+	case time.Since(st.Started) < autoUpdateStaleAfter:
+		// Not installed yet, or the update restarted tailscaled before
+		// installing it.
+		return
+	default:
+		b.logf("autoupdate: update from %v to %v didn't happen", st.From, st.To)
+	}
+	st.From, st.To, st.RollingBack = "", "", false
+	if err := b.writeAutoUpdateState(st); err != nil {
+		b.logf("autoupdate: writing state: %v", err)
+	}
+}
+
+// waitRunning reports whether b reaches the Running state within timeout,
+// or is stopped on purpose, in which case the version can't be blamed.
+func (b *LocalBackend) waitRunning(timeout time.Duration) bool {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	tick := time.NewTicker(5 * time.Second)
+	defer tick.Stop()
+	for {
+		b.mu.Lock()
+		state, wantRunning := b.state, b.pm.CurrentPrefs().WantRunning()
+		b.mu.Unlock()
+		if state == ipn.Running || !wantRunning {
+			return true
+		}
+		select {
+		case <-b.ctx.Done():
+			// Shutting down isn't a failure of the new version.
+			return true
+		case <-t.C:
+			return false
+		case <-tick.C:
+		}
+	}
+}
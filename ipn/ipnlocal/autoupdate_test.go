@@ -0,0 +1,128 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/version"
+	"tailscale.com/wgengine"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	day := time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(h, m int) time.Time { return day.Add(time.Duration(h)*time.Hour + time.Duration(m)*time.Minute) }
+	tests := []struct {
+		window string
+		now    time.Time
+		want   time.Duration
+	}{
+		{"02:00-04:30", at(3, 0), 0},
+		{"02:00-04:30", at(2, 0), 0},
+		{"02:00-04:30", at(1, 30), 30 * time.Minute},
+		{"02:00-04:30", at(4, 30), 21*time.Hour + 30*time.Minute},
+		{"22:00-03:00", at(23, 0), 0},
+		{"22:00-03:00", at(1, 0), 0},
+		{"22:00-03:00", at(12, 0), 10 * time.Hour},
+		{" 22:00 - 03:00 ", at(3, 0), 19 * time.Hour},
+	}
+	for _, tt := range tests {
+		w, err := parseMaintenanceWindow(tt.window)
+		if err != nil {
+			t.Fatalf("parseMaintenanceWindow(%q): %v", tt.window, err)
+		}
+		if got := w.untilOpen(tt.now); got != tt.want {
+			t.Errorf("%q.untilOpen(%v) = %v; want %v", tt.window, tt.now.Format("15:04"), got, tt.want)
+		}
+	}
+	for _, bad := range []string{"", "02:00", "2am-4am", "25:00-26:00", "02:00-02:00"} {
+		if _, err := parseMaintenanceWindow(bad); err == nil {
+			t.Errorf("parseMaintenanceWindow(%q) succeeded; want error", bad)
+		}
+	}
+}
+
+func TestAutoUpdateRollout(t *testing.T) {
+	tests := []struct {
+		caps []string
+		want int
+	}{
+		{nil, 100},
+		{[]string{tailcfg.CapabilityFileSharing}, 100},
+		{[]string{tailcfg.CapabilityAutoUpdateRollout + "?pct=25"}, 25},
+		{[]string{tailcfg.CapabilityAutoUpdateRollout + "?pct=0"}, 0},
+		{[]string{tailcfg.CapabilityAutoUpdateRollout + "?pct=250"}, 100},
+		{[]string{tailcfg.CapabilityAutoUpdateRollout}, 0},
+		{[]string{tailcfg.CapabilityAutoUpdateRollout + "?pct=x"}, 0},
+	}
+	for _, tt := range tests {
+		if got := autoUpdateRolloutPercent(tt.caps); got != tt.want {
+			t.Errorf("autoUpdateRolloutPercent(%q) = %v; want %v", tt.caps, got, tt.want)
+		}
+	}
+
+	const n = 1000
+	in := 0
+	for i := 0; i < n; i++ {
+		id := tailcfg.StableNodeID(fmt.Sprintf("n%dCNTRL", i))
+		if inAutoUpdateRollout(id, "1.38.0", 25) {
+			in++
+		}
+		if inAutoUpdateRollout(id, "1.38.0", 0) {
+			t.Fatalf("%v is in a 0%% rollout", id)
+		}
+		if !inAutoUpdateRollout(id, "1.38.0", 100) {
+			t.Fatalf("%v isn't in a 100%% rollout", id)
+		}
+	}
+	if in < n/5 || in > n*3/10 {
+		t.Errorf("%d of %d nodes in a 25%% rollout", in, n)
+	}
+}
+
+func TestCheckAutoUpdated(t *testing.T) {
+	store := new(mem.Store)
+	eng, err := wgengine.NewFakeUserspaceEngine(logger.Discard, 0)
+	if err != nil {
+		t.Fatalf("NewFakeUserspaceEngine: %v", err)
+	}
+	t.Cleanup(eng.Close)
+	b, err := NewLocalBackend(t.Logf, "logid", store, nil, eng, 0)
+	if err != nil {
+		t.Fatalf("NewLocalBackend: %v", err)
+	}
+	t.Cleanup(b.Shutdown)
+
+	// An update that never happened is given up on.
+	stale := autoUpdateState{From: version.Short(), To: "999.0.0", Started: time.Now().Add(-2 * autoUpdateStaleAfter), Failed: []string{"1.0.0"}}
+	if err := b.writeAutoUpdateState(stale); err != nil {
+		t.Fatal(err)
+	}
+	b.checkAutoUpdated()
+	st, err := b.readAutoUpdateState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.To != "" || len(st.Failed) != 1 {
+		t.Errorf("state after stale update = %+v; want no update in progress, and the failed version kept", st)
+	}
+
+	// A rollback that did happen is done.
+	rolledBack := autoUpdateState{From: "999.0.0", To: version.Short(), Started: time.Now(), RollingBack: true, Failed: []string{"999.0.0"}}
+	if err := b.writeAutoUpdateState(rolledBack); err != nil {
+		t.Fatal(err)
+	}
+	b.checkAutoUpdated()
+	if st, err = b.readAutoUpdateState(); err != nil {
+		t.Fatal(err)
+	}
+	if st.To != "" || st.RollingBack || len(st.Failed) != 1 {
+		t.Errorf("state after rollback = %+v; want no update in progress, and the failed version kept", st)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/mak"
+)
+
+// CapabilityHandler is called when the control server grants (present is
+// true) or revokes a capability of the self node. It's called with the
+// LocalBackend's mutex held, so it must not block or call back into the
+// LocalBackend.
+type CapabilityHandler func(logf logger.Logf, present bool)
+
+var capabilityHandlers map[string]CapabilityHandler
+
+// RegisterCapabilityHandler registers h to be called as the capability
+// cap is granted or revoked, for capabilities that tailscaled doesn't
+// handle itself, such as those of custom control servers. It must be
+// called before the LocalBackend is created, typically from an init func.
+// It panics if a handler for cap is already registered.
+func RegisterCapabilityHandler(cap string, h CapabilityHandler) {
+	if cap == "" || h == nil {
+		panic("invalid capability handler")
+	}
+	if _, ok := capabilityHandlers[cap]; ok {
+		panic(fmt.Sprintf("capability handler for %q already registered", cap))
+	}
+	mak.Set(&capabilityHandlers, cap, h)
+}
+
+// builtinNodeAttrs are the capabilities handled by tailscaled that aren't
+// under the "https://tailscale.com/cap/" namespace.
+var builtinNodeAttrs = []string{
+	tailcfg.NodeAttrFunnel,
+	tailcfg.NodeAttrSSHAggregator,
+}
+
+// isKnownCapability reports whether cap is one of Tailscale's own
+// capabilities, or has a registered CapabilityHandler.
+func isKnownCapability(cap string) bool {
+	// The query (as in "funnel-ports?ports=443") is a parameter, not part
+	// of the capability's name.
+	name, _, _ := strings.Cut(cap, "?")
+	if strings.HasPrefix(name, "https://tailscale.com/cap/") || slices.Contains(builtinNodeAttrs, name) {
+		return true
+	}
+	_, ok := capabilityHandlers[cap]
+	return ok
+}
+
+// runCapabilityHandlersLocked calls the registered capability handlers
+// whose capability was granted or revoked since the previous netmap, and
+// logs capabilities unknown to tailscaled the first time each is seen.
+// b.mu must be held.
+func (b *LocalBackend) runCapabilityHandlersLocked(nm *netmap.NetworkMap) {
+	var caps []string
+	if nm != nil && nm.SelfNode != nil {
+		caps = nm.SelfNode.Capabilities
+	}
+	for cap, h := range capabilityHandlers {
+		present := slices.Contains(caps, cap)
+		if present != b.capsHandled[cap] {
+			h(b.logf, present)
+			mak.Set(&b.capsHandled, cap, present)
+		}
+	}
+	for _, cap := range caps {
+		if !isKnownCapability(cap) && !b.capsUnknownLogged[cap] {
+			b.logf("ignoring unknown node capability %q", cap)
+			mak.Set(&b.capsUnknownLogged, cap, true)
+		}
+	}
+}
+
+// Capabilities returns the capabilities that the control server granted
+// the self node, and which of them are unknown.
+func (b *LocalBackend) Capabilities() *apitype.CapabilitiesResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := &apitype.CapabilitiesResponse{}
+	if nm := b.netMap; nm != nil && nm.SelfNode != nil {
+		res.Capabilities = append([]string(nil), nm.SelfNode.Capabilities...)
+	}
+	slices.Sort(res.Capabilities)
+	for _, cap := range res.Capabilities {
+		if !isKnownCapability(cap) {
+			res.Unknown = append(res.Unknown, cap)
+		}
+	}
+	return res
+}
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+)
+
+func TestCapabilityHandlers(t *testing.T) {
+	defer func(old map[string]CapabilityHandler) { capabilityHandlers = old }(capabilityHandlers)
+	capabilityHandlers = nil
+
+	const custom = "https://example.com/cap/custom"
+	var calls []bool
+	RegisterCapabilityHandler(custom, func(logf logger.Logf, present bool) {
+		calls = append(calls, present)
+	})
+
+	nmWith := func(caps ...string) *netmap.NetworkMap {
+		return &netmap.NetworkMap{SelfNode: &tailcfg.Node{Capabilities: caps}}
+	}
+	b := &LocalBackend{logf: t.Logf}
+	b.runCapabilityHandlersLocked(nmWith(tailcfg.CapabilityFileSharing))
+	b.runCapabilityHandlersLocked(nmWith(custom, "headscale-feature"))
+	b.runCapabilityHandlersLocked(nmWith(custom, "headscale-feature"))
+	b.runCapabilityHandlersLocked(nil)
+	if want := []bool{true, false}; len(calls) != len(want) || calls[0] != want[0] || calls[1] != want[1] {
+		t.Errorf("handler calls = %v; want %v", calls, want)
+	}
+	if !b.capsUnknownLogged["headscale-feature"] || len(b.capsUnknownLogged) != 1 {
+		t.Errorf("capsUnknownLogged = %v; want just headscale-feature", b.capsUnknownLogged)
+	}
+}
+
+func TestIsKnownCapability(t *testing.T) {
+	tests := []struct {
+		cap  string
+		want bool
+	}{
+		{tailcfg.CapabilitySSH, true},
+		{tailcfg.NodeAttrFunnel, true},
+		{"https://tailscale.com/cap/funnel-ports?ports=443", true},
+		{"https://tailscale.com/cap/some-future-feature", true},
+		{"https://example.com/cap/custom", false},
+		{"funnel-ish", false},
+	}
+	for _, tt := range tests {
+		if got := isKnownCapability(tt.cap); got != tt.want {
+			t.Errorf("isKnownCapability(%q) = %v; want %v", tt.cap, got, tt.want)
+		}
+	}
+}
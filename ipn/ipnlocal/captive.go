@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"net/netip"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/net/dns"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+var warnCaptivePortal = health.NewWarnable(health.WithCode("captive-portal"))
+
+var errCaptivePortal = errors.New("a captive portal was detected; internet traffic bypasses the exit node until you sign in to the network")
+
+// setCaptivePortal notes whether netcheck detected a captive portal on the
+// current network, notifying the IPN bus watchers and reconfiguring the
+// exit node (and kill switch) as needed if that changed.
+func (b *LocalBackend) setCaptivePortal(captive bool) {
+	if b.captivePortal.Swap(captive) == captive {
+		return
+	}
+	b.logf("captive portal detected: %v", captive)
+	b.send(ipn.Notify{CaptivePortal: &captive})
+
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs()
+	state := b.state
+	b.mu.Unlock()
+	if !usesExitNode(prefs) {
+		return
+	}
+	switch state {
+	case ipn.Running, ipn.Starting:
+		b.authReconfig()
+	case ipn.Stopped, ipn.NeedsLogin:
+		// The kill switch, if enabled, blocks the portal too.
+		if err := b.e.Reconfig(&wgcfg.Config{}, b.downRouterConfig(prefs), &dns.Config{}, nil); err != nil {
+			b.logf("Reconfig(down): %v", err)
+		}
+	}
+}
+
+// usesExitNode reports whether prefs (which may be !Valid) specify an exit
+// node.
+func usesExitNode(prefs ipn.PrefsView) bool {
+	return prefs.Valid() && (prefs.ExitNodeID() != "" || prefs.ExitNodeIP().IsValid())
+}
+
+// captivePortalPrefs returns prefs without its exit node if a captive portal
+// was detected, so that internet traffic, including to the portal's sign-in
+// page, bypasses the exit node and kill switch until the portal is passed.
+// Otherwise it returns prefs. It also updates the captive portal health
+// warning accordingly.
+func (b *LocalBackend) captivePortalPrefs(prefs ipn.PrefsView) ipn.PrefsView {
+	if !b.captivePortal.Load() || !usesExitNode(prefs) {
+		warnCaptivePortal.Set(nil)
+		return prefs
+	}
+	warnCaptivePortal.Set(errCaptivePortal)
+	p := prefs.AsStruct()
+	p.ExitNodeID = ""
+	p.ExitNodeIP = netip.Addr{}
+	return p.View()
+}
@@ -0,0 +1,36 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+)
+
+func TestCaptivePortalPrefs(t *testing.T) {
+	defer warnCaptivePortal.Set(nil)
+	exit := &ipn.Prefs{WantRunning: true, ExitNodeID: "exit", KillSwitch: true}
+	b := &LocalBackend{}
+
+	if got := b.captivePortalPrefs(exit.View()); got.ExitNodeID() != "exit" {
+		t.Errorf("without captive portal: ExitNodeID = %q; want exit", got.ExitNodeID())
+	}
+
+	b.captivePortal.Store(true)
+	got := b.captivePortalPrefs(exit.View())
+	if got.ExitNodeID() != "" || got.ExitNodeIP().IsValid() {
+		t.Errorf("with captive portal: exit node = %q, %v; want none", got.ExitNodeID(), got.ExitNodeIP())
+	}
+	if killSwitchEnabled(got) {
+		t.Error("with captive portal: kill switch enabled")
+	}
+	if exit.ExitNodeID != "exit" {
+		t.Error("prefs modified")
+	}
+
+	if got := b.captivePortalPrefs((&ipn.Prefs{WantRunning: true}).View()); !got.WantRunning() {
+		t.Error("prefs without exit node changed")
+	}
+}
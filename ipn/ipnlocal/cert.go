@@ -36,6 +36,7 @@
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 )
@@ -128,6 +129,46 @@ func (b *LocalBackend) shouldStartDomainRenewal(dir, domain string, future time.
 	return errors.Is(err, errCertExpired)
 }
 
+// certExpiryWarning is how far ahead of a cached cert's expiry the
+// "cert-expiry" health check starts failing. Renewal starts 14 days before
+// expiry, so a cert this close to expiring means renewal isn't working.
+const certExpiryWarning = 7 * 24 * time.Hour
+
+// certExpiryCheck is the "cert-expiry" health check. It fails if any cert
+// cached for the node's cert domains has expired or is about to expire.
+// Domains that no cert has been requested for are ignored.
+func (b *LocalBackend) certExpiryCheck() error {
+	b.mu.Lock()
+	var domains []string
+	if b.netMap != nil {
+		domains = b.netMap.DNS.CertDomains
+	}
+	b.mu.Unlock()
+	if len(domains) == 0 {
+		return nil
+	}
+	dir, err := b.certDir()
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	var errs []error
+	for _, domain := range domains {
+		_, err := b.getCertPEMCached(dir, domain, now)
+		if err == nil {
+			_, err = b.getCertPEMCached(dir, domain, now.Add(certExpiryWarning))
+			if errors.Is(err, errCertExpired) {
+				errs = append(errs, fmt.Errorf("cert for %q expires within %v", domain, certExpiryWarning))
+			}
+			continue
+		}
+		if errors.Is(err, errCertExpired) {
+			errs = append(errs, fmt.Errorf("cert for %q has expired", domain))
+		}
+	}
+	return multierr.New(errs...)
+}
+
 // certStore provides a way to perist and retrieve TLS certificates.
 // As of 2023-02-01, we use store certs in directories on disk everywhere
 // except on Kubernetes, where we use the state store.
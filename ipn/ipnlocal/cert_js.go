@@ -15,3 +15,7 @@ type TLSCertKeyPair struct {
 func (b *LocalBackend) GetCertPEM(ctx context.Context, domain string) (*TLSCertKeyPair, error) {
 	return nil, errors.New("not implemented for js/wasm")
 }
+
+func (b *LocalBackend) certExpiryCheck() error {
+	return nil
+}
@@ -0,0 +1,214 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/dnsname"
+	"tailscale.com/wgengine/filter"
+)
+
+// debugDialStageTimeout bounds each network stage of DebugDial.
+const debugDialStageTimeout = 5 * time.Second
+
+// DebugDial tries to connect to port on host, a peer's name or Tailscale
+// IP or an IP routed to a peer, and reports how each stage of doing so
+// went. Later stages are still tried after one fails, except when no
+// usable peer is found for host.
+func (b *LocalBackend) DebugDial(ctx context.Context, host string, port uint16) *ipnstate.DebugDialReport {
+	rep := new(ipnstate.DebugDialReport)
+	stage := func(name string, f func() (info string, err error)) bool {
+		t0 := time.Now()
+		info, err := f()
+		st := ipnstate.DebugDialStage{
+			Name:           name,
+			LatencySeconds: time.Since(t0).Seconds(),
+			Info:           info,
+		}
+		if err != nil {
+			st.Err = err.Error()
+		}
+		rep.Stages = append(rep.Stages, st)
+		return err == nil
+	}
+
+	var (
+		nm   = b.NetMap()
+		peer *tailcfg.Node
+		ip   netip.Addr
+	)
+	if !stage("netmap", func() (string, error) {
+		var err error
+		peer, ip, err = debugDialPeer(nm, host)
+		if err != nil {
+			return "", err
+		}
+		info := fmt.Sprintf("%v is %s", ip, peer.Name)
+		if !slices.ContainsFunc(peer.Addresses, func(p netip.Prefix) bool { return p.Addr() == ip }) {
+			info = fmt.Sprintf("%v is routed via %s", ip, peer.Name)
+		}
+		switch {
+		case peer.Expired:
+			return info, errors.New("peer's node key has expired")
+		case peer.Online != nil && !*peer.Online:
+			info += "; control says it's offline"
+		}
+		return info, nil
+	}) {
+		return rep
+	}
+
+	stage("filter", func() (string, error) {
+		// Outgoing traffic isn't filtered here; whether the peer accepts
+		// the connection is up to its own filter, which this node can't
+		// see. The reverse direction is the best hint available.
+		f := b.e.GetFilter()
+		self, ok := selfAddrForFamily(nm, ip.Is6())
+		if f == nil || !ok {
+			return "no local packet filter", nil
+		}
+		verdict := "denies"
+		if f.CheckTCP(peerAddrForFamily(peer, ip.Is6()), self, port) == filter.Accept {
+			verdict = "allows"
+		}
+		return fmt.Sprintf("this node's filter %s the peer to connect back to port %d; the peer's filter decides this dial", verdict, port), nil
+	})
+
+	var viaDERP bool
+	stage("disco", func() (string, error) {
+		pr, err := b.debugDialPing(ctx, ip, tailcfg.PingDisco)
+		if err != nil {
+			return "", err
+		}
+		if pr.Endpoint != "" {
+			return "direct path via " + pr.Endpoint, nil
+		}
+		viaDERP = true
+		return "no direct path; pong came via DERP", nil
+	})
+
+	stage("derp", func() (string, error) {
+		if peer.DERP == "" {
+			return "", errors.New("peer has no home DERP region")
+		}
+		_, regStr, _ := strings.Cut(peer.DERP, ":")
+		info := "peer's home DERP region is " + regStr
+		if dm := b.DERPMap(); dm != nil {
+			if id, err := strconv.Atoi(regStr); err == nil && dm.Regions[id] != nil {
+				info = fmt.Sprintf("peer's home DERP region is %d (%s)", id, dm.Regions[id].RegionCode)
+			}
+		}
+		if viaDERP {
+			info += "; relaying traffic"
+		}
+		return info, nil
+	})
+
+	stage("handshake", func() (string, error) {
+		// A TSMP ping travels inside WireGuard, so it needs a session.
+		if _, err := b.debugDialPing(ctx, ip, tailcfg.PingTSMP); err != nil {
+			return "", fmt.Errorf("no WireGuard session: %w", err)
+		}
+		return "WireGuard session is up", nil
+	})
+
+	stage("tcp", func() (string, error) {
+		ctx, cancel := context.WithTimeout(ctx, debugDialStageTimeout)
+		defer cancel()
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+		c, err := b.Dialer().UserDial(ctx, "tcp", addr)
+		if err != nil {
+			return "", err
+		}
+		c.Close()
+		return "connected to " + addr, nil
+	})
+	return rep
+}
+
+// debugDialPing pings ip, returning an error if no pong arrives in time.
+func (b *LocalBackend) debugDialPing(ctx context.Context, ip netip.Addr, pingType tailcfg.PingType) (*ipnstate.PingResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, debugDialStageTimeout)
+	defer cancel()
+	pr, err := b.Ping(ctx, ip, pingType)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("no %s pong in %v", pingType, debugDialStageTimeout)
+		}
+		return nil, err
+	}
+	if pr.Err != "" {
+		return nil, errors.New(pr.Err)
+	}
+	return pr, nil
+}
+
+// debugDialPeer returns the peer in nm that handles host, which is a
+// peer's name or an IP address, and the IP to dial.
+func debugDialPeer(nm *netmap.NetworkMap, host string) (peer *tailcfg.Node, ip netip.Addr, err error) {
+	if nm == nil {
+		return nil, ip, errors.New("no netmap; is Tailscale running?")
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if peer, ok := nm.PeerByTailscaleIP(ip); ok {
+			return peer, ip, nil
+		}
+		// Prefer the most specific route, as the OS would.
+		bits := -1
+		for _, p := range nm.Peers {
+			for _, pfx := range p.AllowedIPs {
+				if pfx.Contains(ip) && pfx.Bits() > bits {
+					peer, bits = p, pfx.Bits()
+				}
+			}
+		}
+		if peer == nil {
+			return nil, ip, fmt.Errorf("no peer has or routes %v", ip)
+		}
+		return peer, ip, nil
+	}
+	host = strings.TrimSuffix(host, ".")
+	for _, p := range nm.Peers {
+		if strings.TrimSuffix(p.Name, ".") == host || dnsname.FirstLabel(p.Name) == host || p.ComputedName == host {
+			if len(p.Addresses) == 0 {
+				return nil, ip, fmt.Errorf("peer %s has no Tailscale IPs", p.Name)
+			}
+			return p, p.Addresses[0].Addr(), nil
+		}
+	}
+	return nil, ip, fmt.Errorf("no peer named %q in netmap", host)
+}
+
+// selfAddrForFamily returns the node's Tailscale IP of the given family.
+func selfAddrForFamily(nm *netmap.NetworkMap, is6 bool) (netip.Addr, bool) {
+	for _, p := range nm.Addresses {
+		if p.Addr().Is6() == is6 {
+			return p.Addr(), true
+		}
+	}
+	return netip.Addr{}, false
+}
+
+// peerAddrForFamily returns the peer's Tailscale IP of the given family,
+// or the zero value if it has none.
+func peerAddrForFamily(peer *tailcfg.Node, is6 bool) netip.Addr {
+	for _, p := range peer.Addresses {
+		if p.Addr().Is6() == is6 {
+			return p.Addr()
+		}
+	}
+	return netip.Addr{}
+}
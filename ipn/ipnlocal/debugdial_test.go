@@ -0,0 +1,72 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestDebugDialPeer(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				ID:           1,
+				Name:         "foo.tail-scale.ts.net.",
+				ComputedName: "foo",
+				Addresses:    []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+				AllowedIPs: []netip.Prefix{
+					netip.MustParsePrefix("100.64.0.1/32"),
+					netip.MustParsePrefix("10.0.0.0/8"),
+				},
+			},
+			{
+				ID:           2,
+				Name:         "router.tail-scale.ts.net.",
+				ComputedName: "router",
+				Addresses:    []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+				AllowedIPs: []netip.Prefix{
+					netip.MustParsePrefix("100.64.0.2/32"),
+					netip.MustParsePrefix("10.1.0.0/16"),
+				},
+			},
+		},
+	}
+	tests := []struct {
+		host     string
+		wantPeer tailcfg.NodeID // or 0 for an error
+		wantIP   string
+	}{
+		{"foo", 1, "100.64.0.1"},
+		{"foo.tail-scale.ts.net", 1, "100.64.0.1"},
+		{"foo.tail-scale.ts.net.", 1, "100.64.0.1"},
+		{"100.64.0.2", 2, "100.64.0.2"},
+		{"10.2.3.4", 1, "10.2.3.4"},
+		{"10.1.2.3", 2, "10.1.2.3"}, // most specific route
+		{"192.168.0.1", 0, ""},
+		{"bar", 0, ""},
+	}
+	for _, tt := range tests {
+		peer, ip, err := debugDialPeer(nm, tt.host)
+		if tt.wantPeer == 0 {
+			if err == nil {
+				t.Errorf("debugDialPeer(%q) = %v, %v; want error", tt.host, peer.Name, ip)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("debugDialPeer(%q): %v", tt.host, err)
+			continue
+		}
+		if peer.ID != tt.wantPeer || ip.String() != tt.wantIP {
+			t.Errorf("debugDialPeer(%q) = peer %v, %v; want peer %v, %v", tt.host, peer.ID, ip, tt.wantPeer, tt.wantIP)
+		}
+	}
+	if _, _, err := debugDialPeer(nil, "foo"); err == nil {
+		t.Error("debugDialPeer with no netmap succeeded")
+	}
+}
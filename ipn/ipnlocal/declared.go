@@ -0,0 +1,93 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+)
+
+var warnConfigDrift = health.NewWarnable(health.WithCode("config-drift"))
+
+// configDriftInterval is how often the prefs are compared against the
+// declared config.
+const configDriftInterval = time.Minute
+
+// SetDeclaredPrefs sets the prefs declared in tailscaled's --config file
+// and applies them. From then on, until b shuts down, the current prefs
+// are compared against them every configDriftInterval, and any that were
+// changed, such as with "tailscale set", are reported as a health warning
+// and by ConfigDrift. If autoRevert, they're also changed back.
+//
+// It must be called at most once, before b is started.
+func (b *LocalBackend) SetDeclaredPrefs(mp *ipn.MaskedPrefs, autoRevert bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.declaredPrefs = mp
+	b.declaredAutoRevert = autoRevert
+	// Before Start, there's no hostinfo or control client for EditPrefs
+	// to update; Start picks the prefs up from the profile manager.
+	p := b.pm.CurrentPrefs().AsStruct()
+	p.ApplyEdits(mp)
+	if err := b.pm.SetPrefs(p.View()); err != nil {
+		return fmt.Errorf("applying declared prefs: %w", err)
+	}
+	go b.configDriftLoop()
+	return nil
+}
+
+// ConfigDrift returns the declared prefs (see SetDeclaredPrefs) whose
+// current values differ from the declared ones.
+func (b *LocalBackend) ConfigDrift() []ipn.PrefChange {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.declaredPrefs == nil {
+		return nil
+	}
+	return b.declaredPrefs.Drift(b.pm.CurrentPrefs())
+}
+
+func (b *LocalBackend) configDriftLoop() {
+	t := time.NewTicker(configDriftInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+			b.checkConfigDrift()
+		}
+	}
+}
+
+// checkConfigDrift updates the config drift health warning, and reverts
+// the drifted prefs if configured to.
+func (b *LocalBackend) checkConfigDrift() {
+	drift := b.ConfigDrift()
+	b.mu.Lock()
+	mp, autoRevert := b.declaredPrefs, b.declaredAutoRevert
+	b.mu.Unlock()
+	if len(drift) == 0 {
+		warnConfigDrift.Set(nil)
+		return
+	}
+	names := make([]string, len(drift))
+	for i, c := range drift {
+		names[i] = c.Field
+	}
+	if autoRevert {
+		b.logf("reverting prefs that drifted from the config file: %s", strings.Join(names, ", "))
+		_, err := b.EditPrefs(mp)
+		if err == nil {
+			warnConfigDrift.Set(nil)
+			return
+		}
+		b.logf("reverting prefs: %v", err)
+	}
+	warnConfigDrift.Set(fmt.Errorf("prefs differ from tailscaled's config file: %s", strings.Join(names, ", ")))
+}
@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine"
+)
+
+func TestConfigDrift(t *testing.T) {
+	for _, autoRevert := range []bool{false, true} {
+		eng, err := wgengine.NewFakeUserspaceEngine(logger.Discard, 0)
+		if err != nil {
+			t.Fatalf("NewFakeUserspaceEngine: %v", err)
+		}
+		t.Cleanup(eng.Close)
+		b, err := NewLocalBackend(t.Logf, "logid", new(mem.Store), nil, eng, 0)
+		if err != nil {
+			t.Fatalf("NewLocalBackend: %v", err)
+		}
+		t.Cleanup(b.Shutdown)
+		t.Cleanup(func() { warnConfigDrift.Set(nil) })
+
+		mp, err := ipn.ParseDeclaredPrefs([]byte(`{"RouteAll": true, "Hostname": "managed"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.SetDeclaredPrefs(mp, autoRevert); err != nil {
+			t.Fatal(err)
+		}
+		b.hostinfo = new(tailcfg.Hostinfo) // as set by Start
+
+		if p := b.Prefs(); !p.RouteAll() || p.Hostname() != "managed" {
+			t.Fatalf("declared prefs not applied: %v", p.Pretty())
+		}
+		if drift := b.ConfigDrift(); len(drift) != 0 {
+			t.Fatalf("ConfigDrift = %+v; want none", drift)
+		}
+
+		// Undeclared prefs can change without drift.
+		if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{ShieldsUp: true}, ShieldsUpSet: true}); err != nil {
+			t.Fatal(err)
+		}
+		if drift := b.ConfigDrift(); len(drift) != 0 {
+			t.Fatalf("ConfigDrift = %+v; want none", drift)
+		}
+
+		if _, err := b.EditPrefs(&ipn.MaskedPrefs{Prefs: ipn.Prefs{RouteAll: false}, RouteAllSet: true}); err != nil {
+			t.Fatal(err)
+		}
+		drift := b.ConfigDrift()
+		if len(drift) != 1 || drift[0].Field != "RouteAll" || drift[0].Old != false || drift[0].New != true {
+			t.Fatalf("ConfigDrift = %+v; want RouteAll from false to true", drift)
+		}
+		b.checkConfigDrift()
+		if autoRevert {
+			if !b.Prefs().RouteAll() {
+				t.Errorf("RouteAll not reverted")
+			}
+			if drift := b.ConfigDrift(); len(drift) != 0 {
+				t.Errorf("ConfigDrift after revert = %+v; want none", drift)
+			}
+		} else if b.Prefs().RouteAll() {
+			t.Errorf("RouteAll reverted without autoRevert")
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/util/dnsname"
+)
+
+// SetDNSServerDomains replaces the set of DNS domains this node announces
+// to control, in Hostinfo.DNSDomains, as served by a DNS server on port
+// 53 of its Tailscale IPs. The control server decides which of them, such
+// as subdomains of the tailnet's domain, to route to this node with split
+// DNS.
+func (b *LocalBackend) SetDNSServerDomains(domains []string) error {
+	var norm []string
+	for _, d := range domains {
+		fqdn, err := dnsname.ToFQDN(d)
+		if err != nil {
+			return fmt.Errorf("invalid DNS domain %q: %w", d, err)
+		}
+		if fqdn.NumLabels() < 2 {
+			return fmt.Errorf("invalid DNS domain %q: want a subdomain, like \"svc.example.ts.net\"", d)
+		}
+		norm = append(norm, strings.ToLower(fqdn.WithoutTrailingDot()))
+	}
+	slices.Sort(norm)
+	norm = slices.Compact(norm)
+
+	b.mu.Lock()
+	if slices.Equal(b.dnsServerDomains, norm) {
+		b.mu.Unlock()
+		return nil
+	}
+	b.dnsServerDomains = norm
+	hi := b.hostinfo
+	b.mu.Unlock()
+
+	if hi != nil {
+		b.logf("DNS server domains changed to %q; sending to control", norm)
+		go b.doSetHostinfoFilterServices(hi)
+	}
+	return nil
+}
+
+// DNSServerDomains returns the DNS domains set by SetDNSServerDomains.
+func (b *LocalBackend) DNSServerDomains() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.dnsServerDomains)
+}
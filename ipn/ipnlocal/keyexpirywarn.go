@@ -0,0 +1,173 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+)
+
+var warnKeyExpiry = health.NewWarnable(health.WithCode("key-expiry"))
+
+var (
+	// keyExpiryWarnThresholds is a comma-separated list of durations
+	// before the node key expires at which to warn about it, overriding
+	// defaultKeyExpiryWarnThresholds.
+	keyExpiryWarnThresholds = envknob.RegisterString("TS_KEY_EXPIRY_WARNINGS")
+
+	// keyExpiryScript is the path of a program to run on each key expiry
+	// warning, with TS_KEY_EXPIRY (RFC 3339) and TS_KEY_EXPIRY_THRESHOLD
+	// (a duration) in its environment.
+	keyExpiryScript = envknob.RegisterString("TS_KEY_EXPIRY_SCRIPT")
+)
+
+// defaultKeyExpiryWarnThresholds are when to warn about the node key
+// expiring, by default: a week, a day and an hour before it does.
+var defaultKeyExpiryWarnThresholds = []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour}
+
+// keyExpiryScriptTimeout is how long the TS_KEY_EXPIRY_SCRIPT can run.
+const keyExpiryScriptTimeout = time.Minute
+
+// keyExpiryThresholds returns the key expiry warning thresholds, from the
+// longest to the shortest.
+func (b *LocalBackend) keyExpiryThresholds() []time.Duration {
+	v := keyExpiryWarnThresholds()
+	if v == "" {
+		return defaultKeyExpiryWarnThresholds
+	}
+	var ths []time.Duration
+	for _, s := range strings.Split(v, ",") {
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil || d <= 0 {
+			b.logf("ignoring invalid TS_KEY_EXPIRY_WARNINGS threshold %q", s)
+			continue
+		}
+		ths = append(ths, d)
+	}
+	sort.Slice(ths, func(i, j int) bool { return ths[i] > ths[j] })
+	return ths
+}
+
+// crossedKeyExpiryThreshold returns the shortest of the thresholds (sorted
+// from the longest) that left, the time until the node key expires, is
+// within, or zero if the key has expired. It reports whether there's one.
+func crossedKeyExpiryThreshold(thresholds []time.Duration, left time.Duration) (_ time.Duration, ok bool) {
+	if left <= 0 {
+		return 0, true
+	}
+	for i := len(thresholds) - 1; i >= 0; i-- {
+		if left <= thresholds[i] {
+			return thresholds[i], true
+		}
+	}
+	return 0, false
+}
+
+// nextKeyExpiryCheck returns how long until left, the time until the node
+// key expires, crosses the next of the thresholds, or expires. It returns
+// zero if the key has expired.
+func nextKeyExpiryCheck(thresholds []time.Duration, left time.Duration) time.Duration {
+	if left <= 0 {
+		return 0
+	}
+	for _, th := range thresholds {
+		if th < left {
+			return left - th
+		}
+	}
+	return left
+}
+
+// checkKeyExpiryLocked updates the key expiry health warning for a node key
+// that expires at expiry (or never, if zero), and schedules the next check.
+// It returns the warning to send with notifyKeyExpiry if a new threshold
+// was crossed, else nil.
+//
+// b.mu must be held.
+func (b *LocalBackend) checkKeyExpiryLocked(expiry, now time.Time) *ipn.KeyExpiryWarning {
+	if b.keyExpiryWarnTimer != nil {
+		b.keyExpiryWarnTimer.Stop()
+		b.keyExpiryWarnTimer = nil
+	}
+	if expiry.IsZero() {
+		b.keyExpiryWarned = false
+		warnKeyExpiry.Set(nil)
+		return nil
+	}
+	if !expiry.Equal(b.keyExpiryWarnedFor) {
+		// New key or extended expiry; start over.
+		b.keyExpiryWarnedFor = expiry
+		b.keyExpiryWarned = false
+	}
+
+	thresholds := b.keyExpiryThresholds()
+	left := expiry.Sub(now)
+	if next := nextKeyExpiryCheck(thresholds, left); next > 0 {
+		b.keyExpiryWarnTimer = time.AfterFunc(next, b.keyExpiryTimerFired)
+	}
+	th, ok := crossedKeyExpiryThreshold(thresholds, left)
+	if !ok {
+		warnKeyExpiry.Set(nil)
+		return nil
+	}
+	if left > 0 {
+		warnKeyExpiry.Set(fmt.Errorf("this node's key expires in %v, at %v; reauthenticate with 'tailscale up --force-reauth' or disable its key expiry", left.Round(time.Minute), expiry.Format(time.RFC3339)))
+	} else {
+		warnKeyExpiry.Set(fmt.Errorf("this node's key expired at %v", expiry.Format(time.RFC3339)))
+	}
+	if b.keyExpiryWarned && th >= b.keyExpiryWarnedThreshold {
+		return nil
+	}
+	b.keyExpiryWarned = true
+	b.keyExpiryWarnedThreshold = th
+	return &ipn.KeyExpiryWarning{Expiry: expiry, Threshold: th}
+}
+
+// keyExpiryTimerFired checks the key expiry again when the time left
+// before it crosses a threshold.
+func (b *LocalBackend) keyExpiryTimerFired() {
+	b.mu.Lock()
+	var expiry time.Time
+	if b.netMap != nil {
+		expiry = b.netMap.Expiry
+	}
+	w := b.checkKeyExpiryLocked(expiry, time.Now())
+	b.mu.Unlock()
+	b.notifyKeyExpiry(w)
+}
+
+// notifyKeyExpiry sends w, if non-nil, to the IPN bus watchers and runs the
+// TS_KEY_EXPIRY_SCRIPT with it, if set.
+func (b *LocalBackend) notifyKeyExpiry(w *ipn.KeyExpiryWarning) {
+	if w == nil {
+		return
+	}
+	b.logf("node key expires at %v (warning threshold %v)", w.Expiry.Format(time.RFC3339), w.Threshold)
+	b.send(ipn.Notify{KeyExpiryWarning: w})
+	if script := keyExpiryScript(); script != "" {
+		go b.runKeyExpiryScript(script, *w)
+	}
+}
+
+func (b *LocalBackend) runKeyExpiryScript(script string, w ipn.KeyExpiryWarning) {
+	ctx, cancel := context.WithTimeout(context.Background(), keyExpiryScriptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, script)
+	cmd.Env = append(os.Environ(),
+		"TS_KEY_EXPIRY="+w.Expiry.Format(time.RFC3339),
+		"TS_KEY_EXPIRY_THRESHOLD="+w.Threshold.String(),
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		b.logf("TS_KEY_EXPIRY_SCRIPT %v: %v; output: %q", script, err, out)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyExpiryThresholds(t *testing.T) {
+	ths := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour}
+	tests := []struct {
+		left        time.Duration
+		wantCrossed time.Duration
+		wantOK      bool
+		wantNext    time.Duration
+	}{
+		{30 * 24 * time.Hour, 0, false, 23 * 24 * time.Hour},
+		{7 * 24 * time.Hour, 7 * 24 * time.Hour, true, 6 * 24 * time.Hour},
+		{2 * 24 * time.Hour, 7 * 24 * time.Hour, true, 24 * time.Hour},
+		{5 * time.Hour, 24 * time.Hour, true, 4 * time.Hour},
+		{30 * time.Minute, time.Hour, true, 30 * time.Minute},
+		{0, 0, true, 0},
+		{-time.Hour, 0, true, 0},
+	}
+	for _, tt := range tests {
+		th, ok := crossedKeyExpiryThreshold(ths, tt.left)
+		if th != tt.wantCrossed || ok != tt.wantOK {
+			t.Errorf("crossedKeyExpiryThreshold(%v) = %v, %v; want %v, %v", tt.left, th, ok, tt.wantCrossed, tt.wantOK)
+		}
+		if got := nextKeyExpiryCheck(ths, tt.left); got != tt.wantNext {
+			t.Errorf("nextKeyExpiryCheck(%v) = %v; want %v", tt.left, got, tt.wantNext)
+		}
+	}
+}
+
+func TestCheckKeyExpiry(t *testing.T) {
+	b := &LocalBackend{logf: t.Logf}
+	t.Cleanup(func() {
+		if b.keyExpiryWarnTimer != nil {
+			b.keyExpiryWarnTimer.Stop()
+		}
+	})
+	now := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	expiry := now.Add(10 * 24 * time.Hour)
+
+	if w := b.checkKeyExpiryLocked(expiry, now); w != nil {
+		t.Fatalf("warned %+v 10 days ahead", w)
+	}
+	if b.keyExpiryWarnTimer == nil {
+		t.Fatal("no timer for the next threshold")
+	}
+	now = now.Add(4 * 24 * time.Hour)
+	w := b.checkKeyExpiryLocked(expiry, now)
+	if w == nil || w.Threshold != 7*24*time.Hour || !w.Expiry.Equal(expiry) {
+		t.Fatalf("6 days ahead: got %+v; want the 7 day warning", w)
+	}
+	if w := b.checkKeyExpiryLocked(expiry, now.Add(time.Hour)); w != nil {
+		t.Fatalf("warned %+v again for the same threshold", w)
+	}
+	now = expiry.Add(-30 * time.Minute)
+	if w := b.checkKeyExpiryLocked(expiry, now); w == nil || w.Threshold != time.Hour {
+		t.Fatalf("30 minutes ahead: got %+v; want the 1 hour warning", w)
+	}
+	if w := b.checkKeyExpiryLocked(expiry, expiry.Add(time.Second)); w == nil || w.Threshold != 0 {
+		t.Fatalf("after expiry: got %+v; want the expired warning", w)
+	}
+	if b.keyExpiryWarnTimer != nil {
+		t.Error("timer still set after expiry")
+	}
+
+	// Extending the expiry starts over.
+	expiry = expiry.Add(90 * 24 * time.Hour)
+	if w := b.checkKeyExpiryLocked(expiry, now); w != nil {
+		t.Fatalf("warned %+v for an extended expiry", w)
+	}
+	if w := b.checkKeyExpiryLocked(time.Time{}, now); w != nil || b.keyExpiryWarnTimer != nil {
+		t.Fatalf("got %+v, timer %v for a key that doesn't expire", w, b.keyExpiryWarnTimer)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// maxLatencyTargets is the most nodes a latency matrix can measure, which
+// bounds the pings a node sends when a peer asks it to measure.
+const maxLatencyTargets = 32
+
+// latencyPingTimeout is how long to wait for each ping of a latency
+// matrix.
+const latencyPingTimeout = 5 * time.Second
+
+func (h *peerAPIHandler) canMeasureLatency() bool {
+	return h.isSelf || h.peerHasCap(tailcfg.CapabilityDebugPeer)
+}
+
+func (h *peerAPIHandler) handleServeLatency(w http.ResponseWriter, r *http.Request) {
+	if !h.canMeasureLatency() {
+		http.Error(w, "denied; no latency access", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ips, err := parseLatencyTargets(r.Form["ip"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nm := h.ps.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusServiceUnavailable)
+		return
+	}
+	row := apitype.LatencyRow{
+		From:     latencyFrom(nm).String(),
+		FromName: nm.Name,
+		Results:  h.ps.b.measureLatencies(r.Context(), nm, ips),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(row)
+}
+
+// parseLatencyTargets parses the Tailscale IPs of the nodes to measure
+// the latency to.
+func parseLatencyTargets(ss []string) ([]netip.Addr, error) {
+	if len(ss) > maxLatencyTargets {
+		return nil, fmt.Errorf("too many nodes; the most is %d", maxLatencyTargets)
+	}
+	ips := make([]netip.Addr, len(ss))
+	for i, s := range ss {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP %q", s)
+		}
+		ips[i] = ip
+	}
+	return ips, nil
+}
+
+// latencyFrom returns the Tailscale IP that identifies this node in a
+// latency matrix, preferring IPv4.
+func latencyFrom(nm *netmap.NetworkMap) netip.Addr {
+	if ip, ok := selfAddrForFamily(nm, false); ok {
+		return ip
+	}
+	ip, _ := selfAddrForFamily(nm, true)
+	return ip
+}
+
+// LatencyMatrix measures the latency and path from this node to the nodes
+// with the Tailscale IPs ips, by disco pings along the path that other
+// traffic to them takes. If allPairs, each of those nodes is also asked,
+// over its PeerAPI, to measure its latency to this node and the others,
+// filling in the rest of the matrix. They must be owned by the same user
+// as this node, or grant it access to debug them.
+func (b *LocalBackend) LatencyMatrix(ctx context.Context, ips []netip.Addr, allPairs bool) (*apitype.LatencyMatrix, error) {
+	if len(ips) > maxLatencyTargets {
+		return nil, fmt.Errorf("too many nodes; the most is %d", maxLatencyTargets)
+	}
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	self := latencyFrom(nm)
+	rows := make([]apitype.LatencyRow, 1, 1+len(ips))
+	rows[0] = apitype.LatencyRow{
+		From:     self.String(),
+		FromName: nm.Name,
+		Results:  b.measureLatencies(ctx, nm, ips),
+	}
+	if !allPairs {
+		return &apitype.LatencyMatrix{Rows: rows}, nil
+	}
+	rows = rows[:1+len(ips)]
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		targets := []netip.Addr{self}
+		for _, other := range ips {
+			if other != ip {
+				targets = append(targets, other)
+			}
+		}
+		wg.Add(1)
+		go func(row *apitype.LatencyRow, ip netip.Addr) {
+			defer wg.Done()
+			*row = b.peerLatencies(ctx, nm, ip, targets)
+		}(&rows[1+i], ip)
+	}
+	wg.Wait()
+	return &apitype.LatencyMatrix{Rows: rows}, nil
+}
+
+// peerLatencies asks the peer with the Tailscale IP ip to measure its
+// latency to targets, over its PeerAPI.
+func (b *LocalBackend) peerLatencies(ctx context.Context, nm *netmap.NetworkMap, ip netip.Addr, targets []netip.Addr) apitype.LatencyRow {
+	row := apitype.LatencyRow{From: ip.String()}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		row.Err = fmt.Sprintf("no peer found with Tailscale IP %v", ip)
+		return row
+	}
+	row.FromName = peer.Name
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		row.Err = fmt.Sprintf("no PeerAPI base found for peer %v (%v)", peer.ID, ip)
+		return row
+	}
+	got, err := b.fetchPeerLatencies(ctx, base, targets)
+	if err != nil {
+		row.Err = err.Error()
+		return row
+	}
+	row.Results = got.Results
+	return row
+}
+
+func (b *LocalBackend) fetchPeerLatencies(ctx context.Context, base string, targets []netip.Addr) (*apitype.LatencyRow, error) {
+	// The peer pings all targets at once, so give it about as long as a
+	// single ping, plus time for the request itself.
+	ctx, cancel := context.WithTimeout(ctx, latencyPingTimeout+5*time.Second)
+	defer cancel()
+	form := url.Values{}
+	for _, ip := range targets {
+		form.Add("ip", ip.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/latency", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := b.Dialer().PeerAPITransport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP status %v: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+	row := new(apitype.LatencyRow)
+	if err := json.Unmarshal(body, row); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// measureLatencies disco-pings the nodes with the Tailscale IPs ips, all at
+// once, returning the results in the same order.
+func (b *LocalBackend) measureLatencies(ctx context.Context, nm *netmap.NetworkMap, ips []netip.Addr) []apitype.LatencyResult {
+	res := make([]apitype.LatencyResult, len(ips))
+	var wg sync.WaitGroup
+	for i, ip := range ips {
+		r := &res[i]
+		r.To = ip.String()
+		if isSelfAddr(nm, ip) {
+			r.ToName = nm.Name
+			r.Path = "self"
+			continue
+		}
+		peer, ok := nm.PeerByTailscaleIP(ip)
+		if !ok {
+			r.Err = "no peer found with this Tailscale IP"
+			continue
+		}
+		r.ToName = peer.Name
+		wg.Add(1)
+		go func(ip netip.Addr) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, latencyPingTimeout)
+			defer cancel()
+			pr, err := b.Ping(ctx, ip, tailcfg.PingDisco)
+			switch {
+			case errors.Is(err, context.DeadlineExceeded):
+				r.Err = "timeout"
+			case err != nil:
+				r.Err = err.Error()
+			case pr.Err != "":
+				r.Err = pr.Err
+			default:
+				r.LatencySeconds = pr.LatencySeconds
+				r.Path = pingPath(pr)
+			}
+		}(ip)
+	}
+	wg.Wait()
+	return res
+}
+
+// isSelfAddr reports whether ip is one of this node's Tailscale IPs.
+func isSelfAddr(nm *netmap.NetworkMap, ip netip.Addr) bool {
+	for _, p := range nm.Addresses {
+		if p.Addr() == ip {
+			return true
+		}
+	}
+	return false
+}
+
+// pingPath describes the path a disco ping took, in the format of
+// peerPath.
+func pingPath(pr *ipnstate.PingResult) string {
+	switch {
+	case pr.Endpoint != "":
+		return "direct " + pr.Endpoint
+	case pr.DERPRegionCode != "":
+		return "DERP(" + pr.DERPRegionCode + ")"
+	}
+	return "unknown"
+}
@@ -43,14 +43,20 @@
 	"tailscale.com/ipn/ipnauth"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/ipn/policy"
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/net/connstats"
 	"tailscale.com/net/dns"
 	"tailscale.com/net/dnscache"
 	"tailscale.com/net/dnsfallback"
+	"tailscale.com/net/exitlimit"
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/netutil"
+	"tailscale.com/net/routeobs"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tsdial"
+	"tailscale.com/net/tshttpproxy"
+	"tailscale.com/net/usage"
 	"tailscale.com/paths"
 	"tailscale.com/portlist"
 	"tailscale.com/syncs"
@@ -73,12 +79,14 @@
 	"tailscale.com/util/set"
 	"tailscale.com/util/systemd"
 	"tailscale.com/util/uniq"
+	"tailscale.com/util/winutil"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/capture"
 	"tailscale.com/wgengine/filter"
 	"tailscale.com/wgengine/magicsock"
+	"tailscale.com/wgengine/monitor"
 	"tailscale.com/wgengine/router"
 	"tailscale.com/wgengine/wgcfg"
 	"tailscale.com/wgengine/wgcfg/nmcfg"
@@ -138,17 +146,42 @@ type LocalBackend struct {
 	dialer                *tsdial.Dialer // non-nil
 	backendLogID          string
 	unregisterLinkMon     func()
+	unregisterLinkDelta   func()
 	unregisterHealthWatch func()
+	unregisterChecks      []func()         // unregister this backend's health checks
 	portpoll              *portlist.Poller // may be nil
 	portpollOnce          sync.Once        // guards starting readPoller
 	gotPortPollRes        chan struct{}    // closed upon first readPoller result
 	newDecompressor       func() (controlclient.Decompressor, error)
-	varRoot               string         // or empty if SetVarRoot never called
-	logFlushFunc          func()         // or nil if SetLogFlusher wasn't called
-	em                    *expiryManager // non-nil
+	varRoot               string                  // or empty if SetVarRoot never called
+	logFlushFunc          func()                  // or nil if SetLogFlusher wasn't called
+	app, appVersion       string                  // or empty if SetApp never called
+	serviceRecords        []apitype.ServiceRecord // guarded by mu; served over peerapi
+	dnsServerDomains      []string                // guarded by mu; sent to control in Hostinfo.DNSDomains
+	peerAPIApps           map[string]http.Handler // guarded by mu; served under /v0/app/<name>
+	ingressHandler        IngressHandler          // or nil if SetIngressHandler never called
+	em                    *expiryManager          // non-nil
 	sshAtomicBool         atomic.Bool
 	shutdownCalled        bool // if Shutdown has been called
 	debugSink             *capture.Sink
+	routeObs              *routeobs.Observer    // or nil if not observing routes; guarded by mu
+	usage                 *usage.Counter        // attributes counted traffic to peers; never nil
+	usageStats            *connstats.Statistics // or nil if not recording usage; guarded by mu
+	exitRateLimit         uint64                // bytes/sec of the tstun exit rate limiter, or 0 for none; guarded by mu
+
+	// usageMu guards the daily traffic totals, which are read from the
+	// state store the first time they're needed.
+	usageMu     sync.Mutex
+	usageLoaded bool
+	usageDays   []apitype.UsageDay
+
+	// controlMu guards the control server connection state reported by
+	// ControlStatus. It's separate from mu so ControlStatus never blocks
+	// on slow backend operations.
+	controlMu      sync.Mutex
+	controlErr     error     // last error from controlclient; nil once it recovers
+	controlErrTime time.Time // when controlErr was last set
+	lastNetMapTime time.Time // when the last netmap was received from control
 
 	// lastProfileID tracks the last profile we've seen from the ProfileManager.
 	// It's used to detect when the user has changed their profile.
@@ -196,6 +229,13 @@ type LocalBackend struct {
 	fileWaiters      set.HandleSet[context.CancelFunc] // of wake-up funcs
 	notifyWatchers   set.HandleSet[chan *ipn.Notify]
 	lastStatusTime   time.Time // status.AsOf value of the last processed status update
+	// keyExpiryWarnTimer fires at the next key expiry warning threshold;
+	// can be nil. keyExpiryWarned is whether a warning was sent for the
+	// node key expiry keyExpiryWarnedFor, at keyExpiryWarnedThreshold.
+	keyExpiryWarnTimer       *time.Timer
+	keyExpiryWarnedFor       time.Time
+	keyExpiryWarnedThreshold time.Duration
+	keyExpiryWarned          bool
 	// directFileRoot, if non-empty, means to write received files
 	// directly to this directory, without staging them in an
 	// intermediate buffered directory for "pick-up" later. If
@@ -211,6 +251,41 @@ type LocalBackend struct {
 	directFileRoot          string
 	directFileDoFinalRename bool // false on macOS, true on several NAS platforms
 	componentLogUntil       map[string]componentLogState
+	proxyURL                string // last ProxyURL pref applied by setProxyOverrideLocked
+
+	// capsHandled is whether each capability with a registered
+	// CapabilityHandler was present in the last netmap, and
+	// capsUnknownLogged the unknown capabilities already logged.
+	capsHandled       map[string]bool
+	capsUnknownLogged map[string]bool
+
+	// captivePortal is whether netcheck last detected a captive portal,
+	// in which case the exit node is bypassed; see captivePortalPrefs.
+	captivePortal atomic.Bool
+
+	// postureAttrs are the device posture attributes last collected by
+	// the registered posture collectors, which are added to the
+	// Hostinfo sent to control, and postureErrs the errors of those
+	// that failed, by collector name. postureCollected is when they
+	// were collected. (guarded by mu)
+	postureAttrs     map[string]string
+	postureErrs      map[string]string
+	postureCollected time.Time
+
+	// policySettings are the values of the system policy settings as of
+	// the last check for changes. (guarded by mu)
+	policySettings map[string]string
+
+	// declaredPrefs, if non-nil, are the prefs declared in tailscaled's
+	// --config file, which are reverted to on drift if
+	// declaredAutoRevert. (guarded by mu)
+	declaredPrefs      *ipn.MaskedPrefs
+	declaredAutoRevert bool
+
+	// autoUpdateTimer, if non-nil, fires to start the automatic update to
+	// autoUpdateTo. (guarded by mu)
+	autoUpdateTimer *time.Timer
+	autoUpdateTo    string
 
 	// ServeConfig fields. (also guarded by mu)
 	lastServeConfJSON mem.RO              // last JSON that was parsed into serveConfig
@@ -250,7 +325,8 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 		panic("ipn.NewLocalBackend: engine must not be nil")
 	}
 
-	pm, err := newProfileManager(store, logf)
+	hstore := &healthStateStore{StateStore: store}
+	pm, err := newProfileManager(hstore, logf)
 	if err != nil {
 		return nil, err
 	}
@@ -286,12 +362,13 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 		em:             newExpiryManager(logf),
 		gotPortPollRes: make(chan struct{}),
 		loginFlags:     loginFlags,
+		usage:          new(usage.Counter),
 	}
 
 	// Default filter blocks everything and logs nothing, until Start() is called.
 	b.setFilter(filter.NewAllowNone(logf, &netipx.IPSet{}))
 
-	b.setTCPPortsIntercepted(nil)
+	b.setTCPPortsIntercepted(nil, nil)
 
 	b.statusChanged = sync.NewCond(&b.statusLock)
 	b.e.SetStatusCallback(b.setWgengineStatus)
@@ -302,8 +379,13 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 	// then also whenever it changes:
 	b.linkChange(false, linkMon.InterfaceState())
 	b.unregisterLinkMon = linkMon.RegisterChangeCallback(b.linkChange)
+	b.unregisterLinkDelta = linkMon.RegisterChangeDeltaCallback(b.linkChangeDelta)
 
 	b.unregisterHealthWatch = health.RegisterWatcher(b.onHealthChange)
+	b.unregisterChecks = []func(){
+		health.RegisterCheck("cert-expiry", b.certExpiryCheck),
+		health.RegisterCheck("state-write", hstore.check),
+	}
 
 	wiredPeerAPIPort := false
 	if ig, ok := e.(wgengine.InternalsGetter); ok {
@@ -315,6 +397,9 @@ func NewLocalBackend(logf logger.Logf, logid string, store ipn.StateStore, diale
 	if !wiredPeerAPIPort {
 		b.logf("[unexpected] failed to wire up PeerAPI port for engine %T", e)
 	}
+	go b.postureLoop()
+	go b.policyWatchLoop()
+	go b.checkAutoUpdated()
 
 	for _, component := range debuggableComponents {
 		key := componentStateKey(component)
@@ -454,6 +539,15 @@ func (b *LocalBackend) maybePauseControlClientLocked() {
 
 // linkChange is our link monitor callback, called whenever the network changes.
 // major is whether ifst is different than earlier.
+// linkChangeDelta tells IPN bus watchers how the network changed.
+func (b *LocalBackend) linkChangeDelta(d *monitor.ChangeDelta) {
+	b.send(ipn.Notify{LinkChange: &ipn.LinkChange{
+		Kind:             d.Kind.String(),
+		DefaultInterface: d.New.DefaultRouteInterface,
+		Metered:          d.Metered(),
+	}})
+}
+
 func (b *LocalBackend) linkChange(major bool, ifst *interfaces.State) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -495,6 +589,36 @@ func (b *LocalBackend) onHealthChange(sys health.Subsystem, err error) {
 	}
 }
 
+// healthStateStore is an ipn.StateStore that records whether the last
+// write to it failed, such as due to a full disk, for the "state-write"
+// health check.
+type healthStateStore struct {
+	ipn.StateStore
+
+	mu      sync.Mutex
+	lastErr error // from the most recent WriteState
+}
+
+func (s *healthStateStore) WriteState(id ipn.StateKey, bs []byte) error {
+	err := s.StateStore.WriteState(id, bs)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err
+	return err
+}
+
+func (s *healthStateStore) String() string { return fmt.Sprint(s.StateStore) }
+
+// check is the "state-write" health check.
+func (s *healthStateStore) check() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.lastErr != nil {
+		return fmt.Errorf("writing state: %w", s.lastErr)
+	}
+	return nil
+}
+
 // Shutdown halts the backend and all its sub-components. The backend
 // can no longer be used after Shutdown returns.
 func (b *LocalBackend) Shutdown() {
@@ -518,6 +642,10 @@ func (b *LocalBackend) Shutdown() {
 		b.sshServer = nil
 	}
 	b.closePeerAPIListenersLocked()
+	if b.autoUpdateTimer != nil {
+		b.autoUpdateTimer.Stop()
+		b.autoUpdateTimer = nil
+	}
 	if b.debugSink != nil {
 		b.e.InstallCaptureHook(nil)
 		b.debugSink.Close()
@@ -526,13 +654,18 @@ func (b *LocalBackend) Shutdown() {
 	b.mu.Unlock()
 
 	b.unregisterLinkMon()
+	b.unregisterLinkDelta()
 	b.unregisterHealthWatch()
+	for _, f := range b.unregisterChecks {
+		f()
+	}
 	if cc != nil {
 		cc.Shutdown()
 	}
 	b.ctxCancel()
 	b.e.Close()
 	b.e.Wait()
+	b.setRecordUsage(false)
 }
 
 func stripKeysFromPrefs(p ipn.PrefsView) ipn.PrefsView {
@@ -585,6 +718,18 @@ func (b *LocalBackend) UpdateStatus(sb *ipnstate.StatusBuilder) {
 	b.updateStatus(sb, extraLocked)
 }
 
+// addHealthWarning adds a health problem to both s.Health and
+// s.HealthWarnings.
+func addHealthWarning(s *ipnstate.Status, code string, sev health.Severity, text, docsURL string) {
+	s.Health = append(s.Health, text)
+	s.HealthWarnings = append(s.HealthWarnings, ipnstate.HealthWarning{
+		Code:     code,
+		Severity: string(sev),
+		Text:     text,
+		DocsURL:  docsURL,
+	})
+}
+
 // updateStatus populates sb with status.
 //
 // extraLocked, if non-nil, is called while b.mu is still held.
@@ -596,21 +741,17 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 		s.TUN = !wgengine.IsNetstack(b.e)
 		s.BackendState = b.state.String()
 		s.AuthURL = b.authURLSticky
-		if err := health.OverallError(); err != nil {
-			switch e := err.(type) {
-			case multierr.Error:
-				for _, err := range e.Errors() {
-					s.Health = append(s.Health, err.Error())
-				}
-			default:
-				s.Health = append(s.Health, err.Error())
-			}
+		if mc, err := b.magicConn(); err == nil {
+			s.PowerSave = mc.PowerSave()
+		}
+		for _, w := range health.Warnings() {
+			addHealthWarning(s, w.Code, w.Severity, w.Text, w.DocsURL)
 		}
 		if m := b.sshOnButUnusableHealthCheckMessageLocked(); m != "" {
-			s.Health = append(s.Health, m)
+			addHealthWarning(s, "ssh-unusable", health.SeverityWarning, m, "https://tailscale.com/kb/1193/tailscale-ssh/")
 		}
 		if version.IsUnstableBuild() {
-			s.Health = append(s.Health, "This is an unstable (development) version of Tailscale; frequent updates and bugs are likely")
+			addHealthWarning(s, "unstable-version", health.SeverityWarning, "This is an unstable (development) version of Tailscale; frequent updates and bugs are likely", "")
 		}
 		if b.netMap != nil {
 			s.CertDomains = append([]string(nil), b.netMap.DNS.CertDomains...)
@@ -623,7 +764,7 @@ func (b *LocalBackend) updateStatus(sb *ipnstate.StatusBuilder, extraLocked func
 			s.CurrentTailnet.Name = b.netMap.Domain
 			if prefs := b.pm.CurrentPrefs(); prefs.Valid() {
 				if !prefs.RouteAll() && b.netMap.AnyPeersAdvertiseRoutes() {
-					s.Health = append(s.Health, healthmsg.WarnAcceptRoutesOff)
+					addHealthWarning(s, "accept-routes-off", health.SeverityWarning, healthmsg.WarnAcceptRoutesOff, "https://tailscale.com/kb/1019/subnets/")
 				}
 				if !prefs.ExitNodeID().IsZero() {
 					if exitPeer, ok := b.netMap.PeerWithStableID(prefs.ExitNodeID()); ok {
@@ -699,6 +840,7 @@ func (b *LocalBackend) populatePeerStatusLocked(sb *ipnstate.StatusBuilder) {
 			LastSeen:     lastSeen,
 			Online:       p.Online != nil && *p.Online,
 			ShareeNode:   p.Hostinfo.ShareeNode(),
+			Maintenance:  p.Hostinfo.Maintenance(),
 			ExitNode:     p.StableID != "" && p.StableID == exitNodeID,
 			SSH_HostKeys: p.Hostinfo.SSH_HostKeys().AsSlice(),
 		}
@@ -766,6 +908,33 @@ func (b *LocalBackend) WhoIs(ipp netip.AddrPort) (n *tailcfg.Node, u tailcfg.Use
 	return n, u, true
 }
 
+// AuthorizeProxyClient returns an error if the client at src may not use
+// tailscaled's SOCKS5 and HTTP proxies. Clients on the tailnet, connecting
+// to a Tailscale IP or forwarded to the proxies by netstack, are
+// identified with WhoIs, and must be owned by the same user as this node,
+// or granted tailcfg.CapabilityOutboundProxy. Other clients are allowed;
+// the proxies' listen addresses limit who they are.
+func (b *LocalBackend) AuthorizeProxyClient(src netip.AddrPort) error {
+	n, _, ok := b.WhoIs(src)
+	if !ok {
+		if tsaddr.IsTailscaleIP(src.Addr()) {
+			return fmt.Errorf("unknown tailnet client %v", src.Addr())
+		}
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if nm := b.netMap; nm != nil && nm.SelfNode != nil && nm.SelfNode.User == n.User {
+		return nil
+	}
+	for _, a := range n.Addresses {
+		if slices.Contains(b.peerCapsLocked(a.Addr()), tailcfg.CapabilityOutboundProxy) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%v isn't owned by this node's user or granted %v", n.ComputedName, tailcfg.CapabilityOutboundProxy)
+}
+
 // PeerCaps returns the capabilities that remote src IP has to
 // ths current node.
 func (b *LocalBackend) PeerCaps(src netip.Addr) []string {
@@ -807,6 +976,8 @@ func (b *LocalBackend) SetDecompressor(fn func() (controlclient.Decompressor, er
 // setClientStatus is the callback invoked by the control client whenever it posts a new status.
 // Among other things, this is where we update the netmap, packet filters, DNS and DERP maps.
 func (b *LocalBackend) setClientStatus(st controlclient.Status) {
+	b.noteControlStatus(st)
+
 	// The following do not depend on any data for which we need to lock b.
 	if st.Err != nil {
 		// TODO(crawshaw): display in the UI.
@@ -870,6 +1041,7 @@ func (b *LocalBackend) setClientStatus(st controlclient.Status) {
 
 	wasBlocked := b.blocked
 	keyExpiryExtended := false
+	var keyExpiryWarning *ipn.KeyExpiryWarning
 	if st.NetMap != nil {
 		wasExpired := b.keyExpired
 		isExpired := !st.NetMap.Expiry.IsZero() && st.NetMap.Expiry.Before(time.Now())
@@ -877,9 +1049,12 @@ func (b *LocalBackend) setClientStatus(st controlclient.Status) {
 			keyExpiryExtended = true
 		}
 		b.keyExpired = isExpired
+		keyExpiryWarning = b.checkKeyExpiryLocked(st.NetMap.Expiry, time.Now())
 	}
 	b.mu.Unlock()
 
+	b.notifyKeyExpiry(keyExpiryWarning)
+
 	if keyExpiryExtended && wasBlocked {
 		// Key extended, unblock the engine
 		b.blockEngineUpdates(false)
@@ -988,6 +1163,7 @@ func (b *LocalBackend) setClientStatus(st controlclient.Status) {
 		if !envknob.TKASkipSignatureCheck() {
 			b.tkaFilterNetmapLocked(st.NetMap)
 		}
+		b.trimNetmapLocked(st.NetMap, prefs.View())
 		b.setNetMapLocked(st.NetMap)
 		b.updateFilterLocked(st.NetMap, prefs.View())
 	}
@@ -1206,6 +1382,28 @@ func (b *LocalBackend) startIsNoopLocked(opts ipn.Options) bool {
 		opts.AuthKey == ""
 }
 
+// netmapCacheStateKey is the StateKey under which the control client
+// stores the last netmap. There's one for all profiles: it's sealed to
+// the node key, so it's only used by the profile that stored it.
+const netmapCacheStateKey = ipn.StateKey("_netmap-cache")
+
+// netmapCache is the controlclient.MapCache of a StateStore.
+type netmapCache struct {
+	store ipn.StateStore
+}
+
+func (c netmapCache) Load() ([]byte, error) {
+	b, err := c.store.ReadState(netmapCacheStateKey)
+	if errors.Is(err, ipn.ErrStateNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
+
+func (c netmapCache) Store(b []byte) error {
+	return c.store.WriteState(netmapCacheStateKey, b)
+}
+
 // Start applies the configuration specified in opts, and starts the
 // state machine.
 //
@@ -1266,6 +1464,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	hostinfo.FrontendLogID = opts.FrontendLogID
 	hostinfo.Userspace.Set(wgengine.IsNetstack(b.e))
 	hostinfo.UserspaceRouter.Set(wgengine.IsNetstackRouter(b.e))
+	b.applyAppToHostinfo(hostinfo)
 
 	if b.cc != nil {
 		// TODO(apenwarr): avoid the need to reinit controlclient.
@@ -1381,6 +1580,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 		Status:               b.setClientStatus,
 		C2NHandler:           http.HandlerFunc(b.handleC2N),
 		DialPlan:             &b.dialPlan, // pointer because it can't be copied
+		MapCache:             netmapCache{b.store},
 
 		// Don't warn about broken Linux IP forwarding when
 		// netstack is being used.
@@ -1431,7 +1631,7 @@ func (b *LocalBackend) Start(opts ipn.Options) error {
 	return nil
 }
 
-var warnInvalidUnsignedNodes = health.NewWarnable()
+var warnInvalidUnsignedNodes = health.NewWarnable(health.WithCode("tailnet-lock-unsigned-nodes"))
 
 // updateFilterLocked updates the packet filter in wgengine based on the
 // given netMap and user preferences.
@@ -1585,6 +1785,28 @@ func (b *LocalBackend) setFilter(f *filter.Filter) {
 	b.e.SetFilter(f)
 }
 
+// StartFilterTrace makes the packet filter record its decisions about new
+// flows for d, or stop if d is zero. If ip is valid, only flows to or from
+// it are recorded. See filter.Filter.StartTrace.
+func (b *LocalBackend) StartFilterTrace(d time.Duration, ip netip.Addr) error {
+	f := b.filterAtomic.Load()
+	if f == nil {
+		return errors.New("no packet filter")
+	}
+	f.StartTrace(d, ip)
+	return nil
+}
+
+// FilterTrace returns the decisions the packet filter recorded since the
+// last StartFilterTrace, oldest first.
+func (b *LocalBackend) FilterTrace() []filter.TraceEntry {
+	f := b.filterAtomic.Load()
+	if f == nil {
+		return nil
+	}
+	return f.Trace()
+}
+
 var removeFromDefaultRoute = []netip.Prefix{
 	// RFC1918 LAN ranges
 	netip.MustParsePrefix("192.168.0.0/16"),
@@ -1801,6 +2023,7 @@ func (b *LocalBackend) readPoller() {
 // the new version to the control server.
 func (b *LocalBackend) ResendHostinfoIfNeeded() {
 	hi := hostinfo.New()
+	b.applyAppToHostinfo(hi)
 
 	b.mu.Lock()
 	if b.hostinfo != nil {
@@ -2069,6 +2292,9 @@ func (b *LocalBackend) onClientVersion(v *tailcfg.ClientVersion) {
 		// if they ignore it.
 		b.send(ipn.Notify{ClientVersion: v})
 	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maybeScheduleAutoUpdateLocked(v)
 }
 
 // For testing lazy machine key generation.
@@ -2187,7 +2413,7 @@ func (b *LocalBackend) migrateStateLocked(prefs *ipn.Prefs) (err error) {
 // setTCPPortsIntercepted populates b.shouldInterceptTCPPortAtomic with an
 // efficient func for ShouldInterceptTCPPort to use, which is called on every
 // incoming packet.
-func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
+func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16, ranges []tailcfg.PortRange) {
 	slices.Sort(ports)
 	uniq.ModifySlice(&ports)
 	var f func(uint16) bool
@@ -2218,17 +2444,34 @@ func (b *LocalBackend) setTCPPortsIntercepted(ports []uint16) {
 			}
 		}
 	}
+	if len(ranges) > 0 {
+		inPorts := f
+		f = func(p uint16) bool {
+			if inPorts(p) {
+				return true
+			}
+			for _, r := range ranges {
+				if r.Contains(p) {
+					return true
+				}
+			}
+			return false
+		}
+	}
 	b.shouldInterceptTCPPortAtomic.Store(f)
 }
 
 // setAtomicValuesFromPrefsLocked populates sshAtomicBool, containsViaIPFuncAtomic
-// and shouldInterceptTCPPortAtomic from the prefs p, which may be !Valid().
+// and shouldInterceptTCPPortAtomic, and the upstream proxy override, from the
+// prefs p, which may be !Valid().
 func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	b.sshAtomicBool.Store(p.Valid() && p.RunSSH() && envknob.CanSSHD())
+	b.setProxyOverrideLocked(p)
+	updateMaintenanceHealth(p, b.netMap)
 
 	if !p.Valid() {
 		b.containsViaIPFuncAtomic.Store(tsaddr.NewContainsIPFunc(nil))
-		b.setTCPPortsIntercepted(nil)
+		b.setTCPPortsIntercepted(nil, nil)
 		b.lastServeConfJSON = mem.B(nil)
 		b.serveConfig = ipn.ServeConfigView{}
 	} else {
@@ -2237,6 +2480,36 @@ func (b *LocalBackend) setAtomicValuesFromPrefsLocked(p ipn.PrefsView) {
 	}
 }
 
+// setProxyOverrideLocked makes control, logging and DERP traffic use the
+// upstream proxy in the ProxyURL pref of p, which may be !Valid(), or the
+// environment's proxy if it's empty. Existing connections are unaffected.
+//
+// The override is process-wide, so it also applies to any other
+// LocalBackend in the process; see tshttpproxy.SetOverride.
+func (b *LocalBackend) setProxyOverrideLocked(p ipn.PrefsView) {
+	var s string
+	if p.Valid() {
+		s = p.ProxyURL()
+	}
+	if s == b.proxyURL {
+		return
+	}
+	b.proxyURL = s
+	if s == "" {
+		b.logf("proxy: using the environment's proxy, if any")
+		tshttpproxy.SetOverride(nil)
+		return
+	}
+	u, err := tshttpproxy.ParseProxyURL(s)
+	if err != nil {
+		b.logf("proxy: ignoring invalid ProxyURL pref: %v", err)
+		tshttpproxy.SetOverride(nil)
+		return
+	}
+	b.logf("proxy: using %s", u.Redacted())
+	tshttpproxy.SetOverride(u)
+}
+
 // State returns the backend state machine's current state.
 func (b *LocalBackend) State() ipn.State {
 	b.mu.Lock()
@@ -2498,9 +2771,35 @@ func (b *LocalBackend) checkPrefsLocked(p *ipn.Prefs) error {
 	if err := b.checkExitNodePrefsLocked(p); err != nil {
 		errs = append(errs, err)
 	}
+	if err := checkRemoteAdminPref(p); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, checkNetworkPrefs(p)...)
 	return multierr.New(errs...)
 }
 
+// checkNetworkPrefs returns the errors of the prefs in p that configure
+// this node's networking and name, each naming the Prefs field at fault.
+func checkNetworkPrefs(p *ipn.Prefs) (errs []error) {
+	if p.Hostname != "" && dnsname.SanitizeHostname(p.Hostname) == "" {
+		errs = append(errs, fmt.Errorf("invalid Hostname %q: it has no letters or digits", p.Hostname))
+	}
+	if p.ProxyURL != "" {
+		if _, err := tshttpproxy.ParseProxyURL(p.ProxyURL); err != nil {
+			errs = append(errs, fmt.Errorf("invalid ProxyURL %q: %w", p.ProxyURL, err))
+		}
+	}
+	switch p.NetfilterMode {
+	case preftype.NetfilterOff, preftype.NetfilterNoDivert, preftype.NetfilterOn:
+	default:
+		errs = append(errs, fmt.Errorf("invalid NetfilterMode %d; want off, nodivert or on", p.NetfilterMode))
+	}
+	if p.RouteMetric != 0 && runtime.GOOS != "linux" && runtime.GOOS != "windows" {
+		errs = append(errs, fmt.Errorf("RouteMetric is not supported on %s", runtime.GOOS))
+	}
+	return errs
+}
+
 func (b *LocalBackend) checkSSHPrefsLocked(p *ipn.Prefs) error {
 	if !p.RunSSH {
 		return nil
@@ -2620,6 +2919,24 @@ func (b *LocalBackend) EditPrefs(mp *ipn.MaskedPrefs) (ipn.PrefsView, error) {
 	return stripKeysFromPrefs(newPrefs), nil
 }
 
+// PreviewEditPrefs reports the changes that EditPrefs(mp) would make to
+// the current prefs, without applying them. It returns the same
+// validation errors as EditPrefs.
+func (b *LocalBackend) PreviewEditPrefs(mp *ipn.MaskedPrefs) ([]ipn.PrefChange, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p0 := b.pm.CurrentPrefs().AsStruct()
+	p1 := p0.Clone()
+	p1.ApplyEdits(mp)
+	if err := b.checkPrefsLocked(p1); err != nil {
+		return nil, err
+	}
+	if p1.RunSSH && !envknob.CanSSHD() {
+		return nil, errors.New("Tailscale SSH server administratively disabled.")
+	}
+	return p0.Changes(p1), nil
+}
+
 func (b *LocalBackend) checkProfileNameLocked(p *ipn.Prefs) error {
 	if p.ProfileName == "" {
 		// It is always okay to clear the profile name.
@@ -2820,11 +3137,15 @@ func (b *LocalBackend) doSetHostinfoFilterServices(hi *tailcfg.Hostinfo) {
 	if b.egg {
 		peerAPIServices = append(peerAPIServices, tailcfg.Service{Proto: "egg", Port: 1})
 	}
+	postureAttrs := b.postureAttrs
+	dnsDomains := b.dnsServerDomains
 	b.mu.Unlock()
 
 	// Make a shallow copy of hostinfo so we can mutate
 	// at the Service field.
 	hi2 := *hi // shallow copy
+	hi2.PostureAttributes = postureAttrs
+	hi2.DNSDomains = dnsDomains
 	if !b.shouldUploadServices() {
 		hi2.Services = []tailcfg.Service{}
 	}
@@ -2843,6 +3164,18 @@ func (b *LocalBackend) NetMap() *netmap.NetworkMap {
 	return b.netMap
 }
 
+// ControlBackoff returns the schedules the control client retries failed
+// requests to the control server on, or nil if there's no control client.
+func (b *LocalBackend) ControlBackoff() []backoff.State {
+	b.mu.Lock()
+	cc := b.cc
+	b.mu.Unlock()
+	if cc == nil {
+		return nil
+	}
+	return cc.BackoffState()
+}
+
 func (b *LocalBackend) isEngineBlocked() bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -2871,6 +3204,7 @@ func (b *LocalBackend) authReconfig() {
 	hasPAC := b.prevIfState.HasPAC()
 	disableSubnetsIfPAC := nm != nil && nm.Debug != nil && nm.Debug.DisableSubnetsIfPAC.EqualBool(true)
 	b.mu.Unlock()
+	prefs = b.captivePortalPrefs(prefs)
 
 	if blocked {
 		b.logf("[v1] authReconfig: blocked, skipping.")
@@ -2908,11 +3242,17 @@ func (b *LocalBackend) authReconfig() {
 		b.dialer.SetExitDNSDoH("")
 	}
 
-	cfg, err := nmcfg.WGCfg(nm, b.logf, flags, prefs.ExitNodeID())
+	cfg, err := nmcfg.WGCfg(nm, b.logf, flags, prefs.ExitNodeID(), prefs.RejectRoutes().AsSlice(), prefs.KeepAlivePeers().AsSlice())
 	if err != nil {
 		b.logf("wgcfg: %v", err)
 		return
 	}
+	b.usage.SetRoutes(usageRoutes(nm, cfg))
+	b.setRecordUsage(prefs.RecordUsage())
+	b.setExitRateLimit(prefs.ExitRateLimit())
+	if mc, err := b.magicConn(); err == nil {
+		mc.SetPowerSaveForced(prefs.PowerSave())
+	}
 
 	oneCGNATRoute := shouldUseOneCGNATRoute(nm, b.logf, version.OS())
 	rcfg := b.routerConfig(cfg, prefs, oneCGNATRoute)
@@ -3132,6 +3472,73 @@ func (b *LocalBackend) SetLogFlusher(flushFunc func()) {
 	b.logFlushFunc = flushFunc
 }
 
+// ControlStatus describes the state of a LocalBackend's connection to
+// the control server.
+type ControlStatus struct {
+	// Connected is whether a network map has been received since the
+	// last error from the control server. If false and LastErr is
+	// non-nil, the control client is retrying.
+	Connected bool
+
+	// LastNetMap is when the most recent network map was received, or
+	// the zero time if none has been.
+	LastNetMap time.Time
+
+	// LastErr is the most recent error communicating with the control
+	// server, or nil if there hasn't been one since the last network
+	// map. LastErrTime is when it happened.
+	LastErr     error
+	LastErrTime time.Time
+}
+
+// ControlStatus returns the state of the connection to the control server.
+func (b *LocalBackend) ControlStatus() ControlStatus {
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+	return ControlStatus{
+		Connected:   b.controlErr == nil && !b.lastNetMapTime.IsZero(),
+		LastNetMap:  b.lastNetMapTime,
+		LastErr:     b.controlErr,
+		LastErrTime: b.controlErrTime,
+	}
+}
+
+// noteControlStatus records the parts of st reported by ControlStatus.
+func (b *LocalBackend) noteControlStatus(st controlclient.Status) {
+	b.controlMu.Lock()
+	defer b.controlMu.Unlock()
+	now := time.Now()
+	if st.Err != nil {
+		b.controlErr = st.Err
+		b.controlErrTime = now
+		return
+	}
+	if st.NetMap != nil {
+		b.lastNetMapTime = now
+		b.controlErr = nil
+	}
+}
+
+// SetApp sets the app name and version reported in Hostinfo, overriding
+// the process-wide value from hostinfo.SetApp. It lets multiple tsnet
+// servers in one process identify themselves separately.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetApp(name, version string) {
+	b.app = name
+	b.appVersion = version
+}
+
+// applyAppToHostinfo sets the Hostinfo app fields from SetApp, if set.
+func (b *LocalBackend) applyAppToHostinfo(hi *tailcfg.Hostinfo) {
+	if b.app != "" {
+		hi.App = b.app
+	}
+	if b.appVersion != "" {
+		hi.AppVersion = b.appVersion
+	}
+}
+
 // TryFlushLogs calls the log flush function. It returns false if a log flush
 // function was never initialized with SetLogFlusher.
 //
@@ -3372,6 +3779,42 @@ func peerRoutes(logf logger.Logf, peers []wgcfg.Peer, cgnatThreshold int) (route
 	return routes
 }
 
+// killSwitchEnabled reports whether the kill switch should block traffic
+// that isn't over Tailscale, which it does while an exit node is wanted
+// and either prefs or the "KillSwitch" system policy ask for it.
+func killSwitchEnabled(prefs ipn.PrefsView) bool {
+	if !prefs.Valid() || !prefs.WantRunning() {
+		return false
+	}
+	if prefs.ExitNodeID() == "" && !prefs.ExitNodeIP().IsValid() {
+		return false
+	}
+	return prefs.KillSwitch() || winutil.GetPolicyString("KillSwitch", "") == "always"
+}
+
+// downRouterConfig returns the router config to use while the tunnel is
+// down. It's empty, unless the kill switch is enabled, in which case it
+// only blocks traffic that isn't over Tailscale or to allowed local
+// networks.
+func (b *LocalBackend) downRouterConfig(prefs ipn.PrefsView) *router.Config {
+	prefs = b.captivePortalPrefs(prefs)
+	rc := &router.Config{}
+	if !killSwitchEnabled(prefs) {
+		return rc
+	}
+	rc.KillSwitch = true
+	internalIPs, externalIPs, err := internalAndExternalInterfaces()
+	if err != nil {
+		b.logf("failed to discover interface ips: %v", err)
+	}
+	rc.LocalRoutes = internalIPs
+	if prefs.ExitNodeAllowLANAccess() {
+		rc.LocalRoutes = append(rc.LocalRoutes, externalIPs...)
+		rc.LocalRoutes = append(rc.LocalRoutes, unmapIPPrefixes(prefs.ExitNodeAllowLANCIDRs().AsSlice())...)
+	}
+	return rc
+}
+
 // routerConfig produces a router.Config from a wireguard config and IPN prefs.
 func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneCGNATRoute bool) *router.Config {
 	singleRouteThreshold := 10_000
@@ -3385,6 +3828,8 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 		SNATSubnetRoutes: !prefs.NoSNAT(),
 		NetfilterMode:    prefs.NetfilterMode(),
 		Routes:           peerRoutes(b.logf, cfg.Peers, singleRouteThreshold),
+		KillSwitch:       killSwitchEnabled(prefs),
+		RouteMetric:      prefs.RouteMetric(),
 	}
 
 	if distro.Get() == distro.Synology {
@@ -3425,6 +3870,7 @@ func (b *LocalBackend) routerConfig(cfg *wgcfg.Config, prefs ipn.PrefsView, oneC
 			rs.LocalRoutes = internalIPs // unconditionally allow access to guest VM networks
 			if prefs.ExitNodeAllowLANAccess() {
 				rs.LocalRoutes = append(rs.LocalRoutes, externalIPs...)
+				rs.LocalRoutes = append(rs.LocalRoutes, unmapIPPrefixes(prefs.ExitNodeAllowLANCIDRs().AsSlice())...)
 			} else {
 				// Explicitly add routes to the local network so that we do not
 				// leak any traffic.
@@ -3462,6 +3908,7 @@ func (b *LocalBackend) applyPrefsToHostinfoLocked(hi *tailcfg.Hostinfo, prefs ip
 	hi.RoutableIPs = prefs.AdvertiseRoutes().AsSlice()
 	hi.RequestTags = prefs.AdvertiseTags().AsSlice()
 	hi.ShieldsUp = prefs.ShieldsUp()
+	hi.Maintenance = prefs.Maintenance()
 
 	var sshHostKeys []string
 	if prefs.RunSSH() && envknob.CanSSHD() {
@@ -3528,7 +3975,7 @@ func (b *LocalBackend) enterStateLockedOnEntry(newState ipn.State) {
 		b.blockEngineUpdates(true)
 		fallthrough
 	case ipn.Stopped:
-		err := b.e.Reconfig(&wgcfg.Config{}, &router.Config{}, &dns.Config{}, nil)
+		err := b.e.Reconfig(&wgcfg.Config{}, b.downRouterConfig(prefs), &dns.Config{}, nil)
 		if err != nil {
 			b.logf("Reconfig(down): %v", err)
 		}
@@ -3657,7 +4104,10 @@ func (b *LocalBackend) stateMachine() {
 // a status update that predates the "I've shut down" update.
 func (b *LocalBackend) stopEngineAndWait() {
 	b.logf("stopEngineAndWait...")
-	b.e.Reconfig(&wgcfg.Config{}, &router.Config{}, &dns.Config{}, nil)
+	b.mu.Lock()
+	prefs := b.pm.CurrentPrefs()
+	b.mu.Unlock()
+	b.e.Reconfig(&wgcfg.Config{}, b.downRouterConfig(prefs), &dns.Config{}, nil)
 	b.requestEngineStatusAndWait()
 	b.logf("stopEngineAndWait: done.")
 }
@@ -3696,6 +4146,10 @@ func (b *LocalBackend) resetControlClientLockedAsync() {
 		// will abort.
 		b.numClientStatusCalls.Add(1)
 	}
+	if b.keyExpiryWarnTimer != nil {
+		b.keyExpiryWarnTimer.Stop()
+		b.keyExpiryWarnTimer = nil
+	}
 
 	go b.cc.Shutdown()
 	b.cc = nil
@@ -3790,8 +4244,13 @@ func (b *LocalBackend) assertClientLocked() {
 }
 
 // setNetInfo sets b.hostinfo.NetInfo to ni, and passes ni along to the
-// controlclient, if one exists.
+// controlclient, if one exists. It also notes whether ni reports a captive
+// portal.
 func (b *LocalBackend) setNetInfo(ni *tailcfg.NetInfo) {
+	if captive, ok := ni.CaptivePortal.Get(); ok {
+		b.setCaptivePortal(captive)
+	}
+
 	b.mu.Lock()
 	cc := b.cc
 	b.mu.Unlock()
@@ -3865,12 +4324,14 @@ func (b *LocalBackend) setNetMapLocked(nm *netmap.NetworkMap) {
 	b.capFileSharing = fs
 
 	b.setDebugLogsByCapabilityLocked(nm)
+	b.runCapabilityHandlersLocked(nm)
 
 	// See the netns package for documentation on what this capability does.
 	netns.SetBindToInterfaceByRoute(hasCapability(nm, tailcfg.CapabilityBindToInterfaceByRoute))
 	netns.SetDisableBindConnToInterface(hasCapability(nm, tailcfg.CapabilityDebugDisableBindConnToInterface))
 
 	b.setTCPPortsInterceptedFromNetmapAndPrefsLocked(b.pm.CurrentPrefs())
+	updateMaintenanceHealth(b.pm.CurrentPrefs(), nm)
 	if nm == nil {
 		b.nodeByAddr = nil
 		return
@@ -3953,6 +4414,7 @@ func (b *LocalBackend) reloadServeConfigLocked(prefs ipn.PrefsView) {
 // b.mu must be held.
 func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.PrefsView) {
 	handlePorts := make([]uint16, 0, 4)
+	var handleRanges []tailcfg.PortRange
 
 	if prefs.Valid() && prefs.RunSSH() && envknob.CanSSHD() {
 		handlePorts = append(handlePorts, 22)
@@ -3968,6 +4430,10 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 			return true
 		})
 		handlePorts = append(handlePorts, servePorts...)
+		rs := b.serveConfig.TCPRanges()
+		for i := 0; i < rs.Len(); i++ {
+			handleRanges = append(handleRanges, rs.At(i).Ports)
+		}
 
 		b.setServeProxyHandlersLocked()
 
@@ -3983,7 +4449,7 @@ func (b *LocalBackend) setTCPPortsInterceptedFromNetmapAndPrefsLocked(prefs ipn.
 		go b.doSetHostinfoFilterServices(b.hostinfo.Clone())
 	}
 
-	b.setTCPPortsIntercepted(handlePorts)
+	b.setTCPPortsIntercepted(handlePorts, handleRanges)
 }
 
 // setServeProxyHandlersLocked ensures there is an http proxy handler for each
@@ -4335,6 +4801,42 @@ func (b *LocalBackend) CheckIPForwarding() error {
 	return warn
 }
 
+// IPForwardingReport returns whether the system is configured to forward
+// packets as a subnet router or exit node, and which sysctls need
+// enabling if not.
+func (b *LocalBackend) IPForwardingReport() (*ipnstate.IPForwardingReport, error) {
+	r := new(ipnstate.IPForwardingReport)
+	if err := b.CheckIPForwarding(); err != nil {
+		r.Warning = err.Error()
+	}
+	if wgengine.IsNetstackRouter(b.e) {
+		return r, nil
+	}
+	var err error
+	r.DisabledSysctls, err = netutil.DisabledIPForwardingSysctls(tsaddr.ExitRoutes(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// FixIPForwarding enables the sysctls reported by IPForwardingReport,
+// until reboot, and returns the report afterwards.
+func (b *LocalBackend) FixIPForwarding() (*ipnstate.IPForwardingReport, error) {
+	r, err := b.IPForwardingReport()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.DisabledSysctls) == 0 {
+		return r, nil
+	}
+	if err := netutil.EnableIPForwardingSysctls(r.DisabledSysctls); err != nil {
+		return nil, err
+	}
+	b.logf("enabled IP forwarding: %v", r.DisabledSysctls)
+	return b.IPForwardingReport()
+}
+
 // DERPMap returns the current DERPMap in use, or nil if not connected.
 func (b *LocalBackend) DERPMap() *tailcfg.DERPMap {
 	b.mu.Lock()
@@ -4480,6 +4982,125 @@ func (b *LocalBackend) magicConn() (*magicsock.Conn, error) {
 	return mc, nil
 }
 
+// setExitRateLimit makes the TUN device limit the traffic forwarded off
+// the tailnet for each peer to bytesPerSec, or not at all if zero.
+func (b *LocalBackend) setExitRateLimit(bytesPerSec uint64) {
+	b.mu.Lock()
+	changed := b.exitRateLimit != bytesPerSec
+	b.exitRateLimit = bytesPerSec
+	b.mu.Unlock()
+	if !changed {
+		return
+	}
+	ig, ok := b.e.(wgengine.InternalsGetter)
+	if !ok {
+		return
+	}
+	tunWrap, _, _, ok := ig.GetInternals()
+	if !ok {
+		return
+	}
+	var l *exitlimit.Limiter
+	if bytesPerSec != 0 {
+		l = exitlimit.New(bytesPerSec)
+		b.logf("limiting forwarded traffic to %d bytes/sec per peer", bytesPerSec)
+	}
+	tunWrap.SetExitRateLimiter(l)
+}
+
+// setRecordUsage starts or stops counting the traffic through the TUN
+// device for the stored daily per-peer totals. Stopping adds what was
+// counted since the last flush to the totals.
+func (b *LocalBackend) setRecordUsage(on bool) {
+	b.mu.Lock()
+	if b.shutdownCalled {
+		on = false
+	}
+	old := b.usageStats
+	if on == (old != nil) {
+		b.mu.Unlock()
+		return
+	}
+	var stats *connstats.Statistics
+	if on {
+		stats = connstats.NewStatistics(usageFlushInterval, usageMaxConns, b.recordUsage)
+	}
+	b.usageStats = stats
+	b.mu.Unlock()
+
+	if ig, ok := b.e.(wgengine.InternalsGetter); ok {
+		if tunWrap, _, _, ok := ig.GetInternals(); ok {
+			tunWrap.SetUsageStatistics(stats)
+		}
+	}
+	if old != nil {
+		// Shutdown dumps the remaining counts to b.recordUsage, which
+		// acquires b.mu, so b.mu must not be held here.
+		old.Shutdown(context.Background())
+	}
+}
+
+// SetObserveRoutes starts or stops observing which subnets the traffic
+// through this node flows to and from, for SuggestedRoutes. Starting
+// discards anything observed previously.
+func (b *LocalBackend) SetObserveRoutes(on bool) error {
+	ig, ok := b.e.(wgengine.InternalsGetter)
+	if !ok {
+		return errors.New("engine isn't InternalsGetter")
+	}
+	tunWrap, _, _, ok := ig.GetInternals()
+	if !ok {
+		return errors.New("failed to get internals")
+	}
+	var obs *routeobs.Observer
+	if on {
+		obs = new(routeobs.Observer)
+	}
+	b.mu.Lock()
+	b.routeObs = obs
+	b.mu.Unlock()
+	tunWrap.SetRouteObserver(obs)
+	return nil
+}
+
+// SuggestedRoutes returns the subnets that traffic was observed to or
+// from since SetObserveRoutes(true), busiest first, along with the
+// advertised route covering each, if any.
+func (b *LocalBackend) SuggestedRoutes() *apitype.SuggestedRoutesResponse {
+	b.mu.Lock()
+	obs := b.routeObs
+	advertised := b.pm.CurrentPrefs().AdvertiseRoutes()
+	b.mu.Unlock()
+
+	res := &apitype.SuggestedRoutesResponse{Observing: obs != nil}
+	if obs == nil {
+		return res
+	}
+	for pfx, cnts := range obs.Snapshot() {
+		sr := apitype.SuggestedRoute{
+			Route:    pfx,
+			Packets:  cnts.Packets,
+			Bytes:    cnts.Bytes,
+			LastSeen: cnts.LastSeen,
+		}
+		for i := 0; i < advertised.Len(); i++ {
+			if r := advertised.At(i); r.Bits() <= pfx.Bits() && r.Contains(pfx.Addr()) {
+				sr.CoveredBy = r
+				break
+			}
+		}
+		res.Routes = append(res.Routes, sr)
+	}
+	sort.Slice(res.Routes, func(i, j int) bool {
+		ri, rj := res.Routes[i], res.Routes[j]
+		if ri.Bytes != rj.Bytes {
+			return ri.Bytes > rj.Bytes
+		}
+		return ri.Route.String() < rj.Route.String()
+	})
+	return res
+}
+
 type keyProvingNoiseRoundTripper struct {
 	b *LocalBackend
 }
@@ -4573,7 +5194,7 @@ func (b *LocalBackend) sshServerOrInit() (_ SSHServer, err error) {
 	return b.sshServer, nil
 }
 
-var warnSSHSELinux = health.NewWarnable()
+var warnSSHSELinux = health.NewWarnable(health.WithCode("ssh-selinux"))
 
 func checkSELinux() {
 	if runtime.GOOS != "linux" {
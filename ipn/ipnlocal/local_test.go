@@ -10,6 +10,7 @@
 	"net/http"
 	"net/netip"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
@@ -870,3 +871,123 @@ func TestWatchNotificationsCallbacks(t *testing.T) {
 		t.Fatalf("unexpected number of watchers in new LocalBackend, want: 0 got: %v", len(b.notifyWatchers))
 	}
 }
+
+func TestControlStatus(t *testing.T) {
+	b := new(LocalBackend)
+	if st := b.ControlStatus(); st.Connected || st.LastErr != nil || !st.LastNetMap.IsZero() {
+		t.Fatalf("initial ControlStatus = %+v; want zero", st)
+	}
+
+	b.noteControlStatus(controlclient.Status{NetMap: new(netmap.NetworkMap)})
+	st := b.ControlStatus()
+	if !st.Connected || st.LastNetMap.IsZero() {
+		t.Fatalf("after netmap, ControlStatus = %+v; want connected", st)
+	}
+	lastNetMap := st.LastNetMap
+
+	errControl := fmt.Errorf("control unreachable")
+	b.noteControlStatus(controlclient.Status{Err: errControl})
+	st = b.ControlStatus()
+	if st.Connected || st.LastErr != errControl || st.LastErrTime.IsZero() || st.LastNetMap != lastNetMap {
+		t.Fatalf("after error, ControlStatus = %+v; want retrying with previous netmap time", st)
+	}
+
+	b.noteControlStatus(controlclient.Status{NetMap: new(netmap.NetworkMap)})
+	if st := b.ControlStatus(); !st.Connected || st.LastErr != nil {
+		t.Fatalf("after recovery, ControlStatus = %+v; want connected", st)
+	}
+}
+
+func TestCheckNetworkPrefs(t *testing.T) {
+	tests := []struct {
+		name    string
+		edit    func(*ipn.Prefs)
+		wantErr string // substring, or empty for no error
+	}{
+		{"defaults", func(p *ipn.Prefs) {}, ""},
+		{"hostname", func(p *ipn.Prefs) { p.Hostname = "my host.local" }, ""},
+		{"bad-hostname", func(p *ipn.Prefs) { p.Hostname = "..." }, `invalid Hostname "..."`},
+		{"proxy", func(p *ipn.Prefs) { p.ProxyURL = "socks5://proxy:1080" }, ""},
+		{"bad-proxy", func(p *ipn.Prefs) { p.ProxyURL = "ftp://proxy" }, `invalid ProxyURL "ftp://proxy"`},
+		{"bad-netfilter-mode", func(p *ipn.Prefs) { p.NetfilterMode = 7 }, "invalid NetfilterMode 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := ipn.NewPrefs()
+			tt.edit(p)
+			errs := checkNetworkPrefs(p)
+			if tt.wantErr == "" {
+				if len(errs) != 0 {
+					t.Fatalf("unexpected errors: %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), tt.wantErr) {
+				t.Fatalf("errors = %v; want one containing %q", errs, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthorizeProxyClient(t *testing.T) {
+	eng, err := wgengine.NewFakeUserspaceEngine(logger.Discard, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(eng.Close)
+
+	self := &tailcfg.Node{ID: 1, User: 10, Addresses: ipps("100.64.0.1")}
+	alice := &tailcfg.Node{ID: 2, User: 10, Addresses: ipps("100.64.0.2")}
+	bob := &tailcfg.Node{ID: 3, User: 20, Addresses: ipps("100.64.0.3")}
+	carol := &tailcfg.Node{ID: 4, User: 30, Addresses: ipps("100.64.0.4")}
+	b := &LocalBackend{
+		e:    eng,
+		logf: t.Logf,
+		nodeByAddr: map[netip.Addr]*tailcfg.Node{
+			netip.MustParseAddr("100.64.0.2"): alice,
+			netip.MustParseAddr("100.64.0.3"): bob,
+			netip.MustParseAddr("100.64.0.4"): carol,
+		},
+		netMap: &netmap.NetworkMap{
+			SelfNode:  self,
+			Addresses: self.Addresses,
+			UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+				10: {ID: 10, LoginName: "alice@example.com"},
+				20: {ID: 20, LoginName: "bob@example.com"},
+				30: {ID: 30, LoginName: "carol@example.com"},
+			},
+		},
+	}
+	var localNets netipx.IPSetBuilder
+	localNets.AddPrefix(netip.MustParsePrefix("100.64.0.1/32"))
+	localNetsSet, _ := localNets.IPSet()
+	b.filterAtomic.Store(filter.New([]filter.Match{{
+		Srcs: ipps("100.64.0.4"),
+		Caps: []filter.CapMatch{{
+			Dst: netip.MustParsePrefix("100.64.0.1/32"),
+			Cap: tailcfg.CapabilityOutboundProxy,
+		}},
+	}}, localNetsSet, nil, nil, logger.Discard))
+
+	// A tailnet connection from bob, forwarded to the proxy by netstack.
+	eng.RegisterIPPortIdentity(netip.MustParseAddrPort("127.0.0.1:5555"), netip.MustParseAddr("100.64.0.3"))
+
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"100.64.0.2:1234", true},  // same user
+		{"100.64.0.3:1234", false}, // other user
+		{"100.64.0.4:1234", true},  // granted the capability
+		{"100.64.0.9:1234", false}, // unknown tailnet IP
+		{"127.0.0.1:5555", false},  // bob, via netstack
+		{"127.0.0.1:6666", true},   // local client
+		{"192.168.1.5:1234", true}, // LAN client
+	}
+	for _, tt := range tests {
+		err := b.AuthorizeProxyClient(netip.MustParseAddrPort(tt.src))
+		if got := err == nil; got != tt.want {
+			t.Errorf("AuthorizeProxyClient(%v) = %v; want allowed=%v", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+var (
+	warnMaintenance         = health.NewWarnable(health.WithCode("maintenance"))
+	warnExitNodeMaintenance = health.NewWarnable(health.WithCode("exit-node-maintenance"))
+)
+
+var errMaintenance = errors.New("this node is in maintenance mode; peers avoid routing through it")
+
+// updateMaintenanceHealth updates the health warnings for this node being
+// in maintenance, per prefs (which may be !Valid), and for its exit node
+// being in maintenance, per nm (which may be nil).
+func updateMaintenanceHealth(prefs ipn.PrefsView, nm *netmap.NetworkMap) {
+	if prefs.Valid() && prefs.Maintenance() {
+		warnMaintenance.Set(errMaintenance)
+	} else {
+		warnMaintenance.Set(nil)
+	}
+	if exit := maintenanceExitNode(prefs, nm); exit != nil {
+		warnExitNodeMaintenance.Set(fmt.Errorf("exit node %s is in maintenance; consider using another", exit.Name))
+	} else {
+		warnExitNodeMaintenance.Set(nil)
+	}
+}
+
+// maintenanceExitNode returns the exit node selected in prefs if it's in
+// maintenance, or nil otherwise.
+func maintenanceExitNode(prefs ipn.PrefsView, nm *netmap.NetworkMap) *tailcfg.Node {
+	if !prefs.Valid() || prefs.ExitNodeID() == "" || nm == nil {
+		return nil
+	}
+	for _, p := range nm.Peers {
+		if p.StableID == prefs.ExitNodeID() {
+			if inMaintenance(p) {
+				return p
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// inMaintenance reports whether the peer n advertises that it's in
+// maintenance.
+func inMaintenance(n *tailcfg.Node) bool {
+	return n.Hostinfo.Valid() && n.Hostinfo.Maintenance()
+}
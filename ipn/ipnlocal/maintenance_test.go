@@ -0,0 +1,46 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestMaintenanceExitNode(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{StableID: "busy", Name: "busy.", Hostinfo: (&tailcfg.Hostinfo{Maintenance: true}).View()},
+			{StableID: "ok", Name: "ok.", Hostinfo: (&tailcfg.Hostinfo{}).View()},
+		},
+	}
+	tests := []struct {
+		name   string
+		exitID tailcfg.StableNodeID
+		want   tailcfg.StableNodeID
+	}{
+		{"none", "", ""},
+		{"in_maintenance", "busy", "busy"},
+		{"not_in_maintenance", "ok", ""},
+		{"unknown", "gone", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := &ipn.Prefs{ExitNodeID: tt.exitID}
+			var got tailcfg.StableNodeID
+			if n := maintenanceExitNode(prefs.View(), nm); n != nil {
+				got = n.StableID
+			}
+			if got != tt.want {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+	if n := maintenanceExitNode(ipn.PrefsView{}, nm); n != nil {
+		t.Errorf("invalid prefs: got %v; want nil", n.StableID)
+	}
+}
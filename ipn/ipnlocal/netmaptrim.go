@@ -0,0 +1,127 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"strings"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/filter"
+)
+
+// Knobs that trim the peers of the netmap from control before the rest of
+// tailscaled sees it, to bound its memory and CPU usage on small devices
+// in tailnets of thousands of nodes. A trimmed peer is unreachable, and
+// not in status or MagicDNS.
+var (
+	// netmapPeerTags is a comma-separated list of ACL tags. If set, only
+	// the peers with one of them are kept (or that pass
+	// netmapInboundPeersOnly).
+	netmapPeerTags = envknob.RegisterString("TS_NETMAP_PEER_TAGS")
+
+	// netmapInboundPeersOnly, if true, keeps only the peers that the
+	// packet filter lets connect to this node (or that pass
+	// netmapPeerTags). It suits servers that never connect out to peers.
+	netmapInboundPeersOnly = envknob.RegisterBool("TS_NETMAP_INBOUND_PEERS_ONLY")
+
+	// netmapStalePeerAge, if positive, drops the peers that control says
+	// have been offline for longer than that.
+	netmapStalePeerAge = envknob.RegisterDuration("TS_NETMAP_STALE_PEER_AGE")
+)
+
+// netmapTrimOpts are the criteria of trimNetmap.
+type netmapTrimOpts struct {
+	tags         []string
+	inboundOnly  bool
+	stalePeerAge time.Duration
+}
+
+func netmapTrimOptsFromEnv() netmapTrimOpts {
+	var o netmapTrimOpts
+	if v := netmapPeerTags(); v != "" {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				o.tags = append(o.tags, tag)
+			}
+		}
+	}
+	o.inboundOnly = netmapInboundPeersOnly()
+	o.stalePeerAge = netmapStalePeerAge()
+	return o
+}
+
+func (o netmapTrimOpts) isZero() bool {
+	return len(o.tags) == 0 && !o.inboundOnly && o.stalePeerAge <= 0
+}
+
+// trimNetmapLocked removes the peers of nm that don't pass the
+// TS_NETMAP_* knobs.
+//
+// b.mu must be held.
+func (b *LocalBackend) trimNetmapLocked(nm *netmap.NetworkMap, prefs ipn.PrefsView) {
+	o := netmapTrimOptsFromEnv()
+	if o.isZero() {
+		return
+	}
+	before := len(nm.Peers)
+	trimNetmap(nm, o, prefs, time.Now())
+	if n := before - len(nm.Peers); n > 0 {
+		b.logf("[v1] netmap: trimmed %d of %d peers", n, before)
+	}
+}
+
+// trimNetmap removes the peers of nm that don't pass o, as of now, keeping
+// the ones that prefs needs: the exit node, and subnet routers if routes
+// are accepted.
+func trimNetmap(nm *netmap.NetworkMap, o netmapTrimOpts, prefs ipn.PrefsView, now time.Time) {
+	exitNodeID := prefs.ExitNodeID()
+	routeAll := prefs.RouteAll()
+	// nm.Peers is ordered, so deletion must be order-preserving. It
+	// may be shared with the control client's map session state, so
+	// make a new slice.
+	peers := make([]*tailcfg.Node, 0, len(nm.Peers))
+	for _, p := range nm.Peers {
+		if (exitNodeID != "" && p.StableID == exitNodeID) ||
+			(routeAll && len(p.PrimaryRoutes) > 0) ||
+			o.keepPeer(p, nm.PacketFilter, now) {
+			peers = append(peers, p)
+		}
+	}
+	nm.Peers = peers
+}
+
+// keepPeer reports whether p passes o, given the packet filter filt, as
+// of now.
+func (o netmapTrimOpts) keepPeer(p *tailcfg.Node, filt []filter.Match, now time.Time) bool {
+	if o.stalePeerAge > 0 && p.Online != nil && !*p.Online &&
+		p.LastSeen != nil && now.Sub(*p.LastSeen) > o.stalePeerAge {
+		return false
+	}
+	if len(o.tags) == 0 && !o.inboundOnly {
+		return true
+	}
+	for _, tag := range p.Tags {
+		for _, want := range o.tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	if o.inboundOnly {
+		for _, m := range filt {
+			for _, src := range m.Srcs {
+				for _, addr := range p.Addresses {
+					if src.Overlaps(addr) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/ptr"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestTrimNetmap(t *testing.T) {
+	now := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+	peer := func(id tailcfg.StableNodeID, ip string, mod func(*tailcfg.Node)) *tailcfg.Node {
+		n := &tailcfg.Node{
+			StableID:  id,
+			Addresses: []netip.Prefix{netip.MustParsePrefix(ip + "/32")},
+		}
+		if mod != nil {
+			mod(n)
+		}
+		return n
+	}
+	newNetmap := func() *netmap.NetworkMap {
+		return &netmap.NetworkMap{
+			Peers: []*tailcfg.Node{
+				peer("tagged", "100.64.0.1", func(n *tailcfg.Node) { n.Tags = []string{"tag:server"} }),
+				peer("inbound", "100.64.0.2", nil),
+				peer("stale", "100.64.0.3", func(n *tailcfg.Node) {
+					n.Tags = []string{"tag:server"}
+					n.Online = ptr.To(false)
+					n.LastSeen = ptr.To(now.Add(-48 * time.Hour))
+				}),
+				peer("exit", "100.64.0.4", nil),
+				peer("router", "100.64.0.5", func(n *tailcfg.Node) {
+					n.PrimaryRoutes = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}
+				}),
+				peer("other", "100.64.0.6", nil),
+			},
+			PacketFilter: []filter.Match{{
+				Srcs: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+			}},
+		}
+	}
+	prefs := ipn.NewPrefs()
+	prefs.ExitNodeID = "exit"
+
+	tests := []struct {
+		name      string
+		opts      netmapTrimOpts
+		routeAll  bool
+		wantPeers []tailcfg.StableNodeID
+	}{
+		{
+			name:      "tags",
+			opts:      netmapTrimOpts{tags: []string{"tag:server"}},
+			wantPeers: []tailcfg.StableNodeID{"tagged", "stale", "exit"},
+		},
+		{
+			name:      "inbound",
+			opts:      netmapTrimOpts{inboundOnly: true},
+			wantPeers: []tailcfg.StableNodeID{"inbound", "exit"},
+		},
+		{
+			name:      "tags-or-inbound",
+			opts:      netmapTrimOpts{tags: []string{"tag:server"}, inboundOnly: true},
+			wantPeers: []tailcfg.StableNodeID{"tagged", "inbound", "stale", "exit"},
+		},
+		{
+			name:      "stale",
+			opts:      netmapTrimOpts{stalePeerAge: 24 * time.Hour},
+			wantPeers: []tailcfg.StableNodeID{"tagged", "inbound", "exit", "router", "other"},
+		},
+		{
+			name:      "not-stale-yet",
+			opts:      netmapTrimOpts{stalePeerAge: 72 * time.Hour},
+			wantPeers: []tailcfg.StableNodeID{"tagged", "inbound", "stale", "exit", "router", "other"},
+		},
+		{
+			name:      "route-all-keeps-routers",
+			opts:      netmapTrimOpts{tags: []string{"tag:none"}},
+			routeAll:  true,
+			wantPeers: []tailcfg.StableNodeID{"exit", "router"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nm := newNetmap()
+			orig := nm.Peers
+			prefs.RouteAll = tt.routeAll
+			trimNetmap(nm, tt.opts, prefs.View(), now)
+			var got []tailcfg.StableNodeID
+			for _, p := range nm.Peers {
+				got = append(got, p.StableID)
+			}
+			if !reflect.DeepEqual(got, tt.wantPeers) {
+				t.Errorf("peers = %v; want %v", got, tt.wantPeers)
+			}
+			if len(orig) != 6 || orig[1].StableID != "inbound" {
+				t.Error("original peers slice modified")
+			}
+		})
+	}
+}
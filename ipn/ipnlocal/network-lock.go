@@ -640,6 +640,46 @@ func (b *LocalBackend) NetworkLockSign(nodeKey key.NodePublic, rotationPublic []
 	return nil
 }
 
+// NetworkLockSubmitSignature transmits sig, a node-key signature made
+// elsewhere, such as by a hardware token holding a trusted tailnet lock
+// key, to the control plane. The signature must verify against the
+// current authority.
+func (b *LocalBackend) NetworkLockSubmitSignature(sig tkatype.MarshaledSignature) error {
+	ourNodeKey, err := func() (key.NodePublic, error) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if b.tka == nil {
+			return key.NodePublic{}, errNetworkLockNotActive
+		}
+		var decoded tka.NodeKeySignature
+		if err := decoded.Unserialize(sig); err != nil {
+			return key.NodePublic{}, fmt.Errorf("decoding signature: %w", err)
+		}
+		var nodeKey key.NodePublic
+		if err := nodeKey.UnmarshalBinary(decoded.Pubkey); err != nil {
+			return key.NodePublic{}, fmt.Errorf("decoding signed node-key: %w", err)
+		}
+		if err := b.tka.authority.NodeKeyAuthorized(nodeKey, sig); err != nil {
+			return key.NodePublic{}, fmt.Errorf("signature does not verify: %w", err)
+		}
+		p := b.pm.CurrentPrefs()
+		if !p.Valid() || !p.Persist().Valid() || p.Persist().PrivateNodeKey().IsZero() {
+			return key.NodePublic{}, errors.New("no node-key: is tailscale logged in?")
+		}
+		return p.Persist().PublicNodeKey(), nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	b.logf("Submitting externally-made network-lock signature to control plane")
+	if _, err := b.tkaSubmitSignature(ourNodeKey, sig); err != nil {
+		return err
+	}
+	return nil
+}
+
 // NetworkLockModify adds and/or removes keys in the tailnet's key authority.
 func (b *LocalBackend) NetworkLockModify(addKeys, removeKeys []tka.Key) (err error) {
 	defer func() {
@@ -779,6 +779,96 @@ func TestTKASign(t *testing.T) {
 	}
 }
 
+func TestTKASubmitSignature(t *testing.T) {
+	envknob.Setenv("TAILSCALE_USE_WIP_CODE", "1")
+	defer envknob.Setenv("TAILSCALE_USE_WIP_CODE", "")
+	nodePriv := key.NewNode()
+	toSign := key.NewNode()
+	nlPriv := key.NewNLPrivate()
+	hwPriv := key.NewNLPrivate() // as if held by a hardware token
+
+	pm := must.Get(newProfileManager(new(mem.Store), t.Logf))
+	must.Do(pm.SetPrefs((&ipn.Prefs{
+		Persist: &persist.Persist{
+			PrivateNodeKey: nodePriv,
+			NetworkLockKey: nlPriv,
+		},
+	}).View()))
+
+	temp := t.TempDir()
+	tkaPath := filepath.Join(temp, "tka-profile", string(pm.CurrentProfile().ID))
+	os.Mkdir(tkaPath, 0755)
+	chonk, err := tka.ChonkDir(tkaPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authority, _, err := tka.Create(chonk, tka.State{
+		Keys: []tka.Key{
+			{Kind: tka.Key25519, Public: nlPriv.Public().Verifier(), Votes: 1},
+			{Kind: tka.Key25519, Public: hwPriv.Public().Verifier(), Votes: 1},
+		},
+		DisablementSecrets: [][]byte{tka.DisablementKDF(bytes.Repeat([]byte{0xa5}, 32))},
+	}, nlPriv)
+	if err != nil {
+		t.Fatalf("tka.Create() failed: %v", err)
+	}
+
+	var submitted int
+	ts, client := fakeNoiseServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		if r.URL.Path != "/machine/tka/sign" {
+			t.Errorf("unhandled endpoint path: %v", r.URL.Path)
+			w.WriteHeader(404)
+			return
+		}
+		body := new(tailcfg.TKASubmitSignatureRequest)
+		if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+			t.Fatal(err)
+		}
+		if body.NodeKey != nodePriv.Public() {
+			t.Errorf("nodeKey = %v, want %v", body.NodeKey, nodePriv.Public())
+		}
+		submitted++
+		w.WriteHeader(200)
+		if err := json.NewEncoder(w).Encode(tailcfg.TKASubmitSignatureResponse{}); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	defer ts.Close()
+	cc := fakeControlClient(t, client)
+	b := LocalBackend{
+		varRoot: temp,
+		cc:      cc,
+		ccAuto:  cc,
+		logf:    t.Logf,
+		tka: &tkaState{
+			authority: authority,
+			storage:   chonk,
+		},
+		pm:    pm,
+		store: pm.Store(),
+	}
+
+	sign := func(signer key.NLPrivate) tkatype.MarshaledSignature {
+		sig := tka.NodeKeySignature{
+			SigKind: tka.SigDirect,
+			KeyID:   signer.KeyID(),
+			Pubkey:  must.Get(toSign.Public().MarshalBinary()),
+		}
+		sig.Signature = must.Get(signer.SignNKS(sig.SigHash()))
+		return sig.Serialize()
+	}
+	if err := b.NetworkLockSubmitSignature(sign(hwPriv)); err != nil {
+		t.Errorf("NetworkLockSubmitSignature() failed: %v", err)
+	}
+	if err := b.NetworkLockSubmitSignature(sign(key.NewNLPrivate())); err == nil {
+		t.Error("NetworkLockSubmitSignature() with an untrusted key succeeded")
+	}
+	if submitted != 1 {
+		t.Errorf("submitted %d signatures; want 1", submitted)
+	}
+}
+
 func TestTKAForceDisable(t *testing.T) {
 	envknob.Setenv("TAILSCALE_USE_WIP_CODE", "1")
 	defer envknob.Setenv("TAILSCALE_USE_WIP_CODE", "")
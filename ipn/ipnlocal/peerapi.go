@@ -609,6 +609,10 @@ func (h *peerAPIHandler) validateHost(r *http.Request) error {
 	if r.Host == "peer" {
 		return nil
 	}
+	if r.Host == apitype.LocalAPIHost && strings.HasPrefix(r.URL.Path, "/localapi/") {
+		// A LocalClient administering this node remotely.
+		return nil
+	}
 	ap, err := netip.ParseAddrPort(r.Host)
 	if err != nil {
 		return err
@@ -680,6 +684,14 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.handlePeerPut(w, r)
 		return
 	}
+	if strings.HasPrefix(r.URL.Path, peerAPIAppPrefix) {
+		h.handleServeApp(w, r)
+		return
+	}
+	if strings.HasPrefix(r.URL.Path, "/localapi/") {
+		h.handleServeLocalAPI(w, r)
+		return
+	}
 	if strings.HasPrefix(r.URL.Path, "/dns-query") {
 		metricDNSCalls.Add(1)
 		h.handleDNSQuery(w, r)
@@ -717,6 +729,16 @@ func (h *peerAPIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		metricIngressCalls.Add(1)
 		h.handleServeIngress(w, r)
 		return
+	case "/v0/services":
+		h.handleServeServices(w, r)
+		return
+	case "/v0/speedtest":
+		h.handleServeSpeedTest(w, r)
+		return
+	case "/v0/latency":
+		metricLatencyCalls.Add(1)
+		h.handleServeLatency(w, r)
+		return
 	}
 	who := h.peerUser.DisplayName
 	fmt.Fprintf(w, `<html>
@@ -835,6 +857,21 @@ func (h *peerAPIHandler) handleServeInterfaces(w http.ResponseWriter, r *http.Re
 	fmt.Fprintln(w, "</table>")
 }
 
+// handleServeServices serves the service records set with
+// LocalBackend.SetServiceRecords. Any peer can read them.
+func (h *peerAPIHandler) handleServeServices(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "GET required", http.StatusMethodNotAllowed)
+		return
+	}
+	recs := h.ps.b.ServiceRecords()
+	if recs == nil {
+		recs = []apitype.ServiceRecord{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(recs)
+}
+
 func (h *peerAPIHandler) handleServeDoctor(w http.ResponseWriter, r *http.Request) {
 	if !h.canDebug() {
 		http.Error(w, "denied; no debug access", http.StatusForbidden)
@@ -1252,10 +1289,7 @@ func (h *peerAPIHandler) handleWakeOnLAN(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "failed to get interfaces state", http.StatusInternalServerError)
 		return
 	}
-	var res struct {
-		SentTo []string
-		Errors []string
-	}
+	var res apitype.WakeOnLANResponse
 	for ifName, ips := range st.InterfaceIPs {
 		for _, ip := range ips {
 			if ip.Addr().IsLoopback() || ip.Addr().Is6() {
@@ -1551,4 +1585,7 @@ func (fl *fakePeerAPIListener) Addr() net.Addr { return fl.addr }
 	metricDNSCalls       = clientmetric.NewCounter("peerapi_dns")
 	metricWakeOnLANCalls = clientmetric.NewCounter("peerapi_wol")
 	metricIngressCalls   = clientmetric.NewCounter("peerapi_ingress")
+	metricAppCalls       = clientmetric.NewCounter("peerapi_app")
+	metricSpeedTestCalls = clientmetric.NewCounter("peerapi_speedtest")
+	metricLatencyCalls   = clientmetric.NewCounter("peerapi_latency")
 )
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/util/mak"
+)
+
+// peerAPIAppPrefix is the PeerAPI path prefix under which the
+// application handlers are served, as "/v0/app/<name>/...".
+const peerAPIAppPrefix = "/v0/app/"
+
+// peerAPIAppNameRx matches valid PeerAPI application names.
+var peerAPIAppNameRx = regexp.MustCompile(`^[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// peerAPIAppHandlers are the application handlers registered with
+// RegisterPeerAPIApp, keyed by name.
+var peerAPIAppHandlers map[string]http.Handler
+
+// checkPeerAPIAppName reports whether name is a valid PeerAPI
+// application name.
+func checkPeerAPIAppName(name string) error {
+	if !peerAPIAppNameRx.MatchString(name) {
+		return fmt.Errorf("invalid peerapi app name %q; want lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// RegisterPeerAPIApp registers h to serve the PeerAPI requests under
+// "/v0/app/<name>/" of every LocalBackend, for daemon extensions that
+// provide tailnet-internal APIs. It must be called before the
+// LocalBackend is created, typically from an init func. It panics if
+// name is invalid or already registered.
+//
+// See SetPeerAPIApp for how requests are passed to h.
+func RegisterPeerAPIApp(name string, h http.Handler) {
+	if err := checkPeerAPIAppName(name); err != nil {
+		panic(err)
+	}
+	if h == nil {
+		panic("nil peerapi app handler")
+	}
+	if _, ok := peerAPIAppHandlers[name]; ok {
+		panic(fmt.Sprintf("peerapi app %q already registered", name))
+	}
+	mak.Set(&peerAPIAppHandlers, name, h)
+}
+
+// SetPeerAPIApp sets h to serve this node's PeerAPI requests under
+// "/v0/app/<name>/", replacing any handler previously set for name. A
+// nil h removes the handler. Handlers registered with RegisterPeerAPIApp
+// can't be replaced.
+//
+// Requests are only passed to h from peers permitted by the tailnet
+// policy to reach this node, with the "/v0/app/<name>" prefix removed
+// from the URL path. The identity of the peer making the request is
+// available from PeerAPIAppCaller.
+func (b *LocalBackend) SetPeerAPIApp(name string, h http.Handler) error {
+	if err := checkPeerAPIAppName(name); err != nil {
+		return err
+	}
+	if _, ok := peerAPIAppHandlers[name]; ok {
+		return fmt.Errorf("peerapi app %q is registered by tailscaled", name)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if h == nil {
+		delete(b.peerAPIApps, name)
+		return nil
+	}
+	mak.Set(&b.peerAPIApps, name, h)
+	return nil
+}
+
+// peerAPIApp returns the handler of the PeerAPI application name, if any.
+func (b *LocalBackend) peerAPIApp(name string) (http.Handler, bool) {
+	if h, ok := peerAPIAppHandlers[name]; ok {
+		return h, true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.peerAPIApps[name]
+	return h, ok
+}
+
+// PeerAPIAppURL returns the base URL of the PeerAPI application name
+// of the peer with the Tailscale IP ip. Requests to it should be sent
+// with Dialer().PeerAPITransport().
+func (b *LocalBackend) PeerAPIAppURL(ip netip.Addr, name string) (string, error) {
+	if err := checkPeerAPIAppName(name); err != nil {
+		return "", err
+	}
+	nm := b.NetMap()
+	if nm == nil {
+		return "", errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return "", fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return "", fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID, ip)
+	}
+	return base + peerAPIAppPrefix + name, nil
+}
+
+type peerAPIAppCallerKey struct{}
+
+// PeerAPIAppCaller returns the peer making the PeerAPI request whose
+// context is ctx, as passed to the handlers of SetPeerAPIApp and
+// RegisterPeerAPIApp. Caps are the capabilities the tailnet policy
+// grants the peer to this node.
+func PeerAPIAppCaller(ctx context.Context) (_ *apitype.WhoIsResponse, ok bool) {
+	who, ok := ctx.Value(peerAPIAppCallerKey{}).(*apitype.WhoIsResponse)
+	return who, ok
+}
+
+// handleServeApp serves the requests to the PeerAPI application
+// handlers, under peerAPIAppPrefix.
+func (h *peerAPIHandler) handleServeApp(w http.ResponseWriter, r *http.Request) {
+	name, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, peerAPIAppPrefix), "/")
+	app, ok := h.ps.b.peerAPIApp(name)
+	if !ok {
+		http.Error(w, "unknown peerapi app", http.StatusNotFound)
+		return
+	}
+	metricAppCalls.Add(1)
+	peerUser := h.peerUser
+	who := &apitype.WhoIsResponse{
+		Node:        h.peerNode,
+		UserProfile: &peerUser,
+		Caps:        h.ps.b.PeerCaps(h.remoteAddr.Addr()),
+	}
+	r2 := r.Clone(context.WithValue(r.Context(), peerAPIAppCallerKey{}, who))
+	r2.URL.Path = "/" + rest
+	r2.URL.RawPath = ""
+	app.ServeHTTP(w, r2)
+}
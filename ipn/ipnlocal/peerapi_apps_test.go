@@ -0,0 +1,95 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+)
+
+func TestCheckPeerAPIAppName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"inventory", false},
+		{"remote-cmd", false},
+		{"v2", false},
+		{"", true},
+		{"Inventory", true},
+		{"-foo", true},
+		{"foo-", true},
+		{"foo/bar", true},
+		{"foo.bar", true},
+	}
+	for _, tt := range tests {
+		err := checkPeerAPIAppName(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkPeerAPIAppName(%q) = %v; wantErr %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPeerAPIApp(t *testing.T) {
+	selfNode := &tailcfg.Node{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("100.100.100.101/32")},
+	}
+	lb := &LocalBackend{
+		logf:   logger.Discard,
+		netMap: &netmap.NetworkMap{SelfNode: selfNode},
+	}
+	ph := &peerAPIHandler{
+		ps:         &peerAPIServer{b: lb},
+		selfNode:   selfNode,
+		peerNode:   &tailcfg.Node{ComputedName: "some-peer-name"},
+		peerUser:   tailcfg.UserProfile{LoginName: "alice@example.com"},
+		remoteAddr: netip.MustParseAddrPort("100.100.100.102:12345"),
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		ph.ServeHTTP(rr, httptest.NewRequest("GET", "http://100.100.100.101:12345"+path, nil))
+		return rr
+	}
+
+	if rr := serve("/v0/app/inventory/items"); rr.Code != http.StatusNotFound {
+		t.Errorf("unset app: got status %v; want 404", rr.Code)
+	}
+	if err := lb.SetPeerAPIApp("inventory", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		who, ok := PeerAPIAppCaller(r.Context())
+		if !ok {
+			http.Error(w, "no caller", 500)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %s", r.URL.Path, who.Node.ComputedName, who.UserProfile.LoginName)
+	})); err != nil {
+		t.Fatal(err)
+	}
+	rr := serve("/v0/app/inventory/items")
+	if got, want := rr.Body.String(), "/items some-peer-name alice@example.com"; rr.Code != 200 || got != want {
+		t.Errorf("got %v %q; want 200 %q", rr.Code, got, want)
+	}
+	if rr := serve("/v0/app/inventory"); rr.Body.String() != "/ some-peer-name alice@example.com" {
+		t.Errorf("app root: got %v %q", rr.Code, rr.Body.String())
+	}
+	if rr := serve("/v0/app/other/items"); rr.Code != http.StatusNotFound {
+		t.Errorf("other app: got status %v; want 404", rr.Code)
+	}
+
+	if err := lb.SetPeerAPIApp("inventory", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rr := serve("/v0/app/inventory/items"); rr.Code != http.StatusNotFound {
+		t.Errorf("removed app: got status %v; want 404", rr.Code)
+	}
+	if err := lb.SetPeerAPIApp("Bad/Name", http.NotFoundHandler()); err == nil {
+		t.Error("SetPeerAPIApp with invalid name succeeded")
+	}
+}
@@ -432,6 +432,41 @@ func TestHandlePeerAPI(t *testing.T) {
 				httpStatus(403),
 			),
 		},
+		{
+			name:   "speedtest_not_owner",
+			isSelf: false,
+			req:    httptest.NewRequest("POST", "/v0/speedtest", nil),
+			checks: checks(
+				httpStatus(403),
+				bodyContains("no speedtest access"),
+			),
+		},
+		{
+			name:   "speedtest_no_upgrade",
+			isSelf: true,
+			req:    httptest.NewRequest("POST", "/v0/speedtest", nil),
+			checks: checks(
+				httpStatus(400),
+			),
+		},
+		{
+			name:   "latency_not_owner",
+			isSelf: false,
+			req:    httptest.NewRequest("POST", "/v0/latency", nil),
+			checks: checks(
+				httpStatus(403),
+				bodyContains("no latency access"),
+			),
+		},
+		{
+			name:   "latency_bad_ip",
+			isSelf: true,
+			req:    httptest.NewRequest("POST", "/v0/latency?ip=foo", nil),
+			checks: checks(
+				httpStatus(400),
+				bodyContains(`invalid IP "foo"`),
+			),
+		},
 		{
 			name:     "host-val/peer",
 			isSelf:   true,
@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sort"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn"
+	"tailscale.com/net/dnsfallback"
+	"tailscale.com/util/winutil"
+)
+
+// policySettings are the system policy settings that LocalBackend reads.
+// KillSwitch, BootstrapDNSServers and the automatic update settings apply
+// as they change; the others only set the defaults of new profiles.
+var policySettings = []string{
+	"AllowIncomingConnections",
+	"AutoUpdateWindow",
+	"BootstrapDNSServers",
+	"ExitNodeIP",
+	"InstallUpdates",
+	"KillSwitch",
+	"LoginURL",
+	"UnattendedMode",
+}
+
+// readPolicySettings returns the current values of the policySettings.
+func readPolicySettings() map[string]string {
+	m := make(map[string]string, len(policySettings))
+	for _, name := range policySettings {
+		m[name] = winutil.GetPolicyString(name, "")
+	}
+	return m
+}
+
+// changedPolicySettings returns the sorted names of the settings whose
+// values differ between old and new.
+func changedPolicySettings(old, new map[string]string) []string {
+	var changed []string
+	for name, v := range new {
+		if ov, ok := old[name]; !ok || ov != v {
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// policyWatchLoop applies the system policy changes that a sysadmin pushes
+// (with GPO or MDM) while tailscaled runs, until b.ctx is done.
+func (b *LocalBackend) policyWatchLoop() {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	settings := readPolicySettings()
+	b.mu.Lock()
+	b.policySettings = settings
+	b.mu.Unlock()
+	b.setBootstrapDNSFromPolicy(settings["BootstrapDNSServers"])
+	err := winutil.WatchPolicyChanges(b.ctx, b.onPolicyChange)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		b.logf("not watching for system policy changes: %v", err)
+	}
+}
+
+// onPolicyChange checks which system policy settings changed, applies them
+// and tells the IPN bus watchers about them.
+func (b *LocalBackend) onPolicyChange() {
+	settings := readPolicySettings()
+	b.mu.Lock()
+	changed := changedPolicySettings(b.policySettings, settings)
+	b.policySettings = settings
+	b.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	b.logf("system policy settings changed: %v", changed)
+	if slices.Contains(changed, "KillSwitch") {
+		b.authReconfig()
+	}
+	if slices.Contains(changed, "BootstrapDNSServers") {
+		b.setBootstrapDNSFromPolicy(settings["BootstrapDNSServers"])
+	}
+	b.send(ipn.Notify{PolicyChanged: changed})
+}
+
+// setBootstrapDNSFromPolicy sets the DNS servers that dnsfallback tries
+// when the system DNS fails from the BootstrapDNSServers policy value v,
+// a comma-separated list of IPs or IP:ports. If v is empty, the
+// TS_DNSFALLBACK_SERVERS environment variable applies instead.
+func (b *LocalBackend) setBootstrapDNSFromPolicy(v string) {
+	if v == "" {
+		dnsfallback.SetBootstrapServers(nil)
+		return
+	}
+	servers, err := dnsfallback.ParseBootstrapServers(v)
+	if err != nil {
+		b.logf("BootstrapDNSServers policy: %v", err)
+		return
+	}
+	dnsfallback.SetBootstrapServers(servers)
+}
@@ -0,0 +1,28 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedPolicySettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new map[string]string
+		want     []string
+	}{
+		{"none", map[string]string{"KillSwitch": ""}, map[string]string{"KillSwitch": ""}, nil},
+		{"set", map[string]string{"KillSwitch": "", "LoginURL": ""}, map[string]string{"KillSwitch": "always", "LoginURL": ""}, []string{"KillSwitch"}},
+		{"several", map[string]string{"KillSwitch": "always", "LoginURL": "a"}, map[string]string{"KillSwitch": "", "LoginURL": "b"}, []string{"KillSwitch", "LoginURL"}},
+		{"added", nil, map[string]string{"UnattendedMode": "always"}, []string{"UnattendedMode"}},
+		{"removed", map[string]string{"ExitNodeIP": "100.64.0.1"}, nil, []string{"ExitNodeIP"}},
+	}
+	for _, tt := range tests {
+		if got := changedPolicySettings(tt.old, tt.new); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"time"
+
+	"golang.org/x/exp/maps"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/hostinfo/posture"
+	"tailscale.com/util/mak"
+)
+
+// postureCollectInterval is how often the device posture attributes are
+// collected.
+const postureCollectInterval = time.Hour
+
+// postureLoop periodically collects the device posture attributes, until
+// b.ctx is done. It returns immediately if no posture collector is
+// registered.
+func (b *LocalBackend) postureLoop() {
+	if !posture.HaveCollectors() {
+		return
+	}
+	t := time.NewTicker(postureCollectInterval)
+	defer t.Stop()
+	for {
+		b.collectPosture()
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// collectPosture runs the posture collectors and, if the attributes they
+// collected changed, sends them to control.
+func (b *LocalBackend) collectPosture() {
+	attrs, errs := posture.Collect(b.ctx)
+	var errStrs map[string]string
+	for name, err := range errs {
+		b.logf("posture collector %q: %v", name, err)
+		mak.Set(&errStrs, name, err.Error())
+	}
+
+	b.mu.Lock()
+	changed := !maps.Equal(b.postureAttrs, attrs)
+	b.postureAttrs = attrs
+	b.postureErrs = errStrs
+	b.postureCollected = time.Now()
+	hi := b.hostinfo
+	b.mu.Unlock()
+
+	if changed && hi != nil {
+		b.logf("posture attributes changed; sending %d to control", len(attrs))
+		b.doSetHostinfoFilterServices(hi)
+	}
+}
+
+// Posture returns the device posture attributes reported to control.
+func (b *LocalBackend) Posture() *apitype.PostureResponse {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return &apitype.PostureResponse{
+		Attributes: b.postureAttrs,
+		Errors:     b.postureErrs,
+		Collected:  b.postureCollected,
+	}
+}
@@ -323,7 +323,7 @@ func (pm *profileManager) setAsUserSelectedProfileLocked() error {
 func (pm *profileManager) loadSavedPrefs(key ipn.StateKey) (ipn.PrefsView, error) {
 	bs, err := pm.store.ReadState(key)
 	if err == ipn.ErrStateNotExist || len(bs) == 0 {
-		return defaultPrefs, nil
+		return defaultPrefs(), nil
 	}
 	if err != nil {
 		return ipn.PrefsView{}, err
@@ -413,13 +413,14 @@ func (pm *profileManager) writeKnownProfiles() error {
 func (pm *profileManager) NewProfile() {
 	metricNewProfile.Add(1)
 
-	pm.prefs = defaultPrefs
+	pm.prefs = defaultPrefs()
 	pm.isNewProfile = true
 	pm.currentProfile = &ipn.LoginProfile{}
 }
 
-// defaultPrefs is the default prefs for a new profile.
-var defaultPrefs = func() ipn.PrefsView {
+// defaultPrefs returns the default prefs for a new profile, as set by the
+// system policy.
+func defaultPrefs() ipn.PrefsView {
 	prefs := ipn.NewPrefs()
 	prefs.WantRunning = false
 
@@ -437,7 +438,7 @@ func (pm *profileManager) NewProfile() {
 	prefs.ForceDaemon = winutil.GetPolicyString("UnattendedMode", "") == "always"
 
 	return prefs.View()
-}()
+}
 
 // Store returns the StateStore used by the ProfileManager.
 func (pm *profileManager) Store() ipn.StateStore {
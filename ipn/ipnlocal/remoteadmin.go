@@ -0,0 +1,91 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+)
+
+// newRemoteAdminHandlerFunc returns the LocalAPI handler of b for a
+// remote administrator, permitted to write if permitWrite.
+type newRemoteAdminHandlerFunc func(b *LocalBackend, logf logger.Logf, logID string, permitWrite bool) http.Handler
+
+var newRemoteAdminHandler newRemoteAdminHandlerFunc // or nil
+
+// RegisterNewRemoteAdminHandler lets the ipn/localapi package register
+// the LocalAPI handler that serves remote administrators over the
+// PeerAPI, which ipnlocal can't import.
+func RegisterNewRemoteAdminHandler(fn newRemoteAdminHandlerFunc) {
+	newRemoteAdminHandler = fn
+}
+
+func checkRemoteAdminPref(p *ipn.Prefs) error {
+	switch p.RemoteAdmin {
+	case "", ipn.RemoteAdminRead, ipn.RemoteAdminWrite:
+		return nil
+	}
+	return fmt.Errorf("invalid RemoteAdmin %q; want %q, %q or empty", p.RemoteAdmin, ipn.RemoteAdminRead, ipn.RemoteAdminWrite)
+}
+
+// remoteAdminAccess reports whether h's peer may read, and write, this
+// node's LocalAPI. The node must allow it with the RemoteAdmin pref, and
+// the peer must be a tagged node, such as a fleet's admin servers,
+// granted the corresponding capability by the tailnet policy.
+func (h *peerAPIHandler) remoteAdminAccess() (read, write bool) {
+	mode := h.ps.b.Prefs().RemoteAdmin()
+	if mode == "" || len(h.peerNode.Tags) == 0 {
+		return false, false
+	}
+	write = mode == ipn.RemoteAdminWrite && h.peerHasCap(tailcfg.CapabilityRemoteAdminWrite)
+	read = write || h.peerHasCap(tailcfg.CapabilityRemoteAdminRead) || h.peerHasCap(tailcfg.CapabilityRemoteAdminWrite)
+	return read, write
+}
+
+// handleServeLocalAPI serves this node's LocalAPI to a remote
+// administrator, with the access granted by remoteAdminAccess.
+func (h *peerAPIHandler) handleServeLocalAPI(w http.ResponseWriter, r *http.Request) {
+	read, write := h.remoteAdminAccess()
+	if !read {
+		h.logf("remote-admin: denied %s %s from %v", r.Method, r.URL.Path, h.remoteAddr)
+		http.Error(w, "denied; remote administration not permitted", http.StatusForbidden)
+		return
+	}
+	if newRemoteAdminHandler == nil {
+		http.Error(w, "remote administration not supported", http.StatusNotImplemented)
+		return
+	}
+	// Every remote request is logged, as an audit trail.
+	h.logf("remote-admin: %s %s from %v (%s, write=%v)", r.Method, r.URL.Path, h.remoteAddr, h.peerNode.ComputedName, write)
+	r2 := r.Clone(r.Context())
+	r2.Host = apitype.LocalAPIHost
+	newRemoteAdminHandler(h.ps.b, h.logf, h.ps.b.backendLogID, write).ServeHTTP(w, r2)
+}
+
+// PeerAPIHostPort returns the "ip:port" address of the PeerAPI of the
+// peer with the Tailscale IP ip, over which a LocalClient can reach the
+// peer's LocalAPI if the peer permits this node to administer it.
+func (b *LocalBackend) PeerAPIHostPort(ip netip.Addr) (string, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return "", errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return "", fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return "", fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID, ip)
+	}
+	return strings.TrimPrefix(base, "http://"), nil
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"go4.org/netipx"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/util/must"
+	"tailscale.com/wgengine"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestRemoteAdmin(t *testing.T) {
+	old := newRemoteAdminHandler
+	defer func() { newRemoteAdminHandler = old }()
+	RegisterNewRemoteAdminHandler(func(b *LocalBackend, logf logger.Logf, logID string, permitWrite bool) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s write=%v", r.URL.Path, permitWrite)
+		})
+	})
+
+	self := netip.MustParsePrefix("100.100.100.101/32")
+	peerIP := netip.MustParseAddr("100.100.100.102")
+	tests := []struct {
+		name  string
+		pref  string
+		tags  []string
+		caps  []string
+		host  string
+		want  string // response body, or empty if denied
+		wantC int
+	}{
+		{
+			name:  "not_allowed",
+			tags:  []string{"tag:admin"},
+			caps:  []string{tailcfg.CapabilityRemoteAdminWrite},
+			wantC: http.StatusForbidden,
+		},
+		{
+			name:  "untagged_peer",
+			pref:  ipn.RemoteAdminWrite,
+			caps:  []string{tailcfg.CapabilityRemoteAdminWrite},
+			wantC: http.StatusForbidden,
+		},
+		{
+			name:  "no_cap",
+			pref:  ipn.RemoteAdminWrite,
+			tags:  []string{"tag:admin"},
+			wantC: http.StatusForbidden,
+		},
+		{
+			name:  "read",
+			pref:  ipn.RemoteAdminWrite,
+			tags:  []string{"tag:admin"},
+			caps:  []string{tailcfg.CapabilityRemoteAdminRead},
+			want:  "/localapi/v0/status write=false",
+			wantC: http.StatusOK,
+		},
+		{
+			name:  "write_cap_read_pref",
+			pref:  ipn.RemoteAdminRead,
+			tags:  []string{"tag:admin"},
+			caps:  []string{tailcfg.CapabilityRemoteAdminWrite},
+			want:  "/localapi/v0/status write=false",
+			wantC: http.StatusOK,
+		},
+		{
+			name:  "write",
+			pref:  ipn.RemoteAdminWrite,
+			tags:  []string{"tag:admin"},
+			caps:  []string{tailcfg.CapabilityRemoteAdminWrite},
+			host:  apitype.LocalAPIHost,
+			want:  "/localapi/v0/status write=true",
+			wantC: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selfNode := &tailcfg.Node{Addresses: []netip.Prefix{self}}
+			eng, _ := wgengine.NewFakeUserspaceEngine(logger.Discard, 0)
+			pm := must.Get(newProfileManager(new(mem.Store), t.Logf))
+			pm.SetPrefs((&ipn.Prefs{RemoteAdmin: tt.pref}).View())
+			lb := &LocalBackend{
+				logf:   t.Logf,
+				e:      eng,
+				pm:     pm,
+				store:  pm.Store(),
+				netMap: &netmap.NetworkMap{SelfNode: selfNode, Addresses: selfNode.Addresses},
+			}
+			matches := must.Get(filter.MatchesFromFilterRules([]tailcfg.FilterRule{{
+				SrcIPs:   []string{peerIP.String()},
+				CapGrant: []tailcfg.CapGrant{{Dsts: []netip.Prefix{self}, Caps: tt.caps}},
+			}}))
+			var b netipx.IPSetBuilder
+			b.AddPrefix(self)
+			localNets := must.Get(b.IPSet())
+			lb.setFilter(filter.New(matches, localNets, localNets, nil, logger.Discard))
+
+			h := &peerAPIHandler{
+				remoteAddr: netip.AddrPortFrom(peerIP, 12345),
+				selfNode:   selfNode,
+				peerNode:   &tailcfg.Node{ComputedName: "admin", Tags: tt.tags},
+				ps:         &peerAPIServer{b: lb},
+			}
+			req := httptest.NewRequest("GET", "/localapi/v0/status", nil)
+			req.Host = "100.100.100.101:12345"
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantC {
+				t.Fatalf("status = %d; want %d (body %q)", rr.Code, tt.wantC, rr.Body.String())
+			}
+			if tt.want != "" && rr.Body.String() != tt.want {
+				t.Errorf("body = %q; want %q", rr.Body.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+// ResolveRoute explains how this node routes traffic to ip: to itself, a
+// peer, a subnet router or an exit node, or outside the tunnel, and why.
+func (b *LocalBackend) ResolveRoute(ip netip.Addr) (*ipnstate.DebugRouteReport, error) {
+	internal, external, err := internalAndExternalInterfaces()
+	if err != nil {
+		b.logf("resolve-route: failed to discover interface ips: %v", err)
+	}
+	b.mu.Lock()
+	nm := b.netMap
+	prefs := b.pm.CurrentPrefs()
+	b.mu.Unlock()
+	return resolveRoute(nm, prefs, internal, external, ip)
+}
+
+// resolveRoute is ResolveRoute with the netmap, the prefs and the
+// prefixes of the host's internal (such as VM guest) and external
+// networks, as returned by internalAndExternalInterfaces.
+func resolveRoute(nm *netmap.NetworkMap, prefs ipn.PrefsView, internal, external []netip.Prefix, ip netip.Addr) (*ipnstate.DebugRouteReport, error) {
+	if nm == nil {
+		return nil, errors.New("no netmap; is Tailscale running?")
+	}
+	ip = ip.Unmap()
+	rep := &ipnstate.DebugRouteReport{IP: ip}
+	because := func(format string, args ...any) {
+		rep.Reasons = append(rep.Reasons, fmt.Sprintf(format, args...))
+	}
+	via := func(how string, peer *tailcfg.Node, route netip.Prefix) *ipnstate.DebugRouteReport {
+		rep.Via = how
+		rep.Route = route
+		if peer != nil {
+			rep.Peer = peerDisplayName(peer)
+			explainFilter(rep, nm, prefs, ip)
+		}
+		return rep
+	}
+
+	switch {
+	case ip.IsLoopback():
+		because("%v is a loopback address, which never leaves the host", ip)
+		return via("local", nil, netip.Prefix{}), nil
+	case ip == tsaddr.TailscaleServiceIP() || ip == tsaddr.TailscaleServiceIPv6():
+		because("%v is Tailscale's service IP, for MagicDNS, handled by this node", ip)
+		return via("local", nil, netip.Prefix{}), nil
+	}
+	for _, a := range nm.Addresses {
+		if a.Addr() == ip {
+			because("%v is this node's Tailscale IP", ip)
+			return via("local", nil, a), nil
+		}
+	}
+	if peer, ok := nm.PeerByTailscaleIP(ip); ok {
+		because("%v is the Tailscale IP of %s", ip, peerDisplayName(peer))
+		switch {
+		case peer.Expired:
+			because("the peer's node key has expired, so it can't be reached")
+		case peer.Online != nil && !*peer.Online:
+			because("control says the peer is offline")
+		}
+		return via("peer", peer, netip.PrefixFrom(ip, ip.BitLen())), nil
+	}
+	if tsaddr.IsTailscaleIP(ip) {
+		because("%v is in the Tailscale range, but no peer visible to this node has it", ip)
+		because("the tunnel routes the whole range, so the traffic is dropped")
+		return via("drop", nil, netip.Prefix{}), nil
+	}
+
+	// The most specific LAN prefix containing ip, if any.
+	var lan netip.Prefix
+	lanInternal := false
+	for i, pfxs := range [][]netip.Prefix{internal, external} {
+		for _, p := range pfxs {
+			if p.Contains(ip) && (!lan.IsValid() || p.Bits() > lan.Bits()) {
+				lan, lanInternal = p, i == 0
+			}
+		}
+	}
+
+	if peer, route, ok := resolveSubnetRoute(nm, prefs, ip, because); ok {
+		if lan.IsValid() && lan.Bits() >= route.Bits() {
+			because("but this host's local network %v is at least as specific, so the OS sends it there", lan)
+			return via("lan", nil, lan), nil
+		}
+		return via("subnet", peer, route), nil
+	}
+
+	if exitID, exitIP := prefs.ExitNodeID(), prefs.ExitNodeIP(); !exitID.IsZero() || exitIP.IsValid() {
+		exit, ok := nm.PeerWithStableID(exitID)
+		if !ok && exitIP.IsValid() {
+			exit, ok = nm.PeerByTailscaleIP(exitIP)
+		}
+		if !ok {
+			because("--exit-node is set, but that node isn't in the netmap")
+			because("traffic that would use the exit node is dropped rather than leaked outside the tunnel")
+			return via("drop", nil, netip.Prefix{}), nil
+		}
+		switch {
+		case lan.IsValid() && lanInternal:
+			because("%v is on this host's internal network %v, which is always reachable with an exit node", ip, lan)
+			return via("lan", nil, lan), nil
+		case lan.IsValid() && prefs.ExitNodeAllowLANAccess():
+			because("%v is on this host's local network %v, and --exit-node-allow-lan-access is on", ip, lan)
+			return via("lan", nil, lan), nil
+		}
+		for _, p := range prefs.ExitNodeAllowLANCIDRs().AsSlice() {
+			if p.Contains(ip) {
+				because("%v is within %v of --exit-node-allow-lan-cidrs", ip, p)
+				return via("lan", nil, p), nil
+			}
+		}
+		if lan.IsValid() {
+			because("%v is on this host's local network %v, but --exit-node-allow-lan-access is off", ip, lan)
+		}
+		def := netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+		if ip.Is6() {
+			def = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+		}
+		if !slices.Contains(exit.AllowedIPs, def) {
+			because("--exit-node is %s, but it doesn't offer %v", peerDisplayName(exit), def)
+			because("traffic that would use the exit node is dropped rather than leaked outside the tunnel")
+			return via("drop", nil, def), nil
+		}
+		because("--exit-node is %s, which routes %v", peerDisplayName(exit), def)
+		return via("exit-node", exit, def), nil
+	}
+
+	if lan.IsValid() {
+		because("%v is on this host's local network %v", ip, lan)
+		return via("lan", nil, lan), nil
+	}
+	because("no route from the tunnel covers %v, and no exit node is in use", ip)
+	var exits []string
+	for _, p := range nm.Peers {
+		if tsaddr.ContainsExitRoutes(p.AllowedIPs) {
+			exits = append(exits, peerDisplayName(p))
+		}
+	}
+	if len(exits) > 0 {
+		because("peers offering to be an exit node: %s", strings.Join(exits, ", "))
+	}
+	because("the host's own routes take it, outside the tunnel")
+	return via("outside", nil, netip.Prefix{}), nil
+}
+
+// resolveSubnetRoute returns the peer and subnet route this node sends
+// traffic to ip by, if any. It explains, with because, why any more
+// specific subnet routes including ip aren't used.
+func resolveSubnetRoute(nm *netmap.NetworkMap, prefs ipn.PrefsView, ip netip.Addr, because func(string, ...any)) (_ *tailcfg.Node, _ netip.Prefix, ok bool) {
+	type candidate struct {
+		peer  *tailcfg.Node
+		route netip.Prefix
+	}
+	var cands []candidate
+	for _, peer := range nm.Peers {
+		for _, r := range peer.AllowedIPs {
+			if r.Bits() > 0 && r.Contains(ip) && !slices.Contains(peer.Addresses, r) {
+				cands = append(cands, candidate{peer, r})
+			}
+		}
+		if peer.Hostinfo.Valid() {
+			for _, r := range peer.Hostinfo.RoutableIPs().AsSlice() {
+				if r.Bits() > 0 && r.Contains(ip) && !slices.Contains(peer.AllowedIPs, r) {
+					because("%s advertises %v, but it isn't approved for it in the admin console", peerDisplayName(peer), r)
+				}
+			}
+		}
+	}
+	// Prefer the most specific route, as the OS does, and otherwise peers
+	// not in maintenance, as nmcfg.WGCfg does.
+	sort.SliceStable(cands, func(i, j int) bool {
+		if a, b := cands[i].route.Bits(), cands[j].route.Bits(); a != b {
+			return a > b
+		}
+		return !inMaintenance(cands[i].peer) && inMaintenance(cands[j].peer)
+	})
+	reject := prefs.RejectRoutes().AsSlice()
+	for _, c := range cands {
+		name := peerDisplayName(c.peer)
+		switch {
+		case !prefs.RouteAll():
+			because("%s routes %v, but this node doesn't accept subnet routes (--accept-routes is off)", name, c.route)
+			continue
+		case slices.ContainsFunc(reject, func(p netip.Prefix) bool { return p.Bits() <= c.route.Bits() && p.Contains(c.route.Addr()) }):
+			because("%s routes %v, but it's within --reject-routes", name, c.route)
+			continue
+		case inMaintenance(c.peer):
+			if slices.ContainsFunc(cands, func(o candidate) bool { return o.route == c.route && !inMaintenance(o.peer) }) {
+				because("%s routes %v, but it's in maintenance and another peer routes it too", name, c.route)
+				continue
+			}
+			because("%s is in maintenance, but no other peer routes %v", name, c.route)
+		}
+		because("%v is in %v, a subnet route of %s approved by control", ip, c.route, name)
+		if pr := c.peer.PrimaryRoutes; len(pr) > 0 && !slices.Contains(pr, c.route) {
+			because("%s isn't the primary router of %v", name, c.route)
+		}
+		return c.peer, c.route, true
+	}
+	return nil, netip.Prefix{}, false
+}
+
+// explainFilter adds what this node's packet filter says about traffic
+// from ip to the reasons of rep.
+func explainFilter(rep *ipnstate.DebugRouteReport, nm *netmap.NetworkMap, prefs ipn.PrefsView, ip netip.Addr) {
+	if prefs.ShieldsUp() {
+		rep.Reasons = append(rep.Reasons, "shields are up, so this node accepts no connections from it; the peer's packet filter decides whether it accepts this node's")
+		return
+	}
+	for _, m := range nm.PacketFilter {
+		if slices.ContainsFunc(m.Srcs, func(p netip.Prefix) bool { return p.Contains(ip) }) && len(m.Dsts) > 0 {
+			rep.Reasons = append(rep.Reasons, fmt.Sprintf("this node's packet filter allows connections from %v by rule %v; the peer's filter decides whether it accepts this node's", ip, m))
+			return
+		}
+	}
+	rep.Reasons = append(rep.Reasons, fmt.Sprintf("this node's packet filter allows no connections from %v, but replies to this node's connections; the peer's filter decides whether it accepts them", ip))
+}
+
+// peerDisplayName returns the name of peer to show users.
+func peerDisplayName(peer *tailcfg.Node) string {
+	if n := strings.TrimSuffix(peer.Name, "."); n != "" {
+		return n
+	}
+	return peer.ComputedName
+}
@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/filter"
+)
+
+func TestResolveRoute(t *testing.T) {
+	pfx := netip.MustParsePrefix
+	pfxs := func(ss ...string) (ret []netip.Prefix) {
+		for _, s := range ss {
+			ret = append(ret, pfx(s))
+		}
+		return ret
+	}
+	nm := &netmap.NetworkMap{
+		Addresses: pfxs("100.64.0.1/32"),
+		Peers: []*tailcfg.Node{
+			{
+				ID:         2,
+				StableID:   "exit",
+				Name:       "exit.tail-scale.ts.net.",
+				Addresses:  pfxs("100.64.0.2/32"),
+				AllowedIPs: pfxs("100.64.0.2/32", "0.0.0.0/0", "::/0"),
+			},
+			{
+				ID:         3,
+				StableID:   "router",
+				Name:       "router.tail-scale.ts.net.",
+				Addresses:  pfxs("100.64.0.3/32"),
+				AllowedIPs: pfxs("100.64.0.3/32", "10.0.0.0/8"),
+				Hostinfo:   (&tailcfg.Hostinfo{RoutableIPs: pfxs("10.0.0.0/8", "172.16.0.0/12")}).View(),
+			},
+			{
+				ID:         4,
+				StableID:   "router2",
+				Name:       "router2.tail-scale.ts.net.",
+				Addresses:  pfxs("100.64.0.4/32"),
+				AllowedIPs: pfxs("100.64.0.4/32", "10.1.0.0/16"),
+			},
+		},
+		PacketFilter: []filter.Match{{
+			Srcs: pfxs("100.64.0.2/32"),
+			Dsts: []filter.NetPortRange{{Net: pfx("100.64.0.1/32"), Ports: filter.PortRange{First: 22, Last: 22}}},
+		}},
+	}
+	external := pfxs("192.168.1.0/24")
+
+	tests := []struct {
+		name       string
+		ip         string
+		prefs      *ipn.Prefs
+		wantVia    string
+		wantPeer   string
+		wantRoute  string
+		wantReason string // substring of one of the reasons
+	}{
+		{name: "self", ip: "100.64.0.1", wantVia: "local", wantRoute: "100.64.0.1/32"},
+		{name: "loopback", ip: "127.0.0.1", wantVia: "local"},
+		{name: "service-ip", ip: "100.100.100.100", wantVia: "local"},
+		{name: "peer", ip: "100.64.0.2", wantVia: "peer", wantPeer: "exit.tail-scale.ts.net", wantRoute: "100.64.0.2/32", wantReason: "allows connections from 100.64.0.2 by rule"},
+		{name: "unknown-tailscale-ip", ip: "100.64.9.9", wantVia: "drop"},
+		{name: "subnet", ip: "10.2.3.4", prefs: &ipn.Prefs{RouteAll: true}, wantVia: "subnet", wantPeer: "router.tail-scale.ts.net", wantRoute: "10.0.0.0/8", wantReason: "allows no connections from 10.2.3.4"},
+		{name: "subnet-most-specific", ip: "10.1.2.3", prefs: &ipn.Prefs{RouteAll: true}, wantVia: "subnet", wantPeer: "router2.tail-scale.ts.net", wantRoute: "10.1.0.0/16"},
+		{name: "subnet-rejected", ip: "10.1.2.3", prefs: &ipn.Prefs{RouteAll: true, RejectRoutes: pfxs("10.1.0.0/16")}, wantVia: "subnet", wantPeer: "router.tail-scale.ts.net", wantRoute: "10.0.0.0/8", wantReason: "within --reject-routes"},
+		{name: "subnet-not-accepted", ip: "10.2.3.4", prefs: &ipn.Prefs{}, wantVia: "outside", wantReason: "--accept-routes is off"},
+		{name: "subnet-unapproved", ip: "172.16.0.1", prefs: &ipn.Prefs{RouteAll: true}, wantVia: "outside", wantReason: "isn't approved"},
+		{name: "lan", ip: "192.168.1.10", wantVia: "lan", wantRoute: "192.168.1.0/24"},
+		{name: "outside", ip: "8.8.8.8", wantVia: "outside", wantReason: "peers offering to be an exit node: exit.tail-scale.ts.net"},
+		{name: "exit-node", ip: "8.8.8.8", prefs: &ipn.Prefs{ExitNodeID: "exit"}, wantVia: "exit-node", wantPeer: "exit.tail-scale.ts.net", wantRoute: "0.0.0.0/0"},
+		{name: "exit-node-v6", ip: "2001:db8::1", prefs: &ipn.Prefs{ExitNodeID: "exit"}, wantVia: "exit-node", wantPeer: "exit.tail-scale.ts.net", wantRoute: "::/0"},
+		{name: "exit-node-lan-off", ip: "192.168.1.10", prefs: &ipn.Prefs{ExitNodeID: "exit"}, wantVia: "exit-node", wantPeer: "exit.tail-scale.ts.net", wantReason: "--exit-node-allow-lan-access is off"},
+		{name: "exit-node-lan-on", ip: "192.168.1.10", prefs: &ipn.Prefs{ExitNodeID: "exit", ExitNodeAllowLANAccess: true}, wantVia: "lan", wantRoute: "192.168.1.0/24"},
+		{name: "exit-node-lan-cidr", ip: "203.0.113.5", prefs: &ipn.Prefs{ExitNodeID: "exit", ExitNodeAllowLANCIDRs: pfxs("203.0.113.0/24")}, wantVia: "lan", wantRoute: "203.0.113.0/24"},
+		{name: "exit-node-missing", ip: "8.8.8.8", prefs: &ipn.Prefs{ExitNodeID: "gone"}, wantVia: "drop"},
+		{name: "exit-node-not-offering", ip: "8.8.8.8", prefs: &ipn.Prefs{ExitNodeID: "router"}, wantVia: "drop", wantReason: "doesn't offer 0.0.0.0/0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefs := tt.prefs
+			if prefs == nil {
+				prefs = new(ipn.Prefs)
+			}
+			rep, err := resolveRoute(nm, prefs.View(), nil, external, netip.MustParseAddr(tt.ip))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.Via != tt.wantVia || rep.Peer != tt.wantPeer {
+				t.Errorf("via %q, peer %q; want %q, %q; reasons: %q", rep.Via, rep.Peer, tt.wantVia, tt.wantPeer, rep.Reasons)
+			}
+			if tt.wantRoute != "" && rep.Route.String() != tt.wantRoute {
+				t.Errorf("route %v; want %v", rep.Route, tt.wantRoute)
+			}
+			if tt.wantReason != "" && !strings.Contains(strings.Join(rep.Reasons, "\n"), tt.wantReason) {
+				t.Errorf("reasons %q; want one containing %q", rep.Reasons, tt.wantReason)
+			}
+		})
+	}
+
+	if _, err := resolveRoute(nil, new(ipn.Prefs).View(), nil, nil, netip.MustParseAddr("8.8.8.8")); err == nil {
+		t.Error("no error without a netmap")
+	}
+}
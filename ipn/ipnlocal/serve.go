@@ -4,6 +4,7 @@
 package ipnlocal
 
 import (
+	"bufio"
 	"context"
 	"crypto/tls"
 	"encoding/json"
@@ -21,6 +22,7 @@
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/exp/slices"
@@ -252,6 +254,24 @@ func (b *LocalBackend) ServeConfig() ipn.ServeConfigView {
 	return b.serveConfig
 }
 
+// IngressHandler is the type of func registered with SetIngressHandler.
+//
+// It is called for each Funnel connection permitted by the ServeConfig's
+// AllowFunnel, with the target SNI:port and the public source address.
+// If it returns true, it has taken responsibility for the connection,
+// which it obtains by calling getConn. If it returns false, the
+// connection is handled per the ServeConfig.
+type IngressHandler func(target ipn.HostPort, srcAddr netip.AddrPort, getConn func() (net.Conn, bool)) (handled bool)
+
+// SetIngressHandler sets a func to be consulted before the ServeConfig
+// for incoming Funnel connections. It's used by tsnet to deliver Funnel
+// connections to its listeners.
+//
+// It should only be called before the LocalBackend is used.
+func (b *LocalBackend) SetIngressHandler(h IngressHandler) {
+	b.ingressHandler = h
+}
+
 func (b *LocalBackend) HandleIngressTCPConn(ingressPeer *tailcfg.Node, target ipn.HostPort, srcAddr netip.AddrPort, getConn func() (net.Conn, bool), sendRST func()) {
 	b.mu.Lock()
 	sc := b.serveConfig
@@ -269,6 +289,10 @@ func (b *LocalBackend) HandleIngressTCPConn(ingressPeer *tailcfg.Node, target ip
 		return
 	}
 
+	if h := b.ingressHandler; h != nil && h(target, srcAddr, getConn) {
+		return
+	}
+
 	_, port, err := net.SplitHostPort(string(target))
 	if err != nil {
 		b.logf("localbackend: got ingress conn for bad target %q; rejecting", target)
@@ -299,6 +323,10 @@ func (b *LocalBackend) HandleInterceptedTCPConn(dport uint16, srcAddr netip.Addr
 
 	tcph, ok := sc.TCP().GetOk(dport)
 	if !ok {
+		if r, ok := serveTCPPortRange(sc, dport); ok {
+			b.forwardTCPConn(dport, srcAddr, net.JoinHostPort(r.TCPForward, strconv.Itoa(int(dport))), "", getConn, sendRST)
+			return
+		}
 		b.logf("[unexpected] localbackend: got TCP conn without TCP config for port %v; from %v", dport, srcAddr)
 		sendRST()
 		return
@@ -327,54 +355,7 @@ func (b *LocalBackend) HandleInterceptedTCPConn(dport uint16, srcAddr netip.Addr
 	}
 
 	if backDst := tcph.TCPForward(); backDst != "" {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		backConn, err := b.dialer.SystemDial(ctx, "tcp", backDst)
-		cancel()
-		if err != nil {
-			b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
-			sendRST()
-			return
-		}
-		conn, ok := getConn()
-		if !ok {
-			b.logf("localbackend: getConn didn't complete from %v to port %v", srcAddr, dport)
-			backConn.Close()
-			return
-		}
-		defer conn.Close()
-		defer backConn.Close()
-
-		if sni := tcph.TerminateTLS(); sni != "" {
-			conn = tls.Server(conn, &tls.Config{
-				GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-					ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
-					defer cancel()
-					pair, err := b.GetCertPEM(ctx, sni)
-					if err != nil {
-						return nil, err
-					}
-					cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
-					if err != nil {
-						return nil, err
-					}
-					return &cert, nil
-				},
-			})
-		}
-
-		// TODO(bradfitz): do the RegisterIPPortIdentity and
-		// UnregisterIPPortIdentity stuff that netstack does
-
-		errc := make(chan error, 1)
-		go func() {
-			_, err := io.Copy(backConn, conn)
-			errc <- err
-		}()
-		go func() {
-			_, err := io.Copy(conn, backConn)
-			errc <- err
-		}()
-		<-errc
+		b.forwardTCPConn(dport, srcAddr, backDst, tcph.TerminateTLS(), getConn, sendRST)
 		return
 	}
 
@@ -382,6 +363,70 @@ func (b *LocalBackend) HandleInterceptedTCPConn(dport uint16, srcAddr netip.Addr
 	sendRST()
 }
 
+// serveTCPPortRange returns the range of sc.TCPRanges containing port, if any.
+func serveTCPPortRange(sc ipn.ServeConfigView, port uint16) (ipn.TCPPortRange, bool) {
+	rs := sc.TCPRanges()
+	for i := 0; i < rs.Len(); i++ {
+		if r := rs.At(i); r.Ports.Contains(port) {
+			return r, true
+		}
+	}
+	return ipn.TCPPortRange{}, false
+}
+
+// forwardTCPConn proxies the TCP conn from srcAddr to dport to backDst,
+// first terminating TLS for the SNI name sni if it's non-empty.
+func (b *LocalBackend) forwardTCPConn(dport uint16, srcAddr netip.AddrPort, backDst, sni string, getConn func() (net.Conn, bool), sendRST func()) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	backConn, err := b.dialServeBackend(ctx, "tcp", backDst)
+	cancel()
+	if err != nil {
+		b.logf("localbackend: failed to TCP proxy port %v (from %v) to %s: %v", dport, srcAddr, backDst, err)
+		sendRST()
+		return
+	}
+	conn, ok := getConn()
+	if !ok {
+		b.logf("localbackend: getConn didn't complete from %v to port %v", srcAddr, dport)
+		backConn.Close()
+		return
+	}
+	defer conn.Close()
+	defer backConn.Close()
+
+	if sni != "" {
+		conn = tls.Server(conn, &tls.Config{
+			GetCertificate: func(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+				defer cancel()
+				pair, err := b.GetCertPEM(ctx, sni)
+				if err != nil {
+					return nil, err
+				}
+				cert, err := tls.X509KeyPair(pair.CertPEM, pair.KeyPEM)
+				if err != nil {
+					return nil, err
+				}
+				return &cert, nil
+			},
+		})
+	}
+
+	// TODO(bradfitz): do the RegisterIPPortIdentity and
+	// UnregisterIPPortIdentity stuff that netstack does
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(backConn, conn)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, backConn)
+		errc <- err
+	}()
+	<-errc
+}
+
 func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView, at string, ok bool) {
 	var z ipn.HTTPHandlerView // zero value
 
@@ -418,6 +463,20 @@ func (b *LocalBackend) getServeHandler(r *http.Request) (_ ipn.HTTPHandlerView,
 	}
 }
 
+// dialServeBackend dials addr, the address of a serve backend. Local
+// backends are dialed directly; others are other nodes in the tailnet, so
+// their MagicDNS names are resolved and they are dialed over Tailscale.
+func (b *LocalBackend) dialServeBackend(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip, err := netip.ParseAddr(host); host == "localhost" || (err == nil && ip.IsLoopback()) {
+		return b.dialer.SystemDial(ctx, network, addr)
+	}
+	return b.dialer.UserDial(ctx, network, addr)
+}
+
 // proxyHandlerForBackend creates a new HTTP reverse proxy for a particular backend that
 // we serve requests for. `backend` is a HTTPHandler.Proxy string (url, hostport or just port).
 func (b *LocalBackend) proxyHandlerForBackend(backend string) (*httputil.ReverseProxy, error) {
@@ -428,7 +487,7 @@ func (b *LocalBackend) proxyHandlerForBackend(backend string) (*httputil.Reverse
 	}
 	rp := httputil.NewSingleHostReverseProxy(u)
 	rp.Transport = &http.Transport{
-		DialContext: b.dialer.SystemDial,
+		DialContext: b.dialServeBackend,
 		TLSClientConfig: &tls.Config{
 			InsecureSkipVerify: insecure,
 		},
@@ -439,6 +498,8 @@ func (b *LocalBackend) proxyHandlerForBackend(backend string) (*httputil.Reverse
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
 	}
+	rp.ModifyResponse = stopProxyReadTimer
+	rp.ErrorHandler = b.serveProxyError
 	return rp, nil
 }
 
@@ -448,6 +509,13 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	if h.AllowFrom().Len() > 0 {
+		sctx, _ := r.Context().Value(serveHTTPContextKey{}).(*serveHTTPContext)
+		if sctx == nil || !b.serveAllowedFrom(h, sctx.SrcAddr) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
 	if s := h.Text(); s != "" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		io.WriteString(w, s)
@@ -463,13 +531,198 @@ func (b *LocalBackend) serveWebHandler(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "unknown proxy destination", http.StatusInternalServerError)
 			return
 		}
-		p.(http.Handler).ServeHTTP(w, r)
+		serveProxy(w, r, p.(http.Handler), h)
 		return
 	}
 
 	http.Error(w, "empty handler", 500)
 }
 
+// errProxyReadTimeout is the cause of canceling a proxied request whose
+// backend didn't respond within the handler's ReadTimeout.
+var errProxyReadTimeout = errors.New("backend didn't respond within the read timeout")
+
+// proxyReadTimerKey is the context.Value key for the *time.Timer that
+// cancels a proxied request at its handler's ReadTimeout.
+type proxyReadTimerKey struct{}
+
+// serveProxy serves r with p, the reverse proxy to the backend of h,
+// applying the timeouts of h.
+func serveProxy(w http.ResponseWriter, r *http.Request, p http.Handler, h ipn.HTTPHandlerView) {
+	if d := h.ReadTimeout(); d > 0 {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+		t := time.AfterFunc(d, func() { cancel(errProxyReadTimeout) })
+		defer t.Stop()
+		r = r.WithContext(context.WithValue(ctx, proxyReadTimerKey{}, t))
+	}
+	if h.WriteTimeout() > 0 || h.IdleTimeout() > 0 {
+		tw := &timeoutResponseWriter{
+			ResponseWriter: w,
+			rc:             http.NewResponseController(w),
+			write:          h.WriteTimeout(),
+			idle:           h.IdleTimeout(),
+		}
+		// Don't leave the deadline for the next request on the connection,
+		// which may be for another handler.
+		defer tw.rc.SetWriteDeadline(time.Time{})
+		w = tw
+	}
+	p.ServeHTTP(w, r)
+}
+
+// stopProxyReadTimer is the ReverseProxy.ModifyResponse of serve proxies.
+// It stops the ReadTimeout timer of the request, if any, now that the
+// backend has responded.
+func stopProxyReadTimer(res *http.Response) error {
+	if t, ok := res.Request.Context().Value(proxyReadTimerKey{}).(*time.Timer); ok && !t.Stop() {
+		return errProxyReadTimeout
+	}
+	return nil
+}
+
+// serveProxyError is the ReverseProxy.ErrorHandler of serve proxies.
+func (b *LocalBackend) serveProxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errProxyReadTimeout) || errors.Is(context.Cause(r.Context()), errProxyReadTimeout) {
+		http.Error(w, "backend timed out", http.StatusGatewayTimeout)
+		return
+	}
+	b.logf("serve: proxy error: %v", err)
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// timeoutResponseWriter is the http.ResponseWriter for a proxied request
+// whose handler has a WriteTimeout or IdleTimeout. It bounds each write
+// to the client by the WriteTimeout, and applies both timeouts to the
+// connection if the backend upgrades it, such as to a WebSocket.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+	rc    *http.ResponseController
+	write time.Duration // or zero
+	idle  time.Duration // or zero
+}
+
+func (w *timeoutResponseWriter) setWriteDeadline() {
+	if w.write > 0 {
+		w.rc.SetWriteDeadline(time.Now().Add(w.write))
+	}
+}
+
+func (w *timeoutResponseWriter) WriteHeader(code int) {
+	w.setWriteDeadline()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *timeoutResponseWriter) Write(p []byte) (int, error) {
+	w.setWriteDeadline()
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *timeoutResponseWriter) Flush() {
+	w.setWriteDeadline()
+	w.rc.Flush()
+}
+
+func (w *timeoutResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	c, brw, err := w.rc.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.SetDeadline(time.Time{})
+	ic := &idleConn{Conn: c, write: w.write, idle: w.idle}
+	ic.touch()
+	return ic, brw, nil
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for
+// http.ResponseController.
+func (w *timeoutResponseWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+// idleConn is a client connection that the backend of a serve proxy
+// upgraded. It's closed once no data was read or written for idle, if
+// non-zero, and each write may block for write, if non-zero, or else for
+// idle.
+type idleConn struct {
+	net.Conn
+	write time.Duration
+	idle  time.Duration
+
+	last atomic.Int64 // unix nanos of the last read or write of data
+}
+
+// touch records that data was just read or written.
+func (c *idleConn) touch() { c.last.Store(time.Now().UnixNano()) }
+
+// idleFor returns how long it's been since data was read or written.
+func (c *idleConn) idleFor() time.Duration { return time.Since(time.Unix(0, c.last.Load())) }
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	for {
+		if c.idle > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.idle - c.idleFor()))
+		}
+		n, err := c.Conn.Read(p)
+		if n > 0 {
+			c.touch()
+		}
+		var ne net.Error
+		if n == 0 && errors.As(err, &ne) && ne.Timeout() && c.idleFor() < c.idle {
+			// Data was written since the deadline was set, so it's not
+			// idle; keep reading.
+			continue
+		}
+		return n, err
+	}
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	d := c.write
+	if d <= 0 {
+		d = c.idle
+	}
+	if d > 0 {
+		c.Conn.SetWriteDeadline(time.Now().Add(d))
+	}
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.touch()
+	}
+	return n, err
+}
+
+// serveAllowedFrom reports whether h, which has a non-empty AllowFrom,
+// may serve a request from src.
+func (b *LocalBackend) serveAllowedFrom(h ipn.HTTPHandlerView, src netip.AddrPort) bool {
+	n, u, ok := b.WhoIs(src)
+	if !ok {
+		// Not a tailnet peer, such as a Funnel client.
+		return false
+	}
+	var caps []string
+	for i := 0; i < h.AllowFrom().Len(); i++ {
+		a := h.AllowFrom().At(i)
+		switch {
+		case strings.HasPrefix(a, "tag:"):
+			if slices.Contains(n.Tags, a) {
+				return true
+			}
+		case strings.Contains(a, "@"):
+			// Tagged nodes are owned by the tagger, not the user.
+			if len(n.Tags) == 0 && strings.EqualFold(u.LoginName, a) {
+				return true
+			}
+		default:
+			if caps == nil {
+				caps = b.PeerCaps(src.Addr())
+			}
+			if slices.Contains(caps, a) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (b *LocalBackend) serveFileOrDirectory(w http.ResponseWriter, r *http.Request, fileOrDir, mountPoint string) {
 	fi, err := os.Stat(fileOrDir)
 	if err != nil {
@@ -8,14 +8,23 @@
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"nhooyr.io/websocket"
 	"tailscale.com/ipn"
+	"tailscale.com/net/tsdial"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine"
 )
 
 func TestExpandProxyArg(t *testing.T) {
@@ -251,3 +260,174 @@ func TestServeFileOrDirectory(t *testing.T) {
 		}
 	}
 }
+
+func TestServeAllowedFrom(t *testing.T) {
+	eng, err := wgengine.NewFakeUserspaceEngine(logger.Discard, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(eng.Close)
+
+	alice := &tailcfg.Node{ID: 1, User: 10, Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")}}
+	tagged := &tailcfg.Node{ID: 2, User: 10, Tags: []string{"tag:admin"}, Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")}}
+	bob := &tailcfg.Node{ID: 3, User: 20, Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.3/32")}}
+	b := &LocalBackend{
+		e:    eng,
+		logf: t.Logf,
+		nodeByAddr: map[netip.Addr]*tailcfg.Node{
+			netip.MustParseAddr("100.64.0.1"): alice,
+			netip.MustParseAddr("100.64.0.2"): tagged,
+			netip.MustParseAddr("100.64.0.3"): bob,
+		},
+		netMap: &netmap.NetworkMap{
+			UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+				10: {ID: 10, LoginName: "alice@example.com"},
+				20: {ID: 20, LoginName: "bob@example.com"},
+			},
+		},
+	}
+
+	h := (&ipn.HTTPHandler{Text: "admin", AllowFrom: []string{"Alice@example.com", "tag:admin"}}).View()
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"100.64.0.1:1234", true},
+		{"100.64.0.2:1234", true},
+		{"100.64.0.3:1234", false},
+		{"203.0.113.5:1234", false}, // Funnel
+	}
+	for _, tt := range tests {
+		if got := b.serveAllowedFrom(h, netip.MustParseAddrPort(tt.src)); got != tt.want {
+			t.Errorf("serveAllowedFrom(%v) = %v; want %v", tt.src, got, tt.want)
+		}
+	}
+
+	// A tagged node isn't its tagger's.
+	h = (&ipn.HTTPHandler{Text: "admin", AllowFrom: []string{"alice@example.com"}}).View()
+	if b.serveAllowedFrom(h, netip.MustParseAddrPort("100.64.0.2:1234")) {
+		t.Errorf("tagged node allowed as its user")
+	}
+}
+
+func TestServeTCPPortRange(t *testing.T) {
+	sc := (&ipn.ServeConfig{
+		TCP: map[uint16]*ipn.TCPPortHandler{
+			30050: {TCPForward: "127.0.0.1:5060"},
+		},
+		TCPRanges: []ipn.TCPPortRange{
+			{Ports: tailcfg.PortRange{First: 30000, Last: 30100}, TCPForward: "127.0.0.1"},
+			{Ports: tailcfg.PortRange{First: 40000, Last: 40000}, TCPForward: "nas"},
+		},
+	}).View()
+	tests := []struct {
+		port uint16
+		want string // TCPForward of the range, or empty for none
+	}{
+		{29999, ""},
+		{30000, "127.0.0.1"},
+		{30100, "127.0.0.1"},
+		{30101, ""},
+		{40000, "nas"},
+	}
+	for _, tt := range tests {
+		r, ok := serveTCPPortRange(sc, tt.port)
+		if got := r.TCPForward; got != tt.want || ok != (tt.want != "") {
+			t.Errorf("serveTCPPortRange(%d) = %q, %v; want %q", tt.port, got, ok, tt.want)
+		}
+	}
+
+	b := &LocalBackend{}
+	b.setTCPPortsIntercepted([]uint16{22}, []tailcfg.PortRange{{First: 30000, Last: 30100}})
+	for port, want := range map[uint16]bool{22: true, 80: false, 29999: false, 30000: true, 30042: true, 30100: true, 30101: false} {
+		if got := b.ShouldInterceptTCPPort(port); got != want {
+			t.Errorf("ShouldInterceptTCPPort(%d) = %v; want %v", port, got, want)
+		}
+	}
+}
+
+func TestServeProxyTimeouts(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		case "/ws":
+			c, err := websocket.Accept(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer c.Close(websocket.StatusNormalClosure, "")
+			ctx := r.Context()
+			for {
+				typ, msg, err := c.Read(ctx)
+				if err != nil {
+					return
+				}
+				if err := c.Write(ctx, typ, msg); err != nil {
+					return
+				}
+			}
+		default:
+			io.WriteString(w, "ok")
+		}
+	}))
+	defer backend.Close()
+
+	b := &LocalBackend{logf: t.Logf, dialer: &tsdial.Dialer{Logf: t.Logf}}
+	p, err := b.proxyHandlerForBackend(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ipn.HTTPHandler{
+		Proxy:        backend.URL,
+		ReadTimeout:  100 * time.Millisecond,
+		WriteTimeout: time.Second,
+		IdleTimeout:  200 * time.Millisecond,
+	}
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveProxy(w, r, p, h.View())
+	}))
+	defer front.Close()
+
+	for path, want := range map[string]int{"/": 200, "/slow": http.StatusGatewayTimeout} {
+		res, err := http.Get(front.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("GET %s = %v; want %v", path, res.Status, want)
+		}
+	}
+
+	// A WebSocket outlives the ReadTimeout, and the IdleTimeout as long
+	// as it's used.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	c, _, err := websocket.Dial(ctx, front.URL+"/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(websocket.StatusNormalClosure, "")
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		msg := fmt.Sprint("ping ", i)
+		if err := c.Write(ctx, websocket.MessageText, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		if _, got, err := c.Read(ctx); err != nil || string(got) != msg {
+			t.Fatalf("read %q, %v; want %q", got, err, msg)
+		}
+	}
+
+	// Once idle, it's closed.
+	time.Sleep(400 * time.Millisecond)
+	if _, _, err := c.Read(ctx); err == nil {
+		t.Fatal("read from idle WebSocket succeeded")
+	} else if ctx.Err() != nil {
+		t.Fatal("idle WebSocket not closed")
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"regexp"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// serviceNameRx matches a DNS-SD service type and protocol (RFC 6763
+// section 7), such as "_http._tcp".
+var serviceNameRx = regexp.MustCompile(`^_[a-z0-9](?:[a-z0-9-]{0,13}[a-z0-9])?\._(?:tcp|udp)$`)
+
+// checkServiceRecord reports whether rec is well formed.
+func checkServiceRecord(rec apitype.ServiceRecord) error {
+	if !serviceNameRx.MatchString(rec.Service) {
+		return fmt.Errorf("invalid service name %q; want a form like \"_http._tcp\"", rec.Service)
+	}
+	if rec.Port == 0 {
+		return fmt.Errorf("service %q: port must be non-zero", rec.Service)
+	}
+	for _, txt := range rec.TXT {
+		if len(txt) == 0 || len(txt) > 255 {
+			return fmt.Errorf("service %q: TXT entries must be 1 to 255 bytes", rec.Service)
+		}
+	}
+	return nil
+}
+
+// SetServiceRecords replaces the set of service records this node
+// publishes to its peers over the PeerAPI.
+func (b *LocalBackend) SetServiceRecords(recs []apitype.ServiceRecord) error {
+	for _, rec := range recs {
+		if err := checkServiceRecord(rec); err != nil {
+			return err
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.serviceRecords = slices.Clone(recs)
+	return nil
+}
+
+// ServiceRecords returns the service records set by SetServiceRecords.
+func (b *LocalBackend) ServiceRecords() []apitype.ServiceRecord {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return slices.Clone(b.serviceRecords)
+}
+
+// PeerServiceRecords fetches the service records published by the peer
+// with the Tailscale IP ip.
+func (b *LocalBackend) PeerServiceRecords(ctx context.Context, ip netip.Addr) ([]apitype.ServiceRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID, ip)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", base+"/v0/services", nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.Dialer().PeerAPITransport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("peer %v: HTTP status %v", ip, res.Status)
+	}
+	// Peers running older versions serve their HTML landing page
+	// here, so check the type before decoding.
+	if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+		return nil, fmt.Errorf("peer %v does not publish service records", ip)
+	}
+	var recs []apitype.ServiceRecord
+	if err := json.Unmarshal(body, &recs); err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
@@ -0,0 +1,35 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"strings"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+func TestCheckServiceRecord(t *testing.T) {
+	tests := []struct {
+		rec     apitype.ServiceRecord
+		wantErr bool
+	}{
+		{apitype.ServiceRecord{Service: "_http._tcp", Port: 80}, false},
+		{apitype.ServiceRecord{Service: "_dns-sd._udp", Port: 53, TXT: []string{"a=b"}}, false},
+		{apitype.ServiceRecord{Service: "http._tcp", Port: 80}, true},
+		{apitype.ServiceRecord{Service: "_http._sctp", Port: 80}, true},
+		{apitype.ServiceRecord{Service: "_HTTP._tcp", Port: 80}, true},
+		{apitype.ServiceRecord{Service: "_http-._tcp", Port: 80}, true},
+		{apitype.ServiceRecord{Service: "_sixteen-chars-xx._tcp", Port: 80}, true},
+		{apitype.ServiceRecord{Service: "_http._tcp"}, true},
+		{apitype.ServiceRecord{Service: "_http._tcp", Port: 80, TXT: []string{""}}, true},
+		{apitype.ServiceRecord{Service: "_http._tcp", Port: 80, TXT: []string{strings.Repeat("x", 256)}}, true},
+	}
+	for _, tt := range tests {
+		err := checkServiceRecord(tt.rec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkServiceRecord(%+v) = %v; wantErr %v", tt.rec, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/speedtest"
+	"tailscale.com/tailcfg"
+	"tailscale.com/wgengine"
+)
+
+// speedTestUpgrade is the HTTP Upgrade protocol of the PeerAPI speedtest
+// endpoint, after which the connection speaks the net/speedtest protocol.
+const speedTestUpgrade = "tailscale-speedtest"
+
+func (h *peerAPIHandler) canSpeedTest() bool {
+	return h.isSelf || h.peerHasCap(tailcfg.CapabilityDebugPeer)
+}
+
+func (h *peerAPIHandler) handleServeSpeedTest(w http.ResponseWriter, r *http.Request) {
+	if !h.canSpeedTest() {
+		http.Error(w, "denied; no speedtest access", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "only POST allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Upgrade") != speedTestUpgrade {
+		http.Error(w, "missing Upgrade: "+speedTestUpgrade, http.StatusBadRequest)
+		return
+	}
+	conn, _, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		h.logf("speedtest: failed hijacking conn")
+		http.Error(w, "failed hijacking conn", http.StatusInternalServerError)
+		return
+	}
+	metricSpeedTestCalls.Add(1)
+	io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: "+speedTestUpgrade+"\r\nConnection: Upgrade\r\n\r\n")
+	h.logf("speedtest: starting test with %v", h.remoteAddr)
+	if err := speedtest.ServeConn(conn, h.ps.b.speedTestListenUDP(conn.LocalAddr())); err != nil {
+		h.logf("speedtest: test with %v failed: %v", h.remoteAddr, err)
+	}
+}
+
+// speedTestListenUDP returns the func that opens the UDP socket of a
+// speedtest with the peer connected to laddr, or nil if UDP tests aren't
+// supported.
+func (b *LocalBackend) speedTestListenUDP(laddr net.Addr) func() (net.PacketConn, error) {
+	// In netstack mode, the peer's UDP packets don't reach the host's
+	// sockets.
+	if wgengine.IsNetstack(b.e) {
+		return nil
+	}
+	ap, err := netip.ParseAddrPort(laddr.String())
+	if err != nil {
+		return nil
+	}
+	return func() (net.PacketConn, error) {
+		return net.ListenPacket("udp", netip.AddrPortFrom(ap.Addr(), 0).String())
+	}
+}
+
+// SpeedTest runs a speedtest with the peer with the Tailscale IP ip over
+// its PeerAPI, with the traffic taking the same path as any other traffic
+// to the peer.
+func (b *LocalBackend) SpeedTest(ctx context.Context, ip netip.Addr, opts speedtest.Options) (*apitype.SpeedTestResponse, error) {
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(ip)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", ip)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID, ip)
+	}
+	if opts.Protocol == speedtest.UDP {
+		if wgengine.IsNetstack(b.e) {
+			return nil, errors.New("UDP speedtests are not supported in userspace-networking mode")
+		}
+		opts.DialUDP = func(port uint16) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), strconv.Itoa(int(port))))
+		}
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := b.Dialer().PeerAPITransport().DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/speedtest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", speedTestUpgrade)
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	// The peer doesn't send anything past its response until we send the
+	// test config, so there's nothing buffered to lose.
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+		res.Body.Close()
+		return nil, fmt.Errorf("peer %v: HTTP status %v: %s", ip, res.Status, body)
+	}
+	rep, err := speedtest.RunClientConn(conn, opts)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	proto := opts.Protocol
+	if proto == "" {
+		proto = speedtest.TCP
+	}
+	return &apitype.SpeedTestResponse{
+		Protocol:  proto,
+		Direction: opts.Direction.String(),
+		Path:      b.peerPath(peer),
+		Results:   rep.Results,
+		UDP:       rep.UDP,
+	}, nil
+}
+
+// peerPath describes how traffic to peer currently flows: directly, with
+// the endpoint in use, or relayed through a DERP region.
+func (b *LocalBackend) peerPath(peer *tailcfg.Node) string {
+	ps, ok := b.Status().Peer[peer.Key]
+	switch {
+	case !ok:
+		return "unknown"
+	case ps.CurAddr != "":
+		return "direct " + ps.CurAddr
+	case ps.Relay != "":
+		return "DERP(" + ps.Relay + ")"
+	}
+	return "unknown"
+}
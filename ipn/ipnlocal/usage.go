@@ -0,0 +1,195 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/netip"
+	"sort"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
+	"tailscale.com/net/usage"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netlogtype"
+	"tailscale.com/types/netmap"
+	"tailscale.com/wgengine/wgcfg"
+)
+
+// usageStateKey is the StateKey under which the daily traffic totals are
+// stored. They aren't per profile: they account for the device's traffic
+// across all its profiles.
+const usageStateKey = ipn.StateKey("_usage")
+
+const (
+	// usageFlushInterval is how often the traffic counters are added to
+	// the stored daily totals.
+	usageFlushInterval = 5 * time.Minute
+
+	// usageMaxConns is how many connections are counted before the
+	// counters are added to the stored daily totals early.
+	usageMaxConns = 10000
+
+	// usageDaysKept is how many days of daily totals are stored.
+	usageDaysKept = 100
+)
+
+// usageDateFormat is the format of apitype.UsageDay.Date.
+const usageDateFormat = "2006-01-02"
+
+// usageRoutes returns the routes to account traffic by, mapping each
+// WireGuard peer's AllowedIPs to its stable node ID.
+func usageRoutes(nm *netmap.NetworkMap, cfg *wgcfg.Config) map[netip.Prefix]tailcfg.StableNodeID {
+	ids := make(map[key.NodePublic]tailcfg.StableNodeID, len(nm.Peers))
+	for _, p := range nm.Peers {
+		ids[p.Key] = p.StableID
+	}
+	routes := make(map[netip.Prefix]tailcfg.StableNodeID)
+	for _, p := range cfg.Peers {
+		id, ok := ids[p.PublicKey]
+		if !ok {
+			continue
+		}
+		for _, pfx := range p.AllowedIPs {
+			routes[pfx] = id
+		}
+	}
+	return routes
+}
+
+// recordUsage is the connstats dump func of b.usageStats. It adds the
+// traffic counted between start and end to the stored daily totals.
+func (b *LocalBackend) recordUsage(start, end time.Time, virtual, physical map[netlogtype.Connection]netlogtype.Counts) {
+	b.usage.Add(virtual)
+	b.flushUsage()
+}
+
+// flushUsage adds the traffic counted since the previous flush to the
+// stored daily totals.
+func (b *LocalBackend) flushUsage() {
+	counts := b.usage.Swap()
+	if len(counts) == 0 {
+		return
+	}
+	names := map[tailcfg.StableNodeID]string{}
+	b.mu.Lock()
+	if nm := b.netMap; nm != nil {
+		for _, p := range nm.Peers {
+			names[p.StableID] = p.DisplayName(false)
+		}
+	}
+	b.mu.Unlock()
+
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	days, err := b.usageDaysLocked()
+	if err != nil {
+		b.logf("usage: %v", err)
+		return
+	}
+	b.usageDays = addUsage(days, time.Now().Format(usageDateFormat), counts, names)
+	bs, err := json.Marshal(b.usageDays)
+	if err != nil {
+		b.logf("usage: %v", err)
+		return
+	}
+	if err := b.store.WriteState(usageStateKey, bs); err != nil {
+		b.logf("usage: writing state: %v", err)
+	}
+}
+
+// usageDaysLocked returns the stored daily totals, reading them from the
+// state store the first time. b.usageMu must be held.
+func (b *LocalBackend) usageDaysLocked() ([]apitype.UsageDay, error) {
+	if b.usageLoaded {
+		return b.usageDays, nil
+	}
+	bs, err := b.store.ReadState(usageStateKey)
+	if err == nil {
+		err = json.Unmarshal(bs, &b.usageDays)
+	} else if errors.Is(err, ipn.ErrStateNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b.usageLoaded = true
+	return b.usageDays, nil
+}
+
+// Usage returns the stored daily totals of the traffic sent to and
+// received from peers on the days from since (formatted as "2006-01-02"),
+// inclusive. An empty since returns all the stored days. The totals lag
+// the traffic by up to usageFlushInterval.
+func (b *LocalBackend) Usage(since string) (*apitype.UsageResponse, error) {
+	b.mu.Lock()
+	recording := b.usageStats != nil
+	b.mu.Unlock()
+
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	days, err := b.usageDaysLocked()
+	if err != nil {
+		return nil, err
+	}
+	res := &apitype.UsageResponse{Recording: recording}
+	for _, d := range days {
+		if d.Date >= since {
+			res.Days = append(res.Days, d)
+		}
+	}
+	return res, nil
+}
+
+// addUsage returns days with counts added to the totals of date, and
+// the days before the most recent usageDaysKept dropped. The names of
+// the counted peers are updated from names, if present. The days are
+// sorted by date and days is not modified.
+func addUsage(days []apitype.UsageDay, date string, counts map[usage.Key]usage.Counts, names map[tailcfg.StableNodeID]string) []apitype.UsageDay {
+	ret := append([]apitype.UsageDay(nil), days...)
+	i := sort.Search(len(ret), func(i int) bool { return ret[i].Date >= date })
+	if i == len(ret) || ret[i].Date != date {
+		ret = slices.Insert(ret, i, apitype.UsageDay{Date: date})
+	} else {
+		ret[i].Peers = append([]apitype.PeerUsage(nil), ret[i].Peers...)
+	}
+	day := &ret[i]
+
+	for k, cnts := range counts {
+		i := 0
+		for ; i < len(day.Peers); i++ {
+			if pu := day.Peers[i]; pu.Peer == k.Peer && pu.Route == k.Route {
+				break
+			}
+		}
+		if i == len(day.Peers) {
+			day.Peers = append(day.Peers, apitype.PeerUsage{Peer: k.Peer, Route: k.Route})
+		}
+		pu := &day.Peers[i]
+		if name, ok := names[k.Peer]; ok {
+			pu.Name = name
+		}
+		pu.TxBytes += cnts.TxBytes
+		pu.RxBytes += cnts.RxBytes
+	}
+	sort.Slice(day.Peers, func(i, j int) bool {
+		pi, pj := day.Peers[i], day.Peers[j]
+		if ti, tj := pi.TxBytes+pi.RxBytes, pj.TxBytes+pj.RxBytes; ti != tj {
+			return ti > tj
+		}
+		if pi.Peer != pj.Peer {
+			return pi.Peer < pj.Peer
+		}
+		return pi.Route.String() < pj.Route.String()
+	})
+
+	if len(ret) > usageDaysKept {
+		ret = ret[len(ret)-usageDaysKept:]
+	}
+	return ret
+}
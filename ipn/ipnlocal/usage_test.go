@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/net/usage"
+	"tailscale.com/tailcfg"
+)
+
+func TestAddUsage(t *testing.T) {
+	exitRoute := netip.MustParsePrefix("0.0.0.0/0")
+	days := []apitype.UsageDay{
+		{Date: "2023-01-30", Peers: []apitype.PeerUsage{{Peer: "n1", Name: "a", TxBytes: 1}}},
+		{Date: "2023-02-01", Peers: []apitype.PeerUsage{{Peer: "n1", Name: "a", TxBytes: 1, RxBytes: 2}}},
+	}
+	counts := map[usage.Key]usage.Counts{
+		{Peer: "n1"}:                   {TxBytes: 10},
+		{Peer: "n2", Route: exitRoute}: {TxBytes: 100, RxBytes: 1000},
+	}
+	names := map[tailcfg.StableNodeID]string{"n1": "laptop", "n2": "exit"}
+
+	got := addUsage(days, "2023-02-01", counts, names)
+	want := []apitype.UsageDay{
+		days[0],
+		{Date: "2023-02-01", Peers: []apitype.PeerUsage{
+			{Peer: "n2", Name: "exit", Route: exitRoute, TxBytes: 100, RxBytes: 1000},
+			{Peer: "n1", Name: "laptop", TxBytes: 11, RxBytes: 2},
+		}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+	if days[1].Peers[0].TxBytes != 1 {
+		t.Errorf("input days modified: %+v", days)
+	}
+
+	got = addUsage(got, "2023-01-31", counts, nil)
+	if len(got) != 3 || got[1].Date != "2023-01-31" || got[1].Peers[1].Name != "" {
+		t.Errorf("inserting a day: got %+v", got)
+	}
+
+	var many []apitype.UsageDay
+	for i := 0; i < usageDaysKept; i++ {
+		many = append(many, apitype.UsageDay{Date: fmt.Sprintf("2020-%03d", i)})
+	}
+	got = addUsage(many, "2023-01-01", counts, names)
+	if len(got) != usageDaysKept || got[0].Date != "2020-001" || got[len(got)-1].Date != "2023-01-01" {
+		t.Errorf("trimming: got %d days, from %v to %v", len(got), got[0].Date, got[len(got)-1].Date)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+)
+
+// WakeOnLAN asks the peer with the Tailscale IP via to send a Wake-on-LAN
+// magic packet for mac on its LANs, so machines behind a subnet router can
+// be woken over the tailnet. The peer must grant this node the
+// tailcfg.CapabilityWakeOnLAN capability, unless it's owned by the same
+// user.
+func (b *LocalBackend) WakeOnLAN(ctx context.Context, via netip.Addr, mac net.HardwareAddr) (*apitype.WakeOnLANResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	nm := b.NetMap()
+	if nm == nil {
+		return nil, errors.New("no netmap")
+	}
+	peer, ok := nm.PeerByTailscaleIP(via)
+	if !ok {
+		return nil, fmt.Errorf("no peer found with Tailscale IP %v", via)
+	}
+	base := peerAPIBase(nm, peer)
+	if base == "" {
+		return nil, fmt.Errorf("no PeerAPI base found for peer %v (%v)", peer.ID, via)
+	}
+	form := url.Values{"mac": {mac.String()}}
+	req, err := http.NewRequestWithContext(ctx, "POST", base+"/v0/wol", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	res, err := b.Dialer().PeerAPITransport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("peer %v doesn't allow this node to send Wake-on-LAN packets", via)
+	default:
+		return nil, fmt.Errorf("peer %v: HTTP status %v: %s", via, res.Status, strings.TrimSpace(string(body)))
+	}
+	ret := new(apitype.WakeOnLANResponse)
+	if err := json.Unmarshal(body, ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
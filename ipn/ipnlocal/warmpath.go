@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tailcfg"
+	"tailscale.com/util/dnsname"
+)
+
+// The maximum number of disco pings that WarmPath sends while looking for
+// a direct path, and the time between them.
+const (
+	warmPathPings    = 10
+	warmPathInterval = 500 * time.Millisecond
+)
+
+// WarmPath establishes the best path to the peer with the Tailscale IP ip
+// ahead of the traffic that needs it, so the traffic doesn't pay for the
+// path setup: it completes a WireGuard handshake with a TSMP ping, then
+// sends disco pings, which also start the peer's endpoint discovery, until
+// one takes a direct path, up to warmPathPings of them or until ctx is
+// done.
+//
+// It returns the result of the last disco ping, whose Endpoint is set if
+// the path is direct and DERPRegionID if it's still relayed.
+func (b *LocalBackend) WarmPath(ctx context.Context, ip netip.Addr) (*ipnstate.PingResult, error) {
+	pr, err := b.Ping(ctx, ip, tailcfg.PingTSMP)
+	if err != nil || pr.Err != "" {
+		return pr, err
+	}
+	var last *ipnstate.PingResult
+	for i := 0; i < warmPathPings; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return last, nil
+			case <-time.After(warmPathInterval):
+			}
+		}
+		pr, err := b.Ping(ctx, ip, tailcfg.PingDisco)
+		if err != nil {
+			if last != nil && ctx.Err() != nil {
+				return last, nil
+			}
+			return nil, err
+		}
+		last = pr
+		if pr.Err != "" || pr.Endpoint != "" {
+			break
+		}
+	}
+	return last, nil
+}
+
+// PeerIPForName returns the first Tailscale IP of the peer named name, which
+// is either its MagicDNS name, with or without the tailnet suffix, or its
+// hostname.
+func (b *LocalBackend) PeerIPForName(name string) (ip netip.Addr, ok bool) {
+	nm := b.NetMap()
+	if nm == nil {
+		return netip.Addr{}, false
+	}
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, p := range nm.Peers {
+		if len(p.Addresses) == 0 {
+			continue
+		}
+		fqdn := strings.ToLower(strings.TrimSuffix(p.Name, "."))
+		short := dnsname.FirstLabel(fqdn)
+		if name == fqdn || name == short || strings.EqualFold(name, p.Hostinfo.Hostname()) {
+			return p.Addresses[0].Addr(), true
+		}
+	}
+	return netip.Addr{}, false
+}
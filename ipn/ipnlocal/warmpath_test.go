@@ -0,0 +1,56 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package ipnlocal
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netmap"
+)
+
+func TestPeerIPForName(t *testing.T) {
+	b := &LocalBackend{}
+	if _, ok := b.PeerIPForName("foo"); ok {
+		t.Fatal("found a peer with no netmap")
+	}
+	b.netMap = &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				Name:      "foo.tail-scale.ts.net.",
+				Addresses: []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+				Hostinfo:  (&tailcfg.Hostinfo{Hostname: "Foo-Laptop"}).View(),
+			},
+			{
+				Name:     "noaddrs.tail-scale.ts.net.",
+				Hostinfo: (&tailcfg.Hostinfo{}).View(),
+			},
+		},
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"foo", "100.64.0.1"},
+		{"FOO", "100.64.0.1"},
+		{"foo.tail-scale.ts.net", "100.64.0.1"},
+		{"foo.tail-scale.ts.net.", "100.64.0.1"},
+		{"foo-laptop", "100.64.0.1"},
+		{"noaddrs", ""},
+		{"bar", ""},
+	}
+	for _, tt := range tests {
+		ip, ok := b.PeerIPForName(tt.name)
+		if tt.want == "" {
+			if ok {
+				t.Errorf("PeerIPForName(%q) = %v; want none", tt.name, ip)
+			}
+			continue
+		}
+		if !ok || ip.String() != tt.want {
+			t.Errorf("PeerIPForName(%q) = %v, %v; want %v", tt.name, ip, ok, tt.want)
+		}
+	}
+}
@@ -53,6 +53,10 @@ type Status struct {
 	// problems are detected)
 	Health []string
 
+	// HealthWarnings are the same problems as Health, each with a
+	// stable code and severity for monitoring systems.
+	HealthWarnings []HealthWarning `json:",omitempty"`
+
 	// This field is the legacy name of CurrentTailnet.MagicDNSSuffix.
 	//
 	// Deprecated: use CurrentTailnet.MagicDNSSuffix instead.
@@ -69,10 +73,33 @@ type Status struct {
 	// trailing periods, and without any "_acme-challenge." prefix.
 	CertDomains []string
 
+	// PowerSave is whether the node runs in its low-power mode, because
+	// the PowerSave pref is set or it's running on battery.
+	PowerSave bool `json:",omitempty"`
+
 	Peer map[key.NodePublic]*PeerStatus
 	User map[tailcfg.UserID]tailcfg.UserProfile
 }
 
+// HealthWarning is a health check problem, as reported in
+// Status.HealthWarnings.
+type HealthWarning struct {
+	// Code identifies the kind of problem, such as "network-down",
+	// "dns" or "accept-routes-off".
+	Code string
+
+	// Severity is "error" if the node can't connect or a subsystem is
+	// broken, and "warning" if something may not work as expected.
+	Severity string
+
+	// Text is the problem as reported in Status.Health.
+	Text string
+
+	// DocsURL, if non-empty, is a page describing the problem and how
+	// to fix it.
+	DocsURL string `json:",omitempty"`
+}
+
 // TKAKey describes a key trusted by network lock.
 type TKAKey struct {
 	Key      key.NLPublic
@@ -210,6 +237,12 @@ type PeerStatus struct {
 	CurAddr string // one of Addrs, or unique if roaming
 	Relay   string // DERP region
 
+	// DiscoPingsSent counts the disco pings sent to the peer's
+	// endpoints to find and keep the best path to it, and
+	// DiscoPongsReceived the replies to them.
+	DiscoPingsSent     int64 `json:",omitempty"`
+	DiscoPongsReceived int64 `json:",omitempty"`
+
 	RxBytes        int64
 	TxBytes        int64
 	Created        time.Time // time registered with tailcontrol
@@ -248,6 +281,11 @@ type PeerStatus struct {
 	// etc by default.
 	ShareeNode bool `json:",omitempty"`
 
+	// Maintenance indicates the node is in maintenance, and
+	// should be avoided for routing while other routers are
+	// available.
+	Maintenance bool `json:",omitempty"`
+
 	// InNetworkMap means that this peer was seen in our latest network map.
 	// In theory, all of InNetworkMap and InMagicSock and InEngine should all be true.
 	InNetworkMap bool
@@ -401,6 +439,12 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if v := st.CurAddr; v != "" {
 		e.CurAddr = v
 	}
+	if v := st.DiscoPingsSent; v != 0 {
+		e.DiscoPingsSent = v
+	}
+	if v := st.DiscoPongsReceived; v != 0 {
+		e.DiscoPongsReceived = v
+	}
 	if v := st.RxBytes; v != 0 {
 		e.RxBytes = v
 	}
@@ -443,6 +487,9 @@ func (sb *StatusBuilder) AddPeer(peer key.NodePublic, st *PeerStatus) {
 	if st.ShareeNode {
 		e.ShareeNode = true
 	}
+	if st.Maintenance {
+		e.Maintenance = true
+	}
 	if st.Active {
 		e.Active = true
 	}
@@ -670,6 +717,19 @@ func sortKey(ps *PeerStatus) string {
 	return string(raw[:])
 }
 
+// IPForwardingReport is the result of checking whether the system is
+// configured to forward packets as a subnet router or exit node.
+type IPForwardingReport struct {
+	// Warning, if non-empty, describes why forwarding won't work or
+	// may not work.
+	Warning string
+
+	// DisabledSysctls are the system-wide sysctls, such as
+	// "net.ipv4.ip_forward", that need enabling. tailscaled can enable
+	// them, on Linux, when asked.
+	DisabledSysctls []string `json:",omitempty"`
+}
+
 // DebugDERPRegionReport is the result of a "tailscale debug derp" command,
 // to let people debug a custom DERP setup.
 type DebugDERPRegionReport struct {
@@ -677,3 +737,99 @@ type DebugDERPRegionReport struct {
 	Warnings []string
 	Errors   []string
 }
+
+// DebugDialReport is the result of a "tailscale debug dial" command,
+// tracing each stage of connecting to a peer's TCP port.
+type DebugDialReport struct {
+	Stages []DebugDialStage
+}
+
+// DebugDialStage is one stage of a DebugDialReport.
+type DebugDialStage struct {
+	// Name is the stage: "netmap", "filter", "disco", "derp",
+	// "handshake" or "tcp".
+	Name string
+
+	LatencySeconds float64 `json:",omitempty"`
+
+	Info string `json:",omitempty"` // what the stage found
+	Err  string `json:",omitempty"` // why the stage failed, if it did
+}
+
+// FirstErr returns the first stage that failed, if any.
+func (r *DebugDialReport) FirstErr() (_ DebugDialStage, ok bool) {
+	for _, s := range r.Stages {
+		if s.Err != "" {
+			return s, true
+		}
+	}
+	return DebugDialStage{}, false
+}
+
+// DebugRouteReport is the result of a "tailscale debug resolve-route"
+// command, explaining how this node routes traffic to an IP.
+type DebugRouteReport struct {
+	IP netip.Addr
+
+	// Via is where the traffic goes: "local" (this node), "peer" (a
+	// peer's Tailscale IP), "subnet" (a peer's subnet route), "exit-node",
+	// "lan" (the host's local network, outside the tunnel), "outside"
+	// (the host's other routes, outside the tunnel) or "drop".
+	Via string
+
+	// Peer is the MagicDNS name of the peer the traffic is sent to, if
+	// any, and Route the route it's sent to the peer or LAN by.
+	Peer  string `json:",omitempty"`
+	Route netip.Prefix
+
+	// Reasons are the prefs, routes and packet filter rules the decision
+	// is based on, in the order they were considered.
+	Reasons []string
+}
+
+// DebugPortmapReport is the result of a "tailscale debug portmap --json"
+// command, to let people share how their gateway does port mapping.
+type DebugPortmapReport struct {
+	Gateway netip.Addr
+	SelfIP  netip.Addr
+
+	// PCP, PMP and UPnP are whether the gateway responded to a probe for
+	// each port mapping protocol.
+	PCP  bool
+	PMP  bool
+	UPnP bool
+
+	// UPnPServer is the Server header of the gateway's UPnP discovery
+	// response, describing its UPnP implementation.
+	UPnPServer string `json:",omitempty"`
+
+	// Attempts are the port mappings attempted, one per protocol.
+	Attempts []DebugPortmapAttempt
+
+	// Quirks are the gateway behaviors seen that may hinder NAT
+	// traversal.
+	Quirks []string
+
+	Errors []string
+
+	// Log is the verbose log of the port mapping client.
+	Log []string
+}
+
+// DebugPortmapAttempt is an attempt to map a port with one protocol, in
+// a DebugPortmapReport.
+type DebugPortmapAttempt struct {
+	Type      string // "pmp", "pcp" or "upnp"
+	LocalPort uint16
+	External  netip.AddrPort // zero if the attempt failed
+	Latency   time.Duration  // how long mapping the port took
+	Lease     time.Duration  // how long the gateway leased the mapping for
+
+	// Held is how long the mapping was held for, renewing it as needed,
+	// and Changes are the different External ports it changed to
+	// meanwhile, if any.
+	Held    time.Duration    `json:",omitempty"`
+	Changes []netip.AddrPort `json:",omitempty"`
+
+	Error string `json:",omitempty"`
+}
@@ -0,0 +1,32 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// serveDebugDial traces connecting to the TCP port "port" of the peer
+// "host", responding with an ipnstate.DebugDialReport.
+func (h *Handler) serveDebugDial(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return
+	}
+	host := r.FormValue("host")
+	port, err := strconv.ParseUint(r.FormValue("port"), 10, 16)
+	if host == "" || err != nil || port == 0 {
+		http.Error(w, "host and port required", http.StatusBadRequest)
+		return
+	}
+	rep := h.b.DebugDial(r.Context(), host, uint16(port))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
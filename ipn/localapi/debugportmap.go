@@ -0,0 +1,334 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/portmapper"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/logger"
+	"tailscale.com/wgengine/monitor"
+)
+
+// portmapTypes are the port mapping protocols, in the order that
+// serveDebugPortmap tries them.
+var portmapTypes = []string{"pmp", "pcp", "upnp"}
+
+// shortPortmapLease is the lease below which a port mapping is reported as
+// a quirk, for how often it has to be renewed.
+const shortPortmapLease = 5 * time.Minute
+
+// serveDebugPortmap probes the gateway for port mapping services, then
+// maps a port with each of them in turn, holding each mapping for the
+// "hold" duration if set. It streams the portmapper logs and a summary,
+// or with "format=json", responds with an ipnstate.DebugPortmapReport.
+func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+
+	dur, err := time.ParseDuration(r.FormValue("duration"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var hold time.Duration
+	if v := r.FormValue("hold"); v != "" {
+		if hold, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "invalid 'hold' parameter", http.StatusBadRequest)
+			return
+		}
+	}
+	types := portmapTypes
+	switch ty := r.FormValue("type"); ty {
+	case "":
+	case "pmp", "pcp", "upnp":
+		types = []string{ty}
+	default:
+		http.Error(w, "unknown portmap debug type", http.StatusBadRequest)
+		return
+	}
+	var gwOverride, selfOverride netip.Addr
+	if v := r.FormValue("gateway_and_self"); v != "" {
+		a, b, ok := strings.Cut(v, "/")
+		gwOverride, err = netip.ParseAddr(a)
+		if err == nil {
+			selfOverride, err = netip.ParseAddr(b)
+		}
+		if !ok || err != nil {
+			http.Error(w, "invalid 'gateway_and_self' parameter; want gatewayIP/selfIP", http.StatusBadRequest)
+			return
+		}
+	}
+	asJSON := r.FormValue("format") == "json"
+	if asJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain")
+	}
+
+	var (
+		rep         ipnstate.DebugPortmapReport
+		logLock     sync.Mutex
+		handlerDone bool
+	)
+	logf := func(format string, args ...any) {
+		line := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+		logLock.Lock()
+		defer logLock.Unlock()
+
+		// The portmapper can call this log function after the HTTP
+		// handler returns, which is not allowed and can cause a panic.
+		// If this happens, ignore the log lines since this typically
+		// occurs due to a client disconnect.
+		if handlerDone {
+			return
+		}
+		if asJSON {
+			rep.Log = append(rep.Log, line)
+			return
+		}
+
+		// Write and flush each line to the client so that output is streamed
+		fmt.Fprintln(w, line)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	defer func() {
+		logLock.Lock()
+		defer logLock.Unlock()
+		handlerDone = true
+		rep.Quirks = portmapQuirks(&rep)
+		if asJSON {
+			json.NewEncoder(w).Encode(rep)
+		} else {
+			writePortmapSummary(w, &rep)
+		}
+	}()
+	fail := func(format string, args ...any) {
+		msg := fmt.Sprintf(format, args...)
+		logf("%s", msg)
+		rep.Errors = append(rep.Errors, msg)
+	}
+
+	linkMon, err := monitor.New(logger.WithPrefix(logf, "monitor: "))
+	if err != nil {
+		fail("error creating monitor: %v", err)
+		return
+	}
+	defer linkMon.Close()
+
+	gatewayAndSelfIP := func() (gw, self netip.Addr, ok bool) {
+		if gwOverride.IsValid() {
+			return gwOverride, selfOverride, true
+		}
+		return linkMon.GatewayAndSelfIP()
+	}
+	gw, selfIP, ok := gatewayAndSelfIP()
+	if !ok {
+		fail("no gateway or self IP; %v", linkMon.InterfaceState())
+		return
+	}
+	logf("gw=%v; self=%v", gw, selfIP)
+	rep.Gateway, rep.SelfIP = gw, selfIP
+
+	ctx, cancel := context.WithTimeout(r.Context(), dur)
+	c := portmapper.NewClient(logger.WithPrefix(logf, "portmapper: "), portmapDebugKnobs(types), nil)
+	c.SetGatewayLookupFunc(gatewayAndSelfIP)
+	res, err := c.Probe(ctx)
+	rep.UPnPServer = c.UPnPServer()
+	c.Close()
+	cancel()
+	if err != nil {
+		fail("error in Probe: %v", err)
+		return
+	}
+	logf("Probe: %+v", res)
+	rep.PCP, rep.PMP, rep.UPnP = res.PCP, res.PMP, res.UPnP
+
+	for _, ty := range types {
+		if ty == "pmp" && !res.PMP || ty == "pcp" && !res.PCP || ty == "upnp" && !res.UPnP {
+			continue
+		}
+		rep.Attempts = append(rep.Attempts, portmapAttempt(r.Context(), logf, ty, gatewayAndSelfIP, dur, hold))
+	}
+	if len(rep.Attempts) == 0 {
+		logf("no portmapping services available")
+	}
+}
+
+// portmapDebugKnobs returns the portmapper knobs that enable only types,
+// with verbose logs.
+func portmapDebugKnobs(types []string) *portmapper.DebugKnobs {
+	k := &portmapper.DebugKnobs{
+		VerboseLogs: true,
+		DisablePMP:  true,
+		DisablePCP:  true,
+		DisableUPnP: true,
+	}
+	for _, ty := range types {
+		switch ty {
+		case "pmp":
+			k.DisablePMP = false
+		case "pcp":
+			k.DisablePCP = false
+		case "upnp":
+			k.DisableUPnP = false
+		}
+	}
+	return k
+}
+
+// portmapAttempt maps a UDP port with the port mapping protocol ty, waiting
+// up to timeout for it, then holds the mapping for hold, renewing it as
+// needed, unless ctx is done first.
+func portmapAttempt(ctx context.Context, logf logger.Logf, ty string, gatewayAndSelfIP func() (gw, self netip.Addr, ok bool), timeout, hold time.Duration) ipnstate.DebugPortmapAttempt {
+	a := ipnstate.DebugPortmapAttempt{Type: ty}
+	logf("mapping a port with %s ...", ty)
+
+	changed := make(chan bool, 1)
+	c := portmapper.NewClient(logger.WithPrefix(logf, "portmapper: "), portmapDebugKnobs([]string{ty}), func() {
+		select {
+		case changed <- true:
+		default:
+		}
+	})
+	defer c.Close()
+	c.SetGatewayLookupFunc(gatewayAndSelfIP)
+
+	uc, err := net.ListenPacket("udp", "0.0.0.0:0")
+	if err != nil {
+		a.Error = err.Error()
+		return a
+	}
+	defer uc.Close()
+	a.LocalPort = uint16(uc.LocalAddr().(*net.UDPAddr).Port)
+	c.SetLocalPort(a.LocalPort)
+
+	mapCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// The UPnP mapping needs the gateway's discovery response.
+	if _, err := c.Probe(mapCtx); err != nil {
+		a.Error = fmt.Sprintf("probe: %v", err)
+		logf("%s: %s", ty, a.Error)
+		return a
+	}
+	start := time.Now()
+	if _, ok := c.GetCachedMappingOrStartCreatingOne(); !ok {
+		select {
+		case <-changed:
+		case <-mapCtx.Done():
+		}
+	}
+	m, ok := c.CurrentMapping()
+	if !ok {
+		a.Error = "no mapping"
+		if mapCtx.Err() != nil {
+			a.Error = fmt.Sprintf("no mapping after %v", timeout)
+		}
+		logf("%s: %s", ty, a.Error)
+		return a
+	}
+	now := time.Now()
+	a.Latency = now.Sub(start)
+	a.External = m.External
+	a.Lease = m.GoodUntil.Sub(now).Round(time.Second)
+	logf("%s: mapped local port %d to %v in %v, for %v", ty, a.LocalPort, a.External, a.Latency.Round(time.Millisecond), a.Lease)
+
+	if hold <= 0 {
+		return a
+	}
+	logf("%s: holding the mapping for %v ...", ty, hold)
+	holdTimer := time.NewTimer(hold)
+	defer holdTimer.Stop()
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	last := a.External
+	for {
+		select {
+		case <-ctx.Done():
+		case <-holdTimer.C:
+		case <-tick.C:
+			// This also renews the mapping when it's due.
+			ext, _ := c.GetCachedMappingOrStartCreatingOne()
+			if ext != last {
+				logf("%s: mapping changed from %v to %v", ty, last, ext)
+				a.Changes = append(a.Changes, ext)
+				last = ext
+			}
+			continue
+		}
+		a.Held = time.Since(now).Round(time.Second)
+		return a
+	}
+}
+
+// portmapQuirks returns the gateway behaviors in rep that may hinder NAT
+// traversal.
+func portmapQuirks(rep *ipnstate.DebugPortmapReport) (quirks []string) {
+	var extIPs []netip.Addr
+	for _, a := range rep.Attempts {
+		if !a.External.IsValid() {
+			quirks = append(quirks, fmt.Sprintf("%s responded to the probe but didn't map a port: %s", a.Type, a.Error))
+			continue
+		}
+		ip := a.External.Addr()
+		if !ip.IsGlobalUnicast() || ip.IsPrivate() || tsaddr.CGNATRange().Contains(ip) {
+			quirks = append(quirks, fmt.Sprintf("the %s mapping's external IP %v isn't public; there's another NAT beyond the gateway", a.Type, ip))
+		}
+		if a.Lease < shortPortmapLease {
+			quirks = append(quirks, fmt.Sprintf("the %s mapping is leased for only %v", a.Type, a.Lease))
+		}
+		for _, ext := range a.Changes {
+			if ext.IsValid() {
+				quirks = append(quirks, fmt.Sprintf("the %s mapping changed to %v while held", a.Type, ext))
+			} else {
+				quirks = append(quirks, fmt.Sprintf("the %s mapping was lost while held", a.Type))
+			}
+		}
+		if len(extIPs) == 0 || extIPs[len(extIPs)-1] != ip {
+			extIPs = append(extIPs, ip)
+		}
+	}
+	if len(extIPs) > 1 {
+		quirks = append(quirks, fmt.Sprintf("the mappings disagree on the external IP: %v", extIPs))
+	}
+	return quirks
+}
+
+func writePortmapSummary(w http.ResponseWriter, rep *ipnstate.DebugPortmapReport) {
+	fmt.Fprintf(w, "\nSummary:\n")
+	if rep.UPnPServer != "" {
+		fmt.Fprintf(w, "  UPnP server: %s\n", rep.UPnPServer)
+	}
+	if len(rep.Attempts) == 0 && len(rep.Errors) == 0 {
+		fmt.Fprintf(w, "  no port mapping services\n")
+	}
+	for _, a := range rep.Attempts {
+		if a.External.IsValid() {
+			fmt.Fprintf(w, "  %s: mapped local port %d to %v, for %v\n", a.Type, a.LocalPort, a.External, a.Lease)
+		} else {
+			fmt.Fprintf(w, "  %s: %s\n", a.Type, a.Error)
+		}
+	}
+	for _, q := range rep.Quirks {
+		fmt.Fprintf(w, "  quirk: %s\n", q)
+	}
+	for _, e := range rep.Errors {
+		fmt.Fprintf(w, "  error: %s\n", e)
+	}
+}
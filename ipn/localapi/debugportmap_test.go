@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/net/portmapper"
+)
+
+func TestPortmapDebugKnobs(t *testing.T) {
+	got := portmapDebugKnobs([]string{"pcp"})
+	want := &portmapper.DebugKnobs{VerboseLogs: true, DisablePMP: true, DisableUPnP: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("knobs for pcp = %+v; want %+v", got, want)
+	}
+	got = portmapDebugKnobs(portmapTypes)
+	want = &portmapper.DebugKnobs{VerboseLogs: true}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("knobs for all = %+v; want %+v", got, want)
+	}
+}
+
+func TestPortmapQuirks(t *testing.T) {
+	ext := netip.MustParseAddrPort("203.0.113.5:41641")
+	tests := []struct {
+		name     string
+		attempts []ipnstate.DebugPortmapAttempt
+		want     []string
+	}{
+		{
+			name: "fine",
+			attempts: []ipnstate.DebugPortmapAttempt{
+				{Type: "pmp", External: ext, Lease: 2 * time.Hour},
+				{Type: "upnp", External: ext, Lease: time.Hour},
+			},
+		},
+		{
+			name: "failed",
+			attempts: []ipnstate.DebugPortmapAttempt{
+				{Type: "pcp", Error: "no mapping"},
+			},
+			want: []string{"pcp responded to the probe but didn't map a port: no mapping"},
+		},
+		{
+			name: "double-nat",
+			attempts: []ipnstate.DebugPortmapAttempt{
+				{Type: "upnp", External: netip.MustParseAddrPort("100.64.1.2:1234"), Lease: time.Hour},
+			},
+			want: []string{"the upnp mapping's external IP 100.64.1.2 isn't public; there's another NAT beyond the gateway"},
+		},
+		{
+			name: "short-lease-and-changes",
+			attempts: []ipnstate.DebugPortmapAttempt{
+				{Type: "pmp", External: ext, Lease: time.Minute, Changes: []netip.AddrPort{
+					netip.MustParseAddrPort("203.0.113.5:5000"),
+					{},
+				}},
+			},
+			want: []string{
+				"the pmp mapping is leased for only 1m0s",
+				"the pmp mapping changed to 203.0.113.5:5000 while held",
+				"the pmp mapping was lost while held",
+			},
+		},
+		{
+			name: "disagree",
+			attempts: []ipnstate.DebugPortmapAttempt{
+				{Type: "pmp", External: ext, Lease: time.Hour},
+				{Type: "upnp", External: netip.MustParseAddrPort("198.51.100.1:1234"), Lease: time.Hour},
+			},
+			want: []string{"the mappings disagree on the external IP: [203.0.113.5 198.51.100.1]"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := portmapQuirks(&ipnstate.DebugPortmapReport{Attempts: tt.attempts})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@
 	"net/http/httputil"
 	"net/netip"
 	"net/url"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
@@ -34,18 +35,20 @@
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/ipn/ipnstate"
 	"tailscale.com/logtail"
+	"tailscale.com/logtail/backoff"
 	"tailscale.com/net/netutil"
-	"tailscale.com/net/portmapper"
+	"tailscale.com/net/speedtest"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tka"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/ptr"
+	"tailscale.com/types/tkatype"
 	"tailscale.com/util/clientmetric"
 	"tailscale.com/util/httpm"
 	"tailscale.com/util/mak"
 	"tailscale.com/version"
-	"tailscale.com/wgengine/monitor"
+	"tailscale.com/wgengine/filter"
 )
 
 type localAPIHandler func(*Handler, http.ResponseWriter, *http.Request)
@@ -63,34 +66,47 @@
 	// The other /localapi/v0/NAME handlers are exact matches and contain only NAME
 	// without a trailing slash:
 	"bugreport":                   (*Handler).serveBugReport,
+	"capabilities":                (*Handler).serveCapabilities,
 	"check-ip-forwarding":         (*Handler).serveCheckIPForwarding,
 	"check-prefs":                 (*Handler).serveCheckPrefs,
 	"component-debug-logging":     (*Handler).serveComponentDebugLogging,
+	"config-drift":                (*Handler).serveConfigDrift,
 	"debug":                       (*Handler).serveDebug,
 	"debug-derp-region":           (*Handler).serveDebugDERPRegion,
+	"debug-dial":                  (*Handler).serveDebugDial,
+	"debug-filter-trace":          (*Handler).serveDebugFilterTrace,
+	"debug-latency-matrix":        (*Handler).serveDebugLatencyMatrix,
 	"debug-packet-filter-matches": (*Handler).serveDebugPacketFilterMatches,
 	"debug-packet-filter-rules":   (*Handler).serveDebugPacketFilterRules,
 	"debug-portmap":               (*Handler).serveDebugPortmap,
 	"debug-peer-endpoint-changes": (*Handler).serveDebugPeerEndpointChanges,
 	"debug-capture":               (*Handler).serveDebugCapture,
+	"debug-control-backoff":       (*Handler).serveDebugControlBackoff,
+	"debug-resolve-route":         (*Handler).serveDebugResolveRoute,
+	"debug-suggested-routes":      (*Handler).serveDebugSuggestedRoutes,
 	"derpmap":                     (*Handler).serveDERPMap,
 	"dev-set-state-store":         (*Handler).serveDevSetStateStore,
 	"set-push-device-token":       (*Handler).serveSetPushDeviceToken,
 	"dial":                        (*Handler).serveDial,
 	"file-targets":                (*Handler).serveFileTargets,
 	"goroutines":                  (*Handler).serveGoroutines,
+	"health-checks":               (*Handler).serveHealthChecks,
 	"id-token":                    (*Handler).serveIDToken,
 	"login-interactive":           (*Handler).serveLoginInteractive,
 	"logout":                      (*Handler).serveLogout,
 	"logtap":                      (*Handler).serveLogTap,
 	"metrics":                     (*Handler).serveMetrics,
+	"openapi.json":                (*Handler).serveOpenAPI,
 	"ping":                        (*Handler).servePing,
+	"posture":                     (*Handler).servePosture,
 	"prefs":                       (*Handler).servePrefs,
 	"pprof":                       (*Handler).servePprof,
+	"preview-prefs":               (*Handler).servePreviewPrefs,
 	"reset-auth":                  (*Handler).serveResetAuth,
 	"serve-config":                (*Handler).serveServeConfig,
 	"set-dns":                     (*Handler).serveSetDNS,
 	"set-expiry-sooner":           (*Handler).serveSetExpirySooner,
+	"speedtest":                   (*Handler).serveSpeedTest,
 	"start":                       (*Handler).serveStart,
 	"status":                      (*Handler).serveStatus,
 	"tka/init":                    (*Handler).serveTKAInit,
@@ -98,14 +114,90 @@
 	"tka/modify":                  (*Handler).serveTKAModify,
 	"tka/sign":                    (*Handler).serveTKASign,
 	"tka/status":                  (*Handler).serveTKAStatus,
+	"tka/submit-signature":        (*Handler).serveTKASubmitSignature,
 	"tka/disable":                 (*Handler).serveTKADisable,
 	"tka/force-local-disable":     (*Handler).serveTKALocalDisable,
 	"tka/affected-sigs":           (*Handler).serveTKAAffectedSigs,
 	"upload-client-metrics":       (*Handler).serveUploadClientMetrics,
+	"usage":                       (*Handler).serveUsage,
+	"wake-on-lan":                 (*Handler).serveWakeOnLAN,
+	"warm-path":                   (*Handler).serveWarmPath,
 	"watch-ipn-bus":               (*Handler).serveWatchIPNBus,
 	"whois":                       (*Handler).serveWhoIs,
 }
 
+// remoteAdminAllowed are the handlers served to remote administrators
+// (see ipn.Prefs.RemoteAdmin), which still need PermitWrite to change
+// anything. The others would let them act as this node, read its secrets
+// or traffic, or take the node away from its owner, beyond administering
+// it.
+var remoteAdminAllowed = map[string]bool{
+	"capabilities":        true,
+	"check-ip-forwarding": true,
+	"config-drift":        true,
+	"derpmap":             true,
+	"file-targets":        true,
+	"health-checks":       true,
+	"metrics":             true,
+	"openapi.json":        true,
+	"ping":                true,
+	"prefs":               true, // edits limited by remoteAdminPrefsAllowed
+	"status":              true,
+	"tka/log":             true,
+	"tka/status":          true,
+	"usage":               true,
+	"watch-ipn-bus":       true,
+	"whois":               true,
+}
+
+// remoteAdminPrefsAllowed are the MaskedPrefs fields remote administrators
+// may set. Others, such as ControlURL, RunSSH or RemoteAdmin itself, could
+// hand the node to someone else or widen remote access to it.
+var remoteAdminPrefsAllowed = map[string]bool{
+	"AdvertiseRoutesSet":        true,
+	"CorpDNSSet":                true,
+	"ExitNodeAllowLANAccessSet": true,
+	"ExitNodeAllowLANCIDRsSet":  true,
+	"ExitNodeIDSet":             true,
+	"ExitNodeIPSet":             true,
+	"ExitRateLimitSet":          true,
+	"HostnameSet":               true,
+	"KillSwitchSet":             true,
+	"MaintenanceSet":            true,
+	"NoSNATSet":                 true,
+	"PowerSaveSet":              true,
+	"RouteAllSet":               true,
+	"RouteMetricSet":            true,
+	"ShieldsUpSet":              true,
+}
+
+// checkRemoteAdminPrefsEdit returns an error if mp sets prefs not in
+// remoteAdminPrefsAllowed.
+func checkRemoteAdminPrefsEdit(mp *ipn.MaskedPrefs) error {
+	v := reflect.ValueOf(mp).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if !strings.HasSuffix(name, "Set") || t.Field(i).Type.Kind() != reflect.Bool {
+			continue
+		}
+		if v.Field(i).Bool() && !remoteAdminPrefsAllowed[name] {
+			return fmt.Errorf("%s not editable by remote administrators", strings.TrimSuffix(name, "Set"))
+		}
+	}
+	return nil
+}
+
+func init() {
+	ipnlocal.RegisterNewRemoteAdminHandler(func(b *ipnlocal.LocalBackend, logf logger.Logf, logID string, permitWrite bool) http.Handler {
+		h := NewHandler(b, logf, logID)
+		h.PermitRead = true
+		h.PermitWrite = permitWrite
+		h.remote = true
+		return h
+	})
+}
+
 func randHex(n int) string {
 	b := make([]byte, n)
 	rand.Read(b)
@@ -148,6 +240,7 @@ type Handler struct {
 	b            *ipnlocal.LocalBackend
 	logf         logger.Logf
 	backendLogID string
+	remote       bool // serving a remote administrator over the PeerAPI
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -178,6 +271,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
+	if h.remote && !isRemoteAdminAllowed(r.URL.Path) {
+		http.Error(w, "not available to remote administrators", http.StatusForbidden)
+		return
+	}
 	if fn, ok := handlerForPath(r.URL.Path); ok {
 		fn(h, w, r)
 	} else {
@@ -212,6 +309,21 @@ func (h *Handler) validHost(hostname string) bool {
 	return addr.IsLoopback()
 }
 
+// isRemoteAdminAllowed reports whether the handler of urlPath is in
+// remoteAdminAllowed.
+func isRemoteAdminAllowed(urlPath string) bool {
+	suff, ok := strings.CutPrefix(urlPath, "/localapi/v0/")
+	if !ok {
+		return false
+	}
+	if remoteAdminAllowed[suff] {
+		return true
+	}
+	// Prefix match handlers, whose keys end with a slash.
+	i := strings.IndexByte(suff, '/')
+	return i != -1 && remoteAdminAllowed[suff[:i+1]]
+}
+
 // handlerForPath returns the LocalAPI handler for the provided Request.URI.Path.
 // (the path doesn't include any query parameters)
 func handlerForPath(urlPath string) (h localAPIHandler, ok bool) {
@@ -588,168 +700,60 @@ func (h *Handler) serveDebugPacketFilterRules(w http.ResponseWriter, r *http.Req
 	enc.Encode(nm.PacketFilterRules)
 }
 
-func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
-	if !h.PermitWrite {
-		http.Error(w, "debug access denied", http.StatusForbidden)
-		return
-	}
-	nm := h.b.NetMap()
-	if nm == nil {
-		http.Error(w, "no netmap", http.StatusNotFound)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-
-	enc := json.NewEncoder(w)
-	enc.SetIndent("", "\t")
-	enc.Encode(nm.PacketFilter)
-}
-
-func (h *Handler) serveDebugPortmap(w http.ResponseWriter, r *http.Request) {
+// serveDebugFilterTrace returns the packet filter decisions recorded since
+// the trace was started with a POST, for the duration of the "dur"
+// parameter, and optionally only for flows to or from the "ip" parameter.
+func (h *Handler) serveDebugFilterTrace(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "debug access denied", http.StatusForbidden)
 		return
 	}
-	w.Header().Set("Content-Type", "text/plain")
-
-	dur, err := time.ParseDuration(r.FormValue("duration"))
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
-
-	gwSelf := r.FormValue("gateway_and_self")
-
-	// Update portmapper debug flags
-	debugKnobs := &portmapper.DebugKnobs{VerboseLogs: true}
-	switch r.FormValue("type") {
-	case "":
-	case "pmp":
-		debugKnobs.DisablePCP = true
-		debugKnobs.DisableUPnP = true
-	case "pcp":
-		debugKnobs.DisablePMP = true
-		debugKnobs.DisableUPnP = true
-	case "upnp":
-		debugKnobs.DisablePCP = true
-		debugKnobs.DisablePMP = true
-	default:
-		http.Error(w, "unknown portmap debug type", http.StatusBadRequest)
-		return
-	}
-
-	var (
-		logLock     sync.Mutex
-		handlerDone bool
-	)
-	logf := func(format string, args ...any) {
-		if !strings.HasSuffix(format, "\n") {
-			format = format + "\n"
+	switch r.Method {
+	case "GET":
+		trace := h.b.FilterTrace()
+		if trace == nil {
+			trace = []filter.TraceEntry{}
 		}
-
-		logLock.Lock()
-		defer logLock.Unlock()
-
-		// The portmapper can call this log function after the HTTP
-		// handler returns, which is not allowed and can cause a panic.
-		// If this happens, ignore the log lines since this typically
-		// occurs due to a client disconnect.
-		if handlerDone {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(trace)
+	case "POST":
+		d, err := time.ParseDuration(r.FormValue("dur"))
+		if err != nil {
+			http.Error(w, "invalid dur", 400)
 			return
 		}
-
-		// Write and flush each line to the client so that output is streamed
-		fmt.Fprintf(w, format, args...)
-		if f, ok := w.(http.Flusher); ok {
-			f.Flush()
-		}
-	}
-	defer func() {
-		logLock.Lock()
-		handlerDone = true
-		logLock.Unlock()
-	}()
-
-	ctx, cancel := context.WithTimeout(r.Context(), dur)
-	defer cancel()
-
-	done := make(chan bool, 1)
-
-	var c *portmapper.Client
-	c = portmapper.NewClient(logger.WithPrefix(logf, "portmapper: "), debugKnobs, func() {
-		logf("portmapping changed.")
-		logf("have mapping: %v", c.HaveMapping())
-
-		if ext, ok := c.GetCachedMappingOrStartCreatingOne(); ok {
-			logf("cb: mapping: %v", ext)
-			select {
-			case done <- true:
-			default:
+		var ip netip.Addr
+		if s := r.FormValue("ip"); s != "" {
+			if ip, err = netip.ParseAddr(s); err != nil {
+				http.Error(w, "invalid ip", 400)
+				return
 			}
-			return
 		}
-		logf("cb: no mapping")
-	})
-	defer c.Close()
-
-	linkMon, err := monitor.New(logger.WithPrefix(logf, "monitor: "))
-	if err != nil {
-		logf("error creating monitor: %v", err)
-		return
-	}
-
-	gatewayAndSelfIP := func() (gw, self netip.Addr, ok bool) {
-		if a, b, ok := strings.Cut(gwSelf, "/"); ok {
-			gw = netip.MustParseAddr(a)
-			self = netip.MustParseAddr(b)
-			return gw, self, true
+		if err := h.b.StartFilterTrace(d, ip); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
 		}
-		return linkMon.GatewayAndSelfIP()
-	}
-
-	c.SetGatewayLookupFunc(gatewayAndSelfIP)
-
-	gw, selfIP, ok := gatewayAndSelfIP()
-	if !ok {
-		logf("no gateway or self IP; %v", linkMon.InterfaceState())
-		return
-	}
-	logf("gw=%v; self=%v", gw, selfIP)
-
-	uc, err := net.ListenPacket("udp", "0.0.0.0:0")
-	if err != nil {
-		return
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "want GET or POST", 400)
 	}
-	defer uc.Close()
-	c.SetLocalPort(uint16(uc.LocalAddr().(*net.UDPAddr).Port))
+}
 
-	res, err := c.Probe(ctx)
-	if err != nil {
-		logf("error in Probe: %v", err)
+func (h *Handler) serveDebugPacketFilterMatches(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
 		return
 	}
-	logf("Probe: %+v", res)
-
-	if !res.PCP && !res.PMP && !res.UPnP {
-		logf("no portmapping services available")
+	nm := h.b.NetMap()
+	if nm == nil {
+		http.Error(w, "no netmap", http.StatusNotFound)
 		return
 	}
+	w.Header().Set("Content-Type", "application/json")
 
-	if ext, ok := c.GetCachedMappingOrStartCreatingOne(); ok {
-		logf("mapping: %v", ext)
-	} else {
-		logf("no mapping")
-	}
-
-	select {
-	case <-done:
-	case <-ctx.Done():
-		if r.Context().Err() == nil {
-			logf("serveDebugPortmap: context done: %v", ctx.Err())
-		} else {
-			h.logf("serveDebugPortmap: context done: %v", ctx.Err())
-		}
-	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(nm.PacketFilter)
 }
 
 func (h *Handler) serveComponentDebugLogging(w http.ResponseWriter, r *http.Request) {
@@ -840,16 +844,29 @@ func (h *Handler) serveCheckIPForwarding(w http.ResponseWriter, r *http.Request)
 		http.Error(w, "IP forwarding check access denied", http.StatusForbidden)
 		return
 	}
-	var warning string
-	if err := h.b.CheckIPForwarding(); err != nil {
-		warning = err.Error()
+	var (
+		res *ipnstate.IPForwardingReport
+		err error
+	)
+	switch r.Method {
+	case "GET":
+		res, err = h.b.IPForwardingReport()
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "IP forwarding fix access denied", http.StatusForbidden)
+			return
+		}
+		res, err = h.b.FixIPForwarding()
+	default:
+		http.Error(w, "want GET or POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(struct {
-		Warning string
-	}{
-		Warning: warning,
-	})
+	json.NewEncoder(w).Encode(res)
 }
 
 func (h *Handler) serveStatus(w http.ResponseWriter, r *http.Request) {
@@ -897,6 +914,61 @@ func (h *Handler) serveDebugPeerEndpointChanges(w http.ResponseWriter, r *http.R
 	e.Encode(chs)
 }
 
+// serveDebugResolveRoute explains how traffic to the "ip" parameter is
+// routed, responding with an ipnstate.DebugRouteReport.
+func (h *Handler) serveDebugResolveRoute(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid or missing 'ip' parameter", 400)
+		return
+	}
+	rep, err := h.b.ResolveRoute(ip)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// serveDebugSuggestedRoutes returns the subnets that traffic through this
+// node was observed to or from. A POST with an "observe" parameter of
+// true or false first starts or stops observing.
+func (h *Handler) serveDebugSuggestedRoutes(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "status access denied", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case "GET":
+	case "POST":
+		if !h.PermitWrite {
+			http.Error(w, "access denied", http.StatusForbidden)
+			return
+		}
+		on, err := strconv.ParseBool(r.FormValue("observe"))
+		if err != nil {
+			http.Error(w, "invalid 'observe' parameter", 400)
+			return
+		}
+		if err := h.b.SetObserveRoutes(on); err != nil {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+	default:
+		http.Error(w, "want GET or POST", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.SuggestedRoutes())
+}
+
 // InUseOtherUserIPNStream reports whether r is a request for the watch-ipn-bus
 // handler. If so, it writes an ipn.Notify InUseOtherUser message to the user
 // and returns true. Otherwise it returns false, in which case it doesn't write
@@ -944,6 +1016,10 @@ func (h *Handler) serveWatchIPNBus(w http.ResponseWriter, r *http.Request) {
 		}
 		mask = ipn.NotifyWatchOpt(v)
 	}
+	if h.remote {
+		// Never give the node's private key to a tailnet peer.
+		mask |= ipn.NotifyNoPrivateKeys
+	}
 	ctx := r.Context()
 	h.b.WatchNotifications(ctx, mask, f.Flush, func(roNotify *ipn.Notify) (keepGoing bool) {
 		js, err := json.Marshal(roNotify)
@@ -1030,6 +1106,12 @@ func (h *Handler) servePrefs(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), 400)
 			return
 		}
+		if h.remote {
+			if err := checkRemoteAdminPrefsEdit(mp); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
 		var err error
 		prefs, err = h.b.EditPrefs(mp)
 		if err != nil {
@@ -1077,6 +1159,35 @@ func (h *Handler) serveCheckPrefs(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// servePreviewPrefs reports the changes that PATCHing the MaskedPrefs in
+// the request body to /localapi/v0/prefs would make, without making them.
+func (h *Handler) servePreviewPrefs(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "preview-prefs access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
+		return
+	}
+	mp := new(ipn.MaskedPrefs)
+	if err := json.NewDecoder(r.Body).Decode(mp); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	changes, err := h.b.PreviewEditPrefs(mp)
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(resJSON{Error: err.Error()})
+		return
+	}
+	if changes == nil {
+		changes = []ipn.PrefChange{}
+	}
+	json.NewEncoder(w).Encode(changes)
+}
+
 func (h *Handler) serveFiles(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "file access denied", http.StatusForbidden)
@@ -1274,6 +1385,120 @@ func (h *Handler) serveDERPMap(w http.ResponseWriter, r *http.Request) {
 	e.Encode(h.b.DERPMap())
 }
 
+// serveHealthChecks runs the registered health checks and returns their
+// results.
+func (h *Handler) serveHealthChecks(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "health check access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(health.CheckResults())
+}
+
+// serveConfigDrift returns the prefs declared in tailscaled's --config
+// file that were changed since.
+func (h *Handler) serveConfigDrift(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "config-drift access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	drift := h.b.ConfigDrift()
+	if drift == nil {
+		drift = []ipn.PrefChange{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
+
+// serveDebugControlBackoff returns the schedules the control client
+// retries failed requests to the control server on.
+func (h *Handler) serveDebugControlBackoff(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	st := h.b.ControlBackoff()
+	if st == nil {
+		st = []backoff.State{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+// serveUsage returns the daily totals of the traffic sent to and received
+// from peers, from the optional "since" date ("2006-01-02") onwards.
+func (h *Handler) serveUsage(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "usage access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	since := r.FormValue("since")
+	if since != "" {
+		if _, err := time.Parse("2006-01-02", since); err != nil {
+			http.Error(w, "invalid 'since' parameter", 400)
+			return
+		}
+	}
+	res, err := h.b.Usage(since)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(res)
+}
+
+func (h *Handler) serveCapabilities(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "capabilities access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Capabilities())
+}
+
+func (h *Handler) servePosture(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "posture access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "want GET", 400)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(h.b.Posture())
+}
+
 // serveSetExpirySooner sets the expiry date on the current machine, specified
 // by an `expiry` unix timestamp as POST or query param.
 func (h *Handler) serveSetExpirySooner(w http.ResponseWriter, r *http.Request) {
@@ -1337,6 +1562,171 @@ func (h *Handler) servePing(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// serveWakeOnLAN asks the peer with the Tailscale IP of the "via"
+// parameter to send a Wake-on-LAN packet for the "mac" parameter on its
+// LANs.
+func (h *Handler) serveWakeOnLAN(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "wake-on-lan access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	via, err := netip.ParseAddr(r.FormValue("via"))
+	if err != nil {
+		http.Error(w, "invalid 'via' parameter", 400)
+		return
+	}
+	mac, err := net.ParseMAC(r.FormValue("mac"))
+	if err != nil {
+		http.Error(w, "invalid 'mac' parameter", 400)
+		return
+	}
+	res, err := h.b.WakeOnLAN(r.Context(), via, mac)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveWarmPath establishes the best path to the peer of the "peer"
+// parameter, a Tailscale IP or peer name, ahead of traffic to it.
+func (h *Handler) serveWarmPath(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitRead {
+		http.Error(w, "warm-path access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	peer := r.FormValue("peer")
+	if peer == "" {
+		http.Error(w, "missing 'peer' parameter", 400)
+		return
+	}
+	ip, err := netip.ParseAddr(peer)
+	if err != nil {
+		var ok bool
+		ip, ok = h.b.PeerIPForName(peer)
+		if !ok {
+			http.Error(w, "no peer named "+peer, 404)
+			return
+		}
+	}
+	res, err := h.b.WarmPath(r.Context(), ip)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveSpeedTest runs a speedtest with the peer with the Tailscale IP of
+// the "ip" parameter, with the "proto" ("tcp" or "udp"), "direction"
+// ("download" or "upload"), "duration" and "bitrate" (bits per second of
+// UDP tests) parameters.
+func (h *Handler) serveSpeedTest(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "speedtest access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	ip, err := netip.ParseAddr(r.FormValue("ip"))
+	if err != nil {
+		http.Error(w, "invalid 'ip' parameter", 400)
+		return
+	}
+	opts := speedtest.Options{
+		Duration: speedtest.DefaultDuration,
+		Protocol: speedtest.Protocol(r.FormValue("proto")),
+	}
+	switch opts.Protocol {
+	case "", speedtest.TCP, speedtest.UDP:
+	default:
+		http.Error(w, "invalid 'proto' parameter", 400)
+		return
+	}
+	switch r.FormValue("direction") {
+	case "", "download":
+		opts.Direction = speedtest.Download
+	case "upload":
+		opts.Direction = speedtest.Upload
+	default:
+		http.Error(w, "invalid 'direction' parameter", 400)
+		return
+	}
+	if v := r.FormValue("duration"); v != "" {
+		if opts.Duration, err = time.ParseDuration(v); err != nil {
+			http.Error(w, "invalid 'duration' parameter", 400)
+			return
+		}
+	}
+	if opts.Duration < speedtest.MinDuration || opts.Duration > speedtest.MaxDuration {
+		http.Error(w, fmt.Sprintf("duration must be within %v and %v", speedtest.MinDuration, speedtest.MaxDuration), 400)
+		return
+	}
+	if v := r.FormValue("bitrate"); v != "" {
+		if opts.UDPBitrate, err = strconv.ParseInt(v, 10, 64); err != nil || opts.UDPBitrate <= 0 {
+			http.Error(w, "invalid 'bitrate' parameter", 400)
+			return
+		}
+	}
+	res, err := h.b.SpeedTest(r.Context(), ip, opts)
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// serveDebugLatencyMatrix measures the latency to the nodes with the
+// Tailscale IPs of the "ip" parameters and, if "all_pairs" is true,
+// between each pair of them.
+func (h *Handler) serveDebugLatencyMatrix(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "debug access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "want POST", 400)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	var ips []netip.Addr
+	for _, s := range r.Form["ip"] {
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			http.Error(w, "invalid 'ip' parameter", 400)
+			return
+		}
+		ips = append(ips, ip)
+	}
+	if len(ips) == 0 {
+		http.Error(w, "missing 'ip' parameter", 400)
+		return
+	}
+	res, err := h.b.LatencyMatrix(r.Context(), ips, r.Form.Get("all_pairs") == "true")
+	if err != nil {
+		writeErrorJSON(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
 func (h *Handler) serveDial(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "POST required", http.StatusMethodNotAllowed)
@@ -1413,18 +1803,18 @@ func (h *Handler) serveSetPushDeviceToken(w http.ResponseWriter, r *http.Request
 	w.WriteHeader(http.StatusOK)
 }
 
+type clientMetricJSON struct {
+	Name string `json:"name"`
+	// One of "counter" or "gauge"
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+}
+
 func (h *Handler) serveUploadClientMetrics(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "unsupported method", http.StatusMethodNotAllowed)
 		return
 	}
-	type clientMetricJSON struct {
-		Name string `json:"name"`
-		// One of "counter" or "gauge"
-		Type  string `json:"type"`
-		Value int    `json:"value"`
-	}
-
 	var clientMetrics []clientMetricJSON
 	if err := json.NewDecoder(r.Body).Decode(&clientMetrics); err != nil {
 		http.Error(w, "invalid JSON body", 400)
@@ -1480,6 +1870,11 @@ func (h *Handler) serveTKAStatus(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+type tkaSignRequest struct {
+	NodeKey        key.NodePublic
+	RotationPublic []byte
+}
+
 func (h *Handler) serveTKASign(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitRead {
 		http.Error(w, "lock status access denied", http.StatusForbidden)
@@ -1490,11 +1885,7 @@ func (h *Handler) serveTKASign(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type signRequest struct {
-		NodeKey        key.NodePublic
-		RotationPublic []byte
-	}
-	var req signRequest
+	var req tkaSignRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON body", http.StatusBadRequest)
 		return
@@ -1508,6 +1899,40 @@ type signRequest struct {
 	w.WriteHeader(http.StatusOK)
 }
 
+type tkaSubmitSignatureRequest struct {
+	Signature tkatype.MarshaledSignature
+}
+
+func (h *Handler) serveTKASubmitSignature(w http.ResponseWriter, r *http.Request) {
+	if !h.PermitWrite {
+		http.Error(w, "lock sign access denied", http.StatusForbidden)
+		return
+	}
+	if r.Method != httpm.POST {
+		http.Error(w, "use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req tkaSubmitSignatureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.b.NetworkLockSubmitSignature(req.Signature); err != nil {
+		http.Error(w, "submitting signature failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type tkaInitRequest struct {
+	Keys               []tka.Key
+	DisablementValues  [][]byte
+	SupportDisablement []byte
+}
+
 func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "lock init access denied", http.StatusForbidden)
@@ -1518,12 +1943,7 @@ func (h *Handler) serveTKAInit(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type initRequest struct {
-		Keys               []tka.Key
-		DisablementValues  [][]byte
-		SupportDisablement []byte
-	}
-	var req initRequest
+	var req tkaInitRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON body", 400)
 		return
@@ -1543,6 +1963,11 @@ type initRequest struct {
 	w.Write(j)
 }
 
+type tkaModifyRequest struct {
+	AddKeys    []tka.Key
+	RemoveKeys []tka.Key
+}
+
 func (h *Handler) serveTKAModify(w http.ResponseWriter, r *http.Request) {
 	if !h.PermitWrite {
 		http.Error(w, "network-lock modify access denied", http.StatusForbidden)
@@ -1553,11 +1978,7 @@ func (h *Handler) serveTKAModify(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	type modifyRequest struct {
-		AddKeys    []tka.Key
-		RemoveKeys []tka.Key
-	}
-	var req modifyRequest
+	var req tkaModifyRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid JSON body", 400)
 		return
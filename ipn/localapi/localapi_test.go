@@ -4,8 +4,10 @@
 package localapi
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -13,8 +15,11 @@
 
 	"tailscale.com/client/tailscale/apitype"
 	"tailscale.com/hostinfo"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
 	"tailscale.com/tstest"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
 )
 
 func TestValidHost(t *testing.T) {
@@ -77,3 +82,117 @@ func TestSetPushDeviceToken(t *testing.T) {
 		t.Errorf("hostinfo.PushDeviceToken=%q, want %q", got, want)
 	}
 }
+
+func TestIsRemoteAdminAllowed(t *testing.T) {
+	tests := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/localapi/v0/status", true},
+		{"/localapi/v0/prefs", true},
+		{"/localapi/v0/tka/status", true},
+		{"/localapi/v0/cert/node.example.ts.net", false},
+		{"/localapi/v0/serve-config", false},
+		{"/localapi/v0/set-dns", false},
+		{"/localapi/v0/debug-capture", false},
+		{"/localapi/v0/pprof", false},
+		{"/localapi/v0/logout", false},
+		{"/localapi/v0/profiles/", false},
+		{"/localapi/v0/tka/modify", false},
+		{"/localapi/v0/tka/sign", false},
+		{"/localapi/v0/dial", false},
+		{"/localapi/v0/id-token", false},
+		{"/localapi/v0/files/foo.txt", false},
+		{"/localapi/v0/status/extra", false},
+		{"/", false},
+	}
+	for _, tt := range tests {
+		if got := isRemoteAdminAllowed(tt.path); got != tt.allowed {
+			t.Errorf("isRemoteAdminAllowed(%q) = %v; want %v", tt.path, got, tt.allowed)
+		}
+	}
+}
+
+func TestRemoteAdminRefused(t *testing.T) {
+	h := &Handler{
+		PermitRead:  true,
+		PermitWrite: true,
+		remote:      true,
+		b:           &ipnlocal.LocalBackend{},
+	}
+	for _, path := range []string{"/localapi/v0/cert/node.example.ts.net", "/localapi/v0/serve-config"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Host = apitype.LocalAPIHost
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("GET %s by remote admin = %v; want %v", path, rec.Code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestRemoteWatchIPNBusNoPrivateKeys(t *testing.T) {
+	b := &ipnlocal.LocalBackend{}
+	h := &Handler{
+		PermitRead:  true,
+		PermitWrite: true,
+		remote:      true,
+		b:           b,
+		logf:        t.Logf,
+	}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	// Ask for the netmap without NotifyNoPrivateKeys.
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/localapi/v0/watch-ipn-bus?mask=%d", ts.URL, ipn.NotifyInitialNetMap), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = apitype.LocalAPIHost
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %v", res.Status)
+	}
+	br := bufio.NewReader(res.Body)
+	// The initial notification means the watcher is registered.
+	if _, err := br.ReadBytes('\n'); err != nil {
+		t.Fatal(err)
+	}
+
+	b.DebugNotify(ipn.Notify{NetMap: &netmap.NetworkMap{PrivateKey: key.NewNode()}})
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var n ipn.Notify
+	if err := json.Unmarshal(line, &n); err != nil {
+		t.Fatal(err)
+	}
+	if n.NetMap == nil {
+		t.Fatalf("got %s; want a netmap", line)
+	}
+	if !n.NetMap.PrivateKey.IsZero() {
+		t.Errorf("remote watcher got the node's private key")
+	}
+}
+
+func TestCheckRemoteAdminPrefsEdit(t *testing.T) {
+	tests := []struct {
+		mp ipn.MaskedPrefs
+		ok bool
+	}{
+		{ipn.MaskedPrefs{ExitNodeIDSet: true, RouteAllSet: true}, true},
+		{ipn.MaskedPrefs{ControlURLSet: true}, false},
+		{ipn.MaskedPrefs{RemoteAdminSet: true}, false},
+		{ipn.MaskedPrefs{RunSSHSet: true, CorpDNSSet: true}, false},
+	}
+	for _, tt := range tests {
+		if err := checkRemoteAdminPrefsEdit(&tt.mp); (err == nil) != tt.ok {
+			t.Errorf("checkRemoteAdminPrefsEdit(%+v) = %v; want ok=%v", tt.mp, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,494 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/health"
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/logtail/backoff"
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/tkatype"
+	"tailscale.com/util/httpm"
+	"tailscale.com/version"
+	"tailscale.com/wgengine/filter"
+	"tailscale.com/wgengine/magicsock"
+)
+
+// handlerDoc documents one or more methods of a LocalAPI path, for the
+// OpenAPI document served at /localapi/v0/openapi.json.
+type handlerDoc struct {
+	// path is the path after "/localapi/v0/", with "{name}" for the
+	// parts of the path matched by a prefix handler.
+	path    string
+	methods []string
+	summary string
+
+	// params are the query (or form) parameters, by name, with their
+	// descriptions.
+	params map[string]string
+
+	// req is the type of the JSON request body, if any.
+	req reflect.Type
+
+	// res is the type of the JSON response body, if any. If resType is
+	// set instead, it's the content type of the non-JSON response.
+	res     reflect.Type
+	resType string
+}
+
+func typeOf[T any]() reflect.Type { return reflect.TypeOf((*T)(nil)).Elem() }
+
+// handlerDocs documents the LocalAPI. Every handler must be documented;
+// TestHandlerDocs checks it. The same path may be in several entries with
+// different methods.
+var handlerDocs = []handlerDoc{
+	{path: "bugreport", methods: []string{httpm.POST}, summary: "Logs a bug report marker and returns it",
+		params:  map[string]string{"note": "note to log with the marker", "diagnose": "whether to log diagnostics", "record": "whether to wait for the reproduction of the bug, with verbose logging"},
+		resType: "text/plain"},
+	{path: "capabilities", methods: []string{httpm.GET}, summary: "Returns what this node can do",
+		res: typeOf[apitype.CapabilitiesResponse]()},
+	{path: "cert/{domain}", methods: []string{httpm.GET}, summary: "Returns a TLS certificate or key for domain",
+		params:  map[string]string{"type": `"cert" (the default), "key" or "pair"`},
+		resType: "text/plain"},
+	{path: "check-ip-forwarding", methods: []string{httpm.GET, httpm.POST}, summary: "Checks that IP forwarding is enabled for subnet routing; POST enables the disabled sysctls until reboot",
+		res: typeOf[ipnstate.IPForwardingReport]()},
+	{path: "check-prefs", methods: []string{httpm.POST}, summary: "Checks that prefs are valid",
+		req: typeOf[ipn.Prefs](), res: typeOf[resJSON]()},
+	{path: "component-debug-logging", methods: []string{httpm.POST}, summary: "Enables the debug logging of a component",
+		params: map[string]string{"component": "the component", "secs": "how long to enable it for, or zero to disable it"},
+		res:    typeOf[struct{ Error string }]()},
+	{path: "config-drift", methods: []string{httpm.GET}, summary: "Returns the prefs declared in tailscaled's config file that were changed since",
+		res: typeOf[[]ipn.PrefChange]()},
+	{path: "debug", methods: []string{httpm.POST}, summary: "Runs a debug action",
+		params:  map[string]string{"action": `"rebind", "restun", "enginestatus", or "notify" with an ipn.Notify body`},
+		resType: "text/plain"},
+	{path: "debug-capture", methods: []string{httpm.POST}, summary: "Streams a packet capture",
+		resType: "application/vnd.tcpdump.pcap"},
+	{path: "debug-control-backoff", methods: []string{httpm.GET}, summary: "Returns the schedules the control client retries failed requests on",
+		res: typeOf[[]backoff.State]()},
+	{path: "debug-derp-region", methods: []string{httpm.POST}, summary: "Checks the connectivity to a DERP region",
+		params: map[string]string{"region": "the region ID or code"},
+		res:    typeOf[ipnstate.DebugDERPRegionReport]()},
+	{path: "debug-dial", methods: []string{httpm.POST}, summary: "Traces each stage of connecting to a peer's TCP port",
+		params: map[string]string{"host": "the peer's name or IP", "port": "the TCP port"},
+		res:    typeOf[ipnstate.DebugDialReport]()},
+	{path: "debug-filter-trace", methods: []string{httpm.GET, httpm.POST}, summary: "Returns the packet filter decisions about new flows since the trace was started with POST",
+		params: map[string]string{"dur": "the duration to trace for, on POST; zero stops the trace", "ip": "the IP to only trace flows to or from, on POST; optional"},
+		res:    typeOf[[]filter.TraceEntry]()},
+	{path: "debug-latency-matrix", methods: []string{httpm.POST}, summary: "Measures the latency and path to peers, and optionally between each pair of them",
+		params: map[string]string{"ip": "the Tailscale IP of a peer; repeated", "all_pairs": "whether the peers also measure their latency to each other"},
+		res:    typeOf[apitype.LatencyMatrix]()},
+	{path: "debug-packet-filter-matches", methods: []string{httpm.GET}, summary: "Returns the packet filter",
+		res: typeOf[[]filter.Match]()},
+	{path: "debug-packet-filter-rules", methods: []string{httpm.GET}, summary: "Returns the packet filter rules from control",
+		res: typeOf[[]tailcfg.FilterRule]()},
+	{path: "debug-peer-endpoint-changes", methods: []string{httpm.GET}, summary: "Returns the recent endpoint changes of a peer",
+		params: map[string]string{"ip": "the Tailscale IP of the peer"},
+		res:    typeOf[[]magicsock.EndpointChange]()},
+	{path: "debug-portmap", methods: []string{httpm.GET}, summary: "Probes the gateway's port mapping services and maps a port with each, streaming the logs and a summary",
+		params:  map[string]string{"duration": "how long to wait for each mapping", "gateway_and_self": "the gateway and own IPs to use, as \"gw/self\"", "type": `"pmp", "pcp" or "upnp"; all of them if empty`, "hold": "how long to hold each mapping for", "format": `"json" to respond with an ipnstate.DebugPortmapReport instead`},
+		resType: "text/plain"},
+	{path: "debug-resolve-route", methods: []string{httpm.GET}, summary: "Explains how traffic to an IP is routed, and why",
+		params: map[string]string{"ip": "the destination IP"},
+		res:    typeOf[ipnstate.DebugRouteReport]()},
+	{path: "debug-suggested-routes", methods: []string{httpm.GET}, summary: "Returns the subnet routes suggested from the observed traffic",
+		res: typeOf[apitype.SuggestedRoutesResponse]()},
+	{path: "debug-suggested-routes", methods: []string{httpm.POST}, summary: "Turns the observing of traffic for subnet routes on or off",
+		params: map[string]string{"observe": "whether to observe"},
+		res:    typeOf[apitype.SuggestedRoutesResponse]()},
+	{path: "derpmap", methods: []string{httpm.GET}, summary: "Returns the DERP map",
+		res: typeOf[tailcfg.DERPMap]()},
+	{path: "dev-set-state-store", methods: []string{httpm.POST}, summary: "Sets a state store key, for development",
+		params:  map[string]string{"key": "the key", "value": "the value"},
+		resType: "text/plain"},
+	{path: "dial", methods: []string{httpm.POST}, summary: "Dials a TCP connection through tailscaled, upgrading the HTTP connection to it; the address is in the Dial-Host and Dial-Port headers"},
+	{path: "file-put/{target}/{name}", methods: []string{httpm.PUT}, summary: "Sends the request body as a file to a Taildrop target"},
+	{path: "file-targets", methods: []string{httpm.GET}, summary: "Returns the Taildrop targets",
+		res: typeOf[[]*apitype.FileTarget]()},
+	{path: "files/", methods: []string{httpm.GET}, summary: "Returns the received Taildrop files",
+		params: map[string]string{"waitsec": "how many seconds to wait for a file, if there's none"},
+		res:    typeOf[[]apitype.WaitingFile]()},
+	{path: "files/{name}", methods: []string{httpm.GET}, summary: "Returns a received Taildrop file",
+		resType: "application/octet-stream"},
+	{path: "files/{name}", methods: []string{httpm.DELETE}, summary: "Deletes a received Taildrop file"},
+	{path: "goroutines", methods: []string{httpm.GET}, summary: "Returns the goroutine stacks of tailscaled",
+		resType: "text/plain"},
+	{path: "health-checks", methods: []string{httpm.GET}, summary: "Returns the results of the health checks",
+		res: typeOf[[]health.CheckResult]()},
+	{path: "id-token", methods: []string{httpm.GET}, summary: "Returns an OIDC ID token for this node",
+		params: map[string]string{"aud": "the audience of the token"},
+		res:    typeOf[tailcfg.TokenResponse]()},
+	{path: "login-interactive", methods: []string{httpm.POST}, summary: "Starts an interactive login"},
+	{path: "logout", methods: []string{httpm.POST}, summary: "Logs out"},
+	{path: "logtap", methods: []string{httpm.GET}, summary: "Streams the logs of tailscaled",
+		resType: "text/plain"},
+	{path: "metrics", methods: []string{httpm.GET}, summary: "Returns the client metrics",
+		resType: "text/plain"},
+	{path: "openapi.json", methods: []string{httpm.GET}, summary: "Returns this OpenAPI document"},
+	{path: "ping", methods: []string{httpm.POST}, summary: "Pings a peer",
+		params: map[string]string{"ip": "the Tailscale IP of the peer", "type": `"disco", "TSMP", "ICMP" or "peerapi"`},
+		res:    typeOf[ipnstate.PingResult]()},
+	{path: "posture", methods: []string{httpm.GET}, summary: "Returns the device posture of this node",
+		res: typeOf[apitype.PostureResponse]()},
+	{path: "pprof", methods: []string{httpm.GET}, summary: "Returns a pprof profile of tailscaled",
+		params:  map[string]string{"name": "the profile", "seconds": "how long to profile for"},
+		resType: "application/octet-stream"},
+	{path: "prefs", methods: []string{httpm.GET}, summary: "Returns the prefs",
+		res: typeOf[ipn.Prefs]()},
+	{path: "prefs", methods: []string{httpm.PATCH}, summary: "Edits the prefs and returns them",
+		req: typeOf[ipn.MaskedPrefs](), res: typeOf[ipn.Prefs]()},
+	{path: "preview-prefs", methods: []string{httpm.POST}, summary: "Returns the changes that editing the prefs would make",
+		req: typeOf[ipn.MaskedPrefs](), res: typeOf[[]ipn.PrefChange]()},
+	{path: "profiles/", methods: []string{httpm.GET}, summary: "Returns the login profiles",
+		res: typeOf[[]ipn.LoginProfile]()},
+	{path: "profiles/", methods: []string{httpm.PUT}, summary: "Creates a login profile and switches to it"},
+	{path: "profiles/current", methods: []string{httpm.GET}, summary: "Returns the current login profile",
+		res: typeOf[ipn.LoginProfile]()},
+	{path: "profiles/{id}", methods: []string{httpm.GET}, summary: "Returns a login profile",
+		res: typeOf[ipn.LoginProfile]()},
+	{path: "profiles/{id}", methods: []string{httpm.POST}, summary: "Switches to a login profile"},
+	{path: "profiles/{id}", methods: []string{httpm.DELETE}, summary: "Deletes a login profile"},
+	{path: "reset-auth", methods: []string{httpm.POST}, summary: "Deletes the state of this node, for it to log in again"},
+	{path: "serve-config", methods: []string{httpm.GET}, summary: "Returns the serve config",
+		res: typeOf[ipn.ServeConfig]()},
+	{path: "serve-config", methods: []string{httpm.POST}, summary: "Sets the serve config",
+		req: typeOf[ipn.ServeConfig]()},
+	{path: "set-dns", methods: []string{httpm.POST}, summary: "Sets a DNS TXT record for an ACME challenge",
+		params: map[string]string{"name": "the record name", "value": "the record value"},
+		res:    typeOf[struct{}]()},
+	{path: "set-expiry-sooner", methods: []string{httpm.POST}, summary: "Brings the node key expiry forward",
+		params:  map[string]string{"expiry": "the new expiry, in Unix seconds"},
+		resType: "text/plain"},
+	{path: "set-push-device-token", methods: []string{httpm.POST}, summary: "Sets the push notification token of this device",
+		req: typeOf[apitype.SetPushDeviceTokenRequest]()},
+	{path: "speedtest", methods: []string{httpm.POST}, summary: "Measures the throughput to a peer",
+		params: map[string]string{"ip": "the Tailscale IP of the peer", "proto": `"tcp" (the default) or "udp"`, "direction": `"download" (the default) or "upload"`, "duration": "how long to measure for", "bitrate": "the UDP send rate, in bits per second"},
+		res:    typeOf[apitype.SpeedTestResponse]()},
+	{path: "start", methods: []string{httpm.POST}, summary: "Starts the backend",
+		req: typeOf[ipn.Options]()},
+	{path: "status", methods: []string{httpm.GET}, summary: "Returns the status of this node and its peers",
+		params: map[string]string{"peers": "whether to include the peers (the default)"},
+		res:    typeOf[ipnstate.Status]()},
+	{path: "tka/affected-sigs", methods: []string{httpm.POST}, summary: "Returns the signatures affected by removing the key in the request body",
+		res: typeOf[[]tkatype.MarshaledSignature]()},
+	{path: "tka/disable", methods: []string{httpm.POST}, summary: "Disables tailnet lock with the disablement secret in the request body"},
+	{path: "tka/force-local-disable", methods: []string{httpm.POST}, summary: "Disables tailnet lock on this node only",
+		req: typeOf[struct{}]()},
+	{path: "tka/init", methods: []string{httpm.POST}, summary: "Initializes tailnet lock",
+		req: typeOf[tkaInitRequest](), res: typeOf[ipnstate.NetworkLockStatus]()},
+	{path: "tka/log", methods: []string{httpm.GET}, summary: "Returns the tailnet lock log",
+		params: map[string]string{"limit": "the maximum number of entries"},
+		res:    typeOf[[]ipnstate.NetworkLockUpdate]()},
+	{path: "tka/modify", methods: []string{httpm.POST}, summary: "Adds or removes tailnet lock keys",
+		req: typeOf[tkaModifyRequest]()},
+	{path: "tka/sign", methods: []string{httpm.POST}, summary: "Signs a node key with the tailnet lock key of this node",
+		req: typeOf[tkaSignRequest]()},
+	{path: "tka/status", methods: []string{httpm.GET}, summary: "Returns the tailnet lock status",
+		res: typeOf[ipnstate.NetworkLockStatus]()},
+	{path: "tka/submit-signature", methods: []string{httpm.POST}, summary: "Submits a node key signature made elsewhere, such as by a hardware token",
+		req: typeOf[tkaSubmitSignatureRequest]()},
+	{path: "upload-client-metrics", methods: []string{httpm.POST}, summary: "Updates client metrics",
+		req: typeOf[[]clientMetricJSON](), res: typeOf[struct{}]()},
+	{path: "usage", methods: []string{httpm.GET}, summary: "Returns the traffic usage by peer",
+		params: map[string]string{"since": `the first day, as "2006-01-02"; all days if empty`},
+		res:    typeOf[apitype.UsageResponse]()},
+	{path: "wake-on-lan", methods: []string{httpm.POST}, summary: "Asks a peer, such as a subnet router, to send a Wake-on-LAN packet on its LANs",
+		params: map[string]string{"via": "the Tailscale IP of the peer", "mac": "the MAC address of the machine to wake"},
+		res:    typeOf[apitype.WakeOnLANResponse]()},
+	{path: "warm-path", methods: []string{httpm.POST}, summary: "Establishes the best path to a peer ahead of traffic",
+		params: map[string]string{"peer": "the Tailscale IP or name of the peer"},
+		res:    typeOf[ipnstate.PingResult]()},
+	{path: "watch-ipn-bus", methods: []string{httpm.GET}, summary: "Streams the IPN bus notifications, one JSON object per notification",
+		params: map[string]string{"mask": "the ipn.NotifyWatchOpt bits"},
+		res:    typeOf[ipn.Notify]()},
+	{path: "whois", methods: []string{httpm.GET}, summary: "Returns the node and user of a Tailscale IP",
+		params: map[string]string{"addr": "the IP:port"},
+		res:    typeOf[apitype.WhoIsResponse]()},
+}
+
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "want GET", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(openAPI())
+}
+
+// The OpenAPI 3.0 document types; only the parts that openAPI uses.
+type (
+	openAPIDoc struct {
+		OpenAPI    string                                  `json:"openapi"`
+		Info       openAPIInfo                             `json:"info"`
+		Servers    []openAPIServer                         `json:"servers"`
+		Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+		Components openAPIComponents                       `json:"components"`
+	}
+	openAPIInfo struct {
+		Title   string `json:"title"`
+		Version string `json:"version"`
+	}
+	openAPIServer struct {
+		URL string `json:"url"`
+	}
+	openAPIOperation struct {
+		OperationID string                      `json:"operationId"`
+		Summary     string                      `json:"summary"`
+		Parameters  []openAPIParameter          `json:"parameters,omitempty"`
+		RequestBody *openAPIBody                `json:"requestBody,omitempty"`
+		Responses   map[string]*openAPIResponse `json:"responses"`
+	}
+	openAPIParameter struct {
+		Name        string      `json:"name"`
+		In          string      `json:"in"` // "query" or "path"
+		Description string      `json:"description,omitempty"`
+		Required    bool        `json:"required,omitempty"`
+		Schema      *jsonSchema `json:"schema"`
+	}
+	openAPIBody struct {
+		Required bool                         `json:"required,omitempty"`
+		Content  map[string]*openAPIMediaType `json:"content"`
+	}
+	openAPIResponse struct {
+		Description string                       `json:"description"`
+		Content     map[string]*openAPIMediaType `json:"content,omitempty"`
+	}
+	openAPIMediaType struct {
+		Schema *jsonSchema `json:"schema"`
+	}
+	openAPIComponents struct {
+		Schemas map[string]*jsonSchema `json:"schemas"`
+	}
+)
+
+// jsonSchema is the subset of an OpenAPI schema object that schemaGen
+// generates.
+type jsonSchema struct {
+	Ref                  string                 `json:"$ref,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Format               string                 `json:"format,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	AdditionalProperties *jsonSchema            `json:"additionalProperties,omitempty"`
+}
+
+// openAPI returns the OpenAPI document of the LocalAPI, from handlerDocs.
+func openAPI() *openAPIDoc {
+	g := &schemaGen{schemas: map[string]*jsonSchema{}}
+	doc := &openAPIDoc{
+		OpenAPI:    "3.0.3",
+		Info:       openAPIInfo{Title: "Tailscale LocalAPI", Version: version.Long()},
+		Servers:    []openAPIServer{{URL: "http://" + apitype.LocalAPIHost + "/localapi/v0"}},
+		Paths:      map[string]map[string]*openAPIOperation{},
+		Components: openAPIComponents{Schemas: g.schemas},
+	}
+	for _, d := range handlerDocs {
+		p := "/" + d.path
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = map[string]*openAPIOperation{}
+		}
+		for _, m := range d.methods {
+			doc.Paths[p][strings.ToLower(m)] = g.operation(d, m)
+		}
+	}
+	return doc
+}
+
+func (g *schemaGen) operation(d handlerDoc, method string) *openAPIOperation {
+	op := &openAPIOperation{
+		OperationID: operationID(method, d.path),
+		Summary:     d.summary,
+		Responses:   map[string]*openAPIResponse{},
+	}
+	for _, name := range pathParams(d.path) {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:     name,
+			In:       "path",
+			Required: true,
+			Schema:   &jsonSchema{Type: "string"},
+		})
+	}
+	names := make([]string, 0, len(d.params))
+	for name := range d.params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		op.Parameters = append(op.Parameters, openAPIParameter{
+			Name:        name,
+			In:          "query",
+			Description: d.params[name],
+			Schema:      &jsonSchema{Type: "string"},
+		})
+	}
+	if d.req != nil {
+		op.RequestBody = &openAPIBody{
+			Required: true,
+			Content:  map[string]*openAPIMediaType{"application/json": {Schema: g.schemaOf(d.req)}},
+		}
+	}
+	res := &openAPIResponse{Description: "OK"}
+	switch {
+	case d.res != nil:
+		res.Content = map[string]*openAPIMediaType{"application/json": {Schema: g.schemaOf(d.res)}}
+	case d.resType != "":
+		res.Content = map[string]*openAPIMediaType{d.resType: {Schema: &jsonSchema{Type: "string"}}}
+	}
+	op.Responses["200"] = res
+	return op
+}
+
+// operationID returns the OpenAPI operationId of method on p, such as
+// "getTkaStatus" for GET on "tka/status".
+func operationID(method, p string) string {
+	var sb strings.Builder
+	sb.WriteString(strings.ToLower(method))
+	for _, f := range strings.FieldsFunc(p, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9')
+	}) {
+		sb.WriteString(strings.ToUpper(f[:1]))
+		sb.WriteString(f[1:])
+	}
+	return sb.String()
+}
+
+// pathParams returns the names of the "{name}" parts of p.
+func pathParams(p string) (names []string) {
+	for _, seg := range strings.Split(p, "/") {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			names = append(names, seg[1:len(seg)-1])
+		}
+	}
+	return names
+}
+
+var (
+	timeType          = typeOf[time.Time]()
+	textMarshalerType = typeOf[encoding.TextMarshaler]()
+	jsonMarshalerType = typeOf[json.Marshaler]()
+)
+
+// schemaGen generates the JSON schemas of Go types, as encoding/json
+// encodes them. Named struct types go in schemas, by their package and
+// type name, and are referred to from the others.
+type schemaGen struct {
+	schemas map[string]*jsonSchema
+}
+
+func (g *schemaGen) schemaOf(t reflect.Type) *jsonSchema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &jsonSchema{Type: "string", Format: "date-time"}
+	}
+	// Views encode as the value they're a view of.
+	for _, name := range []string{"AsStruct", "AsSlice", "AsMap"} {
+		if m, ok := t.MethodByName(name); ok && m.Type.NumIn() == 1 && m.Type.NumOut() == 1 {
+			return g.schemaOf(m.Type.Out(0))
+		}
+	}
+	pt := reflect.PointerTo(t)
+	if t.Implements(textMarshalerType) || pt.Implements(textMarshalerType) {
+		return &jsonSchema{Type: "string"}
+	}
+	if t.Implements(jsonMarshalerType) || pt.Implements(jsonMarshalerType) {
+		return &jsonSchema{} // any
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &jsonSchema{Type: "string", Format: "byte"}
+		}
+		return &jsonSchema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := schemaName(t)
+		if _, ok := g.schemas[name]; !ok {
+			s := &jsonSchema{Type: "object"}
+			g.schemas[name] = s // before the fields, for recursive types
+			*s = *g.structSchema(t)
+		}
+		return &jsonSchema{Ref: "#/components/schemas/" + name}
+	}
+	return &jsonSchema{} // any
+}
+
+func (g *schemaGen) structSchema(t reflect.Type) *jsonSchema {
+	s := &jsonSchema{Type: "object", Properties: map[string]*jsonSchema{}}
+	g.addFields(s, t)
+	return s
+}
+
+// addFields adds the fields of the struct type t to s, inlining embedded
+// structs like encoding/json.
+func (g *schemaGen) addFields(s *jsonSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		ft := f.Type
+		if f.Anonymous && name == "" {
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(s, ft)
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",string,") {
+			s.Properties[name] = &jsonSchema{Type: "string"}
+			continue
+		}
+		s.Properties[name] = g.schemaOf(ft)
+	}
+}
+
+// schemaName returns the name of the schema of the named type t, such as
+// "ipnstate.Status".
+func schemaName(t reflect.Type) string {
+	name := path.Base(t.PkgPath()) + "." + t.Name()
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '.' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+}
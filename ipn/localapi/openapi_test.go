@@ -0,0 +1,158 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package localapi
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var pathParamRx = regexp.MustCompile(`\{[^}]*\}`)
+
+// handlerKey returns the key in handler of the handler that serves the
+// documented path p, if any.
+func handlerKey(p string) (key string, ok bool) {
+	p = pathParamRx.ReplaceAllString(p, "x")
+	if _, ok := handler[p]; ok {
+		return p, true
+	}
+	if i := strings.IndexByte(p, '/'); i != -1 {
+		if _, ok := handler[p[:i+1]]; ok {
+			return p[:i+1], true
+		}
+	}
+	return "", false
+}
+
+func TestHandlerDocs(t *testing.T) {
+	documented := map[string]bool{}
+	for _, d := range handlerDocs {
+		key, ok := handlerKey(d.path)
+		if !ok {
+			t.Errorf("documented path %q has no handler", d.path)
+			continue
+		}
+		documented[key] = true
+		if len(d.methods) == 0 {
+			t.Errorf("documented path %q has no methods", d.path)
+		}
+		if d.summary == "" {
+			t.Errorf("documented path %q has no summary", d.path)
+		}
+		if d.res != nil && d.resType != "" {
+			t.Errorf("documented path %q has both res and resType", d.path)
+		}
+	}
+	for key := range handler {
+		if !documented[key] {
+			t.Errorf("handler %q is not in handlerDocs", key)
+		}
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	j, err := json.Marshal(openAPI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		OpenAPI string
+		Paths   map[string]map[string]struct {
+			OperationID string
+			Responses   map[string]struct {
+				Content map[string]struct {
+					Schema map[string]any
+				}
+			}
+		}
+		Components struct {
+			Schemas map[string]struct {
+				Type       string
+				Properties map[string]any
+			}
+		}
+	}
+	if err := json.Unmarshal(j, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("openapi = %q; want 3.0.3", doc.OpenAPI)
+	}
+	for _, d := range handlerDocs {
+		for _, m := range d.methods {
+			if _, ok := doc.Paths["/"+d.path][strings.ToLower(m)]; !ok {
+				t.Errorf("no %v /%v operation", m, d.path)
+			}
+		}
+	}
+
+	ids := map[string]bool{}
+	for p, ops := range doc.Paths {
+		for m, op := range ops {
+			if ids[op.OperationID] {
+				t.Errorf("%v %v: duplicate operationId %q", m, p, op.OperationID)
+			}
+			ids[op.OperationID] = true
+		}
+	}
+
+	// All the references must resolve.
+	for _, ref := range regexp.MustCompile(`"\$ref":"([^"]*)"`).FindAllSubmatch(j, -1) {
+		name, ok := strings.CutPrefix(string(ref[1]), "#/components/schemas/")
+		if _, found := doc.Components.Schemas[name]; !ok || !found {
+			t.Errorf("unresolved reference %q", ref[1])
+		}
+	}
+
+	status := doc.Paths["/status"]["get"].Responses["200"].Content["application/json"].Schema
+	if status["$ref"] != "#/components/schemas/ipnstate.Status" {
+		t.Fatalf("status schema = %v; want a reference to ipnstate.Status", status)
+	}
+	st := doc.Components.Schemas["ipnstate.Status"]
+	if st.Type != "object" {
+		t.Errorf("ipnstate.Status type = %q; want object", st.Type)
+	}
+	for _, f := range []string{"BackendState", "Self", "Peer", "User"} {
+		if _, ok := st.Properties[f]; !ok {
+			t.Errorf("ipnstate.Status has no %q property", f)
+		}
+	}
+}
+
+func TestSchemaOf(t *testing.T) {
+	type inner struct {
+		C int
+	}
+	type embedded struct {
+		E string
+	}
+	type outer struct {
+		embedded
+		A       string `json:"a,omitempty"`
+		B       []byte
+		Skipped bool  `json:"-"`
+		N       int64 `json:",string"`
+		In      *inner
+		M       map[string][]inner
+	}
+	g := &schemaGen{schemas: map[string]*jsonSchema{}}
+	ref := g.schemaOf(typeOf[outer]())
+	if ref.Ref != "#/components/schemas/localapi.outer" {
+		t.Fatalf("ref = %q", ref.Ref)
+	}
+	got, _ := json.Marshal(g.schemas)
+	want := `{"localapi.inner":{"type":"object","properties":{"C":{"type":"integer"}}},` +
+		`"localapi.outer":{"type":"object","properties":{` +
+		`"B":{"type":"string","format":"byte"},` +
+		`"E":{"type":"string"},` +
+		`"In":{"$ref":"#/components/schemas/localapi.inner"},` +
+		`"M":{"type":"object","additionalProperties":{"type":"array","items":{"$ref":"#/components/schemas/localapi.inner"}}},` +
+		`"N":{"type":"string"},` +
+		`"a":{"type":"string"}}}}`
+	if string(got) != want {
+		t.Errorf("schemas:\n got %s\nwant %s", got, want)
+	}
+}
@@ -10,6 +10,7 @@
 	"fmt"
 	"log"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,6 +32,12 @@
 // The default control plane is the hosted version run by Tailscale.com.
 const DefaultControlURL = "https://controlplane.tailscale.com"
 
+// Values of Prefs.RemoteAdmin.
+const (
+	RemoteAdminRead  = "read"
+	RemoteAdminWrite = "write"
+)
+
 var (
 	// ErrExitNodeIDAlreadySet is returned from (*Prefs).SetExitNodeIP when the
 	// Prefs.ExitNodeID field is already set.
@@ -62,12 +69,36 @@ type Prefs struct {
 	// calling Backend.Start().
 	ControlURL string
 
+	// ProxyURL is the URL of an upstream HTTP, HTTPS or SOCKS5 proxy
+	// (such as "http://proxy:3128" or "socks5://proxy:1080") to send
+	// control, logging and DERP traffic through, instead of the proxy
+	// from the environment, if any. Peer traffic then reaches peers
+	// via DERP, as the proxy can't carry direct WireGuard UDP.
+	// Changes take effect on new connections.
+	//
+	// The proxy is set process-wide (see tshttpproxy.SetOverride), so in
+	// a process with several tsnet.Servers, the last one to apply its
+	// ProxyURL sets the proxy for all of them.
+	ProxyURL string `json:",omitempty"`
+
 	// RouteAll specifies whether to accept subnets advertised by
 	// other nodes on the Tailscale network. Note that this does not
 	// include default routes (0.0.0.0/0 and ::/0), those are
 	// controlled by ExitNodeID/IP below.
 	RouteAll bool
 
+	// RejectRoutes are subnet routes not to accept from other nodes,
+	// even with RouteAll set. A route is rejected if it's within one of
+	// them, so rejecting 10.0.0.0/8 also rejects 10.1.0.0/16.
+	RejectRoutes []netip.Prefix `json:",omitempty"`
+
+	// KeepAlivePeers are peers to send WireGuard persistent keepalives
+	// to, so the path to them stays warm even while idle, in addition
+	// to the peers the coordination server asks for. Each is a peer's
+	// hostname, MagicDNS name or Tailscale IP, or an ACL tag such as
+	// "tag:office-router" to match all peers with that tag.
+	KeepAlivePeers []string `json:",omitempty"`
+
 	// AllowSingleHosts specifies whether to install routes for each
 	// node IP on the tailscale network, in addition to a route for
 	// the whole network.
@@ -102,6 +133,19 @@ type Prefs struct {
 	// routed directly or via the exit node.
 	ExitNodeAllowLANAccess bool
 
+	// ExitNodeAllowLANCIDRs are additional prefixes, such as another
+	// local subnet or a multicast range, that are routed directly
+	// instead of via the exit node when ExitNodeAllowLANAccess is set.
+	ExitNodeAllowLANCIDRs []netip.Prefix `json:",omitempty"`
+
+	// KillSwitch specifies whether to block all traffic that isn't
+	// over Tailscale while WantRunning and an exit node is selected,
+	// even when the tunnel is down (such as while logged out, or while
+	// tailscaled can't reach the coordination server). Locally
+	// accessible subnets are still reachable if ExitNodeAllowLANAccess
+	// allows them.
+	KillSwitch bool
+
 	// CorpDNS specifies whether to install the Tailscale network's
 	// DNS configuration, if it exists.
 	CorpDNS bool
@@ -111,6 +155,26 @@ type Prefs struct {
 	// policies as configured by the Tailnet's admin(s).
 	RunSSH bool
 
+	// RemoteAdmin specifies whether tagged peers granted the
+	// "https://tailscale.com/cap/remote-admin-read" or "-write"
+	// capability by the tailnet policy may use this node's LocalAPI
+	// over the PeerAPI, so headless nodes can be administered
+	// centrally. It's either empty (no one may), RemoteAdminRead (for
+	// read-only access), or RemoteAdminWrite (for read and write
+	// access, where the peer also has the write capability).
+	RemoteAdmin string `json:",omitempty"`
+
+	// PowerSave specifies whether to always run in the low-power mode,
+	// which probes peers and rediscovers endpoints less often. The mode
+	// is on regardless while running on battery, when logs are also
+	// uploaded less often, as they're shared by the whole process.
+	PowerSave bool `json:",omitempty"`
+
+	// RecordUsage specifies whether to count the bytes of traffic sent
+	// to and received from each peer, and keep daily totals of them in
+	// the state store, for "tailscale usage".
+	RecordUsage bool `json:",omitempty"`
+
 	// WantRunning indicates whether networking should be active on
 	// this node.
 	WantRunning bool
@@ -182,10 +246,34 @@ type Prefs struct {
 	// Linux-only.
 	NoSNAT bool
 
+	// ExitRateLimit, if non-zero, is the most bytes per second that
+	// this node forwards off the tailnet for each peer in each
+	// direction, as an exit node or subnet router, so that no one peer
+	// can saturate its uplink. Traffic over it is dropped.
+	ExitRateLimit uint64 `json:",omitempty"`
+
+	// Maintenance specifies whether this node is in maintenance, such
+	// as while a router in a fleet is being upgraded. It's advertised
+	// to control and peers in Hostinfo, so that peers avoid routing
+	// through the node while other routers are available, and it's
+	// reported by health checks.
+	Maintenance bool `json:",omitempty"`
+
 	// NetfilterMode specifies how much to manage netfilter rules for
 	// Tailscale, if at all.
 	NetfilterMode preftype.NetfilterMode
 
+	// RouteMetric is the metric of the routes Tailscale installs, for
+	// choosing between them and another VPN's routes to overlapping
+	// prefixes. As with the OS's own route metrics, lower is preferred.
+	// Zero means the platform's default.
+	//
+	// On Windows, it's the routes' effective metric, including the
+	// interface metric. On Linux, it's the metric of the routes in
+	// Tailscale's routing table, which is consulted before the main
+	// table when policy routing is available. It's ignored elsewhere.
+	RouteMetric uint32 `json:",omitempty"`
+
 	// OperatorUser is the local machine user name who is allowed to
 	// operate tailscaled without being root or using sudo.
 	OperatorUser string `json:",omitempty"`
@@ -208,13 +296,21 @@ type MaskedPrefs struct {
 	Prefs
 
 	ControlURLSet             bool `json:",omitempty"`
+	ProxyURLSet               bool `json:",omitempty"`
 	RouteAllSet               bool `json:",omitempty"`
+	RejectRoutesSet           bool `json:",omitempty"`
+	KeepAlivePeersSet         bool `json:",omitempty"`
 	AllowSingleHostsSet       bool `json:",omitempty"`
 	ExitNodeIDSet             bool `json:",omitempty"`
 	ExitNodeIPSet             bool `json:",omitempty"`
 	ExitNodeAllowLANAccessSet bool `json:",omitempty"`
+	ExitNodeAllowLANCIDRsSet  bool `json:",omitempty"`
+	KillSwitchSet             bool `json:",omitempty"`
 	CorpDNSSet                bool `json:",omitempty"`
 	RunSSHSet                 bool `json:",omitempty"`
+	RemoteAdminSet            bool `json:",omitempty"`
+	PowerSaveSet              bool `json:",omitempty"`
+	RecordUsageSet            bool `json:",omitempty"`
 	WantRunningSet            bool `json:",omitempty"`
 	LoggedOutSet              bool `json:",omitempty"`
 	ShieldsUpSet              bool `json:",omitempty"`
@@ -225,7 +321,10 @@ type MaskedPrefs struct {
 	EggSet                    bool `json:",omitempty"`
 	AdvertiseRoutesSet        bool `json:",omitempty"`
 	NoSNATSet                 bool `json:",omitempty"`
+	ExitRateLimitSet          bool `json:",omitempty"`
+	MaintenanceSet            bool `json:",omitempty"`
 	NetfilterModeSet          bool `json:",omitempty"`
+	RouteMetricSet            bool `json:",omitempty"`
 	OperatorUserSet           bool `json:",omitempty"`
 	ProfileNameSet            bool `json:",omitempty"`
 }
@@ -314,6 +413,12 @@ func (p *Prefs) pretty(goos string) string {
 	var sb strings.Builder
 	sb.WriteString("Prefs{")
 	fmt.Fprintf(&sb, "ra=%v ", p.RouteAll)
+	if len(p.RejectRoutes) > 0 {
+		fmt.Fprintf(&sb, "reject=%v ", p.RejectRoutes)
+	}
+	if len(p.KeepAlivePeers) > 0 {
+		fmt.Fprintf(&sb, "keepalive=%s ", strings.Join(p.KeepAlivePeers, ","))
+	}
 	if !p.AllowSingleHosts {
 		sb.WriteString("mesh=false ")
 	}
@@ -321,6 +426,15 @@ func (p *Prefs) pretty(goos string) string {
 	if p.RunSSH {
 		sb.WriteString("ssh=true ")
 	}
+	if p.RemoteAdmin != "" {
+		fmt.Fprintf(&sb, "remoteadmin=%s ", p.RemoteAdmin)
+	}
+	if p.PowerSave {
+		sb.WriteString("powersave=true ")
+	}
+	if p.RecordUsage {
+		sb.WriteString("recordusage=true ")
+	}
 	if p.LoggedOut {
 		sb.WriteString("loggedout=true ")
 	}
@@ -338,21 +452,39 @@ func (p *Prefs) pretty(goos string) string {
 	} else if !p.ExitNodeID.IsZero() {
 		fmt.Fprintf(&sb, "exit=%v lan=%t ", p.ExitNodeID, p.ExitNodeAllowLANAccess)
 	}
+	if len(p.ExitNodeAllowLANCIDRs) > 0 {
+		fmt.Fprintf(&sb, "lancidrs=%v ", p.ExitNodeAllowLANCIDRs)
+	}
+	if p.KillSwitch {
+		sb.WriteString("killswitch=true ")
+	}
 	if len(p.AdvertiseRoutes) > 0 || goos == "linux" {
 		fmt.Fprintf(&sb, "routes=%v ", p.AdvertiseRoutes)
 	}
 	if len(p.AdvertiseRoutes) > 0 || p.NoSNAT {
 		fmt.Fprintf(&sb, "snat=%v ", !p.NoSNAT)
 	}
+	if p.ExitRateLimit != 0 {
+		fmt.Fprintf(&sb, "exitrate=%d ", p.ExitRateLimit)
+	}
+	if p.Maintenance {
+		sb.WriteString("maintenance=true ")
+	}
 	if len(p.AdvertiseTags) > 0 {
 		fmt.Fprintf(&sb, "tags=%s ", strings.Join(p.AdvertiseTags, ","))
 	}
 	if goos == "linux" {
 		fmt.Fprintf(&sb, "nf=%v ", p.NetfilterMode)
 	}
+	if p.RouteMetric != 0 {
+		fmt.Fprintf(&sb, "metric=%d ", p.RouteMetric)
+	}
 	if p.ControlURL != "" && p.ControlURL != DefaultControlURL {
 		fmt.Fprintf(&sb, "url=%q ", p.ControlURL)
 	}
+	if p.ProxyURL != "" {
+		fmt.Fprintf(&sb, "proxy=%q ", redactProxyURL(p.ProxyURL))
+	}
 	if p.Hostname != "" {
 		fmt.Fprintf(&sb, "host=%q ", p.Hostname)
 	}
@@ -394,19 +526,30 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 
 	return p != nil && p2 != nil &&
 		p.ControlURL == p2.ControlURL &&
+		p.ProxyURL == p2.ProxyURL &&
 		p.RouteAll == p2.RouteAll &&
+		compareIPNets(p.RejectRoutes, p2.RejectRoutes) &&
+		compareStrings(p.KeepAlivePeers, p2.KeepAlivePeers) &&
 		p.AllowSingleHosts == p2.AllowSingleHosts &&
 		p.ExitNodeID == p2.ExitNodeID &&
 		p.ExitNodeIP == p2.ExitNodeIP &&
 		p.ExitNodeAllowLANAccess == p2.ExitNodeAllowLANAccess &&
+		compareIPNets(p.ExitNodeAllowLANCIDRs, p2.ExitNodeAllowLANCIDRs) &&
+		p.KillSwitch == p2.KillSwitch &&
 		p.CorpDNS == p2.CorpDNS &&
 		p.RunSSH == p2.RunSSH &&
+		p.RemoteAdmin == p2.RemoteAdmin &&
+		p.PowerSave == p2.PowerSave &&
+		p.RecordUsage == p2.RecordUsage &&
 		p.WantRunning == p2.WantRunning &&
 		p.LoggedOut == p2.LoggedOut &&
 		p.NotepadURLs == p2.NotepadURLs &&
 		p.ShieldsUp == p2.ShieldsUp &&
 		p.NoSNAT == p2.NoSNAT &&
+		p.ExitRateLimit == p2.ExitRateLimit &&
+		p.Maintenance == p2.Maintenance &&
 		p.NetfilterMode == p2.NetfilterMode &&
+		p.RouteMetric == p2.RouteMetric &&
 		p.OperatorUser == p2.OperatorUser &&
 		p.Hostname == p2.Hostname &&
 		p.ForceDaemon == p2.ForceDaemon &&
@@ -416,6 +559,38 @@ func (p *Prefs) Equals(p2 *Prefs) bool {
 		p.ProfileName == p2.ProfileName
 }
 
+// PrefChange is a change to a single field of Prefs, as reported by
+// Prefs.Changes.
+type PrefChange struct {
+	Field string // name of the Prefs field, such as "RouteAll"
+	Old   any    // the field's current value
+	New   any    // the field's value after the change
+}
+
+// Changes returns the fields that differ between p and p2 in Prefs field
+// order, treating p as the old value and p2 as the new one. The Persist
+// field is not compared, and a nil slice equals an empty one.
+func (p *Prefs) Changes(p2 *Prefs) []PrefChange {
+	var changes []PrefChange
+	v1, v2 := reflect.ValueOf(p).Elem(), reflect.ValueOf(p2).Elem()
+	t := v1.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if name == "Persist" {
+			continue
+		}
+		f1, f2 := v1.Field(i), v2.Field(i)
+		if f1.Kind() == reflect.Slice && f1.Len() == 0 && f2.Len() == 0 {
+			continue
+		}
+		if reflect.DeepEqual(f1.Interface(), f2.Interface()) {
+			continue
+		}
+		changes = append(changes, PrefChange{Field: name, Old: f1.Interface(), New: f2.Interface()})
+	}
+	return changes
+}
+
 func compareIPNets(a, b []netip.Prefix) bool {
 	if len(a) != len(b) {
 		return false
@@ -428,6 +603,16 @@ func compareIPNets(a, b []netip.Prefix) bool {
 	return true
 }
 
+// redactProxyURL returns the ProxyURL pref s with any password replaced,
+// for logging.
+func redactProxyURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
+	}
+	return u.Redacted()
+}
+
 func compareStrings(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
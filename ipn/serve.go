@@ -3,6 +3,12 @@
 
 package ipn
 
+import (
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
 // ServeConfigKey returns a StateKey that stores the
 // JSON-encoded ServeConfig for a config profile.
 func ServeConfigKey(profileID ProfileID) StateKey {
@@ -16,6 +22,13 @@ type ServeConfig struct {
 	// the Tailscale IP addresses. (not subnet routers, etc)
 	TCP map[uint16]*TCPPortHandler `json:",omitempty"`
 
+	// TCPRanges are ranges of TCP ports that tailscaled should forward
+	// for the Tailscale IP addresses, for protocols such as RTP or passive
+	// FTP that use many ports. A port in TCP takes precedence over a
+	// range containing it. Unlike TCP, ranges are only served to peers,
+	// not to connections from this node to its own Tailscale IPs.
+	TCPRanges []TCPPortRange `json:",omitempty"`
+
 	// Web maps from "$SNI_NAME:$PORT" to a set of HTTP handlers
 	// keyed by mount point ("/", "/foo", etc)
 	Web map[HostPort]*WebServerConfig `json:",omitempty"`
@@ -57,6 +70,16 @@ type TCPPortHandler struct {
 	TerminateTLS string `json:",omitempty"`
 }
 
+// TCPPortRange describes forwarding TCP connections to a range of ports.
+type TCPPortRange struct {
+	Ports tailcfg.PortRange
+
+	// TCPForward is the host, without a port, to forward TCP connections
+	// to, such as "localhost" or "192.168.1.2". Each connection is
+	// forwarded to the port it was made to.
+	TCPForward string
+}
+
 // HTTPHandler is either a path or a proxy to serve.
 type HTTPHandler struct {
 	// Exactly one of the following may be set.
@@ -66,6 +89,32 @@ type HTTPHandler struct {
 
 	Text string `json:",omitempty"` // plaintext to serve (primarily for testing)
 
+	// AllowFrom, if non-empty, restricts the handler to requests from
+	// tailnet peers matching any of its entries, checked with WhoIs at
+	// request time. An entry is a user's login name
+	// ("alice@example.com"), a tag ("tag:admin"), or the name of a peer
+	// capability the tailnet policy grants the peer to this node, which is
+	// how to allow a group, as nodes don't know group membership.
+	// Requests from Funnel never match.
+	AllowFrom []string `json:",omitempty"`
+
+	// The following optionally limit how long a Proxy may stall. Zero
+	// means no limit, so long-lived connections such as WebSockets stay
+	// open as long as the client and backend keep them open.
+
+	// ReadTimeout is how long to wait for the backend to start
+	// responding to a request before replying 504 Gateway Timeout.
+	ReadTimeout time.Duration `json:",omitempty"`
+
+	// WriteTimeout is how long each write of the response to the client
+	// may block before the connection is closed.
+	WriteTimeout time.Duration `json:",omitempty"`
+
+	// IdleTimeout is how long a connection the backend upgraded, such as
+	// a WebSocket, may go without data in either direction before it's
+	// closed.
+	IdleTimeout time.Duration `json:",omitempty"`
+
 	// TODO(bradfitz): bool to not enumerate directories? TTL on mapping for
 	// temporary ones? Error codes? Redirects?
 }
@@ -99,9 +148,12 @@ func (sc *ServeConfig) GetTCPPortHandler(port uint16) *TCPPortHandler {
 // in TCPForward mode on any port.
 // This is exclusive of Web/HTTPS serving.
 func (sc *ServeConfig) IsTCPForwardingAny() bool {
-	if sc == nil || len(sc.TCP) == 0 {
+	if sc == nil {
 		return false
 	}
+	if len(sc.TCPRanges) > 0 {
+		return true
+	}
 	for _, h := range sc.TCP {
 		if h.TCPForward != "" {
 			return true
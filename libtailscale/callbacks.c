@@ -0,0 +1,15 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+#include "tailscale.h"
+
+// Go can't call C function pointers directly, so these trampolines do it
+// on its behalf.
+
+void tailscale_call_log_fn(tailscale_log_fn fn, void* userdata, const char* msg) {
+	fn(userdata, msg);
+}
+
+void tailscale_call_authurl_fn(tailscale_authurl_fn fn, void* userdata, const char* url) {
+	fn(userdata, url);
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package main
+
+//#include <stdlib.h>
+//
+//typedef int tailscale; // as in tailscale.go
+import "C"
+
+import (
+	"bytes"
+	"unsafe"
+)
+
+// Go does not support cgo in tests, so these helpers let
+// tailscale_test.go call the exported functions with C arguments.
+
+// testHandle is a server handle.
+type testHandle = C.tailscale
+
+// testCString returns s as a C string, to be freed with testFree.
+func testCString(s string) *C.char { return C.CString(s) }
+
+// testFree frees a C string from testCString.
+func testFree(p *C.char) { C.free(unsafe.Pointer(p)) }
+
+// testErrmsg returns the last error message of sd from tailscale_errmsg.
+func testErrmsg(sd C.tailscale) string {
+	buf := make([]byte, 256)
+	tailscale_errmsg(sd, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)))
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
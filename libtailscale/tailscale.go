@@ -0,0 +1,503 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+// The libtailscale command is a C library wrapping tsnet, so that
+// programs written in languages other than Go can embed a Tailscale node.
+// See tailscale.h for the API.
+//
+// Build it with:
+//
+//	go build -buildmode=c-archive -o libtailscale.a ./libtailscale
+package main
+
+//#include "errno.h"
+//#include <stdlib.h>
+//#include <string.h>
+//
+//// Mirrors of the types in tailscale.h, which can't be included here as
+//// cgo's generated declarations of the exported functions lack const.
+//typedef int tailscale;
+//typedef int tailscale_conn;
+//typedef int tailscale_listener;
+//typedef void (*tailscale_log_fn)(void* userdata, const char* msg);
+//typedef void (*tailscale_authurl_fn)(void* userdata, const char* url);
+//
+//extern void tailscale_call_log_fn(tailscale_log_fn fn, void* userdata, const char* msg);
+//extern void tailscale_call_authurl_fn(tailscale_authurl_fn fn, void* userdata, const char* url);
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"tailscale.com/ipn"
+	"tailscale.com/tsnet"
+)
+
+func main() {}
+
+// servers tracks all live servers and listeners by their C handle.
+var servers struct {
+	mu        sync.Mutex
+	next      C.int
+	m         map[C.int]*server
+	listeners map[C.int]*listener
+}
+
+type server struct {
+	s *tsnet.Server
+
+	// startMu serializes starting and closing s. Unlike mu, it's held
+	// while the tsnet.Server starts, which logs through the C log
+	// callback, so callbacks must not call anything that locks it.
+	startMu sync.Mutex
+	running bool // under startMu; whether the tsnet.Server started
+
+	mu      sync.Mutex
+	lastErr string
+	started bool // whether start was called; the configuration is fixed once set
+	noLogs  bool // discard logs
+	logFn   C.tailscale_log_fn
+	logUD   unsafe.Pointer
+	authFn  C.tailscale_authurl_fn
+	authUD  unsafe.Pointer
+
+	// cbMu is held while a callback into C is in progress, so that
+	// tailscale_close can wait for in-flight callbacks to finish.
+	cbMu      sync.Mutex
+	closed    bool               // under cbMu; no more callbacks are made once set
+	stopWatch context.CancelFunc // under cbMu; or nil
+}
+
+type listener struct {
+	srv *server
+	ln  net.Listener
+}
+
+func nextHandleLocked() C.int {
+	servers.next++
+	return servers.next
+}
+
+func getServer(sd C.int) (*server, bool) {
+	servers.mu.Lock()
+	defer servers.mu.Unlock()
+	s, ok := servers.m[sd]
+	return s, ok
+}
+
+// recErr records err as the last error of s and returns -1.
+func (s *server) recErr(err error) C.int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastErr = err.Error()
+	return -1
+}
+
+// logf is the tsnet logger for s, forwarding to the C log callback
+// if one is set.
+func (s *server) logf(format string, args ...any) {
+	s.cbMu.Lock()
+	defer s.cbMu.Unlock()
+	s.mu.Lock()
+	noLogs, fn, ud := s.noLogs, s.logFn, s.logUD
+	s.mu.Unlock()
+	if s.closed || noLogs {
+		return
+	}
+	if fn == nil {
+		log.Printf(format, args...)
+		return
+	}
+	msg := C.CString(fmt.Sprintf(format, args...))
+	defer C.free(unsafe.Pointer(msg))
+	C.tailscale_call_log_fn(fn, ud, msg)
+}
+
+// watchAuthURLs calls the C auth URL callback fn for each login URL
+// announced on the IPN bus, until ctx is done.
+func (s *server) watchAuthURLs(ctx context.Context, fn C.tailscale_authurl_fn, ud unsafe.Pointer) {
+	lc, err := s.s.LocalClient()
+	if err != nil {
+		return
+	}
+	w, err := lc.WatchIPNBus(ctx, ipn.NotifyInitialState|ipn.NotifyNoPrivateKeys)
+	if err != nil {
+		return
+	}
+	defer w.Close()
+	for {
+		n, err := w.Next()
+		if err != nil {
+			return
+		}
+		if n.BrowseToURL == nil || *n.BrowseToURL == "" {
+			continue
+		}
+		s.cbMu.Lock()
+		if !s.closed {
+			u := C.CString(*n.BrowseToURL)
+			C.tailscale_call_authurl_fn(fn, ud, u)
+			C.free(unsafe.Pointer(u))
+		}
+		s.cbMu.Unlock()
+	}
+}
+
+// start starts s if it hasn't been already, and begins watching for
+// auth URLs if a callback is set.
+//
+// The configuration is fixed before the tsnet.Server starts, so that
+// setConfig can't change it as it's read. s.mu isn't held while the
+// server starts, as that logs through the C log callback, which may
+// call tailscale_errmsg or the tailscale_set_* functions.
+func (s *server) start() error {
+	s.startMu.Lock()
+	defer s.startMu.Unlock()
+	s.mu.Lock()
+	s.started = true
+	authFn, authUD := s.authFn, s.authUD
+	s.mu.Unlock()
+
+	if err := s.s.Start(); err != nil {
+		return err
+	}
+	if s.running {
+		return nil
+	}
+	s.running = true
+	s.cbMu.Lock()
+	defer s.cbMu.Unlock()
+	if authFn != nil && !s.closed {
+		ctx, cancel := context.WithCancel(context.Background())
+		s.stopWatch = cancel
+		go s.watchAuthURLs(ctx, authFn, authUD)
+	}
+	return nil
+}
+
+//export tailscale_new
+func tailscale_new() C.tailscale {
+	servers.mu.Lock()
+	defer servers.mu.Unlock()
+	sd := nextHandleLocked()
+	srv := &server{s: &tsnet.Server{}}
+	srv.s.Logf = srv.logf
+	if servers.m == nil {
+		servers.m = map[C.int]*server{}
+	}
+	servers.m[sd] = srv
+	return sd
+}
+
+//export tailscale_start
+func tailscale_start(sd C.tailscale) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	if err := s.start(); err != nil {
+		return s.recErr(err)
+	}
+	return 0
+}
+
+//export tailscale_up
+func tailscale_up(sd C.tailscale) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	if err := s.start(); err != nil {
+		return s.recErr(err)
+	}
+	if _, err := s.s.Up(context.Background()); err != nil {
+		return s.recErr(err)
+	}
+	return 0
+}
+
+//export tailscale_close
+func tailscale_close(sd C.tailscale) C.int {
+	servers.mu.Lock()
+	s, ok := servers.m[sd]
+	delete(servers.m, sd)
+	for ld, ln := range servers.listeners {
+		if ln.srv == s {
+			delete(servers.listeners, ld)
+		}
+	}
+	servers.mu.Unlock()
+	if !ok {
+		return C.EBADF
+	}
+
+	ret := C.int(0)
+	s.startMu.Lock()
+	// tsnet.Server.Close must not be called before Start.
+	if s.running {
+		if err := s.s.Close(); err != nil {
+			s.logf("tailscale_close: %v", err)
+			ret = -1
+		}
+	}
+	s.startMu.Unlock()
+
+	// Wait for any in-flight callback, then prevent new ones.
+	s.cbMu.Lock()
+	s.closed = true
+	if s.stopWatch != nil {
+		s.stopWatch()
+	}
+	s.cbMu.Unlock()
+	return ret
+}
+
+// setConfig runs fn to modify s's configuration, failing if s has
+// already been started. fn must not lock s.mu.
+func setConfig(sd C.tailscale, fn func(*server)) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return s.recErr(fmt.Errorf("tailscale: cannot change configuration after start"))
+	}
+	fn(s) // under s.mu, so start waits for it
+	s.mu.Unlock()
+	return 0
+}
+
+//export tailscale_set_dir
+func tailscale_set_dir(sd C.tailscale, dir *C.char) C.int {
+	v := C.GoString(dir)
+	return setConfig(sd, func(s *server) { s.s.Dir = v })
+}
+
+//export tailscale_set_hostname
+func tailscale_set_hostname(sd C.tailscale, hostname *C.char) C.int {
+	v := C.GoString(hostname)
+	return setConfig(sd, func(s *server) { s.s.Hostname = v })
+}
+
+//export tailscale_set_authkey
+func tailscale_set_authkey(sd C.tailscale, authkey *C.char) C.int {
+	v := C.GoString(authkey)
+	return setConfig(sd, func(s *server) { s.s.AuthKey = v })
+}
+
+//export tailscale_set_control_url
+func tailscale_set_control_url(sd C.tailscale, controlURL *C.char) C.int {
+	v := C.GoString(controlURL)
+	return setConfig(sd, func(s *server) { s.s.ControlURL = v })
+}
+
+//export tailscale_set_ephemeral
+func tailscale_set_ephemeral(sd C.tailscale, e C.int) C.int {
+	return setConfig(sd, func(s *server) { s.s.Ephemeral = e != 0 })
+}
+
+//export tailscale_set_log_callback
+func tailscale_set_log_callback(sd C.tailscale, fn C.tailscale_log_fn, userdata unsafe.Pointer) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.noLogs = fn == nil
+	s.logFn, s.logUD = fn, userdata
+	return 0
+}
+
+//export tailscale_set_authurl_callback
+func tailscale_set_authurl_callback(sd C.tailscale, fn C.tailscale_authurl_fn, userdata unsafe.Pointer) C.int {
+	return setConfig(sd, func(s *server) { s.authFn, s.authUD = fn, userdata })
+}
+
+//export tailscale_dial
+func tailscale_dial(sd C.tailscale, network, addr *C.char, connOut *C.tailscale_conn) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	if err := s.start(); err != nil {
+		return s.recErr(err)
+	}
+	conn, err := s.s.Dial(context.Background(), C.GoString(network), C.GoString(addr))
+	if err != nil {
+		return s.recErr(err)
+	}
+	fd, err := newConnFD(conn)
+	if err != nil {
+		conn.Close()
+		return s.recErr(err)
+	}
+	*connOut = C.tailscale_conn(fd)
+	return 0
+}
+
+//export tailscale_listen
+func tailscale_listen(sd C.tailscale, network, addr *C.char, listenerOut *C.tailscale_listener) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	goNetwork := C.GoString(network)
+	switch goNetwork {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return s.recErr(fmt.Errorf("tailscale_listen: unsupported network %q", goNetwork))
+	}
+	if err := s.start(); err != nil {
+		return s.recErr(err)
+	}
+	ln, err := s.s.Listen(goNetwork, C.GoString(addr))
+	if err != nil {
+		return s.recErr(err)
+	}
+	servers.mu.Lock()
+	defer servers.mu.Unlock()
+	ld := nextHandleLocked()
+	if servers.listeners == nil {
+		servers.listeners = map[C.int]*listener{}
+	}
+	servers.listeners[ld] = &listener{srv: s, ln: ln}
+	*listenerOut = C.tailscale_listener(ld)
+	return 0
+}
+
+func getListener(ld C.tailscale_listener) (*listener, bool) {
+	servers.mu.Lock()
+	defer servers.mu.Unlock()
+	l, ok := servers.listeners[C.int(ld)]
+	return l, ok
+}
+
+//export tailscale_accept
+func tailscale_accept(ld C.tailscale_listener, connOut *C.tailscale_conn) C.int {
+	l, ok := getListener(ld)
+	if !ok {
+		return C.EBADF
+	}
+	conn, err := l.ln.Accept()
+	if err != nil {
+		if _, ok := getListener(ld); !ok {
+			return C.EBADF
+		}
+		return l.srv.recErr(err)
+	}
+	fd, err := newConnFD(conn)
+	if err != nil {
+		conn.Close()
+		return l.srv.recErr(err)
+	}
+	*connOut = C.tailscale_conn(fd)
+	return 0
+}
+
+//export tailscale_listener_close
+func tailscale_listener_close(ld C.tailscale_listener) C.int {
+	servers.mu.Lock()
+	l, ok := servers.listeners[C.int(ld)]
+	delete(servers.listeners, C.int(ld))
+	servers.mu.Unlock()
+	if !ok {
+		return C.EBADF
+	}
+	l.ln.Close()
+	return 0
+}
+
+// newConnFD returns one end of a new socketpair, the other end of which
+// is connected to conn by copying in both directions until either side
+// is closed.
+func newConnFD(conn net.Conn) (int, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return -1, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	f := os.NewFile(uintptr(fds[1]), "socketpair")
+	local, err := net.FileConn(f)
+	f.Close() // FileConn dups the fd
+	if err != nil {
+		syscall.Close(fds[0])
+		return -1, err
+	}
+	go func() {
+		defer local.Close()
+		defer conn.Close()
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(conn, local); done <- struct{}{} }()
+		go func() { io.Copy(local, conn); done <- struct{}{} }()
+		<-done
+	}()
+	return fds[0], nil
+}
+
+//export tailscale_loopback
+func tailscale_loopback(sd C.tailscale, addrOut *C.char, addrLen C.size_t, proxyCredOut, localAPICredOut *C.char) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	if err := s.start(); err != nil {
+		return s.recErr(err)
+	}
+	addr, proxyCred, localAPICred, err := s.s.Loopback()
+	if err != nil {
+		return s.recErr(err)
+	}
+	if len(proxyCred) != 32 || len(localAPICred) != 32 {
+		return s.recErr(fmt.Errorf("tailscale_loopback: unexpected credential length"))
+	}
+	if C.size_t(len(addr)+1) > addrLen {
+		return s.recErr(fmt.Errorf("tailscale_loopback: addr buffer too small"))
+	}
+	copyCString(addrOut, addr)
+	copyCString(proxyCredOut, proxyCred)
+	copyCString(localAPICredOut, localAPICred)
+	return 0
+}
+
+// copyCString copies s plus a NUL terminator to dst, which the caller
+// guarantees holds at least len(s)+1 bytes.
+func copyCString(dst *C.char, s string) {
+	b := unsafe.Slice((*byte)(unsafe.Pointer(dst)), len(s)+1)
+	copy(b, s)
+	b[len(s)] = 0
+}
+
+//export tailscale_errmsg
+func tailscale_errmsg(sd C.tailscale, buf *C.char, buflen C.size_t) C.int {
+	s, ok := getServer(sd)
+	if !ok {
+		return C.EBADF
+	}
+	if buflen == 0 {
+		return C.ERANGE
+	}
+	s.mu.Lock()
+	msg := s.lastErr
+	s.mu.Unlock()
+	ret := C.int(0)
+	if C.size_t(len(msg)+1) > buflen {
+		msg = msg[:buflen-1]
+		ret = C.ERANGE
+	}
+	copyCString(buf, msg)
+	return ret
+}
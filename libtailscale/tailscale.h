@@ -0,0 +1,186 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//
+// Tailscale C library.
+//
+// Use this library to compile Tailscale into your program and get
+// an entirely userspace IP address on a tailnet.
+//
+// From here you can listen for other programs on your tailnet dialing
+// you, or connect directly to other services.
+//
+// Build with:
+//
+//	go build -buildmode=c-archive -o libtailscale.a ./libtailscale
+//
+// or -buildmode=c-shared for a shared library.
+//
+
+#include <stddef.h>
+
+#ifndef TAILSCALE_H
+#define TAILSCALE_H
+
+#ifdef __cplusplus
+extern "C" {
+#endif
+
+// tailscale is a handle onto a Tailscale server.
+typedef int tailscale;
+
+// tailscale_new creates a tailscale server object.
+//
+// No network connection is initialized until tailscale_start is called.
+extern tailscale tailscale_new();
+
+// tailscale_start connects the server to the tailnet.
+//
+// Calling this function is optional as it will be called by the first use
+// of tailscale_listen or tailscale_dial on a server.
+//
+// See also: tailscale_up.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_start(tailscale sd);
+
+// tailscale_up connects the server to the tailnet and waits for it to be usable.
+//
+// To cancel an in-progress call to tailscale_up, use tailscale_close.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_up(tailscale sd);
+
+// tailscale_close shuts down the server.
+//
+// After it returns, no further callbacks are made for sd, and sd must
+// not be used again.
+//
+// Returns:
+// 	0     - success
+// 	EBADF - sd is not a valid tailscale
+// 	-1    - other error, details printed to the tsnet logger
+extern int tailscale_close(tailscale sd);
+
+// The following set tailscale configuration options.
+//
+// Configure these options before any explicit or implicit call to tailscale_start.
+//
+// For details of each value see the godoc for the fields of tsnet.Server.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_set_dir(tailscale sd, const char* dir);
+extern int tailscale_set_hostname(tailscale sd, const char* hostname);
+extern int tailscale_set_authkey(tailscale sd, const char* authkey);
+extern int tailscale_set_control_url(tailscale sd, const char* control_url);
+extern int tailscale_set_ephemeral(tailscale sd, int ephemeral);
+
+// tailscale_log_fn receives a log line from the server. The string is
+// only valid for the duration of the call.
+typedef void (*tailscale_log_fn)(void* userdata, const char* msg);
+
+// tailscale_authurl_fn receives the URL to visit to authenticate the
+// server when it needs interactive login. The string is only valid for
+// the duration of the call.
+typedef void (*tailscale_authurl_fn)(void* userdata, const char* url);
+
+// tailscale_set_log_callback arranges for server logs to be passed to fn.
+// If fn is NULL, logs are discarded. By default, logs go to stderr.
+//
+// Callbacks are made from arbitrary threads, one at a time, including
+// from within tailscale_start and the other functions that start the
+// server. They must not block for long. They may call tailscale_errmsg
+// and the tailscale_set_* functions, but must not call tailscale_close or
+// any function that may start the server.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_set_log_callback(tailscale sd, tailscale_log_fn fn, void* userdata);
+
+// tailscale_set_authurl_callback arranges for fn to be called each time
+// the server presents a new login URL. It must be set before the server
+// is started. The same rules as for log callbacks apply.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_set_authurl_callback(tailscale sd, tailscale_authurl_fn fn, void* userdata);
+
+// A tailscale_conn is a connection to an address on the tailnet.
+//
+// It is a pipe(2) on which you can use read(2), write(2), and close(2).
+// For extra control over the connection, see the underlying socketpair(2).
+typedef int tailscale_conn;
+
+// tailscale_dial connects to the address on the tailnet.
+//
+// network is a NUL-terminated string of the form "tcp", "udp", etc.
+// addr is a NUL-terminated string of an IP address or domain name.
+//
+// It will start the server if it has not been started yet.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_dial(tailscale sd, const char* network, const char* addr, tailscale_conn* conn_out);
+
+// A tailscale_listener is a socket on the tailnet listening for connections.
+typedef int tailscale_listener;
+
+// tailscale_listen listens for a connection on the tailnet.
+//
+// It is analogous to listen(2) in C or net.Listen in Go. Use
+// tailscale_accept to receive connections, and tailscale_listener_close
+// to stop listening.
+//
+// network is a NUL-terminated string of the form "tcp", "tcp4" or "tcp6".
+// addr is a NUL-terminated string of an IP address or domain name.
+//
+// It will start the server if it has not been started yet.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_listen(tailscale sd, const char* network, const char* addr, tailscale_listener* listener_out);
+
+// tailscale_accept blocks until a new connection arrives on listener.
+//
+// Returns:
+// 	0     - success
+// 	EBADF - listener is not a valid tailscale_listener or was closed
+// 	-1    - call tailscale_errmsg for details
+extern int tailscale_accept(tailscale_listener listener, tailscale_conn* conn_out);
+
+// tailscale_listener_close stops listener, unblocking any tailscale_accept.
+//
+// Returns zero on success or EBADF if listener is not valid.
+extern int tailscale_listener_close(tailscale_listener listener);
+
+// tailscale_loopback starts a loopback address server.
+//
+// The server has multiple functions.
+//
+// It can be used as a SOCKS5 proxy onto the tailnet.
+// Authentication is required with the username "tsnet" and
+// the value of proxy_cred used as the password.
+//
+// The HTTP server also serves out the "LocalAPI" on /localapi.
+// As the LocalAPI is powerful, access to endpoints requires BOTH passing a
+// "Sec-Tailscale: localapi" HTTP header and passing local_api_cred as
+// the basic auth password.
+//
+// The pointers proxy_cred_out and local_api_cred_out must be non-NULL
+// and point to arrays that can hold 33 bytes. The first 32 bytes are
+// the credential and the final byte is a NUL terminator.
+//
+// Returns zero on success or -1 on error, call tailscale_errmsg for details.
+extern int tailscale_loopback(tailscale sd, char* addr_out, size_t addrlen, char* proxy_cred_out, char* local_api_cred_out);
+
+// tailscale_errmsg writes the details of the last error to buf.
+//
+// After returning, buf is always NUL-terminated.
+//
+// Returns:
+// 	0      - success
+// 	EBADF  - sd is not a valid tailscale
+// 	ERANGE - insufficient storage for buf
+extern int tailscale_errmsg(tailscale sd, char* buf, size_t buflen);
+
+#ifdef __cplusplus
+}
+#endif
+
+#endif
@@ -0,0 +1,141 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build unix
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestBadHandle(t *testing.T) {
+	if got := tailscale_start(-1); int(got) != int(syscall.EBADF) {
+		t.Errorf("tailscale_start(-1) = %d; want EBADF", got)
+	}
+	if got := tailscale_close(-1); int(got) != int(syscall.EBADF) {
+		t.Errorf("tailscale_close(-1) = %d; want EBADF", got)
+	}
+}
+
+func TestSetConfig(t *testing.T) {
+	sd := tailscale_new()
+	defer tailscale_close(sd)
+
+	dir, host := testCString(t.TempDir()), testCString("libts-test")
+	defer testFree(dir)
+	defer testFree(host)
+	if got := tailscale_set_dir(sd, dir); got != 0 {
+		t.Fatalf("tailscale_set_dir = %d", got)
+	}
+	if got := tailscale_set_hostname(sd, host); got != 0 {
+		t.Fatalf("tailscale_set_hostname = %d", got)
+	}
+	if got := tailscale_set_ephemeral(sd, 1); got != 0 {
+		t.Fatalf("tailscale_set_ephemeral = %d", got)
+	}
+	s, ok := getServer(sd)
+	if !ok {
+		t.Fatal("server not registered")
+	}
+	if s.s.Hostname != "libts-test" || !s.s.Ephemeral {
+		t.Errorf("Hostname, Ephemeral = %q, %v; want libts-test, true", s.s.Hostname, s.s.Ephemeral)
+	}
+}
+
+// newTestServer returns a server configured to store its state in a
+// temporary directory and talk to a control server that refuses it.
+func newTestServer(t *testing.T) testHandle {
+	control := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(control.Close)
+	sd := tailscale_new()
+	t.Cleanup(func() { tailscale_close(sd) })
+	dir, url := testCString(t.TempDir()), testCString(control.URL)
+	defer testFree(dir)
+	defer testFree(url)
+	tailscale_set_dir(sd, dir)
+	tailscale_set_control_url(sd, url)
+	tailscale_set_ephemeral(sd, 1)
+	return sd
+}
+
+func TestSetAfterStart(t *testing.T) {
+	sd := newTestServer(t)
+	if got := tailscale_start(sd); got != 0 {
+		t.Fatalf("tailscale_start = %d: %s", got, testErrmsg(sd))
+	}
+	host := testCString("too-late")
+	defer testFree(host)
+	if got := tailscale_set_hostname(sd, host); got != -1 {
+		t.Fatalf("tailscale_set_hostname after start = %d; want -1", got)
+	}
+	if msg := testErrmsg(sd); !strings.Contains(msg, "after start") {
+		t.Errorf("errmsg = %q; want it to mention start", msg)
+	}
+}
+
+// TestSetDuringStart checks, when run with -race, that configuration
+// changes racing with tailscale_start either happen before the server
+// reads its configuration or fail.
+func TestSetDuringStart(t *testing.T) {
+	sd := newTestServer(t)
+	host := testCString("racing")
+	defer testFree(host)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				tailscale_set_hostname(sd, host)
+				tailscale_set_ephemeral(sd, 1)
+			}
+		}()
+	}
+	if got := tailscale_start(sd); got != 0 {
+		t.Errorf("tailscale_start = %d: %s", got, testErrmsg(sd))
+	}
+	wg.Wait()
+}
+
+// TestLogDuringStart checks that the server's logger can call
+// tailscale_errmsg and the tailscale_set_* functions while the server
+// starts, as a C log callback may.
+func TestLogDuringStart(t *testing.T) {
+	sd := newTestServer(t)
+	s, ok := getServer(sd)
+	if !ok {
+		t.Fatal("server not registered")
+	}
+	host := testCString("from-logger")
+	defer testFree(host)
+	logf := s.s.Logf
+	var logged atomic.Bool
+	s.s.Logf = func(format string, args ...any) {
+		logged.Store(true)
+		testErrmsg(sd)
+		tailscale_set_hostname(sd, host) // fails, as the server is starting
+		logf(format, args...)
+	}
+
+	done := make(chan int)
+	go func() { done <- int(tailscale_start(sd)) }()
+	select {
+	case got := <-done:
+		if got != 0 {
+			t.Fatalf("tailscale_start = %d: %s", got, testErrmsg(sd))
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("tailscale_start deadlocked")
+	}
+	if !logged.Load() {
+		t.Error("server didn't log while starting")
+	}
+}
@@ -50,6 +50,7 @@
 	"tailscale.com/util/winutil"
 	"tailscale.com/version"
 	"tailscale.com/version/distro"
+	"tailscale.com/wgengine/powersave"
 )
 
 var getLogTargetOnce struct {
@@ -445,6 +446,20 @@ func tryFixLogStateLocation(dir, cmdname string) {
 	}
 }
 
+// powerSaveLogFlushDelay is how long node logs are accumulated before
+// uploading them in the low-power mode, to wake the radio less often.
+const powerSaveLogFlushDelay = time.Minute
+
+// nodeLogFlushDelay returns how long to accumulate node logs before
+// uploading them. The logs are process-wide, so only the power source
+// counts, not the per-engine PowerSave pref.
+func nodeLogFlushDelay() time.Duration {
+	if powersave.Auto() {
+		return powerSaveLogFlushDelay
+	}
+	return logtail.DefaultFlushDelay
+}
+
 // New returns a new log policy (a logger and its instance ID) for a
 // given collection name.
 func New(collection string) *Policy {
@@ -557,6 +572,9 @@ func NewWithConfigPath(collection, dir, cmdName string) *Policy {
 		conf.MetricsDelta = clientmetric.EncodeLogTailMetricsDelta
 		conf.IncludeProcID = true
 		conf.IncludeProcSequence = true
+		if !envknob.Bool("IN_TS_TEST") {
+			conf.FlushDelayFn = nodeLogFlushDelay
+		}
 	}
 
 	if envknob.NoLogsNoSupport() {
@@ -727,8 +745,20 @@ func NewLogtailTransport(host string) *http.Transport {
 		c, err = dialer(ctx, netw, addr)
 		if err == nil {
 			log.Printf("logtail: bootstrap dial succeeded")
+			return c, nil
 		}
-		return c, err
+
+		// If the network blocks the log server, try over the tailnet.
+		if tailnetDial.Load() != nil {
+			log.Printf("logtail: bootstrap dial %q failed: %v, trying over the tailnet...", addr, err)
+			tc, terr := dialTailnet(ctx, netw, addr)
+			if terr == nil {
+				log.Printf("logtail: tailnet dial succeeded")
+				return tc, nil
+			}
+			log.Printf("logtail: tailnet dial %q failed: %v", addr, terr)
+		}
+		return nil, err
 	}
 
 	// We're contacting exactly 1 hostname, so the default's 100
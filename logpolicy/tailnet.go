@@ -0,0 +1,94 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logpolicy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/syncs"
+)
+
+// DialFunc is a function that dials a network address.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// tailnetDial is the DialFunc set by SetTailnetDialer, if any.
+var tailnetDial syncs.AtomicValue[DialFunc]
+
+// tailnetProxy is the "host:port" of an HTTP proxy on the tailnet, such as
+// on a node that can reach the log server, through which to upload logs
+// when they can't be uploaded directly.
+var tailnetProxy = envknob.RegisterString("TS_LOG_TAILNET_PROXY")
+
+// SetTailnetDialer sets the func with which the transports returned by
+// NewLogtailTransport dial over the tailnet when they fail to reach the log
+// server directly, such as when the underlying network blocks it. dial is
+// typically tsdial.Dialer.UserDial, which reaches the internet through the
+// exit node, if any, or the TS_LOG_TAILNET_PROXY proxy.
+//
+// Until the tailnet is up, uploads fail and logs stay buffered, as they do
+// while offline, to be uploaded once dial succeeds.
+func SetTailnetDialer(dial DialFunc) {
+	tailnetDial.Store(dial)
+}
+
+// dialTailnet dials addr over the tailnet, via TS_LOG_TAILNET_PROXY if
+// set, using the dialer set by SetTailnetDialer.
+func dialTailnet(ctx context.Context, netw, addr string) (net.Conn, error) {
+	dial := tailnetDial.Load()
+	if dial == nil {
+		return nil, errors.New("no tailnet dialer")
+	}
+	proxy := tailnetProxy()
+	if proxy == "" {
+		return dial(ctx, netw, addr)
+	}
+	c, err := dial(ctx, "tcp", proxy)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := proxyConnect(ctx, c, addr)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("CONNECT via %s: %w", proxy, err)
+	}
+	return pc, nil
+}
+
+// proxyConnect sends a CONNECT request for addr over c, a connection to an
+// HTTP proxy, and reads the proxy's response. It returns c, or a wrapper of
+// c if the proxy already sent data after its response.
+func proxyConnect(ctx context.Context, c net.Conn, addr string) (net.Conn, error) {
+	if d, ok := ctx.Deadline(); ok {
+		c.SetDeadline(d)
+		defer c.SetDeadline(time.Time{})
+	}
+	fmt.Fprintf(c, "CONNECT %s HTTP/1.0\r\n\r\n", addr)
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New(res.Status)
+	}
+	if br.Buffered() > 0 {
+		return bufferedConn{c, br}, nil
+	}
+	return c, nil
+}
+
+// bufferedConn is a net.Conn whose reads start with what's buffered in r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
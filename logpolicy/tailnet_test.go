@@ -0,0 +1,82 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package logpolicy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"tailscale.com/envknob"
+)
+
+func TestDialTailnet(t *testing.T) {
+	defer tailnetDial.Store(nil)
+	defer envknob.Setenv("TS_LOG_TAILNET_PROXY", "")
+
+	ctx := context.Background()
+	if _, err := dialTailnet(ctx, "tcp", "log.tailscale.io:443"); err == nil {
+		t.Fatal("dialTailnet succeeded without a tailnet dialer")
+	}
+
+	// A CONNECT proxy that only allows the log server, and says hello
+	// instead of connecting to it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				req, err := http.ReadRequest(bufio.NewReader(c))
+				if err != nil {
+					return
+				}
+				if req.Method != "CONNECT" || req.Host != "log.tailscale.io:443" {
+					io.WriteString(c, "HTTP/1.0 403 Forbidden\r\n\r\n")
+					return
+				}
+				io.WriteString(c, "HTTP/1.0 200 OK\r\n\r\n")
+				io.WriteString(c, "hello")
+			}()
+		}
+	}()
+
+	var dialed []string
+	SetTailnetDialer(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialed = append(dialed, addr)
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	})
+	envknob.Setenv("TS_LOG_TAILNET_PROXY", ln.Addr().String())
+
+	c, err := dialTailnet(ctx, "tcp", "log.tailscale.io:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(c)
+	c.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Errorf("read %q; want hello", got)
+	}
+	if len(dialed) != 1 || dialed[0] != ln.Addr().String() {
+		t.Errorf("dialed %q; want the proxy", dialed)
+	}
+
+	if _, err := dialTailnet(ctx, "tcp", "example.com:443"); err == nil {
+		t.Error("dialTailnet succeeded despite the proxy refusing")
+	}
+}
@@ -6,7 +6,9 @@
 
 import (
 	"context"
+	"errors"
 	"math/rand"
+	"sync"
 	"time"
 
 	"tailscale.com/types/logger"
@@ -15,7 +17,6 @@
 // Backoff tracks state the history of consecutive failures and sleeps
 // an increasing amount of time, up to a provided limit.
 type Backoff struct {
-	n          int // number of consecutive failures
 	maxBackoff time.Duration
 
 	// Name is the name of this backoff timer, for logging purposes.
@@ -30,6 +31,18 @@ type Backoff struct {
 	// LogLongerThan sets the minimum time of a single backoff interval
 	// before we mention it in the log.
 	LogLongerThan time.Duration
+
+	// Jitter is the fraction, from 0 to 1, by which each backoff
+	// interval d is randomized, uniformly over d±Jitter*d. Higher
+	// values spread out the retries of many clients that failed at
+	// the same time, such as after a server outage, so they don't
+	// all come back at once. NewBackoff sets it to 0.5.
+	Jitter float64
+
+	mu        sync.Mutex // guards the following, for State
+	n         int        // number of consecutive failures
+	lastDelay time.Duration
+	until     time.Time // when the current backoff ends, or zero if not backing off
 }
 
 // NewBackoff returns a new Backoff timer with the provided name (for logging), logger,
@@ -41,16 +54,34 @@ func NewBackoff(name string, logf logger.Logf, maxBackoff time.Duration) *Backof
 		logf:       logf,
 		maxBackoff: maxBackoff,
 		NewTimer:   time.NewTimer,
+		Jitter:     0.5,
 	}
 }
 
+// RetryAfterError is implemented by errors that say how long the server
+// asked to wait before retrying, such as with an HTTP Retry-After header.
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// maxRetryAfter bounds how long BackOff honors a RetryAfterError for, in
+// case of a bogus server value.
+const maxRetryAfter = 10 * time.Minute
+
 // Backoff sleeps an increasing amount of time if err is non-nil.
 // and the context is not a
 // It resets the backoff schedule once err is nil.
+//
+// If err is or wraps a RetryAfterError, it sleeps at least as long as
+// the server asked, plus up to Jitter of that again, even past the
+// max backoff time.
 func (b *Backoff) BackOff(ctx context.Context, err error) {
 	if err == nil {
 		// No error. Reset number of consecutive failures.
+		b.mu.Lock()
 		b.n = 0
+		b.mu.Unlock()
 		return
 	}
 	if ctx.Err() != nil {
@@ -58,20 +89,49 @@ func (b *Backoff) BackOff(ctx context.Context, err error) {
 		return
 	}
 
+	b.mu.Lock()
 	b.n++
+	n := b.n
+	b.mu.Unlock()
+
 	// n^2 backoff timer is a little smoother than the
 	// common choice of 2^n.
-	d := time.Duration(b.n*b.n) * 10 * time.Millisecond
+	d := time.Duration(n*n) * 10 * time.Millisecond
 	if d > b.maxBackoff {
 		d = b.maxBackoff
 	}
-	// Randomize the delay between 0.5-1.5 x msec, in order
-	// to prevent accidental "thundering herd" problems.
-	d = time.Duration(float64(d) * (rand.Float64() + 0.5))
+	// Randomize the delay between (1-Jitter) and (1+Jitter) x msec, in
+	// order to prevent accidental "thundering herd" problems.
+	j := b.jitter()
+	d = time.Duration(float64(d) * (1 - j + 2*j*rand.Float64()))
+
+	var rae RetryAfterError
+	if errors.As(err, &rae) {
+		ra := rae.RetryAfter()
+		if ra > maxRetryAfter {
+			ra = maxRetryAfter
+		}
+		// Only ever add to the server's delay, but still spread
+		// clients out, or every client told the same thing would
+		// retry in lockstep.
+		if ra = time.Duration(float64(ra) * (1 + j*rand.Float64())); ra > d {
+			d = ra
+		}
+	}
 
 	if d >= b.LogLongerThan {
 		b.logf("%s: [v1] backoff: %d msec", b.name, d.Milliseconds())
 	}
+	b.mu.Lock()
+	b.lastDelay = d
+	b.until = time.Now().Add(d)
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.until = time.Time{}
+		b.mu.Unlock()
+	}()
+
 	t := b.NewTimer(d)
 	select {
 	case <-ctx.Done():
@@ -79,3 +139,44 @@ func (b *Backoff) BackOff(ctx context.Context, err error) {
 	case <-t.C:
 	}
 }
+
+// jitter returns b.Jitter clamped to [0, 1].
+func (b *Backoff) jitter() float64 {
+	switch {
+	case b.Jitter < 0:
+		return 0
+	case b.Jitter > 1:
+		return 1
+	}
+	return b.Jitter
+}
+
+// State is a snapshot of a Backoff's schedule.
+type State struct {
+	Name       string
+	Failures   int           // number of consecutive failures
+	MaxBackoff time.Duration // the cap on the backoff interval, before jitter
+	Jitter     float64       // the fraction each interval is randomized by
+	LastDelay  time.Duration // the most recent backoff interval
+	Remaining  time.Duration // time left in the current backoff, or zero if not backing off
+}
+
+// State returns a snapshot of b's schedule. It's safe to call
+// concurrently with BackOff.
+func (b *Backoff) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st := State{
+		Name:       b.name,
+		Failures:   b.n,
+		MaxBackoff: b.maxBackoff,
+		Jitter:     b.jitter(),
+		LastDelay:  b.lastDelay,
+	}
+	if !b.until.IsZero() {
+		if st.Remaining = time.Until(b.until); st.Remaining < 0 {
+			st.Remaining = 0
+		}
+	}
+	return st
+}
@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestBackoff returns a Backoff that doesn't sleep, and a func that
+// returns the delay of its last BackOff.
+func newTestBackoff(maxBackoff time.Duration) (*Backoff, func() time.Duration) {
+	b := NewBackoff("test", func(string, ...any) {}, maxBackoff)
+	var last time.Duration
+	b.NewTimer = func(d time.Duration) *time.Timer {
+		last = d
+		return time.NewTimer(0)
+	}
+	return b, func() time.Duration { return last }
+}
+
+func TestBackoffJitter(t *testing.T) {
+	ctx := context.Background()
+	errFail := errors.New("fail")
+	for _, jitter := range []float64{0, 0.5, 1} {
+		t.Run(fmt.Sprint(jitter), func(t *testing.T) {
+			b, last := newTestBackoff(time.Second)
+			b.Jitter = jitter
+			for i := 0; i < 20; i++ {
+				b.BackOff(ctx, errFail)
+			}
+			const d = time.Second // the max, after 20 failures
+			lo := time.Duration(float64(d) * (1 - jitter))
+			hi := time.Duration(float64(d) * (1 + jitter))
+			for i := 0; i < 100; i++ {
+				b.BackOff(ctx, errFail)
+				if got := last(); got < lo || got > hi {
+					t.Fatalf("delay %v not in [%v, %v]", got, lo, hi)
+				}
+			}
+			if st := b.State(); st.Failures != 120 || st.LastDelay != last() || st.Remaining != 0 {
+				t.Errorf("State = %+v", st)
+			}
+			b.BackOff(ctx, nil)
+			if st := b.State(); st.Failures != 0 {
+				t.Errorf("after success, Failures = %d; want 0", st.Failures)
+			}
+		})
+	}
+}
+
+type retryAfterErr time.Duration
+
+func (e retryAfterErr) Error() string             { return "slow down" }
+func (e retryAfterErr) RetryAfter() time.Duration { return time.Duration(e) }
+
+func TestBackoffRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	b, last := newTestBackoff(time.Second)
+	for i := 0; i < 100; i++ {
+		b.BackOff(ctx, fmt.Errorf("wrapped: %w", retryAfterErr(time.Minute)))
+		if got := last(); got < time.Minute || got > 90*time.Second {
+			t.Fatalf("delay %v not in [1m, 1m30s]", got)
+		}
+	}
+	b.BackOff(ctx, retryAfterErr(time.Hour))
+	if got := last(); got > 15*time.Minute {
+		t.Errorf("delay %v; want at most 15m", got)
+	}
+}
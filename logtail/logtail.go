@@ -35,7 +35,9 @@
 // Config.BaseURL isn't provided.
 const DefaultHost = "log.tailscale.io"
 
-const defaultFlushDelay = 2 * time.Second
+// DefaultFlushDelay is how long logs are accumulated before uploading
+// them when Config.FlushDelayFn isn't provided.
+const DefaultFlushDelay = 2 * time.Second
 
 const (
 	// CollectionNode is the name of a logtail Config.Collection
@@ -525,7 +527,7 @@ func (l *Logger) sendLocked(jsonBlob []byte) (int, error) {
 
 	n, err := l.buffer.Write(jsonBlob)
 
-	flushDelay := defaultFlushDelay
+	flushDelay := DefaultFlushDelay
 	if l.flushDelayFn != nil {
 		flushDelay = l.flushDelayFn()
 	}
@@ -0,0 +1,277 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package dhcpd implements a tiny DHCPv4 server and IPv6 router
+// advertisement responder, for a node acting as the gateway of a LAN to
+// its tailnet, such as a travel router: clients on the LAN get an
+// address, and their default route and DNS point at the node.
+//
+// The node must have the gateway's address on the LAN interface, forward
+// IP packets, and advertise the LAN's prefixes as subnet routes, so that
+// tailnet peers (including an exit node) answer the clients.
+package dhcpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// Config is the configuration of a Server.
+type Config struct {
+	// Interface is the name of the LAN interface to serve.
+	Interface string
+
+	// Prefix is the IPv4 address of Interface, which clients use as
+	// their router, and its prefix, from which clients get the other
+	// addresses. For example, 192.168.77.1/24.
+	Prefix netip.Prefix
+
+	// V6Prefix, if valid, is an IPv6 /64 that router advertisements
+	// tell clients to configure addresses from with SLAAC, and route
+	// through the node.
+	V6Prefix netip.Prefix
+
+	// DNS are the nameservers handed out to clients. If empty, it's the
+	// Tailscale DNS resolver, 100.100.100.100 and fd7a:115c:a1e0::53,
+	// which the clients reach through the node.
+	DNS []netip.Addr
+
+	// LeaseTime is how long DHCP leases last. If zero, it's an hour.
+	LeaseTime time.Duration
+
+	// Logf is the logger to use. If nil, log.Printf is used.
+	Logf logger.Logf
+}
+
+// Server serves DHCP and router advertisements on a LAN interface.
+type Server struct {
+	conf   Config
+	self   netip.Addr // Prefix's address, the gateway
+	logf   logger.Logf
+	leases *leases
+
+	mu      sync.Mutex
+	closed  bool
+	closers []func() error // of the sockets being served
+}
+
+// New returns a Server for c, which is validated. It needs to be started
+// with Serve.
+func New(c Config) (*Server, error) {
+	if c.Interface == "" {
+		return nil, errors.New("no interface")
+	}
+	pfx := c.Prefix
+	if !pfx.IsValid() || !pfx.Addr().Is4() {
+		return nil, fmt.Errorf("invalid prefix %v: must be an IPv4 address and prefix, such as 192.168.77.1/24", pfx)
+	}
+	if pfx.Bits() < 16 || pfx.Bits() > 30 {
+		return nil, fmt.Errorf("invalid prefix %v: must be between /16 and /30", pfx)
+	}
+	if !assignable(pfx.Masked(), pfx.Addr()) {
+		return nil, fmt.Errorf("invalid prefix %v: its address is the network or broadcast address", pfx)
+	}
+	if c.V6Prefix.IsValid() && (!c.V6Prefix.Addr().Is6() || c.V6Prefix.Bits() != 64) {
+		return nil, fmt.Errorf("invalid IPv6 prefix %v: must be a /64", c.V6Prefix)
+	}
+	c.V6Prefix = c.V6Prefix.Masked()
+	if len(c.DNS) == 0 {
+		c.DNS = []netip.Addr{tsaddr.TailscaleServiceIP()}
+		if c.V6Prefix.IsValid() {
+			c.DNS = append(c.DNS, tsaddr.TailscaleServiceIPv6())
+		}
+	}
+	if c.LeaseTime == 0 {
+		c.LeaseTime = time.Hour
+	}
+	logf := c.Logf
+	if logf == nil {
+		logf = log.Printf
+	}
+	return &Server{
+		conf:   c,
+		self:   pfx.Addr(),
+		logf:   logger.WithPrefix(logf, "dhcpd: "),
+		leases: newLeases(pfx.Masked(), pfx.Addr()),
+	}, nil
+}
+
+// Close stops the Server.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var errs []error
+	for _, close := range s.closers {
+		if err := close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	s.closers = nil
+	return multierr.New(errs...)
+}
+
+// offerHold is how long an address offered to a client is held for it
+// to request it.
+const offerHold = time.Minute
+
+// leases tracks the addresses of a prefix leased to clients.
+type leases struct {
+	pfx  netip.Prefix // masked
+	self netip.Addr   // the gateway's address, never leased
+
+	mu    sync.Mutex
+	byMAC map[string]*lease
+	byIP  map[netip.Addr]*lease
+}
+
+type lease struct {
+	mac     string
+	ip      netip.Addr
+	expires time.Time
+}
+
+func newLeases(pfx netip.Prefix, self netip.Addr) *leases {
+	return &leases{
+		pfx:   pfx,
+		self:  self,
+		byMAC: map[string]*lease{},
+		byIP:  map[netip.Addr]*lease{},
+	}
+}
+
+// assignable reports whether ip is in pfx, and neither its network nor
+// broadcast address.
+func assignable(pfx netip.Prefix, ip netip.Addr) bool {
+	return pfx.Contains(ip) && ip != pfx.Addr() && pfx.Contains(ip.Next())
+}
+
+// freeLocked reports whether ip can be leased to mac, forgetting the
+// expired lease of ip, if any.
+func (l *leases) freeLocked(mac string, ip netip.Addr, now time.Time) bool {
+	if !assignable(l.pfx, ip) || ip == l.self {
+		return false
+	}
+	le, ok := l.byIP[ip]
+	if !ok || le.mac == mac {
+		return true
+	}
+	if now.Before(le.expires) {
+		return false
+	}
+	delete(l.byIP, ip)
+	delete(l.byMAC, le.mac)
+	return true
+}
+
+// setLocked leases ip to mac until expires, replacing mac's lease of
+// another address, if any.
+func (l *leases) setLocked(mac string, ip netip.Addr, expires time.Time) {
+	if old, ok := l.byMAC[mac]; ok && old.ip != ip {
+		delete(l.byIP, old.ip)
+	}
+	le := &lease{mac: mac, ip: ip, expires: expires}
+	l.byMAC[mac] = le
+	l.byIP[ip] = le
+}
+
+// offer returns the address to offer to mac, preferring the one it has
+// or else requested, and holds it for offerHold. It reports false if no
+// address is free.
+func (l *leases) offer(mac string, requested netip.Addr, now time.Time) (netip.Addr, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if le, ok := l.byMAC[mac]; ok {
+		if le.expires.Before(now.Add(offerHold)) {
+			le.expires = now.Add(offerHold)
+		}
+		return le.ip, true
+	}
+	ip := requested
+	if !l.freeLocked(mac, ip, now) {
+		ip = netip.Addr{}
+		for a := l.pfx.Addr().Next(); assignable(l.pfx, a); a = a.Next() {
+			if l.freeLocked(mac, a, now) {
+				ip = a
+				break
+			}
+		}
+		if !ip.IsValid() {
+			return netip.Addr{}, false
+		}
+	}
+	l.setLocked(mac, ip, now.Add(offerHold))
+	return ip, true
+}
+
+// ack leases ip to mac for d, and reports whether it could.
+func (l *leases) ack(mac string, ip netip.Addr, d time.Duration, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.freeLocked(mac, ip, now) {
+		return false
+	}
+	l.setLocked(mac, ip, now.Add(d))
+	return true
+}
+
+// release ends the lease of ip to mac, if any.
+func (l *leases) release(mac string, ip netip.Addr) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if le, ok := l.byMAC[mac]; ok && le.ip == ip {
+		delete(l.byMAC, mac)
+		delete(l.byIP, ip)
+	}
+}
+
+// Router advertisement parameters, per RFC 4861 and RFC 8106.
+const (
+	raInterval       = 200 * time.Second // between unsolicited advertisements
+	raRouterLifetime = 30 * time.Minute
+	raValidLifetime  = 24 * time.Hour
+	raPrefLifetime   = 4 * time.Hour
+	raDNSLifetime    = 3 * raInterval
+)
+
+// raMessage returns the ICMPv6 router advertisement, without its type,
+// code and checksum, that makes clients configure an address from pfx,
+// route through the sender, and use dns as nameservers. mac, if
+// non-nil, is the sender's link-layer address.
+func raMessage(pfx netip.Prefix, dns []netip.Addr, mac net.HardwareAddr) []byte {
+	b := make([]byte, 12, 64)
+	b[0] = 64 // current hop limit
+	binary.BigEndian.PutUint16(b[2:], uint16(raRouterLifetime/time.Second))
+
+	if len(mac) == 6 {
+		b = append(b, 1, 1) // source link-layer address option, 8 bytes
+		b = append(b, mac...)
+	}
+
+	b = append(b, 3, 4, 64, 0xc0) // prefix information option, 32 bytes, on-link and autonomous
+	b = binary.BigEndian.AppendUint32(b, uint32(raValidLifetime/time.Second))
+	b = binary.BigEndian.AppendUint32(b, uint32(raPrefLifetime/time.Second))
+	b = append(b, 0, 0, 0, 0) // reserved
+	a := pfx.Addr().As16()
+	b = append(b, a[:]...)
+
+	if len(dns) > 0 {
+		b = append(b, 25, byte(1+2*len(dns)), 0, 0) // recursive DNS server option
+		b = binary.BigEndian.AppendUint32(b, uint32(raDNSLifetime/time.Second))
+		for _, ip := range dns {
+			a := ip.As16()
+			b = append(b, a[:]...)
+		}
+	}
+	return b
+}
@@ -0,0 +1,239 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dhcpd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/server4"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv6"
+)
+
+// Serve serves DHCP and, if Config.V6Prefix is set, router advertisements
+// until s is closed or fails. It needs root.
+func (s *Server) Serve() error {
+	errc := make(chan error, 2)
+	n := 1
+	go func() { errc <- s.serveDHCP() }()
+	if s.conf.V6Prefix.IsValid() {
+		n++
+		go func() { errc <- s.serveRA() }()
+	}
+	err := <-errc
+	s.Close()
+	for i := 1; i < n; i++ {
+		<-errc
+	}
+	return err
+}
+
+// addCloser adds close to the funcs Close calls, unless s is already
+// closed, in which case it reports false.
+func (s *Server) addCloser(close func() error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.closers = append(s.closers, close)
+	return true
+}
+
+// dnsAddrs returns the IPv4 or, if !is4, IPv6 addresses of c.DNS.
+func (c *Config) dnsAddrs(is4 bool) []netip.Addr {
+	var ret []netip.Addr
+	for _, ip := range c.DNS {
+		if ip.Is4() == is4 {
+			ret = append(ret, ip)
+		}
+	}
+	return ret
+}
+
+func (s *Server) serveDHCP() error {
+	srv, err := server4.NewServer(s.conf.Interface, nil, s.handleDHCP)
+	if err != nil {
+		return fmt.Errorf("DHCP: %w", err)
+	}
+	if !s.addCloser(srv.Close) {
+		srv.Close()
+		return net.ErrClosed
+	}
+	s.logf("serving DHCP for %v on %s", s.conf.Prefix, s.conf.Interface)
+	if err := srv.Serve(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("DHCP: %w", err)
+	}
+	return nil
+}
+
+func (s *Server) handleDHCP(conn net.PacketConn, peer net.Addr, req *dhcpv4.DHCPv4) {
+	resp, err := s.dhcpReply(req, time.Now())
+	if err != nil {
+		s.logf("building reply to %v: %v", req.ClientHWAddr, err)
+		return
+	}
+	if resp == nil {
+		return
+	}
+	if _, err := conn.WriteTo(resp.ToBytes(), peer); err != nil {
+		s.logf("writing %v to %v: %v", resp.MessageType(), req.ClientHWAddr, err)
+	}
+}
+
+// dhcpReply returns the reply to req, or nil if there's none.
+func (s *Server) dhcpReply(req *dhcpv4.DHCPv4, now time.Time) (*dhcpv4.DHCPv4, error) {
+	if req.OpCode != dhcpv4.OpcodeBootRequest {
+		return nil, nil
+	}
+	mac := req.ClientHWAddr.String()
+	switch req.MessageType() {
+	case dhcpv4.MessageTypeDiscover:
+		ip, ok := s.leases.offer(mac, addrOf(req.RequestedIPAddress()), now)
+		if !ok {
+			s.logf("no free address for %v", mac)
+			return nil, nil
+		}
+		return s.newReply(req, dhcpv4.MessageTypeOffer, ip)
+	case dhcpv4.MessageTypeRequest:
+		if sid := addrOf(req.ServerIdentifier()); sid.IsValid() && sid != s.self {
+			// The client chose another server's offer.
+			s.leases.release(mac, addrOf(req.RequestedIPAddress()))
+			return nil, nil
+		}
+		ip := addrOf(req.RequestedIPAddress())
+		if !ip.IsValid() {
+			ip = addrOf(req.ClientIPAddr) // renewing
+		}
+		if !s.leases.ack(mac, ip, s.conf.LeaseTime, now) {
+			return s.newReply(req, dhcpv4.MessageTypeNak, netip.Addr{})
+		}
+		return s.newReply(req, dhcpv4.MessageTypeAck, ip)
+	case dhcpv4.MessageTypeRelease:
+		s.leases.release(mac, addrOf(req.ClientIPAddr))
+	}
+	return nil, nil
+}
+
+// newReply returns a reply of type t to req that, unless it's a NAK,
+// leases ip to the client.
+func (s *Server) newReply(req *dhcpv4.DHCPv4, t dhcpv4.MessageType, ip netip.Addr) (*dhcpv4.DHCPv4, error) {
+	self := net.IP(s.self.AsSlice())
+	mods := []dhcpv4.Modifier{
+		dhcpv4.WithMessageType(t),
+		dhcpv4.WithServerIP(self),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(self)),
+	}
+	if t != dhcpv4.MessageTypeNak {
+		var dns []net.IP
+		for _, ip := range s.conf.dnsAddrs(true) {
+			dns = append(dns, ip.AsSlice())
+		}
+		mods = append(mods,
+			dhcpv4.WithYourIP(ip.AsSlice()),
+			dhcpv4.WithNetmask(net.CIDRMask(s.conf.Prefix.Bits(), 32)),
+			dhcpv4.WithRouter(self),
+			dhcpv4.WithDNS(dns...),
+			dhcpv4.WithLeaseTime(uint32(s.conf.LeaseTime/time.Second)),
+		)
+	}
+	return dhcpv4.NewReplyFromRequest(req, mods...)
+}
+
+// addrOf returns ip as a netip.Addr, or the zero Addr if ip isn't a valid
+// IPv4 address other than 0.0.0.0.
+func addrOf(ip net.IP) netip.Addr {
+	a, ok := netip.AddrFromSlice(ip.To4())
+	if !ok || a.IsUnspecified() {
+		return netip.Addr{}
+	}
+	return a
+}
+
+var (
+	allNodes   = net.ParseIP("ff02::1")
+	allRouters = net.ParseIP("ff02::2")
+)
+
+// serveRA sends router advertisements for Config.V6Prefix every
+// raInterval, and in response to router solicitations.
+func (s *Server) serveRA() error {
+	ifi, err := net.InterfaceByName(s.conf.Interface)
+	if err != nil {
+		return fmt.Errorf("RA: %w", err)
+	}
+	c, err := icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		return fmt.Errorf("RA: %w", err)
+	}
+	if !s.addCloser(c.Close) {
+		c.Close()
+		return net.ErrClosed
+	}
+	pc := c.IPv6PacketConn()
+	var f ipv6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ipv6.ICMPTypeRouterSolicitation)
+	for _, err := range []error{
+		pc.SetICMPFilter(&f),
+		pc.JoinGroup(ifi, &net.IPAddr{IP: allRouters}),
+		pc.SetMulticastInterface(ifi),
+		pc.SetMulticastHopLimit(255),
+		pc.SetControlMessage(ipv6.FlagInterface, true),
+	} {
+		if err != nil {
+			return fmt.Errorf("RA: %w", err)
+		}
+	}
+
+	body := raMessage(s.conf.V6Prefix, s.conf.dnsAddrs(false), ifi.HardwareAddr)
+	msg, err := (&icmp.Message{
+		Type: ipv6.ICMPTypeRouterAdvertisement,
+		Body: &icmp.RawBody{Data: body},
+	}).Marshal(nil) // the kernel fills in the checksum
+	if err != nil {
+		return fmt.Errorf("RA: %w", err)
+	}
+	dst := &net.IPAddr{IP: allNodes, Zone: ifi.Name}
+	send := func() {
+		if _, err := pc.WriteTo(msg, &ipv6.ControlMessage{IfIndex: ifi.Index, HopLimit: 255}, dst); err != nil {
+			s.logf("sending RA: %v", err)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		t := time.NewTicker(raInterval)
+		defer t.Stop()
+		for {
+			send()
+			select {
+			case <-t.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	s.logf("advertising %v on %s", s.conf.V6Prefix, s.conf.Interface)
+	buf := make([]byte, 1500)
+	for {
+		_, cm, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("RA: %w", err)
+		}
+		if cm != nil && cm.IfIndex == ifi.Index {
+			send()
+		}
+	}
+}
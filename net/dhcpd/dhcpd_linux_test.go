@@ -0,0 +1,74 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dhcpd
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestDHCPReply(t *testing.T) {
+	s, err := New(Config{Interface: "eth1", Prefix: netip.MustParsePrefix("192.168.77.1/24")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 0xa}
+
+	discover, err := dhcpv4.NewDiscovery(mac)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offer, err := s.dhcpReply(discover, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer.MessageType() != dhcpv4.MessageTypeOffer {
+		t.Fatalf("reply to discover = %v; want offer", offer.MessageType())
+	}
+	if got := offer.YourIPAddr.String(); got != "192.168.77.2" {
+		t.Errorf("offered %v; want 192.168.77.2", got)
+	}
+	if got := offer.Router(); len(got) != 1 || got[0].String() != "192.168.77.1" {
+		t.Errorf("router = %v; want 192.168.77.1", got)
+	}
+	if got := offer.DNS(); len(got) != 1 || got[0].String() != "100.100.100.100" {
+		t.Errorf("DNS = %v; want 100.100.100.100", got)
+	}
+	if got := offer.SubnetMask().String(); got != "ffffff00" {
+		t.Errorf("netmask = %v; want ffffff00", got)
+	}
+
+	req, err := dhcpv4.NewRequestFromOffer(offer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := s.dhcpReply(req, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.MessageType() != dhcpv4.MessageTypeAck || !ack.YourIPAddr.Equal(offer.YourIPAddr) {
+		t.Errorf("reply to request = %v of %v; want ack of %v", ack.MessageType(), ack.YourIPAddr, offer.YourIPAddr)
+	}
+	if got := ack.IPAddressLeaseTime(0); got != time.Hour {
+		t.Errorf("lease time = %v; want 1h", got)
+	}
+
+	// Another client requesting the same address is refused.
+	other, err := dhcpv4.NewRequestFromOffer(offer, dhcpv4.WithHwAddr(net.HardwareAddr{2, 0, 0, 0, 0, 0xb}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nak, err := s.dhcpReply(other, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nak.MessageType() != dhcpv4.MessageTypeNak {
+		t.Errorf("reply to another client's request = %v; want NAK", nak.MessageType())
+	}
+}
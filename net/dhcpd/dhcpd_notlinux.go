@@ -0,0 +1,17 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package dhcpd
+
+import (
+	"errors"
+	"runtime"
+)
+
+// Serve serves DHCP and router advertisements, which is only supported
+// on Linux.
+func (s *Server) Serve() error {
+	return errors.New("dhcpd: not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dhcpd
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestNewValidates(t *testing.T) {
+	tests := []struct {
+		pfx, v6 string
+		ok      bool
+	}{
+		{"192.168.77.1/24", "", true},
+		{"192.168.77.1/24", "fd00:77::/64", true},
+		{"192.168.77.0/24", "", false},   // network address
+		{"192.168.77.255/24", "", false}, // broadcast address
+		{"10.0.0.1/8", "", false},        // too large
+		{"192.168.77.1/31", "", false},   // too small
+		{"fd00::1/64", "", false},
+		{"192.168.77.1/24", "fd00:77::/48", false},
+	}
+	for _, tt := range tests {
+		c := Config{Interface: "eth1", Prefix: netip.MustParsePrefix(tt.pfx)}
+		if tt.v6 != "" {
+			c.V6Prefix = netip.MustParsePrefix(tt.v6)
+		}
+		if _, err := New(c); (err == nil) != tt.ok {
+			t.Errorf("New(%v, %v) error = %v; want ok=%v", tt.pfx, tt.v6, err, tt.ok)
+		}
+	}
+}
+
+func TestLeases(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newLeases(netip.MustParsePrefix("192.168.77.0/29"), netip.MustParseAddr("192.168.77.1"))
+	a, b := "02:00:00:00:00:0a", "02:00:00:00:00:0b"
+	ip := netip.MustParseAddr
+
+	// The gateway's own address is skipped.
+	got, ok := l.offer(a, netip.Addr{}, now)
+	if !ok || got != ip("192.168.77.2") {
+		t.Fatalf("offer to a = %v, %v; want 192.168.77.2", got, ok)
+	}
+	if again, _ := l.offer(a, ip("192.168.77.5"), now); again != got {
+		t.Errorf("second offer to a = %v; want %v", again, got)
+	}
+	// b's requested address is offered, unless it's taken.
+	if got, _ := l.offer(b, ip("192.168.77.2"), now); got != ip("192.168.77.3") {
+		t.Errorf("offer to b of a's address = %v; want 192.168.77.3", got)
+	}
+	if l.ack(b, ip("192.168.77.2"), time.Hour, now) {
+		t.Error("ack to b of a's address succeeded")
+	}
+	if !l.ack(b, ip("192.168.77.6"), time.Hour, now) {
+		t.Error("ack to b of a free address failed")
+	}
+	if l.ack(b, ip("192.168.77.7"), time.Hour, now) {
+		t.Error("ack of the broadcast address succeeded")
+	}
+	if !l.ack(a, ip("192.168.77.2"), time.Hour, now) {
+		t.Error("ack to a of its offer failed")
+	}
+
+	// a's lease expires, and another client gets its address.
+	now = now.Add(2 * time.Hour)
+	c := "02:00:00:00:00:0c"
+	if !l.ack(c, ip("192.168.77.2"), time.Hour, now) {
+		t.Error("ack to c of a's expired address failed")
+	}
+
+	l.release(c, ip("192.168.77.2"))
+	if got, _ := l.offer(a, netip.Addr{}, now); got != ip("192.168.77.2") {
+		t.Errorf("offer to a after release = %v; want 192.168.77.2", got)
+	}
+}
+
+func TestLeasesExhausted(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newLeases(netip.MustParsePrefix("192.168.77.0/30"), netip.MustParseAddr("192.168.77.1"))
+	if _, ok := l.offer("02:00:00:00:00:0a", netip.Addr{}, now); !ok {
+		t.Fatal("first offer failed")
+	}
+	if got, ok := l.offer("02:00:00:00:00:0b", netip.Addr{}, now); ok {
+		t.Errorf("offer with no free address = %v; want none", got)
+	}
+	if _, ok := l.offer("02:00:00:00:00:0b", netip.Addr{}, now.Add(offerHold)); !ok {
+		t.Error("offer after the first one's hold expired failed")
+	}
+}
+
+func TestRAMessage(t *testing.T) {
+	mac := net.HardwareAddr{2, 0, 0, 0, 0, 1}
+	got := raMessage(netip.MustParsePrefix("fd00:77::/64"), []netip.Addr{netip.MustParseAddr("fd7a:115c:a1e0::53")}, mac)
+	want := []byte{
+		64, 0, 0x07, 0x08, // hop limit, flags, router lifetime 1800s
+		0, 0, 0, 0, 0, 0, 0, 0, // reachable time, retrans timer
+
+		1, 1, 2, 0, 0, 0, 0, 1, // source link-layer address
+
+		3, 4, 64, 0xc0, // prefix information
+		0, 0x01, 0x51, 0x80, // valid lifetime 86400s
+		0, 0, 0x38, 0x40, // preferred lifetime 14400s
+		0, 0, 0, 0,
+		0xfd, 0, 0, 0x77, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+
+		25, 3, 0, 0, // RDNSS
+		0, 0, 0x02, 0x58, // lifetime 600s
+		0xfd, 0x7a, 0x11, 0x5c, 0xa1, 0xe0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x53,
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("raMessage =\n% x\nwant\n% x", got, want)
+	}
+}
@@ -4,29 +4,68 @@
 package dns
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/json"
+	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"go4.org/mem"
+	"tailscale.com/atomicfile"
 	"tailscale.com/types/logger"
 	"tailscale.com/util/mak"
+	"tailscale.com/util/multierr"
 )
 
 func NewOSConfigurator(logf logger.Logf, ifName string) (OSConfigurator, error) {
-	return &darwinConfigurator{logf: logf, ifName: ifName}, nil
+	c := &darwinConfigurator{logf: logf, ifName: ifName, savedFile: savedServicesFile}
+	// If a previous tailscaled died while overriding the network
+	// services' DNS, restore them from its backup on Close, or on the
+	// next SetDNS that doesn't override them.
+	if err := c.loadSaved(); err != nil {
+		logf("dns: reading %s: %v", c.savedFile, err)
+	}
+	return c, nil
 }
 
+// savedServicesFile is where darwinConfigurator backs up the network
+// services' DNS settings while it overrides them, so that they can be
+// restored after a crash, by the next tailscaled or tailscaled --cleanup.
+const savedServicesFile = "/Library/Tailscale/dns-pre-tailscale-backup.json"
+
 // darwinConfigurator is the tailscaled-on-macOS DNS OS configurator that
 // maintains the Split DNS nameserver entries pointing MagicDNS DNS suffixes
 // to 100.100.100.100 using the macOS /etc/resolver/$SUFFIX files.
+//
+// When all DNS queries are to go to Tailscale's nameservers (such as with
+// an exit node, or when overriding the local DNS), it instead sets them as
+// the DNS servers of each network service with networksetup(8), restoring
+// the services' own settings afterwards.
 type darwinConfigurator struct {
-	logf   logger.Logf
-	ifName string
+	logf      logger.Logf
+	ifName    string
+	savedFile string // backup of saved; savedServicesFile but in tests
+
+	// saved are the DNS settings of each network service from before
+	// they were overridden, or nil if they're not overridden. It's
+	// written to savedFile before any service is overridden.
+	saved map[string]serviceDNS
+}
+
+// serviceDNS are the DNS settings of a macOS network service. A nil slice
+// means the setting isn't set manually, and comes from DHCP or similar.
+type serviceDNS struct {
+	Servers []string
+	Search  []string
 }
 
 func (c *darwinConfigurator) Close() error {
+	err := c.restoreServices()
 	c.removeResolverFiles(func(domain string) bool { return true })
-	return nil
+	return err
 }
 
 func (c *darwinConfigurator) SupportsSplitDNS() bool {
@@ -34,6 +73,18 @@ func (c *darwinConfigurator) SupportsSplitDNS() bool {
 }
 
 func (c *darwinConfigurator) SetDNS(cfg OSConfig) error {
+	if len(cfg.Nameservers) > 0 && len(cfg.MatchDomains) == 0 {
+		// Not split DNS: all queries go to cfg.Nameservers, which
+		// /etc/resolver files can't express.
+		if err := c.overrideServices(cfg); err != nil {
+			return err
+		}
+		return c.removeResolverFiles(func(domain string) bool { return true })
+	}
+	if err := c.restoreServices(); err != nil {
+		return err
+	}
+
 	var buf bytes.Buffer
 	buf.WriteString(macResolverFileHeader)
 	for i, ip := range cfg.Nameservers {
@@ -123,3 +174,192 @@ func (c *darwinConfigurator) removeResolverFiles(shouldDelete func(domain string
 	}
 	return nil
 }
+
+// overrideServices sets the DNS servers and search domains of all enabled
+// network services to those of cfg, saving their previous settings first.
+func (c *darwinConfigurator) overrideServices(cfg OSConfig) error {
+	services, err := networkServices()
+	if err != nil {
+		return err
+	}
+	servers := make([]string, 0, len(cfg.Nameservers))
+	for _, ip := range cfg.Nameservers {
+		servers = append(servers, ip.String())
+	}
+	search := make([]string, 0, len(cfg.SearchDomains))
+	for _, d := range cfg.SearchDomains {
+		search = append(search, string(d.WithoutTrailingDot()))
+	}
+	var errs []error
+	var toSet []string
+	newSaved := false
+	for _, svc := range services {
+		if _, ok := c.saved[svc]; !ok {
+			orig, err := getServiceDNS(svc)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			mak.Set(&c.saved, svc, orig)
+			newSaved = true
+		}
+		toSet = append(toSet, svc)
+	}
+	if newSaved {
+		// Back up the settings before changing any, or a crash would
+		// leave the services without their DNS servers.
+		if err := c.writeSaved(); err != nil {
+			return fmt.Errorf("backing up DNS settings: %w", err)
+		}
+	}
+	for _, svc := range toSet {
+		if err := setServiceDNS(svc, serviceDNS{servers, search}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierr.New(errs...)
+}
+
+// restoreServices restores the DNS settings of the network services
+// overridden by overrideServices, if any.
+func (c *darwinConfigurator) restoreServices() error {
+	var errs []error
+	for svc, orig := range c.saved {
+		if err := setServiceDNS(svc, orig); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(c.saved, svc)
+	}
+	if len(c.saved) == 0 {
+		c.saved = nil
+	}
+	if err := c.writeSaved(); err != nil {
+		errs = append(errs, err)
+	}
+	return multierr.New(errs...)
+}
+
+// loadSaved adds the settings backed up in c.savedFile, if any, to
+// c.saved.
+func (c *darwinConfigurator) loadSaved() error {
+	b, err := os.ReadFile(c.savedFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved map[string]serviceDNS
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	for svc, d := range saved {
+		if _, ok := c.saved[svc]; !ok {
+			mak.Set(&c.saved, svc, d)
+		}
+	}
+	return nil
+}
+
+// writeSaved writes c.saved to c.savedFile, or removes the file if
+// there's nothing saved.
+func (c *darwinConfigurator) writeSaved() error {
+	if len(c.saved) == 0 {
+		if err := os.Remove(c.savedFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(c.saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.savedFile), 0700); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(c.savedFile, b, 0600)
+}
+
+// networksetup runs networksetup(8) with args and returns its output.
+func networksetup(args ...string) ([]byte, error) {
+	out, err := exec.Command("networksetup", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("networksetup %s: %w: %s", strings.Join(args, " "), err, bytes.TrimSpace(out))
+	}
+	return out, nil
+}
+
+// networkServices returns the names of the enabled network services.
+func networkServices() ([]string, error) {
+	out, err := networksetup("-listallnetworkservices")
+	if err != nil {
+		return nil, err
+	}
+	return parseNetworkServices(out), nil
+}
+
+// parseNetworkServices parses the output of
+// "networksetup -listallnetworkservices", skipping disabled services.
+func parseNetworkServices(out []byte) []string {
+	var services []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "*") || strings.HasPrefix(line, "An asterisk (*)") {
+			continue
+		}
+		services = append(services, line)
+	}
+	return services
+}
+
+// parseNetworksetupList parses the output of "networksetup -getdnsservers"
+// or "networksetup -getsearchdomains", which is one entry per line, or a
+// sentence if there are none. It returns nil if there are none.
+func parseNetworksetupList(out []byte) []string {
+	var items []string
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if strings.Contains(line, " ") {
+			// "There aren't any DNS Servers set on Wi-Fi."
+			return nil
+		}
+		items = append(items, line)
+	}
+	return items
+}
+
+func getServiceDNS(svc string) (serviceDNS, error) {
+	servers, err := networksetup("-getdnsservers", svc)
+	if err != nil {
+		return serviceDNS{}, err
+	}
+	search, err := networksetup("-getsearchdomains", svc)
+	if err != nil {
+		return serviceDNS{}, err
+	}
+	return serviceDNS{
+		Servers: parseNetworksetupList(servers),
+		Search:  parseNetworksetupList(search),
+	}, nil
+}
+
+func setServiceDNS(svc string, d serviceDNS) error {
+	// "Empty" clears the manual setting.
+	orEmpty := func(s []string) []string {
+		if len(s) == 0 {
+			return []string{"Empty"}
+		}
+		return s
+	}
+	if _, err := networksetup(append([]string{"-setdnsservers", svc}, orEmpty(d.Servers)...)...); err != nil {
+		return err
+	}
+	_, err := networksetup(append([]string{"-setsearchdomains", svc}, orEmpty(d.Search)...)...)
+	return err
+}
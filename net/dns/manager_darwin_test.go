@@ -0,0 +1,73 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseNetworkServices(t *testing.T) {
+	out := []byte(`An asterisk (*) denotes that a network service is disabled.
+USB 10/100/1000 LAN
+Wi-Fi
+*Thunderbolt Bridge
+`)
+	got := parseNetworkServices(out)
+	want := []string{"USB 10/100/1000 LAN", "Wi-Fi"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+}
+
+func TestParseNetworksetupList(t *testing.T) {
+	tests := []struct {
+		out  string
+		want []string
+	}{
+		{"There aren't any DNS Servers set on Wi-Fi.\n", nil},
+		{"There aren't any Search Domains set on Wi-Fi.\n", nil},
+		{"1.1.1.1\n8.8.8.8\n", []string{"1.1.1.1", "8.8.8.8"}},
+		{"corp.example.com\n", []string{"corp.example.com"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got := parseNetworksetupList([]byte(tt.out))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseNetworksetupList(%q) = %q; want %q", tt.out, got, tt.want)
+		}
+	}
+}
+
+func TestSavedServicesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saved.json")
+	c := &darwinConfigurator{savedFile: path}
+	c.saved = map[string]serviceDNS{
+		"Wi-Fi":               {Servers: []string{"192.168.1.1"}, Search: []string{"lan"}},
+		"USB 10/100/1000 LAN": {},
+	}
+	if err := c.writeSaved(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new configurator, as after a crash or in tailscaled --cleanup,
+	// picks up the backup.
+	c2 := &darwinConfigurator{savedFile: path}
+	if err := c2.loadSaved(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c2.saved, c.saved) {
+		t.Errorf("loaded %+v; want %+v", c2.saved, c.saved)
+	}
+
+	c2.saved = nil
+	if err := c2.writeSaved(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("backup not removed once nothing is saved: %v", err)
+	}
+}
@@ -20,10 +20,12 @@
 	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"tailscale.com/atomicfile"
+	"tailscale.com/envknob"
 	"tailscale.com/net/netns"
 	"tailscale.com/net/tlsdial"
 	"tailscale.com/net/tshttpproxy"
@@ -33,10 +35,19 @@
 	"tailscale.com/util/slicesx"
 )
 
+// Lookup resolves host without the system DNS, first with any bootstrap
+// DNS servers configured by SetBootstrapServers or TS_DNSFALLBACK_SERVERS,
+// then with the DNS service of the DERP servers.
 func Lookup(ctx context.Context, host string) ([]netip.Addr, error) {
 	if ip, err := netip.ParseAddr(host); err == nil && ip.IsValid() {
 		return []netip.Addr{ip}, nil
 	}
+	if servers := getBootstrapServers(); len(servers) > 0 {
+		if ips, err := lookupBootstrapServers(ctx, servers, host); err == nil {
+			return ips, nil
+		}
+		logf("no bootstrap DNS server resolved %q; trying DERP", host)
+	}
 
 	type nameIP struct {
 		dnsName string
@@ -98,6 +109,94 @@ type nameIP struct {
 	return nil, fmt.Errorf("no DNS fallback candidates remain for %q", host)
 }
 
+// bootstrapServersEnv is a comma-separated list of bootstrap DNS servers,
+// as ParseBootstrapServers takes.
+var bootstrapServersEnv = envknob.RegisterString("TS_DNSFALLBACK_SERVERS")
+
+// bootstrapServers are the servers set by SetBootstrapServers, or nil if
+// it hasn't been called.
+var bootstrapServers atomic.Pointer[[]netip.AddrPort]
+
+// SetBootstrapServers sets the DNS servers that Lookup tries before the
+// DERP servers, for networks where only internal resolvers are reachable.
+// It overrides the TS_DNSFALLBACK_SERVERS environment variable. An empty
+// non-nil list means to only use DERP, and nil goes back to the
+// environment variable.
+func SetBootstrapServers(servers []netip.AddrPort) {
+	if servers == nil {
+		bootstrapServers.Store(nil)
+		return
+	}
+	bootstrapServers.Store(&servers)
+}
+
+// ParseBootstrapServers parses s, a comma-separated list of DNS servers,
+// each an IP address or IP:port, such as "10.0.0.53,[fd00::53]:5353".
+// The port defaults to 53.
+func ParseBootstrapServers(s string) ([]netip.AddrPort, error) {
+	var ret []netip.AddrPort
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" {
+			continue
+		}
+		if ip, err := netip.ParseAddr(f); err == nil {
+			ret = append(ret, netip.AddrPortFrom(ip, 53))
+			continue
+		}
+		ap, err := netip.ParseAddrPort(f)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bootstrap DNS server %q", f)
+		}
+		ret = append(ret, ap)
+	}
+	return ret, nil
+}
+
+func getBootstrapServers() []netip.AddrPort {
+	if p := bootstrapServers.Load(); p != nil {
+		return *p
+	}
+	servers, err := ParseBootstrapServers(bootstrapServersEnv())
+	if err != nil {
+		logf("TS_DNSFALLBACK_SERVERS: %v", err)
+	}
+	return servers
+}
+
+// lookupBootstrapServers resolves host with plain DNS queries to each of
+// servers in turn, returning the first answer.
+func lookupBootstrapServers(ctx context.Context, servers []netip.AddrPort, host string) ([]netip.Addr, error) {
+	dialer := netns.NewDialer(logf)
+	var lastErr error
+	for _, server := range servers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		server := server
+		r := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, server.String())
+			},
+		}
+		ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+		ips, err := r.LookupNetIP(ctx, "ip", host)
+		cancel()
+		if err != nil {
+			logf("bootstrap DNS server %v for %q error: %v", server, host, err)
+			lastErr = err
+			continue
+		}
+		for i := range ips {
+			ips[i] = ips[i].Unmap()
+		}
+		logf("bootstrap DNS server %v for %q = %v", server, host, ips)
+		return ips, nil
+	}
+	return nil, lastErr
+}
+
 // serverName and serverIP of are, say, "derpN.tailscale.com".
 // queryName is the name being sought (e.g. "controlplane.tailscale.com"), passed as hint.
 func bootstrapDNSMap(ctx context.Context, serverName string, serverIP netip.Addr, queryName string) (dnsMap, error) {
@@ -4,12 +4,17 @@
 package dnsfallback
 
 import (
+	"context"
 	"encoding/json"
+	"net"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"reflect"
 	"testing"
 
+	"golang.org/x/net/dns/dnsmessage"
+	"tailscale.com/net/netns"
 	"tailscale.com/tailcfg"
 )
 
@@ -180,3 +185,76 @@ func TestCacheUnchanged(t *testing.T) {
 		t.Fatalf("didn't find non-empty regular file; mode=%v size=%d", st.Mode(), st.Size())
 	}
 }
+
+func TestParseBootstrapServers(t *testing.T) {
+	got, err := ParseBootstrapServers(" 10.0.0.53, [fd00::53]:5353,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []netip.AddrPort{
+		netip.MustParseAddrPort("10.0.0.53:53"),
+		netip.MustParseAddrPort("[fd00::53]:5353"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v; want %v", got, want)
+	}
+	if _, err := ParseBootstrapServers("dns.example.com"); err == nil {
+		t.Error("ParseBootstrapServers accepted a hostname")
+	}
+}
+
+func TestLookupBootstrapServers(t *testing.T) {
+	netns.SetEnabled(false)
+	t.Cleanup(func() { netns.SetEnabled(true) })
+
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	go serveFakeDNS(pc, netip.MustParseAddr("100.100.1.1"))
+
+	SetBootstrapServers([]netip.AddrPort{pc.LocalAddr().(*net.UDPAddr).AddrPort()})
+	t.Cleanup(func() { SetBootstrapServers(nil) })
+
+	ips, err := Lookup(context.Background(), "controlplane.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ips) != 1 || ips[0] != netip.MustParseAddr("100.100.1.1") {
+		t.Errorf("Lookup = %v; want [100.100.1.1]", ips)
+	}
+}
+
+// serveFakeDNS answers every A query read from pc with ip, and every other
+// query with no records, until pc is closed.
+func serveFakeDNS(pc net.PacketConn, ip netip.Addr) {
+	buf := make([]byte, 512)
+	for {
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, Authoritative: true})
+		b.StartQuestions()
+		b.Question(q)
+		b.StartAnswers()
+		if q.Type == dnsmessage.TypeA {
+			b.AResource(dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}, dnsmessage.AResource{A: ip.As4()})
+		}
+		msg, err := b.Finish()
+		if err != nil {
+			continue
+		}
+		pc.WriteTo(msg, src)
+	}
+}
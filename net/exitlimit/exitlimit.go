@@ -0,0 +1,99 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package exitlimit limits the rate of the traffic that a node forwards
+// off the tailnet for each of its peers, such as when it's their exit
+// node, so that no one peer can saturate the node's uplink.
+package exitlimit
+
+import (
+	"net/netip"
+	"sync"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/net/tsaddr"
+	"tailscale.com/tstime/rate"
+	"tailscale.com/util/clientmetric"
+)
+
+// minBurst is the smallest burst a Limiter allows, so that any packet
+// can get through a low limit.
+const minBurst = 64 << 10
+
+// Limiter limits the bytes per second of traffic forwarded between each
+// peer and non-Tailscale addresses, separately in each direction.
+// Traffic between Tailscale addresses isn't limited.
+// All methods are safe for concurrent use.
+type Limiter struct {
+	limit rate.Limit
+	burst int
+
+	mu   sync.Mutex
+	up   map[netip.Addr]*rate.Limiter // from peers, by peer IP
+	down map[netip.Addr]*rate.Limiter // to peers, by peer IP
+}
+
+// New returns a Limiter of bytesPerSec for each peer in each direction.
+// Bursts of up to a second's worth are allowed.
+func New(bytesPerSec uint64) *Limiter {
+	burst := minBurst
+	if bytesPerSec > minBurst {
+		burst = int(bytesPerSec)
+	}
+	return &Limiter{
+		limit: rate.Limit(bytesPerSec),
+		burst: burst,
+		up:    make(map[netip.Addr]*rate.Limiter),
+		down:  make(map[netip.Addr]*rate.Limiter),
+	}
+}
+
+// AllowFromPeer reports whether p, a packet received from a peer, is
+// within the peer's limit for being forwarded.
+func (l *Limiter) AllowFromPeer(p *packet.Parsed) bool {
+	peer, other := p.Src.Addr(), p.Dst.Addr()
+	if !limited(peer, other) {
+		return true
+	}
+	if l.allow(l.up, peer, len(p.Buffer())) {
+		return true
+	}
+	metricDropFromPeer.Add(1)
+	return false
+}
+
+// AllowToPeer reports whether p, a packet about to be sent to a peer, is
+// within the peer's limit for forwarded traffic.
+func (l *Limiter) AllowToPeer(p *packet.Parsed) bool {
+	peer, other := p.Dst.Addr(), p.Src.Addr()
+	if !limited(peer, other) {
+		return true
+	}
+	if l.allow(l.down, peer, len(p.Buffer())) {
+		return true
+	}
+	metricDropToPeer.Add(1)
+	return false
+}
+
+// limited reports whether traffic between peer and other is forwarded
+// traffic that's limited.
+func limited(peer, other netip.Addr) bool {
+	return tsaddr.IsTailscaleIP(peer) && other.IsValid() && !tsaddr.IsTailscaleIP(other)
+}
+
+func (l *Limiter) allow(m map[netip.Addr]*rate.Limiter, peer netip.Addr, n int) bool {
+	l.mu.Lock()
+	lim, ok := m[peer]
+	if !ok {
+		lim = rate.NewLimiter(l.limit, l.burst)
+		m[peer] = lim
+	}
+	l.mu.Unlock()
+	return lim.AllowN(n)
+}
+
+var (
+	metricDropFromPeer = clientmetric.NewCounter("exitlimit_drop_from_peer")
+	metricDropToPeer   = clientmetric.NewCounter("exitlimit_drop_to_peer")
+)
@@ -0,0 +1,57 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package exitlimit
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func udpPacket(src, dst string, size int) *packet.Parsed {
+	h := packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			Src: netip.MustParseAddr(src),
+			Dst: netip.MustParseAddr(dst),
+		},
+		SrcPort: 1234,
+		DstPort: 443,
+	}
+	h.IPProto = ipproto.UDP
+	b := packet.Generate(h, make([]byte, size-h.Len()))
+	p := new(packet.Parsed)
+	p.Decode(b)
+	return p
+}
+
+func TestLimiter(t *testing.T) {
+	l := New(1000) // burst minBurst
+	const pktSize = 1000
+	n := 0
+	for l.AllowFromPeer(udpPacket("100.64.0.1", "8.8.8.8", pktSize)) {
+		n++
+		if n > 1000 {
+			t.Fatal("not limited")
+		}
+	}
+	if want := minBurst / pktSize; n != want {
+		t.Errorf("allowed %d packets from peer; want %d", n, want)
+	}
+
+	// Other peers, the other direction and tailnet traffic have their
+	// own limits or none.
+	if !l.AllowFromPeer(udpPacket("100.64.0.2", "8.8.8.8", pktSize)) {
+		t.Error("other peer limited")
+	}
+	if !l.AllowToPeer(udpPacket("8.8.8.8", "100.64.0.1", pktSize)) {
+		t.Error("replies to peer limited")
+	}
+	for i := 0; i < 100; i++ {
+		if !l.AllowFromPeer(udpPacket("100.64.0.1", "100.64.0.9", pktSize)) {
+			t.Fatal("tailnet traffic limited")
+		}
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netcheck
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"tailscale.com/net/stun"
+	"tailscale.com/types/opt"
+	"tailscale.com/util/mak"
+)
+
+// ParseProbeTargets splits the comma-separated list s into probe targets,
+// for use as a Client's ExtraSTUNServers or ExtraHTTPProbes.
+func ParseProbeTargets(s string) []string {
+	var ret []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+func (c *Client) extraSTUNServers() []string {
+	if c.ExtraSTUNServers != nil {
+		return c.ExtraSTUNServers
+	}
+	return ParseProbeTargets(extraSTUNServers())
+}
+
+func (c *Client) extraHTTPProbes() []string {
+	if c.ExtraHTTPProbes != nil {
+		return c.ExtraHTTPProbes
+	}
+	return ParseProbeTargets(extraHTTPProbes())
+}
+
+// probeSTUNServer sends STUN requests to the user-specified server, a
+// "host:port" or a host using the default STUN port, over IPv4 and IPv6 as
+// available, retransmitting once.
+func (rs *reportState) probeSTUNServer(ctx context.Context, server string) {
+	c := rs.c
+	host, portStr, err := net.SplitHostPort(server)
+	if err != nil {
+		host, portStr = server, "3478"
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		c.logf("netcheck: bad STUN server %q: %v", server, err)
+		return
+	}
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else if ips, err = net.DefaultResolver.LookupNetIP(ctx, "ip", host); err != nil {
+		c.logf("[v1] netcheck: resolving STUN server %q: %v", server, err)
+		return
+	}
+
+	var sent4, sent6 bool
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		pc := rs.pc4
+		if ip.Is6() {
+			if sent6 || rs.pc6 == nil {
+				continue
+			}
+			sent6 = true
+			pc = rs.pc6
+		} else {
+			if sent4 {
+				continue
+			}
+			sent4 = true
+		}
+		dst := netip.AddrPortFrom(ip, uint16(port))
+		go rs.sendSTUNServerRequest(ctx, pc, server, dst)
+	}
+}
+
+func (rs *reportState) sendSTUNServerRequest(ctx context.Context, pc STUNConn, server string, dst netip.AddrPort) {
+	txID := stun.NewTxID()
+	req := stun.Request(txID)
+	done := make(chan struct{})
+	sent := time.Now()
+
+	rs.mu.Lock()
+	rs.inFlight[txID] = func(ipp netip.AddrPort) {
+		d := time.Since(sent)
+		rs.mu.Lock()
+		defer rs.mu.Unlock()
+		rs.report.UDP = true
+		if prev, ok := rs.report.STUNServerLatency[server]; !ok || d < prev {
+			mak.Set(&rs.report.STUNServerLatency, server, d)
+		}
+		rs.addGlobalAddrLocked(ipp)
+		close(done)
+	}
+	rs.mu.Unlock()
+
+	for i := 0; i < 2; i++ {
+		if _, err := pc.WriteToUDPAddrPort(req, dst); err != nil {
+			rs.c.vlogf("STUN server %v: %v", dst, err)
+		}
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-time.After(defaultActiveRetransmitTime):
+		}
+	}
+}
+
+// runHTTPProbes measures the latency of each of the Client's
+// ExtraHTTPProbes. It reports whether their responses indicate a captive
+// portal, or the empty value if none of them answered.
+func (rs *reportState) runHTTPProbes(ctx context.Context) (portal opt.Bool) {
+	urls := rs.c.extraHTTPProbes()
+	if len(urls) == 0 {
+		return ""
+	}
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex // guards portal
+	)
+	for _, u := range urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			d, isPortal, err := rs.c.measureHTTPProbe(ctx, u)
+			if err != nil {
+				rs.c.logf("[v1] netcheck: HTTP probe %q: %v", u, err)
+				return
+			}
+			mu.Lock()
+			if isPortal || portal == "" {
+				portal.Set(isPortal)
+			}
+			mu.Unlock()
+			if !isPortal {
+				rs.mu.Lock()
+				mak.Set(&rs.report.HTTPProbeLatency, u, d)
+				rs.mu.Unlock()
+			}
+		}(u)
+	}
+	wg.Wait()
+	return portal
+}
+
+// measureHTTPProbe returns how long the GET of url took to respond, and
+// whether the response looks like it came from a captive portal
+// rather than url's server.
+func (c *Client) measureHTTPProbe(ctx context.Context, url string) (d time.Duration, portal bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, overallProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	t0 := c.timeNow()
+	res, err := noRedirectClient.Do(req)
+	if err != nil {
+		return 0, false, err
+	}
+	d = c.timeNow().Sub(t0)
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+	res.Body.Close()
+
+	c.logf("[v2] HTTP probe url=%q status_code=%d latency=%v", url, res.StatusCode, d)
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode <= 299:
+		return d, false, nil
+	case res.StatusCode >= 300 && res.StatusCode <= 399,
+		res.StatusCode == http.StatusNetworkAuthenticationRequired:
+		return d, true, nil
+	}
+	return 0, false, errors.New(res.Status)
+}
@@ -45,6 +45,11 @@
 // Debugging and experimentation tweakables.
 var (
 	debugNetcheck = envknob.RegisterBool("TS_DEBUG_NETCHECK")
+
+	// extraSTUNServers and extraHTTPProbes are the default
+	// comma-separated Client.ExtraSTUNServers and Client.ExtraHTTPProbes.
+	extraSTUNServers = envknob.RegisterString("TS_NETCHECK_STUN_SERVERS")
+	extraHTTPProbes  = envknob.RegisterString("TS_NETCHECK_HTTP_PROBES")
 )
 
 // The various default timeouts for things.
@@ -118,6 +123,15 @@ type Report struct {
 	// intercepting HTTP traffic.
 	CaptivePortal opt.Bool
 
+	// STUNServerLatency is the round trip time to each of the Client's
+	// ExtraSTUNServers that replied, keyed by the server as configured.
+	STUNServerLatency map[string]time.Duration `json:",omitempty"`
+
+	// HTTPProbeLatency is the time to the response headers of each of
+	// the Client's ExtraHTTPProbes that answered with a 2xx status,
+	// keyed by URL.
+	HTTPProbeLatency map[string]time.Duration `json:",omitempty"`
+
 	// TODO: update Clone when adding new fields
 }
 
@@ -134,14 +148,16 @@ func (r *Report) Clone() *Report {
 	r2.RegionLatency = cloneDurationMap(r2.RegionLatency)
 	r2.RegionV4Latency = cloneDurationMap(r2.RegionV4Latency)
 	r2.RegionV6Latency = cloneDurationMap(r2.RegionV6Latency)
+	r2.STUNServerLatency = cloneDurationMap(r2.STUNServerLatency)
+	r2.HTTPProbeLatency = cloneDurationMap(r2.HTTPProbeLatency)
 	return &r2
 }
 
-func cloneDurationMap(m map[int]time.Duration) map[int]time.Duration {
+func cloneDurationMap[K comparable](m map[K]time.Duration) map[K]time.Duration {
 	if m == nil {
 		return nil
 	}
-	m2 := make(map[int]time.Duration, len(m))
+	m2 := make(map[K]time.Duration, len(m))
 	for k, v := range m {
 		m2[k] = v
 	}
@@ -181,6 +197,20 @@ type Client struct {
 	// If nil, portmap discovery is not done.
 	PortMapper *portmapper.Client // lazily initialized on first use
 
+	// ExtraSTUNServers optionally specifies "host:port" STUN servers to
+	// probe in addition to the DERP map's nodes, for networks that block
+	// those. Their replies count toward the report's UDP, IPv4, IPv6 and
+	// mapping results, but not toward DERP region selection.
+	// If nil, the comma-separated TS_NETCHECK_STUN_SERVERS is used.
+	ExtraSTUNServers []string
+
+	// ExtraHTTPProbes optionally specifies URLs whose HTTP latency is
+	// measured in each report. Each should answer a GET with a 2xx
+	// status, without redirecting; a redirect or a "511 Network
+	// Authentication Required" response is taken as a captive portal.
+	// If nil, the comma-separated TS_NETCHECK_HTTP_PROBES is used.
+	ExtraHTTPProbes []string
+
 	// For tests
 	testEnoughRegions      int
 	testCaptivePortalDelay time.Duration
@@ -667,11 +697,6 @@ func (rs *reportState) stopTimers() {
 // is non-zero (for all but HTTPS replies), it's recorded as our UDP
 // IP:port.
 func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort, d time.Duration) {
-	var ipPortStr string
-	if ipp != (netip.AddrPort{}) {
-		ipPortStr = net.JoinHostPort(ipp.Addr().String(), fmt.Sprint(ipp.Port()))
-	}
-
 	rs.mu.Lock()
 	defer rs.mu.Unlock()
 	ret := rs.report
@@ -696,12 +721,27 @@ func (rs *reportState) addNodeLatency(node *tailcfg.DERPNode, ipp netip.AddrPort
 	switch {
 	case ipp.Addr().Is6():
 		updateLatency(ret.RegionV6Latency, node.RegionID, d)
+	case ipp.Addr().Is4():
+		updateLatency(ret.RegionV4Latency, node.RegionID, d)
+	}
+	rs.addGlobalAddrLocked(ipp)
+}
+
+// addGlobalAddrLocked notes that a STUN server saw us as ipp, if non-zero.
+// rs.mu must be held.
+func (rs *reportState) addGlobalAddrLocked(ipp netip.AddrPort) {
+	var ipPortStr string
+	if ipp != (netip.AddrPort{}) {
+		ipPortStr = net.JoinHostPort(ipp.Addr().String(), fmt.Sprint(ipp.Port()))
+	}
+	ret := rs.report
+	switch {
+	case ipp.Addr().Is6():
 		ret.IPv6 = true
 		ret.GlobalV6 = ipPortStr
 		// TODO: track MappingVariesByDestIP for IPv6
 		// too? Would be sad if so, but who knows.
 	case ipp.Addr().Is4():
-		updateLatency(ret.RegionV4Latency, node.RegionID, d)
 		ret.IPv4 = true
 		if rs.gotEP4 == "" {
 			rs.gotEP4 = ipPortStr
@@ -961,6 +1001,12 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 		}
 	}
 
+	for _, server := range c.extraSTUNServers() {
+		go rs.probeSTUNServer(ctx, server)
+	}
+	httpProbesDone := make(chan opt.Bool, 1)
+	go func() { httpProbesDone <- rs.runHTTPProbes(ctx) }()
+
 	wg := syncs.NewWaitGroupChan()
 	wg.Add(len(plan))
 	for _, probeSet := range plan {
@@ -1063,6 +1109,16 @@ func (c *Client) GetReport(ctx context.Context, dm *tailcfg.DERPMap) (_ *Report,
 	// Wait for captive portal check before finishing the report.
 	<-captivePortalDone
 
+	// The HTTP probes can see a captive portal that the DERP check
+	// failed to reach, or missed.
+	if portal, ok := (<-httpProbesDone).Get(); ok {
+		rs.mu.Lock()
+		if portal || rs.report.CaptivePortal == "" {
+			rs.report.CaptivePortal.Set(portal)
+		}
+		rs.mu.Unlock()
+	}
+
 	return c.finishAndStoreReport(rs, dm), nil
 }
 
@@ -1083,10 +1139,21 @@ func (c *Client) finishAndStoreReport(rs *reportState, dm *tailcfg.DERPMap) *Rep
 		return http.ErrUseLastResponse
 	},
 
+	// Dial outside of the Tailscale tunnel, as the STUN probes do, so
+	// that a captive portal is seen even while an exit node is in use.
+	Transport: outsideTunnelTransport(),
+
 	// Remaining fields are the same as the default client.
-	Transport: http.DefaultClient.Transport,
-	Jar:       http.DefaultClient.Jar,
-	Timeout:   http.DefaultClient.Timeout,
+	Jar:     http.DefaultClient.Jar,
+	Timeout: http.DefaultClient.Timeout,
+}
+
+// outsideTunnelTransport returns a clone of http.DefaultTransport that dials
+// with a netns dialer.
+func outsideTunnelTransport() *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	tr.DialContext = netns.NewDialer(logger.Discard).DialContext
+	return tr
 }
 
 // checkCaptivePortal reports whether or not we think the system is behind a
@@ -1375,6 +1442,12 @@ func (c *Client) logConciseReport(r *Report, dm *tailcfg.DERPMap) {
 		if r.CaptivePortal != "" {
 			fmt.Fprintf(w, " captiveportal=%v", r.CaptivePortal)
 		}
+		if len(r.STUNServerLatency) > 0 {
+			fmt.Fprintf(w, " stunservers=%v", len(r.STUNServerLatency))
+		}
+		if len(r.HTTPProbeLatency) > 0 {
+			fmt.Fprintf(w, " httpprobes=%v", len(r.HTTPProbeLatency))
+		}
 		fmt.Fprintf(w, " derp=%v", r.PreferredDERP)
 		if r.PreferredDERP != 0 {
 			fmt.Fprintf(w, " derpdist=")
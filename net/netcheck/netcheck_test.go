@@ -9,6 +9,7 @@
 	"fmt"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"net/netip"
 	"reflect"
 	"sort"
@@ -23,6 +24,7 @@
 	"tailscale.com/net/stun/stuntest"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
+	"tailscale.com/types/opt"
 )
 
 func TestHairpinSTUN(t *testing.T) {
@@ -717,3 +719,66 @@ func TestNoCaptivePortalWhenUDP(t *testing.T) {
 func (f RoundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
 	return f(req), nil
 }
+
+func TestExtraProbes(t *testing.T) {
+	derpAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+	stunAddr, cleanup := stuntest.Serve(t)
+	defer cleanup()
+
+	okSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer okSrv.Close()
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://portal.example/login", http.StatusFound)
+	}))
+	defer portal.Close()
+
+	for _, tt := range []struct {
+		name       string
+		httpProbes []string
+		wantPortal opt.Bool
+	}{
+		{"ok", []string{okSrv.URL}, "false"},
+		{"portal", []string{okSrv.URL, portal.URL}, "true"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{
+				Logf:              t.Logf,
+				UDPBindAddr:       "127.0.0.1:0",
+				testEnoughRegions: 1,
+				ExtraSTUNServers:  []string{stunAddr.String()},
+				ExtraHTTPProbes:   tt.httpProbes,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			r, err := c.GetReport(ctx, stuntest.DERPMapOf(derpAddr.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := r.STUNServerLatency[stunAddr.String()]; !ok {
+				t.Errorf("STUNServerLatency = %v; want %v", r.STUNServerLatency, stunAddr)
+			}
+			if _, ok := r.HTTPProbeLatency[okSrv.URL]; !ok || len(r.HTTPProbeLatency) != 1 {
+				t.Errorf("HTTPProbeLatency = %v; want just %v", r.HTTPProbeLatency, okSrv.URL)
+			}
+			if r.CaptivePortal != tt.wantPortal {
+				t.Errorf("CaptivePortal = %q; want %q", r.CaptivePortal, tt.wantPortal)
+			}
+			if r.MappingVariesByDestIP != "false" {
+				t.Errorf("MappingVariesByDestIP = %q; want false, from the DERP and extra STUN servers", r.MappingVariesByDestIP)
+			}
+		})
+	}
+}
+
+func TestParseProbeTargets(t *testing.T) {
+	got := ParseProbeTargets(" stun.example.com:3478,,[2001:db8::1]:3478 , ")
+	want := []string{"stun.example.com:3478", "[2001:db8::1]:3478"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q; want %q", got, want)
+	}
+	if got := ParseProbeTargets(""); got != nil {
+		t.Errorf("ParseProbeTargets(\"\") = %q; want nil", got)
+	}
+}
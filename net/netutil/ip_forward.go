@@ -144,6 +144,70 @@ func CheckIPForwarding(routes []netip.Prefix, state *interfaces.State) (warn, er
 	return nil, nil
 }
 
+// DisabledIPForwardingSysctls returns the system-wide sysctls, such as
+// "net.ipv4.ip_forward", that are disabled but needed to forward routes.
+// It returns nil on platforms other than Linux. The state param can be
+// nil, in which case interfaces.GetState is used.
+func DisabledIPForwardingSysctls(routes []netip.Prefix, state *interfaces.State) ([]string, error) {
+	if runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	if state == nil {
+		var err error
+		state, err = interfaces.GetState()
+		if err != nil {
+			return nil, err
+		}
+	}
+	wantV4, wantV6 := protocolsRequiredForForwarding(routes, state)
+	var ret []string
+	for _, p := range []struct {
+		want  bool
+		proto protocol
+	}{{wantV4, ipv4}, {wantV6, ipv6}} {
+		if !p.want {
+			continue
+		}
+		on, err := ipForwardingEnabledLinux(p.proto, "")
+		if err != nil {
+			return nil, err
+		}
+		if !on {
+			ret = append(ret, ipForwardSysctlKey(dotFormat, p.proto, ""))
+		}
+	}
+	return ret, nil
+}
+
+// EnableIPForwardingSysctls enables the system-wide IP forwarding sysctls
+// keys, as returned by DisabledIPForwardingSysctls. The change lasts until
+// reboot; to make it permanent, the keys must also be set in
+// /etc/sysctl.conf or /etc/sysctl.d.
+func EnableIPForwardingSysctls(keys []string) error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("enabling IP forwarding is not supported on %v", runtime.GOOS)
+	}
+	for _, k := range keys {
+		var p protocol
+		switch k {
+		case ipForwardSysctlKey(dotFormat, ipv4, ""):
+			p = ipv4
+		case ipForwardSysctlKey(dotFormat, ipv6, ""):
+			p = ipv6
+		default:
+			return fmt.Errorf("not an IP forwarding sysctl: %q", k)
+		}
+		path := filepath.Join(procSys, ipForwardSysctlKey(slashFormat, p, ""))
+		if err := os.WriteFile(path, []byte("1\n"), 0644); err != nil {
+			return fmt.Errorf("enabling %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+// procSys is where procfs sysctls are, changed by tests.
+var procSys = "/proc/sys"
+
 // ipForwardSysctlKey returns the sysctl key for the given protocol and iface.
 // When the dotFormat parameter is true the output is formatted as `net.ipv4.ip_forward`,
 // else it is `net/ipv4/ip_forward`
@@ -197,13 +261,13 @@ func ipForwardSysctlKey(format sysctlFormat, p protocol, iface string) string {
 // sysctl (which on Linux just reads from /proc/sys anyway).
 func ipForwardingEnabledLinux(p protocol, iface string) (bool, error) {
 	k := ipForwardSysctlKey(slashFormat, p, iface)
-	bs, err := os.ReadFile(filepath.Join("/proc/sys", k))
+	bs, err := os.ReadFile(filepath.Join(procSys, k))
 	if err != nil {
 		if os.IsNotExist(err) {
 			// If IPv6 is disabled, sysctl keys like "net.ipv6.conf.all.forwarding" just don't
 			// exist on disk. But first diagnose whether procfs is even mounted before assuming
 			// absence means false.
-			if fi, err := os.Stat("/proc/sys"); err != nil {
+			if fi, err := os.Stat(procSys); err != nil {
 				return false, fmt.Errorf("failed to check sysctl %v; no procfs? %w", k, err)
 			} else if !fi.IsDir() {
 				return false, fmt.Errorf("failed to check sysctl %v; /proc/sys isn't a directory, is %v", k, fi.Mode())
@@ -6,8 +6,14 @@
 import (
 	"io"
 	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
+
+	"tailscale.com/net/interfaces"
 )
 
 type conn struct {
@@ -65,3 +71,44 @@ func TestIPForwardingEnabledLinux(t *testing.T) {
 		t.Errorf("got true; want false")
 	}
 }
+
+func TestIPForwardingSysctls(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skipf("skipping on %s", runtime.GOOS)
+	}
+	old := procSys
+	procSys = t.TempDir()
+	t.Cleanup(func() { procSys = old })
+	for k, v := range map[string]string{
+		"net/ipv4/ip_forward":          "0\n",
+		"net/ipv6/conf/all/forwarding": "1\n",
+	} {
+		path := filepath.Join(procSys, k)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(v), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	routes := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	state := &interfaces.State{}
+	got, err := DisabledIPForwardingSysctls(routes, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"net.ipv4.ip_forward"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("disabled = %q; want %q", got, want)
+	}
+	if err := EnableIPForwardingSysctls(got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := DisabledIPForwardingSysctls(routes, state); err != nil || got != nil {
+		t.Errorf("after enabling, disabled = %q, %v; want none", got, err)
+	}
+
+	if err := EnableIPForwardingSysctls([]string{"kernel.panic"}); err == nil {
+		t.Error("enabled a non-forwarding sysctl")
+	}
+}
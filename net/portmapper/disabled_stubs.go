@@ -14,6 +14,8 @@
 
 type uPnPDiscoResponse struct{}
 
+func (c *Client) UPnPServer() string { return "" }
+
 func parseUPnPDiscoResponse([]byte) (uPnPDiscoResponse, error) {
 	return uPnPDiscoResponse{}, nil
 }
@@ -109,6 +109,9 @@ type mapping interface {
 	RenewAfter() time.Time
 	// External indicates what port the mapping can be reached from on the outside.
 	External() netip.AddrPort
+	// MappingType returns the protocol of the mapping: "pmp", "pcp" or
+	// "upnp".
+	MappingType() string
 }
 
 // HaveMapping reports whether we have a current valid mapping.
@@ -118,6 +121,30 @@ func (c *Client) HaveMapping() bool {
 	return c.mapping != nil && c.mapping.GoodUntil().After(time.Now())
 }
 
+// MappingInfo describes a port mapping.
+type MappingInfo struct {
+	Type       string         // "pmp", "pcp" or "upnp"
+	External   netip.AddrPort // where the mapping is reachable from outside
+	GoodUntil  time.Time      // when the mapping's lease ends
+	RenewAfter time.Time      // when the Client renews the mapping
+}
+
+// CurrentMapping returns the current valid mapping, if any.
+func (c *Client) CurrentMapping() (_ MappingInfo, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.mapping
+	if m == nil || !m.GoodUntil().After(time.Now()) {
+		return MappingInfo{}, false
+	}
+	return MappingInfo{
+		Type:       m.MappingType(),
+		External:   m.External(),
+		GoodUntil:  m.GoodUntil(),
+		RenewAfter: m.RenewAfter(),
+	}, true
+}
+
 // pmpMapping is an already-created PMP mapping.
 //
 // All fields are immutable once created.
@@ -139,6 +166,7 @@ func (m *pmpMapping) externalValid() bool {
 func (p *pmpMapping) GoodUntil() time.Time     { return p.goodUntil }
 func (p *pmpMapping) RenewAfter() time.Time    { return p.renewAfter }
 func (p *pmpMapping) External() netip.AddrPort { return p.external }
+func (p *pmpMapping) MappingType() string      { return "pmp" }
 
 // Release does a best effort fire-and-forget release of the PMP mapping m.
 func (m *pmpMapping) Release(ctx context.Context) {
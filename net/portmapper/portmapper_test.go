@@ -121,4 +121,7 @@ func TestPCPIntegration(t *testing.T) {
 	if c.mapping == nil {
 		t.Errorf("got nil mapping after successful createOrGetMapping")
 	}
+	if m, ok := c.CurrentMapping(); !ok || m.Type != "pcp" || m.External != external {
+		t.Errorf("CurrentMapping = %+v, %v; want a pcp mapping of %v", m, ok, external)
+	}
 }
@@ -57,6 +57,7 @@ type upnpMapping struct {
 func (u *upnpMapping) GoodUntil() time.Time     { return u.goodUntil }
 func (u *upnpMapping) RenewAfter() time.Time    { return u.renewAfter }
 func (u *upnpMapping) External() netip.AddrPort { return u.external }
+func (u *upnpMapping) MappingType() string      { return "upnp" }
 func (u *upnpMapping) Release(ctx context.Context) {
 	u.client.DeletePortMapping(ctx, "", u.external.Port(), upnpProtocolUDP)
 }
@@ -337,6 +338,15 @@ type uPnPDiscoResponse struct {
 	USN string
 }
 
+// UPnPServer returns the Server header of the last UPnP discovery
+// response, describing the UPnP implementation of the gateway, or the
+// empty string if there's been none.
+func (c *Client) UPnPServer() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.uPnPMeta.Server
+}
+
 // parseUPnPDiscoResponse parses a UPnP HTTP-over-UDP discovery response.
 func parseUPnPDiscoResponse(body []byte) (uPnPDiscoResponse, error) {
 	var r uPnPDiscoResponse
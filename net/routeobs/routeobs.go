@@ -0,0 +1,112 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package routeobs observes the non-Tailscale addresses that traffic
+// flowing through a TUN device is sent to and from, so that a subnet
+// router can suggest which subnets are worth advertising.
+package routeobs
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/net/tsaddr"
+)
+
+// maxPrefixes is the maximum number of distinct prefixes an Observer
+// tracks. Traffic to and from new prefixes is ignored once it's reached.
+const maxPrefixes = 1024
+
+// Bits of the prefixes that observed addresses are grouped into.
+const (
+	bitsIPv4 = 24
+	bitsIPv6 = 64
+)
+
+// Counts are the traffic counters for an observed prefix.
+type Counts struct {
+	Packets  uint64
+	Bytes    uint64
+	LastSeen time.Time
+}
+
+// Observer counts the traffic through a TUN device to and from private
+// non-Tailscale addresses, grouped into /24 (IPv4) and /64 (IPv6) prefixes.
+// All methods are safe for concurrent use.
+// The zero value is ready for use.
+type Observer struct {
+	mu       sync.Mutex
+	prefixes map[netip.Prefix]Counts
+}
+
+// ObserveTx notes an IP packet read from the TUN device, destined for the
+// tailnet. Its source address is observed.
+func (o *Observer) ObserveTx(b []byte) {
+	var p packet.Parsed
+	p.Decode(b)
+	o.observe(p.Src.Addr(), len(b))
+}
+
+// ObserveRx notes an IP packet written to the TUN device, received from
+// the tailnet. Its destination address is observed.
+func (o *Observer) ObserveRx(b []byte) {
+	var p packet.Parsed
+	p.Decode(b)
+	o.observe(p.Dst.Addr(), len(b))
+}
+
+func (o *Observer) observe(ip netip.Addr, n int) {
+	pfx, ok := observedPrefix(ip)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	cnts, found := o.prefixes[pfx]
+	if !found {
+		if o.prefixes == nil {
+			o.prefixes = make(map[netip.Prefix]Counts)
+		}
+		if len(o.prefixes) >= maxPrefixes {
+			return
+		}
+	}
+	cnts.Packets++
+	cnts.Bytes += uint64(n)
+	cnts.LastSeen = now
+	o.prefixes[pfx] = cnts
+}
+
+// observedPrefix returns the prefix that traffic to or from ip is
+// counted under, and whether ip is of interest at all. Only private
+// addresses (RFC 1918 and fc00::/7) other than Tailscale's own are, as
+// anything else is typically Internet traffic through an exit node.
+func observedPrefix(ip netip.Addr) (netip.Prefix, bool) {
+	ip = ip.Unmap()
+	if !ip.IsValid() || !ip.IsPrivate() || tsaddr.IsTailscaleIP(ip) {
+		return netip.Prefix{}, false
+	}
+	bits := bitsIPv4
+	if ip.Is6() {
+		bits = bitsIPv6
+	}
+	pfx, err := ip.Prefix(bits)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	return pfx, true
+}
+
+// Snapshot returns a copy of the counters of every observed prefix.
+func (o *Observer) Snapshot() map[netip.Prefix]Counts {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	m := make(map[netip.Prefix]Counts, len(o.prefixes))
+	for pfx, cnts := range o.prefixes {
+		m[pfx] = cnts
+	}
+	return m
+}
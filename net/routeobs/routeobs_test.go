@@ -0,0 +1,83 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package routeobs
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/net/packet"
+	"tailscale.com/types/ipproto"
+)
+
+func udp4(src, dst string) []byte {
+	return packet.Generate(packet.UDP4Header{
+		IP4Header: packet.IP4Header{
+			IPProto: ipproto.UDP,
+			Src:     netip.MustParseAddr(src),
+			Dst:     netip.MustParseAddr(dst),
+		},
+		SrcPort: 1234,
+		DstPort: 53,
+	}, []byte("hello"))
+}
+
+func TestObserver(t *testing.T) {
+	var o Observer
+	o.ObserveRx(udp4("100.101.102.103", "192.168.1.10"))
+	o.ObserveRx(udp4("100.101.102.103", "192.168.1.20"))
+	o.ObserveTx(udp4("10.1.2.3", "100.101.102.103"))
+	o.ObserveTx(udp4("100.64.0.1", "100.101.102.103")) // Tailscale IP
+	o.ObserveRx(udp4("100.101.102.103", "8.8.8.8"))    // public IP, via an exit node
+	o.ObserveRx(udp4("100.101.102.103", "10.1.2.3"))
+
+	got := o.Snapshot()
+	want := map[netip.Prefix]uint64{
+		netip.MustParsePrefix("192.168.1.0/24"): 2,
+		netip.MustParsePrefix("10.1.2.0/24"):    2,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d prefixes %v; want %d", len(got), got, len(want))
+	}
+	for pfx, packets := range want {
+		c, ok := got[pfx]
+		if !ok {
+			t.Errorf("missing %v", pfx)
+			continue
+		}
+		if c.Packets != packets {
+			t.Errorf("%v: Packets = %d; want %d", pfx, c.Packets, packets)
+		}
+		if c.Bytes == 0 || c.LastSeen.IsZero() {
+			t.Errorf("%v: counts not updated: %+v", pfx, c)
+		}
+	}
+}
+
+func TestObservedPrefix(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string // or empty if not observed
+	}{
+		{"192.168.1.10", "192.168.1.0/24"},
+		{"172.16.5.4", "172.16.5.0/24"},
+		{"fd00:1:2:3::4", "fd00:1:2:3::/64"},
+		{"::ffff:10.0.0.1", "10.0.0.0/24"},
+		{"100.64.0.1", ""},
+		{"fd7a:115c:a1e0::1", ""},
+		{"1.2.3.4", ""},
+		{"127.0.0.1", ""},
+		{"fe80::1", ""},
+	}
+	for _, tt := range tests {
+		pfx, ok := observedPrefix(netip.MustParseAddr(tt.ip))
+		got := ""
+		if ok {
+			got = pfx.String()
+		}
+		if got != tt.want {
+			t.Errorf("observedPrefix(%s) = %q; want %q", tt.ip, got, tt.want)
+		}
+	}
+}
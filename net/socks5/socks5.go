@@ -92,6 +92,12 @@ type Server struct {
 	// Username and Password, if set, are the credential clients must provide.
 	Username string
 	Password string
+
+	// Authorize optionally authorizes clients by who they are rather than
+	// by password. It's called with the client's address once the client
+	// has sent its request, and the request is refused with "connection
+	// not allowed" if it returns an error.
+	Authorize func(clientAddr net.Addr) error
 }
 
 func (s *Server) dial(ctx context.Context, network, addr string) (net.Conn, error) {
@@ -185,6 +191,15 @@ func (c *Conn) handleRequest() error {
 	}
 	c.request = req
 
+	if c.srv.Authorize != nil {
+		if err := c.srv.Authorize(c.clientConn.RemoteAddr()); err != nil {
+			res := &response{reply: connectionNotAllowed}
+			buf, _ := res.marshal()
+			c.clientConn.Write(buf)
+			return fmt.Errorf("client %v not authorized: %w", c.clientConn.RemoteAddr(), err)
+		}
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	srv, err := c.srv.dial(
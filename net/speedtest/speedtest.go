@@ -18,6 +18,8 @@
 	increment       = time.Second           // increment to display results for, in seconds
 	minInterval     = 10 * time.Millisecond // minimum interval length for a result to be included
 	DefaultPort     = 20333
+
+	DefaultUDPBitrate = 100_000_000 // default bits per second sent in UDP tests
 )
 
 // config is the initial message sent to the server, that contains information on how to
@@ -26,12 +28,15 @@ type config struct {
 	Version      int           `json:"version"`
 	TestDuration time.Duration `json:"time"`
 	Direction    Direction     `json:"direction"`
+	Protocol     Protocol      `json:"protocol,omitempty"`   // TCP if empty
+	UDPBitrate   int64         `json:"udpBitrate,omitempty"` // bits per second sent in UDP tests
 }
 
 // configResponse is the response to the testConfig message. If the server has an
 // error with the config, the Error variable will hold that error value.
 type configResponse struct {
-	Error string `json:"error,omitempty"`
+	Error   string `json:"error,omitempty"`
+	UDPPort uint16 `json:"udpPort,omitempty"` // port the server receives UDP test packets on
 }
 
 // This represents the Result of a speedtest within a specific interval
@@ -58,6 +63,34 @@ func (r Result) Interval() time.Duration {
 	return r.IntervalEnd.Sub(r.IntervalStart)
 }
 
+// Protocol is the transport protocol a speedtest measures.
+type Protocol string
+
+const (
+	TCP Protocol = "tcp"
+	UDP Protocol = "udp"
+)
+
+// Report is the outcome of a speedtest run with RunClientConn.
+type Report struct {
+	Results []Result
+	UDP     *UDPStats // packet counts of UDP tests; nil for TCP tests
+}
+
+// UDPStats are the packet counts of a UDP speedtest.
+type UDPStats struct {
+	Sent     int // number of packets sent
+	Received int // number of packets received
+}
+
+// Lost returns the number of packets sent but not received.
+func (s UDPStats) Lost() int {
+	if s.Received > s.Sent {
+		return 0
+	}
+	return s.Sent - s.Received
+}
+
 type Direction int
 
 const (
@@ -18,24 +18,75 @@ func RunClient(direction Direction, duration time.Duration, host string) ([]Resu
 	if err != nil {
 		return nil, err
 	}
+	rep, err := RunClientConn(conn, Options{Direction: direction, Duration: duration})
+	if err != nil {
+		return nil, err
+	}
+	return rep.Results, nil
+}
 
-	conf := config{TestDuration: duration, Version: version, Direction: direction}
+// Options are the parameters of a speedtest run with RunClientConn.
+type Options struct {
+	Direction Direction
+	Duration  time.Duration
+	Protocol  Protocol // TCP if empty
+
+	// UDPBitrate is the number of bits per second sent in UDP tests. If
+	// zero, DefaultUDPBitrate is used.
+	UDPBitrate int64
+
+	// DialUDP, required for UDP tests, dials the server's UDP port
+	// the test packets are exchanged with.
+	DialUDP func(port uint16) (net.Conn, error)
+}
 
+// RunClientConn runs a speedtest with the server connected with conn,
+// and closes conn.
+func RunClientConn(conn net.Conn, opts Options) (*Report, error) {
 	defer conn.Close()
+	conf := config{TestDuration: opts.Duration, Version: version, Direction: opts.Direction}
+	if opts.Protocol == UDP {
+		if opts.DialUDP == nil {
+			return nil, errors.New("UDP tests require DialUDP")
+		}
+		conf.Protocol = UDP
+		conf.UDPBitrate = opts.UDPBitrate
+		if conf.UDPBitrate == 0 {
+			conf.UDPBitrate = DefaultUDPBitrate
+		}
+	}
+
 	encoder := json.NewEncoder(conn)
 
-	if err = encoder.Encode(conf); err != nil {
+	if err := encoder.Encode(conf); err != nil {
 		return nil, err
 	}
 
 	var response configResponse
 	decoder := json.NewDecoder(conn)
-	if err = decoder.Decode(&response); err != nil {
+	if err := decoder.Decode(&response); err != nil {
 		return nil, err
 	}
 	if response.Error != "" {
 		return nil, errors.New(response.Error)
 	}
 
-	return doTest(conn, conf)
+	if conf.Protocol == UDP {
+		// Servers that predate UDP tests run a TCP test instead.
+		if response.UDPPort == 0 {
+			return nil, errors.New("server does not support UDP tests")
+		}
+		uc, err := opts.DialUDP(response.UDPPort)
+		if err != nil {
+			return nil, err
+		}
+		defer uc.Close()
+		return runUDPClient(decoder, encoder, conf, uc)
+	}
+
+	results, err := doTest(conn, conf)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{Results: results}, nil
 }
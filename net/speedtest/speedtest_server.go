@@ -39,6 +39,14 @@ func Serve(l net.Listener) error {
 // errors to the client with a configResponse. After the exchange, it will start
 // the speed test.
 func handleConnection(conn net.Conn) error {
+	return ServeConn(conn, nil)
+}
+
+// ServeConn runs the server side of the speedtest requested by the client
+// connected with conn, and closes conn. UDP tests are only supported if
+// listenUDP is non-nil; it's called to open the UDP socket the client's
+// test packets are exchanged with.
+func ServeConn(conn net.Conn, listenUDP func() (net.PacketConn, error)) error {
 	defer conn.Close()
 	var conf config
 
@@ -61,6 +69,10 @@ func handleConnection(conn net.Conn) error {
 		return err
 	}
 
+	if conf.Protocol == UDP {
+		return serveUDP(conn, decoder, encoder, conf, listenUDP)
+	}
+
 	// Start the test
 	encoder.Encode(configResponse{})
 	_, err = doTest(conn, conf)
@@ -4,7 +4,10 @@
 package speedtest
 
 import (
+	"errors"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -81,3 +84,76 @@ type state struct {
 		t.Error("server error:", err)
 	}
 }
+
+func TestUDP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ServeConn(conn, func() (net.PacketConn, error) {
+				return net.ListenPacket("udp4", "127.0.0.1:0")
+			})
+		}
+	}()
+	dialUDP := func(port uint16) (net.Conn, error) {
+		return net.Dial("udp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(port))))
+	}
+
+	for _, dir := range []Direction{Download, Upload} {
+		t.Run(dir.String(), func(t *testing.T) {
+			conn, err := net.Dial("tcp", l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			rep, err := RunClientConn(conn, Options{
+				Direction:  dir,
+				Duration:   2 * time.Second,
+				Protocol:   UDP,
+				UDPBitrate: 10_000_000,
+				DialUDP:    dialUDP,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rep.UDP == nil || rep.UDP.Sent == 0 || rep.UDP.Received == 0 {
+				t.Fatalf("UDP stats = %+v; want packets sent and received", rep.UDP)
+			}
+			if len(rep.Results) == 0 || !rep.Results[len(rep.Results)-1].Total {
+				t.Fatalf("results = %+v; want a total", rep.Results)
+			}
+			total := rep.Results[len(rep.Results)-1]
+			if total.Bytes != rep.UDP.Received*udpPacketSize {
+				t.Errorf("total bytes = %d; want %d", total.Bytes, rep.UDP.Received*udpPacketSize)
+			}
+			t.Logf("%s: %.2f Mbit/s, %d/%d packets lost", dir, total.MBitsPerSecond(), rep.UDP.Lost(), rep.UDP.Sent)
+		})
+	}
+}
+
+func TestUDPUnsupported(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go Serve(l)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = RunClientConn(conn, Options{
+		Duration: time.Second,
+		Protocol: UDP,
+		DialUDP:  func(uint16) (net.Conn, error) { return nil, errors.New("unexpected dial") },
+	})
+	if err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Errorf("err = %v; want UDP tests not supported", err)
+	}
+}
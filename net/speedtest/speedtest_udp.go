@@ -0,0 +1,271 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package speedtest
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	udpPacketSize = 1200                   // size of the UDP test packets; fits in the Tailscale MTU
+	udpHello      = "tailscale-speedtest"  // sent to the server to start a UDP download test
+	udpTimeout    = 10 * time.Second       // how long past the test duration to wait for the other side
+	udpLinger     = 500 * time.Millisecond // how long the receiver waits for straggling packets
+)
+
+// udpDone is sent over the TCP connection by the sender of a UDP test
+// after it sent its last packet.
+type udpDone struct {
+	Sent int `json:"sent"`
+}
+
+// udpReport is sent over the TCP connection by the server of a UDP upload
+// test, with what it received.
+type udpReport struct {
+	Results  []Result `json:"results"`
+	Received int      `json:"received"`
+}
+
+// intervalCounter accumulates the bytes of the packets received into
+// a Result per interval of length increment, and a total.
+type intervalCounter struct {
+	start, last   time.Time // of the intervals; zero until the first packet
+	lastPacket    time.Time
+	intervalBytes int
+	totalBytes    int
+	results       []Result
+}
+
+func (c *intervalCounter) add(n int, now time.Time) {
+	if c.start.IsZero() {
+		c.start, c.last = now, now
+	}
+	c.intervalBytes += n
+	c.totalBytes += n
+	c.lastPacket = now
+	if now.Sub(c.last) >= increment {
+		c.results = append(c.results, Result{Bytes: c.intervalBytes, IntervalStart: c.last, IntervalEnd: now})
+		c.last = now
+		c.intervalBytes = 0
+	}
+}
+
+// finish returns the results, ending with the last segment and the total.
+func (c *intervalCounter) finish() []Result {
+	if c.lastPacket.Sub(c.last) > minInterval {
+		c.results = append(c.results, Result{Bytes: c.intervalBytes, IntervalStart: c.last, IntervalEnd: c.lastPacket})
+	}
+	if c.lastPacket.Sub(c.start) > minInterval {
+		c.results = append(c.results, Result{Bytes: c.totalBytes, IntervalStart: c.start, IntervalEnd: c.lastPacket, Total: true})
+	}
+	return c.results
+}
+
+// sendUDP sends test packets with write for duration, paced to bitrate
+// bits per second. It returns the number of packets sent.
+func sendUDP(write func([]byte) error, duration time.Duration, bitrate int64) (sent int, err error) {
+	buf := make([]byte, udpPacketSize)
+	if _, err := rand.Read(buf); err != nil {
+		return 0, err
+	}
+	perPacket := time.Duration(float64(time.Second) * udpPacketSize * 8 / float64(bitrate))
+	if perPacket <= 0 {
+		perPacket = 1
+	}
+	start := time.Now()
+	for {
+		elapsed := time.Since(start)
+		if elapsed >= duration {
+			return sent, nil
+		}
+		// Send the packets due by now, but no burst of more than 1000
+		// so we don't overshoot the duration.
+		want := int(elapsed/perPacket) + 1
+		for n := 0; sent < want && n < 1000; n++ {
+			binary.BigEndian.PutUint64(buf, uint64(sent))
+			sent++
+			// Failed writes (such as ENOBUFS) count as lost packets.
+			if err := write(buf); errors.Is(err, net.ErrClosed) {
+				return sent, err
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receiveUDP counts the test packets returned by read until it returns an
+// error, such as when its read deadline expires.
+func receiveUDP(read func([]byte) (int, error)) (results []Result, received int) {
+	buf := make([]byte, udpPacketSize+1)
+	var c intervalCounter
+	for {
+		n, err := read(buf)
+		if err != nil {
+			return c.finish(), received
+		}
+		if n != udpPacketSize {
+			continue
+		}
+		received++
+		c.add(n, time.Now())
+	}
+}
+
+// addrIP returns the unmapped IP address of addr, if it has one.
+func addrIP(addr net.Addr) netip.Addr {
+	ap, _ := netip.ParseAddrPort(addr.String())
+	return ap.Addr().Unmap()
+}
+
+// serveUDP runs the server side of a UDP test, configured by conf, over
+// the control connection conn, whose JSON messages are read with dec and
+// written with enc.
+func serveUDP(conn net.Conn, dec *json.Decoder, enc *json.Encoder, conf config, listenUDP func() (net.PacketConn, error)) error {
+	if listenUDP == nil {
+		err := errors.New("UDP tests are not supported by this server")
+		enc.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+	if conf.TestDuration > MaxDuration || conf.UDPBitrate <= 0 {
+		err := fmt.Errorf("invalid UDP test duration %v or bitrate %v", conf.TestDuration, conf.UDPBitrate)
+		enc.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+	pc, err := listenUDP()
+	if err != nil {
+		enc.Encode(configResponse{Error: err.Error()})
+		return err
+	}
+	defer pc.Close()
+	laddr, err := netip.ParseAddrPort(pc.LocalAddr().String())
+	if err != nil {
+		return fmt.Errorf("unexpected UDP address %v", pc.LocalAddr())
+	}
+	// Only the client's packets count; they come from the client's IP.
+	peer := addrIP(conn.RemoteAddr())
+	readFromPeer := func(b []byte) (int, net.Addr, error) {
+		for {
+			n, addr, err := pc.ReadFrom(b)
+			if err != nil || addrIP(addr) == peer {
+				return n, addr, err
+			}
+		}
+	}
+	if err := enc.Encode(configResponse{UDPPort: laddr.Port()}); err != nil {
+		return err
+	}
+	pc.SetReadDeadline(time.Now().Add(conf.TestDuration + udpTimeout))
+
+	if conf.Direction == Download {
+		var rep udpReport
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.Results, rep.Received = receiveUDP(func(b []byte) (int, error) {
+				n, _, err := readFromPeer(b)
+				return n, err
+			})
+		}()
+		var done udpDone
+		err := dec.Decode(&done)
+		pc.SetReadDeadline(time.Now().Add(udpLinger))
+		wg.Wait()
+		if err != nil {
+			return err
+		}
+		return enc.Encode(rep)
+	}
+
+	// The client sends a hello so we learn where to send the packets.
+	var dst net.Addr
+	for dst == nil {
+		b := make([]byte, len(udpHello))
+		n, addr, err := readFromPeer(b)
+		if err != nil {
+			return fmt.Errorf("waiting for UDP hello: %w", err)
+		}
+		if string(b[:n]) == udpHello {
+			dst = addr
+		}
+	}
+	sent, err := sendUDP(func(b []byte) error {
+		_, err := pc.WriteTo(b, dst)
+		return err
+	}, conf.TestDuration, conf.UDPBitrate)
+	if err != nil {
+		return err
+	}
+	return enc.Encode(udpDone{Sent: sent})
+}
+
+// runUDPClient runs the client side of a UDP test, configured by conf,
+// over the control connection whose JSON messages are read with dec and
+// written with enc, sending or receiving the test packets with uc.
+func runUDPClient(dec *json.Decoder, enc *json.Encoder, conf config, uc net.Conn) (*Report, error) {
+	if conf.Direction == Upload {
+		sent, err := sendUDP(func(b []byte) error {
+			_, err := uc.Write(b)
+			return err
+		}, conf.TestDuration, conf.UDPBitrate)
+		if err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(udpDone{Sent: sent}); err != nil {
+			return nil, err
+		}
+		var rep udpReport
+		if err := dec.Decode(&rep); err != nil {
+			return nil, err
+		}
+		return &Report{Results: rep.Results, UDP: &UDPStats{Sent: sent, Received: rep.Received}}, nil
+	}
+
+	uc.SetReadDeadline(time.Now().Add(conf.TestDuration + udpTimeout))
+	started := make(chan struct{})
+	var startOnce sync.Once
+	var results []Result
+	var received int
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results, received = receiveUDP(func(b []byte) (int, error) {
+			n, err := uc.Read(b)
+			startOnce.Do(func() { close(started) })
+			return n, err
+		})
+	}()
+	go func() {
+		// Resend the hello until the first packet arrives, in case
+		// it's lost.
+		defer wg.Done()
+		t := time.NewTicker(100 * time.Millisecond)
+		defer t.Stop()
+		for i := 0; i < 50; i++ {
+			uc.Write([]byte(udpHello))
+			select {
+			case <-started:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	var done udpDone
+	err := dec.Decode(&done)
+	uc.SetReadDeadline(time.Now().Add(udpLinger))
+	wg.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return &Report{Results: results, UDP: &UDPStats{Sent: done.Sent, Received: received}}, nil
+}
@@ -7,6 +7,7 @@
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -28,8 +29,42 @@ func InvalidateCache() {
 var (
 	mu           sync.Mutex
 	noProxyUntil time.Time // if non-zero, time at which ProxyFromEnvironment should check again
+	override     *url.URL  // if non-nil, the proxy ProxyFromEnvironment always returns
 )
 
+// SetOverride sets the proxy that ProxyFromEnvironment returns for all
+// requests, instead of looking one up from the environment. A nil u
+// removes the override.
+//
+// The override is process-wide: it applies to every user of
+// ProxyFromEnvironment in the process, including all the LocalBackends
+// of a process running several tsnet.Servers, and the most recent call
+// wins. It's returned even while setNoProxyUntil has paused the
+// platform-specific lookups, as those pauses are for lookup failures
+// and the override needs no lookup.
+func SetOverride(u *url.URL) {
+	mu.Lock()
+	defer mu.Unlock()
+	override = u
+}
+
+// ParseProxyURL parses s as the URL of an HTTP, HTTPS or SOCKS5 proxy.
+func ParseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q; want http, https, socks5 or socks5h", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, errors.New("proxy URL has no host")
+	}
+	return u, nil
+}
+
 // setNoProxyUntil stops calls to sysProxyEnv (if any) for the provided duration.
 func setNoProxyUntil(d time.Duration) {
 	mu.Lock()
@@ -46,8 +81,16 @@ func setNoProxyUntil(d time.Duration) {
 
 // ProxyFromEnvironment is like the standard library's http.ProxyFromEnvironment
 // but additionally does OS-specific proxy lookups if the environment variables
-// alone don't specify a proxy.
+// alone don't specify a proxy. If SetOverride was called with a non-nil proxy,
+// it returns that proxy instead.
 func ProxyFromEnvironment(req *http.Request) (*url.URL, error) {
+	mu.Lock()
+	ou := override
+	mu.Unlock()
+	if ou != nil {
+		return ou, nil
+	}
+
 	u, err := http.ProxyFromEnvironment(req)
 	if u != nil && err == nil {
 		return u, nil
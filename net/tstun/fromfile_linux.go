@@ -0,0 +1,34 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tstun
+
+import (
+	"os"
+
+	"github.com/tailscale/wireguard-go/tun"
+	"tailscale.com/envknob"
+	"tailscale.com/types/logger"
+)
+
+// NewFromFile returns a tun.Device for the already configured TUN device
+// open as f, such as one handed over by another process, along with the
+// device's name. Unlike New, it leaves the device's link attributes as
+// they are.
+func NewFromFile(logf logger.Logf, f *os.File) (tun.Device, string, error) {
+	tunMTU := DefaultMTU
+	if mtu, ok := envknob.LookupInt("TS_DEBUG_MTU"); ok {
+		tunMTU = mtu
+	}
+	dev, err := tun.CreateTUNFromFile(f, tunMTU)
+	if err != nil {
+		return nil, "", err
+	}
+	name, err := interfaceName(dev)
+	if err != nil {
+		dev.Close()
+		return nil, "", err
+	}
+	logf("using handed over TUN device %s", name)
+	return dev, name, nil
+}
@@ -22,7 +22,9 @@
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"tailscale.com/disco"
 	"tailscale.com/net/connstats"
+	"tailscale.com/net/exitlimit"
 	"tailscale.com/net/packet"
+	"tailscale.com/net/routeobs"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
 	"tailscale.com/tstime/mono"
@@ -175,6 +177,19 @@ type Wrapper struct {
 	// stats maintains per-connection counters.
 	stats atomic.Pointer[connstats.Statistics]
 
+	// routeObs, if non-nil, observes the subnets that traffic flows
+	// to and from.
+	routeObs atomic.Pointer[routeobs.Observer]
+
+	// usageStats, if non-nil, counts the traffic of each connection for
+	// the daily per-peer usage totals. It's separate from stats, which
+	// network flow logging owns.
+	usageStats atomic.Pointer[connstats.Statistics]
+
+	// exitLimit, if non-nil, limits the rate of the traffic forwarded
+	// off the tailnet for each peer.
+	exitLimit atomic.Pointer[exitlimit.Limiter]
+
 	captureHook syncs.AtomicValue[capture.Callback]
 }
 
@@ -524,6 +539,10 @@ func (t *Wrapper) filterOut(p *packet.Parsed) filter.Response {
 		}
 	}
 
+	if l := t.exitLimit.Load(); l != nil && !l.AllowToPeer(p) {
+		return filter.DropSilently
+	}
+
 	return filter.Accept
 }
 
@@ -589,6 +608,12 @@ func (t *Wrapper) Read(buffs [][]byte, sizes []int, offset int) (int, error) {
 		if stats := t.stats.Load(); stats != nil {
 			stats.UpdateTxVirtual(data[res.dataOffset:])
 		}
+		if obs := t.routeObs.Load(); obs != nil {
+			obs.ObserveTx(data[res.dataOffset:])
+		}
+		if u := t.usageStats.Load(); u != nil {
+			u.UpdateTxVirtual(data[res.dataOffset:])
+		}
 		buffsPos++
 	}
 
@@ -623,6 +648,11 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, buf []byte, offset int) (int
 	defer parsedPacketPool.Put(p)
 	p.Decode(buf[offset : offset+n])
 
+	// Netstack injects its forwarded traffic to peers.
+	if l := t.exitLimit.Load(); l != nil && !l.AllowToPeer(p) {
+		return 0, nil // empty reads are skipped
+	}
+
 	if m := t.destIPActivity.Load(); m != nil {
 		if fn := m[p.Dst.Addr()]; fn != nil {
 			fn()
@@ -632,6 +662,12 @@ func (t *Wrapper) injectedRead(res tunInjectedRead, buf []byte, offset int) (int
 	if stats := t.stats.Load(); stats != nil {
 		stats.UpdateTxVirtual(buf[offset:][:n])
 	}
+	if obs := t.routeObs.Load(); obs != nil {
+		obs.ObserveTx(buf[offset:][:n])
+	}
+	if u := t.usageStats.Load(); u != nil {
+		u.UpdateTxVirtual(buf[offset:][:n])
+	}
 	t.noteActivity()
 	return n, nil
 }
@@ -724,6 +760,12 @@ func (t *Wrapper) filterIn(p *packet.Parsed) filter.Response {
 		return filter.Drop
 	}
 
+	// Before PostFilterIn, so that it applies to traffic forwarded by
+	// netstack too.
+	if l := t.exitLimit.Load(); l != nil && !l.AllowFromPeer(p) {
+		return filter.DropSilently
+	}
+
 	if t.PostFilterIn != nil {
 		if res := t.PostFilterIn(p, t); res.IsDrop() {
 			return res
@@ -769,6 +811,16 @@ func (t *Wrapper) tdevWrite(buffs [][]byte, offset int) (int, error) {
 			stats.UpdateRxVirtual((buffs)[i][offset:])
 		}
 	}
+	if obs := t.routeObs.Load(); obs != nil {
+		for i := range buffs {
+			obs.ObserveRx(buffs[i][offset:])
+		}
+	}
+	if u := t.usageStats.Load(); u != nil {
+		for i := range buffs {
+			u.UpdateRxVirtual(buffs[i][offset:])
+		}
+	}
 	return t.tdev.Write(buffs, offset)
 }
 
@@ -924,6 +976,25 @@ func (t *Wrapper) SetStatistics(stats *connstats.Statistics) {
 	t.stats.Store(stats)
 }
 
+// SetRouteObserver specifies an observer of the subnets that traffic
+// flows to and from. Nil may be specified to stop observing.
+func (t *Wrapper) SetRouteObserver(obs *routeobs.Observer) {
+	t.routeObs.Store(obs)
+}
+
+// SetUsageStatistics specifies a per-connection statistics aggregator
+// for usage accounting, in addition to the one set by SetStatistics.
+// Nil may be specified to stop counting.
+func (t *Wrapper) SetUsageStatistics(stats *connstats.Statistics) {
+	t.usageStats.Store(stats)
+}
+
+// SetExitRateLimiter specifies a limiter of the traffic forwarded off the
+// tailnet for each peer. Nil may be specified to stop limiting.
+func (t *Wrapper) SetExitRateLimiter(l *exitlimit.Limiter) {
+	t.exitLimit.Store(l)
+}
+
 var (
 	metricPacketIn              = clientmetric.NewCounter("tstun_in_from_wg")
 	metricPacketInDrop          = clientmetric.NewCounter("tstun_in_from_wg_drop")
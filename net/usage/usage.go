@@ -0,0 +1,116 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package usage accounts the bytes of traffic through a TUN device, as
+// counted per connection by connstats, to the peers they were sent to and
+// received from, and to the peers' routes.
+package usage
+
+import (
+	"net/netip"
+	"sort"
+	"sync"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/netlogtype"
+)
+
+// Key is what a packet's bytes are accounted to.
+type Key struct {
+	// Peer is the peer that the packet was sent to or received from.
+	Peer tailcfg.StableNodeID
+
+	// Route is the peer's route (a subnet route or an exit node's
+	// default route) that the packet was routed via, or the zero
+	// Prefix if it was addressed to the peer itself.
+	Route netip.Prefix
+}
+
+// Counts are the byte counters of a Key.
+type Counts struct {
+	TxBytes uint64 // sent to the peer
+	RxBytes uint64 // received from the peer
+}
+
+// route is a non-host route of a peer.
+type route struct {
+	pfx  netip.Prefix
+	peer tailcfg.StableNodeID
+}
+
+// Counter accumulates the bytes of traffic through a TUN device per peer
+// and route. All methods are safe for concurrent use.
+// The zero value is ready for use, but counts nothing until SetRoutes is
+// called.
+type Counter struct {
+	mu     sync.Mutex
+	hosts  map[netip.Addr]tailcfg.StableNodeID
+	routes []route // sorted by decreasing prefix length
+	counts map[Key]Counts
+}
+
+// SetRoutes sets the routes that traffic is accounted by, mapping each
+// peer's addresses and routes (its AllowedIPs) to the peer. Traffic to or
+// from addresses not covered by any of them isn't counted.
+func (c *Counter) SetRoutes(routes map[netip.Prefix]tailcfg.StableNodeID) {
+	hosts := make(map[netip.Addr]tailcfg.StableNodeID)
+	var rs []route
+	for pfx, peer := range routes {
+		if pfx.IsSingleIP() {
+			hosts[pfx.Addr()] = peer
+		} else {
+			rs = append(rs, route{pfx.Masked(), peer})
+		}
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].pfx.Bits() > rs[j].pfx.Bits() })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hosts = hosts
+	c.routes = rs
+}
+
+// Add accounts the virtual (TUN) connection counts from a
+// connstats.Statistics. Each connection's Dst is the remote address,
+// whose peer the traffic is accounted to; connections to or from
+// addresses not covered by any route are ignored.
+func (c *Counter) Add(virtual map[netlogtype.Connection]netlogtype.Counts) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for conn, cnts := range virtual {
+		k, ok := c.keyLocked(conn.Dst.Addr())
+		if !ok {
+			continue
+		}
+		if c.counts == nil {
+			c.counts = make(map[Key]Counts)
+		}
+		sum := c.counts[k]
+		sum.TxBytes += cnts.TxBytes
+		sum.RxBytes += cnts.RxBytes
+		c.counts[k] = sum
+	}
+}
+
+// keyLocked returns the key that traffic to or from ip is accounted to.
+func (c *Counter) keyLocked(ip netip.Addr) (Key, bool) {
+	if peer, ok := c.hosts[ip]; ok {
+		return Key{Peer: peer}, true
+	}
+	for _, r := range c.routes {
+		if r.pfx.Contains(ip) {
+			return Key{Peer: r.peer, Route: r.pfx}, true
+		}
+	}
+	return Key{}, false
+}
+
+// Swap returns the counts accumulated since the previous call to Swap and
+// resets them.
+func (c *Counter) Swap() map[Key]Counts {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m := c.counts
+	c.counts = nil
+	return m
+}
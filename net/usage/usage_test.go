@@ -0,0 +1,69 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package usage
+
+import (
+	"net/netip"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/netlogtype"
+)
+
+func conn(src, dst string) netlogtype.Connection {
+	return netlogtype.Connection{
+		Proto: ipproto.UDP,
+		Src:   netip.MustParseAddrPort(src),
+		Dst:   netip.MustParseAddrPort(dst),
+	}
+}
+
+func TestCounter(t *testing.T) {
+	var c Counter
+	c.Add(map[netlogtype.Connection]netlogtype.Counts{
+		conn("100.64.0.1:1234", "100.64.0.2:53"): {TxBytes: 1}, // no routes set yet
+	})
+	if got := c.Swap(); len(got) != 0 {
+		t.Fatalf("counted %v before SetRoutes", got)
+	}
+
+	c.SetRoutes(map[netip.Prefix]tailcfg.StableNodeID{
+		netip.MustParsePrefix("100.64.0.2/32"): "laptop",
+		netip.MustParsePrefix("100.64.0.3/32"): "router",
+		netip.MustParsePrefix("10.0.0.0/8"):    "router",
+		netip.MustParsePrefix("10.1.0.0/16"):   "exit",
+		netip.MustParsePrefix("0.0.0.0/0"):     "exit",
+	})
+	// connstats keys received traffic by the same local Src and
+	// remote Dst as sent traffic.
+	c.Add(map[netlogtype.Connection]netlogtype.Counts{
+		conn("100.64.0.1:1234", "100.64.0.2:53"): {TxBytes: 10, RxBytes: 20},
+		conn("100.64.0.1:1235", "10.2.3.4:80"):   {TxBytes: 30},
+		conn("100.64.0.1:1236", "10.1.2.3:80"):   {TxBytes: 40},
+		conn("100.64.0.1:1237", "8.8.8.8:53"):    {RxBytes: 50},
+	})
+	c.Add(map[netlogtype.Connection]netlogtype.Counts{
+		conn("100.64.0.1:1234", "100.64.0.2:53"): {TxBytes: 1},
+	})
+
+	want := map[Key]Counts{
+		{Peer: "laptop"}: {TxBytes: 11, RxBytes: 20},
+		{Peer: "router", Route: netip.MustParsePrefix("10.0.0.0/8")}: {TxBytes: 30},
+		{Peer: "exit", Route: netip.MustParsePrefix("10.1.0.0/16")}:  {TxBytes: 40},
+		{Peer: "exit", Route: netip.MustParsePrefix("0.0.0.0/0")}:    {RxBytes: 50},
+	}
+	got := c.Swap()
+	if len(got) != len(want) {
+		t.Fatalf("got %d keys %v; want %d", len(got), got, len(want))
+	}
+	for k, w := range want {
+		if got[k] != w {
+			t.Errorf("%+v: got %+v; want %+v", k, got[k], w)
+		}
+	}
+	if got := c.Swap(); len(got) != 0 {
+		t.Errorf("counts not reset by Swap: %v", got)
+	}
+}
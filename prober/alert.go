@@ -0,0 +1,119 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package prober
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"log"
+	"net/http"
+	"time"
+
+	"tailscale.com/util/httpm"
+)
+
+var (
+	webhookAlertSent   = expvar.NewInt("webhook_alert_sent")
+	webhookAlertFailed = expvar.NewInt("webhook_alert_failed")
+)
+
+// Alert is a change in whether a probe is failing, as passed to an
+// AlertFunc.
+type Alert struct {
+	Probe  string            `json:"probe"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Firing is whether the probe has failed enough times in a row to
+	// alert. It's false when a probe that was alerting recovers.
+	Firing bool `json:"firing"`
+
+	Failures int       `json:"failures"`        // consecutive failures, before any recovery
+	Error    string    `json:"error,omitempty"` // the latest error, if firing
+	Since    time.Time `json:"since"`           // when the probe started failing
+}
+
+// AlertFunc is the type of func registered with Prober.WithAlerts.
+type AlertFunc func(Alert)
+
+// WithAlerts makes the Prober call fn when a probe has failed after
+// consecutive times in a row, and again when it next succeeds. fn is
+// called from the probe's goroutine, so it should not block for long.
+func (p *Prober) WithAlerts(after int, fn AlertFunc) *Prober {
+	if after < 1 {
+		after = 1
+	}
+	p.alertAfter = after
+	p.alertFunc = fn
+	return p
+}
+
+// checkAlertLocked updates the probe's failure count for the result err of a
+// run that ended at end, and returns the alert to send, if any.
+//
+// p.mu must be held.
+func (p *Probe) checkAlertLocked(end time.Time, err error) (a Alert, ok bool) {
+	a = Alert{
+		Probe:  p.name,
+		Labels: p.labels,
+	}
+	if err == nil {
+		a.Failures, a.Since = p.failures, p.failingSince
+		p.failures = 0
+		if p.alerting {
+			p.alerting = false
+			return a, p.prober.alertFunc != nil
+		}
+		return a, false
+	}
+	if p.failures == 0 {
+		p.failingSince = end
+	}
+	p.failures++
+	a.Failures, a.Since = p.failures, p.failingSince
+	if p.prober.alertFunc == nil || p.failures != p.prober.alertAfter {
+		return a, false
+	}
+	p.alerting = true
+	a.Firing = true
+	a.Error = err.Error()
+	return a, true
+}
+
+// WebhookAlertFunc returns an AlertFunc that POSTs each Alert as JSON to
+// url, for use with Prober.WithAlerts. Any 2xx response is a success;
+// failures are logged.
+func WebhookAlertFunc(url string) AlertFunc {
+	client := &http.Client{Timeout: 10 * time.Second}
+	return func(a Alert) {
+		if err := postAlert(client, url, a); err != nil {
+			webhookAlertFailed.Add(1)
+			log.Printf("sending alert for probe %s: %v", a.Probe, err)
+			return
+		}
+		webhookAlertSent.Add(1)
+	}
+}
+
+func postAlert(client *http.Client, url string, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(httpm.POST, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
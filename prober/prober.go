@@ -36,6 +36,11 @@ type Prober struct {
 	// Whether to run all probes once instead of running them in a loop.
 	once bool
 
+	// alertFunc, if non-nil, is called when a probe has failed
+	// alertAfter times in a row, and when it recovers.
+	alertFunc  AlertFunc
+	alertAfter int
+
 	// Time-related functions that get faked out during tests.
 	now       func() time.Time
 	newTicker func(time.Duration) ticker
@@ -166,6 +171,10 @@ type Probe struct {
 	end     time.Time // last time doProbe returned
 	result  bool      // whether the last doProbe call succeeded
 	lastErr error
+
+	failures     int       // consecutive failed doProbe calls
+	failingSince time.Time // end of the first of them
+	alerting     bool      // whether an Alert is firing
 }
 
 // Close shuts down the Probe and unregisters it from its Prober.
@@ -252,10 +261,14 @@ func (p *Probe) recordStart() time.Time {
 func (p *Probe) recordEnd(start time.Time, err error) {
 	end := p.prober.now()
 	p.mu.Lock()
-	defer p.mu.Unlock()
 	p.end = end
 	p.result = err == nil
 	p.lastErr = err
+	a, alert := p.checkAlertLocked(end, err)
+	p.mu.Unlock()
+	if alert {
+		p.prober.alertFunc(a)
+	}
 }
 
 type varExporter struct {
@@ -342,6 +342,42 @@ func TestOnceMode(t *testing.T) {
 	}
 }
 
+func TestAlerts(t *testing.T) {
+	clk := newFakeTime()
+	alerts := make(chan Alert, 10)
+	p := newForTest(clk.Now, clk.NewTicker).WithAlerts(2, func(a Alert) { alerts <- a })
+
+	var runs atomic.Int32
+	p.Run("flaky", probeInterval, map[string]string{"label": "value"}, func(context.Context) error {
+		if runs.Add(1) <= 3 {
+			return errors.New("down")
+		}
+		return nil
+	})
+	waitActiveProbes(t, p, clk, 1)
+
+	next := func() Alert {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case a := <-alerts:
+				return a
+			case <-timeout:
+				t.Fatal("no alert")
+			case <-time.After(aFewMillis):
+				clk.Advance(probeInterval)
+			}
+		}
+	}
+	if a := next(); !a.Firing || a.Failures != 2 || a.Error != "down" || a.Labels["label"] != "value" {
+		t.Errorf("first alert = %+v; want firing after 2 failures", a)
+	}
+	if a := next(); a.Firing || a.Failures != 3 || a.Since != epoch {
+		t.Errorf("second alert = %+v; want resolved after 3 failures since %v", a, epoch)
+	}
+}
+
 type fakeTicker struct {
 	ch       chan time.Time
 	interval time.Duration
@@ -12,6 +12,7 @@
 	"fmt"
 	"net/netip"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -94,7 +95,8 @@
 //   - 55: 2023-01-23: start of c2n GET+POST /update handler
 //   - 56: 2023-01-24: Client understands CapabilityDebugTSDNSResolution
 //   - 57: 2023-01-25: Client understands CapabilityBindToInterfaceByRoute
-const CurrentCapabilityVersion CapabilityVersion = 57
+//   - 58: 2023-02-02: Client resumes interrupted map sessions with MapRequest.MapSessionHandle
+const CurrentCapabilityVersion CapabilityVersion = 58
 
 type StableID string
 
@@ -524,6 +526,8 @@ type Hostinfo struct {
 
 	// App is used to disambiguate Tailscale clients that run using tsnet.
 	App string `json:",omitempty"` // "k8s-operator", "golinks", ...
+	// AppVersion is the version of App, as reported by the app itself.
+	AppVersion string `json:",omitempty"` // "1.2.3", ...
 
 	Desktop         opt.Bool       `json:",omitempty"` // if a desktop was detected on Linux
 	Package         string         `json:",omitempty"` // Tailscale package to disambiguate ("choco", "appstore", etc; "" for unknown)
@@ -535,6 +539,7 @@ type Hostinfo struct {
 	NoLogsNoSupport bool           `json:",omitempty"` // indicates that the user has opted out of sending logs and support
 	WireIngress     bool           `json:",omitempty"` // indicates that the node wants the option to receive ingress connections
 	AllowsUpdate    bool           `json:",omitempty"` // indicates that the node has opted-in to admin-console-drive remote updates
+	Maintenance     bool           `json:",omitempty"` // indicates that the node is in maintenance, and peers should avoid routing through it
 	Machine         string         `json:",omitempty"` // the current host's machine type (uname -m)
 	GoArch          string         `json:",omitempty"` // GOARCH value (of the built binary)
 	GoArchVar       string         `json:",omitempty"` // GOARM, GOAMD64, etc (of the built binary)
@@ -548,6 +553,16 @@ type Hostinfo struct {
 	Userspace       opt.Bool       `json:",omitempty"` // if the client is running in userspace (netstack) mode
 	UserspaceRouter opt.Bool       `json:",omitempty"` // if the client's subnet router is running in userspace (netstack) mode
 
+	// PostureAttributes are the device posture attributes collected by
+	// the client's posture collectors, mapping keys like
+	// "diskencryption:enabled" to their values.
+	PostureAttributes map[string]string `json:",omitempty"`
+
+	// DNSDomains are the DNS domains, subdomains of the tailnet's, that
+	// the node serves on port 53 of its Tailscale IPs, for the control
+	// server to route to it with split DNS.
+	DNSDomains []string `json:",omitempty"`
+
 	// NOTE: any new fields containing pointers in this type
 	//       require changes to Hostinfo.Equal.
 }
@@ -594,6 +609,11 @@ type NetInfo struct {
 	// Empty means not checked.
 	WorkingICMPv4 opt.Bool
 
+	// CaptivePortal is whether a captive portal appears to be
+	// intercepting HTTP traffic on the current network.
+	// Empty means not checked.
+	CaptivePortal opt.Bool `json:",omitempty"`
+
 	// HavePortMap is whether we have an existing portmap open
 	// (UPnP, PMP, or PCP).
 	HavePortMap bool `json:",omitempty"`
@@ -684,6 +704,7 @@ func (ni *NetInfo) BasicallyEqual(ni2 *NetInfo) bool {
 		ni.OSHasIPv6 == ni2.OSHasIPv6 &&
 		ni.WorkingUDP == ni2.WorkingUDP &&
 		ni.WorkingICMPv4 == ni2.WorkingICMPv4 &&
+		ni.CaptivePortal == ni2.CaptivePortal &&
 		ni.HavePortMap == ni2.HavePortMap &&
 		ni.UPnP == ni2.UPnP &&
 		ni.PMP == ni2.PMP &&
@@ -1060,6 +1081,40 @@ type PortRange struct {
 
 var PortRangeAny = PortRange{0, 65535}
 
+// ParsePortRange parses s as a port number ("80") or an inclusive range
+// of port numbers ("30000-30100").
+func ParsePortRange(s string) (PortRange, error) {
+	firstStr, lastStr, isRange := strings.Cut(s, "-")
+	first, err := strconv.ParseUint(firstStr, 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+	last := first
+	if isRange {
+		if last, err = strconv.ParseUint(lastStr, 10, 16); err != nil || last < first {
+			return PortRange{}, fmt.Errorf("invalid port range %q", s)
+		}
+	}
+	return PortRange{First: uint16(first), Last: uint16(last)}, nil
+}
+
+// Contains reports whether port is in pr.
+func (pr PortRange) Contains(port uint16) bool {
+	return port >= pr.First && port <= pr.Last
+}
+
+// Overlaps reports whether pr and o have any port in common.
+func (pr PortRange) Overlaps(o PortRange) bool {
+	return pr.First <= o.Last && o.First <= pr.Last
+}
+
+func (pr PortRange) String() string {
+	if pr.First == pr.Last {
+		return strconv.Itoa(int(pr.First))
+	}
+	return fmt.Sprintf("%d-%d", pr.First, pr.Last)
+}
+
 // NetPortRange represents a range of ports that's allowed for one or more IPs.
 type NetPortRange struct {
 	_     structs.Incomparable
@@ -1800,9 +1855,24 @@ type Oauth2Token struct {
 	CapabilityWakeOnLAN = "https://tailscale.com/cap/wake-on-lan"
 	// CapabilityIngress grants the ability for a peer to send ingress traffic.
 	CapabilityIngress = "https://tailscale.com/cap/ingress"
+	// CapabilityOutboundProxy grants the ability for a peer to use this
+	// node's SOCKS5 and HTTP proxies, when they accept tailnet clients.
+	CapabilityOutboundProxy = "https://tailscale.com/cap/outbound-proxy"
 	// CapabilitySSHSessionHaul grants the ability to receive SSH session logs
 	// from a peer.
 	CapabilitySSHSessionHaul = "https://tailscale.com/cap/ssh-session-haul"
+	// CapabilityRemoteAdminRead grants a tagged peer read-only access to
+	// this node's LocalAPI over the PeerAPI, if the node allows it with
+	// the RemoteAdmin pref.
+	CapabilityRemoteAdminRead = "https://tailscale.com/cap/remote-admin-read"
+	// CapabilityRemoteAdminWrite is like CapabilityRemoteAdminRead, but
+	// grants read and write access, if the node allows it.
+	CapabilityRemoteAdminWrite = "https://tailscale.com/cap/remote-admin-write"
+	// CapabilityAutoUpdateRollout sets the percentage of nodes (as in
+	// "https://tailscale.com/cap/auto-update-rollout?pct=25") that install
+	// the latest version, if they have automatic updates enabled. Without
+	// it, all of them do.
+	CapabilityAutoUpdateRollout = "https://tailscale.com/cap/auto-update-rollout"
 
 	// Funnel warning capabilities used for reporting errors to the user.
 
@@ -113,44 +113,55 @@ func (src *Hostinfo) Clone() *Hostinfo {
 	dst.Services = append(src.Services[:0:0], src.Services...)
 	dst.NetInfo = src.NetInfo.Clone()
 	dst.SSH_HostKeys = append(src.SSH_HostKeys[:0:0], src.SSH_HostKeys...)
+	if dst.PostureAttributes != nil {
+		dst.PostureAttributes = map[string]string{}
+		for k, v := range src.PostureAttributes {
+			dst.PostureAttributes[k] = v
+		}
+	}
+	dst.DNSDomains = append(src.DNSDomains[:0:0], src.DNSDomains...)
 	return dst
 }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoCloneNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
+	IPNVersion        string
+	FrontendLogID     string
+	BackendLogID      string
+	OS                string
+	OSVersion         string
+	Container         opt.Bool
+	Env               string
+	Distro            string
+	DistroVersion     string
+	DistroCodeName    string
+	App               string
+	AppVersion        string
+	Desktop           opt.Bool
+	Package           string
+	DeviceModel       string
+	PushDeviceToken   string
+	Hostname          string
+	ShieldsUp         bool
+	ShareeNode        bool
+	NoLogsNoSupport   bool
+	WireIngress       bool
+	AllowsUpdate      bool
+	Maintenance       bool
+	Machine           string
+	GoArch            string
+	GoArchVar         string
+	GoVersion         string
+	RoutableIPs       []netip.Prefix
+	RequestTags       []string
+	Services          []Service
+	NetInfo           *NetInfo
+	SSH_HostKeys      []string
+	Cloud             string
+	Userspace         opt.Bool
+	UserspaceRouter   opt.Bool
+	PostureAttributes map[string]string
+	DNSDomains        []string
 }{})
 
 // Clone makes a deep copy of NetInfo.
@@ -178,6 +189,7 @@ func (src *NetInfo) Clone() *NetInfo {
 	OSHasIPv6             opt.Bool
 	WorkingUDP            opt.Bool
 	WorkingICMPv4         opt.Bool
+	CaptivePortal         opt.Bool
 	HavePortMap           bool
 	UPnP                  opt.Bool
 	PMP                   opt.Bool
@@ -42,6 +42,7 @@ func TestHostinfoEqual(t *testing.T) {
 		"DistroVersion",
 		"DistroCodeName",
 		"App",
+		"AppVersion",
 		"Desktop",
 		"Package",
 		"DeviceModel",
@@ -52,6 +53,7 @@ func TestHostinfoEqual(t *testing.T) {
 		"NoLogsNoSupport",
 		"WireIngress",
 		"AllowsUpdate",
+		"Maintenance",
 		"Machine",
 		"GoArch",
 		"GoArchVar",
@@ -64,6 +66,8 @@ func TestHostinfoEqual(t *testing.T) {
 		"Cloud",
 		"Userspace",
 		"UserspaceRouter",
+		"PostureAttributes",
+		"DNSDomains",
 	}
 	if have := fieldsOf(reflect.TypeOf(Hostinfo{})); !reflect.DeepEqual(have, hiHandles) {
 		t.Errorf("Hostinfo.Equal check might be out of sync\nfields: %q\nhandled: %q\n",
@@ -551,6 +555,7 @@ func TestNetInfoFields(t *testing.T) {
 		"OSHasIPv6",
 		"WorkingUDP",
 		"WorkingICMPv4",
+		"CaptivePortal",
 		"HavePortMap",
 		"UPnP",
 		"PMP",
@@ -716,3 +721,37 @@ func TestCurrentCapabilityVersion(t *testing.T) {
 		t.Errorf("CurrentCapabilityVersion = %d; want %d", CurrentCapabilityVersion, max)
 	}
 }
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    PortRange
+		wantErr bool
+	}{
+		{in: "80", want: PortRange{80, 80}},
+		{in: "30000-30100", want: PortRange{30000, 30100}},
+		{in: "0-65535", want: PortRangeAny},
+		{in: "", wantErr: true},
+		{in: "http", wantErr: true},
+		{in: "30100-30000", wantErr: true},
+		{in: "30000-", wantErr: true},
+		{in: "1-65536", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePortRange(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParsePortRange(%q) = %v, %v; want %v, err=%v", tt.in, got, err, tt.want, tt.wantErr)
+			continue
+		}
+		if err == nil && got.String() != tt.in {
+			t.Errorf("ParsePortRange(%q).String() = %q", tt.in, got.String())
+		}
+	}
+	r := PortRange{30000, 30100}
+	if !r.Contains(30000) || !r.Contains(30100) || r.Contains(30101) {
+		t.Errorf("Contains is wrong for %v", r)
+	}
+	if !r.Overlaps(PortRange{30100, 30200}) || r.Overlaps(PortRange{30101, 30200}) {
+		t.Errorf("Overlaps is wrong for %v", r)
+	}
+}
@@ -268,6 +268,7 @@ func (v HostinfoView) Distro() string          { return v.ж.Distro }
 func (v HostinfoView) DistroVersion() string   { return v.ж.DistroVersion }
 func (v HostinfoView) DistroCodeName() string  { return v.ж.DistroCodeName }
 func (v HostinfoView) App() string             { return v.ж.App }
+func (v HostinfoView) AppVersion() string      { return v.ж.AppVersion }
 func (v HostinfoView) Desktop() opt.Bool       { return v.ж.Desktop }
 func (v HostinfoView) Package() string         { return v.ж.Package }
 func (v HostinfoView) DeviceModel() string     { return v.ж.DeviceModel }
@@ -278,6 +279,7 @@ func (v HostinfoView) ShareeNode() bool        { return v.ж.ShareeNode }
 func (v HostinfoView) NoLogsNoSupport() bool   { return v.ж.NoLogsNoSupport }
 func (v HostinfoView) WireIngress() bool       { return v.ж.WireIngress }
 func (v HostinfoView) AllowsUpdate() bool      { return v.ж.AllowsUpdate }
+func (v HostinfoView) Maintenance() bool       { return v.ж.Maintenance }
 func (v HostinfoView) Machine() string         { return v.ж.Machine }
 func (v HostinfoView) GoArch() string          { return v.ж.GoArch }
 func (v HostinfoView) GoArchVar() string       { return v.ж.GoArchVar }
@@ -292,43 +294,51 @@ func (v HostinfoView) SSH_HostKeys() views.Slice[string] { return views.SliceOf(
 func (v HostinfoView) Cloud() string                     { return v.ж.Cloud }
 func (v HostinfoView) Userspace() opt.Bool               { return v.ж.Userspace }
 func (v HostinfoView) UserspaceRouter() opt.Bool         { return v.ж.UserspaceRouter }
-func (v HostinfoView) Equal(v2 HostinfoView) bool        { return v.ж.Equal(v2.ж) }
+func (v HostinfoView) PostureAttributes() views.Map[string, string] {
+	return views.MapOf(v.ж.PostureAttributes)
+}
+func (v HostinfoView) DNSDomains() views.Slice[string] { return views.SliceOf(v.ж.DNSDomains) }
+func (v HostinfoView) Equal(v2 HostinfoView) bool      { return v.ж.Equal(v2.ж) }
 
 // A compilation failure here means this code must be regenerated, with the command at the top of this file.
 var _HostinfoViewNeedsRegeneration = Hostinfo(struct {
-	IPNVersion      string
-	FrontendLogID   string
-	BackendLogID    string
-	OS              string
-	OSVersion       string
-	Container       opt.Bool
-	Env             string
-	Distro          string
-	DistroVersion   string
-	DistroCodeName  string
-	App             string
-	Desktop         opt.Bool
-	Package         string
-	DeviceModel     string
-	PushDeviceToken string
-	Hostname        string
-	ShieldsUp       bool
-	ShareeNode      bool
-	NoLogsNoSupport bool
-	WireIngress     bool
-	AllowsUpdate    bool
-	Machine         string
-	GoArch          string
-	GoArchVar       string
-	GoVersion       string
-	RoutableIPs     []netip.Prefix
-	RequestTags     []string
-	Services        []Service
-	NetInfo         *NetInfo
-	SSH_HostKeys    []string
-	Cloud           string
-	Userspace       opt.Bool
-	UserspaceRouter opt.Bool
+	IPNVersion        string
+	FrontendLogID     string
+	BackendLogID      string
+	OS                string
+	OSVersion         string
+	Container         opt.Bool
+	Env               string
+	Distro            string
+	DistroVersion     string
+	DistroCodeName    string
+	App               string
+	AppVersion        string
+	Desktop           opt.Bool
+	Package           string
+	DeviceModel       string
+	PushDeviceToken   string
+	Hostname          string
+	ShieldsUp         bool
+	ShareeNode        bool
+	NoLogsNoSupport   bool
+	WireIngress       bool
+	AllowsUpdate      bool
+	Maintenance       bool
+	Machine           string
+	GoArch            string
+	GoArchVar         string
+	GoVersion         string
+	RoutableIPs       []netip.Prefix
+	RequestTags       []string
+	Services          []Service
+	NetInfo           *NetInfo
+	SSH_HostKeys      []string
+	Cloud             string
+	Userspace         opt.Bool
+	UserspaceRouter   opt.Bool
+	PostureAttributes map[string]string
+	DNSDomains        []string
 }{})
 
 // View returns a readonly view of NetInfo.
@@ -382,6 +392,7 @@ func (v NetInfoView) WorkingIPv6() opt.Bool           { return v.ж.WorkingIPv6
 func (v NetInfoView) OSHasIPv6() opt.Bool             { return v.ж.OSHasIPv6 }
 func (v NetInfoView) WorkingUDP() opt.Bool            { return v.ж.WorkingUDP }
 func (v NetInfoView) WorkingICMPv4() opt.Bool         { return v.ж.WorkingICMPv4 }
+func (v NetInfoView) CaptivePortal() opt.Bool         { return v.ж.CaptivePortal }
 func (v NetInfoView) HavePortMap() bool               { return v.ж.HavePortMap }
 func (v NetInfoView) UPnP() opt.Bool                  { return v.ж.UPnP }
 func (v NetInfoView) PMP() opt.Bool                   { return v.ж.PMP }
@@ -400,6 +411,7 @@ func (v NetInfoView) String() string                          { return v.ж.Stri
 	OSHasIPv6             opt.Bool
 	WorkingUDP            opt.Bool
 	WorkingICMPv4         opt.Bool
+	CaptivePortal         opt.Bool
 	HavePortMap           bool
 	UPnP                  opt.Bool
 	PMP                   opt.Bool
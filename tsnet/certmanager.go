@@ -0,0 +1,346 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/exp/slices"
+	"tailscale.com/util/mak"
+	"tailscale.com/version"
+)
+
+// certRenewBefore is how long before a certificate expires that a
+// CertManager starts renewing it.
+const certRenewBefore = 14 * 24 * time.Hour
+
+// DNS01Solver publishes the DNS records that prove control of a domain to
+// an ACME certificate authority, for a CertManager. It's typically a thin
+// wrapper around the API of the domain's DNS provider.
+type DNS01Solver interface {
+	// Present creates a TXT record with the given name, such as
+	// "_acme-challenge.example.com", and value. It may return before the
+	// record is visible to the CA; the CA retries for a while.
+	Present(ctx context.Context, name, value string) error
+
+	// CleanUp removes the record created by Present.
+	CleanUp(ctx context.Context, name, value string) error
+}
+
+// CertManager gets and renews TLS certificates from an ACME certificate
+// authority, such as Let's Encrypt, for custom domains that point at a
+// Server instead of its ts.net name. It proves control of the domains
+// with DNS-01 challenges published by Solver, so the Server never needs
+// to be reachable from the CA and can keep listening only on the tailnet
+// or Funnel.
+//
+// Use it as the GetCertificate func of a tls.Config, or with TLSConfig,
+// to wrap a listener from Listen or ListenOpts.Funnel.
+type CertManager struct {
+	// Server is the Server the certificates are for. Its state
+	// directory stores the ACME account key and the certificates.
+	// Names not in Domains, such as the node's own ts.net name, get
+	// their certificates from its LocalClient.
+	Server *Server
+
+	// Solver publishes the DNS-01 challenge records.
+	Solver DNS01Solver
+
+	// Domains are the custom domains to get certificates for, such as
+	// "app.example.com".
+	Domains []string
+
+	// Email, if non-empty, is the contact address of the ACME account,
+	// for expiry notices from the CA.
+	Email string
+
+	// DirectoryURL is the ACME directory of the CA. If empty, Let's
+	// Encrypt's production directory is used.
+	DirectoryURL string
+
+	mu       sync.Mutex
+	certs    map[string]*tls.Certificate // by domain
+	renewing map[string]bool             // domains being renewed in the background
+
+	acmeMu sync.Mutex // serializes ACME orders and disk writes
+}
+
+// TLSConfig returns a tls.Config that gets its certificates from m.
+func (m *CertManager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: m.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// GetCertificate returns the certificate for the SNI name of hi, for use
+// as tls.Config.GetCertificate. For one of m.Domains, a cached
+// certificate is returned if it's valid, and one is obtained otherwise,
+// which blocks the handshake until the CA issues it.
+func (m *CertManager) GetCertificate(hi *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	domain := strings.ToLower(strings.TrimSuffix(hi.ServerName, "."))
+	if !slices.Contains(m.Domains, domain) {
+		lc, err := m.Server.LocalClient()
+		if err != nil {
+			return nil, err
+		}
+		return lc.GetCertificate(hi)
+	}
+	dir, err := m.dir()
+	if err != nil {
+		return nil, err
+	}
+	return m.cert(hi.Context(), dir, domain, time.Now())
+}
+
+// cert returns a certificate for domain that is valid at now, from
+// m.certs, dir or the CA, starting its renewal in the background if it
+// expires soon.
+func (m *CertManager) cert(ctx context.Context, dir, domain string, now time.Time) (*tls.Certificate, error) {
+	m.mu.Lock()
+	c := m.certs[domain]
+	m.mu.Unlock()
+	if c == nil {
+		c, _ = readCertFiles(dir, domain)
+	}
+	if c == nil || !now.Before(c.Leaf.NotAfter) {
+		return m.obtain(ctx, dir, domain)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mak.Set(&m.certs, domain, c)
+	if now.Add(certRenewBefore).After(c.Leaf.NotAfter) && !m.renewing[domain] {
+		mak.Set(&m.renewing, domain, true)
+		go func() {
+			if _, err := m.obtain(context.Background(), dir, domain); err != nil {
+				m.Server.logf("tsnet: renewing certificate for %s: %v", domain, err)
+			}
+			m.mu.Lock()
+			delete(m.renewing, domain)
+			m.mu.Unlock()
+		}()
+	}
+	return c, nil
+}
+
+// dir returns the directory m stores its state in, starting m.Server if
+// needed to find it.
+func (m *CertManager) dir() (string, error) {
+	if m.Server == nil {
+		return "", errors.New("tsnet: CertManager.Server is nil")
+	}
+	if err := m.Server.Start(); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(m.Server.rootPath, "custom-certs")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// obtain gets a new certificate for domain from the CA and stores it in
+// dir and m.certs.
+func (m *CertManager) obtain(ctx context.Context, dir, domain string) (*tls.Certificate, error) {
+	if m.Solver == nil {
+		return nil, errors.New("tsnet: CertManager.Solver is nil")
+	}
+	m.acmeMu.Lock()
+	defer m.acmeMu.Unlock()
+
+	ac, err := m.acmeClient(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	order, err := ac.AuthorizeOrder(ctx, []acme.AuthzID{{Type: "dns", Value: domain}})
+	if err != nil {
+		return nil, fmt.Errorf("acme.AuthorizeOrder: %w", err)
+	}
+	for _, aurl := range order.AuthzURLs {
+		if err := m.authorize(ctx, ac, aurl); err != nil {
+			return nil, err
+		}
+	}
+	order, err = ac.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, fmt.Errorf("acme.WaitOrder: %w", err)
+	}
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: domain},
+		DNSNames: []string{domain},
+	}, certKey)
+	if err != nil {
+		return nil, err
+	}
+	der, _, err := ac.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, fmt.Errorf("acme.CreateOrderCert: %w", err)
+	}
+
+	var certPEM bytes.Buffer
+	for _, b := range der {
+		if err := pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: b}); err != nil {
+			return nil, err
+		}
+	}
+	keyPEM, err := encodeECKey(certKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, domain+".key"), keyPEM, 0600); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, domain+".crt"), certPEM.Bytes(), 0644); err != nil {
+		return nil, err
+	}
+	c, err := parseCertPair(certPEM.Bytes(), keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	mak.Set(&m.certs, domain, c)
+	m.mu.Unlock()
+	m.Server.logf("tsnet: got certificate for %s, valid until %v", domain, c.Leaf.NotAfter)
+	return c, nil
+}
+
+// authorize completes the DNS-01 challenge of the authorization at aurl.
+func (m *CertManager) authorize(ctx context.Context, ac *acme.Client, aurl string) error {
+	az, err := ac.GetAuthorization(ctx, aurl)
+	if err != nil {
+		return fmt.Errorf("acme.GetAuthorization: %w", err)
+	}
+	if az.Status == acme.StatusValid {
+		return nil
+	}
+	var ch *acme.Challenge
+	for _, c := range az.Challenges {
+		if c.Type == "dns-01" {
+			ch = c
+			break
+		}
+	}
+	if ch == nil {
+		return fmt.Errorf("CA offered no dns-01 challenge for %s", az.Identifier.Value)
+	}
+	rec, err := ac.DNS01ChallengeRecord(ch.Token)
+	if err != nil {
+		return err
+	}
+	name := "_acme-challenge." + az.Identifier.Value
+	if err := m.Solver.Present(ctx, name, rec); err != nil {
+		return fmt.Errorf("presenting %s TXT record: %w", name, err)
+	}
+	defer func() {
+		if err := m.Solver.CleanUp(context.Background(), name, rec); err != nil {
+			m.Server.logf("tsnet: cleaning up %s TXT record: %v", name, err)
+		}
+	}()
+	if _, err := ac.Accept(ctx, ch); err != nil {
+		return fmt.Errorf("acme.Accept: %w", err)
+	}
+	if _, err := ac.WaitAuthorization(ctx, az.URI); err != nil {
+		return fmt.Errorf("acme.WaitAuthorization: %w", err)
+	}
+	return nil
+}
+
+// acmeClient returns an ACME client with an account registered, with the
+// account key stored in dir.
+func (m *CertManager) acmeClient(ctx context.Context, dir string) (*acme.Client, error) {
+	key, err := readOrNewAccountKey(filepath.Join(dir, "acme-account.key.pem"))
+	if err != nil {
+		return nil, err
+	}
+	ac := &acme.Client{
+		Key:          key,
+		DirectoryURL: m.DirectoryURL,
+		UserAgent:    "tsnet/" + version.Long(),
+	}
+	a := new(acme.Account)
+	if m.Email != "" {
+		a.Contact = []string{"mailto:" + m.Email}
+	}
+	if _, err := ac.Register(ctx, a, acme.AcceptTOS); err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, fmt.Errorf("acme.Register: %w", err)
+	}
+	return ac, nil
+}
+
+func readOrNewAccountKey(path string) (crypto.Signer, error) {
+	if b, err := os.ReadFile(path); err == nil {
+		p, _ := pem.Decode(b)
+		if p == nil || p.Type != "EC PRIVATE KEY" {
+			return nil, fmt.Errorf("invalid ACME account key in %s", path)
+		}
+		return x509.ParseECPrivateKey(p.Bytes)
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	b, err := encodeECKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func encodeECKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), nil
+}
+
+// readCertFiles returns the certificate for domain stored in dir.
+func readCertFiles(dir, domain string) (*tls.Certificate, error) {
+	certPEM, err := os.ReadFile(filepath.Join(dir, domain+".crt"))
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := os.ReadFile(filepath.Join(dir, domain+".key"))
+	if err != nil {
+		return nil, err
+	}
+	return parseCertPair(certPEM, keyPEM)
+}
+
+// parseCertPair parses a certificate chain and key, with its Leaf set.
+func parseCertPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+	c, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if c.Leaf, err = x509.ParseCertificate(c.Certificate[0]); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// The adapters below let common Go client libraries be pointed at the
+// tailnet in one line. Libraries taking a DialContext-style func, such
+// as pgx (pgconn.Config.DialFunc) and go-redis (redis.Options.Dialer),
+// can use Server.Dial directly. For example:
+//
+//	grpc.Dial(target, grpc.WithContextDialer(s.DialTCP), ...)
+//	mysql.RegisterDialContext("tailnet", s.DialTCP)
+//	pq.DialOpen(s.Dialer(), dsn)
+//	pgxCfg.ConnConfig.LookupFunc = s.Resolver().LookupHost
+//	redis.NewClient(&redis.Options{Addr: addr, Dialer: s.Dial})
+
+// DialTCP connects to the TCP address addr on the tailnet, as Dial does.
+// Its signature matches that of the dialers of grpc.WithContextDialer and
+// the go-sql-driver/mysql RegisterDialContext func.
+func (s *Server) DialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	return s.Dial(ctx, "tcp", addr)
+}
+
+// Dialer returns a dialer of connections over the tailnet, for client
+// libraries taking one with Dial, DialTimeout or DialContext methods, such
+// as lib/pq's DialOpen, or users of golang.org/x/net/proxy.
+func (s *Server) Dialer() *Dialer {
+	return &Dialer{s: s}
+}
+
+// Dialer dials connections over the tailnet of a Server. Its methods
+// implement, among others, the lib/pq Dialer and DialerContext, and the
+// golang.org/x/net/proxy Dialer and ContextDialer interfaces.
+type Dialer struct {
+	s *Server
+}
+
+// Dial connects to the address on the tailnet.
+func (d *Dialer) Dial(network, address string) (net.Conn, error) {
+	return d.s.Dial(context.Background(), network, address)
+}
+
+// DialTimeout connects to the address on the tailnet, giving up after
+// timeout.
+func (d *Dialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.s.Dial(ctx, network, address)
+}
+
+// DialContext connects to the address on the tailnet using ctx.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.s.Dial(ctx, network, address)
+}
+
+// Resolver returns a resolver of names as a node of the tailnet would
+// resolve them, with MagicDNS, split DNS and the tailnet's DNS settings.
+// Its lookups are served in process, so they work whatever the host's
+// DNS configuration, and the server is started if it isn't already.
+func (s *Server) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial:     s.dialResolver,
+	}
+}
+
+// dialResolver returns a connection to the tailnet DNS resolver for a
+// net.Resolver. It's a stream connection, whatever the network, on which
+// the Go resolver frames messages as over TCP.
+func (s *Server) dialResolver(ctx context.Context, network, address string) (net.Conn, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	from := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), 0)
+	if ip4, _ := s.TailscaleIPs(); ip4.IsValid() {
+		from = netip.AddrPortFrom(ip4, 0)
+	}
+	c, sc := net.Pipe()
+	go s.dnsManager.HandleTCPConn(sc, from)
+	return c, nil
+}
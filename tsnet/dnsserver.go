@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// dnsTCPIdleTimeout is how long a DNS-over-TCP conn to ServeDNS can go
+// without a query before it's closed.
+const dnsTCPIdleTimeout = 10 * time.Second
+
+// A DNSHandler answers the DNS query message query, sent by the peer at
+// from, with a response message. If it returns an error, the query is
+// not answered. A response to a query over UDP should fit in the size
+// the query allows, or else be truncated and set the TC bit, so the
+// client retries over TCP.
+type DNSHandler func(ctx context.Context, query []byte, from netip.AddrPort) (response []byte, err error)
+
+// ServeDNS serves DNS queries with h on UDP and TCP port 53 of the node's
+// Tailscale IPs, and announces domains to the control server as served
+// by it, so that it can route queries for them to this node with split
+// DNS, making the node a DNS resolver of the tailnet for subdomains like
+// "svc.example.ts.net". It will start the server if it has not been
+// started yet.
+//
+// Closing the returned io.Closer stops serving and withdraws domains.
+// Peers may only reach the DNS server as permitted by the tailnet policy.
+func (s *Server) ServeDNS(h DNSHandler, domains ...string) (io.Closer, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	pc, err := s.ListenPacket("udp", ":53")
+	if err != nil {
+		return nil, err
+	}
+	ln, err := s.Listen("tcp", ":53")
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	if err := s.lb.SetDNSServerDomains(domains); err != nil {
+		pc.Close()
+		ln.Close()
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ds := &dnsServer{s: s, h: h, pc: pc, ln: ln, ctx: ctx, cancel: cancel}
+	go ds.serveUDP()
+	go ds.serveTCP()
+	return ds, nil
+}
+
+// dnsServer is a DNS server started by Server.ServeDNS.
+type dnsServer struct {
+	s      *Server
+	h      DNSHandler
+	pc     net.PacketConn
+	ln     net.Listener
+	ctx    context.Context // canceled by Close
+	cancel context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+func (ds *dnsServer) serveUDP() {
+	buf := make([]byte, 64<<10)
+	for {
+		n, addr, err := ds.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		from, ok := addrPortOf(addr)
+		if !ok {
+			continue
+		}
+		q := append([]byte(nil), buf[:n]...)
+		go func() {
+			resp, ok := ds.query(q, from)
+			if !ok {
+				return
+			}
+			if _, err := ds.pc.WriteTo(resp, addr); err != nil {
+				ds.s.logf("tsnet: DNS reply to %v: %v", from, err)
+			}
+		}()
+	}
+}
+
+func (ds *dnsServer) serveTCP() {
+	for {
+		c, err := ds.ln.Accept()
+		if err != nil {
+			return
+		}
+		go ds.serveTCPConn(c)
+	}
+}
+
+// serveTCPConn answers the queries sent over the DNS-over-TCP conn c, in
+// order, until it's closed or idle (RFC 7766).
+func (ds *dnsServer) serveTCPConn(c net.Conn) {
+	defer c.Close()
+	from, err := netip.ParseAddrPort(c.RemoteAddr().String())
+	if err != nil {
+		return
+	}
+	for {
+		c.SetReadDeadline(time.Now().Add(dnsTCPIdleTimeout))
+		var hdr [2]byte
+		if _, err := io.ReadFull(c, hdr[:]); err != nil {
+			return
+		}
+		q := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(c, q); err != nil {
+			return
+		}
+		resp, ok := ds.query(q, from)
+		if !ok {
+			return
+		}
+		if len(resp) > 0xffff {
+			ds.s.logf("tsnet: DNS response to %v of %d bytes is too long for TCP", from, len(resp))
+			return
+		}
+		out := make([]byte, 2, 2+len(resp))
+		binary.BigEndian.PutUint16(out, uint16(len(resp)))
+		if _, err := c.Write(append(out, resp...)); err != nil {
+			return
+		}
+	}
+}
+
+// query returns the handler's response to q from the peer at from,
+// reporting whether there is one.
+func (ds *dnsServer) query(q []byte, from netip.AddrPort) (resp []byte, ok bool) {
+	resp, err := ds.h(ds.ctx, q, from)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			ds.s.logf("tsnet: DNS query from %v: %v", from, err)
+		}
+		return nil, false
+	}
+	return resp, true
+}
+
+// Close stops serving DNS and withdraws the announced domains.
+func (ds *dnsServer) Close() error {
+	ds.closeOnce.Do(func() {
+		ds.cancel()
+		ds.pc.Close()
+		ds.ln.Close()
+		if err := ds.s.lb.SetDNSServerDomains(nil); err != nil {
+			ds.s.logf("tsnet: withdrawing DNS domains: %v", err)
+		}
+	})
+	return nil
+}
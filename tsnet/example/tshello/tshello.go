@@ -5,7 +5,6 @@
 package main
 
 import (
-	"crypto/tls"
 	"flag"
 	"fmt"
 	"html"
@@ -17,14 +16,15 @@
 )
 
 var (
-	addr = flag.String("addr", ":80", "address to listen on")
+	port  = flag.String("port", "80", "port to serve HTTP on")
+	https = flag.Bool("https", false, "also serve HTTPS on port 443, with the node's certificate")
 )
 
 func main() {
 	flag.Parse()
 	s := new(tsnet.Server)
 	defer s.Close()
-	ln, err := s.Listen("tcp", *addr)
+	ln, err := s.ListenGroup(*port, tsnet.ListenGroupOpts{HTTPS: *https})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -35,11 +35,6 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if *addr == ":443" {
-		ln = tls.NewListener(ln, &tls.Config{
-			GetCertificate: lc.GetCertificate,
-		})
-	}
 	log.Fatal(http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		who, err := lc.WhoIs(r.Context(), r.RemoteAddr)
 		if err != nil {
@@ -0,0 +1,129 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ListenGroupOpts configures a listener from ListenGroup.
+type ListenGroupOpts struct {
+	// ListenOpts apply to each of the group's listeners. Funnel is not
+	// supported.
+	ListenOpts
+
+	// HTTPS, if true, makes the group also listen on port 443 and
+	// terminate TLS there, so connections accepted from it are
+	// *tls.Conn. The port passed to ListenGroup must then not be 443.
+	HTTPS bool
+
+	// TLSConfig is the TLS configuration of the HTTPS listener. If nil,
+	// the node's certificates for its MagicDNS names are used, from
+	// LocalClient.GetCertificate; a CertManager's TLSConfig also works.
+	TLSConfig *tls.Config
+}
+
+// ListenGroup listens for TCP connections on port, such as "80", of each
+// of the node's Tailscale IPs, IPv4 and IPv6, and on port 443 with TLS if
+// opts.HTTPS, returning a single listener that accepts connections from
+// all of them. It replaces separate Listen calls per address family and
+// for HTTPS when serving one service, such as an http.Server, on all of
+// the node's addresses. It will start the server if it has not been
+// started yet.
+//
+// The returned listener's Addr is that of the IPv4 listener. Closing it
+// closes all of the group's listeners.
+func (s *Server) ListenGroup(port string, opts ListenGroupOpts) (net.Listener, error) {
+	if opts.Funnel {
+		return nil, errors.New("tsnet: ListenGroup doesn't support Funnel")
+	}
+	if opts.HTTPS && port == "443" {
+		return nil, errors.New("tsnet: ListenGroup port must not be 443 with HTTPS")
+	}
+	g := &groupListener{
+		conn:   make(chan net.Conn),
+		closed: make(chan struct{}),
+	}
+	for _, network := range []string{"tcp4", "tcp6"} {
+		ln, err := s.ListenWithOpts(network, ":"+port, opts.ListenOpts)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.lns = append(g.lns, ln)
+	}
+	if opts.HTTPS {
+		conf := opts.TLSConfig
+		if conf == nil {
+			lc, err := s.LocalClient()
+			if err != nil {
+				g.Close()
+				return nil, err
+			}
+			conf = &tls.Config{GetCertificate: lc.GetCertificate}
+		}
+		ln, err := s.ListenWithOpts("tcp", ":443", opts.ListenOpts)
+		if err != nil {
+			g.Close()
+			return nil, err
+		}
+		g.lns = append(g.lns, tls.NewListener(ln, conf))
+	}
+	for _, ln := range g.lns {
+		go g.acceptLoop(ln)
+	}
+	return g, nil
+}
+
+// groupListener is a net.Listener that accepts the connections of
+// several listeners.
+type groupListener struct {
+	lns    []net.Listener
+	conn   chan net.Conn
+	closed chan struct{} // closed by Close
+
+	closeOnce sync.Once
+}
+
+// acceptLoop passes the connections accepted by ln to g's Accept, until
+// either is closed.
+func (g *groupListener) acceptLoop(ln net.Listener) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		select {
+		case g.conn <- c:
+		case <-g.closed:
+			c.Close()
+			return
+		}
+	}
+}
+
+func (g *groupListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-g.conn:
+		return c, nil
+	case <-g.closed:
+		return nil, fmt.Errorf("tsnet: %w", net.ErrClosed)
+	}
+}
+
+func (g *groupListener) Addr() net.Addr { return g.lns[0].Addr() }
+
+func (g *groupListener) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.closed)
+		for _, ln := range g.lns {
+			ln.Close()
+		}
+	})
+	return nil
+}
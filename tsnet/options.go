@@ -0,0 +1,110 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"tailscale.com/ipn"
+	"tailscale.com/ipn/store/mem"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// An Option configures a Server returned by New. Each sets the Server
+// field of the same name.
+type Option func(*Server)
+
+// WithDir sets Server.Dir.
+func WithDir(dir string) Option { return func(s *Server) { s.Dir = dir } }
+
+// WithStore sets Server.Store.
+func WithStore(store ipn.StateStore) Option { return func(s *Server) { s.Store = store } }
+
+// WithHostname sets Server.Hostname.
+func WithHostname(hostname string) Option { return func(s *Server) { s.Hostname = hostname } }
+
+// WithLogf sets Server.Logf.
+func WithLogf(logf logger.Logf) Option { return func(s *Server) { s.Logf = logf } }
+
+// WithEphemeral sets Server.Ephemeral.
+func WithEphemeral() Option { return func(s *Server) { s.Ephemeral = true } }
+
+// WithAuthKey sets Server.AuthKey.
+func WithAuthKey(authKey string) Option { return func(s *Server) { s.AuthKey = authKey } }
+
+// WithControlURL sets Server.ControlURL.
+func WithControlURL(controlURL string) Option {
+	return func(s *Server) { s.ControlURL = controlURL }
+}
+
+// WithApp sets Server.AppName and Server.AppVersion.
+func WithApp(name, version string) Option {
+	return func(s *Server) { s.AppName, s.AppVersion = name, version }
+}
+
+// WithDialKeepAlive sets Server.DialKeepAlive.
+func WithDialKeepAlive(d time.Duration) Option { return func(s *Server) { s.DialKeepAlive = d } }
+
+// WithDialIdleTimeout sets Server.DialIdleTimeout.
+func WithDialIdleTimeout(d time.Duration) Option {
+	return func(s *Server) { s.DialIdleTimeout = d }
+}
+
+// WithProcessSubnets sets Server.ProcessSubnets.
+func WithProcessSubnets() Option { return func(s *Server) { s.ProcessSubnets = true } }
+
+// WithConnPriority sets Server.ConnPriority.
+func WithConnPriority(f func(ConnInfo) Priority) Option {
+	return func(s *Server) { s.ConnPriority = f }
+}
+
+// New returns a Server configured by opts. Unlike a Server literal, whose
+// misconfiguration is only reported by Start, New returns an error up
+// front if the options conflict or are invalid. The Server isn't started.
+func New(opts ...Option) (*Server, error) {
+	s := new(Server)
+	for _, o := range opts {
+		o(s)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("tsnet: %w", err)
+	}
+	return s, nil
+}
+
+// validate reports the errors in s's exported fields that would prevent
+// it from starting, or make it misbehave once started.
+func (s *Server) validate() error {
+	var errs []error
+	if _, isMemStore := s.Store.(*mem.Store); isMemStore && !s.Ephemeral {
+		errs = append(errs, errors.New("in-memory store is only supported for Ephemeral nodes"))
+	}
+	if s.Dir != "" {
+		if fi, err := os.Stat(s.Dir); err != nil {
+			errs = append(errs, err)
+		} else if !fi.IsDir() {
+			errs = append(errs, fmt.Errorf("%v is not a directory", s.Dir))
+		}
+	}
+	if s.ControlURL != "" {
+		if u, err := url.Parse(s.ControlURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid ControlURL %q; want an http or https URL", s.ControlURL))
+		}
+	}
+	if s.AppVersion != "" && s.AppName == "" {
+		errs = append(errs, errors.New("AppVersion requires AppName"))
+	}
+	if s.DialKeepAlive < 0 {
+		errs = append(errs, fmt.Errorf("negative DialKeepAlive %v", s.DialKeepAlive))
+	}
+	if s.DialIdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("negative DialIdleTimeout %v", s.DialIdleTimeout))
+	}
+	return multierr.New(errs...)
+}
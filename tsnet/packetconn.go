@@ -0,0 +1,271 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+
+	"tailscale.com/types/nettype"
+)
+
+// udpFlowIdleTimeout is how long a UDP flow to a packet conn from
+// ListenPacket can go without receiving packets before it's forgotten.
+const udpFlowIdleTimeout = 2 * time.Minute
+
+// dnsFlowIdleTimeout is udpFlowIdleTimeout for flows to port 53, which
+// usually carry a single query, so DNS servers don't keep every client
+// port they've answered for minutes.
+const dnsFlowIdleTimeout = 30 * time.Second
+
+// ListenPacket announces on the Tailscale network like Listen, but for
+// a "udp", "udp4" or "udp6" network returns a net.PacketConn that
+// receives the packets of all flows to addr, as needed by DNS and QUIC
+// servers. It will start the server if it has not been started yet.
+//
+// Replies written with WriteTo to a packet's source are sent from the
+// Tailscale IP the packet was sent to, even when addr has no host and
+// the node has several addresses. The returned conn also implements
+// nettype.DstPacketConn to report that address for each packet.
+// WriteTo returns an error for an address that hasn't sent the conn a
+// packet recently.
+func (s *Server) ListenPacket(network, addr string) (net.PacketConn, error) {
+	switch network {
+	case "udp", "udp4", "udp6":
+	default:
+		return nil, fmt.Errorf("tsnet: ListenPacket: unsupported network %q", network)
+	}
+	ln, err := s.Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	pc := &packetConn{
+		ln:     ln.(*listener),
+		pkts:   make(chan udpPacket, 64),
+		closed: make(chan struct{}),
+		flows:  make(map[netip.AddrPort]nettype.ConnPacketConn),
+	}
+	go pc.acceptFlows()
+	return pc, nil
+}
+
+// udpPacket is a packet received by a packetConn.
+type udpPacket struct {
+	b        []byte
+	src, dst netip.AddrPort
+}
+
+// packetConn is the net.PacketConn returned by Server.ListenPacket. It
+// demultiplexes the per-flow conns accepted by a UDP listener.
+type packetConn struct {
+	ln        *listener
+	pkts      chan udpPacket
+	closed    chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	mu            sync.Mutex
+	flows         map[netip.AddrPort]nettype.ConnPacketConn // keyed by remote address
+	readDeadline  deadline
+	writeDeadline time.Time
+}
+
+var _ nettype.DstPacketConn = (*packetConn)(nil)
+
+func (pc *packetConn) acceptFlows() {
+	for {
+		c, err := pc.ln.Accept()
+		if err != nil {
+			return
+		}
+		fc, ok := c.(nettype.ConnPacketConn)
+		if !ok {
+			c.Close()
+			continue
+		}
+		src, ok1 := addrPortOf(fc.RemoteAddr())
+		dst, ok2 := addrPortOf(fc.LocalAddr())
+		if !ok1 || !ok2 {
+			fc.Close()
+			continue
+		}
+		pc.mu.Lock()
+		if old, ok := pc.flows[src]; ok {
+			old.Close()
+		}
+		pc.flows[src] = fc
+		wd := pc.writeDeadline
+		pc.mu.Unlock()
+		fc.SetWriteDeadline(wd)
+		go pc.readFlow(fc, src, dst)
+	}
+}
+
+// readFlow queues the packets of the flow fc from src to dst until it's
+// closed or idle.
+func (pc *packetConn) readFlow(fc nettype.ConnPacketConn, src, dst netip.AddrPort) {
+	defer func() {
+		fc.Close()
+		pc.mu.Lock()
+		if pc.flows[src] == fc {
+			delete(pc.flows, src)
+		}
+		pc.mu.Unlock()
+	}()
+	idle := udpFlowIdleTimeout
+	if dst.Port() == 53 {
+		idle = dnsFlowIdleTimeout
+	}
+	buf := make([]byte, 64<<10)
+	for {
+		fc.SetReadDeadline(time.Now().Add(idle))
+		n, err := fc.Read(buf)
+		if err != nil {
+			return
+		}
+		p := udpPacket{b: append([]byte(nil), buf[:n]...), src: src, dst: dst}
+		select {
+		case pc.pkts <- p:
+		case <-pc.closed:
+			return
+		default:
+			// Like a full socket buffer, drop the packet.
+		}
+	}
+}
+
+// ReadFromDst is like ReadFrom, but also returns the Tailscale address
+// and port that the packet was sent to.
+func (pc *packetConn) ReadFromDst(b []byte) (n int, src, dst netip.AddrPort, err error) {
+	pc.mu.Lock()
+	dl := pc.readDeadline.ch
+	pc.mu.Unlock()
+	select {
+	case p := <-pc.pkts:
+		return copy(b, p.b), p.src, p.dst, nil
+	case <-pc.closed:
+		return 0, src, dst, pc.opError("read", net.ErrClosed)
+	case <-dl:
+		return 0, src, dst, pc.opError("read", os.ErrDeadlineExceeded)
+	}
+}
+
+func (pc *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, src, _, err := pc.ReadFromDst(b)
+	if err != nil {
+		return 0, nil, err
+	}
+	return n, net.UDPAddrFromAddrPort(src), nil
+}
+
+func (pc *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addrPortOf(addr)
+	if !ok {
+		return 0, pc.opError("write", fmt.Errorf("unsupported address %v", addr))
+	}
+	return pc.WriteToUDPAddrPort(b, dst)
+}
+
+// WriteToUDPAddrPort is like WriteTo, but takes a netip.AddrPort.
+func (pc *packetConn) WriteToUDPAddrPort(b []byte, dst netip.AddrPort) (int, error) {
+	select {
+	case <-pc.closed:
+		return 0, pc.opError("write", net.ErrClosed)
+	default:
+	}
+	pc.mu.Lock()
+	fc, ok := pc.flows[netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())]
+	pc.mu.Unlock()
+	if !ok {
+		return 0, pc.opError("write", fmt.Errorf("no UDP flow from %v", dst))
+	}
+	return fc.Write(b)
+}
+
+func (pc *packetConn) Close() error {
+	pc.closeOnce.Do(func() { close(pc.closed) })
+	pc.ln.Close()
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.readDeadline.set(time.Time{})
+	for _, fc := range pc.flows {
+		fc.Close()
+	}
+	return nil
+}
+
+func (pc *packetConn) LocalAddr() net.Addr { return pc.ln.Addr() }
+
+func (pc *packetConn) SetDeadline(t time.Time) error {
+	pc.SetReadDeadline(t)
+	return pc.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for current and future reads. Once
+// it passes, they return an error wrapping os.ErrDeadlineExceeded.
+func (pc *packetConn) SetReadDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.readDeadline.set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for writes, on all flows.
+func (pc *packetConn) SetWriteDeadline(t time.Time) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.writeDeadline = t
+	for _, fc := range pc.flows {
+		fc.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+func (pc *packetConn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: pc.ln.key.network, Addr: pc.ln.Addr(), Err: err}
+}
+
+// deadline is a channel closed when a time passes.
+type deadline struct {
+	timer *time.Timer   // or nil if no deadline is pending
+	ch    chan struct{} // closed when the deadline passes; nil for none
+}
+
+// set sets the deadline to t, or none if t is zero.
+func (d *deadline) set(t time.Time) {
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	if t.IsZero() {
+		d.ch = nil
+		return
+	}
+	ch := make(chan struct{})
+	d.ch = ch
+	if dur := time.Until(t); dur > 0 {
+		d.timer = time.AfterFunc(dur, func() { close(ch) })
+	} else {
+		close(ch)
+	}
+}
+
+// addrPortOf returns the IP and port of the UDP address a.
+func addrPortOf(a net.Addr) (netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.UDPAddr:
+		ap := a.AddrPort()
+		return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), ap.IsValid()
+	case nil:
+		return netip.AddrPort{}, false
+	}
+	ap, err := netip.ParseAddrPort(a.String())
+	if err != nil {
+		return ap, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
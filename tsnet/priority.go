@@ -0,0 +1,126 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package tsnet
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"tailscale.com/types/ipproto"
+	"tailscale.com/types/nettype"
+	"tailscale.com/wgengine/netstack"
+)
+
+// Priority is the queueing priority of the packets the Server sends for
+// a connection, as returned by Server.ConnPriority. Of the packets
+// waiting to be sent, those of connections with a higher priority are
+// sent first.
+type Priority = netstack.Priority
+
+const (
+	PriorityBulk     = netstack.PriorityBulk     // sent after others, such as for large transfers
+	PriorityDefault  = netstack.PriorityDefault  // the priority of connections without another
+	PriorityRealtime = netstack.PriorityRealtime // sent before others, such as for VoIP
+)
+
+// ConnInfo describes a connection passed to Server.ConnPriority.
+type ConnInfo struct {
+	// Network is "tcp" or "udp".
+	Network string
+
+	// Local is the Server's address of the connection, and Remote the
+	// peer's.
+	Local, Remote netip.AddrPort
+
+	// Dialed is whether the connection was made with Dial, rather than
+	// accepted by a Listener or ListenPacket.
+	Dialed bool
+}
+
+// prioritized returns c, after setting the priority of its packets to
+// the one ConnPriority returns for it. If that's not PriorityDefault,
+// the returned conn resets it when closed.
+func (s *Server) prioritized(c net.Conn, dialed bool) net.Conn {
+	key, ok := s.setConnPriority(c, dialed)
+	if !ok {
+		return c
+	}
+	pc := &priorityConn{Conn: c}
+	pc.reset = func() { s.netstack.SetFlowPriority(key.proto, key.local, key.remote, PriorityDefault) }
+	return pc
+}
+
+// prioritizedPacketConn is prioritized for a UDP flow accepted by a
+// listener.
+func (s *Server) prioritizedPacketConn(c nettype.ConnPacketConn) nettype.ConnPacketConn {
+	key, ok := s.setConnPriority(c, false)
+	if !ok {
+		return c
+	}
+	pc := &priorityPacketConn{ConnPacketConn: c}
+	pc.reset = func() { s.netstack.SetFlowPriority(key.proto, key.local, key.remote, PriorityDefault) }
+	return pc
+}
+
+// connFlow is a flow whose priority setConnPriority set.
+type connFlow struct {
+	proto         ipproto.Proto
+	local, remote netip.AddrPort
+}
+
+// setConnPriority sets the priority of the packets of c to the one
+// ConnPriority returns for it. It reports whether that's not
+// PriorityDefault, so needs resetting once c is closed.
+func (s *Server) setConnPriority(c net.Conn, dialed bool) (_ connFlow, ok bool) {
+	if s.ConnPriority == nil || s.netstack == nil {
+		return connFlow{}, false
+	}
+	local, ok1 := addrPortOf(c.LocalAddr())
+	remote, ok2 := addrPortOf(c.RemoteAddr())
+	if !ok1 || !ok2 {
+		return connFlow{}, false
+	}
+	info := ConnInfo{Local: local, Remote: remote, Dialed: dialed}
+	f := connFlow{local: local, remote: remote}
+	switch network := c.LocalAddr().Network(); {
+	case strings.HasPrefix(network, "tcp"):
+		info.Network, f.proto = "tcp", ipproto.TCP
+	case strings.HasPrefix(network, "udp"):
+		info.Network, f.proto = "udp", ipproto.UDP
+	default:
+		return connFlow{}, false
+	}
+	p := s.ConnPriority(info)
+	if p == PriorityDefault {
+		return connFlow{}, false
+	}
+	s.netstack.SetFlowPriority(f.proto, f.local, f.remote, p)
+	return f, true
+}
+
+// priorityConn is a net.Conn with a non-default Priority.
+type priorityConn struct {
+	net.Conn
+	resetOnce sync.Once
+	reset     func()
+}
+
+func (c *priorityConn) Close() error {
+	c.resetOnce.Do(c.reset)
+	return c.Conn.Close()
+}
+
+// priorityPacketConn is a UDP flow with a non-default Priority.
+type priorityPacketConn struct {
+	nettype.ConnPacketConn
+	resetOnce sync.Once
+	reset     func()
+}
+
+func (c *priorityPacketConn) Close() error {
+	c.resetOnce.Do(c.reset)
+	return c.ConnPacketConn.Close()
+}
@@ -20,11 +20,15 @@
 	"net/netip"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.org/x/exp/slices"
 	"tailscale.com/client/tailscale"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/client/web"
 	"tailscale.com/control/controlclient"
 	"tailscale.com/envknob"
 	"tailscale.com/hostinfo"
@@ -37,13 +41,17 @@
 	"tailscale.com/logpolicy"
 	"tailscale.com/logtail"
 	"tailscale.com/logtail/filch"
+	"tailscale.com/net/dns"
 	"tailscale.com/net/memnet"
 	"tailscale.com/net/proxymux"
 	"tailscale.com/net/socks5"
 	"tailscale.com/net/tsdial"
 	"tailscale.com/smallzstd"
+	"tailscale.com/tailcfg"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
 	"tailscale.com/types/nettype"
+	"tailscale.com/util/dnsname"
 	"tailscale.com/util/mak"
 	"tailscale.com/wgengine"
 	"tailscale.com/wgengine/monitor"
@@ -53,6 +61,8 @@
 // Server is an embedded Tailscale server.
 //
 // Its exported fields may be changed until the first call to Listen.
+// Alternatively, New returns a Server with its fields set by Options,
+// after checking that they're valid.
 type Server struct {
 	// Dir specifies the name of the directory to use for
 	// state. If empty, a directory is selected automatically
@@ -93,10 +103,45 @@ type Server struct {
 	// If empty, the Tailscale default is used.
 	ControlURL string
 
+	// AppName optionally identifies the program using this Server, such
+	// as "golinks". It is reported to the control server and shown in
+	// the admin console, and takes precedence over hostinfo.SetApp.
+	AppName string
+
+	// AppVersion optionally specifies the version of AppName to report
+	// to the control server.
+	AppVersion string
+
+	// DialKeepAlive, if positive, is the TCP keepalive period for
+	// connections made with Dial: probes are sent after a connection has
+	// been idle that long, and then that often, so connections to peers
+	// that vanish are eventually closed. If zero, the netstack default of
+	// two hours applies.
+	DialKeepAlive time.Duration
+
+	// DialIdleTimeout, if positive, closes TCP connections made with Dial
+	// after they have had no reads or writes for that long.
+	DialIdleTimeout time.Duration
+
+	// ProcessSubnets, if true, makes the Server's listeners also accept
+	// connections to the IPs of the subnet routes it advertises (see
+	// ipn.Prefs.AdvertiseRoutes), as a userspace subnet router. Only
+	// listeners on addresses without an IP, such as ":443", match them.
+	ProcessSubnets bool
+
+	// ConnPriority, if non-nil, is called for each connection made with
+	// Dial or accepted by a Listener, and each UDP flow to ListenPacket,
+	// and returns the priority of the packets the Server sends for it.
+	// It lets latency-sensitive connections, such as a VoIP bridge's,
+	// outrank bulk transfers from the same node. Connections it returns
+	// PriorityDefault for, as does a nil ConnPriority, are sent in order.
+	ConnPriority func(ConnInfo) Priority
+
 	initOnce         sync.Once
 	initErr          error
 	lb               *ipnlocal.LocalBackend
 	netstack         *netstack.Impl
+	dnsManager       *dns.Manager
 	linkMon          *monitor.Mon
 	rootPath         string // the state directory
 	hostname         string
@@ -111,18 +156,193 @@ type Server struct {
 	logtail          *logtail.Logger
 	logid            string
 
-	mu        sync.Mutex
-	listeners map[listenKey]*listener
-	dialer    *tsdial.Dialer
+	mu             sync.Mutex
+	listeners      map[listenKey]*listener
+	rangeListeners []*listener // on port ranges, keyed by their first port
+	dialer         *tsdial.Dialer
 }
 
 // Dial connects to the address on the tailnet.
 // It will start the server if it has not been started yet.
+//
+// For TCP dials to a peer's MagicDNS name, the peer's IPv4 and IPv6
+// addresses are raced as in RFC 8305 ("Happy Eyeballs"), so a dial
+// doesn't stall when one address family is slow to establish.
 func (s *Server) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	if err := s.Start(); err != nil {
 		return nil, err
 	}
-	return s.dialer.UserDial(ctx, network, address)
+	var c net.Conn
+	var err error
+	if addrs := peerDialAddrs(s.lb.NetMap(), network, address); len(addrs) > 1 {
+		c, err = dialParallel(ctx, addrs, happyEyeballsDelay, func(ctx context.Context, ipp netip.AddrPort) (net.Conn, error) {
+			return s.dialer.UserDial(ctx, network, ipp.String())
+		})
+	} else {
+		c, err = s.dialer.UserDial(ctx, network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c = s.prioritized(c, true)
+	if s.DialIdleTimeout > 0 && strings.HasPrefix(network, "tcp") {
+		c = newIdleTimeoutConn(c, s.DialIdleTimeout)
+	}
+	return c, nil
+}
+
+// happyEyeballsDelay is how long Dial waits for a connection attempt
+// before starting one to the next address, per RFC 8305.
+const happyEyeballsDelay = 300 * time.Millisecond
+
+// peerDialAddrs returns the addresses to race for a TCP dial of address,
+// if it names a peer in nm by MagicDNS name. Otherwise it returns nil.
+func peerDialAddrs(nm *netmap.NetworkMap, network, address string) []netip.AddrPort {
+	var want4, want6 bool
+	switch network {
+	case "tcp":
+		want4, want6 = true, true
+	case "tcp4":
+		want4 = true
+	case "tcp6":
+		want6 = true
+	default:
+		return nil
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return nil
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil
+	}
+	if nm == nil {
+		return nil
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	suffix := nm.MagicDNSSuffix()
+	for _, p := range nm.Peers {
+		name := strings.ToLower(strings.TrimSuffix(p.Name, "."))
+		if name == "" || (name != host && dnsname.TrimSuffix(name, suffix) != host) {
+			continue
+		}
+		var addrs []netip.AddrPort
+		for _, a := range p.Addresses {
+			if !a.IsSingleIP() {
+				continue
+			}
+			if ip := a.Addr(); (ip.Is4() && want4) || (ip.Is6() && want6) {
+				addrs = append(addrs, netip.AddrPortFrom(ip, uint16(port)))
+			}
+		}
+		return addrs
+	}
+	return nil
+}
+
+// dialParallel dials addrs in order, starting each attempt after the
+// previous one fails or delay passes, whichever is first. It returns the
+// first connection established and closes any others, or the first
+// error if every attempt fails.
+func dialParallel(ctx context.Context, addrs []netip.AddrPort, delay time.Duration, dial func(context.Context, netip.AddrPort) (net.Conn, error)) (net.Conn, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		c   net.Conn
+		err error
+	}
+	results := make(chan result) // unbuffered; see the drain below
+	start := func(ipp netip.AddrPort) {
+		go func() {
+			c, err := dial(ctx, ipp)
+			select {
+			case results <- result{c, err}:
+			case <-ctx.Done():
+				if c != nil {
+					c.Close()
+				}
+			}
+		}()
+	}
+
+	var firstErr error
+	next, pending := 0, 0
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if next < len(addrs) {
+				start(addrs[next])
+				next++
+				pending++
+				timer.Reset(delay)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.c, nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				// Don't wait out the delay after a failure.
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(0)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// ControlStatus describes the state of a Server's connection to the
+// control server, as returned by Server.ControlStatus.
+type ControlStatus struct {
+	// Connected is whether a network map has been received since the
+	// last error from the control server. If false and LastErr is
+	// non-nil, the Server is retrying.
+	Connected bool
+
+	// LastNetMap is when the most recent network map was received, or
+	// the zero time if none has been. Services can compare it against
+	// the current time to detect running on stale network state.
+	LastNetMap time.Time
+
+	// LastErr is the most recent error communicating with the control
+	// server, or nil if there hasn't been one since the last network
+	// map. LastErrTime is when it happened.
+	LastErr     error
+	LastErrTime time.Time
+}
+
+// ControlStatus reports the state of the connection to the control
+// server. It will start the server if it has not been started yet; if
+// that fails, the error is reported in LastErr.
+func (s *Server) ControlStatus() ControlStatus {
+	if err := s.Start(); err != nil {
+		return ControlStatus{LastErr: err}
+	}
+	st := s.lb.ControlStatus()
+	return ControlStatus{
+		Connected:   st.Connected,
+		LastNetMap:  st.LastNetMap,
+		LastErr:     st.LastErr,
+		LastErrTime: st.LastErrTime,
+	}
 }
 
 // HTTPClient returns an HTTP client that is configured to connect over Tailscale.
@@ -339,11 +559,17 @@ func (s *Server) Close() error {
 	}
 
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	for _, ln := range s.listeners {
+	listeners := s.listeners
+	rangeListeners := s.rangeListeners
+	s.listeners = nil
+	s.rangeListeners = nil
+	s.mu.Unlock()
+	for _, ln := range listeners {
+		ln.Close()
+	}
+	for _, ln := range rangeListeners {
 		ln.Close()
 	}
-	s.listeners = nil
 
 	wg.Wait()
 	return nil
@@ -385,6 +611,10 @@ func (s *Server) start() (reterr error) {
 	var closePool closeOnErrorPool
 	defer closePool.closeAllIfError(&reterr)
 
+	if err := s.validate(); err != nil {
+		return err
+	}
+
 	exe, err := os.Executable()
 	if err != nil {
 		return err
@@ -397,12 +627,6 @@ func (s *Server) start() (reterr error) {
 	}
 
 	s.rootPath = s.Dir
-	if s.Store != nil {
-		_, isMemStore := s.Store.(*mem.Store)
-		if isMemStore && !s.Ephemeral {
-			return fmt.Errorf("in-memory store is only supported for Ephemeral nodes")
-		}
-	}
 
 	logf := s.logf
 
@@ -491,15 +715,21 @@ func (s *Server) start() (reterr error) {
 		return fmt.Errorf("netstack.Create: %w", err)
 	}
 	ns.ProcessLocalIPs = true
+	ns.ProcessSubnets = s.ProcessSubnets
 	ns.GetTCPHandlerForFlow = s.getTCPHandlerForFlow
 	ns.GetUDPHandlerForFlow = s.getUDPHandlerForFlow
+	ns.GetTCPKeepAliveForFlow = s.getTCPKeepAliveForFlow
 	s.netstack = ns
+	s.dnsManager = dns
 	s.dialer.UseNetstackForIP = func(ip netip.Addr) bool {
 		_, ok := eng.PeerForIP(ip)
 		return ok
 	}
 	s.dialer.NetstackDialTCP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
-		return ns.DialContextTCP(ctx, dst)
+		return ns.DialContextTCPKeepAlive(ctx, dst, s.DialKeepAlive)
+	}
+	s.dialer.NetstackDialUDP = func(ctx context.Context, dst netip.AddrPort) (net.Conn, error) {
+		return ns.DialContextUDP(ctx, dst)
 	}
 
 	if s.Store == nil {
@@ -520,6 +750,8 @@ func (s *Server) start() (reterr error) {
 		return fmt.Errorf("NewLocalBackend: %v", err)
 	}
 	lb.SetVarRoot(s.rootPath)
+	lb.SetApp(s.AppName, s.AppVersion)
+	lb.SetIngressHandler(s.handleFunnelConn)
 	logf("tsnet starting with hostname %q, varRoot %q", s.hostname, s.rootPath)
 	s.lb = lb
 	if err := ns.Start(lb); err != nil {
@@ -643,6 +875,9 @@ func networkForFamily(netBase string, is6 bool) string {
 //   - ("tcp4", "", port)
 //   - ("tcp", "", port)
 //
+// At each step, a listener on the single port is preferred to one on a
+// port range containing it.
+//
 // The netBase is "tcp" or "udp" (without any '4' or '6' suffix).
 func (s *Server) listenerForDstAddr(netBase string, dst netip.AddrPort) (_ *listener, ok bool) {
 	s.mu.Lock()
@@ -655,25 +890,68 @@ func (s *Server) listenerForDstAddr(netBase string, dst netip.AddrPort) (_ *list
 			if ln, ok := s.listeners[listenKey{net, a, dst.Port()}]; ok {
 				return ln, true
 			}
+			for _, ln := range s.rangeListeners {
+				if ln.key.network == net && ln.key.host == a && ln.ports.Contains(dst.Port()) {
+					return ln, true
+				}
+			}
 		}
 	}
 	return nil, false
 }
 
+// listenerConflictsLocked reports whether a listener on ports with the
+// network and host of key would overlap an existing one.
+//
+// s.mu must be held.
+func (s *Server) listenerConflictsLocked(key listenKey, ports tailcfg.PortRange) bool {
+	if ports.First == ports.Last {
+		if _, ok := s.listeners[key]; ok {
+			return true
+		}
+	} else {
+		for k := range s.listeners {
+			if k.network == key.network && k.host == key.host && ports.Contains(k.port) {
+				return true
+			}
+		}
+	}
+	for _, ln := range s.rangeListeners {
+		if ln.key.network == key.network && ln.key.host == key.host && ln.ports.Overlaps(ports) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Server) getTCPHandlerForFlow(src, dst netip.AddrPort) (handler func(net.Conn), intercept bool) {
 	ln, ok := s.listenerForDstAddr("tcp", dst)
-	if !ok {
+	if !ok || !ln.allowed(src) {
 		return nil, true // don't handle, don't forward to localhost
 	}
-	return ln.handle, true
+	return func(c net.Conn) {
+		c = s.prioritized(c, false)
+		if d := ln.opts.IdleTimeout; d > 0 {
+			c = newIdleTimeoutConn(c, d)
+		}
+		ln.handle(c)
+	}, true
+}
+
+func (s *Server) getTCPKeepAliveForFlow(src, dst netip.AddrPort) time.Duration {
+	ln, ok := s.listenerForDstAddr("tcp", dst)
+	if !ok {
+		return 0
+	}
+	return ln.opts.KeepAlive
 }
 
 func (s *Server) getUDPHandlerForFlow(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool) {
 	ln, ok := s.listenerForDstAddr("udp", dst)
-	if !ok {
+	if !ok || !ln.allowed(src) {
 		return nil, true // don't handle, don't forward to localhost
 	}
-	return func(c nettype.ConnPacketConn) { ln.handle(c) }, true
+	return func(c nettype.ConnPacketConn) { ln.handle(s.prioritizedPacketConn(c)) }, true
 }
 
 // getTSNetDir usually just returns filepath.Join(confDir, "tsnet-"+prog)
@@ -735,9 +1013,331 @@ func (s *Server) APIClient() (*tailscale.Client, error) {
 	return c, nil
 }
 
+// SetServiceRecords publishes recs to peers, replacing any records
+// previously set. Peers discover them with LookupServices, so clients can
+// find the ports and versions of tailnet services without hardcoding them.
+// It will start the server if it has not been started yet.
+func (s *Server) SetServiceRecords(recs ...apitype.ServiceRecord) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.lb.SetServiceRecords(recs)
+}
+
+// LookupServices returns the service records published with
+// SetServiceRecords by the peer with the Tailscale IP ip.
+// It will start the server if it has not been started yet.
+func (s *Server) LookupServices(ctx context.Context, ip netip.Addr) ([]apitype.ServiceRecord, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	return s.lb.PeerServiceRecords(ctx, ip)
+}
+
+// HandlePeerAPI sets h to serve the requests of peers to this node's
+// PeerAPI under "/v0/app/<name>/", so node-to-node APIs between tailnet
+// apps can rely on Tailscale for identity and transport. A nil h stops
+// serving name. The name must be lowercase letters, digits and dashes.
+// It will start the server if it has not been started yet.
+//
+// Requests are only passed to h from peers permitted by the tailnet
+// policy to reach this node, with the "/v0/app/<name>" prefix removed
+// from the URL path. Use PeerAPICaller to find the peer making a request.
+func (s *Server) HandlePeerAPI(name string, h http.Handler) error {
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return s.lb.SetPeerAPIApp(name, h)
+}
+
+// PeerAPIURL returns the base URL of the handler set with HandlePeerAPI
+// for name by the peer with the Tailscale IP ip. Requests to it should be
+// sent with the HTTPClient.
+// It will start the server if it has not been started yet.
+func (s *Server) PeerAPIURL(ip netip.Addr, name string) (string, error) {
+	if err := s.Start(); err != nil {
+		return "", err
+	}
+	return s.lb.PeerAPIAppURL(ip, name)
+}
+
+// RemoteLocalClient returns a LocalClient for the LocalAPI of the peer
+// with the Tailscale IP ip, over its PeerAPI, to administer it remotely.
+// The peer must allow it with its RemoteAdmin pref, and this node must be
+// tagged and granted the "https://tailscale.com/cap/remote-admin-read"
+// or "-write" capability to the peer by the tailnet policy.
+// It will start the server if it has not been started yet.
+func (s *Server) RemoteLocalClient(ip netip.Addr) (*tailscale.LocalClient, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	addr, err := s.lb.PeerAPIHostPort(ip)
+	if err != nil {
+		return nil, err
+	}
+	return &tailscale.LocalClient{
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return s.Dial(ctx, network, addr)
+		},
+	}, nil
+}
+
+// PeerAPICaller returns the node, and its owner and capabilities, making
+// the request r to a handler set with HandlePeerAPI.
+func PeerAPICaller(r *http.Request) (_ *apitype.WhoIsResponse, ok bool) {
+	return ipnlocal.PeerAPIAppCaller(r.Context())
+}
+
+// webClientPort is the tailnet port on which ServeWebClient listens.
+const webClientPort = 5252
+
+// ServeWebClient serves the Tailscale web client for this node on port
+// 5252 of the Tailscale network, so the embedded node's state can be
+// inspected from a browser.
+// It will start the server if it has not been started yet.
+//
+// If readOnly is true, any peer permitted by the tailnet policy may view
+// the node's status, but requests that would change the node are
+// rejected. Otherwise the web client can also log in, log out, and
+// change the node's advertised routes; those requests are only served to
+// untagged peers owned by the same user as this node.
+//
+// Serving continues in the background until the returned listener or
+// the Server is closed.
+func (s *Server) ServeWebClient(readOnly bool) (net.Listener, error) {
+	lc, err := s.LocalClient()
+	if err != nil {
+		return nil, err
+	}
+	ln, err := s.Listen("tcp", fmt.Sprintf(":%d", webClientPort))
+	if err != nil {
+		return nil, err
+	}
+	opts := web.ServerOpts{
+		LocalClient: lc,
+		ReadOnly:    readOnly,
+	}
+	if !readOnly {
+		opts.Authorize = s.authorizeWebClient
+	}
+	hs := &http.Server{
+		Handler:           web.NewServer(opts),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logf("tsnet: web client server: %v", err)
+		}
+	}()
+	return ln, nil
+}
+
+// authorizeWebClient is the web.ServerOpts.Authorize hook for the manage
+// mode web client. It permits only untagged peers owned by the same user
+// as this node.
+func (s *Server) authorizeWebClient(w http.ResponseWriter, r *http.Request) (user string, ok bool) {
+	src, err := netip.ParseAddrPort(r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "bad remote address", http.StatusBadRequest)
+		return "", false
+	}
+	n, u, ok := s.lb.WhoIs(src)
+	if !ok {
+		http.Error(w, "unknown peer", http.StatusForbidden)
+		return "", false
+	}
+	nm := s.lb.NetMap()
+	if nm == nil || nm.SelfNode == nil || len(n.Tags) > 0 || n.User != nm.SelfNode.User {
+		http.Error(w, "not the owner of this node", http.StatusForbidden)
+		return "", false
+	}
+	return u.LoginName, true
+}
+
+// ServeHTTPSRedirect listens on port 80 of the Tailscale network and
+// replies to every HTTP request with a 301 redirect to the same path on
+// the node's HTTPS MagicDNS name. If the request's Host is one of the
+// node's certificate domains, the redirect keeps that name; otherwise the
+// first certificate domain is used.
+// It will start the server if it has not been started yet.
+//
+// Serving continues in the background until the returned listener or
+// the Server is closed. HTTPS must be enabled for the tailnet; until the
+// node has a certificate domain, requests fail with 503 Service
+// Unavailable.
+//
+// To also send a Strict-Transport-Security header, wrap the HTTPS
+// handler with HSTS.
+func (s *Server) ServeHTTPSRedirect() (net.Listener, error) {
+	ln, err := s.Listen("tcp", ":80")
+	if err != nil {
+		return nil, err
+	}
+	hs := &http.Server{
+		Handler:           httpsRedirectHandler(s.certDomains),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := hs.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
+			s.logf("tsnet: HTTPS redirect server: %v", err)
+		}
+	}()
+	return ln, nil
+}
+
+// certDomains returns the DNS names for which the node can get a TLS
+// certificate, per the current netmap.
+func (s *Server) certDomains() []string {
+	nm := s.lb.NetMap()
+	if nm == nil {
+		return nil
+	}
+	return nm.DNS.CertDomains
+}
+
+// httpsRedirectHandler returns an HTTP handler that redirects to the
+// https:// equivalent of the request URL on one of the names returned by
+// domains.
+func httpsRedirectHandler(domains func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := redirectHost(r.Host, domains())
+		if host == "" {
+			http.Error(w, "tsnet: no HTTPS name available for this node", http.StatusServiceUnavailable)
+			return
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
+
+// redirectHost returns the host to redirect a request for reqHost to,
+// or the empty string if domains is empty.
+func redirectHost(reqHost string, domains []string) string {
+	if len(domains) == 0 {
+		return ""
+	}
+	host := reqHost
+	if h, _, err := net.SplitHostPort(reqHost); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+	for _, d := range domains {
+		if strings.EqualFold(host, d) {
+			return d
+		}
+	}
+	return domains[0]
+}
+
+// HSTS returns a handler that adds a Strict-Transport-Security header
+// with the given max-age to responses served over TLS before calling h.
+// Browsers ignore the header on plain HTTP, so it is not added there.
+func HSTS(h http.Handler, maxAge time.Duration) http.Handler {
+	v := fmt.Sprintf("max-age=%d", int64(maxAge/time.Second))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", v)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
 // Listen announces only on the Tailscale network.
 // It will start the server if it has not been started yet.
+//
+// The port of addr may be an inclusive range such as "30000-30100", to
+// accept connections to any of its ports with one listener, as needed by
+// RTP or passive FTP. The LocalAddr of each accepted connection reports
+// the port it was made to.
+//
+// The returned listener also has methods
+// AcceptContext(context.Context) (net.Conn, error) and
+// SetDeadline(time.Time) error to bound how long Accept blocks.
 func (s *Server) Listen(network, addr string) (net.Listener, error) {
+	return s.ListenWithOpts(network, addr, ListenOpts{})
+}
+
+// ListenOpts restricts which peers a listener accepts connections
+// from, in addition to the tailnet's ACLs.
+//
+// If both fields are empty, all peers are accepted. Otherwise a tagged
+// peer is accepted if it has one of AllowedTags, and an untagged peer is
+// accepted if its user's login name is one of AllowedUsers.
+type ListenOpts struct {
+	// AllowedTags are the tags, such as "tag:admin", that accepted
+	// tagged peers may have.
+	AllowedTags []string
+
+	// AllowedUsers are the login names, such as "alice@example.com",
+	// of users whose untagged peers are accepted.
+	AllowedUsers []string
+
+	// KeepAlive, if positive, is the TCP keepalive period for accepted
+	// connections: probes are sent after a connection has been idle that
+	// long, and then that often, so connections from peers that vanish
+	// are eventually closed. If zero, the netstack default of two hours
+	// applies.
+	KeepAlive time.Duration
+
+	// IdleTimeout, if positive, closes accepted TCP connections after
+	// they have had no reads or writes for that long.
+	IdleTimeout time.Duration
+
+	// Funnel, if true, makes the listener also accept connections from
+	// the public internet through Tailscale Funnel
+	// (https://tailscale.com/kb/1223/tailscale-funnel/). AllowedTags
+	// and AllowedUsers don't apply to such connections, which are
+	// returned from Accept as *FunnelConn so handlers can tell them
+	// apart from tailnet connections.
+	//
+	// The network must be "tcp" with no host, the port must be 443,
+	// 8443 or 10000, and the Server must already be up (see Up).
+	// Funnel delivers the client's TLS stream as is, so the listener
+	// must terminate TLS itself, such as with LocalClient.GetCertificate.
+	Funnel bool
+}
+
+// FunnelConn is a connection accepted from the public internet through
+// Tailscale Funnel by a listener created with ListenOpts.Funnel.
+// Connections from tailnet peers are never FunnelConns.
+type FunnelConn struct {
+	net.Conn
+
+	// Src is the public address of the client.
+	Src netip.AddrPort
+
+	// Target is the SNI name and port the client connected to, such as
+	// "foo.tail-scale.ts.net:443".
+	Target ipn.HostPort
+}
+
+// RemoteAddr returns the public address of the client.
+func (c *FunnelConn) RemoteAddr() net.Addr { return net.TCPAddrFromAddrPort(c.Src) }
+
+// IsFunnelConn reports whether c was accepted from the public internet
+// through Funnel, rather than from a tailnet peer.
+func IsFunnelConn(c net.Conn) bool {
+	_, ok := c.(*FunnelConn)
+	return ok
+}
+
+func (o ListenOpts) restricted() bool {
+	return len(o.AllowedTags) > 0 || len(o.AllowedUsers) > 0
+}
+
+// ListenWithOpts is like Listen, but only accepts connections from peers
+// permitted by opts. Peers are identified when each flow arrives, and
+// rejected flows are reset without reaching Accept.
+func (s *Server) ListenWithOpts(network, addr string, opts ListenOpts) (net.Listener, error) {
+	for _, tag := range opts.AllowedTags {
+		if err := tailcfg.CheckTag(tag); err != nil {
+			return nil, fmt.Errorf("tsnet: invalid AllowedTags entry: %w", err)
+		}
+	}
+	if opts.Funnel {
+		if network != "tcp" {
+			return nil, fmt.Errorf("tsnet: Funnel requires network \"tcp\", not %q", network)
+		}
+	}
 	switch network {
 	case "", "tcp", "tcp4", "tcp6", "udp", "udp4", "udp6":
 	default:
@@ -747,12 +1347,23 @@ func (s *Server) Listen(network, addr string) (net.Listener, error) {
 	if err != nil {
 		return nil, fmt.Errorf("tsnet: %w", err)
 	}
-	port, err := net.LookupPort(network, portStr)
-	if err != nil || port < 0 || port > math.MaxUint16 {
-		// LookupPort returns an error on out of range values so the bounds
-		// checks on port should be unnecessary, but harmless. If they do
-		// match, worst case this error message says "invalid port: <nil>".
-		return nil, fmt.Errorf("invalid port: %w", err)
+	var ports tailcfg.PortRange
+	if strings.Contains(portStr, "-") {
+		if ports, err = tailcfg.ParsePortRange(portStr); err != nil {
+			return nil, fmt.Errorf("tsnet: %w", err)
+		}
+		if opts.Funnel && ports.First != ports.Last {
+			return nil, errors.New("tsnet: Funnel listeners must not use a port range")
+		}
+	} else {
+		port, err := net.LookupPort(network, portStr)
+		if err != nil || port < 0 || port > math.MaxUint16 {
+			// LookupPort returns an error on out of range values so the bounds
+			// checks on port should be unnecessary, but harmless. If they do
+			// match, worst case this error message says "invalid port: <nil>".
+			return nil, fmt.Errorf("invalid port: %w", err)
+		}
+		ports = tailcfg.PortRange{First: uint16(port), Last: uint16(port)}
 	}
 	var bindHostOrZero netip.Addr
 	if host != "" {
@@ -772,61 +1383,248 @@ func (s *Server) Listen(network, addr string) (net.Listener, error) {
 		return nil, err
 	}
 
-	key := listenKey{network, bindHostOrZero, uint16(port)}
+	key := listenKey{network, bindHostOrZero, ports.First}
 	ln := &listener{
-		s:    s,
-		key:  key,
-		addr: addr,
+		s:     s,
+		key:   key,
+		ports: ports,
+		addr:  addr,
+
+		opts: opts,
 
-		conn: make(chan net.Conn),
+		conn:   make(chan net.Conn),
+		closed: make(chan struct{}),
 	}
 	s.mu.Lock()
-	if _, ok := s.listeners[key]; ok {
+	if s.listenerConflictsLocked(key, ports) {
 		s.mu.Unlock()
 		return nil, fmt.Errorf("tsnet: listener already open for %s, %s", network, addr)
 	}
-	mak.Set(&s.listeners, key, ln)
+	if ln.isRange() {
+		s.rangeListeners = append(s.rangeListeners, ln)
+	} else {
+		mak.Set(&s.listeners, key, ln)
+	}
 	s.mu.Unlock()
+	if opts.Funnel {
+		if err := s.setFunnel(key, true); err != nil {
+			s.mu.Lock()
+			delete(s.listeners, key)
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
 	return ln, nil
 }
 
+// setFunnel turns Funnel on or off for the port of the listener with the
+// given key on the node's MagicDNS name.
+func (s *Server) setFunnel(key listenKey, on bool) error {
+	if on {
+		if key.host.IsValid() {
+			return errors.New("tsnet: Funnel listeners must not specify a host")
+		}
+		switch key.port {
+		case 443, 8443, 10000:
+		default:
+			return fmt.Errorf("tsnet: Funnel port %d is invalid; must be 443, 8443 or 10000", key.port)
+		}
+	}
+	nm := s.lb.NetMap()
+	if nm == nil || nm.SelfNode == nil {
+		return errors.New("tsnet: Funnel requires the Server to be up; call Up first")
+	}
+	hp := ipn.HostPort(net.JoinHostPort(strings.TrimSuffix(nm.SelfNode.Name, "."), fmt.Sprint(key.port)))
+
+	sc := s.lb.ServeConfig().AsStruct()
+	if sc == nil {
+		sc = new(ipn.ServeConfig)
+	}
+	if on == sc.AllowFunnel[hp] {
+		return nil
+	}
+	if on {
+		mak.Set(&sc.AllowFunnel, hp, true)
+	} else {
+		delete(sc.AllowFunnel, hp)
+	}
+	return s.lb.SetServeConfig(sc)
+}
+
+// handleFunnelConn is the ipnlocal.IngressHandler that delivers Funnel
+// connections to listeners created with ListenOpts.Funnel.
+func (s *Server) handleFunnelConn(target ipn.HostPort, srcAddr netip.AddrPort, getConn func() (net.Conn, bool)) bool {
+	_, portStr, err := net.SplitHostPort(string(target))
+	if err != nil {
+		return false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return false
+	}
+	s.mu.Lock()
+	ln, ok := s.listeners[listenKey{"tcp", netip.Addr{}, uint16(port)}]
+	s.mu.Unlock()
+	if !ok || !ln.opts.Funnel {
+		return false
+	}
+	c, ok := getConn()
+	if !ok {
+		return true
+	}
+	if d := ln.opts.IdleTimeout; d > 0 {
+		c = newIdleTimeoutConn(c, d)
+	}
+	ln.handle(&FunnelConn{Conn: c, Src: srcAddr, Target: target})
+	return true
+}
+
 type listenKey struct {
 	network string
 	host    netip.Addr // or zero value for unspecified
 	port    uint16
 }
 
+// listener is the net.Listener returned by Server.Listen.
+//
+// In addition to the net.Listener methods, it has AcceptContext and
+// SetDeadline methods to bound how long Accept blocks.
 type listener struct {
-	s    *Server
-	key  listenKey
-	addr string
-	conn chan net.Conn
+	s      *Server
+	key    listenKey
+	ports  tailcfg.PortRange // the ports it accepts on; First is key.port
+	addr   string
+	opts   ListenOpts
+	conn   chan net.Conn
+	closed chan struct{} // closed by Close
+
+	closeOnce sync.Once
+
+	dlMu      sync.Mutex
+	dlTimer   *time.Timer   // or nil if no deadline is pending
+	deadline  chan struct{} // closed when the deadline passes; nil for none
+	dlChanged chan struct{} // closed by SetDeadline; nil until an Accept waits on it
 }
 
 func (ln *listener) Accept() (net.Conn, error) {
-	c, ok := <-ln.conn
-	if !ok {
-		return nil, fmt.Errorf("tsnet: %w", net.ErrClosed)
+	return ln.AcceptContext(context.Background())
+}
+
+// AcceptContext is like Accept, but returns ctx.Err() if ctx is done
+// before a connection arrives.
+func (ln *listener) AcceptContext(ctx context.Context) (net.Conn, error) {
+	for {
+		deadline, changed := ln.deadlineChans()
+		select {
+		case c := <-ln.conn:
+			return c, nil
+		case <-ln.closed:
+			return nil, fmt.Errorf("tsnet: %w", net.ErrClosed)
+		case <-deadline:
+			return nil, &net.OpError{Op: "accept", Net: ln.key.network, Addr: ln.Addr(), Err: os.ErrDeadlineExceeded}
+		case <-changed:
+			// SetDeadline was called; wait on the new deadline.
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
-	return c, nil
+}
+
+// deadlineChans returns the channel closed when the current deadline
+// passes, or nil if there is none, and the channel closed when the
+// deadline is next changed.
+func (ln *listener) deadlineChans() (deadline, changed <-chan struct{}) {
+	ln.dlMu.Lock()
+	defer ln.dlMu.Unlock()
+	if ln.dlChanged == nil {
+		ln.dlChanged = make(chan struct{})
+	}
+	return ln.deadline, ln.dlChanged
+}
+
+// SetDeadline sets the deadline for current and future Accept calls,
+// like (*net.TCPListener).SetDeadline. Once the deadline passes, Accept
+// returns an error wrapping os.ErrDeadlineExceeded. A zero value for t
+// means Accept will not time out.
+func (ln *listener) SetDeadline(t time.Time) error {
+	ln.dlMu.Lock()
+	defer ln.dlMu.Unlock()
+	if ln.dlChanged != nil {
+		// Wake blocked Accept calls to pick up the new deadline.
+		close(ln.dlChanged)
+		ln.dlChanged = nil
+	}
+	if ln.dlTimer != nil {
+		ln.dlTimer.Stop()
+		ln.dlTimer = nil
+	}
+	if t.IsZero() {
+		ln.deadline = nil
+		return nil
+	}
+	ch := make(chan struct{})
+	ln.deadline = ch
+	d := time.Until(t)
+	if d <= 0 {
+		close(ch)
+		return nil
+	}
+	ln.dlTimer = time.AfterFunc(d, func() { close(ch) })
+	return nil
 }
 
 func (ln *listener) Addr() net.Addr { return addr{ln} }
 func (ln *listener) Close() error {
 	ln.s.mu.Lock()
-	defer ln.s.mu.Unlock()
-	if v, ok := ln.s.listeners[ln.key]; ok && v == ln {
+	registered := false
+	if ln.isRange() {
+		if i := slices.Index(ln.s.rangeListeners, ln); i >= 0 {
+			ln.s.rangeListeners = slices.Delete(ln.s.rangeListeners, i, i+1)
+		}
+	} else if v, ok := ln.s.listeners[ln.key]; ok && v == ln {
 		delete(ln.s.listeners, ln.key)
-		close(ln.conn)
+		registered = true
+	}
+	ln.closeOnce.Do(func() { close(ln.closed) })
+	ln.s.mu.Unlock()
+	if registered && ln.opts.Funnel {
+		if err := ln.s.setFunnel(ln.key, false); err != nil {
+			ln.s.logf("tsnet: turning off Funnel for %v: %v", ln.addr, err)
+		}
 	}
 	return nil
 }
 
+// isRange reports whether ln listens on more than one port.
+func (ln *listener) isRange() bool { return ln.ports.First != ln.ports.Last }
+
+// allowed reports whether ln's ListenOpts permit a flow from src.
+func (ln *listener) allowed(src netip.AddrPort) bool {
+	if !ln.opts.restricted() {
+		return true
+	}
+	n, u, ok := ln.s.lb.WhoIs(src)
+	if !ok {
+		return false
+	}
+	if len(n.Tags) > 0 {
+		for _, tag := range n.Tags {
+			if slices.Contains(ln.opts.AllowedTags, tag) {
+				return true
+			}
+		}
+		return false
+	}
+	return slices.Contains(ln.opts.AllowedUsers, u.LoginName)
+}
+
 func (ln *listener) handle(c net.Conn) {
 	t := time.NewTimer(time.Second)
 	defer t.Stop()
 	select {
 	case ln.conn <- c:
+	case <-ln.closed:
+		c.Close()
 	case <-t.C:
 		// TODO(bradfitz): this isn't ideal. Think about how
 		// we how we want to do pushback.
@@ -841,3 +1639,40 @@ type addr struct{
 
 func (a addr) Network() string { return a.ln.key.network }
 func (a addr) String() string  { return a.ln.addr }
+
+// idleTimeoutConn is a net.Conn that closes itself after it has had no
+// reads or writes for timeout.
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+	timer   *time.Timer
+}
+
+func newIdleTimeoutConn(c net.Conn, timeout time.Duration) *idleTimeoutConn {
+	return &idleTimeoutConn{
+		Conn:    c,
+		timeout: timeout,
+		timer:   time.AfterFunc(timeout, func() { c.Close() }),
+	}
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.timer.Reset(c.timeout)
+	}
+	return n, err
+}
+
+func (c *idleTimeoutConn) Close() error {
+	c.timer.Stop()
+	return c.Conn.Close()
+}
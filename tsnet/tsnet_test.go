@@ -5,25 +5,39 @@
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/netip"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"golang.org/x/net/proxy"
+	"tailscale.com/client/tailscale/apitype"
+	"tailscale.com/ipn"
 	"tailscale.com/ipn/store/mem"
 	"tailscale.com/net/netns"
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest/integration"
 	"tailscale.com/tstest/integration/testcontrol"
 	"tailscale.com/types/logger"
+	"tailscale.com/types/netmap"
+	"tailscale.com/types/nettype"
 )
 
 // TestListener_Server ensures that the listener type always keeps the Server
@@ -37,6 +51,99 @@ func TestListener_Server(t *testing.T) {
 	}
 }
 
+func TestListenerAcceptDeadline(t *testing.T) {
+	newListener := func() *listener {
+		return &listener{
+			s:      &Server{},
+			key:    listenKey{network: "tcp", port: 80},
+			addr:   ":80",
+			conn:   make(chan net.Conn),
+			closed: make(chan struct{}),
+		}
+	}
+
+	ln := newListener()
+	ln.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	_, err := ln.Accept()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Accept after deadline = %v; want ErrDeadlineExceeded", err)
+	}
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Accept error %v is not a net.Error timeout", err)
+	}
+
+	// Clearing the deadline lets Accept receive connections again.
+	ln.SetDeadline(time.Time{})
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go ln.handle(c1)
+	if c, err := ln.Accept(); err != nil || c != c1 {
+		t.Fatalf("Accept = %v, %v; want pipe conn", c, err)
+	}
+
+	// Setting a deadline while Accept is blocked applies to that Accept.
+	acceptErr := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		acceptErr <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ln.SetDeadline(time.Now().Add(10 * time.Millisecond))
+	select {
+	case err := <-acceptErr:
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("blocked Accept after SetDeadline = %v; want ErrDeadlineExceeded", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked Accept ignored SetDeadline")
+	}
+	ln.SetDeadline(time.Time{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	if _, err := ln.AcceptContext(ctx); err != context.Canceled {
+		t.Fatalf("AcceptContext after cancel = %v; want context.Canceled", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := ln.Accept()
+		errc <- err
+	}()
+	ln.Close()
+	if err := <-errc; !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close = %v; want net.ErrClosed", err)
+	}
+
+	// Connections arriving after Close are closed, not delivered.
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	ln.handle(c3)
+	if _, err := c3.Write([]byte("x")); err == nil {
+		t.Error("connection handled after Close was not closed")
+	}
+}
+
+func TestControlStatus(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	before := time.Now()
+	s1, _ := startServer(t, ctx, controlURL, "s1")
+
+	st := s1.ControlStatus()
+	if !st.Connected || st.LastErr != nil {
+		t.Errorf("ControlStatus = %+v; want connected without error", st)
+	}
+	if st.LastNetMap.Before(before) || st.LastNetMap.After(time.Now()) {
+		t.Errorf("LastNetMap = %v; want between %v and now", st.LastNetMap, before)
+	}
+}
+
 func TestListenerPort(t *testing.T) {
 	errNone := errors.New("sentinel start error")
 
@@ -63,6 +170,13 @@ func TestListenerPort(t *testing.T) {
 		{"tcp6", "1.2.3.4:80", true},
 		{"tcp4", "[12::34]:80", true},
 		{"tcp6", "[12::34]:80", false},
+
+		// Port ranges
+		{"tcp", ":30000-30100", false},
+		{"udp", "100.102.104.108:30000-30100", false},
+		{"tcp", ":30100-30000", true},
+		{"tcp", ":30000-", true},
+		{"tcp", ":30000-70000", true},
 	}
 	for _, tt := range tests {
 		s := &Server{}
@@ -79,6 +193,12 @@ func TestListenerPort(t *testing.T) {
 var verboseNodes = flag.Bool("verbose-nodes", false, "if set, print tsnet.Server logs")
 
 func startControl(t *testing.T) (controlURL string) {
+	return startControlWithDNS(t, nil)
+}
+
+// startControlWithDNS is like startControl, but the control server sends
+// dnsCfg to the nodes.
+func startControlWithDNS(t *testing.T, dnsCfg *tailcfg.DNSConfig) (controlURL string) {
 	// Corp#4520: don't use netns for tests.
 	netns.SetEnabled(false)
 	t.Cleanup(func() {
@@ -91,7 +211,8 @@ func startControl(t *testing.T) (controlURL string) {
 	}
 	derpMap := integration.RunDERPAndSTUN(t, derpLogf, "127.0.0.1")
 	control := &testcontrol.Server{
-		DERPMap: derpMap,
+		DERPMap:   derpMap,
+		DNSConfig: dnsCfg,
 	}
 	control.HTTPTestServer = httptest.NewUnstartedServer(control)
 	control.HTTPTestServer.Start()
@@ -344,3 +465,773 @@ func TestTailscaleIPs(t *testing.T) {
 			sIp4, upIp4, sIp6, upIp6)
 	}
 }
+
+func TestHTTPSRedirectHandler(t *testing.T) {
+	var domains []string
+	h := httpsRedirectHandler(func() []string { return domains })
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://foo/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("no domains: code = %d; want 503", rec.Code)
+	}
+
+	domains = []string{"foo.tail-scale.ts.net", "foo.example.com"}
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"http://foo/", "https://foo.tail-scale.ts.net/"},
+		{"http://100.64.0.1/a/b?c=d", "https://foo.tail-scale.ts.net/a/b?c=d"},
+		{"http://FOO.example.com.:80/x", "https://foo.example.com/x"},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+		if rec.Code != http.StatusMovedPermanently {
+			t.Errorf("%s: code = %d; want 301", tt.url, rec.Code)
+		}
+		if got := rec.Header().Get("Location"); got != tt.want {
+			t.Errorf("%s: Location = %q; want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	h := HSTS(http.NotFoundHandler(), 365*24*time.Hour)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "http://foo/", nil))
+	if got := rec.Header().Get("Strict-Transport-Security"); got != "" {
+		t.Errorf("plain HTTP got HSTS header %q", got)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "https://foo/", nil))
+	if got, want := rec.Header().Get("Strict-Transport-Security"), "max-age=31536000"; got != want {
+		t.Errorf("HSTS header = %q; want %q", got, want)
+	}
+}
+
+func TestAppHostinfo(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1 := &Server{
+		Dir:        t.TempDir(),
+		ControlURL: controlURL,
+		Hostname:   "s1",
+		Store:      new(mem.Store),
+		Ephemeral:  true,
+		AppName:    "tsnet-test",
+		AppVersion: "1.2.3",
+	}
+	if !*verboseNodes {
+		s1.Logf = logger.Discard
+	}
+	defer s1.Close()
+	st, err := s1.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	// Hostinfo reaches peers through the control server.
+	for _, p := range s2.lb.NetMap().Peers {
+		if len(p.Addresses) == 0 || p.Addresses[0].Addr() != st.TailscaleIPs[0] {
+			continue
+		}
+		hi := p.Hostinfo
+		if hi.App() != "tsnet-test" || hi.AppVersion() != "1.2.3" {
+			t.Errorf("peer App, AppVersion = %q, %q; want tsnet-test, 1.2.3", hi.App(), hi.AppVersion())
+		}
+		return
+	}
+	t.Fatal("s1 not found in s2's netmap")
+}
+
+func TestServiceRecords(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, s2ip := startServer(t, ctx, controlURL, "s2")
+
+	// The PeerAPI rejects peers it doesn't know yet, so wait for s2 to
+	// show up in s1's netmap.
+	for {
+		if nm := s1.lb.NetMap(); nm != nil {
+			if _, ok := nm.PeerByTailscaleIP(s2ip); ok {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			t.Fatal("s2 never appeared in s1's netmap")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err := s1.SetServiceRecords(apitype.ServiceRecord{Service: "bad"}); err == nil {
+		t.Error("SetServiceRecords accepted an invalid service name")
+	}
+	want := []apitype.ServiceRecord{
+		{Service: "_http._tcp", Port: 8080, TXT: []string{"version=1.2.3"}},
+		{Service: "_metrics._tcp", Port: 9100},
+	}
+	if err := s1.SetServiceRecords(want...); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s2.LookupServices(ctx, s1ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupServices = %+v; want %+v", got, want)
+	}
+}
+
+func TestServeWebClient(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.ServeWebClient(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	url := fmt.Sprintf("http://%s:%d/", s1ip, webClientPort)
+	c := s2.HTTPClient()
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("GET status = %v; body: %s", res.Status, body)
+	}
+	if !strings.Contains(string(body), "read-only") {
+		t.Errorf("page doesn't mention read-only mode: %s", body)
+	}
+
+	res, err = c.Post(url, "application/json", strings.NewReader(`{"ForceLogout":true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusForbidden {
+		t.Errorf("POST status = %v; want 403", res.Status)
+	}
+}
+
+func TestListenWithOpts(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, s2ip := startServer(t, ctx, controlURL, "s2")
+
+	if _, err := s1.ListenWithOpts("tcp", ":1", ListenOpts{AllowedTags: []string{"admin"}}); err == nil {
+		t.Error("ListenWithOpts accepted an invalid tag")
+	}
+
+	// Wait for s1 to learn about s2 so it can identify it.
+	var s2Login string
+	for {
+		if _, u, ok := s1.lb.WhoIs(netip.AddrPortFrom(s2ip, 0)); ok {
+			s2Login = u.LoginName
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("s2 never appeared in s1's netmap")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	denied, err := s1.ListenWithOpts("tcp", ":8081", ListenOpts{AllowedUsers: []string{"nobody@example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer denied.Close()
+	if c, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8081", s1ip)); err == nil {
+		c.Close()
+		t.Error("Dial to listener restricted to another user succeeded")
+	}
+
+	ln, err := s1.ListenWithOpts("tcp", ":8082", ListenOpts{AllowedUsers: []string{s2Login}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:8082", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+}
+
+func TestListenPortRange(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.Listen("tcp", ":30000-30002")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	for _, addr := range []string{":30002", ":29999-30000", ":30001-30005"} {
+		if ln, err := s1.Listen("tcp", addr); err == nil {
+			ln.Close()
+			t.Errorf("Listen(%q) overlapping a port range succeeded", addr)
+		}
+	}
+	exact, err := s1.Listen("tcp", ":30003")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exact.Close()
+
+	w, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:30001", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	r, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if got := r.LocalAddr().(*net.TCPAddr).Port; got != 30001 {
+		t.Errorf("accepted conn LocalAddr port = %d; want 30001", got)
+	}
+
+	ln.Close()
+	if c, err := s2.Dial(ctx, "tcp", fmt.Sprintf("%s:30002", s1ip)); err == nil {
+		c.Close()
+		t.Error("Dial to closed port range listener succeeded")
+	}
+}
+
+func TestFunnelListener(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, _ := startServer(t, ctx, controlURL, "s1")
+
+	if _, err := s1.ListenWithOpts("tcp", ":8080", ListenOpts{Funnel: true}); err == nil {
+		t.Error("Funnel listener on port 8080 succeeded")
+	}
+	ln, err := s1.ListenWithOpts("tcp", ":443", ListenOpts{Funnel: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := ipn.HostPort(strings.TrimSuffix(s1.lb.NetMap().SelfNode.Name, ".") + ":443")
+	if !s1.lb.ServeConfig().AllowFunnel().Get(target) {
+		t.Fatalf("Funnel not enabled for %v", target)
+	}
+
+	src := netip.MustParseAddrPort("203.0.113.1:1234")
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	go s1.lb.HandleIngressTCPConn(nil, target, src, func() (net.Conn, bool) { return c1, true }, func() {})
+	c, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsFunnelConn(c) {
+		t.Fatalf("Accept returned %T; want *FunnelConn", c)
+	}
+	if fc := c.(*FunnelConn); fc.Src != src || fc.Target != target {
+		t.Errorf("FunnelConn Src, Target = %v, %v; want %v, %v", fc.Src, fc.Target, src, target)
+	}
+	if got := c.RemoteAddr().String(); got != src.String() {
+		t.Errorf("RemoteAddr = %v; want %v", got, src)
+	}
+	c.Close()
+
+	ln.Close()
+	if s1.lb.ServeConfig().AllowFunnel().Get(target) {
+		t.Errorf("Funnel still enabled for %v after Close", target)
+	}
+}
+
+func TestIdleTimeoutConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := newIdleTimeoutConn(c1, 100*time.Millisecond)
+
+	// Activity keeps the connection open past the timeout.
+	go io.Copy(io.Discard, c2)
+	for i := 0; i < 4; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if _, err := c.Write([]byte("x")); err != nil {
+			t.Fatalf("Write %d: %v", i, err)
+		}
+	}
+
+	// Then idleness closes it.
+	time.Sleep(300 * time.Millisecond)
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("Write after idle timeout succeeded")
+	}
+}
+
+// addrConn is a fake net.Conn that records the address it was dialed to.
+type addrConn struct {
+	net.Conn
+	ipp netip.AddrPort
+}
+
+func TestDialParallel(t *testing.T) {
+	v4 := netip.MustParseAddrPort("100.64.0.1:80")
+	v6 := netip.MustParseAddrPort("[fd7a:115c:a1e0::1]:80")
+	addrs := []netip.AddrPort{v4, v6}
+	errRefused := errors.New("refused")
+
+	// dial returns a conn tagged with the address it dialed, after the
+	// given delay for that address, or an error if fail is set.
+	type behavior struct {
+		delay time.Duration
+		fail  bool
+	}
+	dialer := func(b map[netip.AddrPort]behavior) func(context.Context, netip.AddrPort) (net.Conn, error) {
+		return func(ctx context.Context, ipp netip.AddrPort) (net.Conn, error) {
+			select {
+			case <-time.After(b[ipp].delay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if b[ipp].fail {
+				return nil, errRefused
+			}
+			return addrConn{ipp: ipp}, nil
+		}
+	}
+	gotAddr := func(c net.Conn) netip.AddrPort { return c.(addrConn).ipp }
+
+	tests := []struct {
+		name    string
+		b       map[netip.AddrPort]behavior
+		want    netip.AddrPort
+		wantErr bool
+	}{
+		{"first-fast", map[netip.AddrPort]behavior{v6: {delay: time.Second}}, v4, false},
+		{"first-slow", map[netip.AddrPort]behavior{v4: {delay: time.Second}}, v6, false},
+		{"first-fails", map[netip.AddrPort]behavior{v4: {fail: true}}, v6, false},
+		{"all-fail", map[netip.AddrPort]behavior{v4: {fail: true}, v6: {fail: true}}, netip.AddrPort{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := dialParallel(context.Background(), addrs, 20*time.Millisecond, dialer(tt.b))
+			if tt.wantErr {
+				if err != errRefused {
+					t.Fatalf("err = %v; want %v", err, errRefused)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := gotAddr(c); got != tt.want {
+				t.Errorf("dialed %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPeerDialAddrs(t *testing.T) {
+	nm := &netmap.NetworkMap{
+		Name: "self.tail-scale.ts.net.",
+		Peers: []*tailcfg.Node{{
+			Name: "peer.tail-scale.ts.net.",
+			Addresses: []netip.Prefix{
+				netip.MustParsePrefix("100.64.0.2/32"),
+				netip.MustParsePrefix("fd7a:115c:a1e0::2/128"),
+			},
+		}},
+	}
+	v4 := netip.MustParseAddrPort("100.64.0.2:80")
+	v6 := netip.MustParseAddrPort("[fd7a:115c:a1e0::2]:80")
+	tests := []struct {
+		network, address string
+		want             []netip.AddrPort
+	}{
+		{"tcp", "peer:80", []netip.AddrPort{v4, v6}},
+		{"tcp", "PEER.tail-scale.ts.net.:80", []netip.AddrPort{v4, v6}},
+		{"tcp4", "peer:80", []netip.AddrPort{v4}},
+		{"tcp6", "peer:80", []netip.AddrPort{v6}},
+		{"udp", "peer:80", nil},
+		{"tcp", "100.64.0.2:80", nil},
+		{"tcp", "other:80", nil},
+	}
+	for _, tt := range tests {
+		got := peerDialAddrs(nm, tt.network, tt.address)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("peerDialAddrs(%q, %q) = %v; want %v", tt.network, tt.address, got, tt.want)
+		}
+	}
+}
+
+func TestListenPacket(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	lc2, err := s2.LocalClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lc2.Ping(ctx, s1ip, tailcfg.PingICMP); err != nil {
+		t.Fatal(err)
+	}
+
+	pc, err := s1.ListenPacket("udp", ":5353")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	// A past read deadline fails reads right away.
+	pc.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, _, err := pc.ReadFrom(make([]byte, 10)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("ReadFrom with past deadline: %v; want os.ErrDeadlineExceeded", err)
+	}
+	pc.SetReadDeadline(time.Now().Add(10 * time.Second))
+
+	c, err := s2.Dial(ctx, "udp", fmt.Sprintf("%s:5353", s1ip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := io.WriteString(c, "ping"); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 100)
+	n, src, dst, err := pc.(nettype.DstPacketConn).ReadFromDst(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "ping" {
+		t.Errorf("read %q; want %q", got, "ping")
+	}
+	if want := netip.AddrPortFrom(s1ip, 5353); dst != want {
+		t.Errorf("dst = %v; want %v", dst, want)
+	}
+	if _, err := pc.WriteTo([]byte("pong"), net.UDPAddrFromAddrPort(src)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.WriteTo([]byte("pong"), net.UDPAddrFromAddrPort(netip.MustParseAddrPort("100.64.99.99:53"))); err == nil {
+		t.Error("WriteTo to an address with no flow succeeded")
+	}
+
+	c.SetReadDeadline(time.Now().Add(10 * time.Second))
+	n, err = c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "pong" {
+		t.Errorf("reply %q; want %q", got, "pong")
+	}
+}
+
+func TestCertManagerCache(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	writeCert := func(domain string, notAfter time.Time) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: domain},
+			DNSNames:     []string{domain},
+			NotBefore:    now.Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keyPEM, err := encodeECKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if err := os.WriteFile(filepath.Join(dir, domain+".crt"), certPEM, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, domain+".key"), keyPEM, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeCert("valid.example.com", now.Add(60*24*time.Hour))
+	writeCert("expired.example.com", now.Add(-time.Minute))
+
+	m := &CertManager{Domains: []string{"valid.example.com", "expired.example.com"}}
+	ctx := context.Background()
+	c, err := m.cert(ctx, dir, "valid.example.com", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Leaf.Subject.CommonName; got != "valid.example.com" {
+		t.Errorf("got cert for %q; want valid.example.com", got)
+	}
+	if m.certs["valid.example.com"] != c {
+		t.Error("cert not cached in memory")
+	}
+
+	// An expired cert needs a new one from the CA, which fails without
+	// a Solver.
+	if _, err := m.cert(ctx, dir, "expired.example.com", now); err == nil || !strings.Contains(err.Error(), "Solver") {
+		t.Errorf("expired cert: got err %v; want Solver error", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(WithDir(dir), WithHostname("app"), WithEphemeral(), WithStore(new(mem.Store)), WithApp("app", "1.0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dir != dir || s.Hostname != "app" || !s.Ephemeral || s.AppName != "app" || s.AppVersion != "1.0" {
+		t.Errorf("New set fields wrong: %+v", s)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{"mem_store_not_ephemeral", []Option{WithStore(new(mem.Store))}, "in-memory store is only supported for Ephemeral nodes"},
+		{"dir_not_dir", []Option{WithDir(file)}, "is not a directory"},
+		{"dir_missing", []Option{WithDir(filepath.Join(dir, "missing"))}, "no such file or directory"},
+		{"control_url", []Option{WithControlURL("controlplane.example.com")}, `invalid ControlURL "controlplane.example.com"`},
+		{"app_version", []Option{WithApp("", "1.0")}, "AppVersion requires AppName"},
+		{"keepalive", []Option{WithDialKeepAlive(-time.Second)}, "negative DialKeepAlive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.opts...)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.HasPrefix(err.Error(), "tsnet: ") {
+				t.Fatalf("New = %v, %v; want error containing %q", s, err, tt.wantErr)
+			}
+		})
+	}
+
+	// Both problems are reported at once.
+	_, err = New(WithStore(new(mem.Store)), WithDialIdleTimeout(-time.Second))
+	if err == nil || !strings.Contains(err.Error(), "Ephemeral") || !strings.Contains(err.Error(), "negative DialIdleTimeout") {
+		t.Errorf("New = %v; want both errors", err)
+	}
+}
+
+func TestListenGroup(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, _ := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	if _, err := s1.ListenGroup("443", ListenGroupOpts{HTTPS: true}); err == nil {
+		t.Error("ListenGroup accepted port 443 with HTTPS")
+	}
+	if _, err := s1.ListenGroup("80", ListenGroupOpts{ListenOpts: ListenOpts{Funnel: true}}); err == nil {
+		t.Error("ListenGroup accepted Funnel")
+	}
+
+	ln, err := s1.ListenGroup("8083", ListenGroupOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip4, ip6 := s1.TailscaleIPs()
+	for _, ip := range []netip.Addr{ip4, ip6} {
+		w, err := s2.Dial(ctx, "tcp", netip.AddrPortFrom(ip, 8083).String())
+		if err != nil {
+			t.Fatalf("Dial %v: %v", ip, err)
+		}
+		r, err := ln.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := r.LocalAddr().(*net.TCPAddr).AddrPort().Addr(); got != ip {
+			t.Errorf("accepted connection to %v; want %v", got, ip)
+		}
+		r.Close()
+		w.Close()
+	}
+
+	ln.Close()
+	if _, err := ln.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Fatalf("Accept after Close = %v; want net.ErrClosed", err)
+	}
+	// Closing the group closed its listeners, so the port is free again.
+	ln, err = s1.ListenGroup("8083", ListenGroupOpts{})
+	if err != nil {
+		t.Fatalf("ListenGroup after Close: %v", err)
+	}
+	ln.Close()
+}
+
+func TestServeDNS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControl(t)
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	if _, err := s1.ServeDNS(nil, "com"); err == nil {
+		t.Error("ServeDNS accepted a top-level domain")
+	}
+	h := func(ctx context.Context, q []byte, from netip.AddrPort) ([]byte, error) {
+		return append([]byte("re:"), q...), nil
+	}
+	ds, err := s1.ServeDNS(h, "svc.example.ts.net.", "SVC.example.ts.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ds.Close()
+
+	// The domains reach peers through the control server.
+	for {
+		if p, ok := s2.lb.NetMap().PeerByTailscaleIP(s1ip); ok {
+			got := p.Hostinfo.DNSDomains().AsSlice()
+			if reflect.DeepEqual(got, []string{"svc.example.ts.net"}) {
+				break
+			}
+		}
+		if ctx.Err() != nil {
+			t.Fatal("s1's DNS domains never reached s2")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	uc, err := s2.Dial(ctx, "udp", net.JoinHostPort(s1ip.String(), "53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer uc.Close()
+	if _, err := io.WriteString(uc, "udp-query"); err != nil {
+		t.Fatal(err)
+	}
+	uc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 100)
+	n, err := uc.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(buf[:n]), "re:udp-query"; got != want {
+		t.Errorf("UDP response = %q; want %q", got, want)
+	}
+
+	tc, err := s2.Dial(ctx, "tcp", net.JoinHostPort(s1ip.String(), "53"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tc.Close()
+	if _, err := tc.Write(append([]byte{0, 9}, "tcp-query"...)); err != nil {
+		t.Fatal(err)
+	}
+	tc.SetReadDeadline(time.Now().Add(10 * time.Second))
+	resp := make([]byte, 2+len("re:tcp-query"))
+	if _, err := io.ReadFull(tc, resp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(resp), "\x00\x0cre:tcp-query"; got != want {
+		t.Errorf("TCP response = %q; want %q", got, want)
+	}
+
+	ds.Close()
+	if got := s1.lb.DNSServerDomains(); len(got) != 0 {
+		t.Errorf("DNS domains after Close = %q; want none", got)
+	}
+}
+
+var (
+	_ proxy.Dialer        = (*Dialer)(nil)
+	_ proxy.ContextDialer = (*Dialer)(nil)
+)
+
+func TestDialerAndResolver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	controlURL := startControlWithDNS(t, &tailcfg.DNSConfig{
+		ExtraRecords: []tailcfg.DNSRecord{{Name: "db.example.ts.net", Value: "100.64.99.1"}},
+	})
+	s1, s1ip := startServer(t, ctx, controlURL, "s1")
+	s2, _ := startServer(t, ctx, controlURL, "s2")
+
+	ln, err := s1.Listen("tcp", ":8081")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			io.WriteString(c, "hello")
+			c.Close()
+		}
+	}()
+
+	addrs, err := s2.Resolver().LookupHost(ctx, "db.example.ts.net")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"100.64.99.1"}; !reflect.DeepEqual(addrs, want) {
+		t.Errorf("LookupHost = %q; want %q", addrs, want)
+	}
+
+	addr := net.JoinHostPort(s1ip.String(), "8081")
+	dials := map[string]func() (net.Conn, error){
+		"DialTCP":     func() (net.Conn, error) { return s2.DialTCP(ctx, addr) },
+		"Dial":        func() (net.Conn, error) { return s2.Dialer().Dial("tcp", addr) },
+		"DialTimeout": func() (net.Conn, error) { return s2.Dialer().DialTimeout("tcp", addr, 10*time.Second) },
+		"DialContext": func() (net.Conn, error) { return s2.Dialer().DialContext(ctx, "tcp", addr) },
+	}
+	for name, dial := range dials {
+		c, err := dial()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := io.ReadAll(c)
+		c.Close()
+		if err != nil || string(got) != "hello" {
+			t.Errorf("%s: read %q, %v; want %q", name, got, err, "hello")
+		}
+	}
+}
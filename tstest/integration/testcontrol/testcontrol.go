@@ -376,6 +376,25 @@ func (s *Server) getUser(nodeKey key.NodePublic) (*tailcfg.User, *tailcfg.Login)
 	return user, login
 }
 
+// allUserProfiles returns the profiles of all users, sorted by ID.
+func (s *Server) allUserProfiles() (res []tailcfg.UserProfile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, u := range s.users {
+		up := tailcfg.UserProfile{
+			ID:          u.ID,
+			LoginName:   u.LoginName,
+			DisplayName: u.DisplayName,
+		}
+		if l, ok := s.logins[k]; ok {
+			up.ProfilePicURL = l.ProfilePicURL
+		}
+		res = append(res, up)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
 // authPathDone returns a close-only struct that's closed when the
 // authPath ("/auth/XXXXXX") has authenticated.
 func (s *Server) authPathDone(authPath string) <-chan struct{} {
@@ -761,6 +780,7 @@ func (s *Server) MapResponse(req *tailcfg.MapRequest) (res *tailcfg.MapResponse,
 	sort.Slice(res.Peers, func(i, j int) bool {
 		return res.Peers[i].ID < res.Peers[j].ID
 	})
+	res.UserProfiles = s.allUserProfiles()
 
 	v4Prefix := netip.PrefixFrom(netaddr.IPv4(100, 64, uint8(tailcfg.NodeID(user.ID)>>8), uint8(tailcfg.NodeID(user.ID))), 32)
 	v6Prefix := netip.PrefixFrom(tsaddr.Tailscale4To6(v4Prefix.Addr()), 128)
@@ -5,6 +5,20 @@
 // in-memory without running VMs or requiring root, etc. Despite the
 // name, it does more than just NATs. But NATs are the most
 // interesting.
+//
+// A network is built from Machines, attached to Networks with Interfaces.
+// A Machine's PacketHandler, such as a SNAT44 or Firewall, gives it the
+// behavior of a NAT or firewall. A Machine is a nettype.PacketListener,
+// so code under test, like magicsock, can send its UDP packets through the
+// simulated network.
+//
+// For NAT traversal tests, NewPair builds the common topology of two
+// nodes on the internet, each on a network of one of the canned
+// NATProfiles (full cone, symmetric, CGNAT, UDP blocked, etc.). Its Probe
+// method simulates how the nodes establish a direct path, which the
+// natlab command runs for any pair of profiles:
+//
+//	go run tailscale.com/cmd/natlab --a=symmetric --b=cgnat
 package natlab
 
 import (
@@ -507,3 +507,50 @@ func testNAT(t *testing.T, n *SNAT44, lanIf, wanIf *Interface, tests []natTest)
 		}
 	}
 }
+
+func TestPairProbe(t *testing.T) {
+	tests := []struct {
+		a, b       NATProfile
+		wantDirect bool
+	}{
+		{NoNAT, NoNAT, true},
+		{FullConeNAT, SymmetricNAT, true},
+		{PortRestrictedNAT, PortRestrictedNAT, true},
+		{CGNAT, PortRestrictedNAT, true},
+		{SymmetricNAT, NoNAT, true},
+		{SymmetricNAT, PortRestrictedNAT, false},
+		{SymmetricNAT, SymmetricNAT, false},
+		{UDPBlocked, NoNAT, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s-%s", tt.a, tt.b), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			res, err := NewPair(tt.a, tt.b).Probe(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Direct(); got != tt.wantDirect {
+				t.Errorf("Direct = %v; want %v (result: %+v)", got, tt.wantDirect, res)
+			}
+			if got, want := MappingVariesByDest(res.AEndpoints), tt.a == SymmetricNAT; got != want {
+				t.Errorf("A mapping varies = %v; want %v (endpoints %v)", got, want, res.AEndpoints)
+			}
+			if got, want := len(res.AEndpoints) == 0, tt.a == UDPBlocked; got != want {
+				t.Errorf("A got no endpoints = %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseNATProfile(t *testing.T) {
+	for _, p := range NATProfiles {
+		got, err := ParseNATProfile(string(p))
+		if err != nil || got != p {
+			t.Errorf("ParseNATProfile(%q) = %q, %v", p, got, err)
+		}
+	}
+	if _, err := ParseNATProfile("cone"); err == nil {
+		t.Error("ParseNATProfile(cone) succeeded")
+	}
+}
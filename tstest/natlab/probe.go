@@ -0,0 +1,176 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlab
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+// The ports that the STUN server of Pair.Probe listens on. There are two, to
+// detect NATs whose mappings vary by destination port.
+const (
+	probeSTUNPort  = 3478
+	probeSTUNPort2 = 3479
+)
+
+// ProbeResult is the result of Pair.Probe.
+type ProbeResult struct {
+	// AEndpoints and BEndpoints are the ip:ports of A and B as seen
+	// from the internet, as reported by the STUN server on each of its
+	// two ports. They're empty if no STUN reply was received, such as if
+	// UDP is blocked.
+	AEndpoints, BEndpoints []netip.AddrPort
+
+	// AToB is whether A got a reply from B to a ping sent to one of B's
+	// endpoints, and BToA the reverse.
+	AToB, BToA bool
+}
+
+// Direct reports whether A and B could establish a direct path in either
+// direction. Once either receives the other's ping, it replies to its
+// source, so one direction is enough.
+func (r *ProbeResult) Direct() bool { return r.AToB || r.BToA }
+
+// MappingVariesByDest reports whether eps, some AEndpoints or
+// BEndpoints, show a NAT whose mapping varies by destination.
+func MappingVariesByDest(eps []netip.AddrPort) bool {
+	return len(eps) > 1 && eps[0] != eps[1]
+}
+
+// Probe simulates how Tailscale nodes establish a direct path: A and B
+// first learn their endpoints from the STUN server, then both repeatedly
+// send pings to each other's endpoints, replying to each ping they get
+// with a pong to its source.
+func (p *Pair) Probe(ctx context.Context) (*ProbeResult, error) {
+	for _, port := range []uint16{probeSTUNPort, probeSTUNPort2} {
+		pc, err := p.STUN.ListenPacket(ctx, "udp", netip.AddrPortFrom(p.STUNIP, port).String())
+		if err != nil {
+			return nil, err
+		}
+		defer pc.Close()
+		go serveProbeSTUN(pc)
+	}
+
+	a, err := newProbeNode(ctx, p.A, p.AIP)
+	if err != nil {
+		return nil, err
+	}
+	defer a.pc.Close()
+	b, err := newProbeNode(ctx, p.B, p.BIP)
+	if err != nil {
+		return nil, err
+	}
+	defer b.pc.Close()
+
+	res := &ProbeResult{}
+	for _, port := range []uint16{probeSTUNPort, probeSTUNPort2} {
+		stun := netip.AddrPortFrom(p.STUNIP, port)
+		if ep, ok := a.stun(ctx, stun); ok {
+			res.AEndpoints = append(res.AEndpoints, ep)
+		}
+		if ep, ok := b.stun(ctx, stun); ok {
+			res.BEndpoints = append(res.BEndpoints, ep)
+		}
+	}
+
+	const rounds = 10
+	for i := 0; i < rounds && !(a.gotPong.Load() && b.gotPong.Load()); i++ {
+		for _, ep := range res.BEndpoints {
+			a.pc.WriteTo([]byte("ping"), net.UDPAddrFromAddrPort(ep))
+		}
+		for _, ep := range res.AEndpoints {
+			b.pc.WriteTo([]byte("ping"), net.UDPAddrFromAddrPort(ep))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	res.AToB = a.gotPong.Load()
+	res.BToA = b.gotPong.Load()
+	return res, nil
+}
+
+// serveProbeSTUN replies to each packet received on pc with the packet's
+// source ip:port, until pc is closed.
+func serveProbeSTUN(pc net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		_, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		pc.WriteTo([]byte(addr.String()), addr)
+	}
+}
+
+// probeNode is the state of a node in Pair.Probe.
+type probeNode struct {
+	pc      net.PacketConn
+	stunRes chan [2]netip.AddrPort // STUN server, reported endpoint
+	gotPong atomic.Bool
+}
+
+func newProbeNode(ctx context.Context, m *Machine, ip netip.Addr) (*probeNode, error) {
+	pc, err := m.ListenPacket(ctx, "udp", netip.AddrPortFrom(ip, 0).String())
+	if err != nil {
+		return nil, err
+	}
+	n := &probeNode{
+		pc:      pc,
+		stunRes: make(chan [2]netip.AddrPort, 1),
+	}
+	go n.read()
+	return n, nil
+}
+
+// read handles the packets received by n, until n.pc is closed.
+func (n *probeNode) read() {
+	buf := make([]byte, 1500)
+	for {
+		nr, addr, err := n.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		switch msg := string(buf[:nr]); msg {
+		case "ping":
+			n.pc.WriteTo([]byte("pong"), addr)
+		case "pong":
+			n.gotPong.Store(true)
+		default:
+			ep, err := netip.ParseAddrPort(msg)
+			if err != nil {
+				continue
+			}
+			select {
+			case n.stunRes <- [2]netip.AddrPort{addr.(*net.UDPAddr).AddrPort(), ep}:
+			default:
+			}
+		}
+	}
+}
+
+// stun returns n's endpoint as seen by the STUN server at stun, and
+// whether it got a reply.
+func (n *probeNode) stun(ctx context.Context, stun netip.AddrPort) (netip.AddrPort, bool) {
+	for i := 0; i < 3; i++ {
+		n.pc.WriteTo([]byte("stun"), net.UDPAddrFromAddrPort(stun))
+		select {
+		case res := <-n.stunRes:
+			if res[0] == stun {
+				return res[1], true
+			}
+			// A late reply from a previous server; retry.
+		case <-ctx.Done():
+			return netip.AddrPort{}, false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return netip.AddrPort{}, false
+}
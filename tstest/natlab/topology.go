@@ -0,0 +1,147 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package natlab
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// A NATProfile is a canned type of network that a node can be on, with the
+// NAT and firewall behavior commonly found in the wild.
+type NATProfile string
+
+const (
+	// NoNAT is a node with a public IP and no firewall.
+	NoNAT NATProfile = "none"
+	// FullConeNAT is a NAT with endpoint-independent mapping and
+	// filtering: once a LAN ip:port sends a packet, anyone can reach
+	// it via its single WAN ip:port.
+	FullConeNAT NATProfile = "fullcone"
+	// PortRestrictedNAT is the typical home router: a NAT with
+	// endpoint-independent mapping, but address-and-port-dependent
+	// filtering.
+	PortRestrictedNAT NATProfile = "portrestricted"
+	// SymmetricNAT is a "hard" NAT with address-and-port-dependent
+	// mapping and filtering, as found on many corporate firewalls.
+	SymmetricNAT NATProfile = "symmetric"
+	// CGNAT is a PortRestrictedNAT home router whose WAN side is itself
+	// behind a carrier-grade NAT, on 100.64.0.0/24, with
+	// endpoint-independent mapping and address-and-port-dependent
+	// filtering.
+	CGNAT NATProfile = "cgnat"
+	// UDPBlocked is a network whose firewall lets no UDP out.
+	UDPBlocked NATProfile = "udpblocked"
+)
+
+// NATProfiles are all the NATProfiles, from the easiest to traverse to the
+// hardest.
+var NATProfiles = []NATProfile{NoNAT, FullConeNAT, PortRestrictedNAT, CGNAT, SymmetricNAT, UDPBlocked}
+
+// ParseNATProfile parses the name of one of the NATProfiles.
+func ParseNATProfile(s string) (NATProfile, error) {
+	for _, p := range NATProfiles {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	var names []string
+	for _, p := range NATProfiles {
+		names = append(names, string(p))
+	}
+	return "", fmt.Errorf("unknown NAT profile %q; want one of %s", s, strings.Join(names, ", "))
+}
+
+// A Pair is a simulated topology of two nodes, A and B, on the internet,
+// each on a network of some NATProfile, with a STUN server on the
+// internet.
+type Pair struct {
+	Internet *Network
+
+	STUN   *Machine
+	STUNIP netip.Addr
+
+	A, B     *Machine
+	AIP, BIP netip.Addr // the nodes' own (LAN) IPs
+}
+
+// NewPair returns the topology of a node A on a network of profile a and
+// a node B on one of profile b.
+func NewPair(a, b NATProfile) *Pair {
+	inet := NewInternet()
+	stun := &Machine{Name: "stun"}
+	p := &Pair{
+		Internet: inet,
+		STUN:     stun,
+		STUNIP:   stun.Attach("eth0", inet).V4(),
+	}
+	p.A, p.AIP = newNode("a", a, inet, 0)
+	p.B, p.BIP = newNode("b", b, inet, 1)
+	return p
+}
+
+// newNode returns a node named name, on a network of profile prof attached
+// to inet, and its IP. The node's LAN is 192.168.<n>.0/24.
+func newNode(name string, prof NATProfile, inet *Network, n int) (*Machine, netip.Addr) {
+	m := &Machine{Name: name}
+	if prof == NoNAT {
+		return m, m.Attach("eth0", inet).V4()
+	}
+	lan := &Network{
+		Name:    name + "-lan",
+		Prefix4: netip.MustParsePrefix(fmt.Sprintf("192.168.%d.0/24", n)),
+	}
+	nat := &Machine{Name: name + "-nat"}
+	wan := inet
+	if prof == CGNAT {
+		wan = &Network{
+			Name:    name + "-carrier",
+			Prefix4: netip.MustParsePrefix("100.64.0.0/24"),
+		}
+		carrier := &Machine{Name: name + "-cgnat"}
+		carrierWAN := carrier.Attach("wan", inet)
+		carrierLAN := carrier.Attach("lan", wan)
+		wan.SetDefaultGateway(carrierLAN)
+		carrier.PacketHandler = newSNAT44(carrier, carrierWAN, carrierLAN, EndpointIndependentNAT, AddressAndPortDependentFirewall)
+	}
+	natWAN := nat.Attach("wan", wan)
+	natLAN := nat.Attach("lan", lan)
+	lan.SetDefaultGateway(natLAN)
+	switch prof {
+	case FullConeNAT:
+		nat.PacketHandler = newSNAT44(nat, natWAN, natLAN, EndpointIndependentNAT, EndpointIndependentFirewall)
+	case PortRestrictedNAT, CGNAT:
+		nat.PacketHandler = newSNAT44(nat, natWAN, natLAN, EndpointIndependentNAT, AddressAndPortDependentFirewall)
+	case SymmetricNAT:
+		nat.PacketHandler = newSNAT44(nat, natWAN, natLAN, AddressAndPortDependentNAT, AddressAndPortDependentFirewall)
+	case UDPBlocked:
+		nat.PacketHandler = dropForwarded{}
+	default:
+		panic(fmt.Sprintf("unknown NAT profile %q", prof))
+	}
+	return m, m.Attach("eth0", lan).V4()
+}
+
+func newSNAT44(m *Machine, wan, lan *Interface, nt NATType, ft FirewallType) *SNAT44 {
+	return &SNAT44{
+		Machine:           m,
+		ExternalInterface: wan,
+		Type:              nt,
+		Firewall: &Firewall{
+			Type:             ft,
+			TrustedInterface: lan,
+		},
+	}
+}
+
+// dropForwarded is a PacketHandler that drops all forwarded packets.
+type dropForwarded struct{}
+
+func (dropForwarded) HandleOut(p *Packet, oif *Interface) *Packet { return p }
+func (dropForwarded) HandleIn(p *Packet, iif *Interface) *Packet  { return p }
+func (dropForwarded) HandleForward(p *Packet, iif, oif *Interface) *Packet {
+	p.Trace("udp blocked")
+	return nil
+}
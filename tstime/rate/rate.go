@@ -54,12 +54,21 @@ func NewLimiter(r Limit, b int) *Limiter {
 	return &Limiter{limit: r, burst: float64(b)}
 }
 
-// AllowN reports whether an event may happen now.
+// Allow reports whether an event may happen now.
 func (lim *Limiter) Allow() bool {
-	return lim.allow(mono.Now())
+	return lim.allowN(mono.Now(), 1)
+}
+
+// AllowN reports whether n events may happen now, consuming n tokens if so.
+func (lim *Limiter) AllowN(n int) bool {
+	return lim.allowN(mono.Now(), n)
 }
 
 func (lim *Limiter) allow(now mono.Time) bool {
+	return lim.allowN(now, 1)
+}
+
+func (lim *Limiter) allowN(now mono.Time, n int) bool {
 	lim.mu.Lock()
 	defer lim.mu.Unlock()
 
@@ -75,8 +84,8 @@ func (lim *Limiter) allow(now mono.Time) bool {
 		tokens = lim.burst
 	}
 
-	// Consume a token.
-	tokens--
+	// Consume the tokens.
+	tokens -= float64(n)
 
 	// Update state.
 	ok := tokens >= 0
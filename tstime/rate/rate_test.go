@@ -190,3 +190,19 @@ func BenchmarkAllowN(b *testing.B) {
 		}
 	})
 }
+
+func TestLimiterAllowN(t *testing.T) {
+	lim := NewLimiter(10, 5)
+	if !lim.allowN(t0, 3) {
+		t.Fatal("first 3 not allowed")
+	}
+	if lim.allowN(t0, 3) {
+		t.Fatal("3 more allowed with 2 tokens left")
+	}
+	if !lim.allowN(t0, 2) {
+		t.Fatal("remaining 2 not allowed")
+	}
+	if !lim.allowN(t1, 1) {
+		t.Fatal("refilled token not allowed")
+	}
+}
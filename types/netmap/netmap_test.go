@@ -5,8 +5,11 @@
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"net/netip"
+	"strings"
 	"testing"
+	"time"
 
 	"go4.org/mem"
 	"tailscale.com/net/netaddr"
@@ -288,3 +291,67 @@ func TestConciseDiffFrom(t *testing.T) {
 		})
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	now := time.Date(2023, 2, 1, 12, 0, 0, 0, time.UTC)
+	nm := &NetworkMap{
+		NodeKey:    testNodeKey(1),
+		PrivateKey: key.NewNode(),
+		Name:       "self.tail-scale.ts.net.",
+		Addresses:  []netip.Prefix{netip.MustParsePrefix("100.64.0.1/32")},
+		Domain:     "example.com",
+		Peers: []*tailcfg.Node{
+			{
+				StableID:      "peer",
+				Name:          "peer.tail-scale.ts.net.",
+				Key:           testNodeKey(2),
+				Addresses:     []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32")},
+				AllowedIPs:    []netip.Prefix{netip.MustParsePrefix("100.64.0.2/32"), netip.MustParsePrefix("10.0.0.0/24")},
+				PrimaryRoutes: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")},
+				DERP:          "127.3.3.40:2",
+				Endpoints:     []string{"203.0.113.5:41641"},
+				Hostinfo:      (&tailcfg.Hostinfo{Hostname: "peer", RoutableIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24")}}).View(),
+			},
+		},
+		UserProfiles: map[tailcfg.UserID]tailcfg.UserProfile{
+			2: {ID: 2, LoginName: "b@example.com"},
+			1: {ID: 1, LoginName: "a@example.com"},
+		},
+	}
+	s := nm.Snapshot(now)
+	if s.Version != SnapshotVersion || !s.Created.Equal(now) || s.Domain != "example.com" {
+		t.Errorf("header = %v, %v, %q", s.Version, s.Created, s.Domain)
+	}
+	if s.Self.Name != nm.Name || len(s.Self.Addresses) != 1 {
+		t.Errorf("Self = %+v", s.Self)
+	}
+	if len(s.Peers) != 1 {
+		t.Fatalf("got %d peers; want 1", len(s.Peers))
+	}
+	p := s.Peers[0]
+	if p.ID != "peer" || p.Hostname != "peer" || p.DERP != "127.3.3.40:2" || len(p.PrimaryRoutes) != 1 || len(p.AdvertisedRoutes) != 1 {
+		t.Errorf("peer = %+v", p)
+	}
+	if p.KeyHash == "" || p.KeyHash == s.Self.KeyHash {
+		t.Errorf("key hashes %q and %q; want distinct", p.KeyHash, s.Self.KeyHash)
+	}
+	if len(s.UserProfiles) != 2 || s.UserProfiles[0].ID != 1 {
+		t.Errorf("UserProfiles = %v; want sorted by ID", s.UserProfiles)
+	}
+
+	j, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{
+		nm.NodeKey.String(),
+		nm.Peers[0].Key.String(),
+		nm.PrivateKey.Public().String(),
+		"privkey:",
+		"203.0.113.5",
+	} {
+		if strings.Contains(string(j), secret) {
+			t.Errorf("snapshot contains %q:\n%s", secret, j)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netmap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+	"sort"
+	"time"
+
+	"tailscale.com/tailcfg"
+)
+
+// SnapshotVersion is the version of the Snapshot format, incremented on
+// incompatible changes.
+const SnapshotVersion = 1
+
+// Snapshot is a sanitized copy of a NetworkMap, for tools that analyze a
+// tailnet offline, such as visualizers and auditors. It has no private
+// keys, and its public keys are hashed, so it only identifies nodes.
+// Peers' endpoints, which reveal their public IPs, are omitted too.
+type Snapshot struct {
+	Version int       // SnapshotVersion
+	Created time.Time // when the snapshot was taken
+
+	// Domain is the tailnet's name.
+	Domain string `json:",omitempty"`
+
+	Self  SnapshotNode
+	Peers []SnapshotNode
+
+	DERPMap           *tailcfg.DERPMap      `json:",omitempty"`
+	PacketFilterRules []tailcfg.FilterRule  `json:",omitempty"`
+	DNS               tailcfg.DNSConfig     `json:",omitempty"`
+	SSHPolicy         *tailcfg.SSHPolicy    `json:",omitempty"`
+	UserProfiles      []tailcfg.UserProfile `json:",omitempty"`
+}
+
+// SnapshotNode is a node in a Snapshot.
+type SnapshotNode struct {
+	ID       tailcfg.StableNodeID
+	Name     string // the MagicDNS name
+	Hostname string `json:",omitempty"`
+	OS       string `json:",omitempty"`
+	User     tailcfg.UserID
+	Tags     []string `json:",omitempty"`
+
+	// KeyHash is the hex SHA-256 hash of the node's public key, truncated
+	// to 128 bits, to tell nodes apart across snapshots without the key.
+	KeyHash string
+
+	Addresses        []netip.Prefix
+	AllowedIPs       []netip.Prefix `json:",omitempty"`
+	PrimaryRoutes    []netip.Prefix `json:",omitempty"`
+	AdvertisedRoutes []netip.Prefix `json:",omitempty"` // from its Hostinfo
+	DERP             string         `json:",omitempty"` // home DERP region, as "127.3.3.40:N"
+
+	Online   *bool      `json:",omitempty"`
+	LastSeen *time.Time `json:",omitempty"`
+	Expired  bool       `json:",omitempty"`
+
+	Capabilities []string `json:",omitempty"`
+}
+
+// Snapshot returns a sanitized copy of nm, taken at now.
+func (nm *NetworkMap) Snapshot(now time.Time) *Snapshot {
+	s := &Snapshot{
+		Version:           SnapshotVersion,
+		Created:           now,
+		Domain:            nm.Domain,
+		DERPMap:           nm.DERPMap,
+		PacketFilterRules: nm.PacketFilterRules.AsSlice(),
+		DNS:               nm.DNS,
+		SSHPolicy:         nm.SSHPolicy,
+	}
+	if nm.SelfNode != nil {
+		s.Self = snapshotNode(nm.SelfNode)
+	} else {
+		s.Self = SnapshotNode{
+			Name:      nm.Name,
+			Hostname:  nm.Hostinfo.Hostname,
+			OS:        nm.Hostinfo.OS,
+			User:      nm.User,
+			KeyHash:   hashKey(nm.NodeKey.String()),
+			Addresses: nm.Addresses,
+		}
+	}
+	s.Peers = make([]SnapshotNode, len(nm.Peers))
+	for i, p := range nm.Peers {
+		s.Peers[i] = snapshotNode(p)
+	}
+	for _, up := range nm.UserProfiles {
+		s.UserProfiles = append(s.UserProfiles, up)
+	}
+	sort.Slice(s.UserProfiles, func(i, j int) bool {
+		return s.UserProfiles[i].ID < s.UserProfiles[j].ID
+	})
+	return s
+}
+
+func snapshotNode(n *tailcfg.Node) SnapshotNode {
+	sn := SnapshotNode{
+		ID:            n.StableID,
+		Name:          n.Name,
+		Hostname:      n.Hostinfo.Hostname(),
+		OS:            n.Hostinfo.OS(),
+		User:          n.User,
+		Tags:          n.Tags,
+		KeyHash:       hashKey(n.Key.String()),
+		Addresses:     n.Addresses,
+		AllowedIPs:    n.AllowedIPs,
+		PrimaryRoutes: n.PrimaryRoutes,
+		DERP:          n.DERP,
+		Online:        n.Online,
+		LastSeen:      n.LastSeen,
+		Expired:       n.Expired,
+		Capabilities:  n.Capabilities,
+	}
+	if n.Hostinfo.Valid() {
+		sn.AdvertisedRoutes = n.Hostinfo.RoutableIPs().AsSlice()
+	}
+	return sn
+}
+
+// hashKey returns the truncated hex SHA-256 hash of the public key k, in
+// its text form.
+func hashKey(k string) string {
+	h := sha256.Sum256([]byte(k))
+	return hex.EncodeToString(h[:16])
+}
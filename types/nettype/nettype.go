@@ -50,7 +50,22 @@ func (a packetListenerAdapter) ListenPacket(ctx context.Context, network, addres
 }
 
 // ConnPacketConn is the interface that's a superset of net.Conn and net.PacketConn.
+//
+// When it's a single UDP flow accepted from a peer, its LocalAddr is the
+// address the peer sent to, which is the source of the packets it writes.
 type ConnPacketConn interface {
 	net.Conn
 	net.PacketConn
 }
+
+// DstPacketConn is a PacketConn that receives packets sent to more than
+// one local address, such as all of a node's Tailscale IPs, and reports
+// which one each packet was sent to. Replies written to a packet's source
+// are sent from that address.
+type DstPacketConn interface {
+	net.PacketConn
+
+	// ReadFromDst is like ReadFrom, but also returns the local address
+	// and port that the packet was sent to.
+	ReadFromDst(p []byte) (n int, src, dst netip.AddrPort, err error)
+}
@@ -5,6 +5,7 @@
 package winutil
 
 import (
+	"context"
 	"os/user"
 )
 
@@ -36,6 +37,17 @@ func GetPolicyInteger(name string, defval uint64) uint64 {
 	return getPolicyInteger(name, defval)
 }
 
+// WatchPolicyChanges calls onChange each time the registry keys that
+// GetPolicyString and GetPolicyInteger read change, such as when a sysadmin
+// pushes new policies via GPO or MDM, until ctx is done. It returns
+// ctx.Err() then, or an error if the keys can't be watched.
+//
+// This function will only work on GOOS=windows. Trying to run it on any other
+// OS will always return an error.
+func WatchPolicyChanges(ctx context.Context, onChange func()) error {
+	return watchPolicyChanges(ctx, onChange)
+}
+
 // GetRegString looks up a registry path in the local machine path, or returns
 // the given default if it can't.
 //
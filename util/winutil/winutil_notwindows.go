@@ -6,6 +6,7 @@
 package winutil
 
 import (
+	"context"
 	"fmt"
 	"os/user"
 	"runtime"
@@ -17,6 +18,10 @@ func getPolicyString(name, defval string) string { return defval }
 
 func getPolicyInteger(name string, defval uint64) uint64 { return defval }
 
+func watchPolicyChanges(ctx context.Context, onChange func()) error {
+	return fmt.Errorf("unimplemented on %v", runtime.GOOS)
+}
+
 func getRegString(name, defval string) string { return defval }
 
 func getRegInteger(name string, defval uint64) uint64 { return defval }
@@ -4,6 +4,7 @@
 package winutil
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -63,6 +64,100 @@ func getPolicyInteger(name string, defval uint64) uint64 {
 	return i
 }
 
+// policyWatchKeys are the registry keys in HKEY_LOCAL_MACHINE that
+// watchPolicyChanges watches, and whether it watches their subkeys. The
+// policy key is watched through its parent, since it may not exist yet.
+var policyWatchKeys = []struct {
+	path    string
+	subtree bool
+}{
+	{`SOFTWARE\Policies`, true},
+	{regBase, false},
+}
+
+func watchPolicyChanges(ctx context.Context, onChange func()) error {
+	// Registry change notifications are canceled when the thread that
+	// asked for them exits, so ask for them all from this thread.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	done, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(done)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			windows.SetEvent(done)
+		case <-stop:
+		}
+	}()
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	var keys []windows.Handle
+	var subtree []bool
+	events := []windows.Handle{done}
+	defer func() {
+		for _, k := range keys {
+			windows.RegCloseKey(k)
+		}
+		for _, ev := range events[1:] {
+			windows.CloseHandle(ev)
+		}
+	}()
+	for _, wk := range policyWatchKeys {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, wk.path, registry.NOTIFY)
+		if errors.Is(err, registry.ErrNotExist) && wk.path == regBase {
+			// Not installed by the MSI; nothing to watch.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", wk.path, err)
+		}
+		keys = append(keys, windows.Handle(k))
+		subtree = append(subtree, wk.subtree)
+		ev, err := windows.CreateEvent(nil, 0, 0, nil)
+		if err != nil {
+			return err
+		}
+		events = append(events, ev)
+	}
+	arm := func(i int) error {
+		const filter = windows.REG_NOTIFY_CHANGE_NAME | windows.REG_NOTIFY_CHANGE_LAST_SET
+		return windows.RegNotifyChangeKeyValue(keys[i], subtree[i], filter, events[i+1], true)
+	}
+	for i := range keys {
+		if err := arm(i); err != nil {
+			return err
+		}
+	}
+	for {
+		ev, err := windows.WaitForMultipleObjects(events, false, windows.INFINITE)
+		if err != nil {
+			return err
+		}
+		i := int(ev - windows.WAIT_OBJECT_0)
+		if i == 0 {
+			return ctx.Err()
+		}
+		if i < 0 || i >= len(events) {
+			return fmt.Errorf("unexpected wait result %v", ev)
+		}
+		// A notification fires only once; ask for the next one before
+		// reading the changes, so that none is missed.
+		if err := arm(i - 1); err != nil {
+			return err
+		}
+		onChange()
+	}
+}
+
 func getRegString(name, defval string) string {
 	s, err := getRegStringInternal(regBase, name)
 	if err != nil {
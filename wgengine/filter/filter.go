@@ -59,6 +59,8 @@ type Filter struct {
 type filterState struct {
 	mu  sync.Mutex
 	lru *flowtrack.Cache[struct{}] // from flowtrack.Tuple -> struct{}
+
+	trace traceState
 }
 
 // lruMax is the size of the LRU cache in filterState.
@@ -265,6 +267,7 @@ func init() {
 }
 
 func (f *Filter) logRateLimit(runflags RunFlags, q *packet.Parsed, dir direction, r Response, why string) {
+	f.maybeTrace(q, dir, r, why)
 	if !f.loggingAllowed(q) {
 		return
 	}
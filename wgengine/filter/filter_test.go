@@ -11,6 +11,7 @@
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"go4.org/netipx"
@@ -951,3 +952,58 @@ func TestCaps(t *testing.T) {
 		})
 	}
 }
+
+func TestFilterTrace(t *testing.T) {
+	f := newFilter(t.Logf)
+	run := func(p packet.Parsed) {
+		t.Helper()
+		f.RunIn(&p, 0)
+	}
+
+	// Nothing is recorded until the trace starts.
+	run(parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22))
+	if got := f.Trace(); len(got) != 0 {
+		t.Fatalf("trace before StartTrace = %v; want none", got)
+	}
+
+	f.StartTrace(time.Minute, netip.Addr{})
+	run(parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22))
+	run(parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22)) // same flow, not recorded again
+	run(parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 21))
+	run(parsed(ipproto.ICMPv4, "8.1.1.1", "1.2.3.4", 0, 0))
+
+	// A filter sharing the state, as when the netmap changes, keeps
+	// tracing into the same trace.
+	f = New(f.matches4, f.local, f.logIPs, f, t.Logf)
+	run(parsed(ipproto.TCP, "8.1.1.1", "5.6.7.8", 999, 28))
+
+	type decision struct{ verdict, why, rule string }
+	var got []decision
+	for _, e := range f.Trace() {
+		got = append(got, decision{e.Verdict, e.Why, e.Rule})
+	}
+	want := []decision{
+		{"Accept", "tcp ok", "[TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		{"Drop", "no rules matched", ""},
+		{"Accept", "icmp ok", "[TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>[1.2.3.4/32:22,5.6.7.8/32:23-24]"},
+		{"Accept", "tcp ok", "[TCP UDP ICMPv4 ICMPv6][8.1.1.1/32,8.2.2.2/32]=>5.6.7.8/32:27-28"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("trace:\n got %q\nwant %q", got, want)
+	}
+
+	// With an IP, only its flows are recorded.
+	f.StartTrace(time.Minute, mustIP("8.2.2.2"))
+	run(parsed(ipproto.TCP, "8.1.1.1", "1.2.3.4", 999, 22))
+	run(parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 999, 22))
+	if tr := f.Trace(); len(tr) != 1 || tr[0].Flow.Src.Addr() != mustIP("8.2.2.2") {
+		t.Errorf("trace for 8.2.2.2 = %+v; want only its flow", tr)
+	}
+
+	// Stopping clears the trace and records nothing further.
+	f.StartTrace(0, netip.Addr{})
+	run(parsed(ipproto.TCP, "8.2.2.2", "1.2.3.4", 1000, 22))
+	if tr := f.Trace(); len(tr) != 0 {
+		t.Errorf("trace after stop = %+v; want none", tr)
+	}
+}
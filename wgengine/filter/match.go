@@ -151,6 +151,47 @@ func (ms matches) matchProtoAndIPsOnlyIfAllPorts(q *packet.Parsed) bool {
 	return false
 }
 
+// matchMode is how firstMatch compares a packet to the destinations of a
+// Match.
+type matchMode int
+
+const (
+	matchPorts    matchMode = iota // like match: by protocol, IPs and port
+	matchIPs                       // like matchIPsOnly: by IPs only
+	matchAllPorts                  // like matchProtoAndIPsOnlyIfAllPorts
+)
+
+// firstMatch returns the first Match in ms that q matches per mode, or nil
+// if none does.
+func (ms matches) firstMatch(q *packet.Parsed, mode matchMode) *Match {
+	for i := range ms {
+		m := &ms[i]
+		if mode != matchIPs && !protoInList(q.IPProto, m.IPProto) {
+			continue
+		}
+		if !ipInList(q.Src.Addr(), m.Srcs) {
+			continue
+		}
+		for _, dst := range m.Dsts {
+			if !dst.Net.Contains(q.Dst.Addr()) {
+				continue
+			}
+			switch mode {
+			case matchPorts:
+				if !dst.Ports.contains(q.Dst.Port()) {
+					continue
+				}
+			case matchAllPorts:
+				if dst.Ports != allPorts {
+					continue
+				}
+			}
+			return m
+		}
+	}
+	return nil
+}
+
 func ipInList(ip netip.Addr, netlist []netip.Prefix) bool {
 	for _, net := range netlist {
 		if net.Contains(ip) {
@@ -0,0 +1,149 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package filter
+
+import (
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"tailscale.com/net/flowtrack"
+	"tailscale.com/net/packet"
+	"tailscale.com/tstime/rate"
+)
+
+// maxTraceEntries is the most decisions a trace keeps; older ones are
+// discarded.
+const maxTraceEntries = 512
+
+// TraceEntry is a decision of the packet filter about a flow, recorded
+// while tracing (see Filter.StartTrace).
+type TraceEntry struct {
+	Time    time.Time
+	Dir     string // "in" (from a peer) or "out" (to a peer)
+	Flow    flowtrack.Tuple
+	Verdict string // "Accept" or "Drop"
+	Why     string // the reason, such as "tcp ok" or "no rules matched"
+
+	// Rule is the filter rule that allowed the flow, in the format of
+	// Match.String, if it was allowed by one rather than as the reply to
+	// an outgoing flow or such.
+	Rule string `json:",omitempty"`
+}
+
+// traceState is the state of the decision trace shared by the filters that
+// share a filterState.
+type traceState struct {
+	until atomic.Int64 // unix nanoseconds the trace ends; zero if not tracing
+
+	mu      sync.Mutex
+	ip      netip.Addr // if valid, only flows to or from it are traced
+	seen    flowtrack.Cache[struct{}]
+	limiter *rate.Limiter
+	entries []TraceEntry // oldest first
+}
+
+// StartTrace makes f, and the filters that replace it, record the first
+// decision about each new flow for d, such as which rule allowed it or
+// that none did, to be read with Trace. If ip is valid, only flows to or
+// from it are recorded. The decisions are also logged. A zero d stops
+// tracing. Recording is rate limited, and the previous trace is cleared.
+func (f *Filter) StartTrace(d time.Duration, ip netip.Addr) {
+	t := &f.state.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ip = ip
+	t.seen = flowtrack.Cache[struct{}]{MaxEntries: lruMax}
+	t.limiter = rate.NewLimiter(rate.Every(10*time.Millisecond), 100)
+	t.entries = nil
+	if d <= 0 {
+		t.until.Store(0)
+		return
+	}
+	t.until.Store(time.Now().Add(d).UnixNano())
+}
+
+// Trace returns the decisions recorded since the last StartTrace, oldest
+// first.
+func (f *Filter) Trace() []TraceEntry {
+	t := &f.state.trace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TraceEntry(nil), t.entries...)
+}
+
+// maybeTrace records the decision r about q, for the reason why, if
+// tracing.
+func (f *Filter) maybeTrace(q *packet.Parsed, dir direction, r Response, why string) {
+	t := &f.state.trace
+	until := t.until.Load()
+	if until == 0 {
+		return
+	}
+	now := time.Now()
+	if now.UnixNano() >= until {
+		return
+	}
+	flow := flowtrack.Tuple{Proto: q.IPProto, Src: q.Src, Dst: q.Dst}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.ip.IsValid() && q.Src.Addr() != t.ip && q.Dst.Addr() != t.ip {
+		return
+	}
+	if _, ok := t.seen.Get(flow); ok {
+		return
+	}
+	if !t.limiter.Allow() {
+		return
+	}
+	t.seen.Add(flow, struct{}{})
+	verdict := "Accept"
+	if r.IsDrop() {
+		verdict = "Drop"
+	}
+	e := TraceEntry{
+		Time:    now,
+		Dir:     dir.String(),
+		Flow:    flow,
+		Verdict: verdict,
+		Why:     why,
+		Rule:    f.matchedRule(q, why),
+	}
+	if len(t.entries) == maxTraceEntries {
+		t.entries = append(t.entries[:0], t.entries[1:]...)
+	}
+	t.entries = append(t.entries, e)
+	if f.loggingAllowed(q) {
+		if e.Rule != "" {
+			f.logf("filter-trace: %s %s %v: %s by %s", verdict, e.Dir, flow, why, e.Rule)
+		} else {
+			f.logf("filter-trace: %s %s %v: %s", verdict, e.Dir, flow, why)
+		}
+	}
+}
+
+// matchedRule returns the rule that runIn4 or runIn6 matched q against to
+// accept it for the reason why, or the empty string if it wasn't accepted
+// by a rule.
+func (f *Filter) matchedRule(q *packet.Parsed, why string) string {
+	ms := f.matches4
+	if q.IPVersion == 6 {
+		ms = f.matches6
+	}
+	var m *Match
+	switch why {
+	case "tcp ok", "ok":
+		m = ms.firstMatch(q, matchPorts)
+	case "icmp ok":
+		m = ms.firstMatch(q, matchIPs)
+	case "other-portless ok":
+		m = ms.firstMatch(q, matchAllPorts)
+	}
+	if m == nil {
+		return ""
+	}
+	return m.String()
+}
@@ -142,7 +142,8 @@ func printEndpointHTML(w io.Writer, ep *endpoint) {
 	}
 
 	fmt.Fprintf(w, "<p>Best: <b>%+v</b>, %v ago (for %v)</p>\n", ep.bestAddr, fmtMono(ep.bestAddrAt), ep.trustBestAddrUntil.Sub(mnow).Round(time.Millisecond))
-	fmt.Fprintf(w, "<p>heartbeating: %v</p>\n", ep.heartBeatTimer != nil)
+	fmt.Fprintf(w, "<p>heartbeating: %v (idle: %v)</p>\n", ep.heartBeatTimer != nil, ep.idleProbing)
+	fmt.Fprintf(w, "<p>probes: %d sent, %d pongs</p>\n", ep.probesSent, ep.probePongs)
 	fmt.Fprintf(w, "<p>lastSend: %v ago</p>\n", fmtMono(ep.lastSend))
 	fmt.Fprintf(w, "<p>lastFullPing: %v ago</p>\n", fmtMono(ep.lastFullPing))
 
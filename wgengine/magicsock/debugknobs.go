@@ -36,6 +36,12 @@
 	// debugEnableSilentDisco disables the use of heartbeatTimer on the endpoint struct
 	// and attempts to handle disco silently. See issue #540 for details.
 	debugEnableSilentDisco = envknob.RegisterBool("TS_DEBUG_ENABLE_SILENT_DISCO")
+	// debugHeartbeatInterval, debugUpgradeInterval, and
+	// debugIdleProbeInterval set the defaults of the ProbeConfig fields
+	// of the same names.
+	debugHeartbeatInterval = envknob.RegisterDuration("TS_DISCO_HEARTBEAT_INTERVAL")
+	debugUpgradeInterval   = envknob.RegisterDuration("TS_DISCO_UPGRADE_INTERVAL")
+	debugIdleProbeInterval = envknob.RegisterDuration("TS_DISCO_IDLE_PROBE_INTERVAL")
 )
 
 // inTest reports whether the running program is a test that set the
@@ -5,19 +5,26 @@
 
 package magicsock
 
-import "tailscale.com/types/opt"
+import (
+	"time"
+
+	"tailscale.com/types/opt"
+)
 
 // All knobs are disabled on iOS and Wasm.
 //
 // They're inlinable and the linker can deadcode that's guarded by them to make
 // smaller binaries.
-func debugDisco() bool              { return false }
-func debugOmitLocalAddresses() bool { return false }
-func logDerpVerbose() bool          { return false }
-func debugReSTUNStopOnIdle() bool   { return false }
-func debugAlwaysDERP() bool         { return false }
-func debugEnableSilentDisco() bool  { return false }
-func debugUseDerpRouteEnv() string  { return "" }
-func debugUseDerpRoute() opt.Bool   { return "" }
+func debugDisco() bool                      { return false }
+func debugOmitLocalAddresses() bool         { return false }
+func logDerpVerbose() bool                  { return false }
+func debugReSTUNStopOnIdle() bool           { return false }
+func debugAlwaysDERP() bool                 { return false }
+func debugEnableSilentDisco() bool          { return false }
+func debugHeartbeatInterval() time.Duration { return 0 }
+func debugUpgradeInterval() time.Duration   { return 0 }
+func debugIdleProbeInterval() time.Duration { return 0 }
+func debugUseDerpRouteEnv() string          { return "" }
+func debugUseDerpRoute() opt.Bool           { return "" }
 
 func inTest() bool { return false }
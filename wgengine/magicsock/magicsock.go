@@ -54,7 +54,6 @@
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/syncs"
 	"tailscale.com/tailcfg"
-	"tailscale.com/tstime"
 	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
@@ -355,6 +354,13 @@ type Conn struct {
 	// captureHook, if non-nil, is the pcap logging callback when capturing.
 	captureHook syncs.AtomicValue[capture.Callback]
 
+	// probeConfig, if non-nil, is the ProbeConfig set by SetProbeConfig.
+	probeConfig atomic.Pointer[ProbeConfig]
+
+	// powerSave is whether the low-power mode is on, because
+	// powerSaveForced or powerSaveAuto is set.
+	powerSave atomic.Bool
+
 	// ============================================================
 	// mu guards all following fields; see userspaceEngine lock
 	// ordering rules against the engine. For derphttp, mu must
@@ -365,6 +371,9 @@ type Conn struct {
 	closed  bool        // Close was called
 	closing atomic.Bool // Close is in progress (or done)
 
+	powerSaveForced bool // the low-power mode is forced on; see SetPowerSaveForced
+	powerSaveAuto   bool // the power source calls for the low-power mode; see SetPowerSaveAuto
+
 	// derpCleanupTimer is the timer that fires to occasionally clean
 	// up idle DERP connections. It's only used when there is a non-home
 	// DERP connection in use.
@@ -709,11 +718,7 @@ func (c *Conn) updateEndpoints(why string) {
 				return
 			}
 			if c.shouldDoPeriodicReSTUNLocked() {
-				// Pick a random duration between 20
-				// and 26 seconds (just under 30s, a
-				// common UDP NAT timeout on Linux,
-				// etc)
-				d := tstime.RandomDurationBetween(20*time.Second, 26*time.Second)
+				d := c.ProbeConfig().reSTUNDelay()
 				if t := c.periodicReSTUNTimer; t != nil {
 					if debugReSTUNStopOnIdle() {
 						c.logf("resetting existing periodicSTUN to run in %v", d)
@@ -861,6 +866,16 @@ func (c *Conn) updateNetInfo(ctx context.Context) (*netcheck.Report, error) {
 	ni.OSHasIPv6.Set(report.OSHasIPv6)
 	ni.WorkingUDP.Set(report.UDP)
 	ni.WorkingICMPv4.Set(report.ICMPv4)
+	ni.CaptivePortal = report.CaptivePortal
+	if ni.CaptivePortal == "" {
+		// Only full reports check for a captive portal; keep the
+		// previous result until the next one does.
+		c.mu.Lock()
+		if c.netInfoLast != nil {
+			ni.CaptivePortal = c.netInfoLast.CaptivePortal
+		}
+		c.mu.Unlock()
+	}
 	ni.PreferredDERP = report.PreferredDERP
 
 	if ni.PreferredDERP == 0 {
@@ -2982,6 +2997,9 @@ func (c *Conn) Close() error {
 		return nil
 	}
 	c.closing.Store(true)
+	if c.powerSave.Swap(false) {
+		metricPowerSaveActive.Add(-1)
+	}
 	if c.derpCleanupTimerArmed {
 		c.derpCleanupTimer.Stop()
 	}
@@ -3778,7 +3796,8 @@ type endpoint struct {
 	discoKey   key.DiscoPublic // for discovery messages. Should never be the zero value.
 	discoShort string          // ShortString of discoKey. Empty if peer can't disco.
 
-	heartBeatTimer *time.Timer    // nil when idle
+	heartBeatTimer *time.Timer    // nil when idle, unless idleProbing
+	idleProbing    bool           // heartBeatTimer is for ProbeConfig.IdleInterval
 	lastSend       mono.Time      // last time there was outgoing packets sent to this peer (from wireguard-go)
 	lastFullPing   mono.Time      // last time we pinged all endpoints
 	derpAddr       netip.AddrPort // fallback/bootstrap path, if non-zero (non-zero for well-behaved clients)
@@ -3792,6 +3811,9 @@ type endpoint struct {
 
 	pendingCLIPings []pendingCLIPing // any outstanding "tailscale ping" commands running
 
+	probesSent int64 // disco pings sent to find or keep a path, excluding "tailscale ping"
+	probePongs int64 // pongs received in reply to probesSent
+
 	// The following fields are related to the new "silent disco"
 	// implementation that's a WIP as of 2022-10-20.
 	// See #540 for background.
@@ -3814,11 +3836,12 @@ type pendingCLIPing struct {
 	sessionActiveTimeout = 45 * time.Second
 
 	// upgradeInterval is how often we try to upgrade to a better path
-	// even if we have some non-DERP route that works.
+	// even if we have some non-DERP route that works, by default.
+	// See ProbeConfig.UpgradeInterval.
 	upgradeInterval = 1 * time.Minute
 
 	// heartbeatInterval is how often pings to the best UDP address
-	// are sent.
+	// are sent, by default. See ProbeConfig.HeartbeatInterval.
 	heartbeatInterval = 3 * time.Second
 
 	// trustUDPAddrDuration is how long we trust a UDP address as the exclusive
@@ -3993,13 +4016,15 @@ func (de *endpoint) addrForSendLocked(now mono.Time) (udpAddr, derpAddr netip.Ad
 	return
 }
 
-// heartbeat is called every heartbeatInterval to keep the best UDP path alive,
-// or kick off discovery of other paths.
+// heartbeat is called every ProbeConfig.HeartbeatInterval to keep the best
+// UDP path alive, or kick off discovery of other paths. For idle peers, it's
+// called every ProbeConfig.IdleInterval, if set.
 func (de *endpoint) heartbeat() {
 	de.mu.Lock()
 	defer de.mu.Unlock()
 
 	de.heartBeatTimer = nil
+	de.idleProbing = false
 
 	if de.heartbeatDisabled {
 		// If control override to disable heartBeatTimer set, return early.
@@ -4011,13 +4036,23 @@ func (de *endpoint) heartbeat() {
 		return
 	}
 
+	pc := de.c.ProbeConfig()
+	now := mono.Now()
 	if mono.Since(de.lastSend) > sessionActiveTimeout {
-		// Session's idle. Stop heartbeating.
-		de.c.dlogf("[v1] magicsock: disco: ending heartbeats for idle session to %v (%v)", de.publicKey.ShortString(), de.discoShort)
+		if pc.IdleInterval <= 0 {
+			// Session's idle. Stop heartbeating.
+			de.c.dlogf("[v1] magicsock: disco: ending heartbeats for idle session to %v (%v)", de.publicKey.ShortString(), de.discoShort)
+			return
+		}
+		// Keep the best path warm, without looking for better ones.
+		if de.bestAddr.IsValid() {
+			de.startPingLocked(de.bestAddr.AddrPort, now, pingHeartbeat)
+		}
+		de.idleProbing = true
+		de.heartBeatTimer = time.AfterFunc(pc.IdleInterval, de.heartbeat)
 		return
 	}
 
-	now := mono.Now()
 	udpAddr, _ := de.addrForSendLocked(now)
 	if udpAddr.IsValid() {
 		// We have a preferred path. Ping that every 2 seconds.
@@ -4028,7 +4063,7 @@ func (de *endpoint) heartbeat() {
 		de.sendPingsLocked(now, true)
 	}
 
-	de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+	de.heartBeatTimer = time.AfterFunc(pc.HeartbeatInterval, de.heartbeat)
 }
 
 // wantFullPingLocked reports whether we should ping to all our peers looking for
@@ -4048,7 +4083,7 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 	if de.bestAddr.latency <= goodEnoughLatency {
 		return false
 	}
-	if now.Sub(de.lastFullPing) >= upgradeInterval {
+	if now.Sub(de.lastFullPing) >= de.c.ProbeConfig().UpgradeInterval {
 		return true
 	}
 	return false
@@ -4056,8 +4091,16 @@ func (de *endpoint) wantFullPingLocked(now mono.Time) bool {
 
 func (de *endpoint) noteActiveLocked() {
 	de.lastSend = mono.Now()
+	if de.idleProbing {
+		// Switch back from the idle probe interval, unless the
+		// heartbeat is already running and will do so itself.
+		if de.heartBeatTimer.Stop() {
+			de.heartBeatTimer = nil
+		}
+		de.idleProbing = false
+	}
 	if de.heartBeatTimer == nil && !de.heartbeatDisabled {
-		de.heartBeatTimer = time.AfterFunc(heartbeatInterval, de.heartbeat)
+		de.heartBeatTimer = time.AfterFunc(de.c.ProbeConfig().HeartbeatInterval, de.heartbeat)
 	}
 }
 
@@ -4236,6 +4279,7 @@ func (de *endpoint) startPingLocked(ep netip.AddrPort, now mono.Time, purpose di
 			return
 		}
 		st.lastPing = now
+		de.probesSent++
 	}
 
 	txid := stun.NewTxID()
@@ -4442,6 +4486,9 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 	}
 	knownTxID = true // for naked returns below
 	de.removeSentPingLocked(m.TxID, sp)
+	if sp.purpose != pingCLI {
+		de.probePongs++
+	}
 	di.setNodeKey(de.publicKey)
 
 	now := mono.Now()
@@ -4501,7 +4548,7 @@ func (de *endpoint) handlePongConnLocked(m *disco.Pong, di *discoInfo, src netip
 			})
 			de.bestAddr.latency = latency
 			de.bestAddrAt = now
-			de.trustBestAddrUntil = now.Add(trustUDPAddrDuration)
+			de.trustBestAddrUntil = now.Add(de.c.ProbeConfig().trustUDPAddrDuration())
 		}
 	}
 	return
@@ -4644,6 +4691,8 @@ func (de *endpoint) populatePeerStatus(ps *ipnstate.PeerStatus) {
 	defer de.mu.Unlock()
 
 	ps.Relay = de.c.derpRegionCodeOfIDLocked(int(de.derpAddr.Port()))
+	ps.DiscoPingsSent = de.probesSent
+	ps.DiscoPongsReceived = de.probePongs
 
 	if de.lastSend.IsZero() {
 		return
@@ -4679,6 +4728,7 @@ func (de *endpoint) stopAndReset() {
 	if de.heartBeatTimer != nil {
 		de.heartBeatTimer.Stop()
 		de.heartBeatTimer = nil
+		de.idleProbing = false
 	}
 	de.pendingCLIPings = nil
 }
@@ -4783,6 +4833,8 @@ func (s derpAddrFamSelector) PreferIPv6() bool {
 	metricNumPeers     = clientmetric.NewGauge("magicsock_netmap_num_peers")
 	metricNumDERPConns = clientmetric.NewGauge("magicsock_num_derp_conns")
 
+	metricPowerSaveActive = clientmetric.NewGauge("magicsock_powersave_active")
+
 	metricRebindCalls     = clientmetric.NewCounter("magicsock_rebind_calls")
 	metricReSTUNCalls     = clientmetric.NewCounter("magicsock_restun_calls")
 	metricUpdateEndpoints = clientmetric.NewCounter("magicsock_update_endpoints")
@@ -43,6 +43,7 @@
 	"tailscale.com/tailcfg"
 	"tailscale.com/tstest"
 	"tailscale.com/tstest/natlab"
+	"tailscale.com/tstime/mono"
 	"tailscale.com/types/key"
 	"tailscale.com/types/logger"
 	"tailscale.com/types/netlogtype"
@@ -298,7 +299,7 @@ func meshStacks(logf logger.Logf, mutateNetmap func(idx int, nm *netmap.NetworkM
 				peerSet[peer.Key] = struct{}{}
 			}
 			m.conn.UpdatePeers(peerSet)
-			wg, err := nmcfg.WGCfg(nm, logf, netmap.AllowSingleHosts, "")
+			wg, err := nmcfg.WGCfg(nm, logf, netmap.AllowSingleHosts, "", nil, nil)
 			if err != nil {
 				// We're too far from the *testing.T to be graceful,
 				// blow up. Shouldn't happen anyway.
@@ -1853,3 +1854,46 @@ func TestSetNetworkMapWithNoPeers(t *testing.T) {
 		}
 	}
 }
+
+func TestProbeConfig(t *testing.T) {
+	c := newConn()
+	c.logf = t.Logf
+	if got, want := c.ProbeConfig(), (ProbeConfig{HeartbeatInterval: heartbeatInterval, UpgradeInterval: upgradeInterval}); got != want {
+		t.Errorf("default ProbeConfig = %+v; want %+v", got, want)
+	}
+	c.SetProbeConfig(ProbeConfig{UpgradeInterval: 10 * time.Minute, IdleInterval: time.Minute})
+	want := ProbeConfig{HeartbeatInterval: heartbeatInterval, UpgradeInterval: 10 * time.Minute, IdleInterval: time.Minute}
+	if got := c.ProbeConfig(); got != want {
+		t.Errorf("ProbeConfig = %+v; want %+v", got, want)
+	}
+
+	de := &endpoint{
+		c:                  c,
+		bestAddr:           addrLatency{AddrPort: netip.MustParseAddrPort("1.2.3.4:41641"), latency: 50 * time.Millisecond},
+		lastFullPing:       mono.Now().Add(-5 * time.Minute),
+		trustBestAddrUntil: mono.Now().Add(time.Minute),
+	}
+	if de.wantFullPingLocked(mono.Now()) {
+		t.Error("wantFullPingLocked = true before the UpgradeInterval")
+	}
+	c.SetProbeConfig(ProbeConfig{UpgradeInterval: time.Minute})
+	if !de.wantFullPingLocked(mono.Now()) {
+		t.Error("wantFullPingLocked = false after the UpgradeInterval")
+	}
+
+	c.SetPowerSaveForced(true)
+	de.lastFullPing = mono.Now().Add(-2 * time.Minute)
+	if de.wantFullPingLocked(mono.Now()) {
+		t.Error("wantFullPingLocked = true before the power save UpgradeInterval")
+	}
+	if got, want := c.ProbeConfig(), powerSaveProbeConfig; got != want {
+		t.Errorf("power save ProbeConfig = %+v; want %+v", got, want)
+	}
+	if got := c.ProbeConfig().trustUDPAddrDuration(); got <= powerSaveProbeConfig.HeartbeatInterval {
+		t.Errorf("power save trustUDPAddrDuration = %v; want more than the heartbeat interval", got)
+	}
+	c.SetPowerSaveForced(false)
+	if got, want := c.ProbeConfig().UpgradeInterval, time.Minute; got != want {
+		t.Errorf("UpgradeInterval after power save = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package magicsock
+
+import (
+	"time"
+
+	"tailscale.com/tstime"
+)
+
+// ProbeConfig is how often the disco protocol probes the paths to peers.
+// Probing less often saves power on battery-constrained devices, at the
+// cost of a slower move to better paths and a slower detection of
+// broken ones.
+//
+// Zero fields mean the defaults.
+type ProbeConfig struct {
+	// HeartbeatInterval is how often the best UDP path to an active
+	// peer is pinged to keep it alive. The default is 3 seconds.
+	HeartbeatInterval time.Duration
+
+	// UpgradeInterval is how often all the endpoints of an active peer
+	// are pinged to look for a better path than the one in use, if its
+	// latency isn't already good enough. The default is 1 minute.
+	UpgradeInterval time.Duration
+
+	// IdleInterval, if positive, is how often the best UDP path to an
+	// idle peer (one not sent to for 45 seconds) is pinged, to keep the
+	// path and NAT mappings warm for when it's next used. By default,
+	// idle peers aren't probed.
+	IdleInterval time.Duration
+
+	// ReSTUNInterval, if positive, is about how often this node's
+	// endpoints are rediscovered with STUN and netcheck while it has
+	// active peers, which also keeps NAT mappings alive. By default, it's
+	// a random 20 to 26 seconds, just under a common UDP NAT timeout.
+	ReSTUNInterval time.Duration
+}
+
+// powerSaveProbeConfig is the least often the paths to peers are probed
+// in the low-power mode; see Conn.SetPowerSave.
+var powerSaveProbeConfig = ProbeConfig{
+	HeartbeatInterval: 10 * time.Second,
+	UpgradeInterval:   5 * time.Minute,
+	ReSTUNInterval:    time.Minute,
+}
+
+// withDefaults returns pc with its zero fields set to the defaults,
+// which come from envknobs if set.
+func (pc ProbeConfig) withDefaults() ProbeConfig {
+	if pc.HeartbeatInterval <= 0 {
+		pc.HeartbeatInterval = heartbeatInterval
+		if d := debugHeartbeatInterval(); d > 0 {
+			pc.HeartbeatInterval = d
+		}
+	}
+	if pc.UpgradeInterval <= 0 {
+		pc.UpgradeInterval = upgradeInterval
+		if d := debugUpgradeInterval(); d > 0 {
+			pc.UpgradeInterval = d
+		}
+	}
+	if pc.IdleInterval == 0 {
+		pc.IdleInterval = debugIdleProbeInterval()
+	}
+	return pc
+}
+
+// powerSave returns pc with its intervals raised to at least those of
+// the low-power mode, and with idle peers not probed.
+func (pc ProbeConfig) powerSave() ProbeConfig {
+	ps := powerSaveProbeConfig
+	if pc.HeartbeatInterval < ps.HeartbeatInterval {
+		pc.HeartbeatInterval = ps.HeartbeatInterval
+	}
+	if pc.UpgradeInterval < ps.UpgradeInterval {
+		pc.UpgradeInterval = ps.UpgradeInterval
+	}
+	if pc.ReSTUNInterval < ps.ReSTUNInterval {
+		pc.ReSTUNInterval = ps.ReSTUNInterval
+	}
+	pc.IdleInterval = 0
+	return pc
+}
+
+// trustUDPAddrDuration returns how long a UDP path is trusted as the
+// exclusive path to a peer after a pong on it: long enough for a couple
+// of heartbeats to be missed before also sending over DERP.
+func (pc ProbeConfig) trustUDPAddrDuration() time.Duration {
+	if d := 2*pc.HeartbeatInterval + 500*time.Millisecond; d > trustUDPAddrDuration {
+		return d
+	}
+	return trustUDPAddrDuration
+}
+
+// reSTUNDelay returns how long to wait until the next periodic STUN.
+func (pc ProbeConfig) reSTUNDelay() time.Duration {
+	if d := pc.ReSTUNInterval; d > 0 {
+		return tstime.RandomDurationBetween(d, d+d*3/10)
+	}
+	// Pick a random duration between 20 and 26 seconds (just under
+	// 30s, a common UDP NAT timeout on Linux, etc)
+	return tstime.RandomDurationBetween(20*time.Second, 26*time.Second)
+}
+
+// SetPowerSaveForced sets whether c runs in the low-power mode
+// regardless of the power source, per the PowerSave pref. In the
+// low-power mode, the paths to peers are probed and endpoints
+// rediscovered less often, per powerSaveProbeConfig, on top of any
+// ProbeConfig set.
+func (c *Conn) SetPowerSaveForced(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.powerSaveForced = on
+	c.updatePowerSaveLocked()
+}
+
+// SetPowerSaveAuto sets whether the power source calls for the low-power
+// mode, as reported by powersave.Auto.
+func (c *Conn) SetPowerSaveAuto(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.powerSaveAuto = on
+	c.updatePowerSaveLocked()
+}
+
+// PowerSave reports whether c runs in the low-power mode.
+func (c *Conn) PowerSave() bool {
+	return c.powerSave.Load()
+}
+
+// updatePowerSaveLocked turns the low-power mode on or off to match
+// c.powerSaveForced and c.powerSaveAuto. c.mu must be held.
+func (c *Conn) updatePowerSaveLocked() {
+	on := (c.powerSaveForced || c.powerSaveAuto) && !c.closed
+	if c.powerSave.Swap(on) == on {
+		return
+	}
+	if on {
+		metricPowerSaveActive.Add(1)
+	} else {
+		metricPowerSaveActive.Add(-1)
+	}
+	c.logf("magicsock: power save mode: %v (forced=%v, on battery=%v)", on, c.powerSaveForced, c.powerSaveAuto)
+}
+
+// SetProbeConfig sets how often the paths to peers are probed. It takes
+// effect as the peers are next probed.
+func (c *Conn) SetProbeConfig(pc ProbeConfig) {
+	pc = pc.withDefaults()
+	c.probeConfig.Store(&pc)
+}
+
+// ProbeConfig returns how often the paths to peers are probed, with any
+// defaults filled in, and adjusted for the low-power mode if on.
+func (c *Conn) ProbeConfig() ProbeConfig {
+	pc := ProbeConfig{}.withDefaults()
+	if p := c.probeConfig.Load(); p != nil {
+		pc = *p
+	}
+	if c.powerSave.Load() {
+		pc = pc.powerSave()
+	}
+	return pc
+}
@@ -0,0 +1,155 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package monitor
+
+import (
+	"net/netip"
+	"strings"
+
+	"tailscale.com/net/interfaces"
+)
+
+// ChangeKind classifies a major network change.
+type ChangeKind int
+
+const (
+	// ChangeOther is a change not otherwise classified, such as to an
+	// interface that doesn't carry the default route.
+	ChangeOther ChangeKind = iota
+
+	// ChangeRenumbered is a change of the default route interface's
+	// addresses within the same LAN, such as a new DHCP lease.
+	ChangeRenumbered
+
+	// ChangeLANSwitched is a move of the default route interface to a
+	// different LAN, such as joining another Wi-Fi network.
+	ChangeLANSwitched
+
+	// ChangeInterfaceSwapped is a move of the default route to another
+	// interface, such as from Wi-Fi to Ethernet or cellular.
+	ChangeInterfaceSwapped
+
+	// ChangeTimeJumped is a jump in wall time without a network change,
+	// as on wake from sleep.
+	ChangeTimeJumped
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeRenumbered:
+		return "renumbered"
+	case ChangeLANSwitched:
+		return "lan-switched"
+	case ChangeInterfaceSwapped:
+		return "interface-swapped"
+	case ChangeTimeJumped:
+		return "time-jumped"
+	}
+	return "other"
+}
+
+// ChangeDelta describes a major network change.
+type ChangeDelta struct {
+	Kind ChangeKind
+
+	// Old and New are the interface states before and after the change.
+	// They are owned by the Mon and must not be modified.
+	Old, New *interfaces.State
+}
+
+// Metered reports whether the new default route interface is metered,
+// such as a cellular link.
+func (d *ChangeDelta) Metered() bool {
+	return d.New.IsExpensive
+}
+
+// MeteredChanged reports whether the change was to or from a metered link.
+func (d *ChangeDelta) MeteredChanged() bool {
+	return d.Old == nil || d.Old.IsExpensive != d.New.IsExpensive
+}
+
+// ChangeDeltaFunc is the callback registered with
+// Mon.RegisterChangeDeltaCallback.
+type ChangeDeltaFunc func(*ChangeDelta)
+
+// classifyChange returns how the network changed from old to cur, which
+// must differ.
+func classifyChange(old, cur *interfaces.State) ChangeKind {
+	if old == nil {
+		return ChangeOther
+	}
+	ifName := cur.DefaultRouteInterface
+	if old.DefaultRouteInterface != ifName {
+		return ChangeInterfaceSwapped
+	}
+	if ifName == "" {
+		return ChangeOther
+	}
+	oldPfxs := lanPrefixes(old.InterfaceIPs[ifName])
+	curPfxs := lanPrefixes(cur.InterfaceIPs[ifName])
+	if len(oldPfxs) == 0 || len(curPfxs) == 0 || samePrefixes(oldPfxs, curPfxs) {
+		return ChangeOther
+	}
+	for _, p := range curPfxs {
+		if !containsLAN(oldPfxs, p) {
+			return ChangeLANSwitched
+		}
+	}
+	return ChangeRenumbered
+}
+
+// lanPrefixes returns the addresses in pfxs that identify a LAN, ignoring
+// loopback and link-local ones.
+func lanPrefixes(pfxs []netip.Prefix) (ret []netip.Prefix) {
+	for _, p := range pfxs {
+		if ip := p.Addr(); !ip.IsLoopback() && !ip.IsLinkLocalUnicast() {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func samePrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// containsLAN reports whether any of pfxs is an address on the same
+// subnet as p.
+func containsLAN(pfxs []netip.Prefix, p netip.Prefix) bool {
+	for _, o := range pfxs {
+		if o.Bits() == p.Bits() && o.Masked() == p.Masked() {
+			return true
+		}
+	}
+	return false
+}
+
+// cellularInterfacePrefixes are the name prefixes of cellular modem
+// interfaces on Linux, Android and macOS/iOS.
+var cellularInterfacePrefixes = []string{
+	"rmnet",  // Qualcomm, on Android and Linux
+	"ccmni",  // MediaTek, on Android
+	"wwan",   // Linux
+	"wwp",    // Linux, with predictable interface names
+	"pdp_ip", // macOS and iOS
+}
+
+// isCellularInterface reports whether the interface named name looks like
+// a cellular modem.
+func isCellularInterface(name string) bool {
+	for _, p := range cellularInterfacePrefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -57,6 +57,7 @@ type Mon struct {
 
 	mu         sync.Mutex // guards all following fields
 	cbs        set.HandleSet[interfaces.ChangeFunc]
+	deltaCBs   set.HandleSet[ChangeDeltaFunc]
 	ruleDelCB  set.HandleSet[RuleDeleteCallback]
 	ifState    *interfaces.State
 	gwValid    bool       // whether gw and gwSelfIP are valid
@@ -109,7 +110,16 @@ func (m *Mon) InterfaceState() *interfaces.State {
 }
 
 func (m *Mon) interfaceStateUncached() (*interfaces.State, error) {
-	return interfaces.GetState()
+	st, err := interfaces.GetState()
+	if err != nil {
+		return nil, err
+	}
+	// GetState doesn't know which links are metered, but a cellular
+	// modem's usually is.
+	if !st.IsExpensive {
+		st.IsExpensive = isCellularInterface(st.DefaultRouteInterface)
+	}
+	return st, nil
 }
 
 // GatewayAndSelfIP returns the current network's default gateway, and
@@ -150,6 +160,21 @@ func (m *Mon) RegisterChangeCallback(callback interfaces.ChangeFunc) (unregister
 // currently: 5210, 5230, 5250, 5270)
 type RuleDeleteCallback func(table uint8, priority uint32)
 
+// RegisterChangeDeltaCallback adds callback to the set of parties to be
+// notified, with a classification, of major network changes: those for
+// which the interfaces.ChangeFunc callbacks get changed=true. It returns
+// a function that, when called, unregisters the callback.
+func (m *Mon) RegisterChangeDeltaCallback(callback ChangeDeltaFunc) (unregister func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	handle := m.deltaCBs.Add(callback)
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.deltaCBs, handle)
+	}
+}
+
 // RegisterRuleDeleteCallback adds callback to the set of parties to be
 // notified (in their own goroutine) when a Linux ip rule is deleted.
 // To remove this callback, call unregister (or close the monitor).
@@ -296,7 +321,13 @@ func (m *Mon) debounce() {
 
 			oldState := m.ifState
 			changed := !curState.EqualFiltered(oldState, m.isInterestingInterface, interfaces.UseInterestingIPs)
+			var delta *ChangeDelta
 			if changed {
+				delta = &ChangeDelta{
+					Kind: classifyChange(oldState, curState),
+					Old:  oldState,
+					New:  curState,
+				}
 				m.gwValid = false
 				m.ifState = curState
 
@@ -313,11 +344,18 @@ func (m *Mon) debounce() {
 					// Only log if it wasn't an interesting change.
 					m.logf("time jumped (probably wake from sleep); synthesizing major change event")
 					changed = true
+					delta = &ChangeDelta{Kind: ChangeTimeJumped, Old: oldState, New: oldState}
 				}
 			}
 			for _, cb := range m.cbs {
 				go cb(changed, m.ifState)
 			}
+			if delta != nil {
+				m.logf("[v1] major change: %v, metered=%v", delta.Kind, delta.Metered())
+				for _, cb := range m.deltaCBs {
+					go cb(delta)
+				}
+			}
 			m.mu.Unlock()
 		}
 
@@ -5,6 +5,7 @@
 
 import (
 	"flag"
+	"net/netip"
 	"testing"
 	"time"
 
@@ -86,3 +87,53 @@ func TestMonitorMode(t *testing.T) {
 		select {}
 	}
 }
+
+func TestClassifyChange(t *testing.T) {
+	state := func(defIf string, pfxs ...string) *interfaces.State {
+		st := &interfaces.State{
+			DefaultRouteInterface: defIf,
+			InterfaceIPs:          map[string][]netip.Prefix{},
+		}
+		for _, p := range pfxs {
+			st.InterfaceIPs[defIf] = append(st.InterfaceIPs[defIf], netip.MustParsePrefix(p))
+		}
+		return st
+	}
+	tests := []struct {
+		name     string
+		old, cur *interfaces.State
+		want     ChangeKind
+	}{
+		{"no-old", nil, state("en0", "192.168.1.10/24"), ChangeOther},
+		{"dhcp-renumber", state("en0", "192.168.1.10/24"), state("en0", "192.168.1.23/24"), ChangeRenumbered},
+		{"dhcp-renumber-v6", state("en0", "192.168.1.10/24", "2001:db8::10/64"), state("en0", "192.168.1.10/24", "2001:db8::11/64"), ChangeRenumbered},
+		{"new-wifi", state("en0", "192.168.1.10/24"), state("en0", "10.0.0.5/24"), ChangeLANSwitched},
+		{"new-mask", state("en0", "192.168.1.10/24"), state("en0", "192.168.1.10/16"), ChangeLANSwitched},
+		{"to-cellular", state("en0", "192.168.1.10/24"), state("pdp_ip0", "100.80.1.2/32"), ChangeInterfaceSwapped},
+		{"lost-address", state("en0", "192.168.1.10/24"), state("en0", "fe80::1/64"), ChangeOther},
+		{"other-interface", state("en0", "192.168.1.10/24"), state("en0", "192.168.1.10/24"), ChangeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyChange(tt.old, tt.cur); got != tt.want {
+				t.Errorf("classifyChange = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsCellularInterface(t *testing.T) {
+	for name, want := range map[string]bool{
+		"rmnet_data0": true,
+		"pdp_ip0":     true,
+		"wwan0":       true,
+		"wwp0s20f0u6": true,
+		"wlan0":       false,
+		"en0":         false,
+		"":            false,
+	} {
+		if got := isCellularInterface(name); got != want {
+			t.Errorf("isCellularInterface(%q) = %v; want %v", name, got, want)
+		}
+	}
+}
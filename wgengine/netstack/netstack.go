@@ -58,6 +58,20 @@
 
 var debugNetstack = envknob.RegisterBool("TS_DEBUG_NETSTACK")
 
+// The defaults of Impl.UDPIdleTimeout and Impl.MaxUDPFlows, for busy
+// subnet routers that need other limits than client workloads.
+var (
+	envUDPIdleTimeout = envknob.RegisterDuration("TS_NETSTACK_UDP_IDLE_TIMEOUT")
+	envMaxUDPFlows    = envknob.RegisterInt("TS_NETSTACK_MAX_UDP_FLOWS")
+)
+
+// Idle timeouts of the UDP flows forwarded by netstack, when
+// Impl.UDPIdleTimeout is zero.
+const (
+	defaultUDPIdleTimeout    = 2 * time.Minute
+	defaultDNSUDPIdleTimeout = 30 * time.Second // to port 53
+)
+
 var (
 	magicDNSIP   = tsaddr.TailscaleServiceIP()
 	magicDNSIPv6 = tsaddr.TailscaleServiceIPv6()
@@ -105,6 +119,14 @@ type Impl struct {
 	// over the UDP flow.
 	GetUDPHandlerForFlow func(src, dst netip.AddrPort) (handler func(nettype.ConnPacketConn), intercept bool)
 
+	// GetTCPKeepAliveForFlow optionally returns the TCP keepalive period
+	// for an incoming TCP flow taken over by a handler from
+	// GetTCPHandlerForFlow: probes are sent after the connection has been
+	// idle that long, and then that often.
+	//
+	// A nil value, or a func returning zero, keeps the netstack default.
+	GetTCPKeepAliveForFlow func(src, dst netip.AddrPort) time.Duration
+
 	// ProcessLocalIPs is whether netstack should handle incoming
 	// traffic directed at the Node.Addresses (local IPs).
 	// It can only be set before calling Start.
@@ -116,6 +138,19 @@ type Impl struct {
 	// It can only be set before calling Start.
 	ProcessSubnets bool
 
+	// UDPIdleTimeout, if positive, is how long a forwarded UDP flow can
+	// go without packets in either direction before it's torn down. If
+	// zero, it's two minutes, or 30 seconds for flows to port 53. It
+	// defaults to $TS_NETSTACK_UDP_IDLE_TIMEOUT.
+	// It can only be set before calling Start.
+	UDPIdleTimeout time.Duration
+
+	// MaxUDPFlows, if positive, is the maximum number of UDP flows
+	// forwarded at once. Packets that would start more are dropped. If
+	// zero, there's no limit. It defaults to $TS_NETSTACK_MAX_UDP_FLOWS.
+	// It can only be set before calling Start.
+	MaxUDPFlows int
+
 	ipstack   *stack.Stack
 	linkEP    *channel.Endpoint
 	tundev    *tstun.Wrapper
@@ -128,6 +163,9 @@ type Impl struct {
 	lb        *ipnlocal.LocalBackend // or nil
 	dns       *dns.Manager
 
+	udpFlows       atomic.Int64 // number of UDP flows being forwarded
+	logfUDPDropped logger.Logf  // rate-limited, for flows over MaxUDPFlows
+
 	peerapiPort4Atomic atomic.Uint32 // uint16 port number for IPv4 peerapi
 	peerapiPort6Atomic atomic.Uint32 // uint16 port number for IPv6 peerapi
 
@@ -143,6 +181,13 @@ type Impl struct {
 	// TCP connections, so they can be unregistered when connections are
 	// closed.
 	connsOpenBySubnetIP map[netip.Addr]int
+
+	// flowPriorities holds the priorities of flows set by
+	// SetFlowPriority, other than PriorityDefault. It's replaced, not
+	// mutated, under flowPriorityMu, so that inject can look up each
+	// packet's priority without locking.
+	flowPriorityMu sync.Mutex
+	flowPriorities syncs.AtomicValue[map[flowKey]Priority]
 }
 
 const nicID = 1
@@ -213,6 +258,9 @@ func Create(logf logger.Logf, tundev *tstun.Wrapper, e wgengine.Engine, mc *magi
 		dialer:              dialer,
 		connsOpenBySubnetIP: make(map[netip.Addr]int),
 		dns:                 dns,
+		UDPIdleTimeout:      envUDPIdleTimeout(),
+		MaxUDPFlows:         envMaxUDPFlows(),
+		logfUDPDropped:      logger.RateLimitedFn(logf, time.Minute, 1, 1),
 	}
 	ns.ctx, ns.ctxCancel = context.WithCancel(context.Background())
 	ns.atomicIsLocalIPFunc.Store(tsaddr.NewContainsIPFunc(nil))
@@ -446,6 +494,76 @@ func (ns *Impl) DialContextTCP(ctx context.Context, ipp netip.AddrPort) (*gonet.
 	return gonet.DialContextTCP(ctx, ns.ipstack, remoteAddress, ipType)
 }
 
+// DialContextTCPKeepAlive is like DialContextTCP, but sends TCP
+// keepalive probes once the connection has been idle for keepAlive, and
+// then every keepAlive. If keepAlive is zero, it's equivalent to
+// DialContextTCP.
+func (ns *Impl) DialContextTCPKeepAlive(ctx context.Context, ipp netip.AddrPort, keepAlive time.Duration) (*gonet.TCPConn, error) {
+	if keepAlive <= 0 {
+		return ns.DialContextTCP(ctx, ipp)
+	}
+	remoteAddress := tcpip.FullAddress{
+		NIC:  nicID,
+		Addr: tcpip.Address(ipp.Addr().AsSlice()),
+		Port: ipp.Port(),
+	}
+	var ipType tcpip.NetworkProtocolNumber
+	if ipp.Addr().Is4() {
+		ipType = ipv4.ProtocolNumber
+	} else {
+		ipType = ipv6.ProtocolNumber
+	}
+
+	// This mirrors gonet.DialContextTCP, which doesn't provide a way
+	// to set socket options before connecting.
+	var wq waiter.Queue
+	ep, tcpErr := ns.ipstack.NewEndpoint(tcp.ProtocolNumber, ipType, &wq)
+	if tcpErr != nil {
+		return nil, errors.New(tcpErr.String())
+	}
+	ep.SocketOptions().SetKeepAlive(true)
+	for _, opt := range keepAliveOptions(keepAlive) {
+		ep.SetSockOpt(opt)
+	}
+
+	waitEntry, notifyCh := waiter.NewChannelEntry(waiter.WritableEvents)
+	wq.EventRegister(&waitEntry)
+	defer wq.EventUnregister(&waitEntry)
+
+	tcpErr = ep.Connect(remoteAddress)
+	if _, ok := tcpErr.(*tcpip.ErrConnectStarted); ok {
+		select {
+		case <-ctx.Done():
+			ep.Close()
+			return nil, ctx.Err()
+		case <-notifyCh:
+		}
+		tcpErr = ep.LastError()
+	}
+	if tcpErr != nil {
+		ep.Close()
+		return nil, &net.OpError{
+			Op:   "connect",
+			Net:  "tcp",
+			Addr: net.TCPAddrFromAddrPort(ipp),
+			Err:  errors.New(tcpErr.String()),
+		}
+	}
+	return gonet.NewTCPConn(&wq, ep), nil
+}
+
+// keepAliveOptions returns the socket options to send TCP keepalive
+// probes after d of idleness and every d thereafter, or nil if d is not
+// positive.
+func keepAliveOptions(d time.Duration) []tcpip.SettableSocketOption {
+	if d <= 0 {
+		return nil
+	}
+	idle := tcpip.KeepaliveIdleOption(d)
+	interval := tcpip.KeepaliveIntervalOption(d)
+	return []tcpip.SettableSocketOption{&idle, &interval}
+}
+
 func (ns *Impl) DialContextUDP(ctx context.Context, ipp netip.AddrPort) (*gonet.UDPConn, error) {
 	remoteAddress := &tcpip.FullAddress{
 		NIC:  nicID,
@@ -465,8 +583,13 @@ func (ns *Impl) DialContextUDP(ctx context.Context, ipp netip.AddrPort) (*gonet.
 // The inject goroutine reads in packets that netstack generated, and delivers
 // them to the correct path.
 func (ns *Impl) inject() {
+	var q priorityQueue // packets read from linkEP but not yet sent
+	defer q.release()
 	for {
-		pkt := ns.linkEP.ReadContext(ns.ctx)
+		pkt := q.pop()
+		if pkt.IsNil() {
+			pkt = ns.linkEP.ReadContext(ns.ctx)
+		}
 		if pkt.IsNil() {
 			if ns.ctx.Err() != nil {
 				// Return without logging.
@@ -475,6 +598,11 @@ func (ns *Impl) inject() {
 			ns.logf("[v2] ReadContext-for-write = ok=false")
 			continue
 		}
+		if len(ns.flowPriorities.Load()) > 0 {
+			// Let packets of flows with a higher priority that are
+			// waiting in linkEP overtake pkt.
+			pkt = ns.reorderByPriority(&q, pkt)
+		}
 
 		if debugPackets {
 			ns.logf("[v2] packet Write out: % x", stack.PayloadSince(pkt.NetworkHeader()))
@@ -928,7 +1056,11 @@ func (ns *Impl) acceptTCP(r *tcp.ForwarderRequest) {
 				r.Complete(true)
 				return
 			}
-			c := createConn() // will send a RST if it fails
+			var opts []tcpip.SettableSocketOption
+			if ns.GetTCPKeepAliveForFlow != nil {
+				opts = keepAliveOptions(ns.GetTCPKeepAliveForFlow(clientRemoteAddrPort, dstAddrPort))
+			}
+			c := createConn(opts...) // will send a RST if it fails
 			if c == nil {
 				return
 			}
@@ -1021,31 +1153,24 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 	if debugNetstack() {
 		ns.logf("[v2] UDP ForwarderRequest: %v", stringifyTEI(sess))
 	}
-	var wq waiter.Queue
-	ep, err := r.CreateEndpoint(&wq)
-	if err != nil {
-		ns.logf("acceptUDP: could not create endpoint: %v", err)
-		return
-	}
 	dstAddr, ok := ipPortOfNetstackAddr(sess.LocalAddress, sess.LocalPort)
 	if !ok {
-		ep.Close()
 		return
 	}
 	srcAddr, ok := ipPortOfNetstackAddr(sess.RemoteAddress, sess.RemotePort)
 	if !ok {
-		ep.Close()
 		return
 	}
 
 	// Handle magicDNS traffic (via UDP) here.
 	if dst := dstAddr.Addr(); dst == magicDNSIP || dst == magicDNSIPv6 {
 		if dstAddr.Port() != 53 {
-			ep.Close()
 			return // Only MagicDNS traffic runs on the service IPs for now.
 		}
-
-		c := gonet.NewUDPConn(ns.ipstack, &wq, ep)
+		c, ok := ns.createUDPConn(r)
+		if !ok {
+			return
+		}
 		go ns.handleMagicDNSUDP(srcAddr, c)
 		return
 	}
@@ -1054,18 +1179,74 @@ func (ns *Impl) acceptUDP(r *udp.ForwarderRequest) {
 		h, intercept := get(srcAddr, dstAddr)
 		if intercept {
 			if h == nil {
-				ep.Close()
 				return
 			}
-			go h(gonet.NewUDPConn(ns.ipstack, &wq, ep))
+			c, ok := ns.createUDPConn(r)
+			if !ok {
+				return
+			}
+			go h(c)
 			return
 		}
 	}
 
-	c := gonet.NewUDPConn(ns.ipstack, &wq, ep)
+	// Check the flow limit before creating the endpoint, so that
+	// dropped flows cost nothing.
+	if !ns.acquireUDPFlow() {
+		ns.logfUDPDropped("netstack: dropping UDP flow %v -> %v: already forwarding the maximum of %d flows", srcAddr, dstAddr, ns.MaxUDPFlows)
+		return
+	}
+	c, ok := ns.createUDPConn(r)
+	if !ok {
+		ns.udpFlows.Add(-1)
+		return
+	}
 	go ns.forwardUDP(c, srcAddr, dstAddr)
 }
 
+// createUDPConn creates the endpoint of the UDP flow of r and returns it
+// as a conn, reporting whether it could.
+func (ns *Impl) createUDPConn(r *udp.ForwarderRequest) (_ *gonet.UDPConn, ok bool) {
+	var wq waiter.Queue
+	ep, err := r.CreateEndpoint(&wq)
+	if err != nil {
+		ns.logf("acceptUDP: could not create endpoint: %v", err)
+		return nil, false
+	}
+	return gonet.NewUDPConn(ns.ipstack, &wq, ep), true
+}
+
+// acquireUDPFlow reserves one of the ns.MaxUDPFlows UDP flows that can be
+// forwarded at once, reporting whether there was one left. Each
+// successful call must be matched by a decrement of ns.udpFlows once the
+// flow is torn down.
+func (ns *Impl) acquireUDPFlow() bool {
+	if n := ns.udpFlows.Add(1); ns.MaxUDPFlows > 0 && n > int64(ns.MaxUDPFlows) {
+		ns.udpFlows.Add(-1)
+		return false
+	}
+	return true
+}
+
+// udpIdleTimeout returns how long a forwarded UDP flow to port can go
+// without packets before it's torn down.
+func (ns *Impl) udpIdleTimeout(port uint16) time.Duration {
+	d := defaultUDPIdleTimeout
+	if ns.UDPIdleTimeout > 0 {
+		d = ns.UDPIdleTimeout
+	}
+	if port == 53 && d > defaultDNSUDPIdleTimeout {
+		// Make DNS packet copies time out much sooner.
+		//
+		// TODO(bradfitz): make DNS queries over UDP forwarding even
+		// cheaper by adding an additional idleTimeout post-DNS-reply.
+		// For instance, after the DNS response goes back out, then only
+		// wait a few seconds (or zero, really)
+		d = defaultDNSUDPIdleTimeout
+	}
+	return d
+}
+
 func (ns *Impl) handleMagicDNSUDP(srcAddr netip.AddrPort, c *gonet.UDPConn) {
 	// In practice, implementations are advised not to exceed 512 bytes
 	// due to fragmenting. Just to be sure, we bump all the way to the MTU.
@@ -1102,7 +1283,8 @@ func (ns *Impl) handleMagicDNSUDP(srcAddr netip.AddrPort, c *gonet.UDPConn) {
 	}
 }
 
-// forwardUDP proxies between client (with addr clientAddr) and dstAddr.
+// forwardUDP proxies between client (with addr clientAddr) and dstAddr,
+// then releases the flow reserved by acquireUDPFlow.
 //
 // dstAddr may be either a local Tailscale IP, in which we case we proxy to
 // 127.0.0.1, or any other IP (from an advertised subnet), in which case we
@@ -1138,6 +1320,8 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, clientAddr, dstAddr netip.Addr
 		backendConn, err = net.ListenUDP("udp", backendListenAddr)
 		if err != nil {
 			ns.logf("netstack: could not create UDP socket, preventing forwarding to %v: %v", dstAddr, err)
+			client.Close()
+			ns.udpFlows.Add(-1)
 			return
 		}
 	}
@@ -1152,16 +1336,7 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, clientAddr, dstAddr netip.Addr
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 
-	idleTimeout := 2 * time.Minute
-	if port == 53 {
-		// Make DNS packet copies time out much sooner.
-		//
-		// TODO(bradfitz): make DNS queries over UDP forwarding even
-		// cheaper by adding an additional idleTimeout post-DNS-reply.
-		// For instance, after the DNS response goes back out, then only
-		// wait a few seconds (or zero, really)
-		idleTimeout = 30 * time.Second
-	}
+	idleTimeout := ns.udpIdleTimeout(port)
 	timer := time.AfterFunc(idleTimeout, func() {
 		if isLocal {
 			ns.e.UnregisterIPPortIdentity(backendLocalIPPort)
@@ -1170,6 +1345,7 @@ func (ns *Impl) forwardUDP(client *gonet.UDPConn, clientAddr, dstAddr netip.Addr
 		cancel()
 		client.Close()
 		backendConn.Close()
+		ns.udpFlows.Add(-1)
 	})
 	extend := func() {
 		timer.Reset(idleTimeout)
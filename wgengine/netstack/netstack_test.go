@@ -8,6 +8,7 @@
 	"net/netip"
 	"runtime"
 	"testing"
+	"time"
 
 	"tailscale.com/ipn"
 	"tailscale.com/ipn/ipnlocal"
@@ -460,3 +461,48 @@ func TestShouldProcessInbound(t *testing.T) {
 		})
 	}
 }
+
+func TestUDPIdleTimeout(t *testing.T) {
+	tests := []struct {
+		configured time.Duration
+		port       uint16
+		want       time.Duration
+	}{
+		{0, 5353, 2 * time.Minute},
+		{0, 53, 30 * time.Second},
+		{10 * time.Minute, 5353, 10 * time.Minute},
+		{10 * time.Minute, 53, 30 * time.Second},
+		{5 * time.Second, 5353, 5 * time.Second},
+		{5 * time.Second, 53, 5 * time.Second},
+	}
+	for _, tt := range tests {
+		ns := &Impl{UDPIdleTimeout: tt.configured}
+		if got := ns.udpIdleTimeout(tt.port); got != tt.want {
+			t.Errorf("udpIdleTimeout(%v) with UDPIdleTimeout=%v = %v; want %v", tt.port, tt.configured, got, tt.want)
+		}
+	}
+}
+
+func TestAcquireUDPFlow(t *testing.T) {
+	ns := &Impl{MaxUDPFlows: 2}
+	if !ns.acquireUDPFlow() || !ns.acquireUDPFlow() {
+		t.Fatal("failed to acquire flows under the limit")
+	}
+	if ns.acquireUDPFlow() {
+		t.Fatal("acquired a flow over the limit")
+	}
+	ns.udpFlows.Add(-1)
+	if !ns.acquireUDPFlow() {
+		t.Fatal("failed to acquire a released flow")
+	}
+	if got := ns.udpFlows.Load(); got != 2 {
+		t.Errorf("udpFlows = %d; want 2", got)
+	}
+
+	ns = &Impl{}
+	for i := 0; i < 1000; i++ {
+		if !ns.acquireUDPFlow() {
+			t.Fatalf("flow %d refused with no limit", i)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"tailscale.com/types/ipproto"
+)
+
+// Priority is the queueing priority of the packets netstack sends for a
+// flow. Of the packets netstack has waiting to be sent, those of flows
+// with a higher priority are sent first, so a latency-sensitive flow
+// isn't stuck behind a bulk transfer from the same node.
+type Priority int8
+
+const (
+	PriorityBulk     Priority = -1 // sent after others, such as for large transfers
+	PriorityDefault  Priority = 0
+	PriorityRealtime Priority = 1 // sent before others, such as for VoIP
+)
+
+// flowKey identifies the packets of a flow that netstack sends.
+type flowKey struct {
+	proto         ipproto.Proto
+	local, remote netip.AddrPort
+}
+
+// SetFlowPriority sets the priority of the packets that netstack sends
+// from local to remote for proto, ipproto.TCP or ipproto.UDP. Setting
+// PriorityDefault forgets the flow, as must be done once it's closed.
+func (ns *Impl) SetFlowPriority(proto ipproto.Proto, local, remote netip.AddrPort, p Priority) {
+	k := flowKey{
+		proto:  proto,
+		local:  netip.AddrPortFrom(local.Addr().Unmap(), local.Port()),
+		remote: netip.AddrPortFrom(remote.Addr().Unmap(), remote.Port()),
+	}
+	if p < PriorityBulk {
+		p = PriorityBulk
+	} else if p > PriorityRealtime {
+		p = PriorityRealtime
+	}
+	ns.flowPriorityMu.Lock()
+	defer ns.flowPriorityMu.Unlock()
+	old := ns.flowPriorities.Load()
+	if old[k] == p {
+		return
+	}
+	m := make(map[flowKey]Priority, len(old)+1)
+	for fk, fp := range old {
+		m[fk] = fp
+	}
+	if p == PriorityDefault {
+		delete(m, k)
+	} else {
+		m[k] = p
+	}
+	ns.flowPriorities.Store(m)
+}
+
+// packetPriority returns the priority of pkt, an outgoing packet from
+// linkEP.
+func (ns *Impl) packetPriority(pkt stack.PacketBufferPtr) Priority {
+	k, ok := packetFlowKey(pkt)
+	if !ok {
+		return PriorityDefault
+	}
+	return ns.flowPriorities.Load()[k]
+}
+
+// packetFlowKey returns the flow of pkt, an outgoing TCP or UDP packet.
+func packetFlowKey(pkt stack.PacketBufferPtr) (k flowKey, ok bool) {
+	switch pkt.TransportProtocolNumber {
+	case header.TCPProtocolNumber:
+		k.proto = ipproto.TCP
+	case header.UDPProtocolNumber:
+		k.proto = ipproto.UDP
+	default:
+		return k, false
+	}
+	nh, th := pkt.NetworkHeader().Slice(), pkt.TransportHeader().Slice()
+	if len(th) < 4 {
+		return k, false
+	}
+	var src, dst netip.Addr
+	switch {
+	case len(nh) >= header.IPv4MinimumSize && nh[0]>>4 == 4:
+		src, _ = netip.AddrFromSlice(nh[12:16])
+		dst, _ = netip.AddrFromSlice(nh[16:20])
+	case len(nh) >= header.IPv6MinimumSize && nh[0]>>4 == 6:
+		src, _ = netip.AddrFromSlice(nh[8:24])
+		dst, _ = netip.AddrFromSlice(nh[24:40])
+	default:
+		return k, false
+	}
+	k.local = netip.AddrPortFrom(src, binary.BigEndian.Uint16(th[0:2]))
+	k.remote = netip.AddrPortFrom(dst, binary.BigEndian.Uint16(th[2:4]))
+	return k, true
+}
+
+// priorityQueue holds outgoing packets in order of priority, and of
+// arrival within a priority.
+type priorityQueue [PriorityRealtime - PriorityBulk + 1][]stack.PacketBufferPtr
+
+// maxQueuedPackets is the most packets reorderByPriority holds in a
+// priorityQueue, so that linkEP still fills up, and netstack slows down,
+// if packets are sent slower than it writes them.
+const maxQueuedPackets = 512
+
+func (q *priorityQueue) len() int {
+	n := 0
+	for _, pkts := range q {
+		n += len(pkts)
+	}
+	return n
+}
+
+func (q *priorityQueue) push(p Priority, pkt stack.PacketBufferPtr) {
+	i := p - PriorityBulk
+	q[i] = append(q[i], pkt)
+}
+
+// pop removes and returns the first packet of the highest priority, or
+// a nil one if q is empty.
+func (q *priorityQueue) pop() stack.PacketBufferPtr {
+	for i := len(q) - 1; i >= 0; i-- {
+		if len(q[i]) > 0 {
+			pkt := q[i][0]
+			q[i][0] = stack.PacketBufferPtr{}
+			q[i] = q[i][1:]
+			return pkt
+		}
+	}
+	return stack.PacketBufferPtr{}
+}
+
+// release drops the packets in q.
+func (q *priorityQueue) release() {
+	for pkt := q.pop(); !pkt.IsNil(); pkt = q.pop() {
+		pkt.DecRef()
+	}
+}
+
+// reorderByPriority queues pkt, and the other packets netstack has
+// waiting in linkEP, up to maxQueuedPackets, in q, and returns the one
+// to send first.
+func (ns *Impl) reorderByPriority(q *priorityQueue, pkt stack.PacketBufferPtr) stack.PacketBufferPtr {
+	q.push(ns.packetPriority(pkt), pkt)
+	for n := q.len(); n < maxQueuedPackets; n++ {
+		pkt = ns.linkEP.Read()
+		if pkt.IsNil() {
+			break
+		}
+		q.push(ns.packetPriority(pkt), pkt)
+	}
+	return q.pop()
+}
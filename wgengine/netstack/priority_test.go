@@ -0,0 +1,104 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package netstack
+
+import (
+	"net/netip"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"tailscale.com/types/ipproto"
+)
+
+func TestFlowPriority(t *testing.T) {
+	ns := &Impl{linkEP: channel.New(16, mtu, "")}
+	local := netip.MustParseAddrPort("100.64.0.1:5060")
+	bulk := netip.MustParseAddrPort("100.64.0.2:443")
+	other := netip.MustParseAddrPort("100.64.0.3:80")
+	voip := netip.MustParseAddrPort("100.64.0.4:5060")
+	ns.SetFlowPriority(ipproto.UDP, local, bulk, PriorityBulk)
+	ns.SetFlowPriority(ipproto.UDP, local, voip, PriorityRealtime)
+	ns.SetFlowPriority(ipproto.TCP, local, other, PriorityRealtime) // another protocol
+
+	// The first bulk packet was read; the others are waiting in linkEP.
+	var waiting stack.PacketBufferList
+	for _, dst := range []netip.AddrPort{bulk, other, voip} {
+		waiting.PushBack(udpPacketBuffer(local, dst))
+	}
+	ns.linkEP.WritePackets(waiting)
+	var q priorityQueue
+	var got []netip.AddrPort
+	for pkt := ns.reorderByPriority(&q, udpPacketBuffer(local, bulk)); !pkt.IsNil(); pkt = q.pop() {
+		k, ok := packetFlowKey(pkt)
+		if !ok || k.local != local || k.proto != ipproto.UDP {
+			t.Fatalf("packetFlowKey = %+v, %v", k, ok)
+		}
+		got = append(got, k.remote)
+		pkt.DecRef()
+	}
+	want := []netip.AddrPort{voip, other, bulk, bulk}
+	if len(got) != len(want) {
+		t.Fatalf("sent to %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("sent to %v; want %v", got, want)
+		}
+	}
+
+	for _, dst := range []netip.AddrPort{bulk, voip} {
+		ns.SetFlowPriority(ipproto.UDP, local, dst, PriorityDefault)
+	}
+	ns.SetFlowPriority(ipproto.TCP, local, other, PriorityDefault)
+	if n := len(ns.flowPriorities.Load()); n != 0 {
+		t.Errorf("%d flow priorities left", n)
+	}
+}
+
+func udpPacketBuffer(src, dst netip.AddrPort) stack.PacketBufferPtr {
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: header.IPv4MinimumSize + header.UDPMinimumSize,
+	})
+	pkt.TransportProtocolNumber = header.UDPProtocolNumber
+	header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize)).Encode(&header.UDPFields{
+		SrcPort: src.Port(),
+		DstPort: dst.Port(),
+		Length:  header.UDPMinimumSize,
+	})
+	pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+	header.IPv4(pkt.NetworkHeader().Push(header.IPv4MinimumSize)).Encode(&header.IPv4Fields{
+		TotalLength: header.IPv4MinimumSize + header.UDPMinimumSize,
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     tcpip.Address(src.Addr().AsSlice()),
+		DstAddr:     tcpip.Address(dst.Addr().AsSlice()),
+	})
+	return pkt
+}
+
+func TestPriorityQueueRelease(t *testing.T) {
+	local := netip.MustParseAddrPort("100.64.0.1:5060")
+	remote := netip.MustParseAddrPort("100.64.0.2:443")
+	var q priorityQueue
+	var pkts []stack.PacketBufferPtr
+	for _, p := range []Priority{PriorityBulk, PriorityDefault, PriorityRealtime} {
+		pkt := udpPacketBuffer(local, remote)
+		pkt.IncRef() // the test's reference
+		pkts = append(pkts, pkt)
+		q.push(p, pkt)
+	}
+	q.release()
+	if n := q.len(); n != 0 {
+		t.Errorf("%d packets left after release", n)
+	}
+	for i, pkt := range pkts {
+		if n := pkt.ReadRefs(); n != 1 {
+			t.Errorf("packet %d has %d refs after release; want 1", i, n)
+		}
+		pkt.DecRef()
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package powersave
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isOnBattery reports whether any battery in /sys/class/power_supply is
+// discharging, which it only does with no external power connected.
+func isOnBattery() bool {
+	return isOnBatteryDir("/sys/class/power_supply")
+}
+
+func isOnBatteryDir(dir string) bool {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	read := func(name, file string) string {
+		b, _ := os.ReadFile(filepath.Join(dir, name, file))
+		return strings.TrimSpace(string(b))
+	}
+	for _, de := range ents {
+		if read(de.Name(), "type") == "Battery" && read(de.Name(), "status") == "Discharging" {
+			return true
+		}
+	}
+	return false
+}
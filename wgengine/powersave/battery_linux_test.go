@@ -0,0 +1,33 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package powersave
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIsOnBatteryDir(t *testing.T) {
+	dir := t.TempDir()
+	supply := func(name, typ, status string) {
+		d := filepath.Join(dir, name)
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+		os.WriteFile(filepath.Join(d, "type"), []byte(typ+"\n"), 0644)
+		if status != "" {
+			os.WriteFile(filepath.Join(d, "status"), []byte(status+"\n"), 0644)
+		}
+	}
+	supply("AC", "Mains", "")
+	supply("BAT0", "Battery", "Full")
+	if isOnBatteryDir(dir) {
+		t.Error("on battery with a full battery")
+	}
+	os.WriteFile(filepath.Join(dir, "BAT0", "status"), []byte("Discharging\n"), 0644)
+	if !isOnBatteryDir(dir) {
+		t.Error("not on battery with a discharging battery")
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+//go:build !linux
+
+package powersave
+
+// isOnBattery reports whether the device runs on battery. Only Linux
+// is supported for now; elsewhere, the low-power mode must be forced.
+func isOnBattery() bool { return false }
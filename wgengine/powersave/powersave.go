@@ -0,0 +1,117 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+// Package powersave watches the power source, for the low-power mode in
+// which tailscaled probes peers, rediscovers its endpoints and uploads
+// logs less often, to save the battery of laptops and single-board
+// computers.
+//
+// The mode is on when forced by the PowerSave pref, or when the device
+// runs on battery. The pref is per engine (see
+// magicsock.Conn.SetPowerSaveForced); only the power source, tracked
+// here, is process-wide.
+package powersave
+
+import (
+	"sync"
+	"time"
+
+	"tailscale.com/envknob"
+	"tailscale.com/util/clientmetric"
+	"tailscale.com/util/set"
+)
+
+// pollInterval is how often the power source is checked.
+const pollInterval = time.Minute
+
+// noAuto disables the low-power mode on battery, unless forced.
+var noAuto = envknob.RegisterBool("TS_DEBUG_NO_BATTERY_POWER_SAVE")
+
+var metricOnBattery = clientmetric.NewGauge("powersave_on_battery")
+
+var (
+	mu        sync.Mutex
+	started   bool
+	onBattery bool
+	callbacks set.HandleSet[func(auto bool)]
+
+	// onBatteryFunc reports whether the device is running on battery.
+	// It's a var for tests.
+	onBatteryFunc = isOnBattery
+)
+
+// Auto reports whether the low-power mode is on when not forced: that
+// is, whether the device runs on battery, unless
+// TS_DEBUG_NO_BATTERY_POWER_SAVE is set. The first call starts watching
+// the power source.
+func Auto() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	startLocked()
+	return autoLocked()
+}
+
+// RegisterChangeCallback registers cb to be called with the new value of
+// Auto each time it changes, and starts watching the power source if
+// needed. It returns a func to unregister cb.
+func RegisterChangeCallback(cb func(auto bool)) (unregister func()) {
+	mu.Lock()
+	defer mu.Unlock()
+	startLocked()
+	h := callbacks.Add(cb)
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		delete(callbacks, h)
+	}
+}
+
+// startLocked starts watching the power source, if it isn't already
+// watched. mu must be held.
+func startLocked() {
+	if started {
+		return
+	}
+	started = true
+	setOnBatteryLocked(onBatteryFunc())
+	go poll()
+}
+
+func poll() {
+	for range time.Tick(pollInterval) {
+		checkPowerSource()
+	}
+}
+
+// checkPowerSource updates whether the device runs on battery, and
+// runs the callbacks if that changed Auto.
+func checkPowerSource() {
+	b := onBatteryFunc()
+	mu.Lock()
+	was := autoLocked()
+	setOnBatteryLocked(b)
+	auto := autoLocked()
+	var cbs []func(bool)
+	if auto != was {
+		for _, cb := range callbacks {
+			cbs = append(cbs, cb)
+		}
+	}
+	mu.Unlock()
+	for _, cb := range cbs {
+		cb(auto)
+	}
+}
+
+func setOnBatteryLocked(b bool) {
+	onBattery = b
+	if b {
+		metricOnBattery.Set(1)
+	} else {
+		metricOnBattery.Set(0)
+	}
+}
+
+func autoLocked() bool {
+	return onBattery && !noAuto()
+}
@@ -0,0 +1,38 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package powersave
+
+import (
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPowerSave(t *testing.T) {
+	var battery atomic.Bool
+	onBatteryFunc = battery.Load
+	defer func() { onBatteryFunc = isOnBattery }()
+
+	var got []bool
+	unregister := RegisterChangeCallback(func(auto bool) { got = append(got, auto) })
+	defer unregister()
+
+	if Auto() {
+		t.Fatal("auto on AC power")
+	}
+	checkPowerSource()
+	battery.Store(true)
+	checkPowerSource()
+	if !Auto() {
+		t.Fatal("not auto on battery")
+	}
+	battery.Store(false)
+	checkPowerSource()
+	if Auto() {
+		t.Fatal("auto after the power came back")
+	}
+	if want := []bool{true, false}; !reflect.DeepEqual(got, want) {
+		t.Errorf("callbacks got %v; want %v", got, want)
+	}
+}
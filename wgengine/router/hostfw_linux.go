@@ -0,0 +1,166 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"tailscale.com/envknob"
+	"tailscale.com/types/logger"
+	"tailscale.com/util/multierr"
+)
+
+// firewallCooperation is whether to configure a detected firewalld or ufw
+// to accept Tailscale traffic. It defaults to true.
+var firewallCooperation = envknob.RegisterOptBool("TS_FIREWALL_COOPERATION")
+
+// hostFirewall is a firewall manager running on the host, such as firewalld
+// or ufw, whose own rules survive its reloads where Tailscale's netfilter
+// chains might not.
+type hostFirewall interface {
+	// Name returns the name of the firewall manager, for logging.
+	Name() string
+
+	// ReplacesNetfilter reports whether the firewall's rules entirely
+	// replace Tailscale's own netfilter rules, in which case the
+	// router's netfilter mode is forced off.
+	ReplacesNetfilter() bool
+
+	// AddInterface configures the firewall to accept traffic to, from
+	// and through the tunname interface, masquerading the traffic
+	// forwarded from it if snat.
+	AddInterface(tunname string, snat bool) error
+
+	// RemoveInterface undoes AddInterface.
+	RemoveInterface(tunname string) error
+}
+
+// detectHostFirewall returns the firewall manager running on the host, or
+// nil if there is none or cooperating with it has been disabled.
+func detectHostFirewall(logf logger.Logf, cmd commandRunner) hostFirewall {
+	if v, ok := firewallCooperation().Get(); ok && !v {
+		return nil
+	}
+	if _, err := exec.LookPath("firewall-cmd"); err == nil {
+		if out, err := cmd.output("firewall-cmd", "--state"); err == nil && strings.TrimSpace(string(out)) == "running" {
+			return &firewalld{cmd: cmd}
+		}
+	}
+	if _, err := exec.LookPath("ufw"); err == nil {
+		out, err := cmd.output("ufw", "status")
+		if err != nil {
+			logf("checking ufw status: %v", err)
+		} else if bytes.HasPrefix(out, []byte("Status: active")) {
+			return &ufw{cmd: cmd}
+		}
+	}
+	return nil
+}
+
+// firewalld is a hostFirewall that puts the Tailscale interface in
+// firewalld's "trusted" zone. Its changes are made both to the runtime and
+// permanent configuration, so that they survive "firewall-cmd --reload",
+// which flushes Tailscale's netfilter chains.
+type firewalld struct {
+	cmd commandRunner
+
+	// masqZone is the zone on which masquerading was enabled by
+	// AddInterface, if any.
+	masqZone string
+}
+
+func (f *firewalld) Name() string            { return "firewalld" }
+func (f *firewalld) ReplacesNetfilter() bool { return true }
+
+// firewallCmd runs firewall-cmd with args against both the runtime and
+// permanent configuration.
+func (f *firewalld) firewallCmd(args ...string) error {
+	if err := f.cmd.run(append([]string{"firewall-cmd"}, args...)...); err != nil {
+		return err
+	}
+	return f.cmd.run(append([]string{"firewall-cmd", "--permanent"}, args...)...)
+}
+
+func (f *firewalld) AddInterface(tunname string, snat bool) error {
+	if err := f.firewallCmd("--zone=trusted", "--change-interface="+tunname); err != nil {
+		return err
+	}
+	if !snat {
+		return nil
+	}
+	out, err := f.cmd.output("firewall-cmd", "--get-default-zone")
+	if err != nil {
+		return err
+	}
+	zone := strings.TrimSpace(string(out))
+	if zone == "" {
+		return fmt.Errorf("firewalld has no default zone to masquerade %s traffic in", tunname)
+	}
+	// --query-masquerade exits non-zero if masquerading is disabled.
+	if f.cmd.run("firewall-cmd", "--zone="+zone, "--query-masquerade") == nil {
+		return nil
+	}
+	if err := f.firewallCmd("--zone="+zone, "--add-masquerade"); err != nil {
+		return err
+	}
+	f.masqZone = zone
+	return nil
+}
+
+func (f *firewalld) RemoveInterface(tunname string) error {
+	var errs []error
+	if err := f.firewallCmd("--zone=trusted", "--remove-interface="+tunname); err != nil {
+		errs = append(errs, err)
+	}
+	if f.masqZone != "" {
+		if err := f.firewallCmd("--zone="+f.masqZone, "--remove-masquerade"); err != nil {
+			errs = append(errs, err)
+		}
+		f.masqZone = ""
+	}
+	return multierr.New(errs...)
+}
+
+// ufw is a hostFirewall that adds ufw rules allowing the traffic of the
+// Tailscale interface, so that ufw's default policies don't drop it.
+// Tailscale's netfilter chains are still used, as ufw leaves them alone.
+type ufw struct {
+	cmd commandRunner
+}
+
+func (u *ufw) Name() string            { return "ufw" }
+func (u *ufw) ReplacesNetfilter() bool { return false }
+
+// ufwRules returns the ufw rules for the tunname interface.
+func ufwRules(tunname string) [][]string {
+	return [][]string{
+		{"allow", "in", "on", tunname},
+		{"route", "allow", "in", "on", tunname},
+		{"route", "allow", "out", "on", tunname},
+	}
+}
+
+func (u *ufw) AddInterface(tunname string, snat bool) error {
+	// Masquerading is left to Tailscale's netfilter rules.
+	for _, rule := range ufwRules(tunname) {
+		args := append(append([]string{"ufw"}, rule...), "comment", "tailscale")
+		if err := u.cmd.run(args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *ufw) RemoveInterface(tunname string) error {
+	var errs []error
+	for _, rule := range ufwRules(tunname) {
+		if err := u.cmd.run(append([]string{"ufw", "delete"}, rule...)...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return multierr.New(errs...)
+}
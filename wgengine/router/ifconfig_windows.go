@@ -12,6 +12,7 @@
 	"net"
 	"net/netip"
 	"sort"
+	"sync"
 	"time"
 
 	ole "github.com/go-ole/go-ole"
@@ -24,6 +25,7 @@
 	"tailscale.com/net/interfaces"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/net/tstun"
+	"tailscale.com/syncs"
 	"tailscale.com/util/multierr"
 	"tailscale.com/wgengine/winnet"
 )
@@ -238,7 +240,7 @@ func interfaceFromLUID(luid winipcfg.LUID, flags winipcfg.GAAFlags) (*winipcfg.I
 	return nil, fmt.Errorf("interfaceFromLUID: interface with LUID %v not found", luid)
 }
 
-var networkCategoryWarning = health.NewWarnable(health.WithMapDebugFlag("warn-network-category-unhealthy"))
+var networkCategoryWarning = health.NewWarnable(health.WithMapDebugFlag("warn-network-category-unhealthy"), health.WithCode("network-category"))
 
 func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 	const mtu = tstun.DefaultMTU
@@ -367,7 +369,7 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 		r := &winipcfg.RouteData{
 			Destination: route,
 			NextHop:     gateway,
-			Metric:      0,
+			Metric:      cfg.RouteMetric,
 		}
 		if r.Destination.Addr().Unmap() == gateway {
 			// no need to add a route for the interface's
@@ -420,38 +422,52 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 		return fmt.Errorf("getting interface: %w", err)
 	}
 
-	var errAcc error
-	err = syncRoutes(iface, deduplicatedRoutes, cfg.LocalAddrs)
-	if err != nil && errAcc == nil {
-		log.Printf("setroutes: %v", err)
-		errAcc = err
-	}
+	// The routes and the IPv4 and IPv6 interface settings are
+	// independent, and each can be slow to program, so do them at once.
+	var wg sync.WaitGroup
+	var routesErr, err4, err6 error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		routesErr = syncRoutes(iface, deduplicatedRoutes, cfg.LocalAddrs)
+		if routesErr != nil {
+			log.Printf("setroutes: %v", routesErr)
+		}
+	}()
 
 	if ipif4 != nil {
-		ipif4, err = iface.LUID.IPInterface(windows.AF_INET)
-		if err != nil {
-			return fmt.Errorf("getting AF_INET interface: %w", err)
-		}
-		if foundDefault4 {
-			ipif4.UseAutomaticMetric = false
-			ipif4.Metric = 0
-		}
-		if mtu > 0 {
-			ipif4.NLMTU = uint32(mtu)
-			tun.ForceMTU(int(ipif4.NLMTU))
-		}
-		err = ipif4.Set()
-		if err != nil && errAcc == nil {
-			errAcc = err
-		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipif4, err := iface.LUID.IPInterface(windows.AF_INET)
+			if err != nil {
+				err4 = fmt.Errorf("getting AF_INET interface: %w", err)
+				return
+			}
+			// With an exit node, or a configured route metric, don't let
+			// the interface metric add to the routes' metric.
+			if foundDefault4 || cfg.RouteMetric != 0 {
+				ipif4.UseAutomaticMetric = false
+				ipif4.Metric = 0
+			}
+			if mtu > 0 {
+				ipif4.NLMTU = uint32(mtu)
+				tun.ForceMTU(int(ipif4.NLMTU))
+			}
+			err4 = ipif4.Set()
+		}()
 	}
 
 	if ipif6 != nil {
-		ipif6, err = iface.LUID.IPInterface(windows.AF_INET6)
-		if err != nil {
-			return fmt.Errorf("getting AF_INET6 interface: %w", err)
-		} else {
-			if foundDefault6 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ipif6, err := iface.LUID.IPInterface(windows.AF_INET6)
+			if err != nil {
+				err6 = fmt.Errorf("getting AF_INET6 interface: %w", err)
+				return
+			}
+			if foundDefault6 || cfg.RouteMetric != 0 {
 				ipif6.UseAutomaticMetric = false
 				ipif6.Metric = 0
 			}
@@ -460,14 +476,17 @@ func configureInterface(cfg *Config, tun *tun.NativeTun) (retErr error) {
 			}
 			ipif6.DadTransmits = 0
 			ipif6.RouterDiscoveryBehavior = winipcfg.RouterDiscoveryDisabled
-			err = ipif6.Set()
-			if err != nil && errAcc == nil {
-				errAcc = err
-			}
-		}
+			err6 = ipif6.Set()
+		}()
 	}
+	wg.Wait()
 
-	return errAcc
+	for _, err := range []error{routesErr, err4, err6} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // unwrapIP returns the shortest version of ip.
@@ -769,8 +788,12 @@ func syncRoutes(ifc *winipcfg.IPAdapterAddresses, want []*winipcfg.RouteData, do
 
 	add, del := deltaRouteData(got, want)
 
-	var errs []error
-	for _, a := range del {
+	// Each route is a separate syscall that can take milliseconds, which
+	// adds up to minutes with the thousands of routes of a large subnet
+	// router fleet, so program them in parallel. Deletes go first, in
+	// case an added route replaces a deleted one.
+	t0 := time.Now()
+	errs := forEachParallel(del, func(a *winipcfg.RouteData) error {
 		err := ifc.LUID.DeleteRoute(a.Destination, a.NextHop)
 		if err != nil {
 			dstStr := a.Destination.String()
@@ -778,18 +801,58 @@ func syncRoutes(ifc *winipcfg.IPAdapterAddresses, want []*winipcfg.RouteData, do
 				// Issue 785. Ignore these routes
 				// failing to delete. Harmless.
 				// TODO(maisem): do we still need this?
-				continue
+				return nil
 			}
-			errs = append(errs, fmt.Errorf("deleting route %v: %w", dstStr, err))
+			return fmt.Errorf("deleting route %v: %w", dstStr, err)
 		}
-	}
-
-	for _, a := range add {
+		return nil
+	})
+	errs = append(errs, forEachParallel(add, func(a *winipcfg.RouteData) error {
 		err := ifc.LUID.AddRoute(a.Destination, a.NextHop, a.Metric)
 		if err != nil {
-			errs = append(errs, fmt.Errorf("adding route %v: %w", &a.Destination, err))
+			return fmt.Errorf("adding route %v: %w", &a.Destination, err)
 		}
+		return nil
+	})...)
+	if len(add)+len(del) > routeSyncLogThreshold {
+		log.Printf("syncRoutes: added %d, deleted %d routes in %v", len(add), len(del), time.Since(t0).Round(time.Millisecond))
 	}
 
 	return multierr.New(errs...)
 }
+
+// maxParallelRouteOps is the number of route syscalls that syncRoutes
+// makes at once.
+const maxParallelRouteOps = 16
+
+// routeSyncLogThreshold is the number of route changes above which
+// syncRoutes logs how long they took.
+const routeSyncLogThreshold = 100
+
+// forEachParallel calls fn for each of items, up to maxParallelRouteOps
+// at once, and returns the non-nil errors in the order of items.
+func forEachParallel[T any](items []T, fn func(T) error) []error {
+	if len(items) == 0 {
+		return nil
+	}
+	errs := make([]error, len(items))
+	sem := syncs.NewSemaphore(maxParallelRouteOps)
+	var wg sync.WaitGroup
+	for i, it := range items {
+		sem.Acquire()
+		wg.Add(1)
+		go func(i int, it T) {
+			defer wg.Done()
+			defer sem.Release()
+			errs[i] = fn(it)
+		}(i, it)
+	}
+	wg.Wait()
+	ret := errs[:0]
+	for _, err := range errs {
+		if err != nil {
+			ret = append(ret, err)
+		}
+	}
+	return ret
+}
@@ -9,7 +9,9 @@
 	"net/netip"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
 )
@@ -234,3 +236,44 @@ func TestDeltaRouteData(t *testing.T) {
 		t.Errorf("del:\n   got: %v\n  want: %v\n", formatRouteData(del), formatRouteData(wantDel))
 	}
 }
+
+func TestForEachParallel(t *testing.T) {
+	var items []int
+	for i := 0; i < 100; i++ {
+		items = append(items, i)
+	}
+	var (
+		mu            sync.Mutex
+		running, peak int
+	)
+	errs := forEachParallel(items, func(i int) error {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if i%10 == 3 {
+			return fmt.Errorf("item %d", i)
+		}
+		return nil
+	})
+	if peak > maxParallelRouteOps {
+		t.Errorf("ran %d at once; want at most %d", peak, maxParallelRouteOps)
+	}
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := "item 3,item 13,item 23,item 33,item 43,item 53,item 63,item 73,item 83,item 93"
+	if strings.Join(got, ",") != want {
+		t.Errorf("errors = %q; want %q", got, want)
+	}
+	if errs := forEachParallel(nil, func(int) error { return nil }); errs != nil {
+		t.Errorf("nil items: got %v", errs)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+)
+
+// pfAnchor is the pf anchor that the kill switch rules are loaded into.
+// macOS's default pf.conf evaluates all anchors under com.apple, so using
+// one of those doesn't require editing pf.conf.
+const pfAnchor = "com.apple/250.TailscaleKillSwitch"
+
+var pfTokenRx = regexp.MustCompile(`(?m)^Token : (\d+)`)
+
+// setKillSwitch loads or flushes the pf rules that block all outgoing
+// traffic that isn't over the Tailscale interface, to loopback or to
+// allowed.
+func (r *userspaceBSDRouter) setKillSwitch(on bool, allowed []netip.Prefix) error {
+	if !on {
+		if out, err := cmd("pfctl", "-a", pfAnchor, "-F", "all").CombinedOutput(); err != nil {
+			return fmt.Errorf("flushing pf anchor: %v: %s", err, out)
+		}
+		if r.pfToken != "" {
+			if out, err := cmd("pfctl", "-X", r.pfToken).CombinedOutput(); err != nil {
+				return fmt.Errorf("releasing pf: %v: %s", err, out)
+			}
+			r.pfToken = ""
+		}
+		return nil
+	}
+
+	load := cmd("pfctl", "-a", pfAnchor, "-f", "-")
+	load.Stdin = strings.NewReader(pfKillSwitchRules(r.tunname, allowed))
+	if out, err := load.CombinedOutput(); err != nil {
+		return fmt.Errorf("loading pf rules: %v: %s", err, out)
+	}
+	if r.pfToken == "" {
+		// Enable pf, taking a reference that's released when the kill
+		// switch is turned off. pf stays enabled if anything else
+		// holds a reference.
+		out, err := cmd("pfctl", "-E").CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("enabling pf: %v: %s", err, out)
+		}
+		if m := pfTokenRx.FindSubmatch(out); m != nil {
+			r.pfToken = string(m[1])
+		}
+	}
+	return nil
+}
+
+// pfKillSwitchRules returns the pf rules for the kill switch.
+//
+// tailscaled's own traffic to control, DERP and peers isn't marked in any
+// way pf can match, so traffic from root's TCP and UDP sockets is let
+// through.
+func pfKillSwitchRules(tunname string, allowed []netip.Prefix) string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "pass out quick on lo0 all\n")
+	fmt.Fprintf(&b, "pass out quick on %s all\n", tunname)
+	fmt.Fprintf(&b, "pass out quick inet proto udp to any port 67\n") // DHCP
+	fmt.Fprintf(&b, "pass out quick inet6 to { fe80::/10, ff02::/16 }\n")
+	for _, pfx := range allowed {
+		fmt.Fprintf(&b, "pass out quick %s to %s\n", inet(pfx), pfx.Masked())
+	}
+	fmt.Fprintf(&b, "pass out quick proto { tcp, udp } all user root\n")
+	fmt.Fprintf(&b, "block drop out quick all\n")
+	return b.String()
+}
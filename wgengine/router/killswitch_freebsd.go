@@ -0,0 +1,16 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package router
+
+import (
+	"errors"
+	"net/netip"
+)
+
+func (r *userspaceBSDRouter) setKillSwitch(on bool, allowed []netip.Prefix) error {
+	if on {
+		return errors.New("kill switch not supported on FreeBSD")
+	}
+	return nil
+}
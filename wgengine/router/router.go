@@ -67,6 +67,18 @@ type Config struct {
 	// routing rules apply.
 	LocalRoutes []netip.Prefix
 
+	// KillSwitch, if true, blocks all traffic that isn't over
+	// Tailscale, other than to LocalRoutes, even while Routes don't
+	// include a default route. It's set while an exit node is wanted,
+	// so traffic doesn't leak while the tunnel is down.
+	// It's implemented on Linux, Windows and macOS.
+	KillSwitch bool
+
+	// RouteMetric, if non-zero, is the metric of Routes, for choosing
+	// between them and other VPNs' routes to overlapping prefixes.
+	// It's implemented on Linux and Windows.
+	RouteMetric uint32
+
 	// Linux-only things below, ignored on other platforms.
 	SubnetRoutes     []netip.Prefix         // subnets being advertised to other Tailscale nodes
 	SNATSubnetRoutes bool                   // SNAT traffic to local subnets
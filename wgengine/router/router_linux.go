@@ -21,6 +21,7 @@
 	"github.com/tailscale/netlink"
 	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/netipx"
+	"golang.org/x/exp/slices"
 	"golang.org/x/sys/unix"
 	"golang.org/x/time/rate"
 	"tailscale.com/envknob"
@@ -90,6 +91,22 @@ type netfilterRunner interface {
 	DeleteChain(table, chain string) error
 }
 
+// errNetfilterRunner is a netfilterRunner that fails every command with
+// err. It's used when iptables isn't available but the router can run
+// without it, so that anything needing netfilter fails instead of
+// panicking.
+type errNetfilterRunner struct{ err error }
+
+func (r errNetfilterRunner) Insert(table, chain string, pos int, args ...string) error { return r.err }
+func (r errNetfilterRunner) Append(table, chain string, args ...string) error          { return r.err }
+func (r errNetfilterRunner) Exists(table, chain string, args ...string) (bool, error) {
+	return false, r.err
+}
+func (r errNetfilterRunner) Delete(table, chain string, args ...string) error { return r.err }
+func (r errNetfilterRunner) ClearChain(table, chain string) error             { return r.err }
+func (r errNetfilterRunner) NewChain(table, chain string) error               { return r.err }
+func (r errNetfilterRunner) DeleteChain(table, chain string) error            { return r.err }
+
 type linuxRouter struct {
 	closed           atomic.Bool
 	logf             func(fmt string, args ...any)
@@ -98,10 +115,28 @@ type linuxRouter struct {
 	unregLinkMon     func()
 	addrs            map[netip.Prefix]bool
 	routes           map[netip.Prefix]bool
+	routeMetric      uint32 // metric of routes, or 0 for the kernel's default
 	localRoutes      map[netip.Prefix]bool
 	snatSubnetRoutes bool
 	netfilterMode    preftype.NetfilterMode
 
+	// openWrtFirewall is whether an OpenWrt firewall zone covers the
+	// Tailscale interface, in which case the netfilter mode is forced
+	// off and the OpenWrt firewall is left to handle its traffic.
+	openWrtFirewall bool
+
+	// hostFirewall is the firewalld or ufw found running on the host, if
+	// any. hostFirewallUp is whether the Tailscale interface has been
+	// added to it, and hostFirewallSNAT whether with masquerading.
+	hostFirewall     hostFirewall
+	hostFirewallUp   bool
+	hostFirewallSNAT bool
+
+	// killSwitch is whether the kill switch netfilter rules are
+	// installed, and killSwitchAllowed the local routes they allow.
+	killSwitch        bool
+	killSwitchAllowed []netip.Prefix
+
 	// ruleRestorePending is whether a timer has been started to
 	// restore deleted ip rules.
 	ruleRestorePending atomic.Bool
@@ -116,6 +151,14 @@ type linuxRouter struct {
 	// ipPolicyPrefBase is the base priority at which ip rules are installed.
 	ipPolicyPrefBase int
 
+	// subnetRouteTable and exitRouteTable are the routing tables of
+	// subnet routes and of exit node routes (the default routes and the
+	// throw routes that exempt local routes from them). Both are
+	// tailscaleRouteTable unless set with TS_LINUX_SUBNET_ROUTE_TABLE or
+	// TS_LINUX_EXIT_ROUTE_TABLE.
+	subnetRouteTable routeTable
+	exitRouteTable   routeTable
+
 	ipt4 netfilterRunner
 	ipt6 netfilterRunner
 	cmd  commandRunner
@@ -127,9 +170,17 @@ func newUserspaceRouter(logf logger.Logf, tunDev tun.Device, linkMon *monitor.Mo
 		return nil, err
 	}
 
-	ipt4, err := iptables.NewWithProtocol(iptables.ProtocolIPv4)
+	var ipt4 netfilterRunner
+	ipt4, err = iptables.NewWithProtocol(iptables.ProtocolIPv4)
 	if err != nil {
-		return nil, err
+		// OpenWrt 22.03+ uses fw4 (nftables) and doesn't install
+		// iptables by default. Its firewall can handle the Tailscale
+		// interface itself, so run without netfilter rather than fail.
+		if distro.Get() != distro.OpenWrt {
+			return nil, err
+		}
+		logf("iptables unavailable on OpenWrt, running without netfilter: %v", err)
+		ipt4 = errNetfilterRunner{err}
 	}
 
 	v6err := checkIPv6(logf)
@@ -148,7 +199,10 @@ func newUserspaceRouter(logf logger.Logf, tunDev tun.Device, linkMon *monitor.Mo
 		// if unavailable. We want that to be a non-fatal error.
 		ipt6, err = iptables.NewWithProtocol(iptables.ProtocolIPv6)
 		if err != nil {
-			return nil, err
+			if distro.Get() != distro.OpenWrt {
+				return nil, err
+			}
+			ipt6 = errNetfilterRunner{err}
 		}
 	}
 
@@ -156,7 +210,16 @@ func newUserspaceRouter(logf logger.Logf, tunDev tun.Device, linkMon *monitor.Mo
 		ambientCapNetAdmin: useAmbientCaps(),
 	}
 
-	return newUserspaceRouterAdvanced(logf, tunname, linkMon, ipt4, ipt6, cmd, supportsV6, supportsV6NAT)
+	rtr, err := newUserspaceRouterAdvanced(logf, tunname, linkMon, ipt4, ipt6, cmd, supportsV6, supportsV6NAT)
+	if err != nil {
+		return nil, err
+	}
+	if r := rtr.(*linuxRouter); !r.openWrtFirewall {
+		if r.hostFirewall = detectHostFirewall(logf, cmd); r.hostFirewall != nil {
+			logf("%s detected, configuring it to accept %s traffic", r.hostFirewall.Name(), tunname)
+		}
+	}
+	return rtr, nil
 }
 
 func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, linkMon *monitor.Mon, netfilter4, netfilter6 netfilterRunner, cmd commandRunner, supportsV6, supportsV6NAT bool) (Router, error) {
@@ -176,6 +239,11 @@ func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, linkMon *monit
 		ipRuleFixLimiter: rate.NewLimiter(rate.Every(5*time.Second), 10),
 		ipPolicyPrefBase: 5200,
 	}
+	r.subnetRouteTable = routeTableFromEnv(logf, "TS_LINUX_SUBNET_ROUTE_TABLE", subnetRouteTableNum(), tailscaleRouteTable)
+	r.exitRouteTable = routeTableFromEnv(logf, "TS_LINUX_EXIT_ROUTE_TABLE", exitRouteTableNum(), r.subnetRouteTable)
+	if r.subnetRouteTable != tailscaleRouteTable || r.exitRouteTable != tailscaleRouteTable {
+		r.logf("routing subnet routes via table %v and exit node routes via table %v", r.subnetRouteTable.num, r.exitRouteTable.num)
+	}
 	if r.useIPCommand() {
 		r.ipRuleAvailable = (cmd.run("ip", "rule") == nil)
 	} else {
@@ -225,6 +293,12 @@ func newUserspaceRouterAdvanced(logf logger.Logf, tunname string, linkMon *monit
 		r.logf("mwan3 on openWRT detected, switching policy base priority to 1300")
 	}
 
+	if r.openWrtFirewall, err = checkOpenWrtFirewallZone(tunname); err != nil {
+		r.logf("error checking OpenWrt firewall zones: %v", err)
+	} else if r.openWrtFirewall {
+		r.logf("OpenWrt firewall zone covers %s, disabling netfilter management", tunname)
+	}
+
 	r.fixupWSLMTU()
 
 	return r, nil
@@ -319,6 +393,36 @@ func useAmbientCaps() bool {
 
 var forceIPCommand = envknob.RegisterBool("TS_DEBUG_USE_IP_COMMAND")
 
+var (
+	// subnetRouteTableNum, if non-zero, is the routing table to put
+	// subnet routes in, instead of tailscaleRouteTable.
+	subnetRouteTableNum = envknob.RegisterInt("TS_LINUX_SUBNET_ROUTE_TABLE")
+
+	// exitRouteTableNum, if non-zero, is the routing table to put exit
+	// node routes in. It defaults to the subnet route table.
+	exitRouteTableNum = envknob.RegisterInt("TS_LINUX_EXIT_ROUTE_TABLE")
+)
+
+// routeTableFromEnv returns the routing table num, set by the envknob
+// named knob, or def if num is zero or not a table that can be used.
+func routeTableFromEnv(logf logger.Logf, knob string, num int, def routeTable) routeTable {
+	switch {
+	case num == 0:
+		return def
+	case num == tailscaleRouteTable.num:
+		return tailscaleRouteTable
+	case num < 1 || num > 252:
+		// 0 and 253 and above are reserved for the kernel's own
+		// tables, and busybox's ip command only knows 8-bit ones.
+		logf("ignoring %s=%d; want a table from 1 to 252", knob, num)
+		return def
+	}
+	if rt, ok := routeTableByNumber[num]; ok {
+		return rt
+	}
+	return newRouteTable(strconv.Itoa(num), num)
+}
+
 // useIPCommand reports whether r should use the "ip" command (or its
 // fake commandRunner for tests) instead of netlink.
 func (r *linuxRouter) useIPCommand() bool {
@@ -402,6 +506,12 @@ func (r *linuxRouter) Close() error {
 	if err := r.setNetfilterMode(netfilterOff); err != nil {
 		return err
 	}
+	if err := r.setHostFirewall(false, false); err != nil {
+		return err
+	}
+	if err := r.setKillSwitch(false, nil); err != nil {
+		return err
+	}
 	if err := r.delRoutes(); err != nil {
 		return err
 	}
@@ -430,6 +540,17 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.localRoutes = newLocalRoutes
 
+	if cfg.RouteMetric != r.routeMetric {
+		// The kernel considers routes only differing by metric to be
+		// different routes, so remove all of them before re-adding them
+		// with the new metric.
+		oldRoutes, err := cidrDiff("route", r.routes, nil, r.addRoute, r.delRoute, r.logf)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		r.routes = oldRoutes
+		r.routeMetric = cfg.RouteMetric
+	}
 	newRoutes, err := cidrDiff("route", r.routes, cfg.Routes, r.addRoute, r.delRoute, r.logf)
 	if err != nil {
 		errs = append(errs, err)
@@ -456,15 +577,46 @@ func (r *linuxRouter) Set(cfg *Config) error {
 	}
 	r.snatSubnetRoutes = cfg.SNATSubnetRoutes
 
+	if err := r.setHostFirewall(cfg.NetfilterMode != netfilterOff, cfg.SNATSubnetRoutes); err != nil {
+		errs = append(errs, err)
+	}
+
+	if err := r.setKillSwitch(cfg.KillSwitch, cfg.LocalRoutes); err != nil {
+		errs = append(errs, err)
+	}
+
 	return multierr.New(errs...)
 }
 
+// setHostFirewall adds the Tailscale interface to the host firewall, if
+// any, masquerading its forwarded traffic if snat, or removes it if !up.
+func (r *linuxRouter) setHostFirewall(up, snat bool) error {
+	if r.hostFirewall == nil || (up == r.hostFirewallUp && (!up || snat == r.hostFirewallSNAT)) {
+		return nil
+	}
+	if r.hostFirewallUp {
+		if err := r.hostFirewall.RemoveInterface(r.tunname); err != nil {
+			return fmt.Errorf("removing %s from %s: %w", r.tunname, r.hostFirewall.Name(), err)
+		}
+		r.hostFirewallUp = false
+	}
+	if !up {
+		return nil
+	}
+	if err := r.hostFirewall.AddInterface(r.tunname, snat); err != nil {
+		return fmt.Errorf("adding %s to %s: %w", r.tunname, r.hostFirewall.Name(), err)
+	}
+	r.hostFirewallUp = true
+	r.hostFirewallSNAT = snat
+	return nil
+}
+
 // setNetfilterMode switches the router to the given netfilter
 // mode. Netfilter state is created or deleted appropriately to
 // reflect the new mode, and r.snatSubnetRoutes is updated to reflect
 // the current state of subnet SNATing.
 func (r *linuxRouter) setNetfilterMode(mode preftype.NetfilterMode) error {
-	if distro.Get() == distro.Synology {
+	if distro.Get() == distro.Synology || r.openWrtFirewall || (r.hostFirewall != nil && r.hostFirewall.ReplacesNetfilter()) {
 		mode = netfilterOff
 	}
 	if r.netfilterMode == mode {
@@ -683,7 +835,7 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.addRouteDef(r.routeDef(cidr), cidr, r.routeTableFor(cidr))
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -692,10 +844,21 @@ func (r *linuxRouter) addRoute(cidr netip.Prefix) error {
 	return netlink.RouteReplace(&netlink.Route{
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
-		Table:     r.routeTable(),
+		Table:     r.routeTable(cidr),
+		Priority:  int(r.routeMetric),
 	})
 }
 
+// routeDef returns the "ip route" arguments of the route for cidr,
+// pointing to the tunnel interface.
+func (r *linuxRouter) routeDef(cidr netip.Prefix) []string {
+	def := []string{normalizeCIDR(cidr), "dev", r.tunname}
+	if r.routeMetric != 0 {
+		def = append(def, "metric", strconv.FormatUint(uint64(r.routeMetric), 10))
+	}
+	return def
+}
+
 // addThrowRoute adds a throw route for the provided cidr.
 // This has the effect that lookup in the routing table is terminated
 // pretending that no route was found. Fails if the route already exists,
@@ -708,11 +871,11 @@ func (r *linuxRouter) addThrowRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.addRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr)
+		return r.addRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr, r.exitRouteTable)
 	}
 	err := netlink.RouteReplace(&netlink.Route{
 		Dst:   netipx.PrefixIPNet(cidr.Masked()),
-		Table: tailscaleRouteTable.num,
+		Table: r.exitRouteTable.num,
 		Type:  unix.RTN_THROW,
 	})
 	if err != nil {
@@ -721,13 +884,13 @@ func (r *linuxRouter) addThrowRoute(cidr netip.Prefix) error {
 	return err
 }
 
-func (r *linuxRouter) addRouteDef(routeDef []string, cidr netip.Prefix) error {
+func (r *linuxRouter) addRouteDef(routeDef []string, cidr netip.Prefix, table routeTable) error {
 	if !r.v6Available && cidr.Addr().Is6() {
 		return nil
 	}
 	args := append([]string{"ip", "route", "add"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	err := r.cmd.run(args...)
 	if err == nil {
@@ -760,7 +923,7 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{normalizeCIDR(cidr), "dev", r.tunname}, cidr)
+		return r.delRouteDef(r.routeDef(cidr), cidr, r.routeTableFor(cidr))
 	}
 	linkIndex, err := r.linkIndex()
 	if err != nil {
@@ -769,7 +932,8 @@ func (r *linuxRouter) delRoute(cidr netip.Prefix) error {
 	err = netlink.RouteDel(&netlink.Route{
 		LinkIndex: linkIndex,
 		Dst:       netipx.PrefixIPNet(cidr.Masked()),
-		Table:     r.routeTable(),
+		Table:     r.routeTable(cidr),
+		Priority:  int(r.routeMetric),
 	})
 	if errors.Is(err, errESRCH) {
 		// Didn't exist to begin with.
@@ -788,11 +952,11 @@ func (r *linuxRouter) delThrowRoute(cidr netip.Prefix) error {
 		return nil
 	}
 	if r.useIPCommand() {
-		return r.delRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr)
+		return r.delRouteDef([]string{"throw", normalizeCIDR(cidr)}, cidr, r.exitRouteTable)
 	}
 	err := netlink.RouteDel(&netlink.Route{
 		Dst:   netipx.PrefixIPNet(cidr.Masked()),
-		Table: r.routeTable(),
+		Table: r.exitRouteTable.num,
 		Type:  unix.RTN_THROW,
 	})
 	if errors.Is(err, errESRCH) {
@@ -802,17 +966,17 @@ func (r *linuxRouter) delThrowRoute(cidr netip.Prefix) error {
 	return err
 }
 
-func (r *linuxRouter) delRouteDef(routeDef []string, cidr netip.Prefix) error {
+func (r *linuxRouter) delRouteDef(routeDef []string, cidr netip.Prefix, table routeTable) error {
 	if !r.v6Available && cidr.Addr().Is6() {
 		return nil
 	}
 	args := append([]string{"ip", "route", "del"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	err := r.cmd.run(args...)
 	if err != nil {
-		ok, err := r.hasRoute(routeDef, cidr)
+		ok, err := r.hasRoute(routeDef, cidr, table)
 		if err != nil {
 			r.logf("warning: error checking whether %v even exists after error deleting it: %v", err)
 		} else {
@@ -832,10 +996,10 @@ func dashFam(ip netip.Addr) string {
 	return "-4"
 }
 
-func (r *linuxRouter) hasRoute(routeDef []string, cidr netip.Prefix) (bool, error) {
+func (r *linuxRouter) hasRoute(routeDef []string, cidr netip.Prefix, table routeTable) (bool, error) {
 	args := append([]string{"ip", dashFam(cidr.Addr()), "route", "show"}, routeDef...)
 	if r.ipRuleAvailable {
-		args = append(args, "table", tailscaleRouteTable.ipCmdArg())
+		args = append(args, "table", table.ipCmdArg())
 	}
 	out, err := r.cmd.output(args...)
 	if err != nil {
@@ -862,14 +1026,34 @@ func (r *linuxRouter) linkIndex() (int, error) {
 	return link.Attrs().Index, nil
 }
 
-// routeTable returns the route table to use.
-func (r *linuxRouter) routeTable() int {
+// routeTable returns the number of the route table to put the route for
+// cidr in, or 0 for the main table if policy routing isn't available.
+func (r *linuxRouter) routeTable(cidr netip.Prefix) int {
 	if r.ipRuleAvailable {
-		return tailscaleRouteTable.num
+		return r.routeTableFor(cidr).num
 	}
 	return 0
 }
 
+// routeTableFor returns the route table for the route for cidr, when
+// policy routing is available: the exit route table for default routes,
+// tailscaleRouteTable for routes to Tailscale IPs, and the subnet route
+// table for the rest.
+func (r *linuxRouter) routeTableFor(cidr netip.Prefix) routeTable {
+	if cidr.Bits() == 0 {
+		return r.exitRouteTable
+	}
+	if tsaddr.TailscaleViaRange().Contains(cidr.Addr()) {
+		return r.subnetRouteTable
+	}
+	for _, ts := range []netip.Prefix{tsaddr.CGNATRange(), tsaddr.TailscaleULARange()} {
+		if cidr.Bits() >= ts.Bits() && ts.Contains(cidr.Addr()) {
+			return tailscaleRouteTable
+		}
+	}
+	return r.subnetRouteTable
+}
+
 // upInterface brings up the tunnel interface.
 func (r *linuxRouter) upInterface() error {
 	if r.useIPCommand() {
@@ -1090,6 +1274,27 @@ func mustRouteTable(num int) routeTable {
 	// usual rules (pref 32766 and 32767, ie. main and default).
 }
 
+// ipRules returns the policy routing rules of r: the ipRules, then rules
+// that send packets through to the subnet and exit route tables, if they
+// aren't tailscaleRouteTable, so that the sysadmin can insert their own
+// rules for each kind of traffic in between.
+func (r *linuxRouter) ipRules() []netlink.Rule {
+	rules := ipRules
+	if r.subnetRouteTable.num != 0 && r.subnetRouteTable != tailscaleRouteTable {
+		rules = append(rules[:len(rules):len(rules)], netlink.Rule{
+			Priority: 72,
+			Table:    r.subnetRouteTable.num,
+		})
+	}
+	if r.exitRouteTable.num != 0 && r.exitRouteTable != tailscaleRouteTable && r.exitRouteTable != r.subnetRouteTable {
+		rules = append(rules[:len(rules):len(rules)], netlink.Rule{
+			Priority: 74,
+			Table:    r.exitRouteTable.num,
+		})
+	}
+	return rules
+}
+
 // justAddIPRules adds policy routing rule without deleting any first.
 func (r *linuxRouter) justAddIPRules() error {
 	if !r.ipRuleAvailable {
@@ -1101,7 +1306,7 @@ func (r *linuxRouter) justAddIPRules() error {
 	var errAcc error
 	for _, family := range r.addrFamilies() {
 
-		for _, ru := range ipRules {
+		for _, ru := range r.ipRules() {
 			// Note: r is a value type here; safe to mutate it.
 			ru.Family = family.netlinkInt()
 			if ru.Mark != 0 {
@@ -1130,7 +1335,7 @@ func (r *linuxRouter) addIPRulesWithIPCommand() error {
 	rg := newRunGroup(nil, r.cmd)
 
 	for _, family := range r.addrFamilies() {
-		for _, rule := range ipRules {
+		for _, rule := range r.ipRules() {
 			args := []string{
 				"ip", family.dashArg(),
 				"rule", "add",
@@ -1178,7 +1383,7 @@ func (r *linuxRouter) delIPRules() error {
 	}
 	var errAcc error
 	for _, family := range r.addrFamilies() {
-		for _, ru := range ipRules {
+		for _, ru := range r.ipRules() {
 			// Note: r is a value type here; safe to mutate it.
 			// When deleting rules, we want to be a bit specific (mention which
 			// table we were routing to) but not *too* specific (fwmarks, etc).
@@ -1221,7 +1426,7 @@ func (r *linuxRouter) delIPRulesWithIPCommand() error {
 		// That leaves us some flexibility to change these values in later
 		// versions without having ongoing hacks for every possible
 		// combination.
-		for _, rule := range ipRules {
+		for _, rule := range r.ipRules() {
 			args := []string{
 				"ip", family.dashArg(),
 				"rule", "del",
@@ -1555,6 +1760,104 @@ func (r *linuxRouter) delSNATRule() error {
 	return nil
 }
 
+// setKillSwitch installs or removes the netfilter rules that drop all
+// outgoing traffic that isn't over the Tailscale interface, to loopback,
+// from tailscaled itself, or to allowed. It manages its own ts-killswitch
+// chain, independently of the netfilter mode.
+func (r *linuxRouter) setKillSwitch(on bool, allowed []netip.Prefix) error {
+	if on == r.killSwitch && (!on || slices.Equal(allowed, r.killSwitchAllowed)) {
+		return nil
+	}
+	if r.killSwitch {
+		if err := r.delKillSwitch(); err != nil {
+			return err
+		}
+		r.killSwitch = false
+		r.killSwitchAllowed = nil
+	}
+	if !on {
+		return nil
+	}
+	if err := r.addKillSwitch(allowed); err != nil {
+		return err
+	}
+	r.killSwitch = true
+	r.killSwitchAllowed = append([]netip.Prefix(nil), allowed...)
+	return nil
+}
+
+func (r *linuxRouter) addKillSwitch(allowed []netip.Prefix) error {
+	for _, ipt := range r.netfilterFamilies() {
+		is6 := ipt == r.ipt6
+		err := ipt.ClearChain("filter", "ts-killswitch")
+		if errCode(err) == 1 {
+			err = ipt.NewChain("filter", "ts-killswitch")
+		}
+		if err != nil {
+			return fmt.Errorf("setting up filter/ts-killswitch: %w", err)
+		}
+		rules := [][]string{
+			{"-o", "lo", "-j", "ACCEPT"},
+			{"-o", r.tunname, "-j", "ACCEPT"},
+			// tailscaled's own traffic, to control, DERP and peers.
+			{"-m", "mark", "--mark", tailscaleBypassMark + "/" + tailscaleFwmarkMask, "-j", "ACCEPT"},
+		}
+		if is6 {
+			// Link-local traffic, including neighbor discovery and DHCPv6.
+			rules = append(rules,
+				[]string{"-d", "fe80::/10", "-j", "ACCEPT"},
+				[]string{"-d", "ff02::/16", "-j", "ACCEPT"},
+			)
+		} else {
+			rules = append(rules, []string{"-p", "udp", "--dport", "67", "-j", "ACCEPT"}) // DHCP
+		}
+		for _, pfx := range allowed {
+			if pfx.Addr().Is6() == is6 {
+				rules = append(rules, []string{"-d", normalizeCIDR(pfx), "-j", "ACCEPT"})
+			}
+		}
+		rules = append(rules, []string{"-j", "DROP"})
+		for _, args := range rules {
+			if err := ipt.Append("filter", "ts-killswitch", args...); err != nil {
+				return fmt.Errorf("adding %v in filter/ts-killswitch: %w", args, err)
+			}
+		}
+
+		args := []string{"-j", "ts-killswitch"}
+		exists, err := ipt.Exists("filter", "OUTPUT", args...)
+		if err != nil {
+			return fmt.Errorf("checking for %v in filter/OUTPUT: %w", args, err)
+		}
+		if !exists {
+			if err := ipt.Insert("filter", "OUTPUT", 1, args...); err != nil {
+				return fmt.Errorf("adding %v in filter/OUTPUT: %w", args, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *linuxRouter) delKillSwitch() error {
+	for _, ipt := range r.netfilterFamilies() {
+		args := []string{"-j", "ts-killswitch"}
+		if err := ipt.Delete("filter", "OUTPUT", args...); err != nil {
+			// As in delNetfilterHooks, assume it failed because
+			// there's no such rule.
+			r.logf("note: deleting %v in filter/OUTPUT: %v", args, err)
+		}
+		if err := ipt.ClearChain("filter", "ts-killswitch"); err != nil {
+			if errCode(err) == 1 {
+				continue
+			}
+			return fmt.Errorf("flushing filter/ts-killswitch: %w", err)
+		}
+		if err := ipt.DeleteChain("filter", "ts-killswitch"); err != nil {
+			return fmt.Errorf("deleting filter/ts-killswitch: %w", err)
+		}
+	}
+	return nil
+}
+
 // cidrDiff calls add and del as needed to make the set of prefixes in
 // old and new match. Returns a map reflecting the actual new state
 // (which may be somewhere in between old and new if some commands
@@ -1766,6 +2069,96 @@ func checkOpenWRTUsingMWAN3() (bool, error) {
 	return false, nil
 }
 
+// checkOpenWrtFirewallZone reports whether the running system is OpenWrt
+// with a firewall zone that covers the tunname interface, either directly
+// by device or via a network interface on it, as configured by
+// "tailscale configure openwrt".
+func checkOpenWrtFirewallZone(tunname string) (bool, error) {
+	if distro.Get() != distro.OpenWrt {
+		return false, nil
+	}
+	if _, err := exec.LookPath("uci"); err != nil {
+		return false, nil
+	}
+	firewall, err := exec.Command("uci", "-q", "show", "firewall").Output()
+	if err != nil {
+		return false, fmt.Errorf("uci show firewall: %w", err)
+	}
+	network, err := exec.Command("uci", "-q", "show", "network").Output()
+	if err != nil {
+		return false, fmt.Errorf("uci show network: %w", err)
+	}
+	return openWrtZoneCovers(parseUCIShow(firewall), parseUCIShow(network), tunname), nil
+}
+
+// openWrtZoneCovers reports whether any zone of the parsed uci firewall
+// config covers the tunname device, given the parsed uci network config.
+// A zone's device may end in "+" to match all devices with that prefix.
+func openWrtZoneCovers(firewall, network map[string][]string, tunname string) bool {
+	matchDev := func(devs []string) bool {
+		for _, dev := range devs {
+			if dev == tunname || (strings.HasSuffix(dev, "+") && strings.HasPrefix(tunname, strings.TrimSuffix(dev, "+"))) {
+				return true
+			}
+		}
+		return false
+	}
+	for sec, v := range firewall {
+		if len(v) != 1 || v[0] != "zone" || strings.Count(sec, ".") != 1 {
+			continue
+		}
+		if matchDev(firewall[sec+".device"]) {
+			return true
+		}
+		for _, n := range firewall[sec+".network"] {
+			if matchDev(network["network."+n+".device"]) || matchDev(network["network."+n+".ifname"]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseUCIShow parses the output of "uci show", with lines like:
+//
+//	firewall.tailscale=zone
+//	firewall.tailscale.network='tailscale' 'wg0'
+//
+// into a map from each key to its values. List values are split into
+// their elements, as are space-separated option values.
+func parseUCIShow(out []byte) map[string][]string {
+	ret := make(map[string][]string)
+	for _, line := range strings.Split(string(out), "\n") {
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		var vals []string
+		var cur strings.Builder
+		inVal := false // whether cur is a value, possibly an empty ''
+		for _, c := range v {
+			switch c {
+			case '\'':
+				inVal = true
+			case ' ':
+				if inVal {
+					vals = append(vals, cur.String())
+					cur.Reset()
+					inVal = false
+				}
+			default:
+				cur.WriteRune(c)
+				inVal = true
+			}
+		}
+		if inVal {
+			vals = append(vals, cur.String())
+		}
+		ret[k] = vals
+	}
+	return ret
+}
+
 func nlAddrOfPrefix(p netip.Prefix) *netlink.Addr {
 	return &netlink.Addr{
 		IPNet: netipx.PrefixIPNet(p),
@@ -318,6 +318,52 @@ func TestRouterStates(t *testing.T) {
 ip route add throw 10.0.0.0/8 table 52
 ip route add throw 192.168.0.0/24 table 52` + basic,
 		},
+		{
+			name: "kill switch with local routes and no netfilter",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32"),
+				LocalRoutes:   mustCIDRs("192.168.0.0/24", "fd00::/64"),
+				KillSwitch:    true,
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add throw 192.168.0.0/24 table 52
+ip route add throw fd00::/64 table 52` + basic +
+				`v4/filter/OUTPUT -j ts-killswitch
+v4/filter/ts-killswitch -o lo -j ACCEPT
+v4/filter/ts-killswitch -o tailscale0 -j ACCEPT
+v4/filter/ts-killswitch -m mark --mark 0x80000/0xff0000 -j ACCEPT
+v4/filter/ts-killswitch -p udp --dport 67 -j ACCEPT
+v4/filter/ts-killswitch -d 192.168.0.0/24 -j ACCEPT
+v4/filter/ts-killswitch -j DROP
+v6/filter/OUTPUT -j ts-killswitch
+v6/filter/ts-killswitch -o lo -j ACCEPT
+v6/filter/ts-killswitch -o tailscale0 -j ACCEPT
+v6/filter/ts-killswitch -m mark --mark 0x80000/0xff0000 -j ACCEPT
+v6/filter/ts-killswitch -d fe80::/10 -j ACCEPT
+v6/filter/ts-killswitch -d ff02::/16 -j ACCEPT
+v6/filter/ts-killswitch -d fd00::/64 -j ACCEPT
+v6/filter/ts-killswitch -j DROP
+`,
+		},
+		{
+			name: "route metric",
+			in: &Config{
+				LocalAddrs:    mustCIDRs("100.101.102.104/10"),
+				Routes:        mustCIDRs("100.100.100.100/32", "10.0.0.0/8"),
+				RouteMetric:   100,
+				NetfilterMode: netfilterOff,
+			},
+			want: `
+up
+ip addr add 100.101.102.104/10 dev tailscale0
+ip route add 10.0.0.0/8 dev tailscale0 metric 100 table 52
+ip route add 100.100.100.100/32 dev tailscale0 metric 100 table 52` + basic,
+		},
 	}
 
 	mon, err := monitor.New(logger.Discard)
@@ -362,6 +408,85 @@ func TestRouterStates(t *testing.T) {
 	}
 }
 
+func TestRouterSeparateRouteTables(t *testing.T) {
+	mon, err := monitor.New(logger.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mon.Start()
+	defer mon.Close()
+
+	fake := NewFakeOS(t)
+	router, err := newUserspaceRouterAdvanced(t.Logf, "tailscale0", mon, fake.netfilter4, fake.netfilter6, fake, true, true)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	r := router.(*linuxRouter)
+	r.subnetRouteTable = routeTableFromEnv(t.Logf, "TS_LINUX_SUBNET_ROUTE_TABLE", 100, tailscaleRouteTable)
+	r.exitRouteTable = routeTableFromEnv(t.Logf, "TS_LINUX_EXIT_ROUTE_TABLE", 101, r.subnetRouteTable)
+	if err := router.Up(); err != nil {
+		t.Fatalf("failed to up router: %v", err)
+	}
+	defer router.Close()
+
+	err = router.Set(&Config{
+		LocalAddrs:    mustCIDRs("100.101.102.103/10"),
+		Routes:        mustCIDRs("100.100.100.100/32", "192.168.16.0/24", "fd7a:115c:a1e0::/48", "fd7a:115c:a1e0:b1a::/96", "0.0.0.0/0", "::/0"),
+		LocalRoutes:   mustCIDRs("10.0.0.0/8"),
+		NetfilterMode: netfilterOff,
+	})
+	if err != nil {
+		t.Fatalf("failed to set router config: %v", err)
+	}
+	want := adjustFwmask(t, strings.TrimSpace(`
+up
+ip addr add 100.101.102.103/10 dev tailscale0
+ip route add 0.0.0.0/0 dev tailscale0 table 101
+ip route add 100.100.100.100/32 dev tailscale0 table 52
+ip route add 192.168.16.0/24 dev tailscale0 table 100
+ip route add ::/0 dev tailscale0 table 101
+ip route add fd7a:115c:a1e0::/48 dev tailscale0 table 52
+ip route add fd7a:115c:a1e0:b1a::/96 dev tailscale0 table 100
+ip route add throw 10.0.0.0/8 table 101
+ip rule add -4 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -4 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -4 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -4 pref 5270 table 52
+ip rule add -4 pref 5272 table 100
+ip rule add -4 pref 5274 table 101
+ip rule add -6 pref 5210 fwmark 0x80000/0xff0000 table main
+ip rule add -6 pref 5230 fwmark 0x80000/0xff0000 table default
+ip rule add -6 pref 5250 fwmark 0x80000/0xff0000 type unreachable
+ip rule add -6 pref 5270 table 52
+ip rule add -6 pref 5272 table 100
+ip rule add -6 pref 5274 table 101`))
+	if diff := cmp.Diff(fake.String(), want); diff != "" {
+		t.Fatalf("unexpected OS state (-got+want):\n%s", diff)
+	}
+}
+
+func TestRouteTableFromEnv(t *testing.T) {
+	def := tailscaleRouteTable
+	tests := []struct {
+		num  int
+		want int
+	}{
+		{0, 52},
+		{52, 52},
+		{-1, 52},
+		{253, 52},
+		{254, 52},
+		{1000, 52},
+		{1, 1},
+		{252, 252},
+	}
+	for _, tt := range tests {
+		if got := routeTableFromEnv(t.Logf, "TS_TEST_TABLE", tt.num, def); got.num != tt.want {
+			t.Errorf("routeTableFromEnv(%d) = %d; want %d", tt.num, got.num, tt.want)
+		}
+	}
+}
+
 type fakeNetfilter struct {
 	t *testing.T
 	n map[string][]string
@@ -843,6 +968,192 @@ func TestBusyboxParseVersion(t *testing.T) {
 	}
 }
 
+func TestParseUCIShow(t *testing.T) {
+	input := `network.lan=interface
+network.lan.device='br-lan'
+network.tailscale=interface
+network.tailscale.proto='none'
+network.tailscale.device='tailscale0'
+firewall.@zone[0]=zone
+firewall.@zone[0].name='lan'
+firewall.@zone[0].network='lan' 'wan6'
+firewall.@zone[0].masq=''
+`
+	got := parseUCIShow([]byte(input))
+	want := map[string][]string{
+		"network.lan":               {"interface"},
+		"network.lan.device":        {"br-lan"},
+		"network.tailscale":         {"interface"},
+		"network.tailscale.proto":   {"none"},
+		"network.tailscale.device":  {"tailscale0"},
+		"firewall.@zone[0]":         {"zone"},
+		"firewall.@zone[0].name":    {"lan"},
+		"firewall.@zone[0].network": {"lan", "wan6"},
+		"firewall.@zone[0].masq":    {""},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("parseUCIShow mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestOpenWrtZoneCovers(t *testing.T) {
+	network := parseUCIShow([]byte(`network.lan=interface
+network.lan.device='br-lan'
+network.ts=interface
+network.ts.device='tailscale0'
+network.legacy=interface
+network.legacy.ifname='tailscale0'
+`))
+	tests := []struct {
+		name     string
+		firewall string
+		want     bool
+	}{
+		{"no_zones", "", false},
+		{"lan_only", "firewall.@zone[0]=zone\nfirewall.@zone[0].network='lan'\n", false},
+		{"by_network", "firewall.@zone[0]=zone\nfirewall.@zone[0].network='lan' 'ts'\n", true},
+		{"by_ifname", "firewall.z=zone\nfirewall.z.network='legacy'\n", true},
+		{"by_device", "firewall.z=zone\nfirewall.z.device='tailscale0'\n", true},
+		{"by_device_wildcard", "firewall.z=zone\nfirewall.z.device='tailscale+'\n", true},
+		{"other_device", "firewall.z=zone\nfirewall.z.device='wg0'\n", false},
+		{"not_a_zone", "firewall.f=forwarding\nfirewall.f.device='tailscale0'\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := openWrtZoneCovers(parseUCIShow([]byte(tt.firewall)), network, "tailscale0")
+			if got != tt.want {
+				t.Errorf("got %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// recordingRunner is a commandRunner that records the commands it runs.
+// Commands in fail exit non-zero and output returns the matching value of
+// outputs.
+type recordingRunner struct {
+	cmds    []string
+	fail    map[string]bool
+	outputs map[string]string
+}
+
+func (r *recordingRunner) run(args ...string) error {
+	_, err := r.output(args...)
+	return err
+}
+
+func (r *recordingRunner) output(args ...string) ([]byte, error) {
+	cmd := strings.Join(args, " ")
+	r.cmds = append(r.cmds, cmd)
+	if r.fail[cmd] {
+		return nil, fmt.Errorf("exitcode:1")
+	}
+	return []byte(r.outputs[cmd]), nil
+}
+
+func TestHostFirewall(t *testing.T) {
+	tests := []struct {
+		name       string
+		fw         func(commandRunner) hostFirewall
+		fail       map[string]bool
+		wantUp     []string
+		wantSNAT   []string
+		wantRemove []string
+	}{
+		{
+			name: "firewalld",
+			fw:   func(cmd commandRunner) hostFirewall { return &firewalld{cmd: cmd} },
+			fail: map[string]bool{"firewall-cmd --zone=public --query-masquerade": true},
+			wantUp: []string{
+				"firewall-cmd --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --change-interface=tailscale0",
+			},
+			wantSNAT: []string{
+				"firewall-cmd --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --get-default-zone",
+				"firewall-cmd --zone=public --query-masquerade",
+				"firewall-cmd --zone=public --add-masquerade",
+				"firewall-cmd --permanent --zone=public --add-masquerade",
+			},
+			wantRemove: []string{
+				"firewall-cmd --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --zone=public --remove-masquerade",
+				"firewall-cmd --permanent --zone=public --remove-masquerade",
+			},
+		},
+		{
+			name: "firewalld_already_masquerading",
+			fw:   func(cmd commandRunner) hostFirewall { return &firewalld{cmd: cmd} },
+			wantUp: []string{
+				"firewall-cmd --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --change-interface=tailscale0",
+			},
+			wantSNAT: []string{
+				"firewall-cmd --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --change-interface=tailscale0",
+				"firewall-cmd --get-default-zone",
+				"firewall-cmd --zone=public --query-masquerade",
+			},
+			wantRemove: []string{
+				"firewall-cmd --zone=trusted --remove-interface=tailscale0",
+				"firewall-cmd --permanent --zone=trusted --remove-interface=tailscale0",
+			},
+		},
+		{
+			name: "ufw",
+			fw:   func(cmd commandRunner) hostFirewall { return &ufw{cmd: cmd} },
+			wantUp: []string{
+				"ufw allow in on tailscale0 comment tailscale",
+				"ufw route allow in on tailscale0 comment tailscale",
+				"ufw route allow out on tailscale0 comment tailscale",
+			},
+			wantSNAT: []string{
+				"ufw delete allow in on tailscale0",
+				"ufw delete route allow in on tailscale0",
+				"ufw delete route allow out on tailscale0",
+				"ufw allow in on tailscale0 comment tailscale",
+				"ufw route allow in on tailscale0 comment tailscale",
+				"ufw route allow out on tailscale0 comment tailscale",
+			},
+			wantRemove: []string{
+				"ufw delete allow in on tailscale0",
+				"ufw delete route allow in on tailscale0",
+				"ufw delete route allow out on tailscale0",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &recordingRunner{
+				fail:    tt.fail,
+				outputs: map[string]string{"firewall-cmd --get-default-zone": "public\n"},
+			}
+			r := &linuxRouter{tunname: "tailscale0", hostFirewall: tt.fw(cmd)}
+			step := func(name string, up, snat bool, want []string) {
+				t.Helper()
+				cmd.cmds = nil
+				if err := r.setHostFirewall(up, snat); err != nil {
+					t.Fatalf("%s: %v", name, err)
+				}
+				if !reflect.DeepEqual(cmd.cmds, want) {
+					t.Errorf("%s: ran\n%s\nwant\n%s", name, strings.Join(cmd.cmds, "\n"), strings.Join(want, "\n"))
+				}
+			}
+			step("up", true, false, tt.wantUp)
+			step("up again", true, false, nil)
+			step("snat", true, true, tt.wantSNAT)
+			step("remove", false, false, tt.wantRemove)
+			step("remove again", false, false, nil)
+		})
+	}
+}
+
 func TestCIDRDiff(t *testing.T) {
 	pfx := func(p ...string) []netip.Prefix {
 		var ret []netip.Prefix
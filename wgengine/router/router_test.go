@@ -21,8 +21,8 @@ func mustCIDRs(ss ...string) []netip.Prefix {
 
 func TestConfigEqual(t *testing.T) {
 	testedFields := []string{
-		"LocalAddrs", "Routes", "LocalRoutes", "SubnetRoutes",
-		"SNATSubnetRoutes", "NetfilterMode",
+		"LocalAddrs", "Routes", "LocalRoutes", "KillSwitch", "RouteMetric",
+		"SubnetRoutes", "SNATSubnetRoutes", "NetfilterMode",
 	}
 	configType := reflect.TypeOf(Config{})
 	configFields := []string{}
@@ -102,6 +102,28 @@ func TestConfigEqual(t *testing.T) {
 			true,
 		},
 
+		{
+			&Config{KillSwitch: false},
+			&Config{KillSwitch: true},
+			false,
+		},
+		{
+			&Config{KillSwitch: true},
+			&Config{KillSwitch: true},
+			true,
+		},
+
+		{
+			&Config{RouteMetric: 0},
+			&Config{RouteMetric: 100},
+			false,
+		},
+		{
+			&Config{RouteMetric: 100},
+			&Config{RouteMetric: 100},
+			true,
+		},
+
 		{
 			&Config{SubnetRoutes: nets("100.1.27.0/24")},
 			&Config{SubnetRoutes: nets("100.2.19.0/24")},
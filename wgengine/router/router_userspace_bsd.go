@@ -14,6 +14,7 @@
 
 	"github.com/tailscale/wireguard-go/tun"
 	"go4.org/netipx"
+	"golang.org/x/exp/slices"
 	"tailscale.com/net/tsaddr"
 	"tailscale.com/types/logger"
 	"tailscale.com/version"
@@ -26,6 +27,12 @@ type userspaceBSDRouter struct {
 	tunname string
 	local   []netip.Prefix
 	routes  map[netip.Prefix]bool
+
+	// killSwitch is whether the kill switch is on, and
+	// killSwitchAllowed the local routes it allows.
+	killSwitch        bool
+	killSwitchAllowed []netip.Prefix
+	pfToken           string // macOS: token from "pfctl -E", to release pf
 }
 
 func newUserspaceBSDRouter(logf logger.Logf, tundev tun.Device, linkMon *monitor.Mon) (Router, error) {
@@ -193,9 +200,25 @@ func (r *userspaceBSDRouter) Set(cfg *Config) (reterr error) {
 	}
 	r.routes = newRoutes
 
+	if cfg.KillSwitch != r.killSwitch || (cfg.KillSwitch && !slices.Equal(cfg.LocalRoutes, r.killSwitchAllowed)) {
+		if err := r.setKillSwitch(cfg.KillSwitch, cfg.LocalRoutes); err != nil {
+			r.logf("kill switch: %v", err)
+			setErr(err)
+		} else {
+			r.killSwitch = cfg.KillSwitch
+			r.killSwitchAllowed = append([]netip.Prefix(nil), cfg.LocalRoutes...)
+		}
+	}
+
 	return reterr
 }
 
 func (r *userspaceBSDRouter) Close() error {
+	if r.killSwitch {
+		if err := r.setKillSwitch(false, nil); err != nil {
+			return err
+		}
+		r.killSwitch = false
+	}
 	return nil
 }
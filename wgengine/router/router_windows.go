@@ -76,7 +76,7 @@ func (r *winRouter) Set(cfg *Config) error {
 	for _, la := range cfg.LocalAddrs {
 		localAddrs = append(localAddrs, la.String())
 	}
-	r.firewall.set(localAddrs, cfg.Routes, cfg.LocalRoutes)
+	r.firewall.set(localAddrs, cfg.Routes, cfg.LocalRoutes, cfg.KillSwitch)
 
 	err := configureInterface(cfg, r.nativeTun)
 	if err != nil {
@@ -149,13 +149,14 @@ type firewallTweaker struct {
 	fwProcEncoder *json.Encoder
 }
 
-func (ft *firewallTweaker) clear() { ft.set(nil, nil, nil) }
+func (ft *firewallTweaker) clear() { ft.set(nil, nil, nil, false) }
 
 // set takes CIDRs to allow, and the routes that point into the Tailscale tun interface.
-// Empty slices remove firewall rules.
+// Empty slices remove firewall rules. The killswitch is enabled if routes
+// include a default route, or regardless of routes if killSwitch is true.
 //
 // set takes ownership of cidrs, but not routes.
-func (ft *firewallTweaker) set(cidrs []string, routes, localRoutes []netip.Prefix) {
+func (ft *firewallTweaker) set(cidrs []string, routes, localRoutes []netip.Prefix, killSwitch bool) {
 	ft.mu.Lock()
 	defer ft.mu.Unlock()
 
@@ -166,7 +167,7 @@ func (ft *firewallTweaker) set(cidrs []string, routes, localRoutes []netip.Prefi
 	}
 	ft.wantLocal = cidrs
 	ft.localRoutes = localRoutes
-	ft.wantKillswitch = hasDefaultRoute(routes)
+	ft.wantKillswitch = killSwitch || hasDefaultRoute(routes)
 	if ft.running {
 		// The doAsyncSet goroutine will check ft.wantLocal/wantKillswitch
 		// before returning.
@@ -277,15 +278,17 @@ func (ft *firewallTweaker) doSet(local []string, killswitch bool, clear bool, pr
 			}
 		}
 	}
-	for _, cidr := range local {
-		ft.logf("adding Tailscale-In rule to allow %v ...", cidr)
-		var d time.Duration
-		d, err := ft.runFirewall("add", "rule", "name=Tailscale-In", "dir=in", "action=allow", "localip="+cidr, "profile=private", "enable=yes")
+	if len(local) > 0 {
+		// One rule can take a list of addresses, and each netsh run
+		// takes a while, so add them all at once.
+		localIPs := strings.Join(local, ",")
+		ft.logf("adding Tailscale-In rule to allow %v ...", localIPs)
+		d, err := ft.runFirewall("add", "rule", "name=Tailscale-In", "dir=in", "action=allow", "localip="+localIPs, "profile=private", "enable=yes")
 		if err != nil {
-			ft.logf("error adding Tailscale-In rule to allow %v: %v", cidr, err)
+			ft.logf("error adding Tailscale-In rule to allow %v: %v", localIPs, err)
 			return err
 		}
-		ft.logf("added Tailscale-In rule to allow %v in %v", cidr, d)
+		ft.logf("added Tailscale-In rule to allow %v in %v", localIPs, d)
 	}
 
 	if !killswitch {
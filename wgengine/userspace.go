@@ -50,6 +50,7 @@
 	"tailscale.com/wgengine/magicsock"
 	"tailscale.com/wgengine/monitor"
 	"tailscale.com/wgengine/netlog"
+	"tailscale.com/wgengine/powersave"
 	"tailscale.com/wgengine/router"
 	"tailscale.com/wgengine/wgcfg"
 	"tailscale.com/wgengine/wgint"
@@ -84,21 +85,22 @@
 const networkLoggerUploadTimeout = 5 * time.Second
 
 type userspaceEngine struct {
-	logf              logger.Logf
-	wgLogger          *wglog.Logger //a wireguard-go logging wrapper
-	reqCh             chan struct{}
-	waitCh            chan struct{} // chan is closed when first Close call completes; contrast with closing bool
-	timeNow           func() mono.Time
-	tundev            *tstun.Wrapper
-	wgdev             *device.Device
-	router            router.Router
-	confListenPort    uint16 // original conf.ListenPort
-	dns               *dns.Manager
-	magicConn         *magicsock.Conn
-	linkMon           *monitor.Mon
-	linkMonOwned      bool       // whether we created linkMon (and thus need to close it)
-	linkMonUnregister func()     // unsubscribes from changes; used regardless of linkMonOwned
-	birdClient        BIRDClient // or nil
+	logf                logger.Logf
+	wgLogger            *wglog.Logger //a wireguard-go logging wrapper
+	reqCh               chan struct{}
+	waitCh              chan struct{} // chan is closed when first Close call completes; contrast with closing bool
+	timeNow             func() mono.Time
+	tundev              *tstun.Wrapper
+	wgdev               *device.Device
+	router              router.Router
+	confListenPort      uint16 // original conf.ListenPort
+	dns                 *dns.Manager
+	magicConn           *magicsock.Conn
+	linkMon             *monitor.Mon
+	linkMonOwned        bool       // whether we created linkMon (and thus need to close it)
+	linkMonUnregister   func()     // unsubscribes from changes; used regardless of linkMonOwned
+	powerSaveUnregister func()     // unsubscribes from low-power mode changes
+	birdClient          BIRDClient // or nil
 
 	testMaybeReconfigHook func() // for tests; if non-nil, fires if maybeReconfigWireguardLocked called
 
@@ -369,6 +371,9 @@ func NewUserspaceEngine(logf logger.Logf, conf Config) (_ Engine, reterr error)
 	}
 	closePool.add(e.magicConn)
 	e.magicConn.SetNetworkUp(e.linkMon.InterfaceState().AnyInterfaceUp())
+	e.powerSaveUnregister = powersave.RegisterChangeCallback(e.magicConn.SetPowerSaveAuto)
+	closePool.addFunc(e.powerSaveUnregister)
+	e.magicConn.SetPowerSaveAuto(powersave.Auto())
 
 	tsTUNDev.SetDiscoKey(e.magicConn.DiscoPublicKey())
 
@@ -535,11 +540,15 @@ func forceFullWireguardConfig(numPeers int) bool {
 // For implementation simplicity, we can only trim peers that have
 // only non-subnet AllowedIPs (an IPv4 /32 or IPv6 /128), which is the
 // common case for most peers. Subnet router nodes will just always be
-// created in the wireguard-go config.
+// created in the wireguard-go config. Peers with persistent keepalives
+// aren't trimmed either, as trimming them would stop the keepalives.
 func isTrimmablePeer(p *wgcfg.Peer, numPeers int) bool {
 	if forceFullWireguardConfig(numPeers) {
 		return false
 	}
+	if p.PersistentKeepalive != 0 {
+		return false
+	}
 
 	// AllowedIPs must all be single IPs, not subnets.
 	for _, aip := range p.AllowedIPs {
@@ -1094,6 +1103,7 @@ func (e *userspaceEngine) Close() {
 	e.wgdev.IpcSetOperation(r)
 	e.magicConn.Close()
 	e.linkMonUnregister()
+	e.powerSaveUnregister()
 	if e.linkMonOwned {
 		e.linkMon.Close()
 	}
@@ -50,8 +50,59 @@ func cidrIsSubnet(node *tailcfg.Node, cidr netip.Prefix) bool {
 	return true
 }
 
+// routeRejected reports whether the subnet route cidr is within any of
+// rejectRoutes.
+func routeRejected(cidr netip.Prefix, rejectRoutes []netip.Prefix) bool {
+	for _, r := range rejectRoutes {
+		if r.Bits() <= cidr.Bits() && r.Contains(cidr.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepAliveMatches reports whether peer is matched by any of the
+// selectors in keepAlive, as described by ipn.Prefs.KeepAlivePeers.
+func keepAliveMatches(peer *tailcfg.Node, keepAlive []string) bool {
+	for _, sel := range keepAlive {
+		if strings.HasPrefix(sel, "tag:") {
+			if slices.Contains(peer.Tags, sel) {
+				return true
+			}
+			continue
+		}
+		if ip, err := netip.ParseAddr(sel); err == nil {
+			for _, a := range peer.Addresses {
+				if a.IsSingleIP() && a.Addr() == ip {
+					return true
+				}
+			}
+			continue
+		}
+		sel = strings.TrimSuffix(sel, ".")
+		name := strings.TrimSuffix(peer.Name, ".")
+		host, _, _ := strings.Cut(name, ".")
+		if strings.EqualFold(sel, name) || strings.EqualFold(sel, host) || strings.EqualFold(sel, peer.ComputedName) {
+			return true
+		}
+	}
+	return false
+}
+
+// inMaintenance reports whether peer advertises that it's in
+// maintenance, as with ipn.Prefs.Maintenance.
+func inMaintenance(peer *tailcfg.Node) bool {
+	return peer.Hostinfo.Valid() && peer.Hostinfo.Maintenance()
+}
+
 // WGCfg returns the NetworkMaps's WireGuard configuration.
-func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags, exitNode tailcfg.StableNodeID) (*wgcfg.Config, error) {
+//
+// Subnet routes within rejectRoutes are not accepted, even with
+// netmap.AllowSubnetRoutes in flags, and neither are the subnet routes of
+// peers in maintenance that another peer also routes. Peers matched by
+// keepAlive, as described by ipn.Prefs.KeepAlivePeers, get persistent
+// keepalives even if the coordination server didn't ask for them.
+func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags, exitNode tailcfg.StableNodeID, rejectRoutes []netip.Prefix, keepAlive []string) (*wgcfg.Config, error) {
 	cfg := &wgcfg.Config{
 		Name:       "tailscale",
 		PrivateKey: nm.PrivateKey,
@@ -83,6 +134,22 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 	skippedUnselected := new(bytes.Buffer)
 	skippedIPs := new(bytes.Buffer)
 	skippedSubnets := new(bytes.Buffer)
+	skippedRejected := new(bytes.Buffer)
+	skippedMaintenance := new(bytes.Buffer)
+
+	// The subnet routes of peers not in maintenance, to route through
+	// them instead of peers in maintenance.
+	activeRoutes := map[netip.Prefix]bool{}
+	for _, peer := range nm.Peers {
+		if inMaintenance(peer) {
+			continue
+		}
+		for _, allowedIP := range peer.AllowedIPs {
+			if cidrIsSubnet(peer, allowedIP) && !routeRejected(allowedIP, rejectRoutes) {
+				activeRoutes[allowedIP] = true
+			}
+		}
+	}
 
 	for _, peer := range nm.Peers {
 		if peer.DiscoKey.IsZero() && peer.DERP == "" {
@@ -96,7 +163,7 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 			DiscoKey:  peer.DiscoKey,
 		})
 		cpeer := &cfg.Peers[len(cfg.Peers)-1]
-		if peer.KeepAlive {
+		if peer.KeepAlive || keepAliveMatches(peer, keepAlive) {
 			cpeer.PersistentKeepalive = 25 // seconds
 		}
 
@@ -127,6 +194,20 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 					fmt.Fprintf(skippedSubnets, "%v from %q (%v)", allowedIP, nodeDebugName(peer), peer.Key.ShortString())
 					continue
 				}
+				if routeRejected(allowedIP, rejectRoutes) {
+					if skippedRejected.Len() > 0 {
+						skippedRejected.WriteString(", ")
+					}
+					fmt.Fprintf(skippedRejected, "%v from %q (%v)", allowedIP, nodeDebugName(peer), peer.Key.ShortString())
+					continue
+				}
+				if activeRoutes[allowedIP] && inMaintenance(peer) {
+					if skippedMaintenance.Len() > 0 {
+						skippedMaintenance.WriteString(", ")
+					}
+					fmt.Fprintf(skippedMaintenance, "%v from %q (%v)", allowedIP, nodeDebugName(peer), peer.Key.ShortString())
+					continue
+				}
 			}
 			cpeer.AllowedIPs = append(cpeer.AllowedIPs, allowedIP)
 		}
@@ -141,6 +222,12 @@ func WGCfg(nm *netmap.NetworkMap, logf logger.Logf, flags netmap.WGConfigFlags,
 	if skippedSubnets.Len() > 0 {
 		logf("[v1] wgcfg: did not accept subnet routes: %s", skippedSubnets)
 	}
+	if skippedRejected.Len() > 0 {
+		logf("[v1] wgcfg: rejected subnet routes: %s", skippedRejected)
+	}
+	if skippedMaintenance.Len() > 0 {
+		logf("[v1] wgcfg: routed around peers in maintenance: %s", skippedMaintenance)
+	}
 
 	return cfg, nil
 }
@@ -0,0 +1,187 @@
+// Copyright (c) Tailscale Inc & AUTHORS
+// SPDX-License-Identifier: BSD-3-Clause
+
+package nmcfg
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"tailscale.com/tailcfg"
+	"tailscale.com/types/key"
+	"tailscale.com/types/netmap"
+)
+
+func TestWGCfgRejectRoutes(t *testing.T) {
+	pfxs := func(ss ...string) (ret []netip.Prefix) {
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				Name:       "router1.example.ts.net.",
+				Key:        key.NewNode().Public(),
+				DiscoKey:   key.NewDisco().Public(),
+				Addresses:  pfxs("100.64.0.1/32"),
+				AllowedIPs: pfxs("100.64.0.1/32", "10.0.0.0/8", "10.1.0.0/16", "192.168.1.0/24"),
+			},
+			{
+				Name:       "router2.example.ts.net.",
+				Key:        key.NewNode().Public(),
+				DiscoKey:   key.NewDisco().Public(),
+				Addresses:  pfxs("100.64.0.2/32"),
+				AllowedIPs: pfxs("100.64.0.2/32", "172.16.0.0/12", "10.2.3.4/32"),
+			},
+		},
+	}
+	tests := []struct {
+		name   string
+		reject []netip.Prefix
+		want   [][]netip.Prefix
+	}{
+		{
+			name: "none",
+			want: [][]netip.Prefix{
+				pfxs("100.64.0.1/32", "10.0.0.0/8", "10.1.0.0/16", "192.168.1.0/24"),
+				pfxs("100.64.0.2/32", "172.16.0.0/12", "10.2.3.4/32"),
+			},
+		},
+		{
+			name:   "within",
+			reject: pfxs("10.0.0.0/8"),
+			want: [][]netip.Prefix{
+				pfxs("100.64.0.1/32", "192.168.1.0/24"),
+				pfxs("100.64.0.2/32", "172.16.0.0/12"),
+			},
+		},
+		{
+			name:   "narrower_keeps_wider",
+			reject: pfxs("10.1.0.0/16"),
+			want: [][]netip.Prefix{
+				pfxs("100.64.0.1/32", "10.0.0.0/8", "192.168.1.0/24"),
+				pfxs("100.64.0.2/32", "172.16.0.0/12", "10.2.3.4/32"),
+			},
+		},
+		{
+			name:   "not_self_addresses",
+			reject: pfxs("100.64.0.0/10"),
+			want: [][]netip.Prefix{
+				pfxs("100.64.0.1/32", "10.0.0.0/8", "10.1.0.0/16", "192.168.1.0/24"),
+				pfxs("100.64.0.2/32", "172.16.0.0/12", "10.2.3.4/32"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := WGCfg(nm, t.Logf, netmap.AllowSingleHosts|netmap.AllowSubnetRoutes, "", tt.reject, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got [][]netip.Prefix
+			for _, p := range cfg.Peers {
+				got = append(got, p.AllowedIPs)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AllowedIPs = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWGCfgKeepAlive(t *testing.T) {
+	node := func(name string, ip string, tags ...string) *tailcfg.Node {
+		pfx := netip.MustParsePrefix(ip + "/32")
+		return &tailcfg.Node{
+			Name:       name,
+			Key:        key.NewNode().Public(),
+			DiscoKey:   key.NewDisco().Public(),
+			Addresses:  []netip.Prefix{pfx},
+			AllowedIPs: []netip.Prefix{pfx},
+			Tags:       tags,
+		}
+	}
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			node("office.example.ts.net.", "100.64.0.1"),
+			node("router.example.ts.net.", "100.64.0.2", "tag:router"),
+			node("laptop.example.ts.net.", "100.64.0.3"),
+		},
+	}
+	nm.Peers[2].KeepAlive = true // asked for by control
+
+	tests := []struct {
+		name      string
+		keepAlive []string
+		want      []uint16
+	}{
+		{"none", nil, []uint16{0, 0, 25}},
+		{"hostname", []string{"Office"}, []uint16{25, 0, 25}},
+		{"fqdn", []string{"office.example.ts.net."}, []uint16{25, 0, 25}},
+		{"ip", []string{"100.64.0.2"}, []uint16{0, 25, 25}},
+		{"tag", []string{"tag:router"}, []uint16{0, 25, 25}},
+		{"no_match", []string{"tag:nope", "100.64.0.9", "other"}, []uint16{0, 0, 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := WGCfg(nm, t.Logf, netmap.AllowSingleHosts, "", nil, tt.keepAlive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []uint16
+			for _, p := range cfg.Peers {
+				got = append(got, p.PersistentKeepalive)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("PersistentKeepalive = %v; want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWGCfgMaintenance(t *testing.T) {
+	pfxs := func(ss ...string) (ret []netip.Prefix) {
+		for _, s := range ss {
+			ret = append(ret, netip.MustParsePrefix(s))
+		}
+		return ret
+	}
+	nm := &netmap.NetworkMap{
+		Peers: []*tailcfg.Node{
+			{
+				Name:       "router1.example.ts.net.",
+				Key:        key.NewNode().Public(),
+				DiscoKey:   key.NewDisco().Public(),
+				Addresses:  pfxs("100.64.0.1/32"),
+				AllowedIPs: pfxs("100.64.0.1/32", "10.0.0.0/24", "10.9.0.0/24"),
+				Hostinfo:   (&tailcfg.Hostinfo{Maintenance: true}).View(),
+			},
+			{
+				Name:       "router2.example.ts.net.",
+				Key:        key.NewNode().Public(),
+				DiscoKey:   key.NewDisco().Public(),
+				Addresses:  pfxs("100.64.0.2/32"),
+				AllowedIPs: pfxs("100.64.0.2/32", "10.0.0.0/24"),
+			},
+		},
+	}
+	cfg, err := WGCfg(nm, t.Logf, netmap.AllowSingleHosts|netmap.AllowSubnetRoutes, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][]netip.Prefix
+	for _, p := range cfg.Peers {
+		got = append(got, p.AllowedIPs)
+	}
+	// router1 keeps the route only it has.
+	want := [][]netip.Prefix{
+		pfxs("100.64.0.1/32", "10.9.0.0/24"),
+		pfxs("100.64.0.2/32", "10.0.0.0/24"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AllowedIPs = %v; want %v", got, want)
+	}
+}